@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
@@ -14,10 +15,39 @@ import (
 
 	"github.com/not-nullexception/image-optimizer/config"
 	"github.com/not-nullexception/image-optimizer/internal/api/router"
+	"github.com/not-nullexception/image-optimizer/internal/backpressure"
+	"github.com/not-nullexception/image-optimizer/internal/cdn"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/cache"
+	"github.com/not-nullexception/image-optimizer/internal/db/memory"
 	"github.com/not-nullexception/image-optimizer/internal/db/postgres"
+	"github.com/not-nullexception/image-optimizer/internal/deletion"
+	"github.com/not-nullexception/image-optimizer/internal/errreport"
+	"github.com/not-nullexception/image-optimizer/internal/events"
+	"github.com/not-nullexception/image-optimizer/internal/faults"
+	"github.com/not-nullexception/image-optimizer/internal/gc"
+	"github.com/not-nullexception/image-optimizer/internal/integrity"
 	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	miniomemory "github.com/not-nullexception/image-optimizer/internal/minio/memory"
 	"github.com/not-nullexception/image-optimizer/internal/minio/minio"
+	minioresilient "github.com/not-nullexception/image-optimizer/internal/minio/resilient"
+	"github.com/not-nullexception/image-optimizer/internal/outbox"
+	"github.com/not-nullexception/image-optimizer/internal/profiling"
+	queuememory "github.com/not-nullexception/image-optimizer/internal/queue/memory"
 	"github.com/not-nullexception/image-optimizer/internal/queue/rabbitmq"
+	queueresilient "github.com/not-nullexception/image-optimizer/internal/queue/resilient"
+	"github.com/not-nullexception/image-optimizer/internal/reload"
+	"github.com/not-nullexception/image-optimizer/internal/retry"
+	"github.com/not-nullexception/image-optimizer/internal/tracing"
+	"github.com/not-nullexception/image-optimizer/internal/usage"
+	"github.com/not-nullexception/image-optimizer/internal/worker"
+)
+
+// Modes of the API process
+const (
+	modeServer    = "server"
+	modeEphemeral = "ephemeral"
 )
 
 func main() {
@@ -25,49 +55,212 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration file; environment variables override it")
+	checkConfig := flag.Bool("check-config", false, "validate the configuration and exit")
+	mode := flag.String("mode", modeServer, "server, or ephemeral to run with in-memory storage, database and queue and an in-process worker")
+	flag.Parse()
+	if *mode != modeServer && *mode != modeEphemeral {
+		fmt.Fprintf(os.Stderr, "invalid mode %q: must be %s or %s\n", *mode, modeServer, modeEphemeral)
+		os.Exit(2)
+	}
+	ephemeral := *mode == modeEphemeral
+
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.LoadFile(*configFile)
+	if *checkConfig {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration is valid")
+		return
+	}
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to load configuration")
 	}
 
 	// Setup logger
-	logger.Setup(&cfg.Log)
+	logShutdown := logger.Setup(&cfg.Log)
+	defer logShutdown() // flush logs still pending for Loki
+
+	if cfg.Tracing.Enabled {
+		traceCfg := tracing.TracingConfig{
+			ServiceName:    cfg.Tracing.ServiceName,
+			ServiceVersion: cfg.Tracing.ServiceVersion,
+			Environment:    cfg.Tracing.Environment,
+			OTLPEndpoint:   cfg.Tracing.OTLPEndpoint,
+			Enabled:        cfg.Tracing.Enabled,
+			Insecure:       cfg.Tracing.OTLPInsecure,
+			Sampling: tracing.SamplingConfig{
+				Sampler:     cfg.Tracing.Sampler,
+				Ratio:       cfg.Tracing.SampleRatio,
+				RouteRatios: cfg.Tracing.RouteRatios,
+			},
+		}
+		tracerShutdown, err := tracing.Init(ctx, traceCfg)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to initialize tracing")
+		}
+		defer tracerShutdown() // shutdown tracer on exit
+	}
+
+	// Push metrics over OTLP if enabled
+	metricsShutdown, err := tracing.InitMetrics(ctx, tracing.MetricsConfig{
+		ServiceName:    cfg.Tracing.ServiceName,
+		ServiceVersion: cfg.Tracing.ServiceVersion,
+		Environment:    cfg.Tracing.Environment,
+		OTLPEndpoint:   cfg.Observability.OTLPMetricsEndpoint,
+		Interval:       cfg.Observability.OTLPMetricsInterval,
+		Enabled:        cfg.Observability.OTLPMetrics,
+		Insecure:       cfg.Observability.OTLPMetricsInsecure,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize OTLP metrics export")
+	}
+	defer metricsShutdown() // flush pending metrics on exit
+
+	// Start the profiler if enabled
+	profilerAddr := fmt.Sprintf("%s:%d", cfg.Observability.ProfilerHost, cfg.Observability.ProfilerPort)
+	profilerShutdown, err := profiling.Start(&cfg.Observability, cfg.Tracing.ServiceName, profilerAddr)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to start profiler")
+	}
+	defer profilerShutdown()
 
 	// Log the configuration for debugging (make sure to not log sensitive data in production)
 	// log.Info().Interface("config", cfg).Msg("Configuration loaded")
 
-	// Create database repository
-	repo, err := postgres.NewRepository(ctx, &cfg.Database)
-	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to create database repository")
+	// Create database repository, and pick the storage and queue clients. Ephemeral runs
+	// keep everything in memory and lose it on exit.
+	newStorage, newQueue := minio.NewClient, rabbitmq.NewClient
+	var repo db.Repository
+	if ephemeral {
+		log.Warn().Msg("Running in ephemeral mode: images, objects and tasks are kept in memory only")
+		newStorage, newQueue = miniomemory.NewClient, queuememory.NewClient
+		repo = memory.NewRepository()
+	} else {
+		repo, err = postgres.NewRepository(ctx, &cfg.Database)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create database repository")
+		}
 	}
 	defer repo.Close()
 
+	// Inject faults into the dependencies in builds with the faults tag. The wrappers sit
+	// below the cache and circuit breakers so that those are exercised too.
+	injector := faults.New(&cfg.Faults)
+	repo = faults.WrapRepository(repo, injector)
+
+	// Wrap the repository with an in-memory cache if enabled
+	if cfg.Cache.Enabled {
+		repo = cache.NewRepository(repo, &cfg.Cache)
+	}
+
 	// Create MinIO client
-	minioClient, err := minio.NewClient(&cfg.MinIO)
+	minioClient, err := newStorage(&cfg.MinIO)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to create MinIO client")
 	}
 	defer minioClient.Close()
 
 	// Create RabbitMQ client
-	queueClient, err := rabbitmq.NewClient(&cfg.RabbitMQ)
+	queueClient, err := newQueue(&cfg.RabbitMQ)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to create RabbitMQ client")
 	}
 	defer queueClient.Close()
 
+	// Polls the queue of the client itself, as the wrappers don't report its depth
+	monitor := backpressure.NewMonitor(queueClient, repo, &cfg.Backpressure)
+
+	minioClient = faults.WrapStorage(minioClient, injector)
+	queueClient = faults.WrapQueue(queueClient, injector)
+
+	// Fail fast while MinIO or RabbitMQ is down instead of piling up requests
+	if cfg.Resilience.Enabled {
+		minioClient = minioresilient.NewClient(minioClient, &cfg.Resilience)
+		queueClient = queueresilient.NewClient(queueClient, &cfg.Resilience)
+	}
+
+	// Re-publish tasks stored while the queue was unavailable
+	relay := outbox.NewRelay(repo, queueClient, &cfg.Outbox)
+	go relay.Run(ctx)
+
+	// Keep the storage usage gauge current
+	if cfg.Metrics.Enabled {
+		go reportStorageUsage(ctx, repo, cfg.Metrics.StorageUsageInterval)
+	}
+
+	// Purge or expire pending two-step deletions
+	purger := deletion.NewPurger(repo, minioClient, cdn.NewInvalidator(&cfg.CDN), &cfg.Delete)
+	if purger.TwoStep() {
+		go purger.Run(ctx)
+	}
+
+	// Report or remove orphaned objects and dangling images, on schedule and on POST /admin/gc
+	collector := gc.NewCollector(repo, minioClient, purger, &cfg.GC, &cfg.Ingest, &cfg.MinIO.Lifecycle)
+	if cfg.GC.Interval > 0 {
+		go collector.Run(ctx)
+	}
+
+	// Periodically verify stored objects against their checksums
+	if cfg.Integrity.Interval > 0 {
+		go integrity.NewVerifier(repo, minioClient, &cfg.Integrity).Run(ctx)
+	}
+
+	// Queue failed images again with a backoff, up to the configured number of retries
+	if cfg.Retry.Interval > 0 {
+		go retry.NewRetrier(repo, relay, &cfg.Retry).Run(ctx)
+	}
+
+	// Throttle low priority uploads while the workers can't keep up
+	if cfg.Backpressure.Enabled {
+		go monitor.Run(ctx)
+	}
+
+	// Write the usage metered by the handlers, snapshot stored bytes and roll usage up
+	// into the monthly totals
+	meter := usage.NewMeter(repo, &cfg.Usage)
+	if cfg.Usage.Enabled {
+		go meter.RunFlusher(ctx)
+		go meter.Run(ctx)
+	}
+
+	// Refresh the daily stats materialized view if it is used
+	if cfg.Stats.MaterializedView {
+		go refreshImageStats(ctx, repo, cfg.Stats.RefreshInterval)
+	}
+
+	// Relay the status changes of images notified by the database to progress streams and waits
+	bus := events.NewBus(repo)
+	go bus.Run(ctx)
+
+	// Report handler errors and panics to the error tracker if enabled
+	reporter := errreport.NewReporter(&cfg.ErrorReport)
+
+	// Re-read the reloadable settings on SIGHUP or POST /admin/reload
+	reloader := reload.New()
+	go reloader.WatchSignal(ctx)
+
+	// Process the tasks in-process when there is no separate worker to consume them
+	var w *worker.Worker
+	if ephemeral {
+		w = worker.New(repo, minioClient, queueClient, meter, cfg)
+		if err := w.Start(ctx); err != nil {
+			log.Fatal().Err(err).Msg("Failed to start worker")
+		}
+	}
+
 	// Setup router
-	r := router.Setup(cfg, repo, minioClient, queueClient)
+	r := router.Setup(cfg, repo, minioClient, queueClient, reporter, reloader, collector, injector, bus, monitor, meter)
 
-	// Configure HTTP server
+	// Configure HTTP server. Read and write deadlines are set per route by
+	// middleware.Timeout rather than server-wide.
 	server := &http.Server{
-		Addr:         fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
-		Handler:      r,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:              fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
+		Handler:           r,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		IdleTimeout:       60 * time.Second,
 	}
 
 	// Start HTTP server in a goroutine
@@ -99,5 +292,63 @@ func main() {
 		log.Fatal().Err(err).Msg("API server forced to shutdown")
 	}
 
+	// wait for the tasks of the in-process worker
+	if w != nil {
+		w.Stop(shutdownCtx)
+	}
+
+	// Write the usage metered since the last flush
+	meter.Flush(shutdownCtx)
+
+	// Deliver the errors reported during shutdown
+	if reporter != nil {
+		reporter.Flush(shutdownCtx)
+	}
+
 	log.Info().Msg("API server stopped")
 }
+
+// refreshImageStats refreshes the image_daily_stats materialized view every interval
+func refreshImageStats(ctx context.Context, repo db.Repository, interval time.Duration) {
+	reqLogger := logger.GetLogger("image-stats")
+	ctx = logger.ToContext(ctx, reqLogger)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := repo.RefreshImageStats(ctx); err != nil {
+			reqLogger.Error().Err(err).Msg("Failed to refresh image stats")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reportStorageUsage updates the storage usage gauge from the database every interval
+func reportStorageUsage(ctx context.Context, repo db.Repository, interval time.Duration) {
+	reqLogger := logger.GetLogger("storage-usage")
+	ctx = logger.ToContext(ctx, reqLogger)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		usage, err := repo.GetStorageUsage(ctx)
+		if err != nil {
+			reqLogger.Error().Err(err).Msg("Failed to refresh storage usage")
+		} else {
+			metrics.UpdateStorageUsage(usage.TotalBytes)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
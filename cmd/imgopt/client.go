@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Client is a minimal client for the image optimizer REST API
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+// Image is the image representation returned by GET /images/{id}
+type Image struct {
+	ID               string            `json:"id"`
+	OriginalName     string            `json:"original_name"`
+	Status           string            `json:"status"`
+	OriginalURL      string            `json:"original_url,omitempty"`
+	OptimizedURL     string            `json:"optimized_url,omitempty"`
+	OriginalSize     int64             `json:"original_size"`
+	OptimizedSize    int64             `json:"optimized_size,omitempty"`
+	Reduction        float64           `json:"reduction,omitempty"`
+	Error            string            `json:"error,omitempty"`
+	ModerationStatus string            `json:"moderation_status"`
+	RenditionURLs    map[string]string `json:"rendition_urls,omitempty"`
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+}
+
+// ImageList is the response of GET /images
+type ImageList struct {
+	Images []json.RawMessage `json:"images"`
+	Total  int               `json:"total"`
+}
+
+// Accepted is the response of an upload or reprocess request
+type Accepted struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+// APIError is a problem+json error returned by the API
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	if e.Details != nil {
+		return fmt.Sprintf("%d %s: %s (%v)", e.Status, e.Code, e.Message, e.Details)
+	}
+	return fmt.Sprintf("%d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// NewClient creates a client for the API at baseURL
+func NewClient(baseURL string, timeout time.Duration) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/") + "/api/v1",
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// Upload uploads the file at path with the given processing options
+func (c *Client) Upload(ctx context.Context, path string, options url.Values) (*Accepted, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Stream the multipart body instead of buffering the whole file
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		part, err := form.CreateFormFile("image", filepath.Base(path))
+		if err == nil {
+			_, err = io.Copy(part, file)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/images", options), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	var accepted Accepted
+	if err := c.do(req, &accepted); err != nil {
+		return nil, err
+	}
+	return &accepted, nil
+}
+
+// Get returns an image
+func (c *Client) Get(ctx context.Context, id string) (*Image, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/images/"+url.PathEscape(id), nil), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var img Image
+	if err := c.do(req, &img); err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+// List returns a page of images matching query
+func (c *Client) List(ctx context.Context, page, limit int, query string) (*ImageList, error) {
+	params := url.Values{}
+	params.Set("page", fmt.Sprint(page))
+	params.Set("limit", fmt.Sprint(limit))
+	if query != "" {
+		params.Set("q", query)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/images", params), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var list ImageList
+	if err := c.do(req, &list); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// Delete deletes an image
+func (c *Client) Delete(ctx context.Context, id string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, c.url("/images/"+url.PathEscape(id), nil), nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, nil)
+}
+
+// Reprocess queues an image for processing again with the given options
+func (c *Client) Reprocess(ctx context.Context, id string, options url.Values) (*Accepted, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/images/"+url.PathEscape(id)+"/reprocess", options), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var accepted Accepted
+	if err := c.do(req, &accepted); err != nil {
+		return nil, err
+	}
+	return &accepted, nil
+}
+
+// url builds the URL of an API path
+func (c *Client) url(path string, params url.Values) string {
+	if len(params) == 0 {
+		return c.baseURL + path
+	}
+	return c.baseURL + path + "?" + params.Encode()
+}
+
+// do sends req and decodes a successful JSON response into out, or returns the API error
+func (c *Client) do(req *http.Request, out any) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading response: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr := &APIError{Status: resp.StatusCode}
+		if err := json.Unmarshal(data, apiErr); err != nil || apiErr.Code == "" {
+			apiErr.Message = strings.TrimSpace(string(bytes.TrimSpace(data)))
+		}
+		apiErr.Status = resp.StatusCode
+		return apiErr
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("error decoding response: %w", err)
+	}
+	return nil
+}
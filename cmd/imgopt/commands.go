@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// uploadExtensions are the file extensions accepted by the API
+var uploadExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true}
+
+// processingFlags registers the processing options shared by upload and reprocess
+func processingFlags(fs *flag.FlagSet) func() url.Values {
+	maxWidth := fs.Int("max-width", 0, "maximum width")
+	maxHeight := fs.Int("max-height", 0, "maximum height")
+	quality := fs.Int("quality", 0, "encoding quality (1-100)")
+	filter := fs.String("filter", "", "resampling filter (lanczos, catmullrom, box, nearest)")
+	sharpen := fs.Float64("sharpen", 0, "sharpening sigma")
+	targetSize := fs.Int("target-size-kb", 0, "target size of the optimized image in KB")
+	minQuality := fs.Int("min-quality", 0, "lowest quality used to reach the target size")
+	renditions := fs.String("renditions", "", "comma separated rendition templates")
+	gravity := fs.String("gravity", "", "crop gravity (center, faces)")
+
+	return func() url.Values {
+		params := url.Values{}
+		setInt := func(key string, value int) {
+			if value > 0 {
+				params.Set(key, strconv.Itoa(value))
+			}
+		}
+		setInt("max_width", *maxWidth)
+		setInt("max_height", *maxHeight)
+		setInt("quality", *quality)
+		setInt("target_size_kb", *targetSize)
+		setInt("min_quality", *minQuality)
+		if *sharpen > 0 {
+			params.Set("sharpen", strconv.FormatFloat(*sharpen, 'f', -1, 64))
+		}
+		for key, value := range map[string]string{"filter": *filter, "renditions": *renditions, "gravity": *gravity} {
+			if value != "" {
+				params.Set(key, value)
+			}
+		}
+		return params
+	}
+}
+
+func runUpload(ctx context.Context, client *Client, args []string) error {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	options := processingFlags(fs)
+	concurrency := fs.Int("concurrency", 4, "number of parallel uploads")
+	extractText := fs.Bool("extract-text", false, "also extract text with OCR")
+	removeBackground := fs.Bool("remove-background", false, "also produce a background-removed cut-out")
+	wait := fs.Bool("wait", false, "wait until the uploaded images are processed")
+	fs.Parse(args)
+
+	files, err := collectFiles(fs.Args())
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errors.New("no JPG or PNG files to upload")
+	}
+
+	params := options()
+	if *extractText {
+		params.Set("extract_text", "true")
+	}
+	if *removeBackground {
+		params.Set("remove_background", "true")
+	}
+
+	var progress *Progress
+	if len(files) > 1 {
+		progress = NewProgress(os.Stderr, len(files))
+	}
+
+	type result struct {
+		path     string
+		accepted *Accepted
+		err      error
+	}
+	results := make([]result, len(files))
+
+	// Upload with a bounded pool of workers
+	var wg sync.WaitGroup
+	paths := make(chan int)
+	for i := 0; i < max(*concurrency, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range paths {
+				accepted, err := client.Upload(ctx, files[i], params)
+				results[i] = result{path: files[i], accepted: accepted, err: err}
+				if progress != nil {
+					progress.Done(err != nil)
+				}
+			}
+		}()
+	}
+	for i := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		paths <- i
+	}
+	close(paths)
+	wg.Wait()
+	if progress != nil {
+		progress.Finish()
+	}
+
+	var ids []string
+	failed := 0
+	for _, r := range results {
+		switch {
+		case r.err != nil:
+			failed++
+			fmt.Fprintf(os.Stderr, "%s\t%v\n", r.path, r.err)
+		case r.accepted != nil:
+			ids = append(ids, r.accepted.ID)
+			fmt.Printf("%s\t%s\t%s\n", r.path, r.accepted.ID, r.accepted.Status)
+		}
+	}
+
+	if *wait && len(ids) > 0 {
+		if err := watchImages(ctx, client, ids, 2*time.Second); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d uploads failed", failed, len(files))
+	}
+	return ctx.Err()
+}
+
+// collectFiles expands directories into the image files they contain
+func collectFiles(args []string) ([]string, error) {
+	var files []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		err = filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && uploadExtensions[strings.ToLower(filepath.Ext(path))] {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func runGet(ctx context.Context, client *Client, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: imgopt get <id>")
+	}
+	img, err := client.Get(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return printJSON(img)
+}
+
+func runList(ctx context.Context, client *Client, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	page := fs.Int("page", 1, "page number")
+	limit := fs.Int("limit", 10, "images per page (max 100)")
+	query := fs.String("q", "", "full-text search over names and extracted text")
+	fs.Parse(args)
+
+	list, err := client.List(ctx, *page, *limit, *query)
+	if err != nil {
+		return err
+	}
+	return printJSON(list)
+}
+
+func runDelete(ctx context.Context, client *Client, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: imgopt delete <id>...")
+	}
+	var errs []error
+	for _, id := range args {
+		if err := client.Delete(ctx, id); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", id, err))
+			continue
+		}
+		fmt.Printf("%s\tdeleted\n", id)
+	}
+	return errors.Join(errs...)
+}
+
+func runReprocess(ctx context.Context, client *Client, args []string) error {
+	fs := flag.NewFlagSet("reprocess", flag.ExitOnError)
+	options := processingFlags(fs)
+	wait := fs.Bool("wait", false, "wait until the image is processed")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		return errors.New("usage: imgopt reprocess [flags] <id>")
+	}
+	accepted, err := client.Reprocess(ctx, fs.Arg(0), options())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\t%s\n", accepted.ID, accepted.Status)
+
+	if *wait {
+		return watchImages(ctx, client, []string{accepted.ID}, 2*time.Second)
+	}
+	return nil
+}
+
+func runWatch(ctx context.Context, client *Client, args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := fs.Duration("interval", 2*time.Second, "polling interval")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		return errors.New("usage: imgopt watch [flags] <id>...")
+	}
+	return watchImages(ctx, client, fs.Args(), *interval)
+}
+
+// watchImages polls the images until each one is completed or failed, printing every
+// status change. It returns an error if any image failed.
+func watchImages(ctx context.Context, client *Client, ids []string, interval time.Duration) error {
+	pending := make(map[string]string, len(ids))
+	for _, id := range ids {
+		pending[id] = ""
+	}
+
+	failed := 0
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		for id, last := range pending {
+			img, err := client.Get(ctx, id)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				fmt.Fprintf(os.Stderr, "%s\t%v\n", id, err)
+				continue
+			}
+			if img.Status != last {
+				pending[id] = img.Status
+				line := fmt.Sprintf("%s\t%s", id, img.Status)
+				if img.Status == "completed" && img.OriginalSize > 0 {
+					line += fmt.Sprintf("\t%d -> %d bytes (%.1f%%)", img.OriginalSize, img.OptimizedSize, img.Reduction)
+				}
+				if img.Error != "" {
+					line += "\t" + img.Error
+				}
+				fmt.Println(line)
+			}
+			switch img.Status {
+			case "completed":
+				delete(pending, id)
+			case "failed", "rejected":
+				failed++
+				delete(pending, id)
+			}
+		}
+
+		if len(pending) == 0 {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images failed", failed, len(ids))
+	}
+	return nil
+}
+
+// printJSON writes v to stdout as indented JSON
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
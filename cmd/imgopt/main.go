@@ -0,0 +1,82 @@
+// Command imgopt is a command line client for the image optimizer API.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// command is an imgopt subcommand
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(ctx context.Context, client *Client, args []string) error
+}
+
+var commands = []command{
+	{"upload", "upload [flags] <file|dir>...", "Upload files or directories of images", runUpload},
+	{"get", "get <id>", "Show an image", runGet},
+	{"list", "list [flags]", "List images", runList},
+	{"delete", "delete <id>...", "Delete images", runDelete},
+	{"reprocess", "reprocess [flags] <id>", "Queue an image for processing again", runReprocess},
+	{"watch", "watch [flags] <id>...", "Poll images until they are processed", runWatch},
+}
+
+func main() {
+	global := flag.NewFlagSet("imgopt", flag.ExitOnError)
+	server := global.String("server", envOr("IMGOPT_SERVER", "http://localhost:8080"), "API base URL (env IMGOPT_SERVER)")
+	timeout := global.Duration("timeout", 60*time.Second, "timeout of each API request")
+	global.Usage = usage(global)
+	global.Parse(os.Args[1:])
+
+	if global.NArg() == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+
+	name, args := global.Arg(0), global.Args()[1:]
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		err := cmd.run(ctx, NewClient(*server, *timeout), args)
+		stop()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "imgopt %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "imgopt: unknown command %q\n", name)
+	global.Usage()
+	os.Exit(2)
+}
+
+// usage prints the global flags and the list of subcommands
+func usage(global *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, "Usage: imgopt [global flags] <command> [args]\n\nCommands:\n")
+		for _, cmd := range commands {
+			fmt.Fprintf(os.Stderr, "  %-40s %s\n", cmd.usage, cmd.summary)
+		}
+		fmt.Fprintf(os.Stderr, "\nGlobal flags:\n")
+		global.PrintDefaults()
+	}
+}
+
+// envOr returns the environment variable key or fallback if it is unset
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
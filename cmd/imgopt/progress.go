@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// progressWidth is the number of characters of the bar itself
+const progressWidth = 30
+
+// Progress renders a single-line progress bar for a known number of items
+type Progress struct {
+	mu     sync.Mutex
+	out    io.Writer
+	total  int
+	done   int
+	failed int
+}
+
+// NewProgress creates a progress bar for total items writing to out
+func NewProgress(out io.Writer, total int) *Progress {
+	p := &Progress{out: out, total: total}
+	p.render()
+	return p
+}
+
+// Done marks one item as finished
+func (p *Progress) Done(failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if failed {
+		p.failed++
+	}
+	p.render()
+}
+
+// Finish ends the progress line
+func (p *Progress) Finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintln(p.out)
+}
+
+func (p *Progress) render() {
+	filled := progressWidth
+	if p.total > 0 {
+		filled = p.done * progressWidth / p.total
+	}
+	fmt.Fprintf(p.out, "\r[%s%s] %d/%d", strings.Repeat("#", filled), strings.Repeat("-", progressWidth-filled), p.done, p.total)
+	if p.failed > 0 {
+		fmt.Fprintf(p.out, " (%d failed)", p.failed)
+	}
+}
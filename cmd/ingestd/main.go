@@ -0,0 +1,94 @@
+// Command ingestd ingests the images dropped into a directory or a prefix of the bucket
+package main
+
+import (
+	"context"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/db/postgres"
+	"github.com/not-nullexception/image-optimizer/internal/ingest"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/minio/minio"
+	minioresilient "github.com/not-nullexception/image-optimizer/internal/minio/resilient"
+	"github.com/not-nullexception/image-optimizer/internal/outbox"
+	"github.com/not-nullexception/image-optimizer/internal/queue/rabbitmq"
+	queueresilient "github.com/not-nullexception/image-optimizer/internal/queue/resilient"
+)
+
+func main() {
+	// Cancel the context on shutdown signals
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Load configuration
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to load configuration")
+	}
+
+	// Setup logger
+	logShutdown := logger.Setup(&cfg.Log)
+	defer logShutdown() // flush logs still pending for Loki
+
+	if cfg.Ingest.Dir == "" && cfg.Ingest.BucketPrefix == "" {
+		log.Fatal().Msg("INGEST_DIR or INGEST_BUCKET_PREFIX must be set")
+	}
+	if cfg.Ingest.BucketPrefix != "" && strings.HasPrefix(cfg.Ingest.BucketFailedPrefix, cfg.Ingest.BucketPrefix) {
+		log.Fatal().Msg("INGEST_BUCKET_FAILED_PREFIX must not be inside INGEST_BUCKET_PREFIX")
+	}
+
+	// Create database repository
+	repo, err := postgres.NewRepository(ctx, &cfg.Database)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create database repository")
+	}
+	defer repo.Close()
+
+	// Create MinIO client
+	minioClient, err := minio.NewClient(&cfg.MinIO)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create MinIO client")
+	}
+	defer minioClient.Close()
+
+	// Create RabbitMQ client
+	queueClient, err := rabbitmq.NewClient(&cfg.RabbitMQ)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to create RabbitMQ client")
+	}
+	defer queueClient.Close()
+
+	// Fail fast while MinIO or RabbitMQ is down instead of piling up requests
+	if cfg.Resilience.Enabled {
+		minioClient = minioresilient.NewClient(minioClient, &cfg.Resilience)
+		queueClient = queueresilient.NewClient(queueClient, &cfg.Resilience)
+	}
+
+	// Tasks that could not be published are stored in the outbox and relayed by the API
+	relay := outbox.NewRelay(repo, queueClient, &cfg.Outbox)
+	ingester := ingest.NewIngester(repo, minioClient, relay, &cfg.Processing, &cfg.Scan, cfg.Server.MaxBodyBytes)
+
+	var wg sync.WaitGroup
+	if cfg.Ingest.BucketPrefix != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ingest.NewBucketListener(ingester, minioClient, &cfg.Ingest).Run(ctx)
+		}()
+	}
+
+	if cfg.Ingest.Dir != "" {
+		if err := ingest.NewWatcher(ingester, &cfg.Ingest).Run(ctx); err != nil {
+			log.Fatal().Err(err).Msg("Ingestion stopped")
+		}
+	}
+	wg.Wait()
+
+	log.Info().Msg("Ingestion daemon stopped")
+}
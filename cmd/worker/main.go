@@ -4,6 +4,7 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,16 +12,22 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 
 	"github.com/not-nullexception/image-optimizer/config"
 	"github.com/not-nullexception/image-optimizer/internal/db/postgres"
+	"github.com/not-nullexception/image-optimizer/internal/faults"
 	"github.com/not-nullexception/image-optimizer/internal/logger"
 	"github.com/not-nullexception/image-optimizer/internal/metrics"
 	"github.com/not-nullexception/image-optimizer/internal/minio/minio"
+	minioresilient "github.com/not-nullexception/image-optimizer/internal/minio/resilient"
+	"github.com/not-nullexception/image-optimizer/internal/profiling"
 	"github.com/not-nullexception/image-optimizer/internal/queue/rabbitmq"
+	queueresilient "github.com/not-nullexception/image-optimizer/internal/queue/resilient"
+	"github.com/not-nullexception/image-optimizer/internal/reload"
+	"github.com/not-nullexception/image-optimizer/internal/replication"
 	"github.com/not-nullexception/image-optimizer/internal/tracing"
+	"github.com/not-nullexception/image-optimizer/internal/usage"
 	"github.com/not-nullexception/image-optimizer/internal/worker"
 )
 
@@ -29,14 +36,27 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML configuration file; environment variables override it")
+	checkConfig := flag.Bool("check-config", false, "validate the configuration and exit")
+	flag.Parse()
+
 	// Load configuration
-	cfg, err := config.Load()
+	cfg, err := config.LoadFile(*configFile)
+	if *checkConfig {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("Configuration is valid")
+		return
+	}
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to load configuration")
 	}
 
 	// Setup logger
-	logger.Setup(&cfg.Log)
+	logShutdown := logger.Setup(&cfg.Log)
+	defer logShutdown() // flush logs still pending for Loki
 
 	if cfg.Tracing.Enabled {
 		traceCfg := tracing.TracingConfig{
@@ -45,6 +65,11 @@ func main() {
 			Environment:    cfg.Tracing.Environment,
 			OTLPEndpoint:   cfg.Tracing.OTLPEndpoint,
 			Enabled:        cfg.Tracing.Enabled,
+			Insecure:       cfg.Tracing.OTLPInsecure,
+			Sampling: tracing.SamplingConfig{
+				Sampler: cfg.Tracing.Sampler,
+				Ratio:   cfg.Tracing.SampleRatio,
+			},
 		}
 		tracerShutdown, err := tracing.Init(ctx, traceCfg)
 		if err != nil {
@@ -58,6 +83,29 @@ func main() {
 		metrics.Init()
 	}
 
+	// Push metrics over OTLP if enabled
+	metricsShutdown, err := tracing.InitMetrics(ctx, tracing.MetricsConfig{
+		ServiceName:    cfg.Tracing.ServiceName + "-worker",
+		ServiceVersion: cfg.Tracing.ServiceVersion,
+		Environment:    cfg.Tracing.Environment,
+		OTLPEndpoint:   cfg.Observability.OTLPMetricsEndpoint,
+		Interval:       cfg.Observability.OTLPMetricsInterval,
+		Enabled:        cfg.Observability.OTLPMetrics,
+		Insecure:       cfg.Observability.OTLPMetricsInsecure,
+	})
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to initialize OTLP metrics export")
+	}
+	defer metricsShutdown() // flush pending metrics on exit
+
+	// Start the profiler if enabled
+	profilerAddr := fmt.Sprintf("%s:%d", cfg.Observability.ProfilerHost, cfg.Worker.ProfilerPort)
+	profilerShutdown, err := profiling.Start(&cfg.Observability, cfg.Tracing.ServiceName+"-worker", profilerAddr)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Failed to start profiler")
+	}
+	defer profilerShutdown()
+
 	// Create database repository
 	repo, err := postgres.NewRepository(ctx, &cfg.Database)
 	if err != nil {
@@ -65,6 +113,11 @@ func main() {
 	}
 	defer repo.Close()
 
+	// Inject faults into the dependencies in builds with the faults tag. The wrappers sit
+	// below the cache and circuit breakers so that those are exercised too.
+	injector := faults.New(&cfg.Faults)
+	repo = faults.WrapRepository(repo, injector)
+
 	// Create MinIO client
 	minioClient, err := minio.NewClient(&cfg.MinIO)
 	if err != nil {
@@ -79,16 +132,43 @@ func main() {
 	}
 	defer queueClient.Close()
 
-	// Start metrics server if enabled
-	var metricsServer *http.Server
-	if cfg.Metrics.Enabled {
-		metricsAddr := fmt.Sprintf(":%d", cfg.Worker.MetricsPort)
-		metricsServer = startMetricsServer(metricsAddr)
-		log.Info().Str("address", metricsAddr).Msg("Starting metrics server for worker")
+	minioClient = faults.WrapStorage(minioClient, injector)
+	queueClient = faults.WrapQueue(queueClient, injector)
+
+	// Fail fast while MinIO or RabbitMQ is down instead of piling up requests
+	if cfg.Resilience.Enabled {
+		minioClient = minioresilient.NewClient(minioClient, &cfg.Resilience)
+		queueClient = queueresilient.NewClient(queueClient, &cfg.Resilience)
+	}
+
+	// Copy optimized objects to the replication target if enabled
+	if cfg.Replication.Enabled {
+		targetClient, err := minio.NewClient(cfg.Replication.TargetMinIO(cfg.MinIO))
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create replication target client")
+		}
+		defer targetClient.Close()
+		go replication.NewReplicator(repo, minioClient, targetClient, &cfg.Replication).Run(ctx)
+	}
+
+	// Write the usage metered by the tasks in batches
+	meter := usage.NewMeter(repo, &cfg.Usage)
+	if cfg.Usage.Enabled {
+		go meter.RunFlusher(ctx)
 	}
 
 	// Create worker
-	w := worker.New(repo, minioClient, queueClient, cfg)
+	w := worker.New(repo, minioClient, queueClient, meter, cfg)
+
+	// Re-read the task concurrency and processing defaults on SIGHUP
+	reloader := reload.New()
+	reloader.Register(w)
+	go reloader.WatchSignal(ctx)
+
+	// Start the worker HTTP server with health, status and, if enabled, metrics endpoints
+	httpAddr := fmt.Sprintf(":%d", cfg.Worker.MetricsPort)
+	httpServer := startHTTPServer(httpAddr, w, injector, cfg.Metrics.Enabled, cfg.Server.AdminToken)
+	log.Info().Str("address", httpAddr).Msg("Starting HTTP server for worker")
 
 	// Start worker
 	if err := w.Start(ctx); err != nil {
@@ -103,33 +183,45 @@ func main() {
 
 	log.Info().Msg("Shutting down worker...")
 
-	// cancel the context to stop the worker
+	// cancel the context to stop consuming; tasks in flight keep running
 	cancel()
 
 	// create a new context for shutdown with a timeout
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 15*time.Second) // Aumentado ligeiramente
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), cfg.Worker.ShutdownTimeout)
 	defer shutdownCancel()
 
-	// stop the worker
-	w.Stop() // call the Stop method to stop the worker gracefully
+	// wait for the tasks in flight, requeuing those past the deadline
+	w.Stop(shutdownCtx)
 
-	// Stop the metrics server if it was started
-	if metricsServer != nil {
-		log.Info().Msg("Shutting down metrics server...")
-		if err := metricsServer.Shutdown(shutdownCtx); err != nil {
-			log.Error().Err(err).Msg("Metrics server shutdown failed")
-		} else {
-			log.Info().Msg("Metrics server stopped")
-		}
+	// Write the usage metered since the last flush
+	meter.Flush(shutdownCtx)
+
+	// Stop the HTTP server
+	log.Info().Msg("Shutting down HTTP server...")
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("HTTP server shutdown failed")
+	} else {
+		log.Info().Msg("HTTP server stopped")
 	}
 
 	log.Info().Msg("Worker stopped gracefully")
 }
 
-// startMetricsServer starts the metrics server for the worker
-func startMetricsServer(addr string) *http.Server {
+// startHTTPServer starts the HTTP server for the worker
+func startHTTPServer(addr string, w *worker.Worker, injector *faults.Injector, metricsEnabled bool, adminToken string) *http.Server {
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler()) // Prometheus metrics endpoint
+	mux.Handle("/healthz", w.HealthHandler())
+	mux.Handle("/status", w.StatusHandler())
+	if metricsEnabled {
+		mux.Handle("/metrics", metrics.Handler()) // Prometheus metrics endpoint
+	}
+	// Admin routes are only mounted when a token is configured, as on the API
+	if adminToken != "" {
+		mux.Handle("/admin/loglevel", worker.LogLevelHandler(adminToken))
+		if faults.Available {
+			mux.Handle("/admin/faults", worker.AdminOnly(adminToken, injector.Handler()))
+		}
+	}
 
 	server := &http.Server{
 		Addr:         addr,
@@ -141,9 +233,9 @@ func startMetricsServer(addr string) *http.Server {
 
 	// Start the server in a goroutine to avoid blocking
 	go func() {
-		log.Debug().Str("address", addr).Msg("Metrics server ListenAndServe starting")
+		log.Debug().Str("address", addr).Msg("HTTP server ListenAndServe starting")
 		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Fatal().Err(err).Str("address", addr).Msg("Metrics server ListenAndServe failed")
+			log.Fatal().Err(err).Str("address", addr).Msg("HTTP server ListenAndServe failed")
 		}
 	}()
 
@@ -1,9 +1,15 @@
 package config
 
 import (
+	"context"
 	"fmt"
+	"maps"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -20,12 +26,81 @@ type Config struct {
 	Metrics       MetricsConfig
 	Tracing       TracingConfig
 	Observability ObservabilityConfig
+	ErrorReport   ErrorReportConfig
+	Vault         VaultConfig
+	Transform     TransformConfig
+	UploadTokens  UploadTokenConfig
+	Cache         CacheConfig
+	CDN           CDNConfig
+	Moderation    ModerationConfig
+	OCR           OCRConfig
+	FaceDetection FaceDetectionConfig
+	Background    BackgroundRemovalConfig
+	Quality       QualityConfig
+	Processing    ProcessingConfig
+	Outbox        OutboxConfig
+	Resilience    ResilienceConfig
+	Integrity     IntegrityConfig
+	Retry         RetryConfig
+	Backpressure  BackpressureConfig
+	Usage         UsageConfig
+	Replication   ReplicationConfig
+	Stats         StatsConfig
+	Ingest        IngestConfig
+	Versions      VersionsConfig
+	Delete        DeleteConfig
+	GC            GCConfig
+	Scan          ScanConfig
+	Faults        FaultsConfig
+	SelfTest      SelfTestConfig
 }
 
 type ServerConfig struct {
 	Host string
 	Port int
 	Mode string
+	// LegacyAPISunset is announced in the Sunset header of the unversioned /api routes
+	LegacyAPISunset time.Time
+	// AdminToken is the bearer token of the /admin routes, which are disabled when empty
+	AdminToken string
+	// APIKeys maps owners to their API key; callers without a key are anonymous
+	APIKeys map[string]string
+	// KeyLimits restrict what the API keys of some owners may do, by owner
+	KeyLimits map[string]KeyLimits
+	// MaxBodyBytes rejects larger request bodies with 413
+	MaxBodyBytes int64
+	// ReadHeaderTimeout bounds how long a client may take to send the request headers
+	ReadHeaderTimeout time.Duration
+	// MinBodyRate is the slowest accepted average upload rate in bytes per second after
+	// BodyReadGrace; slower bodies time out with 408. 0 disables it.
+	MinBodyRate   int64
+	BodyReadGrace time.Duration
+	Timeouts      TimeoutConfig
+}
+
+// KeyLimits restrict the callers of an API key, for third-party integrations. Empty fields
+// leave the caller unrestricted.
+type KeyLimits struct {
+	// Formats are the input formats the key may upload
+	Formats []string
+	// MaxWidth and MaxHeight bound the dimensions of the images the key may upload
+	MaxWidth  int
+	MaxHeight int
+	// Operations are the operations the key may perform besides reading image metadata
+	Operations []string
+}
+
+// TimeoutConfig bounds how long requests of each kind of route may run; past it their
+// context is cancelled and they answer 504. 0 leaves those routes unbounded.
+type TimeoutConfig struct {
+	// Read covers metadata, listings, stats and health checks
+	Read time.Duration
+	// Write covers changes such as reprocessing, promotion and deletion
+	Write time.Duration
+	// Upload covers uploads, including the transfer of the body
+	Upload time.Duration
+	// Stream covers downloads, exports and transformations streamed to the client
+	Stream time.Duration
 }
 
 type DatabaseConfig struct {
@@ -37,6 +112,17 @@ type DatabaseConfig struct {
 	SSLMode        string
 	MaxConnections int
 	MinConnections int
+	// MaxConnLifetime and MaxConnIdleTime recycle pool connections; 0 keeps the pgx defaults
+	MaxConnLifetime time.Duration
+	MaxConnIdleTime time.Duration
+	// HealthCheckPeriod is how often idle connections are checked; 0 keeps the pgx default
+	HealthCheckPeriod time.Duration
+	// ConnectAttempts and ConnectRetryDelay wait for the database on startup; the delay
+	// doubles after each failed attempt
+	ConnectAttempts   int
+	ConnectRetryDelay time.Duration
+	// Credentials re-reads User and Password when they are rotated
+	Credentials *Credential
 }
 
 type MinIOConfig struct {
@@ -47,6 +133,58 @@ type MinIOConfig struct {
 	SSL       bool
 	Location  string
 	URLExpiry time.Duration
+	// PrivateURLExpiry is the lifetime of presigned URLs of private images
+	PrivateURLExpiry time.Duration
+	PublicRead       bool
+	// OriginalsBucket, OptimizedBucket and TempBucket keep each class of objects in its
+	// own bucket; they default to Bucket. Temporary objects are the ones dropped into the
+	// bucket for ingestion.
+	OriginalsBucket string
+	OptimizedBucket string
+	TempBucket      string
+	// OriginalsPrefix and OptimizedPrefix are prepended to the names of new originals and
+	// derived objects
+	OriginalsPrefix string
+	OptimizedPrefix string
+	// UploadQuarantinePrefix keeps new originals under this prefix until their image is
+	// processed, then moves them to their permanent name; empty stores them there directly.
+	// Originals left behind expire after UploadQuarantineExpireDays.
+	UploadQuarantinePrefix     string
+	UploadQuarantineExpireDays int
+	// NamingStrategy lays out object names: uuid, date or hash
+	NamingStrategy string
+	// VariantNaming names derived objects after their size and format (sized) or only
+	// their format (plain)
+	VariantNaming string
+	// SSE encrypts new objects on the server: s3, kms (with SSEKMSKeyID) or c (with the
+	// base64 encoded 256-bit SSECustomerKey); empty disables it
+	SSE            string
+	SSEKMSKeyID    string
+	SSECustomerKey string
+	// VerifyChecksums fails reads of whole objects that don't match the checksum
+	// recorded in their metadata at upload
+	VerifyChecksums bool
+	Lifecycle       LifecycleConfig
+	// RetryAttempts bounds the attempts of idempotent calls; the delay between them
+	// doubles from RetryBaseDelay up to RetryMaxDelay, with jitter
+	RetryAttempts  int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// Credentials re-reads AccessKey and SecretKey when they are rotated
+	Credentials *Credential
+}
+
+// LifecycleConfig describes the bucket lifecycle rules applied on startup
+type LifecycleConfig struct {
+	// Managed replaces the bucket lifecycle configuration with these rules; when false
+	// the bucket lifecycle is left alone
+	Managed bool
+	// OriginalsTransitionDays moves originals to OriginalsStorageClass (a MinIO tier or
+	// S3 storage class) after this many days; 0 disables it
+	OriginalsTransitionDays int
+	OriginalsStorageClass   string
+	// ExpirePrefixes deletes objects under each prefix after the given number of days
+	ExpirePrefixes map[string]int
 }
 
 type RabbitMQConfig struct {
@@ -58,12 +196,60 @@ type RabbitMQConfig struct {
 	Exchange    string
 	RoutingKey  string
 	ConsumerTag string
+	// Prefetch bounds the deliveries a consumer holds unacknowledged, and so the tasks
+	// processed concurrently (further bounded by MaxWorkers)
+	Prefetch int
+	// TaskQueues routes task types to queues of their own, consumed with their own
+	// concurrency; other task types go to Queue
+	TaskQueues map[string]TaskQueue
+	// ConfirmTimeout is how long Publish waits for the broker to confirm a task, 0 to
+	// publish without confirms
+	ConfirmTimeout time.Duration
+	// Serialization encodes published tasks as json or protobuf. Consumers decode tasks by
+	// the content type of their message, whatever the serialization.
+	Serialization string
+	// Credentials re-reads User and Password when they are rotated
+	Credentials *Credential
+}
+
+// TaskQueue is the queue of a task type and the number of its tasks processed at once
+type TaskQueue struct {
+	Queue    string
+	Prefetch int
 }
 
 type WorkerConfig struct {
 	Count       int
 	MaxWorkers  int
 	MetricsPort int
+	// RenditionConcurrency bounds how many renditions of one image are encoded in parallel
+	RenditionConcurrency int
+	// StallTimeout marks the worker unhealthy if tasks are in flight but none started or
+	// finished for this long; 0 disables the check
+	StallTimeout time.Duration
+	// ProfilerPort is the worker pprof port, bound to OBSERVABILITY_PROFILER_HOST
+	ProfilerPort int
+	// ShutdownTimeout is how long in-flight tasks may run after a shutdown signal before
+	// they are cancelled and requeued
+	ShutdownTimeout time.Duration
+	// MaxAttempts is how many times a task runs before it is given up; failed attempts are
+	// retried after a delay doubling from RetryBaseDelay up to RetryMaxDelay
+	MaxAttempts    int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+	// ID identifies the worker in image leases and the processing history of images. It must
+	// be unique; the hostname with a random suffix when empty.
+	ID string
+	// LeaseDuration is how long an image stays leased to the worker processing it without a
+	// renewal; the worker renews it every third of it
+	LeaseDuration time.Duration
+	// AutoTune sets the task concurrency every AutoTuneInterval from GOMAXPROCS and the CPU
+	// time of tasks, up to MaxWorkers, and lowers it while the heap exceeds MemoryBudgetMB
+	AutoTune         bool
+	AutoTuneInterval time.Duration
+	// MemoryBudgetMB is the heap size above which auto-tuning lowers the concurrency; 0 for
+	// 90% of GOMEMLIMIT, or no budget if GOMEMLIMIT is not set
+	MemoryBudgetMB int
 }
 
 type LogConfig struct {
@@ -71,11 +257,28 @@ type LogConfig struct {
 	Format      string
 	ServiceName string
 	OutputJSON  bool
+	// DebugSampleRate writes 1 of every DebugSampleRate debug messages; 0 and 1 write all
+	DebugSampleRate int
+	// DebugSampleBurst debug messages per second are written before sampling starts
+	DebugSampleBurst int
+	// File also writes the logs to this file, rotated by size, when set
+	File           string
+	FileMaxSizeMB  int
+	FileMaxBackups int
+	FileMaxAgeDays int
+	FileCompress   bool
+	// LokiURL also pushes the logs to the Loki at this base URL, when set, in batches of
+	// up to LokiBatchSize lines at least every LokiFlushInterval
+	LokiURL           string
+	LokiBatchSize     int
+	LokiFlushInterval time.Duration
 }
 
 type MetricsConfig struct {
 	Enabled bool
 	Port    int
+	// StorageUsageInterval is how often the API refreshes the storage usage gauge
+	StorageUsageInterval time.Duration
 }
 
 type TracingConfig struct {
@@ -84,41 +287,494 @@ type TracingConfig struct {
 	ServiceName    string
 	ServiceVersion string
 	Environment    string
+	// Sampler decides which traces are kept: always_on, always_off, ratio (SampleRatio of
+	// new traces) or tail (every trace, with a hint for tail sampling in the collector)
+	Sampler     string
+	SampleRatio float64
+	// RouteRatios overrides SampleRatio for the API routes listed, by route pattern
+	RouteRatios map[string]float64
+	// OTLPInsecure exports without TLS, as the bundled collector expects
+	OTLPInsecure bool
+}
+
+// ErrorReportConfig configures reporting of handler errors, worker task failures and
+// panics to an error tracker
+type ErrorReportConfig struct {
+	Enabled  bool
+	Provider string
+	Sentry   SentryConfig
+}
+
+type SentryConfig struct {
+	DSN         string
+	Environment string
+	Release     string
+	// Timeout bounds each request to Sentry
+	Timeout time.Duration
 }
 
 type ObservabilityConfig struct {
 	MetricsEndpoint string
 	TracingEndpoint string
 	ProfilerEnabled bool
+	// ProfilerHost and ProfilerPort bind the API pprof server; keep it off public interfaces
+	ProfilerHost string
+	ProfilerPort int
+	// ProfilerToken, if set, is required as a bearer token on pprof requests
+	ProfilerToken string
+	// PyroscopeURL enables continuous profiling in push mode
+	PyroscopeURL   string
+	PyroscopeToken string
+	// OTLPMetrics also pushes the Prometheus metrics over OTLP
+	OTLPMetrics bool
+	// OTLPMetricsEndpoint defaults to the tracing OTLP endpoint
+	OTLPMetricsEndpoint string
+	OTLPMetricsInterval time.Duration
+	// OTLPMetricsInsecure pushes the metrics without TLS; it defaults to the tracing setting
+	OTLPMetricsInsecure bool
+}
+
+type TransformConfig struct {
+	Enabled    bool
+	SigningKey string
+	URLExpiry  time.Duration
+	Templates  map[string]TransformTemplate
+	// MaxDPR bounds the device pixel ratio the template dimensions are scaled by
+	MaxDPR float64
+}
+
+// UploadTokenConfig controls the signed single-use tokens that let browsers upload without
+// an API key
+type UploadTokenConfig struct {
+	Enabled    bool
+	SigningKey string
+	// TTL is the lifetime of tokens issued without one; none may live longer than MaxTTL
+	TTL    time.Duration
+	MaxTTL time.Duration
+}
+
+type CacheConfig struct {
+	Enabled    bool
+	TTL        time.Duration
+	MaxEntries int
+	HTTPMaxAge time.Duration
+}
+
+type CDNConfig struct {
+	PublicBaseURL        string
+	InvalidationProvider string
+	InvalidationURL      string
+	InvalidationToken    string
+}
+
+type ModerationConfig struct {
+	Enabled   bool
+	Provider  string
+	URL       string
+	Token     string
+	Threshold float64
+	Timeout   time.Duration
+	Policy    string
+}
+
+// ScanConfig controls malware scanning of uploads
+type ScanConfig struct {
+	Enabled  bool
+	Provider string
+	// Address is the host:port of the clamd TCP socket
+	Address string
+	Timeout time.Duration
+	// FailOpen accepts uploads when the scanner is unavailable instead of rejecting them
+	FailOpen bool
+	// QuarantinePrefix is where infected uploads are kept for review
+	QuarantinePrefix string
+}
+
+// FaultsConfig sets the faults injected at start into the calls to each dependency, for
+// resilience testing. It only has an effect in binaries built with the faults build tag.
+type FaultsConfig struct {
+	Storage  FaultRule
+	Database FaultRule
+	Queue    FaultRule
+}
+
+// SelfTestConfig controls the end-to-end self-test run by POST /admin/selftest
+type SelfTestConfig struct {
+	// Timeout bounds how long the test image may take to be processed
+	Timeout time.Duration
+}
+
+// FaultRule makes ErrorRate of the calls to a dependency fail, between 0 and 1, and delays
+// every call by Latency
+type FaultRule struct {
+	ErrorRate float64
+	Latency   time.Duration
+}
+
+type OCRConfig struct {
+	Enabled       bool
+	Provider      string
+	TesseractPath string
+	Language      string
+	URL           string
+	Token         string
+	Timeout       time.Duration
+}
+
+type FaceDetectionConfig struct {
+	Enabled  bool
+	Provider string
+	URL      string
+	Token    string
+	Timeout  time.Duration
+}
+
+type BackgroundRemovalConfig struct {
+	Enabled  bool
+	Provider string
+	URL      string
+	Token    string
+	Format   string
+	Timeout  time.Duration
+}
+
+// ProcessingConfig holds the defaults applied to uploads that do not set their own options
+type ProcessingConfig struct {
+	DefaultMaxWidth        int
+	DefaultMaxHeight       int
+	DefaultQuality         int
+	DefaultOptimizeStorage bool
+	// FormatQuality overrides DefaultQuality per image format (jpeg, png)
+	FormatQuality map[string]int
+	// MaxTargets bounds the processing targets of an upload
+	MaxTargets int
+	// SyncMaxBytes bounds the uploads processed inline with sync=true; larger uploads are
+	// queued. Zero disables inline processing.
+	SyncMaxBytes int64
+}
+
+// QualityFor returns the default quality for an image format
+func (c *ProcessingConfig) QualityFor(format string) int {
+	if format == "jpg" {
+		format = "jpeg"
+	}
+	if quality, ok := c.FormatQuality[format]; ok && quality > 0 {
+		return quality
+	}
+	return c.DefaultQuality
+}
+
+// StatsConfig controls how the statistics endpoint reads its aggregates
+type StatsConfig struct {
+	// MaterializedView reads daily uploads from image_daily_stats, refreshed every RefreshInterval
+	MaterializedView bool
+	RefreshInterval  time.Duration
+}
+
+// OutboxConfig controls re-publishing of tasks stored while the queue was unavailable
+type OutboxConfig struct {
+	RelayInterval time.Duration
+	BatchSize     int
+	MaxBackoff    time.Duration
+}
+
+// ResilienceConfig controls the circuit breakers around MinIO and RabbitMQ
+type ResilienceConfig struct {
+	Enabled bool
+	// FailureThreshold consecutive failures open a breaker for OpenTimeout, after which
+	// HalfOpenCalls trial calls decide whether it closes again
+	FailureThreshold int
+	OpenTimeout      time.Duration
+	HalfOpenCalls    int
+}
+
+// IntegrityConfig controls the scheduled verification of stored objects against their
+// checksums
+type IntegrityConfig struct {
+	// Interval verifies every image once per interval; 0 disables it
+	Interval  time.Duration
+	BatchSize int
+}
+
+// RetryConfig controls the automatic retry of images that failed after the worker ran out
+// of attempts, such as on storage timeouts
+type RetryConfig struct {
+	// Interval looks for failed images to retry once per interval; 0 disables it
+	Interval   time.Duration
+	MaxRetries int
+	// BaseDelay is the delay after the failure before the first retry, doubling per retry
+	// up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	BatchSize int
+	// BulkRate bounds the images per second queued again by POST /admin/requeue-failed
+	BulkRate float64
+}
+
+// BackpressureConfig controls the throttling of low priority uploads while the processing
+// pipeline is saturated
+type BackpressureConfig struct {
+	Enabled bool
+	// PollInterval is how often the queue depth and worker utilization are read
+	PollInterval time.Duration
+	// MaxQueueDepth throttles while more tasks are queued; 0 disables the check
+	MaxQueueDepth int
+	// MaxWorkerUtilization throttles while the images being processed take more than this
+	// percentage of the capacity of the consumers; 0 disables the check
+	MaxWorkerUtilization float64
+	// RetryAfter is announced in the Retry-After header of throttled uploads
+	RetryAfter time.Duration
+	// DefaultPriority is the priority of uploads that don't set one, low or normal
+	DefaultPriority string
+}
+
+// UsageConfig controls the metering of billable usage per API key owner
+type UsageConfig struct {
+	Enabled bool
+	// RollupInterval snapshots the stored bytes of every owner and rolls the daily usage
+	// up into monthly totals once per interval
+	RollupInterval time.Duration
+	// FlushInterval is how often the usage recorded from requests and tasks is written
+	// to the database
+	FlushInterval time.Duration
+}
+
+// ReplicationConfig controls the copy of optimized objects to a secondary MinIO/S3
+// target for disaster recovery
+type ReplicationConfig struct {
+	Enabled   bool
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	SSL       bool
+	Location  string
+	// Bucket on the target receives the optimized objects of every class bucket
+	Bucket string
+	// Interval is the delay between passes over the images not replicated yet
+	Interval  time.Duration
+	BatchSize int
+}
+
+// IngestConfig controls the ingestion daemon, which watches a directory, a drop prefix of
+// the bucket, or both
+type IngestConfig struct {
+	// Dir is the watched directory; ingested files are moved to ProcessedDir and
+	// invalid images to FailedDir, which default to subdirectories of Dir
+	Dir          string
+	ProcessedDir string
+	FailedDir    string
+	Recursive    bool
+	// SettleDelay is how long a file must be unchanged before it is ingested
+	SettleDelay time.Duration
+	// ScanInterval rescans Dir for files whose events were missed, e.g. on NFS
+	ScanInterval time.Duration
+	Concurrency  int
+	// BucketPrefix is the drop prefix whose new objects are ingested; invalid images are
+	// moved to BucketFailedPrefix
+	BucketPrefix       string
+	BucketFailedPrefix string
+}
+
+// VersionsConfig is the retention policy for older optimized versions of an image
+type VersionsConfig struct {
+	// Retain is how many versions are kept per image, including the current one; 0 keeps all
+	Retain int
+	// MaxAge deletes older versions after this long; 0 disables it
+	MaxAge time.Duration
+}
+
+// DeleteConfig controls how DELETE /images/{id} removes an image
+type DeleteConfig struct {
+	// Mode is immediate (delete on request), confirm (delete once the returned token is
+	// confirmed) or deferred (delete after GracePeriod, or earlier when confirmed)
+	Mode string
+	// GracePeriod is how long a pending deletion waits: in confirm mode the token expires
+	// and the image is kept, in deferred mode the image is purged
+	GracePeriod time.Duration
+	// PurgeInterval is how often expired pending deletions are processed
+	PurgeInterval time.Duration
+}
+
+// GCConfig controls the garbage collection of orphaned objects and dangling image records
+type GCConfig struct {
+	// Mode is report (only log and return what was found) or delete
+	Mode string
+	// Interval runs a pass every interval; 0 leaves it to POST /admin/gc
+	Interval time.Duration
+	// MinAge spares objects and images younger than this, which may belong to uploads or
+	// processing still in flight
+	MinAge time.Duration
+}
+
+// QualityConfig controls the perceptual quality check of optimized images
+type QualityConfig struct {
+	// MinScore is the lowest acceptable SSIM score; 0 only records the score
+	MinScore float64
+	// FallbackStep is how much the quality is raised per re-encode
+	FallbackStep int
+}
+
+// TransformTemplate describes a named, server-side transformation.
+type TransformTemplate struct {
+	MaxWidth  int
+	MaxHeight int
+	Quality   int
+	Filter    string
+	Sharpen   float64
 }
 
 // ConnectionString generates the connection string for PostgreSQL.
 func (c *DatabaseConfig) ConnectionString() string {
-	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=%s",
-		c.User, c.Password, c.Host, c.Port, c.DBName, c.SSLMode)
+	return fmt.Sprintf("postgres://%s@%s:%d/%s?sslmode=%s",
+		url.UserPassword(c.User, c.Password), c.Host, c.Port, c.DBName, c.SSLMode)
 }
 
 // RabbitMQURL generates the connection string for RabbitMQ.
 func (c *RabbitMQConfig) RabbitMQURL() string {
-	return fmt.Sprintf("amqp://%s:%s@%s:%d/",
-		c.User, c.Password, c.Host, c.Port)
+	return c.URLWith(c.User, c.Password)
+}
+
+// URLWith generates the connection string for RabbitMQ with the given credentials.
+func (c *RabbitMQConfig) URLWith(user, password string) string {
+	return fmt.Sprintf("amqp://%s@%s:%d/",
+		url.UserPassword(user, password), c.Host, c.Port)
+}
+
+// TargetMinIO derives the MinIO settings of the replication target from the primary ones:
+// every class of objects goes to Bucket, and encryption, naming and retries are kept.
+func (c *ReplicationConfig) TargetMinIO(primary MinIOConfig) *MinIOConfig {
+	target := primary
+	target.Endpoint, target.AccessKey, target.SecretKey = c.Endpoint, c.AccessKey, c.SecretKey
+	target.SSL, target.Location = c.SSL, c.Location
+	target.Bucket, target.OriginalsBucket, target.OptimizedBucket, target.TempBucket = c.Bucket, c.Bucket, c.Bucket, c.Bucket
+	target.PublicRead = false
+	target.Lifecycle = LifecycleConfig{}
+	target.Credentials = nil
+	return &target
 }
 
 // Load reads the application configuration from the .env file (if exists)
 // and from OS environment variables, applying default values if variables are not set.
 // In production, it is recommended to supply configuration via environment variables.
+// The configuration is validated, so invalid settings fail at startup.
+// The configuration file named by CONFIG_FILE is read as in LoadFile.
 func Load() (*Config, error) {
-	// Load the .env file into OS environment variables.
-	// If the file doesn't exist or there's an error, a warning is printed.
-	if err := godotenv.Load(".env"); err != nil {
+	return LoadFile(os.Getenv("CONFIG_FILE"))
+}
+
+// LoadFile is Load with the YAML or TOML configuration file at path, unless path is
+// empty. The file holds the configuration tree whose key paths, joined with
+// underscores, are the environment variables; the environment and .env override it.
+func LoadFile(path string) (*Config, error) {
+	envFileMu.Lock()
+	configFile = path
+	envFileMu.Unlock()
+
+	// Load the configuration file and the .env file into OS environment variables.
+	// If the .env file doesn't exist or there's an error, a warning is printed.
+	if err := loadEnvFile(); err != nil {
+		return nil, err
+	}
+
+	cfg := build()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.resolveCredentials(context.Background()); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Reload re-reads the configuration and .env files and returns the resulting
+// configuration. Variables set in the process environment keep precedence over the
+// files, as in Load, so only the ones taken from the files can change. An invalid
+// configuration is rejected.
+func Reload() (*Config, error) {
+	if err := loadEnvFile(); err != nil {
+		return nil, err
+	}
+
+	cfg := build()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// envFile is the dotenv file read by Load and Reload
+const envFile = ".env"
+
+var (
+	envFileMu sync.Mutex
+	// configFile is the configuration file given to LoadFile
+	configFile string
+	// envFileKeys are the variables set from envFile or configFile rather than by the
+	// process environment
+	envFileKeys = map[string]bool{}
+)
+
+// loadEnvFile sets the variables of configFile and envFile, which takes precedence,
+// that are not set by the process environment, and unsets the ones it set before that
+// were removed from the files
+func loadEnvFile() error {
+	envFileMu.Lock()
+	defer envFileMu.Unlock()
+
+	values := map[string]string{}
+	if configFile != "" {
+		fileValues, err := readConfigFile(configFile)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", configFile, err)
+		}
+		maps.Copy(values, fileValues)
+	}
+
+	envValues, err := godotenv.Read(envFile)
+	if err != nil && configFile == "" {
 		fmt.Println("Warning: .env file not found or error loading it; relying solely on OS environment variables and defaults")
 	}
+	maps.Copy(values, envValues)
+
+	for key := range envFileKeys {
+		if _, ok := values[key]; !ok {
+			os.Unsetenv(key)
+			delete(envFileKeys, key)
+		}
+	}
+	for key, value := range values {
+		if _, set := os.LookupEnv(key); set && !envFileKeys[key] {
+			continue
+		}
+		os.Setenv(key, value)
+		envFileKeys[key] = true
+	}
 
+	return nil
+}
+
+// build reads the configuration from the environment
+func build() *Config {
 	cfg := &Config{
 		Server: ServerConfig{
-			Host: getEnv("SERVER_HOST", "0.0.0.0"),
-			Port: getEnvAsInt("SERVER_PORT", 8080),
-			Mode: getEnv("GIN_MODE", "release"),
+			Host:            getEnv("SERVER_HOST", "0.0.0.0"),
+			Port:            getEnvAsInt("SERVER_PORT", 8080),
+			Mode:            getEnv("GIN_MODE", "release"),
+			LegacyAPISunset: getEnvAsDate("API_LEGACY_SUNSET"),
+			AdminToken:      getEnv("ADMIN_TOKEN", ""),
+			APIKeys:         getEnvAsPairs("API_KEYS"),
+			KeyLimits:       getEnvAsKeyLimits("API_KEY_LIMITS"),
+
+			MaxBodyBytes:      int64(getEnvAsInt("SERVER_MAX_BODY_BYTES", 11<<20)),
+			ReadHeaderTimeout: getEnvAsDuration("SERVER_READ_HEADER_TIMEOUT", 10*time.Second),
+			MinBodyRate:       int64(getEnvAsInt("SERVER_MIN_BODY_RATE", 1024)),
+			BodyReadGrace:     getEnvAsDuration("SERVER_BODY_READ_GRACE", 10*time.Second),
+			Timeouts: TimeoutConfig{
+				Read:   getEnvAsDuration("SERVER_TIMEOUT_READ", 10*time.Second),
+				Write:  getEnvAsDuration("SERVER_TIMEOUT_WRITE", 30*time.Second),
+				Upload: getEnvAsDuration("SERVER_TIMEOUT_UPLOAD", 5*time.Minute),
+				Stream: getEnvAsDuration("SERVER_TIMEOUT_STREAM", 10*time.Minute),
+			},
 		},
 		Database: DatabaseConfig{
 			Host:           getEnv("DATABASE_HOST", "localhost"),
@@ -129,15 +785,40 @@ func Load() (*Config, error) {
 			SSLMode:        getEnv("DATABASE_SSL_MODE", "disable"),
 			MaxConnections: getEnvAsInt("DATABASE_MAX_CONNECTIONS", 10),
 			MinConnections: getEnvAsInt("DATABASE_MIN_CONNECTIONS", 2),
+
+			MaxConnLifetime:   getEnvAsDuration("DATABASE_MAX_CONN_LIFETIME", time.Hour),
+			MaxConnIdleTime:   getEnvAsDuration("DATABASE_MAX_CONN_IDLE_TIME", 30*time.Minute),
+			HealthCheckPeriod: getEnvAsDuration("DATABASE_HEALTH_CHECK_PERIOD", time.Minute),
+			ConnectAttempts:   getEnvAsInt("DATABASE_CONNECT_ATTEMPTS", 10),
+			ConnectRetryDelay: getEnvAsDuration("DATABASE_CONNECT_RETRY_DELAY", time.Second),
 		},
 		MinIO: MinIOConfig{
-			Endpoint:  getEnv("MINIO_ENDPOINT", "localhost:9000"),
-			AccessKey: getEnv("MINIO_ACCESS_KEY", "minioadmin"),
-			SecretKey: getEnv("MINIO_SECRET_KEY", "minioadmin"),
-			Bucket:    getEnv("MINIO_BUCKET", "images"),
-			SSL:       getEnvAsBool("MINIO_SSL", false),
-			Location:  getEnv("MINIO_LOCATION", "us-east-1"),
-			URLExpiry: getEnvAsDuration("MINIO_URL_EXPIRY", 24*time.Hour),
+			Endpoint:         getEnv("MINIO_ENDPOINT", "localhost:9000"),
+			AccessKey:        getEnv("MINIO_ACCESS_KEY", "minioadmin"),
+			SecretKey:        getEnv("MINIO_SECRET_KEY", "minioadmin"),
+			Bucket:           getEnv("MINIO_BUCKET", "images"),
+			SSL:              getEnvAsBool("MINIO_SSL", false),
+			Location:         getEnv("MINIO_LOCATION", "us-east-1"),
+			URLExpiry:        getEnvAsDuration("MINIO_URL_EXPIRY", 24*time.Hour),
+			PrivateURLExpiry: getEnvAsDuration("MINIO_PRIVATE_URL_EXPIRY", 5*time.Minute),
+			PublicRead:       getEnvAsBool("MINIO_PUBLIC_READ", false),
+			OriginalsPrefix:  getEnv("MINIO_ORIGINALS_PREFIX", ""),
+			OptimizedPrefix:  getEnv("MINIO_OPTIMIZED_PREFIX", ""),
+			NamingStrategy:   getEnv("MINIO_NAMING_STRATEGY", "uuid"),
+			VariantNaming:    getEnv("MINIO_VARIANT_NAMING", "sized"),
+			SSE:              strings.ToLower(getEnv("MINIO_SSE", "")),
+			SSEKMSKeyID:      getEnv("MINIO_SSE_KMS_KEY_ID", ""),
+			SSECustomerKey:   getEnv("MINIO_SSE_C_KEY", ""),
+			VerifyChecksums:  getEnvAsBool("MINIO_VERIFY_CHECKSUMS", true),
+			RetryAttempts:    getEnvAsInt("MINIO_RETRY_MAX_ATTEMPTS", 3),
+			RetryBaseDelay:   getEnvAsDuration("MINIO_RETRY_BASE_DELAY", 200*time.Millisecond),
+			RetryMaxDelay:    getEnvAsDuration("MINIO_RETRY_MAX_DELAY", 5*time.Second),
+			Lifecycle: LifecycleConfig{
+				Managed:                 getEnvAsBool("LIFECYCLE_MANAGED", false),
+				OriginalsTransitionDays: getEnvAsInt("LIFECYCLE_ORIGINALS_TRANSITION_DAYS", 0),
+				OriginalsStorageClass:   getEnv("LIFECYCLE_ORIGINALS_STORAGE_CLASS", ""),
+				ExpirePrefixes:          getEnvAsPrefixDays("LIFECYCLE_EXPIRE_PREFIXES"),
+			},
 		},
 		RabbitMQ: RabbitMQConfig{
 			Host:        getEnv("RABBITMQ_HOST", "rabbitmq"),
@@ -148,45 +829,314 @@ func Load() (*Config, error) {
 			Exchange:    getEnv("RABBITMQ_EXCHANGE", "image_optimizer"),
 			RoutingKey:  getEnv("RABBITMQ_ROUTING_KEY", "image.resize"),
 			ConsumerTag: getEnv("RABBITMQ_CONSUMER_TAG", "image_worker"),
+			Prefetch:    getEnvAsInt("RABBITMQ_PREFETCH", 1),
+			TaskQueues:  getEnvAsTaskQueues("RABBITMQ_TASK_QUEUES"),
+			// Publishes wait for the broker, so a lost task falls back to the outbox
+			ConfirmTimeout: getEnvAsDuration("RABBITMQ_CONFIRM_TIMEOUT", 5*time.Second),
+			Serialization:  getEnv("QUEUE_SERIALIZATION", "json"),
 		},
 		Worker: WorkerConfig{
-			Count:       getEnvAsInt("WORKER_COUNT", 4),
-			MaxWorkers:  getEnvAsInt("MAX_WORKERS", 10),
-			MetricsPort: getEnvAsInt("WORKER_METRICS_PORT", 9091),
+			Count:                getEnvAsInt("WORKER_COUNT", 4),
+			MaxWorkers:           getEnvAsInt("MAX_WORKERS", 10),
+			MetricsPort:          getEnvAsInt("WORKER_METRICS_PORT", 9091),
+			RenditionConcurrency: getEnvAsInt("WORKER_RENDITION_CONCURRENCY", 4),
+			StallTimeout:         getEnvAsDuration("WORKER_STALL_TIMEOUT", 10*time.Minute),
+			ProfilerPort:         getEnvAsInt("WORKER_PROFILER_PORT", 6061),
+			ShutdownTimeout:      getEnvAsDuration("WORKER_SHUTDOWN_TIMEOUT", 30*time.Second),
+			MaxAttempts:          getEnvAsInt("WORKER_MAX_ATTEMPTS", 5),
+			RetryBaseDelay:       getEnvAsDuration("WORKER_RETRY_BASE_DELAY", 30*time.Second),
+			RetryMaxDelay:        getEnvAsDuration("WORKER_RETRY_MAX_DELAY", 30*time.Minute),
+			ID:                   getEnv("WORKER_ID", ""),
+			LeaseDuration:        getEnvAsDuration("WORKER_LEASE_DURATION", 2*time.Minute),
+			AutoTune:             getEnvAsBool("WORKER_AUTOTUNE", false),
+			AutoTuneInterval:     getEnvAsDuration("WORKER_AUTOTUNE_INTERVAL", 15*time.Second),
+			MemoryBudgetMB:       getEnvAsInt("WORKER_MEMORY_BUDGET_MB", 0),
 		},
 		Log: LogConfig{
-			Level:       getEnv("LOG_LEVEL", "info"),
-			Format:      getEnv("LOG_FORMAT", "json"),
-			ServiceName: getEnv("LOG_SERVICENAME", "image-optimizer"),
-			OutputJSON:  getEnvAsBool("LOG_JSON", true),
+			Level:             getEnv("LOG_LEVEL", "info"),
+			Format:            getEnv("LOG_FORMAT", "json"),
+			ServiceName:       getEnv("LOG_SERVICENAME", "image-optimizer"),
+			OutputJSON:        getEnvAsBool("LOG_JSON", true),
+			DebugSampleRate:   getEnvAsInt("LOG_DEBUG_SAMPLE_RATE", 0),
+			DebugSampleBurst:  getEnvAsInt("LOG_DEBUG_SAMPLE_BURST", 0),
+			File:              getEnv("LOG_FILE", ""),
+			FileMaxSizeMB:     getEnvAsInt("LOG_FILE_MAX_SIZE_MB", 100),
+			FileMaxBackups:    getEnvAsInt("LOG_FILE_MAX_BACKUPS", 5),
+			FileMaxAgeDays:    getEnvAsInt("LOG_FILE_MAX_AGE_DAYS", 30),
+			FileCompress:      getEnvAsBool("LOG_FILE_COMPRESS", true),
+			LokiURL:           getEnv("LOG_LOKI_URL", ""),
+			LokiBatchSize:     getEnvAsInt("LOG_LOKI_BATCH_SIZE", 500),
+			LokiFlushInterval: getEnvAsDuration("LOG_LOKI_FLUSH_INTERVAL", 2*time.Second),
 		},
 		Metrics: MetricsConfig{
-			Enabled: getEnvAsBool("METRICS_ENABLED", true),
-			Port:    getEnvAsInt("METRICS_PORT", 9090),
+			Enabled:              getEnvAsBool("METRICS_ENABLED", true),
+			Port:                 getEnvAsInt("METRICS_PORT", 9090),
+			StorageUsageInterval: getEnvAsDuration("METRICS_STORAGE_USAGE_INTERVAL", 5*time.Minute),
 		},
 		Tracing: TracingConfig{
 			Enabled:        getEnvAsBool("TRACING_ENABLED", true),
 			OTLPEndpoint:   getEnv("TRACING_OTLP_ENDPOINT", "otel-collector:4317"),
+			OTLPInsecure:   getEnvAsBool("TRACING_OTLP_INSECURE", true),
 			ServiceName:    getEnv("TRACING_SERVICE_NAME", "image-optimizer"),
 			ServiceVersion: getEnv("TRACING_SERVICE_VERSION", "1.0.0"),
 			Environment:    getEnv("TRACING_ENVIRONMENT", "dev"),
+			Sampler:        getEnv("TRACING_SAMPLER", "ratio"),
+			SampleRatio:    getEnvAsFloat("TRACING_SAMPLE_RATIO", 0.5),
+			RouteRatios:    getEnvAsRouteRatios("TRACING_ROUTE_RATIOS", "/livez:0,/readyz:0,/health:0"),
+		},
+		ErrorReport: ErrorReportConfig{
+			// Setting a DSN alone keeps enabling Sentry reporting
+			Enabled:  getEnvAsBool("ERROR_REPORTING_ENABLED", getEnv("SENTRY_DSN", "") != ""),
+			Provider: getEnv("ERROR_REPORTING_PROVIDER", "sentry"),
+			Sentry: SentryConfig{
+				DSN:         getEnv("SENTRY_DSN", ""),
+				Environment: getEnv("SENTRY_ENVIRONMENT", getEnv("TRACING_ENVIRONMENT", "dev")),
+				Release:     getEnv("SENTRY_RELEASE", getEnv("TRACING_SERVICE_VERSION", "1.0.0")),
+				Timeout:     getEnvAsDuration("SENTRY_TIMEOUT", 5*time.Second),
+			},
+		},
+		Vault: VaultConfig{
+			Address: getEnv("VAULT_ADDR", ""),
+			Token:   getEnv("VAULT_TOKEN", ""),
+			Mount:   getEnv("VAULT_KV_MOUNT", "secret"),
+			Path:    getEnv("VAULT_SECRET_PATH", "image-optimizer"),
+			Timeout: getEnvAsDuration("VAULT_TIMEOUT", 5*time.Second),
 		},
 		Observability: ObservabilityConfig{
-			MetricsEndpoint: getEnv("OBSERVABILITY_METRICS_ENDPOINT", "/metrics"),
-			TracingEndpoint: getEnv("OBSERVABILITY_TRACING_ENDPOINT", "/traces"),
-			ProfilerEnabled: getEnvAsBool("OBSERVABILITY_PROFILER_ENABLED", false),
+			MetricsEndpoint:     getEnv("OBSERVABILITY_METRICS_ENDPOINT", "/metrics"),
+			TracingEndpoint:     getEnv("OBSERVABILITY_TRACING_ENDPOINT", "/traces"),
+			ProfilerEnabled:     getEnvAsBool("OBSERVABILITY_PROFILER_ENABLED", false),
+			ProfilerHost:        getEnv("OBSERVABILITY_PROFILER_HOST", "127.0.0.1"),
+			ProfilerPort:        getEnvAsInt("OBSERVABILITY_PROFILER_PORT", 6060),
+			ProfilerToken:       getEnv("OBSERVABILITY_PROFILER_TOKEN", ""),
+			PyroscopeURL:        getEnv("PYROSCOPE_SERVER_ADDRESS", ""),
+			PyroscopeToken:      getEnv("PYROSCOPE_AUTH_TOKEN", ""),
+			OTLPMetrics:         getEnvAsBool("OBSERVABILITY_OTLP_METRICS", false),
+			OTLPMetricsEndpoint: getEnv("OBSERVABILITY_OTLP_METRICS_ENDPOINT", getEnv("TRACING_OTLP_ENDPOINT", "otel-collector:4317")),
+			OTLPMetricsInterval: getEnvAsDuration("OBSERVABILITY_OTLP_METRICS_INTERVAL", 30*time.Second),
+			OTLPMetricsInsecure: getEnvAsBool("OBSERVABILITY_OTLP_METRICS_INSECURE", getEnvAsBool("TRACING_OTLP_INSECURE", true)),
+		},
+		Transform: TransformConfig{
+			Enabled:    getEnvAsBool("TRANSFORM_ENABLED", false),
+			SigningKey: getEnv("TRANSFORM_SIGNING_KEY", ""),
+			URLExpiry:  getEnvAsDuration("TRANSFORM_URL_EXPIRY", time.Hour),
+			Templates:  getEnvAsTemplates("TRANSFORM_TEMPLATES", "thumbnail:150x150:80:lanczos:0.5,small:480x480:85,medium:1024x1024:85"),
+			MaxDPR:     getEnvAsFloat("TRANSFORM_MAX_DPR", 3),
+		},
+		UploadTokens: UploadTokenConfig{
+			Enabled:    getEnvAsBool("UPLOAD_TOKENS_ENABLED", false),
+			SigningKey: getEnv("UPLOAD_TOKEN_SIGNING_KEY", ""),
+			TTL:        getEnvAsDuration("UPLOAD_TOKEN_TTL", 15*time.Minute),
+			MaxTTL:     getEnvAsDuration("UPLOAD_TOKEN_MAX_TTL", time.Hour),
+		},
+		Cache: CacheConfig{
+			Enabled:    getEnvAsBool("CACHE_ENABLED", false),
+			TTL:        getEnvAsDuration("CACHE_TTL", 5*time.Second),
+			MaxEntries: getEnvAsInt("CACHE_MAX_ENTRIES", 10000),
+			HTTPMaxAge: getEnvAsDuration("CACHE_HTTP_MAX_AGE", 0),
+		},
+		CDN: CDNConfig{
+			PublicBaseURL:        getEnv("PUBLIC_BASE_URL", ""),
+			InvalidationProvider: getEnv("CDN_INVALIDATION_PROVIDER", "none"),
+			InvalidationURL:      getEnv("CDN_INVALIDATION_URL", ""),
+			InvalidationToken:    getEnv("CDN_INVALIDATION_TOKEN", ""),
+		},
+		Scan: ScanConfig{
+			Enabled:          getEnvAsBool("SCAN_ENABLED", false),
+			Provider:         getEnv("SCAN_PROVIDER", "clamd"),
+			Address:          getEnv("SCAN_CLAMD_ADDRESS", "clamav:3310"),
+			Timeout:          getEnvAsDuration("SCAN_TIMEOUT", 30*time.Second),
+			FailOpen:         getEnvAsBool("SCAN_FAIL_OPEN", false),
+			QuarantinePrefix: getEnv("SCAN_QUARANTINE_PREFIX", "quarantine/"),
+		},
+		Faults: FaultsConfig{
+			Storage: FaultRule{
+				ErrorRate: getEnvAsFloat("FAULTS_STORAGE_ERROR_RATE", 0),
+				Latency:   getEnvAsDuration("FAULTS_STORAGE_LATENCY", 0),
+			},
+			Database: FaultRule{
+				ErrorRate: getEnvAsFloat("FAULTS_DATABASE_ERROR_RATE", 0),
+				Latency:   getEnvAsDuration("FAULTS_DATABASE_LATENCY", 0),
+			},
+			Queue: FaultRule{
+				ErrorRate: getEnvAsFloat("FAULTS_QUEUE_ERROR_RATE", 0),
+				Latency:   getEnvAsDuration("FAULTS_QUEUE_LATENCY", 0),
+			},
+		},
+		SelfTest: SelfTestConfig{
+			Timeout: getEnvAsDuration("SELFTEST_TIMEOUT", time.Minute),
+		},
+		Moderation: ModerationConfig{
+			Enabled:   getEnvAsBool("MODERATION_ENABLED", false),
+			Provider:  getEnv("MODERATION_PROVIDER", "http"),
+			URL:       getEnv("MODERATION_URL", ""),
+			Token:     getEnv("MODERATION_TOKEN", ""),
+			Threshold: getEnvAsFloat("MODERATION_THRESHOLD", 0.8),
+			Timeout:   getEnvAsDuration("MODERATION_TIMEOUT", 10*time.Second),
+			Policy:    getEnv("MODERATION_POLICY", "quarantine"),
+		},
+		OCR: OCRConfig{
+			Enabled:       getEnvAsBool("OCR_ENABLED", false),
+			Provider:      getEnv("OCR_PROVIDER", "tesseract"),
+			TesseractPath: getEnv("OCR_TESSERACT_PATH", "tesseract"),
+			Language:      getEnv("OCR_LANGUAGE", "eng"),
+			URL:           getEnv("OCR_URL", ""),
+			Token:         getEnv("OCR_TOKEN", ""),
+			Timeout:       getEnvAsDuration("OCR_TIMEOUT", 60*time.Second),
+		},
+		FaceDetection: FaceDetectionConfig{
+			Enabled:  getEnvAsBool("FACE_DETECTION_ENABLED", false),
+			Provider: getEnv("FACE_DETECTION_PROVIDER", "pigo"),
+			URL:      getEnv("FACE_DETECTION_URL", ""),
+			Token:    getEnv("FACE_DETECTION_TOKEN", ""),
+			Timeout:  getEnvAsDuration("FACE_DETECTION_TIMEOUT", 10*time.Second),
+		},
+		Background: BackgroundRemovalConfig{
+			Enabled:  getEnvAsBool("BACKGROUND_REMOVAL_ENABLED", false),
+			Provider: getEnv("BACKGROUND_REMOVAL_PROVIDER", "http"),
+			URL:      getEnv("BACKGROUND_REMOVAL_URL", ""),
+			Token:    getEnv("BACKGROUND_REMOVAL_TOKEN", ""),
+			Format:   getEnv("BACKGROUND_REMOVAL_FORMAT", "png"),
+			Timeout:  getEnvAsDuration("BACKGROUND_REMOVAL_TIMEOUT", 120*time.Second),
+		},
+		Processing: ProcessingConfig{
+			DefaultMaxWidth:        getEnvAsInt("PROCESSING_DEFAULT_MAX_WIDTH", 1200),
+			DefaultMaxHeight:       getEnvAsInt("PROCESSING_DEFAULT_MAX_HEIGHT", 1200),
+			DefaultQuality:         getEnvAsInt("PROCESSING_DEFAULT_QUALITY", 85),
+			DefaultOptimizeStorage: getEnvAsBool("PROCESSING_DEFAULT_OPTIMIZE_STORAGE", true),
+			MaxTargets:             getEnvAsInt("PROCESSING_MAX_TARGETS", 8),
+			SyncMaxBytes:           int64(getEnvAsInt("PROCESSING_SYNC_MAX_BYTES", 1<<20)),
+			FormatQuality: map[string]int{
+				"jpeg": getEnvAsInt("PROCESSING_DEFAULT_QUALITY_JPEG", 0),
+				"png":  getEnvAsInt("PROCESSING_DEFAULT_QUALITY_PNG", 0),
+			},
+		},
+		Stats: StatsConfig{
+			MaterializedView: getEnvAsBool("STATS_MATERIALIZED_VIEW", false),
+			RefreshInterval:  getEnvAsDuration("STATS_REFRESH_INTERVAL", 15*time.Minute),
+		},
+		Outbox: OutboxConfig{
+			RelayInterval: getEnvAsDuration("OUTBOX_RELAY_INTERVAL", 10*time.Second),
+			BatchSize:     getEnvAsInt("OUTBOX_BATCH_SIZE", 50),
+			MaxBackoff:    getEnvAsDuration("OUTBOX_MAX_BACKOFF", 5*time.Minute),
+		},
+		Integrity: IntegrityConfig{
+			Interval:  getEnvAsDuration("INTEGRITY_VERIFY_INTERVAL", 0),
+			BatchSize: getEnvAsInt("INTEGRITY_BATCH_SIZE", 100),
+		},
+		Retry: RetryConfig{
+			Interval:   getEnvAsDuration("IMAGE_RETRY_INTERVAL", 0),
+			MaxRetries: getEnvAsInt("IMAGE_RETRY_MAX_RETRIES", 3),
+			BaseDelay:  getEnvAsDuration("IMAGE_RETRY_BASE_DELAY", 10*time.Minute),
+			MaxDelay:   getEnvAsDuration("IMAGE_RETRY_MAX_DELAY", 6*time.Hour),
+			BatchSize:  getEnvAsInt("IMAGE_RETRY_BATCH_SIZE", 50),
+			BulkRate:   getEnvAsFloat("IMAGE_RETRY_BULK_RATE", 20),
+		},
+		Backpressure: BackpressureConfig{
+			Enabled:              getEnvAsBool("BACKPRESSURE_ENABLED", false),
+			PollInterval:         getEnvAsDuration("BACKPRESSURE_POLL_INTERVAL", 10*time.Second),
+			MaxQueueDepth:        getEnvAsInt("BACKPRESSURE_MAX_QUEUE_DEPTH", 1000),
+			MaxWorkerUtilization: getEnvAsFloat("BACKPRESSURE_MAX_WORKER_UTILIZATION", 90),
+			RetryAfter:           getEnvAsDuration("BACKPRESSURE_RETRY_AFTER", 30*time.Second),
+			DefaultPriority:      getEnv("BACKPRESSURE_DEFAULT_PRIORITY", "normal"),
+		},
+		Usage: UsageConfig{
+			Enabled:        getEnvAsBool("USAGE_METERING_ENABLED", false),
+			RollupInterval: getEnvAsDuration("USAGE_ROLLUP_INTERVAL", time.Hour),
+			FlushInterval:  getEnvAsDuration("USAGE_FLUSH_INTERVAL", 10*time.Second),
+		},
+		Replication: ReplicationConfig{
+			Enabled:   getEnvAsBool("REPLICATION_ENABLED", false),
+			Endpoint:  getEnv("REPLICATION_ENDPOINT", ""),
+			AccessKey: getEnv("REPLICATION_ACCESS_KEY", ""),
+			SecretKey: getEnv("REPLICATION_SECRET_KEY", ""),
+			SSL:       getEnvAsBool("REPLICATION_SSL", false),
+			Location:  getEnv("REPLICATION_LOCATION", "us-east-1"),
+			Bucket:    getEnv("REPLICATION_BUCKET", "images-replica"),
+			Interval:  getEnvAsDuration("REPLICATION_INTERVAL", time.Minute),
+			BatchSize: getEnvAsInt("REPLICATION_BATCH_SIZE", 100),
+		},
+		Resilience: ResilienceConfig{
+			Enabled:          getEnvAsBool("RESILIENCE_ENABLED", true),
+			FailureThreshold: getEnvAsInt("CIRCUIT_BREAKER_FAILURE_THRESHOLD", 5),
+			OpenTimeout:      getEnvAsDuration("CIRCUIT_BREAKER_OPEN_TIMEOUT", 30*time.Second),
+			HalfOpenCalls:    getEnvAsInt("CIRCUIT_BREAKER_HALF_OPEN_CALLS", 1),
+		},
+		Quality: QualityConfig{
+			MinScore:     getEnvAsFloat("QUALITY_MIN_SSIM", 0),
+			FallbackStep: getEnvAsInt("QUALITY_FALLBACK_STEP", 5),
+		},
+		Delete: DeleteConfig{
+			Mode:          getEnv("DELETE_MODE", "immediate"),
+			GracePeriod:   getEnvAsDuration("DELETE_GRACE_PERIOD", 24*time.Hour),
+			PurgeInterval: getEnvAsDuration("DELETE_PURGE_INTERVAL", time.Minute),
+		},
+		GC: GCConfig{
+			Mode:     getEnv("GC_MODE", "report"),
+			Interval: getEnvAsDuration("GC_INTERVAL", 0),
+			MinAge:   getEnvAsDuration("GC_MIN_AGE", 24*time.Hour),
+		},
+		Versions: VersionsConfig{
+			Retain: getEnvAsInt("VERSIONS_RETAIN", 5),
+			MaxAge: getEnvAsDuration("VERSIONS_MAX_AGE", 0),
+		},
+		Ingest: IngestConfig{
+			Dir:          getEnv("INGEST_DIR", ""),
+			Recursive:    getEnvAsBool("INGEST_RECURSIVE", false),
+			SettleDelay:  getEnvAsDuration("INGEST_SETTLE_DELAY", 2*time.Second),
+			ScanInterval: getEnvAsDuration("INGEST_SCAN_INTERVAL", time.Minute),
+			Concurrency:  getEnvAsInt("INGEST_CONCURRENCY", 4),
+
+			BucketPrefix:       getEnv("INGEST_BUCKET_PREFIX", ""),
+			BucketFailedPrefix: getEnv("INGEST_BUCKET_FAILED_PREFIX", "failed/"),
 		},
 	}
 
-	return cfg, nil
+	if cfg.Ingest.Dir != "" {
+		cfg.Ingest.Dir = filepath.Clean(cfg.Ingest.Dir)
+		cfg.Ingest.ProcessedDir = filepath.Clean(getEnv("INGEST_PROCESSED_DIR", filepath.Join(cfg.Ingest.Dir, "processed")))
+		cfg.Ingest.FailedDir = filepath.Clean(getEnv("INGEST_FAILED_DIR", filepath.Join(cfg.Ingest.Dir, "failed")))
+	}
+
+	// Object classes are kept in the main bucket unless configured otherwise
+	cfg.MinIO.OriginalsBucket = getEnv("MINIO_ORIGINALS_BUCKET", cfg.MinIO.Bucket)
+	cfg.MinIO.OptimizedBucket = getEnv("MINIO_OPTIMIZED_BUCKET", cfg.MinIO.Bucket)
+	cfg.MinIO.TempBucket = getEnv("MINIO_TEMP_BUCKET", cfg.MinIO.Bucket)
+	cfg.MinIO.UploadQuarantinePrefix = getEnv("MINIO_UPLOAD_QUARANTINE_PREFIX", "")
+	cfg.MinIO.UploadQuarantineExpireDays = getEnvAsInt("MINIO_UPLOAD_QUARANTINE_EXPIRE_DAYS", 7)
+
+	// Originals never promoted out of the upload quarantine expire, unless a rule of
+	// LIFECYCLE_EXPIRE_PREFIXES already covers the prefix
+	if prefix := cfg.MinIO.UploadQuarantinePrefix; prefix != "" && cfg.MinIO.UploadQuarantineExpireDays > 0 {
+		if _, ok := cfg.MinIO.Lifecycle.ExpirePrefixes[prefix]; !ok {
+			if cfg.MinIO.Lifecycle.ExpirePrefixes == nil {
+				cfg.MinIO.Lifecycle.ExpirePrefixes = make(map[string]int)
+			}
+			cfg.MinIO.Lifecycle.ExpirePrefixes[prefix] = cfg.MinIO.UploadQuarantineExpireDays
+		}
+	}
+
+	cfg.Database.Credentials = cfg.Vault.credential("DATABASE_USER", "postgres", "DATABASE_PASSWORD", "postgres", "database_user", "database_password")
+	cfg.MinIO.Credentials = cfg.Vault.credential("MINIO_ACCESS_KEY", "minioadmin", "MINIO_SECRET_KEY", "minioadmin", "minio_access_key", "minio_secret_key")
+	cfg.RabbitMQ.Credentials = cfg.Vault.credential("RABBITMQ_USER", "guest", "RABBITMQ_PASSWORD", "guest", "rabbitmq_user", "rabbitmq_password")
+
+	return cfg
 }
 
-// getEnv returns the value of the environment variable key if it exists,
-// otherwise returns the defaultValue.
+// getEnv returns the value of the environment variable key if it exists, otherwise
+// the content of the file named by key_FILE (Docker and Kubernetes secrets) if that
+// exists, otherwise the defaultValue.
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists && value != "" {
 		return value
 	}
+	if path, exists := os.LookupEnv(key + "_FILE"); exists && path != "" {
+		value, err := readSecretFile(path)
+		if err == nil {
+			return value
+		}
+		fmt.Fprintf(os.Stderr, "Warning: error reading %s_FILE: %v\n", key, err)
+	}
 	return defaultValue
 }
 
@@ -210,6 +1160,16 @@ func getEnvAsBool(key string, defaultValue bool) bool {
 	return defaultValue
 }
 
+// getEnvAsFloat returns the value of the environment variable key as a float64,
+// or returns the defaultValue if conversion fails or the variable is not set.
+func getEnvAsFloat(key string, defaultValue float64) float64 {
+	valStr := getEnv(key, "")
+	if val, err := strconv.ParseFloat(valStr, 64); err == nil {
+		return val
+	}
+	return defaultValue
+}
+
 // getEnvAsDuration returns the value of the environment variable key as a time.Duration,
 // or returns the defaultValue if conversion fails or the variable is not set.
 func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
@@ -219,3 +1179,173 @@ func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
 	}
 	return defaultValue
 }
+
+// getEnvAsDate parses the environment variable key as a YYYY-MM-DD date.
+// The zero time is returned if the variable is not set or malformed.
+func getEnvAsDate(key string) time.Time {
+	date, err := time.Parse("2006-01-02", getEnv(key, ""))
+	if err != nil {
+		return time.Time{}
+	}
+	return date
+}
+
+// getEnvAsPairs parses a comma separated list of name:value pairs. Malformed entries are
+// skipped.
+func getEnvAsPairs(key string) map[string]string {
+	result := make(map[string]string)
+
+	for _, entry := range strings.Split(getEnv(key, ""), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || name == "" || value == "" {
+			continue
+		}
+		result[name] = value
+	}
+
+	return result
+}
+
+// getEnvAsKeyLimits parses a comma separated list of owner[:formats[:WxH[:operations]]]
+// entries, with formats and operations separated by "|", and any field but the owner left
+// empty or out for no limit. Malformed entries are kept with dimensions of -1 for validation
+// to reject, so a typo never lifts the limits of a key.
+func getEnvAsKeyLimits(key string) map[string]KeyLimits {
+	result := make(map[string]KeyLimits)
+
+	for _, entry := range strings.Split(getEnv(key, ""), ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if parts[0] == "" {
+			continue
+		}
+		parts = append(parts, make([]string, max(4-len(parts), 0))...)
+
+		var limits KeyLimits
+		if parts[1] != "" {
+			limits.Formats = strings.Split(parts[1], "|")
+		}
+		if parts[2] != "" {
+			_, err := fmt.Sscanf(parts[2], "%dx%d", &limits.MaxWidth, &limits.MaxHeight)
+			if err != nil || limits.MaxWidth <= 0 || limits.MaxHeight <= 0 {
+				limits.MaxWidth, limits.MaxHeight = -1, -1
+			}
+		}
+		if parts[3] != "" {
+			limits.Operations = strings.Split(parts[3], "|")
+		}
+		if len(parts) > 4 {
+			limits.MaxWidth, limits.MaxHeight = -1, -1
+		}
+
+		result[parts[0]] = limits
+	}
+
+	return result
+}
+
+// getEnvAsPrefixDays parses a comma separated list of prefix:days pairs. Malformed
+// entries are skipped.
+func getEnvAsPrefixDays(key string) map[string]int {
+	result := make(map[string]int)
+
+	for _, entry := range strings.Split(getEnv(key, ""), ",") {
+		prefix, daysStr, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || prefix == "" {
+			continue
+		}
+		days, err := strconv.Atoi(daysStr)
+		if err != nil || days <= 0 {
+			continue
+		}
+		result[prefix] = days
+	}
+
+	return result
+}
+
+// getEnvAsRouteRatios parses a comma separated list of route:ratio pairs, split at the last
+// colon since routes may have parameters. Malformed entries are kept with a ratio of -1
+// for validation to reject. The defaultValue is used if the variable is not set.
+func getEnvAsRouteRatios(key, defaultValue string) map[string]float64 {
+	result := make(map[string]float64)
+
+	for _, entry := range strings.Split(getEnv(key, defaultValue), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		route, ratioStr := entry, ""
+		if i := strings.LastIndex(entry, ":"); i >= 0 {
+			route, ratioStr = entry[:i], entry[i+1:]
+		}
+		ratio, err := strconv.ParseFloat(ratioStr, 64)
+		if err != nil {
+			ratio = -1
+		}
+		result[route] = ratio
+	}
+
+	return result
+}
+
+// getEnvAsTaskQueues parses a comma separated list of task_type:queue[:prefetch] entries,
+// with a prefetch of 1 by default. Malformed entries are skipped.
+func getEnvAsTaskQueues(key string) map[string]TaskQueue {
+	result := make(map[string]TaskQueue)
+
+	for _, entry := range strings.Split(getEnv(key, ""), ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			continue
+		}
+		queue := TaskQueue{Queue: parts[1], Prefetch: 1}
+		if len(parts) > 2 {
+			prefetch, err := strconv.Atoi(parts[2])
+			if err != nil || prefetch <= 0 {
+				continue
+			}
+			queue.Prefetch = prefetch
+		}
+		result[parts[0]] = queue
+	}
+
+	return result
+}
+
+// getEnvAsTemplates parses the environment variable key as a comma separated list of
+// transformation templates in the form name:WIDTHxHEIGHT:QUALITY[:FILTER[:SHARPEN]].
+// Malformed entries are skipped; the defaultValue is used if the variable is not set.
+func getEnvAsTemplates(key, defaultValue string) map[string]TransformTemplate {
+	templates := make(map[string]TransformTemplate)
+
+	for _, entry := range strings.Split(getEnv(key, defaultValue), ",") {
+		parts := strings.Split(strings.TrimSpace(entry), ":")
+		if len(parts) < 3 || len(parts) > 5 || parts[0] == "" {
+			continue
+		}
+
+		var tmpl TransformTemplate
+		if _, err := fmt.Sscanf(parts[1], "%dx%d", &tmpl.MaxWidth, &tmpl.MaxHeight); err != nil {
+			continue
+		}
+		quality, err := strconv.Atoi(parts[2])
+		if err != nil {
+			continue
+		}
+		tmpl.Quality = quality
+		if len(parts) > 3 {
+			tmpl.Filter = parts[3]
+		}
+		if len(parts) > 4 {
+			sharpen, err := strconv.ParseFloat(parts[4], 64)
+			if err != nil {
+				continue
+			}
+			tmpl.Sharpen = sharpen
+		}
+
+		templates[parts[0]] = tmpl
+	}
+
+	return templates
+}
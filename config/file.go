@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// readConfigFile reads a YAML or TOML configuration file into environment variables:
+// each key path is joined with underscores and upper cased, so
+//
+//	processing:
+//	  default:
+//	    quality: 85
+//
+// sets PROCESSING_DEFAULT_QUALITY=85. Lists are joined with commas.
+func readConfigFile(path string) (map[string]string, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml", ".toml":
+	default:
+		return nil, fmt.Errorf("unsupported configuration file type %q, use .yaml or .toml", ext)
+	}
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	for _, key := range v.AllKeys() {
+		name := strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+		values[name] = configValue(v.Get(key))
+	}
+	return values, nil
+}
+
+// configValue formats a configuration file value as an environment variable
+func configValue(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = configValue(item)
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,168 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultConfig locates the KV v2 secret holding the DB, MinIO and RabbitMQ credentials.
+// The secret may hold database_user, database_password, minio_access_key,
+// minio_secret_key, rabbitmq_user and rabbitmq_password; missing keys fall back to
+// the environment.
+type VaultConfig struct {
+	// Address enables Vault when set
+	Address string
+	Token   string
+	// Mount and Path locate the secret at <Address>/v1/<Mount>/data/<Path>
+	Mount   string
+	Path    string
+	Timeout time.Duration
+}
+
+// Credential is a user name and password that can be rotated. Clients call Invalidate
+// when the server rejects it; the next Get reads it again from the environment, its
+// _FILE secret or Vault.
+type Credential struct {
+	resolve func(ctx context.Context) (string, string, error)
+
+	mu       sync.Mutex
+	user     string
+	password string
+	stale    bool
+}
+
+// Get returns the current user name and password. If they can't be refreshed the
+// previous ones are returned along with the error.
+func (c *Credential) Get(ctx context.Context) (string, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.stale {
+		user, password, err := c.resolve(ctx)
+		if err != nil {
+			return c.user, c.password, fmt.Errorf("error refreshing credentials: %w", err)
+		}
+		c.user, c.password, c.stale = user, password, false
+	}
+
+	return c.user, c.password, nil
+}
+
+// Invalidate makes the next Get read the credentials again
+func (c *Credential) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stale = true
+}
+
+// Stale reports whether the credentials were invalidated and not read again yet
+func (c *Credential) Stale() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stale
+}
+
+// credential returns a Credential read from the environment variables userEnv and
+// passwordEnv (or their _FILE secrets), overridden by the Vault keys userKey and
+// passwordKey if Vault is enabled
+func (v *VaultConfig) credential(userEnv, userDefault, passwordEnv, passwordDefault, userKey, passwordKey string) *Credential {
+	return &Credential{
+		stale: true,
+		resolve: func(ctx context.Context) (string, string, error) {
+			user := getEnv(userEnv, userDefault)
+			password := getEnv(passwordEnv, passwordDefault)
+			if v.Address == "" {
+				return user, password, nil
+			}
+
+			secret, err := v.read(ctx)
+			if err != nil {
+				return "", "", err
+			}
+			if value, ok := secret[userKey]; ok {
+				user = value
+			}
+			if value, ok := secret[passwordKey]; ok {
+				password = value
+			}
+			return user, password, nil
+		},
+	}
+}
+
+// read fetches the latest version of the secret
+func (v *VaultConfig) read(ctx context.Context) (map[string]string, error) {
+	url := fmt.Sprintf("%s/v1/%s/data/%s", strings.TrimSuffix(v.Address, "/"), v.Mount, v.Path)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+
+	client := &http.Client{Timeout: v.Timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error reading Vault secret: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error reading Vault secret %s/%s: status %d", v.Mount, v.Path, resp.StatusCode)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]any `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("error decoding Vault secret: %w", err)
+	}
+
+	secret := make(map[string]string, len(body.Data.Data))
+	for key, value := range body.Data.Data {
+		if s, ok := value.(string); ok {
+			secret[key] = s
+		}
+	}
+	return secret, nil
+}
+
+// resolveCredentials reads the credentials of the DB, MinIO and RabbitMQ connections
+// into their User/Password fields
+func (c *Config) resolveCredentials(ctx context.Context) error {
+	var err error
+
+	c.Database.User, c.Database.Password, err = c.Database.Credentials.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("database credentials: %w", err)
+	}
+	c.MinIO.AccessKey, c.MinIO.SecretKey, err = c.MinIO.Credentials.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("MinIO credentials: %w", err)
+	}
+	c.RabbitMQ.User, c.RabbitMQ.Password, err = c.RabbitMQ.Credentials.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("RabbitMQ credentials: %w", err)
+	}
+
+	return nil
+}
+
+// readSecretFile reads a Docker or Kubernetes secret, without its trailing newline
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
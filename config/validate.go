@@ -0,0 +1,242 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ValidationError lists every invalid setting of a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e.Problems, "\n  ")
+}
+
+// Validate rejects configurations that can't work, such as ports out of range or an
+// empty bucket. All problems are reported at once, by environment variable, in a
+// *ValidationError.
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	v.port("SERVER_PORT", c.Server.Port)
+	v.port("DATABASE_PORT", c.Database.Port)
+	v.port("RABBITMQ_PORT", c.RabbitMQ.Port)
+	v.port("METRICS_PORT", c.Metrics.Port)
+	v.port("WORKER_METRICS_PORT", c.Worker.MetricsPort)
+	v.port("WORKER_PROFILER_PORT", c.Worker.ProfilerPort)
+	v.port("OBSERVABILITY_PROFILER_PORT", c.Observability.ProfilerPort)
+	v.check(c.Server.MaxBodyBytes > 0, "SERVER_MAX_BODY_BYTES must be positive, got %d", c.Server.MaxBodyBytes)
+	for owner, limits := range c.Server.KeyLimits {
+		_, ok := c.Server.APIKeys[owner]
+		v.check(ok, "API_KEY_LIMITS has limits for %q, which has no API key in API_KEYS", owner)
+		v.check(limits.MaxWidth >= 0 && limits.MaxHeight >= 0,
+			"API_KEY_LIMITS entries of %q must be owner:formats:WxH:operations with positive dimensions", owner)
+		for _, format := range limits.Formats {
+			v.oneOf("API_KEY_LIMITS formats of "+owner, format, "jpeg", "png")
+		}
+		for _, operation := range limits.Operations {
+			v.oneOf("API_KEY_LIMITS operations of "+owner, operation, "upload", "download", "reprocess", "delete", "export")
+		}
+	}
+
+	v.check(c.Database.MaxConnections > 0, "DATABASE_MAX_CONNECTIONS must be positive, got %d", c.Database.MaxConnections)
+	v.check(c.Database.MinConnections >= 0 && c.Database.MinConnections <= c.Database.MaxConnections,
+		"DATABASE_MIN_CONNECTIONS must be between 0 and DATABASE_MAX_CONNECTIONS (%d), got %d",
+		c.Database.MaxConnections, c.Database.MinConnections)
+
+	v.check(c.Database.ConnectAttempts > 0, "DATABASE_CONNECT_ATTEMPTS must be positive, got %d", c.Database.ConnectAttempts)
+
+	v.check(c.MinIO.Endpoint != "", "MINIO_ENDPOINT must not be empty")
+	v.check(c.MinIO.Bucket != "", "MINIO_BUCKET must not be empty")
+	// The public read policy covers every optimized object, and API keys allow private images
+	v.check(!c.MinIO.PublicRead || len(c.Server.APIKeys) == 0,
+		"MINIO_PUBLIC_READ can't be used with API_KEYS, it would expose the optimized objects of private images")
+	v.oneOf("MINIO_NAMING_STRATEGY", c.MinIO.NamingStrategy, "uuid", "date", "hash")
+	v.oneOf("MINIO_VARIANT_NAMING", c.MinIO.VariantNaming, "sized", "plain")
+	if c.MinIO.SSE != "" {
+		v.oneOf("MINIO_SSE", c.MinIO.SSE, "s3", "kms", "c")
+	}
+	v.check(c.MinIO.SSE != "kms" || c.MinIO.SSEKMSKeyID != "", "MINIO_SSE_KMS_KEY_ID is required when MINIO_SSE=kms")
+	if c.MinIO.SSE == "c" {
+		key, err := base64.StdEncoding.DecodeString(c.MinIO.SSECustomerKey)
+		v.check(err == nil && len(key) == 32, "MINIO_SSE_C_KEY must be a base64 encoded 32-byte key when MINIO_SSE=c")
+		v.check(c.MinIO.SSL, "MINIO_SSL is required when MINIO_SSE=c, keys are only sent over TLS")
+	}
+	v.check(c.MinIO.RetryAttempts > 0, "MINIO_RETRY_MAX_ATTEMPTS must be positive, got %d", c.MinIO.RetryAttempts)
+	v.check(c.MinIO.RetryBaseDelay <= c.MinIO.RetryMaxDelay,
+		"MINIO_RETRY_BASE_DELAY (%s) must not exceed MINIO_RETRY_MAX_DELAY (%s)", c.MinIO.RetryBaseDelay, c.MinIO.RetryMaxDelay)
+	v.check(c.RabbitMQ.Queue != "", "RABBITMQ_QUEUE must not be empty")
+	v.check(c.RabbitMQ.Prefetch > 0, "RABBITMQ_PREFETCH must be positive, got %d", c.RabbitMQ.Prefetch)
+	v.check(c.RabbitMQ.ConfirmTimeout >= 0, "RABBITMQ_CONFIRM_TIMEOUT must not be negative, got %s", c.RabbitMQ.ConfirmTimeout)
+	v.oneOf("QUEUE_SERIALIZATION", c.RabbitMQ.Serialization, "json", "protobuf")
+	for taskType, queue := range c.RabbitMQ.TaskQueues {
+		v.check(queue.Queue != c.RabbitMQ.Queue, "RABBITMQ_TASK_QUEUES routes %s to the default queue %s", taskType, queue.Queue)
+	}
+
+	if c.Resilience.Enabled {
+		v.check(c.Resilience.FailureThreshold > 0, "CIRCUIT_BREAKER_FAILURE_THRESHOLD must be positive, got %d", c.Resilience.FailureThreshold)
+		v.check(c.Resilience.HalfOpenCalls > 0, "CIRCUIT_BREAKER_HALF_OPEN_CALLS must be positive, got %d", c.Resilience.HalfOpenCalls)
+	}
+
+	v.check(c.Integrity.Interval == 0 || c.Integrity.BatchSize > 0, "INTEGRITY_BATCH_SIZE must be positive, got %d", c.Integrity.BatchSize)
+
+	if c.Retry.Interval > 0 {
+		v.check(c.Retry.MaxRetries > 0, "IMAGE_RETRY_MAX_RETRIES must be positive, got %d", c.Retry.MaxRetries)
+		v.check(c.Retry.BaseDelay > 0, "IMAGE_RETRY_BASE_DELAY must be positive, got %s", c.Retry.BaseDelay)
+		v.check(c.Retry.BaseDelay <= c.Retry.MaxDelay,
+			"IMAGE_RETRY_BASE_DELAY (%s) must not exceed IMAGE_RETRY_MAX_DELAY (%s)", c.Retry.BaseDelay, c.Retry.MaxDelay)
+	}
+	v.check(c.Retry.BatchSize > 0, "IMAGE_RETRY_BATCH_SIZE must be positive, got %d", c.Retry.BatchSize)
+	v.check(c.Retry.BulkRate > 0, "IMAGE_RETRY_BULK_RATE must be positive, got %g", c.Retry.BulkRate)
+
+	if c.Backpressure.Enabled {
+		v.check(c.Backpressure.PollInterval > 0, "BACKPRESSURE_POLL_INTERVAL must be positive, got %s", c.Backpressure.PollInterval)
+		v.check(c.Backpressure.MaxQueueDepth >= 0, "BACKPRESSURE_MAX_QUEUE_DEPTH must not be negative, got %d", c.Backpressure.MaxQueueDepth)
+		v.check(c.Backpressure.MaxWorkerUtilization >= 0 && c.Backpressure.MaxWorkerUtilization <= 100,
+			"BACKPRESSURE_MAX_WORKER_UTILIZATION must be between 0 and 100, got %g", c.Backpressure.MaxWorkerUtilization)
+		v.check(c.Backpressure.RetryAfter >= time.Second, "BACKPRESSURE_RETRY_AFTER must be at least 1s, got %s", c.Backpressure.RetryAfter)
+		v.oneOf("BACKPRESSURE_DEFAULT_PRIORITY", c.Backpressure.DefaultPriority, "low", "normal")
+	}
+
+	if c.FaceDetection.Enabled {
+		v.oneOf("FACE_DETECTION_PROVIDER", c.FaceDetection.Provider, "pigo", "http")
+		v.check(c.FaceDetection.Provider != "http" || c.FaceDetection.URL != "", "FACE_DETECTION_URL is required by the http face detection provider")
+	}
+
+	if c.Scan.Enabled {
+		v.oneOf("SCAN_PROVIDER", c.Scan.Provider, "clamd")
+	}
+
+	v.check(!c.Usage.Enabled || c.Usage.RollupInterval > 0, "USAGE_ROLLUP_INTERVAL must be positive, got %s", c.Usage.RollupInterval)
+	v.check(!c.Usage.Enabled || c.Usage.FlushInterval > 0, "USAGE_FLUSH_INTERVAL must be positive, got %s", c.Usage.FlushInterval)
+
+	if c.Replication.Enabled {
+		v.check(c.Replication.Endpoint != "", "REPLICATION_ENDPOINT is required when replication is enabled")
+		v.check(c.Replication.Bucket != "", "REPLICATION_BUCKET is required when replication is enabled")
+		v.check(c.Replication.Interval > 0, "REPLICATION_INTERVAL must be positive, got %s", c.Replication.Interval)
+		v.check(c.Replication.BatchSize > 0, "REPLICATION_BATCH_SIZE must be positive, got %d", c.Replication.BatchSize)
+	}
+
+	v.check(c.Worker.MaxWorkers > 0, "MAX_WORKERS must be positive, got %d", c.Worker.MaxWorkers)
+	v.check(c.Worker.RenditionConcurrency > 0, "WORKER_RENDITION_CONCURRENCY must be positive, got %d", c.Worker.RenditionConcurrency)
+	v.check(c.Worker.ShutdownTimeout > 0, "WORKER_SHUTDOWN_TIMEOUT must be positive, got %s", c.Worker.ShutdownTimeout)
+	v.check(c.Worker.MaxAttempts > 0, "WORKER_MAX_ATTEMPTS must be positive, got %d", c.Worker.MaxAttempts)
+	v.check(c.Worker.RetryBaseDelay > 0, "WORKER_RETRY_BASE_DELAY must be positive, got %s", c.Worker.RetryBaseDelay)
+	if c.Worker.AutoTune {
+		v.check(c.Worker.AutoTuneInterval > 0, "WORKER_AUTOTUNE_INTERVAL must be positive, got %s", c.Worker.AutoTuneInterval)
+		v.check(c.Worker.MemoryBudgetMB >= 0, "WORKER_MEMORY_BUDGET_MB must not be negative, got %d", c.Worker.MemoryBudgetMB)
+		// the prefetch is set once per channel, so it caps the concurrency the tuner can reach
+		v.check(c.RabbitMQ.Prefetch >= c.Worker.MaxWorkers,
+			"RABBITMQ_PREFETCH (%d) must be at least MAX_WORKERS (%d) with WORKER_AUTOTUNE", c.RabbitMQ.Prefetch, c.Worker.MaxWorkers)
+	}
+	v.check(c.Worker.LeaseDuration >= 3*time.Second, "WORKER_LEASE_DURATION must be at least 3s, got %s", c.Worker.LeaseDuration)
+	v.check(c.Worker.RetryBaseDelay <= c.Worker.RetryMaxDelay,
+		"WORKER_RETRY_BASE_DELAY (%s) must not exceed WORKER_RETRY_MAX_DELAY (%s)", c.Worker.RetryBaseDelay, c.Worker.RetryMaxDelay)
+
+	if c.Tracing.Enabled {
+		v.oneOf("TRACING_SAMPLER", c.Tracing.Sampler, "always_on", "always_off", "ratio", "tail")
+		v.check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1,
+			"TRACING_SAMPLE_RATIO must be between 0 and 1, got %g", c.Tracing.SampleRatio)
+		for route, ratio := range c.Tracing.RouteRatios {
+			v.check(strings.HasPrefix(route, "/") && ratio >= 0 && ratio <= 1,
+				"TRACING_ROUTE_RATIOS entries must be /route:ratio with a ratio between 0 and 1, got %q", route)
+		}
+	}
+
+	v.oneOf("LOG_LEVEL", strings.ToLower(c.Log.Level), "debug", "info", "warn", "error", "fatal", "panic")
+	v.check(c.Log.DebugSampleRate >= 0, "LOG_DEBUG_SAMPLE_RATE must not be negative, got %d", c.Log.DebugSampleRate)
+	v.check(c.Log.DebugSampleBurst >= 0, "LOG_DEBUG_SAMPLE_BURST must not be negative, got %d", c.Log.DebugSampleBurst)
+	if c.Log.File != "" {
+		v.check(c.Log.FileMaxSizeMB > 0, "LOG_FILE_MAX_SIZE_MB must be positive, got %d", c.Log.FileMaxSizeMB)
+		v.check(c.Log.FileMaxBackups >= 0, "LOG_FILE_MAX_BACKUPS must not be negative, got %d", c.Log.FileMaxBackups)
+		v.check(c.Log.FileMaxAgeDays >= 0, "LOG_FILE_MAX_AGE_DAYS must not be negative, got %d", c.Log.FileMaxAgeDays)
+	}
+	if c.Log.LokiURL != "" {
+		lokiURL, err := url.Parse(c.Log.LokiURL)
+		v.check(err == nil && (lokiURL.Scheme == "http" || lokiURL.Scheme == "https") && lokiURL.Host != "",
+			"LOG_LOKI_URL must be an http or https URL, got %q", c.Log.LokiURL)
+		v.check(c.Log.LokiBatchSize > 0, "LOG_LOKI_BATCH_SIZE must be positive, got %d", c.Log.LokiBatchSize)
+		v.check(c.Log.LokiFlushInterval > 0, "LOG_LOKI_FLUSH_INTERVAL must be positive, got %s", c.Log.LokiFlushInterval)
+	}
+
+	v.check(c.Processing.DefaultMaxWidth > 0, "PROCESSING_DEFAULT_MAX_WIDTH must be positive, got %d", c.Processing.DefaultMaxWidth)
+	v.check(c.Processing.DefaultMaxHeight > 0, "PROCESSING_DEFAULT_MAX_HEIGHT must be positive, got %d", c.Processing.DefaultMaxHeight)
+	v.check(c.Processing.MaxTargets > 0, "PROCESSING_MAX_TARGETS must be positive, got %d", c.Processing.MaxTargets)
+	v.check(c.Processing.SyncMaxBytes >= 0, "PROCESSING_SYNC_MAX_BYTES must not be negative, got %d", c.Processing.SyncMaxBytes)
+	v.check(c.Processing.DefaultQuality >= 1 && c.Processing.DefaultQuality <= 100,
+		"PROCESSING_DEFAULT_QUALITY must be between 1 and 100, got %d", c.Processing.DefaultQuality)
+	formats := make([]string, 0, len(c.Processing.FormatQuality))
+	for format := range c.Processing.FormatQuality {
+		formats = append(formats, format)
+	}
+	sort.Strings(formats)
+	for _, format := range formats {
+		quality := c.Processing.FormatQuality[format]
+		// 0 falls back to PROCESSING_DEFAULT_QUALITY
+		v.check(quality >= 0 && quality <= 100,
+			"PROCESSING_DEFAULT_QUALITY_%s must be between 0 and 100, got %d", strings.ToUpper(format), quality)
+	}
+	v.check(c.Quality.MinScore >= 0 && c.Quality.MinScore <= 1, "QUALITY_MIN_SSIM must be between 0 and 1, got %g", c.Quality.MinScore)
+
+	v.check(!c.Transform.Enabled || c.Transform.SigningKey != "", "TRANSFORM_SIGNING_KEY is required when TRANSFORM_ENABLED is set")
+	if c.UploadTokens.Enabled {
+		v.check(c.UploadTokens.SigningKey != "", "UPLOAD_TOKEN_SIGNING_KEY is required when UPLOAD_TOKENS_ENABLED is set")
+		v.check(c.UploadTokens.TTL > 0, "UPLOAD_TOKEN_TTL must be positive, got %s", c.UploadTokens.TTL)
+		v.check(c.UploadTokens.TTL <= c.UploadTokens.MaxTTL,
+			"UPLOAD_TOKEN_TTL (%s) must not exceed UPLOAD_TOKEN_MAX_TTL (%s)", c.UploadTokens.TTL, c.UploadTokens.MaxTTL)
+	}
+	v.check(c.Transform.MaxDPR >= 1, "TRANSFORM_MAX_DPR must be at least 1, got %g", c.Transform.MaxDPR)
+	v.oneOf("DELETE_MODE", c.Delete.Mode, "immediate", "confirm", "deferred")
+	v.oneOf("GC_MODE", c.GC.Mode, "report", "delete")
+	v.check(c.MinIO.UploadQuarantineExpireDays >= 0, "MINIO_UPLOAD_QUARANTINE_EXPIRE_DAYS must not be negative, got %d", c.MinIO.UploadQuarantineExpireDays)
+	v.check(c.GC.MinAge > 0, "GC_MIN_AGE must be positive, got %s", c.GC.MinAge)
+	v.check(c.SelfTest.Timeout > 0, "SELFTEST_TIMEOUT must be positive, got %s", c.SelfTest.Timeout)
+	v.check(!c.Scan.Enabled || c.Scan.Address != "", "SCAN_CLAMD_ADDRESS is required when SCAN_ENABLED is set")
+	v.check(c.Vault.Address == "" || c.Vault.Token != "", "VAULT_TOKEN is required when VAULT_ADDR is set")
+	v.check(!c.ErrorReport.Enabled || c.ErrorReport.Provider != "sentry" || c.ErrorReport.Sentry.DSN != "",
+		"SENTRY_DSN is required when ERROR_REPORTING_ENABLED is set")
+
+	v.fault("STORAGE", c.Faults.Storage)
+	v.fault("DATABASE", c.Faults.Database)
+	v.fault("QUEUE", c.Faults.Queue)
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// validator collects the problems found by Validate
+type validator struct {
+	problems []string
+}
+
+func (v *validator) check(ok bool, format string, args ...any) {
+	if !ok {
+		v.problems = append(v.problems, fmt.Sprintf(format, args...))
+	}
+}
+
+func (v *validator) port(key string, port int) {
+	v.check(port >= 1 && port <= 65535, "%s must be between 1 and 65535, got %d", key, port)
+}
+
+func (v *validator) fault(dependency string, rule FaultRule) {
+	v.check(rule.ErrorRate >= 0 && rule.ErrorRate <= 1, "FAULTS_%s_ERROR_RATE must be between 0 and 1, got %g", dependency, rule.ErrorRate)
+	v.check(rule.Latency >= 0, "FAULTS_%s_LATENCY must not be negative, got %s", dependency, rule.Latency)
+}
+
+func (v *validator) oneOf(key, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.problems = append(v.problems, fmt.Sprintf("%s must be one of %s, got %q", key, strings.Join(allowed, ", "), value))
+}
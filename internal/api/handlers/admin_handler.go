@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/api/validation"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+	"github.com/not-nullexception/image-optimizer/internal/gc"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+	"github.com/not-nullexception/image-optimizer/internal/reload"
+	"github.com/not-nullexception/image-optimizer/internal/retry"
+	"github.com/not-nullexception/image-optimizer/internal/selftest"
+)
+
+// AdminHandler serves operational endpoints behind the admin token
+type AdminHandler struct {
+	minioClient minio.Client
+	reloader    *reload.Reloader
+	collector   *gc.Collector
+	gcMode      string
+	selfTest    *selftest.Runner
+	retrier     *retry.Retrier
+}
+
+func NewAdminHandler(minioClient minio.Client, reloader *reload.Reloader, collector *gc.Collector, gcMode string, selfTest *selftest.Runner, retrier *retry.Retrier) *AdminHandler {
+	return &AdminHandler{
+		minioClient: minioClient,
+		reloader:    reloader,
+		collector:   collector,
+		gcMode:      gcMode,
+		selfTest:    selfTest,
+		retrier:     retrier,
+	}
+}
+
+// RunSelfTest runs a test image through the pipeline and returns the latency of each
+// stage, with 503 if the test failed
+func (h *AdminHandler) RunSelfTest(c *gin.Context) {
+	report, err := h.selfTest.Run(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, selftest.ErrRunning) {
+			apierror.Abort(c, apierror.ErrSelfTestRunning)
+			return
+		}
+		apierror.Abort(c, apierror.Internal("Failed to run self-test", err))
+		return
+	}
+
+	code := http.StatusOK
+	if !report.Passed {
+		code = http.StatusServiceUnavailable
+	}
+	c.JSON(code, report)
+}
+
+// CollectGarbage runs a garbage collection pass and returns its report. The pass runs in
+// the configured mode; dry_run=true only reports.
+func (h *AdminHandler) CollectGarbage(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	mode := h.gcMode
+	if c.Query("dry_run") == "true" {
+		mode = gc.ModeReport
+	}
+
+	report, err := h.collector.Collect(c.Request.Context(), mode)
+	if err != nil {
+		if errors.Is(err, gc.ErrRunning) {
+			apierror.Abort(c, apierror.ErrGCRunning)
+			return
+		}
+		reqLogger.Error().Err(err).Msg("Failed to collect garbage")
+		apierror.Abort(c, apierror.Internal("Failed to collect garbage", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// RequeueFailed queues the failed images matching the filter again, at the bulk retry
+// rate, and returns how many were requeued. dry_run=true only counts them.
+func (h *AdminHandler) RequeueFailed(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	var req RequeueFailedRequest
+	if !validation.Query(c, &req) {
+		return
+	}
+	filter := retry.BulkFilter{ErrorLike: req.ErrorLike, Limit: req.Limit, DryRun: req.DryRun}
+	if req.Since > 0 {
+		filter.Since = time.Now().Add(-req.Since)
+	}
+
+	reqLogger.Info().Dur("since", req.Since).Str("error_like", req.ErrorLike).Int("limit", req.Limit).Bool("dry_run", req.DryRun).Msg("Processing requeue failed images request")
+
+	report, err := h.retrier.RequeueFailed(c.Request.Context(), filter)
+	if err != nil {
+		if errors.Is(err, retry.ErrBulkRunning) {
+			apierror.Abort(c, apierror.ErrRequeueRunning)
+			return
+		}
+		reqLogger.Error().Err(err).Msg("Failed to requeue failed images")
+		apierror.Abort(c, apierror.FromRepository(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// ReloadConfig re-reads the configuration, as SIGHUP does, and returns the reloadable
+// settings now in effect
+func (h *AdminHandler) ReloadConfig(c *gin.Context) {
+	cfg, err := h.reloader.Reload()
+	if err != nil {
+		apierror.Abort(c, apierror.Internal("Failed to reload configuration", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "reloaded",
+		"log_level": cfg.Log.Level,
+		"processing": gin.H{
+			"max_width":        cfg.Processing.DefaultMaxWidth,
+			"max_height":       cfg.Processing.DefaultMaxHeight,
+			"quality":          cfg.Processing.DefaultQuality,
+			"format_quality":   cfg.Processing.FormatQuality,
+			"optimize_storage": cfg.Processing.DefaultOptimizeStorage,
+		},
+	})
+}
+
+// GetLogLevel returns the log level in effect and whether it overrides the configuration
+func (h *AdminHandler) GetLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, logger.Level())
+}
+
+// SetLogLevel overrides the log level until the API restarts. Configuration reloads do
+// not undo it.
+func (h *AdminHandler) SetLogLevel(c *gin.Context) {
+	var req LogLevelRequest
+	if !validation.Query(c, &req) {
+		return
+	}
+
+	c.JSON(http.StatusOK, logger.OverrideLevel(req.Level))
+}
+
+// ResetLogLevel drops the log level override and returns to the configured level
+func (h *AdminHandler) ResetLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, logger.ClearLevelOverride())
+}
+
+// GetLifecycle returns the lifecycle rules applied to the bucket
+func (h *AdminHandler) GetLifecycle(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	rules, err := h.minioClient.Lifecycle(c.Request.Context())
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to get bucket lifecycle")
+		apierror.Abort(c, apierror.FromStorage(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/internal/api/validation"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+	"github.com/not-nullexception/image-optimizer/internal/auth"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+)
+
+// maxArchiveImages bounds the images of an archive, whether listed or matched by a filter
+const maxArchiveImages = 1000
+
+// ArchiveImages streams a ZIP archive of the optimized or original objects of the listed
+// images, or of every image matching the filter. Entries are written as the objects are
+// read, so the archive is never held in memory. Images without the variant, such as those
+// still processing, and images withheld by moderation are left out.
+func (h *ImageHandler) ArchiveImages(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	var req ArchiveImagesRequest
+	if !validation.JSON(c, &req) {
+		return
+	}
+	if req.Variant == "" {
+		req.Variant = "optimized"
+	}
+	filter := models.ImageFilter{
+		Query:  req.Query,
+		Viewer: auth.Owner(c.Request.Context()),
+		Status: models.ProcessingStatus(req.Status),
+	}
+
+	reqLogger.Info().Int("ids", len(req.IDs)).Str("query", filter.Query).Str("status", req.Status).Str("variant", req.Variant).Msg("Processing archive images request")
+
+	// Listed images are loaded up front, so a missing one is reported before the archive starts
+	var images []*models.Image
+	if len(req.IDs) > 0 {
+		seen := make(map[uuid.UUID]bool, len(req.IDs))
+		for _, id := range req.IDs {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			img, ok := h.loadImage(c, id)
+			if !ok {
+				return
+			}
+			images = append(images, img)
+		}
+	} else {
+		_, total, err := h.repo.ListImages(c.Request.Context(), filter, 1, 0)
+		if err != nil {
+			reqLogger.Error().Err(err).Msg("Failed to count images to archive")
+			apierror.Abort(c, apierror.FromRepository(err))
+			return
+		}
+		if total > maxArchiveImages {
+			validation.Fail(c, "q", fmt.Sprintf("the filter matches %d images, at most %d can be archived", total, maxArchiveImages))
+			return
+		}
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="images-%s.zip"`, time.Now().Format("20060102-150405")))
+	c.Status(http.StatusOK)
+
+	archive := &imageArchive{h: h, c: c, zw: zip.NewWriter(c.Writer), variant: req.Variant, names: make(map[string]int)}
+	var err error
+	if len(req.IDs) > 0 {
+		for _, img := range images {
+			if err = archive.Add(img); err != nil {
+				break
+			}
+		}
+	} else {
+		err = h.repo.IterateImages(c.Request.Context(), filter, exportBatchSize, archive.Add)
+	}
+	if err == nil {
+		err = archive.zw.Close()
+	}
+	if err != nil {
+		// The status line is already sent, so the client only sees a truncated archive
+		reqLogger.Error().Err(err).Int("archived", archive.count).Msg("Failed to archive images")
+		c.Abort()
+		return
+	}
+
+	reqLogger.Info().Int("archived", archive.count).Int("skipped", archive.skipped).Msg("Images archived successfully")
+}
+
+// imageArchive writes the objects of images as entries of a ZIP archive
+type imageArchive struct {
+	h       *ImageHandler
+	c       *gin.Context
+	zw      *zip.Writer
+	variant string
+	// names counts the entries named after each name, to keep names unique
+	names   map[string]int
+	count   int
+	skipped int
+}
+
+// Add writes the object of img as the next entry
+func (a *imageArchive) Add(img *models.Image) error {
+	reqLogger := logger.FromContext(a.c.Request.Context())
+
+	objectName, storage := img.OriginalPath, a.h.minioClient.In(minio.ClassOriginal)
+	if a.variant == "optimized" {
+		objectName, storage = img.OptimizedPath, a.h.minioClient.In(minio.ClassOptimized)
+		if img.Status != models.StatusCompleted {
+			objectName = ""
+		}
+	}
+	if objectName == "" || img.Withheld() {
+		reqLogger.Debug().Str("image_id", img.ID.String()).Msg("Leaving image out of archive")
+		a.skipped++
+		return nil
+	}
+
+	object, err := storage.GetImage(a.c.Request.Context(), objectName)
+	if err != nil {
+		return fmt.Errorf("error getting %s from storage: %w", objectName, err)
+	}
+	defer object.Close()
+
+	// Images are already compressed, so entries are stored as they are
+	entry, err := a.zw.CreateHeader(&zip.FileHeader{
+		Name:     a.entryName(img),
+		Method:   zip.Store,
+		Modified: img.UpdatedAt,
+	})
+	if err != nil {
+		return fmt.Errorf("error creating archive entry: %w", err)
+	}
+	if _, err := io.Copy(entry, object); err != nil {
+		a.h.checkStreamed(a.c.Request.Context(), img, err)
+		return fmt.Errorf("error archiving %s: %w", objectName, err)
+	}
+	if err := a.zw.Flush(); err != nil {
+		return fmt.Errorf("error flushing archive: %w", err)
+	}
+	a.c.Writer.Flush()
+
+	a.count++
+	return nil
+}
+
+// entryName returns a unique name for the entry of img: its original name without
+// directories, numbered as "name (2).jpg" when it was already used
+func (a *imageArchive) entryName(img *models.Image) string {
+	name := path.Base(strings.ReplaceAll(img.OriginalName, `\`, "/"))
+	if name == "." || name == "/" {
+		name = withFormatExt(img.ID.String(), img.OriginalFormat)
+	}
+
+	unique := name
+	for n := a.names[name] + 1; a.names[unique] > 0; n++ {
+		ext := path.Ext(name)
+		unique = strings.TrimSuffix(name, ext) + " (" + strconv.Itoa(n) + ")" + ext
+	}
+	a.names[name]++
+	a.names[unique]++
+	return unique
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/api/validation"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+)
+
+// CompareImage compares the optimized image to its original, for checking optimization
+// settings. It returns the URLs of both with their SSIM and the share of changed pixels,
+// or with composite=true a JPEG of both side by side, original on the left.
+func (h *ImageHandler) CompareImage(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	id, ok := bindImageID(c)
+	if !ok {
+		return
+	}
+	idStr := id.String()
+
+	var req CompareImageRequest
+	if !validation.Query(c, &req) {
+		return
+	}
+
+	img, ok := h.loadImage(c, id)
+	if !ok {
+		return
+	}
+	if img.Withheld() {
+		apierror.Abort(c, apierror.ErrImageWithheld)
+		return
+	}
+	if img.Status != models.StatusCompleted || img.OptimizedPath == "" {
+		apierror.Abort(c, apierror.ErrVariantNotAvailable)
+		return
+	}
+
+	comparison, err := h.processor.Compare(c.Request.Context(), img.OriginalPath, img.OptimizedPath, req.Composite)
+	if err != nil {
+		reqLogger.Error().Err(err).Str("image_id", idStr).Msg("Failed to compare image")
+		apierror.Abort(c, apierror.Internal("Failed to compare image", err))
+		return
+	}
+
+	reqLogger.Info().
+		Str("image_id", idStr).
+		Float64("ssim", comparison.SSIM).
+		Float64("pixel_diff", comparison.PixelDiff).
+		Bool("composite", req.Composite).
+		Msg("Image compared")
+
+	if req.Composite {
+		c.Header("X-Image-SSIM", strconv.FormatFloat(comparison.SSIM, 'f', 4, 64))
+		c.Header("X-Image-Pixel-Diff", strconv.FormatFloat(comparison.PixelDiff, 'f', 2, 64))
+		c.Data(http.StatusOK, "image/jpeg", comparison.Composite)
+		return
+	}
+
+	originalURL, err := h.objectURL(c.Request.Context(), minio.ClassOriginal, img, img.OriginalPath)
+	if err != nil {
+		reqLogger.Error().Err(err).Str("image_id", idStr).Msg("Failed to generate URL for original image")
+	}
+	optimizedURL, err := h.optimizedURL(c.Request.Context(), img, img.OptimizedPath)
+	if err != nil {
+		reqLogger.Error().Err(err).Str("image_id", idStr).Msg("Failed to generate URL for optimized image")
+	}
+
+	var reduction float64
+	if img.OriginalSize > 0 {
+		reduction = (1 - float64(img.OptimizedSize)/float64(img.OriginalSize)) * 100
+	}
+
+	c.JSON(http.StatusOK, &models.ImageComparisonResponse{
+		ID:            img.ID,
+		OriginalURL:   originalURL,
+		OptimizedURL:  optimizedURL,
+		OriginalSize:  img.OriginalSize,
+		OptimizedSize: img.OptimizedSize,
+		Reduction:     reduction,
+		SSIM:          comparison.SSIM,
+		PixelDiff:     comparison.PixelDiff,
+	})
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/api/validation"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+	"github.com/not-nullexception/image-optimizer/internal/auth"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// exportBatchSize is how many images are read from the repository per query during an export
+const exportBatchSize = 500
+
+// exportColumns is the CSV header of an image export
+var exportColumns = []string{
+	"id", "original_name", "status", "original_format", "original_size", "original_width", "original_height",
+	"optimized_size", "optimized_width", "optimized_height", "stored_bytes", "quality_score",
+	"moderation_status", "error", "created_at", "updated_at",
+}
+
+// exportWriter writes images in one export format
+type exportWriter interface {
+	Write(img *models.Image) error
+	Flush() error
+}
+
+// ExportImages streams every image matching the filter as CSV or JSON Lines
+func (h *ImageHandler) ExportImages(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	var req ExportImagesRequest
+	if !validation.Query(c, &req) {
+		return
+	}
+	filter := models.ImageFilter{
+		Query:  req.Query,
+		Viewer: auth.Owner(c.Request.Context()),
+	}
+
+	reqLogger.Info().Str("format", req.Format).Str("query", filter.Query).Msg("Processing export images request")
+
+	var w exportWriter
+	contentType := "text/csv; charset=utf-8"
+	if req.Format == "jsonl" {
+		contentType = "application/x-ndjson"
+		w = &jsonlExportWriter{enc: json.NewEncoder(c.Writer)}
+	} else {
+		w = newCSVExportWriter(c.Writer)
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="images-%s.%s"`, time.Now().Format("20060102-150405"), req.Format))
+
+	count := 0
+	err := h.repo.IterateImages(c.Request.Context(), filter, exportBatchSize, func(img *models.Image) error {
+		if err := w.Write(img); err != nil {
+			return fmt.Errorf("error writing export row: %w", err)
+		}
+		count++
+		if count%exportBatchSize == 0 {
+			if err := w.Flush(); err != nil {
+				return fmt.Errorf("error flushing export: %w", err)
+			}
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		reqLogger.Error().Err(err).Int("exported", count).Msg("Failed to export images")
+		if !c.Writer.Written() {
+			apierror.Abort(c, apierror.FromRepository(err))
+			return
+		}
+		// The status line is already sent, so the client only sees a truncated export
+		c.Abort()
+		return
+	}
+
+	reqLogger.Info().Int("exported", count).Msg("Images exported successfully")
+}
+
+// csvExportWriter writes images as CSV rows after a header row
+type csvExportWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func newCSVExportWriter(out io.Writer) *csvExportWriter {
+	return &csvExportWriter{w: csv.NewWriter(out)}
+}
+
+func (e *csvExportWriter) Write(img *models.Image) error {
+	if !e.headerWritten {
+		if err := e.w.Write(exportColumns); err != nil {
+			return err
+		}
+		e.headerWritten = true
+	}
+	return e.w.Write([]string{
+		img.ID.String(), csvText(img.OriginalName), string(img.Status), img.OriginalFormat,
+		strconv.FormatInt(img.OriginalSize, 10), strconv.Itoa(img.OriginalWidth), strconv.Itoa(img.OriginalHeight),
+		strconv.FormatInt(img.OptimizedSize, 10), strconv.Itoa(img.OptimizedWidth), strconv.Itoa(img.OptimizedHeight),
+		strconv.FormatInt(img.StoredBytes, 10), strconv.FormatFloat(img.QualityScore, 'f', -1, 64),
+		string(img.ModerationStatus), csvText(img.Error), img.CreatedAt.Format(time.RFC3339), img.UpdatedAt.Format(time.RFC3339),
+	})
+}
+
+func (e *csvExportWriter) Flush() error {
+	// An empty export still gets its header
+	if !e.headerWritten {
+		if err := e.w.Write(exportColumns); err != nil {
+			return err
+		}
+		e.headerWritten = true
+	}
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// csvText prefixes text starting with a formula character with a quote, so spreadsheets
+// opening the export show user-controlled values such as filenames instead of evaluating them
+func csvText(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// jsonlExportWriter writes one JSON object per image and line
+type jsonlExportWriter struct {
+	enc *json.Encoder
+}
+
+func (e *jsonlExportWriter) Write(img *models.Image) error {
+	return e.enc.Encode(img)
+}
+
+func (e *jsonlExportWriter) Flush() error {
+	return nil
+}
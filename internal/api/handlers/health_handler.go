@@ -1,50 +1,117 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/not-nullexception/image-optimizer/internal/db"
 	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
 )
 
+// dependencyCheckTimeout bounds each readiness dependency check
+const dependencyCheckTimeout = 2 * time.Second
+
 type HealthHandler struct {
-	repo db.Repository
+	repo        db.Repository
+	minioClient minio.Client
+	queueClient rabbitmq.Client
 }
 
 type HeathResponse struct {
-	Status    string    `json:"status"`
-	Timestamp time.Time `json:"timestamp"`
-	Version   string    `json:"version"`
-	DB        string    `json:"db"`
+	Status       string                      `json:"status"`
+	Timestamp    time.Time                   `json:"timestamp"`
+	Version      string                      `json:"version"`
+	Dependencies map[string]DependencyStatus `json:"dependencies,omitempty"`
+}
+
+// DependencyStatus reports the result of checking a single dependency
+type DependencyStatus struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
 }
 
-func NewHealthHandler(repo db.Repository) *HealthHandler {
+func NewHealthHandler(repo db.Repository, minioClient minio.Client, queueClient rabbitmq.Client) *HealthHandler {
 	return &HealthHandler{
-		repo: repo,
+		repo:        repo,
+		minioClient: minioClient,
+		queueClient: queueClient,
 	}
 }
 
-// Check handles heath check requests
-func (h *HealthHandler) Check(c *gin.Context) {
-	reqLogger := logger.FromContext(c.Request.Context())
-	reqLogger.Info().Msg("Processing health check request")
-
-	response := HeathResponse{
+// Live handles liveness probes. It only reports that the process is serving requests.
+func (h *HealthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, HeathResponse{
 		Status:    "UP",
 		Timestamp: time.Now(),
 		Version:   "1.0.0",
-		DB:        "UP",
+	})
+}
+
+// Ready handles readiness probes. It checks Postgres, MinIO and RabbitMQ concurrently and
+// returns 503 if any of them is down, so load balancers stop routing traffic.
+func (h *HealthHandler) Ready(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+	reqLogger.Debug().Msg("Processing readiness check request")
+
+	checks := map[string]func(ctx context.Context) error{
+		"postgres": h.repo.Ping,
+		"minio":    h.minioClient.Ping,
+		"rabbitmq": func(context.Context) error { return h.queueClient.Ping() },
 	}
 
-	err := h.repo.Ping(c.Request.Context())
-	if err != nil {
-		reqLogger.Error().Err(err).Msg("Database health check failed")
-		response.Status = "DEGRADED"
-		response.DB = "DOWN"
+	response := HeathResponse{
+		Status:       "UP",
+		Timestamp:    time.Now(),
+		Version:      "1.0.0",
+		Dependencies: make(map[string]DependencyStatus, len(checks)),
 	}
 
-	reqLogger.Info().Msg("Health check successful")
-	c.JSON(http.StatusOK, response)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(ctx context.Context) error) {
+			defer wg.Done()
+			status := runCheck(c.Request.Context(), check)
+			mu.Lock()
+			response.Dependencies[name] = status
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	code := http.StatusOK
+	for name, dep := range response.Dependencies {
+		if dep.Status != "UP" {
+			reqLogger.Error().Str("dependency", name).Str("error", dep.Error).Msg("Readiness dependency check failed")
+			response.Status = "DOWN"
+			code = http.StatusServiceUnavailable
+		}
+	}
+
+	c.JSON(code, response)
+}
+
+// runCheck runs a dependency check with a timeout and measures its latency
+func runCheck(ctx context.Context, check func(ctx context.Context) error) DependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, dependencyCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check(ctx)
+	status := DependencyStatus{
+		Status:    "UP",
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		status.Status = "DOWN"
+		status.Error = err.Error()
+	}
+	return status
 }
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// GetImageHistory returns every status transition of an image, oldest first, with the
+// worker and attempt that processed it and how long it stayed in each status
+func (h *ImageHandler) GetImageHistory(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	id, ok := bindImageID(c)
+	if !ok {
+		return
+	}
+
+	if _, ok := h.loadImage(c, id); !ok {
+		return
+	}
+
+	events, err := h.repo.ListImageEvents(c.Request.Context(), id)
+	if err != nil {
+		reqLogger.Error().Err(err).Str("image_id", id.String()).Msg("Failed to list image events")
+		apierror.Abort(c, apierror.FromRepository(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, &models.ImageHistoryResponse{
+		ImageID: id,
+		Events:  events,
+	})
+}
@@ -1,21 +1,45 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/api/validation"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+	"github.com/not-nullexception/image-optimizer/internal/audit"
+	"github.com/not-nullexception/image-optimizer/internal/auth"
+	"github.com/not-nullexception/image-optimizer/internal/backpressure"
+	"github.com/not-nullexception/image-optimizer/internal/cdn"
 	"github.com/not-nullexception/image-optimizer/internal/db"
 	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/deletion"
+	"github.com/not-nullexception/image-optimizer/internal/events"
 	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
 	"github.com/not-nullexception/image-optimizer/internal/minio"
+	"github.com/not-nullexception/image-optimizer/internal/outbox"
 	imageprocessor "github.com/not-nullexception/image-optimizer/internal/processor/image"
 	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
-	"github.com/rs/zerolog"
+	"github.com/not-nullexception/image-optimizer/internal/retry"
+	"github.com/not-nullexception/image-optimizer/internal/scan"
+	"github.com/not-nullexception/image-optimizer/internal/transform"
+	"github.com/not-nullexception/image-optimizer/internal/usage"
+	"github.com/not-nullexception/image-optimizer/internal/worker"
 )
 
 type ImageHandler struct {
@@ -23,216 +47,795 @@ type ImageHandler struct {
 	minioClient minio.Client
 	queueClient rabbitmq.Client
 	processor   *imageprocessor.Processor
-	config      *config.Config
+	signer      *transform.Signer
+	purger      *deletion.Purger
+	scanner     scan.Scanner
+	outbox      *outbox.Relay
+	retrier     *retry.Retrier
+	meter       *usage.Meter
+	// throttle refuses low priority uploads while the pipeline is saturated
+	throttle *backpressure.Monitor
+	// inline processes the uploads with sync=true within the request
+	inline *worker.Worker
+	// events delivers the status changes of images to progress streams and waits
+	events *events.Bus
+	config *config.Config
+	// processing holds the processing defaults, replaced on configuration reloads
+	processing atomic.Pointer[config.ProcessingConfig]
 }
 
 func NewImageHandler(
 	repo db.Repository,
 	minioClient minio.Client,
 	queueClient rabbitmq.Client,
+	bus *events.Bus,
+	monitor *backpressure.Monitor,
+	meter *usage.Meter,
 	config *config.Config,
 ) *ImageHandler {
-	return &ImageHandler{
+	h := &ImageHandler{
 		repo:        repo,
 		minioClient: minioClient,
 		queueClient: queueClient,
 		processor:   imageprocessor.New(minioClient),
+		signer:      transform.NewSigner(config.Transform.SigningKey),
+		purger:      deletion.NewPurger(repo, minioClient, cdn.NewInvalidator(&config.CDN), &config.Delete),
+		scanner:     scan.NewScanner(&config.Scan),
+		outbox:      outbox.NewRelay(repo, queueClient, &config.Outbox),
+		meter:       meter,
+		throttle:    monitor,
+		events:      bus,
 		config:      config,
 	}
+	h.retrier = retry.NewRetrier(repo, h.outbox, &config.Retry)
+	// Uploads are only processed inline if enabled at startup
+	if config.Processing.SyncMaxBytes > 0 {
+		h.inline = worker.New(repo, minioClient, queueClient, meter, config)
+	}
+	h.processing.Store(&config.Processing)
+	return h
+}
+
+// Reconfigure adopts the processing defaults of a reloaded configuration
+func (h *ImageHandler) Reconfigure(cfg *config.Config) {
+	h.processing.Store(&cfg.Processing)
+	if h.inline != nil {
+		h.inline.Reconfigure(cfg)
+	}
 }
 
 // UploadImage handles image upload requests
 func (h *ImageHandler) UploadImage(c *gin.Context) {
-	// TODO - Improve input validation
-
 	reqLogger := logger.FromContext(c.Request.Context())
 	reqLogger.Info().Msg("Received image upload request")
 
-	// Get file from request
-	file, header, err := c.Request.FormFile("image")
+	// Uploads with an upload token get the processing options of its preset, whatever the
+	// request asks for
+	policy := auth.Policy(c.Request.Context())
+	if policy != nil && policy.Preset != "" {
+		c.Request.URL.RawQuery = policy.Preset
+	}
+
+	// Validate processing options before anything is read or stored
+	var req UploadImageRequest
+	if !validation.Query(c, &req) {
+		reqLogger.Warn().Msg("Rejected upload with invalid processing options")
+		return
+	}
+
+	// Low priority uploads wait while the workers can't keep up, before anything is read
+	if reason := h.throttle.Throttle(req.Priority); reason != "" {
+		reqLogger.Warn().Str("reason", reason).Msg("Throttled low priority upload")
+		metrics.ThrottledUploadsTotal.WithLabelValues(reason).Inc()
+		c.Header("Retry-After", strconv.Itoa(int(h.throttle.RetryAfter().Seconds())))
+		apierror.Abort(c, apierror.ErrUploadThrottled)
+		return
+	}
+
+	renditions, ok := h.parseRenditions(c, req.Renditions)
+	if !ok {
+		return
+	}
+
+	owner := auth.Owner(c.Request.Context())
+	if req.Visibility == string(models.VisibilityPrivate) && owner == "" {
+		reqLogger.Warn().Msg("Rejected anonymous upload of a private image")
+		apierror.Abort(c, apierror.ErrUnauthorized)
+		return
+	}
+
+	// Checksums sent by the client are verified against the upload
+	sums, header, err := parseUploadChecksums(c.Request)
+	if err != nil {
+		reqLogger.Warn().Err(err).Str("header", header).Msg("Rejected upload with a malformed checksum")
+		validation.Fail(c, header, err.Error())
+		return
+	}
+
+	// An upload of content the owner already uploaded, such as a retried upload, returns
+	// the earlier image without reading the file
+	if sums.sha256 != nil {
+		visibility := models.VisibilityPublic
+		if req.Visibility != "" {
+			visibility = models.Visibility(req.Visibility)
+		}
+		existing, err := h.repo.FindImageByChecksum(c.Request.Context(), hex.EncodeToString(sums.sha256), owner)
+		switch {
+		case err == nil && existing.Visibility == visibility:
+			reqLogger.Info().Str("id", existing.ID.String()).Msg("Upload matches an earlier upload, returning its image")
+			c.JSON(http.StatusOK, &models.ImageUploadResponse{
+				ID:        existing.ID,
+				Status:    string(existing.Status),
+				Duplicate: true,
+			})
+			return
+		case err != nil && !errors.Is(err, db.ErrNotFound):
+			reqLogger.Error().Err(err).Msg("Failed to look up earlier uploads by checksum")
+			apierror.Abort(c, apierror.FromRepository(err))
+			return
+		}
+	}
+
+	// Read the image field as it arrives instead of buffering the whole form
+	part, fields, err := imagePart(c.Request)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to get image from request"})
+		if bodyErr := apierror.FromRequestBody(err); bodyErr != nil {
+			reqLogger.Warn().Err(err).Msg("Rejected upload body")
+			apierror.Abort(c, bodyErr)
+			return
+		}
+		if errors.Is(err, errFormFieldTooLarge) {
+			validation.Fail(c, "form", err.Error())
+			return
+		}
+		validation.Fail(c, "image", "image file is required")
 		return
 	}
-	defer file.Close()
+	defer part.Close()
+	filename := part.FileName()
 
-	// Check file size
-	if header.Size > 10*1024*1024 { // 10 MB
-		reqLogger.Error().Str("filename", header.Filename).Int64("size", header.Size).Msg("File too large")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "File too large, max 10MB"})
+	// Processing targets are sent as a form field before the image
+	targets, ok := h.parseTargets(c, fields["targets"])
+	if !ok {
 		return
 	}
 
 	// Validate file type
-	ext := filepath.Ext(header.Filename)
+	ext := filepath.Ext(filename)
 	if ext != ".jpg" && ext != ".jpeg" && ext != ".png" {
-		reqLogger.Error().Str("filename", header.Filename).Str("extension", ext).Msg("Unsupported file format")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported file format, only JPG and PNG are supported"})
+		reqLogger.Error().Str("filename", filename).Str("extension", ext).Msg("Unsupported file format")
+		validation.FailWithCode(c, apierror.CodeUnsupportedFormat, "image", "unsupported file format, only JPG and PNG are supported")
 		return
 	}
 
-	// Validate MIME type
-	buffer := make([]byte, 512)
-	_, err = file.Read(buffer)
+	// Validate MIME type on the start of the file, which stays buffered for the upload
+	upload := newUploadStream(part, uploadLimit(c.Request.Context()), sums)
+	head, err := upload.sniff()
 	if err != nil {
-		reqLogger.Error().Err(err).Str("filename", header.Filename).Msg("Failed to read file for MIME type validation")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read file for MIME type validation"})
+		failUploadRead(c, filename, err)
 		return
 	}
-	file.Seek(0, 0) // Reset file position after reading
 
-	mimeType := http.DetectContentType(buffer)
+	mimeType := http.DetectContentType(head)
 	if mimeType != "image/jpeg" && mimeType != "image/png" {
-		reqLogger.Error().Str("filename", header.Filename).Str("provided_mime", mimeType).Msg("Unsupported MIME type")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported MIME type, only image/jpeg and image/png are supported"})
+		reqLogger.Error().Str("filename", filename).Str("provided_mime", mimeType).Msg("Unsupported MIME type")
+		validation.FailWithCode(c, apierror.CodeUnsupportedFormat, "image", "unsupported MIME type, only image/jpeg and image/png are supported")
 		return
 	}
 
-	// Validate the image and get dimensions
-	width, height, size, format, err := h.processor.ValidateImage(c.Request.Context(), file)
+	// Get dimensions from the image header; the worker decodes the whole image
+	width, height, format, err := h.processor.ProbeImage(c.Request.Context(), bytes.NewReader(head))
 	if err != nil {
-		reqLogger.Error().Err(err).Str("filename", header.Filename).Msg("Invalid image")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image: " + err.Error()})
+		reqLogger.Error().Err(err).Str("filename", filename).Msg("Invalid image")
+		validation.Fail(c, "image", "invalid image: "+err.Error())
 		return
 	}
 
-	// Reset file position for uploading
-	file.Seek(0, 0)
+	// API keys of third-party integrations may be limited to some formats and sizes
+	if !auth.AllowsFormat(c.Request.Context(), format) {
+		reqLogger.Warn().Str("filename", filename).Str("format", format).Msg("Rejected upload in a format not allowed for the API key")
+		validation.FailWithCode(c, apierror.CodeUnsupportedFormat, "image", format+" images are not allowed for this API key")
+		return
+	}
+	if !auth.AllowsDimensions(c.Request.Context(), width, height) {
+		reqLogger.Warn().Str("filename", filename).Int("width", width).Int("height", height).Msg("Rejected upload larger than allowed for the API key")
+		validation.Fail(c, "image", fmt.Sprintf("images of %dx%d are larger than allowed for this API key", width, height))
+		return
+	}
 
 	// Generate ID for the image
 	imageUUID := uuid.New()
-	reqLogger.Info().Str("image_id", imageUUID.String()).Str("filename", header.Filename).Msg("Generated unique ID for new image upload")
-
-	objectName := h.minioClient.GenerateObjectName(imageUUID, header.Filename)
+	reqLogger.Info().Str("image_id", imageUUID.String()).Str("filename", filename).Msg("Generated unique ID for new image upload")
 
-	// Upload original image to MinIO
 	contentType := "image/jpeg"
 	if format == "png" {
 		contentType = "image/png"
 	}
 
-	err = h.minioClient.UploadImage(c.Request.Context(), file, objectName, contentType)
+	// Malware scans and content-addressed names need the whole file before it is stored,
+	// so it is spooled to a temporary file; otherwise it is streamed to storage as it is read
+	var spooled io.ReadSeeker
+	if h.scanner != nil || h.minioClient.ContentAddressed() {
+		file, err := spoolUpload(upload)
+		if err != nil {
+			failUploadRead(c, filename, err)
+			return
+		}
+		defer file.Close()
+		spooled = file
+	}
+
+	// Scan for malware before the original is stored where it can be served
+	if h.scanner != nil {
+		result, err := h.scanner.Scan(c.Request.Context(), spooled)
+		if _, seekErr := spooled.Seek(0, io.SeekStart); seekErr != nil {
+			reqLogger.Error().Err(seekErr).Str("filename", filename).Msg("Failed to rewind scanned upload")
+			apierror.Abort(c, apierror.Internal("Failed to store image", seekErr))
+			return
+		}
+		switch {
+		case err != nil:
+			metrics.MalwareScansTotal.WithLabelValues("error").Inc()
+			if !h.config.Scan.FailOpen {
+				reqLogger.Error().Err(err).Str("filename", filename).Msg("Failed to scan upload for malware")
+				apierror.Abort(c, apierror.ErrScannerUnavailable)
+				return
+			}
+			reqLogger.Warn().Err(err).Str("filename", filename).Msg("Failed to scan upload for malware, accepting it unscanned")
+		case result.Infected:
+			metrics.MalwareScansTotal.WithLabelValues("infected").Inc()
+			img := models.NewImageWithID(imageUUID, filename, upload.size, width, height, format, "")
+			img.Owner = owner
+			h.quarantineUpload(c.Request.Context(), img, spooled, contentType, result.Signature)
+			apierror.Abort(c, apierror.ErrMalwareDetected)
+			return
+		default:
+			metrics.MalwareScansTotal.WithLabelValues("clean").Inc()
+		}
+	}
+
+	// An upload token is used up right before its upload is stored, so uploads rejected by
+	// the checks above can be retried with it
+	if policy != nil {
+		if err := h.repo.UseUploadToken(c.Request.Context(), policy.ID, policy.ExpiresAt); err != nil {
+			if errors.Is(err, db.ErrUploadTokenUsed) {
+				reqLogger.Warn().Str("token_id", policy.ID.String()).Msg("Rejected upload with a used upload token")
+				apierror.Abort(c, apierror.ErrUploadTokenUsed)
+				return
+			}
+			reqLogger.Error().Err(err).Str("token_id", policy.ID.String()).Msg("Failed to record use of upload token")
+			apierror.Abort(c, apierror.FromRepository(err))
+			return
+		}
+	}
+
+	objectName, err := h.minioClient.GenerateObjectName(imageUUID, filename, spooled)
 	if err != nil {
-		reqLogger.Error().Err(err).Str("filename", header.Filename).Msg("Failed to upload image to storage")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to upload image to storage"})
+		reqLogger.Error().Err(err).Str("filename", filename).Msg("Failed to generate object name")
+		apierror.Abort(c, apierror.Internal("Failed to store image", err))
+		return
+	}
+
+	// Upload original image to MinIO. The SHA-256 sent by the client is recorded with
+	// objects streamed to storage, which cannot be hashed before they are stored, and
+	// the store verifies the upload when the client sent its MD5.
+	ctx := c.Request.Context()
+	if sums.md5 != nil {
+		ctx = minio.WithContentMD5(ctx)
+	}
+	if spooled != nil {
+		err = minio.StoreOriginal(ctx, h.minioClient.In(minio.ClassOriginal), spooled, objectName, contentType)
+	} else {
+		if sums.sha256 != nil {
+			ctx = minio.WithContentChecksum(ctx, hex.EncodeToString(sums.sha256))
+		}
+		err = minio.StoreOriginal(ctx, h.minioClient.In(minio.ClassOriginal), upload, objectName, contentType)
+	}
+	if err != nil {
+		if readErr := upload.readErr(); readErr != nil {
+			failUploadRead(c, filename, readErr)
+			return
+		}
+		reqLogger.Error().Err(err).Str("filename", filename).Msg("Failed to upload image to storage")
+		apierror.Abort(c, apierror.FromStorage(err))
 		return
 	}
 
 	// Create image record in database
-	img := models.NewImageWithID(imageUUID, header.Filename, size, width, height, format, objectName)
+	img := models.NewImageWithID(imageUUID, filename, upload.size, width, height, format, objectName)
+	img.Owner = owner
+	// Record the checksums of the original to verify the stored object against later
+	img.OriginalChecksum = upload.checksum()
+	img.OriginalMD5 = upload.md5Checksum()
+	if req.Visibility != "" {
+		img.Visibility = models.Visibility(req.Visibility)
+	}
 
 	err = h.repo.CreateImage(c.Request.Context(), img)
 	if err != nil {
 		reqLogger.Error().Err(err).Str("id", imageUUID.String()).Msg("Failed to save image metadata to database")
-		cleanupErr := h.minioClient.DeleteImage(context.Background(), objectName)
+		cleanupErr := minio.Release(context.Background(), h.minioClient.In(minio.ClassOriginal), h.repo, objectName, imageUUID)
 		if cleanupErr != nil {
 			reqLogger.Error().Err(cleanupErr).Str("object_name", objectName).Msg("Failed to cleanup MinIO object after DB error")
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save image metadata"})
+		apierror.Abort(c, apierror.FromRepository(err))
 		return
 	}
+	h.meter.Record(c.Request.Context(), owner, models.UsageCounts{Uploads: 1})
+	metrics.RecordUpload(c.Request.Context(), format, upload.size)
 
 	// Send image to processing queue
-	task := rabbitmq.Task{
-		ID:   img.ID.String(),
-		Type: rabbitmq.TaskTypeResizeImage,
-		Data: map[string]any{
-			"image_id":      img.ID.String(),
-			"original_path": img.OriginalPath,
-			"filename":      img.OriginalName,
-			"config": map[string]any{
-				"max_width":        1200, // Default max width
-				"max_height":       1200, // Default max height
-				"quality":          85,   // Default JPEG quality
-				"optimize_storage": true,
-			},
-		},
-	}
+	task := h.resizeTask(img, &req, renditions, targets)
 
-	// Process custom parameters if provided
-	if width, err := strconv.Atoi(c.DefaultQuery("max_width", "0")); err == nil && width > 0 {
-		task.Data["config"].(map[string]any)["max_width"] = width
+	reqLogger.Debug().RawJSON("final_task_payload", task.Data).Msg("Applied custom parameters; final task configuration prepared")
+
+	// Small images are processed within the request when the client asks for it, skipping
+	// the queue; larger ones are queued as usual
+	var processed *models.Image
+	if req.Sync && h.inline != nil {
+		if upload.size <= h.processing.Load().SyncMaxBytes {
+			processed = h.processInline(c.Request.Context(), imageUUID, task)
+		} else {
+			reqLogger.Info().Str("id", imageUUID.String()).Int64("size", upload.size).Msg("Upload too large to process synchronously, queuing it")
+			metrics.SyncUploadsTotal.WithLabelValues("too_large").Inc()
+		}
 	}
 
-	if height, err := strconv.Atoi(c.DefaultQuery("max_height", "0")); err == nil && height > 0 {
-		task.Data["config"].(map[string]any)["max_height"] = height
+	status := models.StatusPending
+	if processed != nil {
+		status = processed.Status
+	} else {
+		// Fall back to the outbox if the queue is down, so the task is published later
+		published, err := h.outbox.Publish(c.Request.Context(), imageUUID, task)
+		if err != nil {
+			reqLogger.Error().Err(err).Str("id", imageUUID.String()).Msg("Failed to queue image for processing")
+			if updateErr := h.repo.UpdateImageStatus(c.Request.Context(), imageUUID, models.StatusFailed, "processing queue unavailable"); updateErr != nil {
+				reqLogger.Error().Err(updateErr).Str("id", imageUUID.String()).Msg("Failed to mark unqueued image as failed")
+			}
+			apierror.Abort(c, apierror.FromQueue(err))
+			return
+		}
+		if !published {
+			status = models.StatusQueueFailed
+			if err := h.repo.UpdateImageStatus(c.Request.Context(), imageUUID, status, "processing queue unavailable, task will be retried"); err != nil {
+				reqLogger.Error().Err(err).Str("id", imageUUID.String()).Msg("Failed to mark image as queued_failed")
+			}
+		}
 	}
 
-	if quality, err := strconv.Atoi(c.DefaultQuery("quality", "0")); err == nil && quality > 0 {
-		task.Data["config"].(map[string]any)["quality"] = quality
+	// Queue text extraction if requested
+	if h.config.OCR.Enabled && req.ExtractText {
+		ocrTask := rabbitmq.NewImageTask(rabbitmq.TaskTypeExtractText, rabbitmq.ImagePayload{
+			ImageID:      img.ID.String(),
+			OriginalPath: img.OriginalPath,
+		})
+		if _, err := h.outbox.Publish(c.Request.Context(), imageUUID, ocrTask); err != nil {
+			reqLogger.Error().Err(err).Str("id", imageUUID.String()).Msg("Failed to queue image for text extraction")
+		}
 	}
 
-	if finalConfigMap, ok := task.Data["config"].(map[string]any); ok {
-		// Verifique se 'ok' é true antes de tentar acessar o mapa
-		// Use zerolog.Dict() para logar os valores finais de forma estruturada
-		reqLogger.Debug().Dict("final_task_config", zerolog.Dict().
-			Int("max_width", finalConfigMap["max_width"].(int)).   // Faz type assertion para int
-			Int("max_height", finalConfigMap["max_height"].(int)). // Assume que os tipos no mapa estão corretos
-			Int("quality", finalConfigMap["quality"].(int)).
-			Bool("optimize_storage", finalConfigMap["optimize_storage"].(bool)), // Inclui o campo booleano
-		).Msg("Applied custom parameters; final task configuration prepared")
-	} else {
-		// Logue um aviso se, por algum motivo, o mapa de configuração não estiver lá ou for do tipo errado
-		reqLogger.Warn().Msg("Could not log final task config: task.Data[\"config\"] is not a map[string]any")
+	// Queue background removal if requested
+	if h.config.Background.Enabled && req.RemoveBackground {
+		cutoutTask := rabbitmq.NewImageTask(rabbitmq.TaskTypeRemoveBackground, rabbitmq.ImagePayload{
+			ImageID:      img.ID.String(),
+			OriginalPath: img.OriginalPath,
+		})
+		if _, err := h.outbox.Publish(c.Request.Context(), imageUUID, cutoutTask); err != nil {
+			reqLogger.Error().Err(err).Str("id", imageUUID.String()).Msg("Failed to queue image for background removal")
+		}
 	}
 
-	err = h.queueClient.Publish(c.Request.Context(), task)
-	if err != nil {
-		reqLogger.Error().Err(err).Str("id", imageUUID.String()).Msg("Failed to queue image for processing")
-		// Continue anyway, as we have stored the original image
-		// TODO - consider adding a retry mechanism or a dead-letter queue
+	// An image processed inline is returned with its optimized URL
+	if processed != nil && processed.Status == models.StatusCompleted {
+		resp := &models.ImageUploadResponse{
+			ID:     imageUUID,
+			Status: string(status),
+		}
+		if processed.OptimizedPath != "" && auth.AllowsOperation(c.Request.Context(), auth.OpDownload) {
+			resp.OptimizedURL, err = h.optimizedURL(c.Request.Context(), processed, processed.OptimizedPath)
+			if err != nil {
+				reqLogger.Error().Err(err).Str("id", imageUUID.String()).Msg("Failed to generate URL for optimized image")
+			}
+		}
+		reqLogger.Info().Str("id", imageUUID.String()).Msg("Image processed synchronously")
+		c.JSON(http.StatusOK, resp)
+		return
 	}
 
-	reqLogger.Info().Str("id", imageUUID.String()).Msg("Image accepted and queued for processing")
+	reqLogger.Info().Str("id", imageUUID.String()).Str("status", string(status)).Msg("Image accepted for processing")
 
 	// Return image ID
 	c.JSON(http.StatusAccepted, &models.ImageUploadResponse{
 		ID:     imageUUID,
-		Status: string(models.StatusPending),
+		Status: string(status),
 	})
 }
 
+// processInline processes the image of task within the request, like a worker would. It
+// returns the image as processed, or nil when the task must be queued instead. An image
+// whose processing failed keeps the retry scheduled by the task ledger.
+//
+// The task is stored in the outbox first, to be published once the request timed out, so
+// an image left processing by a crash of the API is processed by a worker.
+func (h *ImageHandler) processInline(ctx context.Context, id uuid.UUID, task rabbitmq.Task) *models.Image {
+	reqLogger := logger.FromContext(ctx)
+
+	fallback, err := h.outbox.Schedule(ctx, id, task, h.config.Server.Timeouts.Upload)
+	if err != nil {
+		reqLogger.Warn().Err(err).Str("id", id.String()).Msg("Failed to store fallback of synchronous processing, queuing image")
+		metrics.SyncUploadsTotal.WithLabelValues("fallback").Inc()
+		return nil
+	}
+	// The task ran, or is queued by the caller instead
+	defer func() {
+		if err := h.outbox.Done(context.WithoutCancel(ctx), fallback); err != nil {
+			reqLogger.Warn().Err(err).Str("id", id.String()).Msg("Failed to remove fallback of synchronous processing")
+		}
+	}()
+
+	if err := h.inline.Process(ctx, task); err != nil {
+		reqLogger.Warn().Err(err).Str("id", id.String()).Msg("Failed to process image synchronously, queuing it")
+		metrics.SyncUploadsTotal.WithLabelValues("fallback").Inc()
+		return nil
+	}
+	metrics.SyncUploadsTotal.WithLabelValues("processed").Inc()
+
+	img, err := h.repo.GetImageByID(ctx, id)
+	if err != nil {
+		// The task ran, so it must not be queued again
+		reqLogger.Error().Err(err).Str("id", id.String()).Msg("Failed to get image after synchronous processing")
+		return &models.Image{ID: id, Status: models.StatusProcessing}
+	}
+	return img
+}
+
+// quarantineUpload keeps an infected upload under the quarantine prefix for review and
+// records it as a rejected image, which is never served or processed. Failures are only
+// logged since the upload is rejected either way.
+func (h *ImageHandler) quarantineUpload(ctx context.Context, img *models.Image, file io.ReadSeeker, contentType, signature string) {
+	reqLogger := logger.FromContext(ctx)
+	idStr := img.ID.String()
+
+	objectName, err := h.minioClient.GenerateObjectName(img.ID, img.OriginalName, file)
+	if err != nil {
+		reqLogger.Error().Err(err).Str("image_id", idStr).Msg("Failed to generate object name for infected upload")
+		return
+	}
+	img.OriginalPath = h.config.Scan.QuarantinePrefix + strings.TrimPrefix(objectName, h.config.MinIO.UploadQuarantinePrefix)
+
+	if err := h.minioClient.In(minio.ClassOriginal).UploadImage(ctx, file, img.OriginalPath, contentType); err != nil {
+		reqLogger.Error().Err(err).Str("image_id", idStr).Msg("Failed to quarantine infected upload")
+		return
+	}
+
+	errMsg := "malware detected: " + signature
+	if err := h.repo.CreateImage(ctx, img); err != nil {
+		reqLogger.Error().Err(err).Str("image_id", idStr).Msg("Failed to record infected upload")
+		return
+	}
+	if err := h.repo.UpdateModerationStatus(ctx, img.ID, models.ModerationRejected); err != nil {
+		reqLogger.Error().Err(err).Str("image_id", idStr).Msg("Failed to reject infected upload")
+	}
+	if err := h.repo.UpdateImageStatus(ctx, img.ID, models.StatusRejected, errMsg); err != nil {
+		reqLogger.Error().Err(err).Str("image_id", idStr).Msg("Failed to update status of infected upload")
+	}
+
+	reqLogger.Warn().Str("image_id", idStr).Str("signature", signature).Str("object", img.OriginalPath).Msg("Infected upload quarantined")
+	audit.Record(ctx, audit.ActionMalwareDetected, img.ID, map[string]any{
+		"signature": signature,
+		"object":    img.OriginalPath,
+		"owner":     img.Owner,
+	})
+}
+
+// ReprocessImage queues an existing image for optimization again, with the same processing
+// options as an upload
+func (h *ImageHandler) ReprocessImage(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	id, ok := bindImageID(c)
+	if !ok {
+		return
+	}
+
+	var req UploadImageRequest
+	if !validation.Query(c, &req) {
+		return
+	}
+	renditions, ok := h.parseRenditions(c, req.Renditions)
+	if !ok {
+		return
+	}
+
+	reqLogger.Info().Str("image_id", id.String()).Msg("Processing reprocess image request")
+
+	img, ok := h.loadImage(c, id)
+	if !ok {
+		return
+	}
+	if img.Status == models.StatusProcessing {
+		apierror.Abort(c, apierror.ErrImageProcessing)
+		return
+	}
+	if img.Withheld() {
+		apierror.Abort(c, apierror.ErrImageWithheld)
+		return
+	}
+
+	status := models.StatusPending
+	if err := h.repo.UpdateImageStatus(c.Request.Context(), id, status, ""); err != nil {
+		reqLogger.Error().Err(err).Str("image_id", id.String()).Msg("Failed to reset image status for reprocessing")
+		apierror.Abort(c, apierror.FromRepository(err))
+		return
+	}
+
+	published, err := h.outbox.Publish(c.Request.Context(), id, h.resizeTask(img, &req, renditions, nil))
+	if err != nil {
+		reqLogger.Error().Err(err).Str("image_id", id.String()).Msg("Failed to queue image for reprocessing")
+		if updateErr := h.repo.UpdateImageStatus(c.Request.Context(), id, models.StatusFailed, "processing queue unavailable"); updateErr != nil {
+			reqLogger.Error().Err(updateErr).Str("image_id", id.String()).Msg("Failed to mark unqueued image as failed")
+		}
+		apierror.Abort(c, apierror.FromQueue(err))
+		return
+	}
+	if !published {
+		status = models.StatusQueueFailed
+		if err := h.repo.UpdateImageStatus(c.Request.Context(), id, status, "processing queue unavailable, task will be retried"); err != nil {
+			reqLogger.Error().Err(err).Str("image_id", id.String()).Msg("Failed to mark image as queued_failed")
+		}
+	}
+
+	reqLogger.Info().Str("image_id", id.String()).Str("status", string(status)).Msg("Image queued for reprocessing")
+
+	c.JSON(http.StatusAccepted, &models.ImageUploadResponse{
+		ID:     id,
+		Status: string(status),
+	})
+}
+
+// RetryImage queues a failed image for processing again with the task it failed with, or
+// the default processing options if no task was recorded
+func (h *ImageHandler) RetryImage(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	id, ok := bindImageID(c)
+	if !ok {
+		return
+	}
+
+	reqLogger.Info().Str("image_id", id.String()).Msg("Processing retry image request")
+
+	img, ok := h.loadImage(c, id)
+	if !ok {
+		return
+	}
+	if img.Withheld() {
+		apierror.Abort(c, apierror.ErrImageWithheld)
+		return
+	}
+	if img.Status != models.StatusFailed {
+		apierror.Abort(c, apierror.ErrImageNotFailed)
+		return
+	}
+
+	task, err := h.retrier.LastTask(c.Request.Context(), id)
+	if errors.Is(err, retry.ErrNoTask) {
+		task, err = h.resizeTask(img, &UploadImageRequest{}, nil, nil), nil
+	}
+	if err != nil {
+		reqLogger.Error().Err(err).Str("image_id", id.String()).Msg("Failed to load task to retry")
+		apierror.Abort(c, apierror.FromRepository(err))
+		return
+	}
+
+	status, err := h.retrier.Requeue(c.Request.Context(), id, task, false)
+	if errors.Is(err, db.ErrStatusConflict) {
+		apierror.Abort(c, apierror.ErrImageNotFailed)
+		return
+	}
+	if err != nil {
+		reqLogger.Error().Err(err).Str("image_id", id.String()).Msg("Failed to queue image for retry")
+		if status == models.StatusFailed {
+			apierror.Abort(c, apierror.FromQueue(err))
+		} else {
+			apierror.Abort(c, apierror.FromRepository(err))
+		}
+		return
+	}
+
+	reqLogger.Info().Str("image_id", id.String()).Str("status", string(status)).Msg("Image queued for retry")
+
+	c.JSON(http.StatusAccepted, &models.ImageUploadResponse{
+		ID:     id,
+		Status: string(status),
+	})
+}
+
+// resizeTask builds the resize task for img, applying the processing options of req
+// over the configured defaults. Targets are encoded alongside the optimized image, with
+// the default quality of their format unless set.
+func (h *ImageHandler) resizeTask(img *models.Image, req *UploadImageRequest, renditions []string, targets []UploadTarget) rabbitmq.Task {
+	defaults := h.processing.Load()
+	config := &rabbitmq.ResizeConfig{
+		MaxWidth:        defaults.DefaultMaxWidth,
+		MaxHeight:       defaults.DefaultMaxHeight,
+		Quality:         defaults.QualityFor(img.OriginalFormat),
+		OptimizeStorage: defaults.DefaultOptimizeStorage,
+		Gravity:         req.Gravity,
+		BlurFaces:       req.BlurFaces,
+		Filter:          req.Filter,
+		Sharpen:         req.Sharpen,
+		TargetSizeKB:    req.TargetSizeKB,
+		MinQuality:      req.MinQuality,
+		Renditions:      renditions,
+	}
+
+	// Process custom parameters if provided
+	if req.MaxWidth > 0 {
+		config.MaxWidth = req.MaxWidth
+	}
+
+	if req.MaxHeight > 0 {
+		config.MaxHeight = req.MaxHeight
+	}
+
+	if req.Quality > 0 {
+		config.Quality = req.Quality
+	}
+
+	for _, target := range targets {
+		format := target.Format
+		if format == "" {
+			format = img.OriginalFormat
+		}
+		quality := target.Quality
+		if quality == 0 {
+			quality = defaults.QualityFor(format)
+		}
+		config.Targets = append(config.Targets, rabbitmq.Target{
+			Width:   target.Width,
+			Height:  target.Height,
+			Quality: quality,
+			Format:  format,
+		})
+	}
+
+	return rabbitmq.NewImageTask(rabbitmq.TaskTypeResizeImage, rabbitmq.ImagePayload{
+		ImageID:      img.ID.String(),
+		OriginalPath: img.OriginalPath,
+		Filename:     img.OriginalName,
+		Config:       config,
+	})
+}
+
+// parseTargets validates the JSON array of processing targets sent in the targets form
+// field, if any. It writes a validation error and returns false if they are invalid or
+// more than configured.
+func (h *ImageHandler) parseTargets(c *gin.Context, value string) ([]UploadTarget, bool) {
+	var targets []UploadTarget
+	if value == "" {
+		return targets, true
+	}
+	if !validation.JSONList(c, "targets", value, &targets) {
+		return nil, false
+	}
+	if limit := h.processing.Load().MaxTargets; len(targets) > limit {
+		validation.Fail(c, "targets", fmt.Sprintf("targets must have at most %d entries", limit))
+		return nil, false
+	}
+	return targets, true
+}
+
+// parseRenditions validates the comma separated rendition template names. It writes a
+// validation error and returns false if any of them is unknown.
+func (h *ImageHandler) parseRenditions(c *gin.Context, names string) ([]string, bool) {
+	var renditions []string
+	if names == "" {
+		return renditions, true
+	}
+	for _, name := range strings.Split(names, ",") {
+		if _, ok := h.config.Transform.Templates[name]; !ok {
+			reqLogger := logger.FromContext(c.Request.Context())
+			reqLogger.Error().Str("rendition", name).Msg("Unknown rendition template")
+			validation.Fail(c, "renditions", "unknown rendition template: "+name)
+			return nil, false
+		}
+		renditions = append(renditions, name)
+	}
+	return renditions, true
+}
+
 // GetImage retrieves information about an image
 func (h *ImageHandler) GetImage(c *gin.Context) {
 	reqLogger := logger.FromContext(c.Request.Context())
 
 	// Parse the ID from the URL
-	idStr := c.Param("id")
-	id, err := uuid.Parse(idStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+	id, ok := bindImageID(c)
+	if !ok {
 		return
 	}
+	idStr := id.String()
 
 	reqLogger.Info().Str("image_id", idStr).Msg("Processing get image request")
 
-	// Get the image from the database
-	img, err := h.repo.GetImageByID(c.Request.Context(), id)
-	if err != nil {
-		reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to get image")
-		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+	img, ok := h.loadImage(c, id)
+	if !ok {
+		return
+	}
+	response := h.imageResponse(c.Request.Context(), img)
+
+	reqLogger.Info().Str("image_id", idStr).Str("status", string(img.Status)).Msg("Image retrieved successfully")
+
+	// The URLs of private images are issued for this caller only
+	if img.Private() {
+		c.Header("Cache-Control", "private, no-store")
+		c.JSON(http.StatusOK, response)
 		return
 	}
 
+	h.writeCacheable(c, imageETag(img), img.UpdatedAt, response)
+}
+
+// imageResponse builds the representation of img returned by GetImage, with URLs for its
+// objects if the caller may download them. Failing URLs are logged and left out.
+func (h *ImageHandler) imageResponse(ctx context.Context, img *models.Image) *models.ImageResponse {
+	reqLogger := logger.FromContext(ctx)
+	idStr := img.ID.String()
+
 	// Generate URLs for the image
 	var originalURL, optimizedURL string
+	var err error
+	// Keys limited to other operations than downloads only read the metadata
+	downloadable := auth.AllowsOperation(ctx, auth.OpDownload)
 
-	// Generate URL for original image
-	originalURL, err = h.minioClient.GetImageURL(c.Request.Context(), img.OriginalPath, h.config.MinIO.URLExpiry)
-	if err != nil {
-		reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to generate URL for original image")
-		// Continue anyway, as we have stored the original image
+	// Generate URL for original image, unless moderation withheld it
+	if downloadable && !img.Withheld() {
+		originalURL, err = h.objectURL(ctx, minio.ClassOriginal, img, img.OriginalPath)
+		if err != nil {
+			reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to generate URL for original image")
+			// Continue anyway, as we have stored the original image
+		}
 	}
 
 	// Generate URL for optimized image if available
-	if img.Status == models.StatusCompleted && img.OptimizedPath != "" {
-		optimizedURL, err = h.minioClient.GetImageURL(c.Request.Context(), img.OptimizedPath, h.config.MinIO.URLExpiry)
+	if downloadable && img.Status == models.StatusCompleted && img.OptimizedPath != "" {
+		optimizedURL, err = h.optimizedURL(ctx, img, img.OptimizedPath)
 		if err != nil {
 			reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to generate URL for optimized image")
 			// Continue anyway, as we have stored the original image
 		}
 	}
 
+	// Generate URL for the background-removed cut-out if available
+	var cutoutURL string
+	if downloadable && img.CutoutPath != "" && !img.Withheld() {
+		cutoutURL, err = h.objectURL(ctx, minio.ClassOptimized, img, img.CutoutPath)
+		if err != nil {
+			reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to generate URL for cutout image")
+		}
+	}
+
+	// Generate URLs for the additional renditions
+	var renditionURLs map[string]string
+	if downloadable && img.Status == models.StatusCompleted && len(img.Renditions) > 0 && !img.Withheld() {
+		renditionURLs = make(map[string]string, len(img.Renditions))
+		for _, rendition := range img.Renditions {
+			if h.config.CDN.PublicBaseURL != "" && !img.Private() {
+				renditionURLs[rendition.Name] = cdn.PublicURL(h.config.CDN.PublicBaseURL, rendition.Path)
+				continue
+			}
+			url, err := h.objectURL(ctx, minio.ClassOptimized, img, rendition.Path)
+			if err != nil {
+				reqLogger.Error().Err(err).Str("id", idStr).Str("rendition", rendition.Name).Msg("Failed to generate URL for rendition")
+				continue
+			}
+			if url == "" {
+				continue
+			}
+			renditionURLs[rendition.Name] = url
+		}
+	}
+
 	// Calculate size reduction percentage
 	var reduction float64
 	if img.Status == models.StatusCompleted && img.OptimizedSize > 0 && img.OriginalSize > 0 {
@@ -241,22 +844,156 @@ func (h *ImageHandler) GetImage(c *gin.Context) {
 
 	// Create response
 	response := &models.ImageResponse{
-		ID:            img.ID,
-		OriginalName:  img.OriginalName,
-		Status:        img.Status,
-		OriginalURL:   originalURL,
-		OptimizedURL:  optimizedURL,
-		OriginalSize:  img.OriginalSize,
-		OptimizedSize: img.OptimizedSize,
-		Reduction:     reduction,
-		CreatedAt:     img.CreatedAt,
-		UpdatedAt:     img.UpdatedAt,
-		Error:         img.Error,
+		ID:               img.ID,
+		OriginalName:     img.OriginalName,
+		Status:           img.Status,
+		OriginalURL:      originalURL,
+		OptimizedURL:     optimizedURL,
+		CutoutURL:        cutoutURL,
+		OriginalSize:     img.OriginalSize,
+		OptimizedSize:    img.OptimizedSize,
+		Reduction:        reduction,
+		QualityScore:     img.QualityScore,
+		StoredBytes:      img.StoredBytes,
+		CreatedAt:        img.CreatedAt,
+		UpdatedAt:        img.UpdatedAt,
+		Error:            img.Error,
+		Attempts:         img.ProcessingAttempts,
+		Progress:         img.ProcessingProgress,
+		Stage:            img.ProcessingStage,
+		ModerationStatus: img.ModerationStatus,
+		Visibility:       img.Visibility,
+		BlurHash:         img.BlurHash,
+		DominantColors:   img.DominantColors,
+		ExtractedText:    img.ExtractedText,
+		RenditionURLs:    renditionURLs,
 	}
 
-	reqLogger.Info().Str("image_id", idStr).Str("status", string(img.Status)).Msg("Image retrieved successfully")
+	// Generate signed transformation URLs if templates are enabled. They are shareable, so
+	// private images do not get any.
+	if downloadable && h.config.Transform.Enabled && !img.Private() {
+		response.TransformURLs = h.transformURLs(img.ID)
+	}
+
+	return response
+}
+
+// DownloadImage streams the original or optimized image through the API,
+// with support for conditional and range requests
+func (h *ImageHandler) DownloadImage(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	// Parse the ID from the URL
+	id, ok := bindImageID(c)
+	if !ok {
+		return
+	}
+	idStr := id.String()
+
+	var req DownloadImageRequest
+	if !validation.Query(c, &req) {
+		return
+	}
+	variant := req.Variant
+
+	reqLogger.Info().Str("image_id", idStr).Str("variant", variant).Msg("Processing download image request")
+
+	img, ok := h.loadImage(c, id)
+	if !ok {
+		return
+	}
+
+	if img.Withheld() {
+		apierror.Abort(c, apierror.ErrImageWithheld)
+		return
+	}
+
+	objectName, storage := img.OriginalPath, h.minioClient.In(minio.ClassOriginal)
+	filename := img.OriginalName
+	if variant == "optimized" {
+		if img.Status != models.StatusCompleted || img.OptimizedPath == "" {
+			apierror.Abort(c, apierror.ErrVariantNotAvailable)
+			return
+		}
+		objectName, storage = img.OptimizedPath, h.minioClient.In(minio.ClassOptimized)
+
+		// Serve the stored format the client prefers, the optimized image if it accepts none
+		c.Header("Vary", "Accept")
+		formats, paths := storedFormats(img)
+		format := negotiateFormat(c.GetHeader("Accept"), formats)
+		if format == "" {
+			format = img.OriginalFormat
+		}
+		if format != img.OriginalFormat {
+			objectName, filename = paths[format], withFormatExt(img.OriginalName, format)
+		}
+		metrics.NegotiatedFormatsTotal.WithLabelValues("download", format).Inc()
+	}
+
+	info, err := storage.StatImage(c.Request.Context(), objectName)
+	if err != nil {
+		reqLogger.Error().Err(err).Str("id", idStr).Str("object", objectName).Msg("Failed to get image metadata from storage")
+		apierror.Abort(c, apierror.FromStorage(err))
+		return
+	}
+
+	object, err := storage.GetImage(c.Request.Context(), objectName)
+	if err != nil {
+		reqLogger.Error().Err(err).Str("id", idStr).Str("object", objectName).Msg("Failed to get image from storage")
+		apierror.Abort(c, apierror.FromStorage(err))
+		return
+	}
+	defer object.Close()
+
+	c.Header("Content-Type", info.ContentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	if info.ETag != "" {
+		c.Header("ETag", `"`+info.ETag+`"`)
+	}
+
+	// ServeContent handles Range, If-Range and If-None-Match when the object is seekable
+	if seeker, ok := object.(io.ReadSeeker); ok {
+		recorder := &readErrRecorder{ReadSeeker: seeker}
+		http.ServeContent(c.Writer, c.Request, filename, info.LastModified, recorder)
+		h.checkStreamed(c.Request.Context(), img, recorder.err)
+		return
+	}
 
-	c.JSON(http.StatusOK, response)
+	c.Header("Content-Length", strconv.FormatInt(info.Size, 10))
+	c.Status(http.StatusOK)
+	if _, err := io.Copy(c.Writer, object); err != nil {
+		reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to stream image")
+		h.checkStreamed(c.Request.Context(), img, err)
+	}
+}
+
+// readErrRecorder keeps the last read error, which http.ServeContent doesn't report
+type readErrRecorder struct {
+	io.ReadSeeker
+	err error
+}
+
+func (r *readErrRecorder) Read(p []byte) (int, error) {
+	n, err := r.ReadSeeker.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+	return n, err
+}
+
+// checkStreamed flags img as corrupted if streaming one of its objects failed checksum
+// verification. The response is already under way, so the client only sees it cut short.
+func (h *ImageHandler) checkStreamed(ctx context.Context, img *models.Image, err error) {
+	if !errors.Is(err, minio.ErrChecksumMismatch) {
+		return
+	}
+
+	reqLogger := logger.FromContext(ctx)
+	reqLogger.Error().Err(err).Str("image_id", img.ID.String()).Msg("Streamed image failed checksum verification")
+	metrics.IntegrityChecksTotal.WithLabelValues(string(models.IntegrityCorrupted)).Inc()
+	if err := h.repo.UpdateImageIntegrity(ctx, img.ID, models.IntegrityCorrupted, time.Now()); err != nil {
+		reqLogger.Error().Err(err).Str("image_id", img.ID.String()).Msg("Failed to flag corrupted image")
+	}
 }
 
 // ListImages lists all images
@@ -264,30 +1001,36 @@ func (h *ImageHandler) ListImages(c *gin.Context) {
 	reqLogger := logger.FromContext(c.Request.Context())
 
 	// Parse pagination parameters
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-
-	// Validation pagination parameters
-	if limit <= 0 {
-		limit = 10
-	}
-	if limit > 100 {
-		limit = 100
+	var req ListImagesRequest
+	if !validation.Query(c, &req) {
+		return
 	}
-	if page <= 0 {
-		page = 1
+	limit, page := req.Limit, req.Page
+
+	filter := models.ImageFilter{
+		Query:  req.Query,
+		Viewer: auth.Owner(c.Request.Context()),
+		Status: models.ProcessingStatus(req.Status),
 	}
 
-	reqLogger.Info().Int("limit", limit).Int("page", page).Msg("Processing list images request")
+	reqLogger.Info().Int("limit", limit).Int("page", page).Str("query", filter.Query).Str("status", req.Status).Msg("Processing list images request")
 
 	// Calculate offset
 	offset := (page - 1) * limit
 
 	// Get images from the database
-	images, total, err := h.repo.ListImages(c.Request.Context(), limit, offset)
+	images, total, err := h.repo.ListImages(c.Request.Context(), filter, limit, offset)
 	if err != nil {
 		reqLogger.Error().Err(err).Msg("Failed to list images")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list images"})
+		apierror.Abort(c, apierror.FromRepository(err))
+		return
+	}
+
+	// Count the matching images of every status, to find failures at a glance
+	counts, err := h.repo.CountImagesByStatus(c.Request.Context(), filter)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to count images by status")
+		apierror.Abort(c, apierror.FromRepository(err))
 		return
 	}
 
@@ -295,58 +1038,70 @@ func (h *ImageHandler) ListImages(c *gin.Context) {
 	response := &models.ImageListResponse{
 		Images: images,
 		Total:  total,
+		Counts: counts,
 	}
 
 	reqLogger.Info().Int("count", len(images)).Int("total_db", total).Msg("Images listed successfully")
 
-	c.JSON(http.StatusOK, response)
+	h.writeCacheable(c, listETag(images, filter, counts, total, limit, page), time.Time{}, response)
 }
 
-// DeleteImage deletes an image
+// DeleteImage deletes an image. With two-step deletion the first request returns a
+// confirmation token, and the image is purged when it is sent back as ?token=.
 func (h *ImageHandler) DeleteImage(c *gin.Context) {
 	reqLogger := logger.FromContext(c.Request.Context())
 
 	// Parse the ID from the URL
-	idStr := c.Param("id")
-	id, err := uuid.Parse(idStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+	id, ok := bindImageID(c)
+	if !ok {
 		return
 	}
+	idStr := id.String()
 
-	reqLogger.Info().Str("image_id", idStr).Msg("Processing delete image request")
-
-	// Get the image from the database
-	img, err := h.repo.GetImageByID(c.Request.Context(), id)
-	if err != nil {
-		reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to get image")
-		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+	var req DeleteImageRequest
+	if !validation.Query(c, &req) {
 		return
 	}
 
-	// Delete original image from MinIO
-	err = h.minioClient.DeleteImage(c.Request.Context(), img.OriginalPath)
-	if err != nil {
-		reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to delete original image from storage")
-		// Continue anyway, as we want to clean up the database
-		// TODO - consider adding cleanup logic for orphaned images in MinIO
+	reqLogger.Info().Str("image_id", idStr).Bool("confirmation", req.Token != "").Msg("Processing delete image request")
+
+	img, ok := h.loadImage(c, id)
+	if !ok {
+		return
 	}
 
-	// Delete optimized image from MinIO if it exists
-	if img.OptimizedPath != "" && img.OptimizedPath != img.OriginalPath {
-		err = h.minioClient.DeleteImage(c.Request.Context(), img.OptimizedPath)
+	if h.purger.TwoStep() && req.Token == "" {
+		pending, token, err := h.purger.Request(c.Request.Context(), id)
 		if err != nil {
-			reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to delete optimized image from storage")
-			// Continue anyway
-			// TODO - consider adding cleanup logic for orphaned images in MinIO
+			reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to request image deletion")
+			apierror.Abort(c, apierror.FromRepository(err))
+			return
 		}
+
+		reqLogger.Info().Str("image_id", idStr).Time("expires_at", pending.ExpiresAt).Msg("Image deletion pending confirmation")
+
+		c.JSON(http.StatusAccepted, &models.ImageDeletionResponse{
+			Status:        "pending",
+			Mode:          h.purger.Mode(),
+			DeletionToken: token,
+			ExpiresAt:     pending.ExpiresAt,
+		})
+		return
 	}
 
-	// Delete the image from the database
-	err = h.repo.DeleteImage(c.Request.Context(), id)
+	var err error
+	if req.Token != "" {
+		err = h.purger.Confirm(c.Request.Context(), img, req.Token)
+	} else {
+		err = h.purger.Purge(c.Request.Context(), img)
+	}
 	if err != nil {
-		reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to delete image from database")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete image"})
+		if errors.Is(err, deletion.ErrInvalidToken) {
+			apierror.Abort(c, apierror.New(http.StatusForbidden, apierror.CodeInvalidDeletionToken, "Invalid or expired deletion token"))
+			return
+		}
+		reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to delete image")
+		apierror.Abort(c, apierror.FromRepository(err))
 		return
 	}
 
@@ -354,3 +1109,132 @@ func (h *ImageHandler) DeleteImage(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"status": "success"})
 }
+
+// loadImage gets an image and writes an error unless the caller may see it. Private images
+// of other owners are reported as not found.
+func (h *ImageHandler) loadImage(c *gin.Context, id uuid.UUID) (*models.Image, bool) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	img, err := h.repo.GetImageByID(c.Request.Context(), id)
+	if err != nil {
+		reqLogger.Error().Err(err).Str("image_id", id.String()).Msg("Failed to get image")
+		apierror.Abort(c, apierror.FromRepository(err))
+		return nil, false
+	}
+
+	if !img.VisibleTo(auth.Owner(c.Request.Context())) {
+		reqLogger.Warn().Str("image_id", id.String()).Msg("Denied access to private image")
+		apierror.Abort(c, apierror.FromRepository(fmt.Errorf("%w: %s", db.ErrNotFound, id)))
+		return nil, false
+	}
+
+	return img, true
+}
+
+// urlExpiry returns the lifetime of presigned URLs for the objects of img
+func (h *ImageHandler) urlExpiry(img *models.Image) time.Duration {
+	if img.Private() {
+		return h.config.MinIO.PrivateURLExpiry
+	}
+	return h.config.MinIO.URLExpiry
+}
+
+// optimizedURL returns the URL of an optimized object of img, using a stable CDN URL in
+// public mode unless the image is private
+func (h *ImageHandler) optimizedURL(ctx context.Context, img *models.Image, path string) (string, error) {
+	if h.config.CDN.PublicBaseURL != "" && !img.Private() {
+		return cdn.PublicURL(h.config.CDN.PublicBaseURL, path), nil
+	}
+	return h.objectURL(ctx, minio.ClassOptimized, img, path)
+}
+
+// objectURL returns a presigned URL of an object of img, or no URL if objects can't be
+// presigned, as with SSE-C encryption
+func (h *ImageHandler) objectURL(ctx context.Context, class minio.Class, img *models.Image, path string) (string, error) {
+	url, err := h.minioClient.In(class).GetImageURL(ctx, path, h.urlExpiry(img))
+	if errors.Is(err, minio.ErrPresignUnavailable) {
+		return "", nil
+	}
+	return url, err
+}
+
+// transformURLs returns signed transformation URLs for every configured template
+func (h *ImageHandler) transformURLs(id uuid.UUID) map[string]string {
+	expires := time.Now().Add(h.config.Transform.URLExpiry)
+
+	urls := make(map[string]string, len(h.config.Transform.Templates))
+	for name := range h.config.Transform.Templates {
+		urls[name] = h.signer.URL(name, id, expires)
+	}
+
+	return urls
+}
+
+// writeCacheable writes a JSON response with ETag, Last-Modified and Cache-Control headers,
+// answering 304 Not Modified if the client already has the current representation. A zero
+// lastModified leaves out Last-Modified, for responses whose changes it cannot tell.
+func (h *ImageHandler) writeCacheable(c *gin.Context, etag string, lastModified time.Time, body any) {
+	c.Header("ETag", etag)
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+	if maxAge := int(h.config.Cache.HTTPMaxAge.Seconds()); maxAge > 0 {
+		c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+	} else {
+		c.Header("Cache-Control", "no-cache")
+	}
+
+	if notModified(c.Request, etag, lastModified) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.JSON(http.StatusOK, body)
+}
+
+// notModified reports whether the conditional headers of r match the current
+// representation. If-Modified-Since is only evaluated without If-None-Match, as RFC 9110
+// requires, and at the one second precision of HTTP dates.
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		return etagMatches(match, etag)
+	}
+
+	since := r.Header.Get("If-Modified-Since")
+	if since == "" || lastModified.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(since)
+	if err != nil {
+		return false
+	}
+	return !lastModified.Truncate(time.Second).After(t)
+}
+
+// etagMatches reports whether the If-None-Match header value match lists etag, comparing
+// weakly as RFC 9110 requires for If-None-Match
+func etagMatches(match, etag string) bool {
+	for _, candidate := range strings.Split(match, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// imageETag derives an ETag from the image identity and last modification time
+func imageETag(img *models.Image) string {
+	return fmt.Sprintf(`"%s-%d"`, img.ID.String(), img.UpdatedAt.UnixNano())
+}
+
+// listETag derives an ETag from the images on a page, the status counts and the pagination
+// parameters
+func listETag(images []*models.Image, filter models.ImageFilter, counts models.ImageStatusCounts, total, limit, page int) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d:%d:%d:%+v:%+v", total, limit, page, filter, counts)
+	for _, img := range images {
+		fmt.Fprintf(hash, "|%s-%d", img.ID.String(), img.UpdatedAt.UnixNano())
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil))[:32] + `"`
+}
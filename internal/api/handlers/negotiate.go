@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+)
+
+// negotiateFormat returns the format of formats the Accept header prefers, the first of
+// them among equally preferred ones, or "" if it accepts none of them. A missing Accept
+// header accepts every format.
+func negotiateFormat(accept string, formats []string) string {
+	best, bestQuality := "", 0.0
+	for _, format := range formats {
+		if q := acceptQuality(accept, "image/"+format); q > bestQuality {
+			best, bestQuality = format, q
+		}
+	}
+	return best
+}
+
+// acceptQuality returns the quality value the Accept header gives mediaType, from its most
+// specific matching media range, and 0 if mediaType is not acceptable
+func acceptQuality(accept, mediaType string) float64 {
+	if strings.TrimSpace(accept) == "" {
+		return 1
+	}
+
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	quality, specificity := 0.0, -1
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		rangeType := strings.ToLower(strings.TrimSpace(params[0]))
+
+		var matched int
+		switch rangeType {
+		case mediaType:
+			matched = 2
+		case mainType + "/*":
+			matched = 1
+		case "*/*":
+			matched = 0
+		default:
+			continue
+		}
+		if matched < specificity {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(name, "q") {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		quality, specificity = q, matched
+	}
+	return quality
+}
+
+// negotiableFormats returns the formats an image in format can be rendered in for
+// negotiateFormat: its own format, then JPEG
+func negotiableFormats(format string) []string {
+	formats := []string{format}
+	if format != "jpeg" {
+		formats = append(formats, "jpeg")
+	}
+	return formats
+}
+
+// storedFormats returns the objects the optimized image of img is stored as by format, and
+// the formats in the order negotiateFormat prefers them: the format of the optimized image,
+// then the others. Besides the optimized image, they are the
+// renditions of the same size recorded with their format.
+func storedFormats(img *models.Image) ([]string, map[string]string) {
+	paths := map[string]string{img.OriginalFormat: img.OptimizedPath}
+	for _, rendition := range img.Renditions {
+		if rendition.Format == "" || rendition.Width != img.OptimizedWidth || rendition.Height != img.OptimizedHeight {
+			continue
+		}
+		if _, ok := paths[rendition.Format]; !ok {
+			paths[rendition.Format] = rendition.Path
+		}
+	}
+
+	formats := make([]string, 0, len(paths))
+	formats = append(formats, img.OriginalFormat)
+	others := make([]string, 0, len(paths))
+	for format := range paths {
+		if !slices.Contains(formats, format) {
+			others = append(others, format)
+		}
+	}
+	slices.Sort(others)
+	return append(formats, others...), paths
+}
+
+// withFormatExt replaces the extension of name with that of format, "jpg" for JPEG
+func withFormatExt(name, format string) string {
+	ext := format
+	if format == "jpeg" {
+		ext = "jpg"
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)) + "." + ext
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/api/validation"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// eventPollInterval is how often the subscribers of the status events of an image read
+// it again, in case an event was missed
+const eventPollInterval = 5 * time.Second
+
+// StreamImageProgress streams the processing status and progress of an image as
+// server-sent events, one whenever they change, until the image completes or fails
+func (h *ImageHandler) StreamImageProgress(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	id, ok := bindImageID(c)
+	if !ok {
+		return
+	}
+
+	reqLogger.Info().Str("image_id", id.String()).Msg("Processing image progress stream request")
+
+	// Subscribe before reading the image, so a change in between is not missed
+	ctx := c.Request.Context()
+	events := h.events.Subscribe(ctx, id)
+
+	img, ok := h.loadImage(c, id)
+	if !ok {
+		return
+	}
+
+	c.Header("Cache-Control", "no-cache")
+	// Keep reverse proxies from buffering the events
+	c.Header("X-Accel-Buffering", "no")
+
+	ticker := time.NewTicker(eventPollInterval)
+	defer ticker.Stop()
+
+	var last models.ImageProgressEvent
+	for sent := false; ; sent = true {
+		event := models.ImageProgressEvent{
+			Status:   img.Status,
+			Progress: img.ProcessingProgress,
+			Stage:    img.ProcessingStage,
+			Error:    img.Error,
+		}
+		if !sent || event != last {
+			c.SSEvent("progress", event)
+			c.Writer.Flush()
+			last = event
+		}
+		if finished(img.Status) {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			// Progress within the same status is taken from the event, other changes read
+			// the image again for their error
+			if !event.Resync && !event.Deleted && event.Status == img.Status {
+				img.ProcessingProgress, img.ProcessingStage = event.Progress, event.Stage
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		var err error
+		img, err = h.repo.GetImageByID(ctx, id)
+		if err != nil {
+			// The response has started, so the stream can only end
+			reqLogger.Error().Err(err).Str("image_id", id.String()).Msg("Failed to get image for progress stream")
+			return
+		}
+	}
+}
+
+// WaitImage returns the image, as GetImage does, once it completes or fails, or when the
+// timeout elapses with the image still pending or processing
+func (h *ImageHandler) WaitImage(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	id, ok := bindImageID(c)
+	if !ok {
+		return
+	}
+
+	var req WaitImageRequest
+	if !validation.Query(c, &req) {
+		return
+	}
+
+	// Subscribe before reading the image, so a change in between is not missed
+	ctx, cancel := context.WithTimeout(c.Request.Context(), req.Timeout)
+	defer cancel()
+	events := h.events.Subscribe(ctx, id)
+
+	img, ok := h.loadImage(c, id)
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(eventPollInterval)
+	defer ticker.Stop()
+
+wait:
+	for !finished(img.Status) {
+		select {
+		case <-ctx.Done():
+			// The client went away or the request timed out
+			if c.Request.Context().Err() != nil {
+				return
+			}
+			break wait
+		case event := <-events:
+			if !event.Resync && !event.Deleted && !finished(event.Status) {
+				continue
+			}
+		case <-ticker.C:
+		}
+
+		if img, ok = h.loadImage(c, id); !ok {
+			return
+		}
+	}
+
+	reqLogger.Info().Str("image_id", id.String()).Str("status", string(img.Status)).Msg("Returning waited for image")
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, h.imageResponse(c.Request.Context(), img))
+}
+
+// finished reports whether an image stopped processing for good, unless retried
+func finished(status models.ProcessingStatus) bool {
+	return status == models.StatusCompleted || status == models.StatusFailed || status == models.StatusRejected
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/internal/api/validation"
+)
+
+// UploadImageRequest holds the processing options accepted by UploadImage
+type UploadImageRequest struct {
+	MaxWidth         int     `form:"max_width" binding:"omitempty,min=1,max=10000"`
+	MaxHeight        int     `form:"max_height" binding:"omitempty,min=1,max=10000"`
+	Quality          int     `form:"quality" binding:"omitempty,min=1,max=100"`
+	Gravity          string  `form:"gravity" binding:"omitempty,oneof=center faces"`
+	BlurFaces        bool    `form:"blur_faces"`
+	Filter           string  `form:"filter" binding:"omitempty,oneof=lanczos catmullrom box nearest"`
+	Sharpen          float64 `form:"sharpen" binding:"omitempty,min=0,max=10"`
+	TargetSizeKB     int     `form:"target_size_kb" binding:"omitempty,min=1"`
+	MinQuality       int     `form:"min_quality" binding:"omitempty,min=1,max=100"`
+	Renditions       string  `form:"renditions"`
+	ExtractText      bool    `form:"extract_text"`
+	RemoveBackground bool    `form:"remove_background"`
+	// Sync processes small uploads within the request instead of queuing them
+	Sync bool `form:"sync"`
+	// Visibility only applies to new uploads; private images require an API key
+	Visibility string `form:"visibility" binding:"omitempty,oneof=public private"`
+	// Priority low uploads are refused while the processing pipeline is saturated
+	Priority string `form:"priority" binding:"omitempty,oneof=low normal"`
+}
+
+// IssueUploadTokenRequest holds the constraints of the upload token issued by
+// UploadTokenHandler.Issue. Preset holds upload options, such as max_width, by name.
+type IssueUploadTokenRequest struct {
+	ExpiresIn int               `json:"expires_in" binding:"omitempty,min=1"`
+	MaxBytes  int64             `json:"max_bytes" binding:"omitempty,min=1,max=10485760"`
+	Formats   []string          `json:"formats" binding:"omitempty,dive,oneof=jpeg png"`
+	Preset    map[string]string `json:"preset"`
+}
+
+// UploadTarget is an output size and format requested in the targets form field of an
+// upload. The image is fitted within Width x Height.
+type UploadTarget struct {
+	Width   int    `json:"width" binding:"required,min=1,max=10000"`
+	Height  int    `json:"height" binding:"required,min=1,max=10000"`
+	Quality int    `json:"quality" binding:"omitempty,min=1,max=100"`
+	Format  string `json:"format" binding:"omitempty,oneof=jpeg png"`
+}
+
+// ListImagesRequest holds the pagination and filter parameters accepted by ListImages
+type ListImagesRequest struct {
+	Page   int    `form:"page,default=1" binding:"min=1"`
+	Limit  int    `form:"limit,default=10" binding:"min=1,max=100"`
+	Query  string `form:"q" binding:"max=200"`
+	Status string `form:"status" binding:"omitempty,oneof=pending processing completed failed queued_failed rejected"`
+}
+
+// ExportImagesRequest holds the format and filter parameters accepted by ExportImages
+type ExportImagesRequest struct {
+	Format string `form:"format,default=csv" binding:"oneof=csv jsonl"`
+	Query  string `form:"q" binding:"max=200"`
+}
+
+// ArchiveImagesRequest holds the images and variant accepted by ArchiveImages: the listed
+// ids, or else the images matching the filter
+type ArchiveImagesRequest struct {
+	IDs     []uuid.UUID `json:"ids" binding:"max=1000"`
+	Query   string      `json:"q" binding:"max=200"`
+	Status  string      `json:"status" binding:"omitempty,oneof=pending processing completed failed queued_failed rejected"`
+	Variant string      `json:"variant" binding:"omitempty,oneof=original optimized"`
+}
+
+// UsageRequest holds the range of months, as YYYY-MM, accepted by GetUsage
+type UsageRequest struct {
+	From string `form:"from" binding:"omitempty,datetime=2006-01"`
+	To   string `form:"to" binding:"omitempty,datetime=2006-01"`
+}
+
+// ExportUsageRequest holds the month, as YYYY-MM, accepted by ExportUsage
+type ExportUsageRequest struct {
+	Month string `form:"month" binding:"omitempty,datetime=2006-01"`
+}
+
+// DownloadImageRequest holds the parameters accepted by DownloadImage
+type DownloadImageRequest struct {
+	Variant string `form:"variant,default=optimized" binding:"oneof=original optimized"`
+}
+
+// CompareImageRequest holds whether CompareImage returns a side-by-side composite
+type CompareImageRequest struct {
+	Composite bool `form:"composite"`
+}
+
+// WaitImageRequest holds how long WaitImage waits for the image to complete or fail
+type WaitImageRequest struct {
+	Timeout time.Duration `form:"timeout,default=30s" binding:"min=1s,max=5m"`
+}
+
+// SrcsetRequest holds the widths and format accepted by GetImageSrcset
+type SrcsetRequest struct {
+	Widths string `form:"widths" binding:"required"`
+	Format string `form:"format" binding:"omitempty,oneof=jpeg png"`
+}
+
+// RequeueFailedRequest holds the filter accepted by RequeueFailed
+type RequeueFailedRequest struct {
+	Since     time.Duration `form:"since" binding:"min=0"`
+	ErrorLike string        `form:"error_like" binding:"max=200"`
+	Limit     int           `form:"limit,default=1000" binding:"min=1,max=10000"`
+	DryRun    bool          `form:"dry_run"`
+}
+
+// DeleteImageRequest holds the confirmation token of a two-step deletion
+type DeleteImageRequest struct {
+	Token string `form:"token" binding:"max=100"`
+}
+
+// TransformURI holds the path parameters of a signed transformation URL. Path and query
+// parameters are bound separately because binding validates the whole struct.
+type TransformURI struct {
+	Signature string `uri:"signature" binding:"required"`
+	Template  string `uri:"template" binding:"required"`
+	ID        string `uri:"id" binding:"required,uuid"`
+}
+
+// TransformQuery holds the query parameters of a signed transformation URL
+type TransformQuery struct {
+	Expires int64 `form:"expires" binding:"required,min=1"`
+	// DPR scales the dimensions of the template for high density displays, overriding
+	// the Sec-CH-DPR client hint
+	DPR float64 `form:"dpr" binding:"omitempty,gt=0,max=10"`
+}
+
+// ImageVersionURI holds the path parameters of the image version endpoints
+type ImageVersionURI struct {
+	ID      string `uri:"id" binding:"required,uuid"`
+	Version int    `uri:"version" binding:"required,min=1"`
+}
+
+// LogLevelRequest holds the query parameters of the log level override
+type LogLevelRequest struct {
+	Level string `form:"level" binding:"required,oneof=debug info warn error fatal panic"`
+}
+
+// imageURI is the :id path parameter shared by the image endpoints
+type imageURI struct {
+	ID string `uri:"id" binding:"required,uuid"`
+}
+
+// bindImageID validates and parses the :id path parameter
+func bindImageID(c *gin.Context) (uuid.UUID, bool) {
+	var uri imageURI
+	if !validation.URI(c, &uri) {
+		return uuid.Nil, false
+	}
+	return uuid.MustParse(uri.ID), true
+}
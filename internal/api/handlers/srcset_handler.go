@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/api/validation"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/transform"
+	"github.com/not-nullexception/image-optimizer/pkg/optimizer"
+)
+
+// maxSrcsetWidths bounds the widths of a srcset
+const maxSrcsetWidths = 16
+
+// GetImageSrcset returns a srcset of the image in the requested widths. Widths stored as
+// the optimized image or a variant use its URL; the others get a transformation URL that
+// renders them on request. Widths larger than the original are served at its width.
+func (h *ImageHandler) GetImageSrcset(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	id, ok := bindImageID(c)
+	if !ok {
+		return
+	}
+
+	var req SrcsetRequest
+	if !validation.Query(c, &req) {
+		return
+	}
+	widths, err := parseWidths(req.Widths)
+	if err != nil {
+		validation.Fail(c, "widths", err.Error())
+		return
+	}
+
+	img, ok := h.loadImage(c, id)
+	if !ok {
+		return
+	}
+	if img.Withheld() {
+		apierror.Abort(c, apierror.ErrImageWithheld)
+		return
+	}
+
+	format := req.Format
+	if format == "" {
+		format = img.OriginalFormat
+	}
+	stored := storedWidths(img, format)
+	expires := time.Now().Add(h.config.Transform.URLExpiry)
+
+	response := &models.SrcsetResponse{ID: img.ID, Variants: make([]models.SrcsetVariant, 0, len(widths))}
+	candidates := make([]string, 0, len(widths))
+	for _, width := range capWidths(widths, img.OriginalWidth) {
+		variant := models.SrcsetVariant{Format: req.Format}
+		variant.Width, variant.Height = optimizer.FitDimensions(img.OriginalWidth, img.OriginalHeight, width, img.OriginalHeight)
+
+		if path, ok := stored[width]; ok {
+			url, err := h.optimizedURL(c.Request.Context(), img, path)
+			if err != nil {
+				reqLogger.Error().Err(err).Str("image_id", id.String()).Int("width", width).Msg("Failed to generate URL for srcset variant")
+			}
+			variant.URL, variant.Format, variant.Stored = url, format, true
+		}
+
+		// Transformation URLs are shareable, so private images only list stored widths
+		if variant.URL == "" && h.config.Transform.Enabled && !img.Private() {
+			variant.URL = h.signer.URL(transform.WidthTemplate(width, req.Format), img.ID, expires)
+		}
+		if variant.URL == "" {
+			reqLogger.Debug().Str("image_id", id.String()).Int("width", width).Msg("Leaving width without URL out of srcset")
+			continue
+		}
+
+		response.Variants = append(response.Variants, variant)
+		candidates = append(candidates, variant.URL+" "+strconv.Itoa(variant.Width)+"w")
+	}
+	response.Srcset = strings.Join(candidates, ", ")
+
+	reqLogger.Info().Str("image_id", id.String()).Int("widths", len(response.Variants)).Msg("Srcset generated")
+
+	// The URLs expire, and those of private images are issued for this caller only
+	c.Header("Cache-Control", "private, no-store")
+	c.JSON(http.StatusOK, response)
+}
+
+// parseWidths parses the comma-separated widths of a srcset
+func parseWidths(value string) ([]int, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) > maxSrcsetWidths {
+		return nil, fmt.Errorf("at most %d widths are allowed", maxSrcsetWidths)
+	}
+
+	widths := make([]int, 0, len(fields))
+	for _, field := range fields {
+		width, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || width < 1 || width > transform.MaxWidth {
+			return nil, fmt.Errorf("widths must be integers between 1 and %d", transform.MaxWidth)
+		}
+		widths = append(widths, width)
+	}
+	return widths, nil
+}
+
+// capWidths sorts widths and caps them at the original width, without duplicates
+func capWidths(widths []int, originalWidth int) []int {
+	capped := make([]int, 0, len(widths))
+	for _, width := range widths {
+		capped = append(capped, min(width, originalWidth))
+	}
+	slices.Sort(capped)
+	return slices.Compact(capped)
+}
+
+// storedWidths returns the stored objects of img in format by width: the optimized image
+// and its variants
+func storedWidths(img *models.Image, format string) map[int]string {
+	stored := make(map[int]string)
+	if img.Status != models.StatusCompleted {
+		return stored
+	}
+
+	for _, rendition := range img.Renditions {
+		// Variants stored before their format was recorded have the format of the original
+		renditionFormat := rendition.Format
+		if renditionFormat == "" {
+			renditionFormat = img.OriginalFormat
+		}
+		if renditionFormat == format {
+			stored[rendition.Width] = rendition.Path
+		}
+	}
+	if img.OptimizedPath != "" && img.OriginalFormat == format {
+		stored[img.OptimizedWidth] = img.OptimizedPath
+	}
+	return stored
+}
@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+)
+
+// statsDays is how many days of uploads GetStats reports
+const statsDays = 30
+
+type StatsHandler struct {
+	repo   db.Repository
+	config *config.StatsConfig
+}
+
+func NewStatsHandler(repo db.Repository, cfg *config.StatsConfig) *StatsHandler {
+	return &StatsHandler{
+		repo:   repo,
+		config: cfg,
+	}
+}
+
+// GetStats returns aggregate processing statistics for dashboards
+func (h *StatsHandler) GetStats(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	now := time.Now()
+	since := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, -(statsDays - 1))
+
+	stats, err := h.repo.GetImageStats(c.Request.Context(), since, h.config.MaterializedView)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to get image stats")
+		apierror.Abort(c, apierror.FromRepository(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// GetStorageUsage returns the bytes stored in total, per original format and reclaimed by optimization
+func (h *StatsHandler) GetStorageUsage(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	usage, err := h.repo.GetStorageUsage(c.Request.Context())
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to get storage usage")
+		apierror.Abort(c, apierror.FromRepository(err))
+		return
+	}
+
+	metrics.UpdateStorageUsage(usage.TotalBytes)
+
+	c.JSON(http.StatusOK, usage)
+}
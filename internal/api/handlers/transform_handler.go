@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/api/validation"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+	imageprocessor "github.com/not-nullexception/image-optimizer/internal/processor/image"
+	"github.com/not-nullexception/image-optimizer/internal/transform"
+	"github.com/not-nullexception/image-optimizer/internal/usage"
+	"github.com/not-nullexception/image-optimizer/pkg/optimizer"
+)
+
+type TransformHandler struct {
+	repo      db.Repository
+	processor *imageprocessor.Processor
+	signer    *transform.Signer
+	meter     *usage.Meter
+	config    *config.TransformConfig
+	// processing holds the default qualities of width templates
+	processing *config.ProcessingConfig
+}
+
+func NewTransformHandler(repo db.Repository, minioClient minio.Client, meter *usage.Meter, cfg *config.TransformConfig, processing *config.ProcessingConfig) *TransformHandler {
+	return &TransformHandler{
+		repo:       repo,
+		processor:  imageprocessor.New(minioClient),
+		signer:     transform.NewSigner(cfg.SigningKey),
+		meter:      meter,
+		config:     cfg,
+		processing: processing,
+	}
+}
+
+// Serve handles signed transformation requests in the form /t/:signature/:template/:id
+func (h *TransformHandler) Serve(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	var uri TransformURI
+	var query TransformQuery
+	if !validation.URI(c, &uri) || !validation.Query(c, &query) {
+		return
+	}
+
+	signature := uri.Signature
+	templateName := uri.Template
+	idStr := uri.ID
+	id := uuid.MustParse(idStr)
+	expires := time.Unix(query.Expires, 0)
+
+	reqLogger.Info().Str("image_id", idStr).Str("template", templateName).Msg("Processing transformation request")
+
+	if err := h.signer.Verify(signature, templateName, id, expires); err != nil {
+		reqLogger.Warn().Err(err).Str("image_id", idStr).Str("template", templateName).Msg("Rejected transformation request")
+		if errors.Is(err, transform.ErrExpired) {
+			apierror.Abort(c, apierror.ErrURLExpired)
+			return
+		}
+		apierror.Abort(c, apierror.ErrInvalidSignature)
+		return
+	}
+
+	// Templates not configured may be width templates, signed for srcset URLs
+	tmpl, ok := h.config.Templates[templateName]
+	templateWidth, templateFormat, widthTemplate := 0, "", false
+	if !ok {
+		templateWidth, templateFormat, widthTemplate = transform.ParseWidthTemplate(templateName)
+		if !widthTemplate {
+			apierror.Abort(c, apierror.ErrTemplateNotFound)
+			return
+		}
+	}
+
+	img, err := h.repo.GetImageByID(c.Request.Context(), id)
+	if err != nil {
+		reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to get image")
+		apierror.Abort(c, apierror.FromRepository(err))
+		return
+	}
+
+	// Transformation URLs are never issued for private images
+	if img.Private() {
+		apierror.Abort(c, apierror.FromRepository(fmt.Errorf("%w: %s", db.ErrNotFound, id)))
+		return
+	}
+
+	if img.Withheld() {
+		apierror.Abort(c, apierror.ErrImageWithheld)
+		return
+	}
+
+	// Render the format the client prefers, falling back to JPEG if it accepts none
+	format := negotiateFormat(c.GetHeader("Accept"), negotiableFormats(img.OriginalFormat))
+	if format == "" {
+		format = "jpeg"
+	}
+
+	// Width templates keep the aspect ratio of the image and are listed per width in a
+	// srcset, which picks the width for the device pixel ratio itself
+	dpr := 1.0
+	if widthTemplate {
+		tmpl = config.TransformTemplate{MaxWidth: templateWidth, MaxHeight: img.OriginalHeight}
+		if templateFormat != "" {
+			format = templateFormat
+		}
+		tmpl.Quality = h.processing.QualityFor(format)
+	} else {
+		// Scale the template for the device pixel ratio, without exceeding the original
+		dpr = devicePixelRatio(query.DPR, c.GetHeader("Sec-CH-DPR"), h.config.MaxDPR)
+	}
+	maxWidth, maxHeight := scaleDimension(tmpl.MaxWidth, dpr), scaleDimension(tmpl.MaxHeight, dpr)
+	width, height := optimizer.FitDimensions(img.OriginalWidth, img.OriginalHeight, maxWidth, maxHeight)
+	baseWidth, _ := optimizer.FitDimensions(img.OriginalWidth, img.OriginalHeight, tmpl.MaxWidth, tmpl.MaxHeight)
+
+	// The rendered dimensions and format key the response in caches, so the device pixel
+	// ratios capped to the same size share it
+	etag := fmt.Sprintf(`"%s-%d-%s-%dx%d-%s"`, img.ID, img.UpdatedAt.UnixNano(), templateName, width, height, format)
+	cacheHeaders := func() {
+		// Allow caches to keep the derived image until the URL expires
+		maxAge := int(time.Until(expires).Seconds())
+		c.Header("Cache-Control", "public, max-age="+strconv.Itoa(maxAge))
+		c.Header("Vary", "Accept, Sec-CH-DPR")
+		c.Header("ETag", etag)
+		if baseWidth > 0 {
+			c.Header("Content-DPR", strconv.FormatFloat(math.Round(float64(width)/float64(baseWidth)*100)/100, 'f', -1, 64))
+		}
+	}
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		cacheHeaders()
+		c.Status(http.StatusNotModified)
+		return
+	}
+	metrics.NegotiatedFormatsTotal.WithLabelValues("transform", format).Inc()
+
+	result, err := h.processor.Render(c.Request.Context(), img.OriginalPath, imageprocessor.Config{
+		MaxWidth:  maxWidth,
+		MaxHeight: maxHeight,
+		Quality:   tmpl.Quality,
+		Filter:    tmpl.Filter,
+		Sharpen:   tmpl.Sharpen,
+		Format:    format,
+	})
+	if err != nil {
+		reqLogger.Error().Err(err).Str("id", idStr).Str("template", templateName).Msg("Failed to render transformation")
+		apierror.Abort(c, apierror.Internal("Failed to render image", err))
+		return
+	}
+
+	cacheHeaders()
+	reqLogger.Info().
+		Str("image_id", idStr).
+		Str("template", templateName).
+		Str("format", format).
+		Float64("dpr", dpr).
+		Int("width", result.Width).
+		Int("height", result.Height).
+		Int("size", len(result.Data)).
+		Msg("Transformation served")
+	// Transformation URLs carry no API key, so they are billed to the owner of the image
+	h.meter.Record(c.Request.Context(), img.Owner, models.UsageCounts{Transformations: 1})
+
+	c.Data(http.StatusOK, result.ContentType, result.Data)
+}
+
+// dprStep is the step device pixel ratios are rounded up to, bounding the sizes rendered
+// for a template
+const dprStep = 0.5
+
+// devicePixelRatio returns the device pixel ratio to render for: the dpr parameter, or
+// else the Sec-CH-DPR client hint, rounded up to dprStep between 1 and maxDPR
+func devicePixelRatio(param float64, hint string, maxDPR float64) float64 {
+	dpr := param
+	if dpr == 0 {
+		if parsed, err := strconv.ParseFloat(strings.TrimSpace(hint), 64); err == nil && parsed > 0 {
+			dpr = parsed
+		}
+	}
+	dpr = math.Ceil(dpr/dprStep) * dprStep
+	return min(max(dpr, 1), maxDPR)
+}
+
+// scaleDimension scales a template dimension by dpr; 0, no limit, stays 0
+func scaleDimension(dimension int, dpr float64) int {
+	return int(math.Round(float64(dimension) * dpr))
+}
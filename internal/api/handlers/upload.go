@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/api/validation"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+	"github.com/not-nullexception/image-optimizer/internal/auth"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// maxUploadSize is the largest image accepted for upload
+const maxUploadSize = 10 * 1024 * 1024 // 10 MB
+
+// uploadLimit returns the largest image accepted from the caller of ctx: maxUploadSize, or
+// less under the policy of its upload token
+func uploadLimit(ctx context.Context) int64 {
+	if policy := auth.Policy(ctx); policy != nil && policy.MaxBytes > 0 {
+		return min(policy.MaxBytes, maxUploadSize)
+	}
+	return maxUploadSize
+}
+
+// uploadSniffSize is how much of an upload is buffered to detect its MIME type and read
+// its dimensions from the image header. JPEG headers may follow large EXIF and ICC
+// segments, so it is well above the 512 bytes used for MIME detection.
+const uploadSniffSize = 256 * 1024
+
+// errUploadTooLarge is returned while reading an upload past maxUploadSize
+var errUploadTooLarge = errors.New("upload exceeds the maximum size")
+
+// errChecksumMismatch is returned at the end of an upload that does not match the
+// checksum sent by the client
+var errChecksumMismatch = errors.New("upload does not match its checksum")
+
+// errNoImagePart is returned when a multipart upload has no image field
+var errNoImagePart = errors.New("image file is required")
+
+// maxFormFieldSize is the largest form field accepted before the image field
+const maxFormFieldSize = 64 * 1024
+
+// errFormFieldTooLarge is returned when a form field sent before the image field exceeds
+// maxFormFieldSize
+var errFormFieldTooLarge = errors.New("form field exceeds 64KB")
+
+// imagePart returns the image field of a multipart upload and the form fields sent
+// before it, without parsing the rest of the form, so the file is read from the request
+// body as it arrives instead of being buffered in memory or on disk
+func imagePart(r *http.Request) (*multipart.Part, map[string]string, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, errNoImagePart
+	}
+	fields := make(map[string]string)
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil, nil, errNoImagePart
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if part.FormName() == "image" && part.FileName() != "" {
+			return part, fields, nil
+		}
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldSize+1))
+			if err != nil {
+				part.Close()
+				return nil, nil, err
+			}
+			if len(value) > maxFormFieldSize {
+				part.Close()
+				return nil, nil, fmt.Errorf("%w: %s", errFormFieldTooLarge, part.FormName())
+			}
+			fields[part.FormName()] = string(value)
+		}
+		part.Close()
+	}
+}
+
+// uploadChecksums are the digests of an upload sent by the client, nil if not sent
+type uploadChecksums struct {
+	md5    []byte
+	sha256 []byte
+}
+
+// parseUploadChecksums reads the checksums of the image file from the Content-MD5 header,
+// base64 encoded, and the X-Checksum-SHA256 header, hex or base64 encoded. It returns the
+// name of the malformed header on error.
+func parseUploadChecksums(r *http.Request) (uploadChecksums, string, error) {
+	var sums uploadChecksums
+	if value := r.Header.Get("Content-MD5"); value != "" {
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(sum) != md5.Size {
+			return sums, "Content-MD5", errors.New("must be the base64 encoded MD5 of the image")
+		}
+		sums.md5 = sum
+	}
+	if value := r.Header.Get("X-Checksum-SHA256"); value != "" {
+		sum, err := hex.DecodeString(value)
+		if err != nil {
+			sum, err = base64.StdEncoding.DecodeString(value)
+		}
+		if err != nil || len(sum) != sha256.Size {
+			return sums, "X-Checksum-SHA256", errors.New("must be the hex or base64 encoded SHA-256 of the image")
+		}
+		sums.sha256 = sum
+	}
+	return sums, "", nil
+}
+
+// uploadStream reads an upload once on its way to storage, hashing and counting it as
+// it goes. Reading past the size limit fails with errUploadTooLarge, and reaching the end
+// of an upload that does not match the checksums sent by the client fails with
+// errChecksumMismatch, so a storage upload reading it is aborted.
+type uploadStream struct {
+	buffered *bufio.Reader
+	tee      io.Reader
+	sha256   hash.Hash
+	md5      hash.Hash
+	expected uploadChecksums
+	size     int64
+	limit    int64
+	err      error // first error reading the upload
+}
+
+func newUploadStream(r io.Reader, limit int64, expected uploadChecksums) *uploadStream {
+	buffered := bufio.NewReaderSize(r, uploadSniffSize)
+	sha, sum := sha256.New(), md5.New()
+	return &uploadStream{
+		buffered: buffered,
+		tee:      io.TeeReader(buffered, io.MultiWriter(sha, sum)),
+		sha256:   sha,
+		md5:      sum,
+		expected: expected,
+		limit:    limit,
+	}
+}
+
+// sniff returns up to uploadSniffSize bytes of the start of the upload without consuming
+// them
+func (u *uploadStream) sniff() ([]byte, error) {
+	head, err := u.buffered.Peek(uploadSniffSize)
+	if err != nil && !(errors.Is(err, io.EOF) && len(head) > 0) {
+		return nil, err
+	}
+	return head, nil
+}
+
+func (u *uploadStream) Read(p []byte) (int, error) {
+	n, err := u.tee.Read(p)
+	u.size += int64(n)
+	if u.size > u.limit {
+		err = errUploadTooLarge
+	} else if errors.Is(err, io.EOF) && !u.matches() {
+		err = errChecksumMismatch
+	}
+	if err != nil && !errors.Is(err, io.EOF) && u.err == nil {
+		u.err = err
+	}
+	return n, err
+}
+
+// readErr returns the error that stopped reading the upload, if any, to tell it apart
+// from failures of the storage the upload was streamed to
+func (u *uploadStream) readErr() error {
+	return u.err
+}
+
+// matches reports whether the upload read so far matches the checksums sent by the client
+func (u *uploadStream) matches() bool {
+	if u.expected.md5 != nil && !bytes.Equal(u.md5.Sum(nil), u.expected.md5) {
+		return false
+	}
+	if u.expected.sha256 != nil && !bytes.Equal(u.sha256.Sum(nil), u.expected.sha256) {
+		return false
+	}
+	return true
+}
+
+// checksum returns the SHA-256 of the upload read so far, hex encoded
+func (u *uploadStream) checksum() string {
+	return hex.EncodeToString(u.sha256.Sum(nil))
+}
+
+// md5Checksum returns the MD5 of the upload read so far, hex encoded
+func (u *uploadStream) md5Checksum() string {
+	return hex.EncodeToString(u.md5.Sum(nil))
+}
+
+// spooledUpload is an upload copied to a temporary file, for the steps that read it more
+// than once. Close removes the file.
+type spooledUpload struct {
+	*os.File
+}
+
+// spoolUpload copies the rest of the upload to a temporary file, rewound to its start
+func spoolUpload(u *uploadStream) (*spooledUpload, error) {
+	file, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("error creating upload file: %w", err)
+	}
+	spooled := &spooledUpload{File: file}
+
+	if _, err := io.Copy(file, u); err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		spooled.Close()
+		return nil, fmt.Errorf("error rewinding upload file: %w", err)
+	}
+	return spooled, nil
+}
+
+// Close closes and removes the temporary file
+func (s *spooledUpload) Close() error {
+	err := s.File.Close()
+	if removeErr := os.Remove(s.Name()); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+		err = errors.Join(err, removeErr)
+	}
+	return err
+}
+
+// failUploadRead rejects an upload that could not be read
+func failUploadRead(c *gin.Context, filename string, err error) {
+	reqLogger := logger.FromContext(c.Request.Context())
+	if errors.Is(err, errUploadTooLarge) {
+		reqLogger.Error().Str("filename", filename).Msg("File too large")
+		if limit := uploadLimit(c.Request.Context()); limit < maxUploadSize {
+			validation.Fail(c, "image", fmt.Sprintf("image must be at most %d bytes", limit))
+			return
+		}
+		validation.Fail(c, "image", "image must be at most 10MB")
+		return
+	}
+	if errors.Is(err, errChecksumMismatch) {
+		reqLogger.Warn().Str("filename", filename).Msg("Upload does not match its checksum")
+		validation.FailWithCode(c, apierror.CodeChecksumMismatch, "image", "image does not match the checksum sent with it")
+		return
+	}
+	if bodyErr := apierror.FromRequestBody(err); bodyErr != nil {
+		reqLogger.Warn().Err(err).Str("filename", filename).Msg("Rejected upload body")
+		apierror.Abort(c, bodyErr)
+		return
+	}
+	reqLogger.Error().Err(err).Str("filename", filename).Msg("Failed to read uploaded file")
+	validation.Fail(c, "image", "image could not be read")
+}
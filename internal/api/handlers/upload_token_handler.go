@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/api/validation"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+	"github.com/not-nullexception/image-optimizer/internal/auth"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// uploadPath is where upload tokens are used
+const uploadPath = "/api/v1/images"
+
+type UploadTokenHandler struct {
+	tokens *auth.UploadTokens
+	config *config.UploadTokenConfig
+}
+
+func NewUploadTokenHandler(tokens *auth.UploadTokens, cfg *config.UploadTokenConfig) *UploadTokenHandler {
+	return &UploadTokenHandler{
+		tokens: tokens,
+		config: cfg,
+	}
+}
+
+// Issue returns a signed token allowing a single upload on behalf of the caller, so
+// browsers can upload without holding its API key. The token carries the constraints of
+// the request: its lifetime, the largest size and the formats accepted, and a preset of
+// upload options applied to the upload.
+func (h *UploadTokenHandler) Issue(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	owner := auth.Owner(c.Request.Context())
+	if owner == "" {
+		reqLogger.Warn().Msg("Rejected anonymous upload token request")
+		apierror.Abort(c, apierror.ErrUnauthorized)
+		return
+	}
+
+	var req IssueUploadTokenRequest
+	if !validation.JSON(c, &req) {
+		return
+	}
+
+	// The preset is checked as the upload will bind it, so a bad preset fails here
+	preset := make(url.Values, len(req.Preset))
+	for name, value := range req.Preset {
+		preset.Set(name, value)
+	}
+	if !validation.Values(c, "preset", preset, &UploadImageRequest{}) {
+		return
+	}
+
+	ttl := h.config.TTL
+	if req.ExpiresIn > 0 {
+		ttl = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if ttl > h.config.MaxTTL {
+		validation.Fail(c, "expires_in", "expires_in must be at most "+h.config.MaxTTL.String())
+		return
+	}
+
+	policy := &auth.UploadPolicy{
+		ID:        uuid.New(),
+		Owner:     owner,
+		MaxBytes:  req.MaxBytes,
+		Formats:   req.Formats,
+		Preset:    preset.Encode(),
+		ExpiresAt: time.Now().Add(ttl).Truncate(time.Second),
+	}
+	token, err := h.tokens.Issue(policy)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to issue upload token")
+		apierror.Abort(c, apierror.Internal("Failed to issue upload token", err))
+		return
+	}
+
+	reqLogger.Info().
+		Str("token_id", policy.ID.String()).
+		Time("expires_at", policy.ExpiresAt).
+		Int64("max_bytes", policy.MaxBytes).
+		Strs("formats", policy.Formats).
+		Msg("Upload token issued")
+
+	c.JSON(http.StatusCreated, &models.UploadTokenResponse{
+		Token:     token,
+		ExpiresAt: policy.ExpiresAt,
+		UploadURL: uploadPath,
+	})
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/api/validation"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+	"github.com/not-nullexception/image-optimizer/internal/auth"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// usageMonths is how many months of usage GetUsage reports by default, the current one
+// included
+const usageMonths = 12
+
+// usageMonthLayout is the layout of the months accepted and exported by the usage endpoints
+const usageMonthLayout = "2006-01"
+
+// usageColumns is the CSV header of a usage export
+var usageColumns = []string{
+	"owner", "month", "uploads", "processed_bytes", "transformations", "storage_byte_days", "storage_gb_days",
+}
+
+type UsageHandler struct {
+	repo db.Repository
+}
+
+func NewUsageHandler(repo db.Repository) *UsageHandler {
+	return &UsageHandler{
+		repo: repo,
+	}
+}
+
+// GetUsage returns the monthly usage of the calling API key owner
+func (h *UsageHandler) GetUsage(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	var req UsageRequest
+	if !validation.Query(c, &req) {
+		return
+	}
+
+	owner := auth.Owner(c.Request.Context())
+	if owner == "" {
+		apierror.Abort(c, apierror.ErrUnauthorized)
+		return
+	}
+
+	now := time.Now().UTC()
+	filter := models.UsageFilter{
+		Owner: owner,
+		From:  time.Date(now.Year(), now.Month()-(usageMonths-1), 1, 0, 0, 0, 0, time.UTC),
+		To:    now,
+	}
+	if req.From != "" {
+		filter.From, _ = time.Parse(usageMonthLayout, req.From)
+	}
+	if req.To != "" {
+		filter.To, _ = time.Parse(usageMonthLayout, req.To)
+	}
+
+	months, err := h.repo.ListMonthlyUsage(c.Request.Context(), filter)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to get usage")
+		apierror.Abort(c, apierror.FromRepository(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, &models.UsageResponse{
+		Owner:  owner,
+		Months: months,
+	})
+}
+
+// ExportUsage streams the usage of every owner over one month, the previous one by
+// default, as CSV for invoicing
+func (h *UsageHandler) ExportUsage(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	var req ExportUsageRequest
+	if !validation.Query(c, &req) {
+		return
+	}
+
+	now := time.Now().UTC()
+	month := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+	if req.Month != "" {
+		month, _ = time.Parse(usageMonthLayout, req.Month)
+	}
+
+	usage, err := h.repo.ListMonthlyUsage(c.Request.Context(), models.UsageFilter{From: month, To: month})
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to get usage for export")
+		apierror.Abort(c, apierror.FromRepository(err))
+		return
+	}
+
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="usage-%s.csv"`, month.Format(usageMonthLayout)))
+
+	w := csv.NewWriter(c.Writer)
+	if err := w.Write(usageColumns); err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to write usage export")
+		return
+	}
+	for _, u := range usage {
+		err := w.Write([]string{
+			csvText(u.Owner), u.Month.Format(usageMonthLayout), strconv.FormatInt(u.Uploads, 10),
+			strconv.FormatInt(u.ProcessedBytes, 10), strconv.FormatInt(u.Transformations, 10),
+			strconv.FormatInt(u.StorageByteDays, 10), strconv.FormatFloat(float64(u.StorageByteDays)/1e9, 'f', 3, 64),
+		})
+		if err != nil {
+			reqLogger.Error().Err(err).Msg("Failed to write usage export")
+			return
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to write usage export")
+		return
+	}
+
+	reqLogger.Info().Str("month", month.Format(usageMonthLayout)).Int("owners", len(usage)).Msg("Usage exported successfully")
+}
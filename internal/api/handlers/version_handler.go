@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/internal/api/validation"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+	"github.com/not-nullexception/image-optimizer/internal/auth"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// ListImageVersions returns the optimized versions of an image, newest first
+func (h *ImageHandler) ListImageVersions(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	id, ok := bindImageID(c)
+	if !ok {
+		return
+	}
+
+	img, ok := h.loadImage(c, id)
+	if !ok {
+		return
+	}
+
+	versions, err := h.repo.ListImageVersions(c.Request.Context(), id)
+	if err != nil {
+		reqLogger.Error().Err(err).Str("image_id", id.String()).Msg("Failed to list image versions")
+		apierror.Abort(c, apierror.FromRepository(err))
+		return
+	}
+
+	response := &models.ImageVersionListResponse{
+		Versions: make([]*models.ImageVersionResponse, 0, len(versions)),
+	}
+	for _, version := range versions {
+		response.Versions = append(response.Versions, h.versionResponse(c, img, version))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// PromoteImageVersion makes an older version the current optimized image again
+func (h *ImageHandler) PromoteImageVersion(c *gin.Context) {
+	reqLogger := logger.FromContext(c.Request.Context())
+
+	var uri ImageVersionURI
+	if !validation.URI(c, &uri) {
+		return
+	}
+	id := uuid.MustParse(uri.ID)
+
+	reqLogger.Info().Str("image_id", id.String()).Int("version", uri.Version).Msg("Processing promote image version request")
+
+	img, ok := h.loadImage(c, id)
+	if !ok {
+		return
+	}
+	// A run in progress would replace the promoted version when it completes
+	if img.Status == models.StatusProcessing {
+		apierror.Abort(c, apierror.ErrImageProcessing)
+		return
+	}
+
+	if err := h.repo.PromoteImageVersion(c.Request.Context(), id, uri.Version); err != nil {
+		reqLogger.Error().Err(err).Str("image_id", id.String()).Int("version", uri.Version).Msg("Failed to promote image version")
+		apierror.Abort(c, apierror.FromRepository(err))
+		return
+	}
+
+	reqLogger.Info().Str("image_id", id.String()).Int("version", uri.Version).Msg("Image version promoted")
+
+	c.JSON(http.StatusOK, gin.H{"status": "success", "version": uri.Version})
+}
+
+// versionResponse converts a version of img to its API representation
+func (h *ImageHandler) versionResponse(c *gin.Context, img *models.Image, version *models.ImageVersion) *models.ImageVersionResponse {
+	response := &models.ImageVersionResponse{
+		Version:      version.Version,
+		Current:      version.Path == img.OptimizedPath,
+		Size:         version.Size,
+		Width:        version.Width,
+		Height:       version.Height,
+		QualityScore: version.QualityScore,
+		CreatedAt:    version.CreatedAt,
+	}
+
+	if !img.Withheld() && auth.AllowsOperation(c.Request.Context(), auth.OpDownload) {
+		url, err := h.optimizedURL(c.Request.Context(), img, version.Path)
+		if err != nil {
+			reqLogger := logger.FromContext(c.Request.Context())
+			reqLogger.Error().Err(err).Str("image_id", img.ID.String()).Int("version", version.Version).Msg("Failed to generate URL for image version")
+		}
+		response.URL = url
+	}
+
+	return response
+}
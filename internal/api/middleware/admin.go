@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"crypto/subtle"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+)
+
+// AdminAuth requires the admin token as a bearer token
+func AdminAuth(token string) gin.HandlerFunc {
+	expected := []byte("Bearer " + token)
+	return func(c *gin.Context) {
+		if subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), expected) != 1 {
+			apierror.Abort(c, apierror.ErrUnauthorized)
+			return
+		}
+		c.Next()
+	}
+}
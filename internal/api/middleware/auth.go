@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+	"github.com/not-nullexception/image-optimizer/internal/auth"
+)
+
+// Authenticate identifies the caller by the API key in the X-API-Key header or a bearer
+// token. Requests without a key continue anonymously; an unknown key is rejected.
+func Authenticate(keys *auth.Keys) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader("X-API-Key")
+		if key == "" {
+			key, _ = strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		}
+		if key == "" {
+			c.Next()
+			return
+		}
+
+		owner, ok := keys.Owner(key)
+		if !ok {
+			apierror.Abort(c, apierror.ErrUnauthorized)
+			return
+		}
+
+		ctx := auth.ToContext(c.Request.Context(), owner)
+		if limits := keys.Limits(owner); limits != nil {
+			ctx = auth.WithLimits(ctx, limits)
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// Allow rejects callers whose API key is limited to other operations than operation
+func Allow(operation string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !auth.AllowsOperation(c.Request.Context(), operation) {
+			apierror.Abort(c, apierror.ErrOperationNotAllowed)
+			return
+		}
+		c.Next()
+	}
+}
+
+// UploadToken authenticates uploads by the upload token in the X-Upload-Token header or the
+// upload_token query parameter, on behalf of the owner it was issued to and under the limits
+// of the owner's key. Requests without a token continue as they are; tokens is nil when
+// upload tokens are disabled, rejecting every token.
+func UploadToken(tokens *auth.UploadTokens, keys *auth.Keys) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader("X-Upload-Token")
+		if token == "" {
+			token = c.Query("upload_token")
+		}
+		if token == "" {
+			c.Next()
+			return
+		}
+		if tokens == nil {
+			apierror.Abort(c, apierror.ErrInvalidUploadToken)
+			return
+		}
+
+		policy, err := tokens.Verify(token)
+		if err != nil {
+			apierror.Abort(c, apierror.ErrInvalidUploadToken)
+			return
+		}
+
+		ctx := auth.ToContext(c.Request.Context(), policy.Owner)
+		if limits := keys.Limits(policy.Owner); limits != nil {
+			ctx = auth.WithLimits(ctx, limits)
+		}
+		c.Request = c.Request.WithContext(auth.WithPolicy(ctx, policy))
+		c.Next()
+	}
+}
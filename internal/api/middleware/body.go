@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+)
+
+// BodyLimit rejects requests that declare a body larger than maxBytes with 413 before
+// anything is read, and caps bodies of unknown length while they are read. Handlers map
+// the resulting read error with apierror.FromRequestBody.
+func BodyLimit(maxBytes int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > maxBytes {
+			apierror.Abort(c, apierror.ErrPayloadTooLarge)
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	}
+}
+
+// SlowClient times out request bodies that arrive slower than minRate bytes per second,
+// after an initial grace period, so slow uploads cannot hold connections open. Reads past
+// the deadline fail and handlers answer 408 through apierror.FromRequestBody. The read
+// deadline is cleared once the request is done.
+func SlowClient(minRate int64, grace time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if minRate > 0 && c.Request.Body != nil && c.Request.Body != http.NoBody {
+			controller := http.NewResponseController(c.Writer)
+			defer func() { _ = controller.SetReadDeadline(time.Time{}) }()
+			c.Request.Body = &minRateBody{
+				ReadCloser: c.Request.Body,
+				controller: controller,
+				start:      time.Now(),
+				minRate:    minRate,
+				grace:      grace,
+			}
+		}
+		c.Next()
+	}
+}
+
+// minRateBody moves the connection read deadline along with the bytes read, so the body
+// must keep arriving at minRate on average
+type minRateBody struct {
+	io.ReadCloser
+	controller *http.ResponseController
+	start      time.Time
+	read       int64
+	minRate    int64
+	grace      time.Duration
+	// deadline, set by Timeout, is the latest read deadline of the request
+	deadline time.Time
+}
+
+func (b *minRateBody) Read(p []byte) (int, error) {
+	allowed := time.Duration(float64(b.read+int64(len(p))) / float64(b.minRate) * float64(time.Second))
+	readDeadline := b.start.Add(b.grace + allowed)
+	if !b.deadline.IsZero() && b.deadline.Before(readDeadline) {
+		readDeadline = b.deadline
+	}
+	// Not every connection supports deadlines (e.g. HTTP/2 before Go 1.20); the route
+	// timeouts still cancel the request context of those
+	_ = b.controller.SetReadDeadline(readDeadline)
+
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	return n, err
+}
@@ -31,6 +31,6 @@ func Metrics() gin.HandlerFunc {
 		metrics.RequestsTotal.WithLabelValues(method, path, status).Inc()
 
 		// Track request duration
-		metrics.RequestDuration.WithLabelValues(method, path).Observe(duration)
+		metrics.RecordRequestDuration(c.Request.Context(), method, path, duration)
 	}
 }
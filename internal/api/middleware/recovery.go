@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+	"github.com/not-nullexception/image-optimizer/internal/errreport"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Recovery recovers panics in later handlers and answers with a 500 problem response.
+// The panic is logged with its stack trace, recorded on the active span, counted in
+// image_optimizer_panics_total and reported to the error tracker if reporter is not nil.
+// Panics caused by clients that went away are only logged.
+func Recovery(reporter errreport.Reporter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			recovered := recover()
+			if recovered == nil {
+				return
+			}
+
+			ctx := c.Request.Context()
+			reqLogger := logger.FromContext(ctx)
+
+			err, ok := recovered.(error)
+			if !ok {
+				err = fmt.Errorf("%v", recovered)
+			}
+
+			if clientGone(err) {
+				reqLogger.Warn().Err(err).Str("path", c.Request.URL.Path).Msg("Client disconnected")
+				c.Abort()
+				return
+			}
+
+			reqLogger.Error().
+				Str("panic", fmt.Sprint(recovered)).
+				Str("method", c.Request.Method).
+				Str("path", c.Request.URL.Path).
+				Str("stack", string(debug.Stack())).
+				Msg("Recovered from panic")
+
+			span := trace.SpanFromContext(ctx)
+			span.RecordError(err, trace.WithStackTrace(true))
+			span.SetStatus(codes.Error, "panic")
+
+			metrics.PanicsTotal.WithLabelValues("api").Inc()
+
+			if reporter != nil {
+				reporter.CapturePanic(ctx, recovered, requestTags(c))
+			}
+
+			// The response can't be replaced once the handler started writing it
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			apierror.Abort(c, apierror.Internal("Internal server error", err))
+		}()
+
+		c.Next()
+	}
+}
+
+// clientGone reports whether a panic was caused by the client closing the connection
+func clientGone(err error) bool {
+	return errors.Is(err, http.ErrAbortHandler) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/errreport"
+)
+
+// ReportErrors reports the errors behind the 5xx responses of later handlers, which
+// attach them to the context through apierror.Abort. It must come after Recovery so
+// panics are reported only once.
+func ReportErrors(reporter errreport.Reporter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if c.Writer.Status() < http.StatusInternalServerError {
+			return
+		}
+		last := c.Errors.Last()
+		if last == nil {
+			return
+		}
+
+		tags := requestTags(c)
+		tags["status"] = strconv.Itoa(c.Writer.Status())
+		reporter.CaptureError(c.Request.Context(), last.Err, tags)
+	}
+}
+
+// requestTags describes the request in error reports
+func requestTags(c *gin.Context) map[string]string {
+	tags := map[string]string{
+		"component": "api",
+		"method":    c.Request.Method,
+		"route":     c.FullPath(),
+	}
+	if id := c.Param("id"); id != "" {
+		tags["image_id"] = id
+	}
+	return tags
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+)
+
+// timeoutWriteGrace leaves room to write the 504 after the request deadline
+const timeoutWriteGrace = 5 * time.Second
+
+// Timeout bounds the route it is applied to: the request context is cancelled after
+// timeout and the request answers 504 unless the handler already responded. It also
+// sets the connection deadlines, so slow bodies and stalled responses are cut off with
+// the request. The deadlines are cleared once the request is done, so a later request on
+// the same keep-alive connection doesn't inherit them. A timeout of 0 leaves the route
+// unbounded.
+func Timeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if timeout <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+
+		deadline, _ := ctx.Deadline()
+		controller := http.NewResponseController(c.Writer)
+		defer resetDeadlines(controller)
+		_ = controller.SetWriteDeadline(deadline.Add(timeoutWriteGrace))
+		if body, ok := c.Request.Body.(*minRateBody); ok {
+			// SlowClient moves the read deadline itself; keep it within the request's
+			body.deadline = deadline
+		} else {
+			_ = controller.SetReadDeadline(deadline)
+		}
+
+		c.Next()
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) && !c.Writer.Written() {
+			apierror.Abort(c, apierror.ErrDeadlineExceeded)
+		}
+	}
+}
+
+// resetDeadlines clears the connection deadlines set for a request
+func resetDeadlines(controller *http.ResponseController) {
+	_ = controller.SetReadDeadline(time.Time{})
+	_ = controller.SetWriteDeadline(time.Time{})
+}
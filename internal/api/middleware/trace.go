@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TraceErrors marks the span of requests answered with a 4xx or 5xx status as failed, with
+// the status, the error code and the image of the request as attributes, so traces of
+// failed requests can be filtered in the tracing backend. The tracing middleware only
+// marks server errors. It must come after the tracing middleware and before Recovery, so
+// recovered panics are marked too.
+func TraceErrors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		status := c.Writer.Status()
+		if status < http.StatusBadRequest {
+			return
+		}
+		span := trace.SpanFromContext(c.Request.Context())
+		if !span.IsRecording() {
+			return
+		}
+
+		attrs := []attribute.KeyValue{attribute.Int("status_code", status)}
+		if code := apierror.ResponseCode(c); code != "" {
+			attrs = append(attrs, attribute.String("error_code", string(code)))
+		}
+		if id := c.Param("id"); id != "" {
+			attrs = append(attrs, attribute.String("image_id", id))
+		}
+		span.SetAttributes(attrs...)
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
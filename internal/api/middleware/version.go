@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+)
+
+// CurrentAPIVersion is the API version served under /api/v1
+const CurrentAPIVersion = "1"
+
+// apiVersionKey is the gin context key holding the negotiated API version
+const apiVersionKey = "api_version"
+
+// vendorMediaType matches versioned Accept values such as application/vnd.image-optimizer.v1+json
+var vendorMediaType = regexp.MustCompile(`application/vnd\.image-optimizer\.v(\d+)\+json`)
+
+// APIVersion negotiates the API version from the API-Version header or a versioned
+// Accept media type, defaulting to version. Requests for a version other than the one
+// served by the route group are rejected with 406.
+func APIVersion(version string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requested := c.GetHeader("API-Version")
+		if requested == "" {
+			if m := vendorMediaType.FindStringSubmatch(c.GetHeader("Accept")); m != nil {
+				requested = m[1]
+			}
+		}
+
+		if requested != "" && strings.TrimPrefix(requested, "v") != version {
+			apierror.Abort(c, apierror.New(http.StatusNotAcceptable, apierror.CodeUnsupportedAPIVersion,
+				"API version "+requested+" is not supported on this route, supported version is "+version))
+			return
+		}
+
+		c.Set(apiVersionKey, version)
+		c.Header("API-Version", version)
+		c.Next()
+	}
+}
+
+// RequestedAPIVersion returns the API version negotiated for the request
+func RequestedAPIVersion(c *gin.Context) string {
+	return c.GetString(apiVersionKey)
+}
+
+// Deprecated marks every response of a route group as deprecated, pointing clients to
+// the same path under successorPrefix. A zero sunset omits the Sunset header.
+func Deprecated(prefix, successorPrefix string, sunset time.Time) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Deprecation", "true")
+		if !sunset.IsZero() {
+			c.Header("Sunset", sunset.UTC().Format(http.TimeFormat))
+		}
+		successor := successorPrefix + strings.TrimPrefix(c.Request.URL.Path, prefix)
+		c.Header("Link", "<"+successor+`>; rel="successor-version"`)
+		c.Next()
+	}
+}
@@ -6,10 +6,21 @@ import (
 	"github.com/not-nullexception/image-optimizer/config"
 	"github.com/not-nullexception/image-optimizer/internal/api/handlers"
 	"github.com/not-nullexception/image-optimizer/internal/api/middleware" // Certifique-se que ambos os middlewares estão aqui
+	"github.com/not-nullexception/image-optimizer/internal/auth"
+	"github.com/not-nullexception/image-optimizer/internal/backpressure"
 	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/errreport"
+	"github.com/not-nullexception/image-optimizer/internal/events"
+	"github.com/not-nullexception/image-optimizer/internal/faults"
+	"github.com/not-nullexception/image-optimizer/internal/gc"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
 	"github.com/not-nullexception/image-optimizer/internal/minio"
+	"github.com/not-nullexception/image-optimizer/internal/outbox"
 	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue" // Use o nome correto do seu pacote
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/not-nullexception/image-optimizer/internal/reload"
+	"github.com/not-nullexception/image-optimizer/internal/retry"
+	"github.com/not-nullexception/image-optimizer/internal/selftest"
+	"github.com/not-nullexception/image-optimizer/internal/usage"
 	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
@@ -18,6 +29,13 @@ func Setup(
 	repository db.Repository,
 	minioClient minio.Client,
 	queueClient rabbitmq.Client, // Use o nome correto do seu pacote
+	reporter errreport.Reporter,
+	reloader *reload.Reloader,
+	collector *gc.Collector,
+	injector *faults.Injector,
+	bus *events.Bus,
+	monitor *backpressure.Monitor,
+	meter *usage.Meter,
 ) *gin.Engine {
 	// Set Gin mode
 	gin.SetMode(cfg.Server.Mode)
@@ -30,53 +48,176 @@ func Setup(
 	// 1. Tracing (se habilitado) - DEVE VIR PRIMEIRO
 	if cfg.Tracing.Enabled {
 		r.Use(otelgin.Middleware(cfg.Tracing.ServiceName))
+		// Failed requests, client errors included, are marked on their span
+		r.Use(middleware.TraceErrors())
 	}
 
-	// 2. Logger Contextual - DEVE VIR DEPOIS do Tracing
+	// 2. Request ID - DEVE VIR ANTES do Logger Contextual
+	r.Use(middleware.RequestID())
+
+	// 3. Logger Contextual - DEVE VIR DEPOIS do Tracing e do Request ID
 	//    Ele usará o trace_id/span_id se o tracing estiver habilitado.
 	r.Use(middleware.ContextualLogger("api")) // Fornece um componente padrão
 
-	// 3. Recuperação de Panics
-	r.Use(gin.Recovery())
+	// 4. Recuperação de Panics, com stack trace no log, no span e no error tracker
+	r.Use(middleware.Recovery(reporter))
+
+	// Report the errors behind 5xx responses, after Recovery so panics are reported once
+	if reporter != nil {
+		r.Use(middleware.ReportErrors(reporter))
+	}
 
-	// 4. CORS
+	// 5. CORS
 	r.Use(middleware.CORS()) // Assumindo que você tem esse middleware
 
-	// 5. Métricas (se habilitado)
+	// 6. Métricas (se habilitado)
 	if cfg.Metrics.Enabled {
 		r.Use(middleware.Metrics()) // Mantém o middleware de métricas separado
 	}
 
-	// 6. Opcional: Logger padrão do Gin (se ainda desejar)
+	// Body size limit and slow upload protection, before any handler reads the body
+	r.Use(middleware.BodyLimit(cfg.Server.MaxBodyBytes))
+	r.Use(middleware.SlowClient(cfg.Server.MinBodyRate, cfg.Server.BodyReadGrace))
+
+	// 7. Opcional: Logger padrão do Gin (se ainda desejar)
 	// r.Use(gin.Logger())
 
 	// --- Criar Handlers (injeção de dependência) ---
 	// Certifique-se que os handlers agora NÃO recebem/usam um logger diretamente
-	imageHandler := handlers.NewImageHandler(repository, minioClient, queueClient, cfg)
-	healthHandler := handlers.NewHealthHandler(repository)
+	imageHandler := handlers.NewImageHandler(repository, minioClient, queueClient, bus, monitor, meter, cfg)
+	healthHandler := handlers.NewHealthHandler(repository, minioClient, queueClient)
+	transformHandler := handlers.NewTransformHandler(repository, minioClient, meter, &cfg.Transform, &cfg.Processing)
+	statsHandler := handlers.NewStatsHandler(repository, &cfg.Stats)
+	selfTest := selftest.NewRunner(repository, minioClient, queueClient, cfg)
+	retrier := retry.NewRetrier(repository, outbox.NewRelay(repository, queueClient, &cfg.Outbox), &cfg.Retry)
+	adminHandler := handlers.NewAdminHandler(minioClient, reloader, collector, cfg.GC.Mode, selfTest, retrier)
+	usageHandler := handlers.NewUsageHandler(repository)
+
+	// Handlers holding reloadable settings follow configuration reloads
+	reloader.Register(imageHandler)
 
 	// --- Rotas ---
-	// Health check
-	r.GET("/health", healthHandler.Check) // Assumindo que o método é Check
+	// Each route gets the timeout of its kind, see config.TimeoutConfig
+	timeouts := &cfg.Server.Timeouts
+	read := middleware.Timeout(timeouts.Read)
+	write := middleware.Timeout(timeouts.Write)
+
+	// Health checks
+	r.GET("/livez", read, healthHandler.Live)
+	r.GET("/readyz", read, healthHandler.Ready)
+	r.GET("/health", read, healthHandler.Ready) // Mantido por compatibilidade
 
 	// Metrics endpoint (se habilitado)
 	if cfg.Metrics.Enabled {
-		r.GET(cfg.Observability.MetricsEndpoint, gin.WrapH(promhttp.Handler()))
+		r.GET(cfg.Observability.MetricsEndpoint, gin.WrapH(metrics.Handler()))
 	}
 
-	// API routes
-	api := r.Group("/api")
-	{
-		// Image routes
-		images := api.Group("/images")
-		{
-			images.POST("", imageHandler.UploadImage)
-			images.GET("", imageHandler.ListImages)
-			images.GET("/:id", imageHandler.GetImage)
-			images.DELETE("/:id", imageHandler.DeleteImage)
+	// Signed transformation templates
+	if cfg.Transform.Enabled {
+		r.GET("/t/:signature/:template/:id", middleware.Timeout(timeouts.Stream), transformHandler.Serve)
+	}
+
+	// Versioned API routes
+	keys := auth.NewKeys(cfg.Server.APIKeys, cfg.Server.KeyLimits)
+
+	// Browsers upload with single-use upload tokens issued to API key owners
+	var uploadTokens *auth.UploadTokens
+	var uploadTokenHandler *handlers.UploadTokenHandler
+	if cfg.UploadTokens.Enabled {
+		uploadTokens = auth.NewUploadTokens(cfg.UploadTokens.SigningKey)
+		uploadTokenHandler = handlers.NewUploadTokenHandler(uploadTokens, &cfg.UploadTokens)
+	}
+	uploadAuth := middleware.UploadToken(uploadTokens, keys)
+
+	v1 := r.Group("/api/v1",
+		middleware.APIVersion(middleware.CurrentAPIVersion),
+		middleware.Authenticate(keys),
+	)
+	registerAPIRoutes(v1, timeouts, imageHandler, statsHandler, usageHandler, uploadTokenHandler, uploadAuth)
+
+	// Unversioned routes are a deprecated alias of v1 kept for existing clients
+	legacy := r.Group("/api",
+		middleware.Deprecated("/api", "/api/v1", cfg.Server.LegacyAPISunset),
+		middleware.APIVersion(middleware.CurrentAPIVersion),
+		middleware.Authenticate(keys),
+	)
+	registerAPIRoutes(legacy, timeouts, imageHandler, statsHandler, usageHandler, uploadTokenHandler, uploadAuth)
+
+	// Admin routes are only mounted when a token is configured
+	if cfg.Server.AdminToken != "" {
+		admin := r.Group("/admin", middleware.AdminAuth(cfg.Server.AdminToken))
+		admin.GET("/lifecycle", read, adminHandler.GetLifecycle)
+		admin.POST("/reload", write, adminHandler.ReloadConfig)
+		admin.GET("/loglevel", read, adminHandler.GetLogLevel)
+		admin.PUT("/loglevel", write, adminHandler.SetLogLevel)
+		admin.DELETE("/loglevel", write, adminHandler.ResetLogLevel)
+		// A pass lists the whole bucket, so it gets the longest timeout
+		admin.POST("/gc", middleware.Timeout(timeouts.Stream), adminHandler.CollectGarbage)
+		admin.GET("/usage/export", read, usageHandler.ExportUsage)
+		// The self-test bounds itself with SELFTEST_TIMEOUT and then cleans up
+		admin.POST("/selftest", middleware.Timeout(timeouts.Stream), adminHandler.RunSelfTest)
+		admin.POST("/requeue-failed", middleware.Timeout(timeouts.Stream), adminHandler.RequeueFailed)
+		// Fault injection is only compiled into builds with the faults tag
+		if faults.Available {
+			faultsHandler := gin.WrapH(injector.Handler())
+			admin.GET("/faults", read, faultsHandler)
+			admin.PUT("/faults", write, faultsHandler)
+			admin.DELETE("/faults", write, faultsHandler)
 		}
-		// Adicione outras rotas da API aqui dentro do grupo 'api'
 	}
 
 	return r
 }
+
+// registerAPIRoutes mounts the API routes on a versioned or legacy group
+func registerAPIRoutes(
+	api *gin.RouterGroup,
+	timeouts *config.TimeoutConfig,
+	imageHandler *handlers.ImageHandler,
+	statsHandler *handlers.StatsHandler,
+	usageHandler *handlers.UsageHandler,
+	uploadTokenHandler *handlers.UploadTokenHandler,
+	uploadAuth gin.HandlerFunc,
+) {
+	read := middleware.Timeout(timeouts.Read)
+	write := middleware.Timeout(timeouts.Write)
+	upload := middleware.Timeout(timeouts.Upload)
+	stream := middleware.Timeout(timeouts.Stream)
+
+	// Image routes
+	images := api.Group("/images")
+	{
+		images.POST("", upload, uploadAuth, middleware.Allow(auth.OpUpload), imageHandler.UploadImage)
+		images.GET("", read, imageHandler.ListImages)
+		images.GET("/export", stream, middleware.Allow(auth.OpExport), imageHandler.ExportImages)
+		images.POST("/archive", stream, middleware.Allow(auth.OpDownload), imageHandler.ArchiveImages)
+		images.GET("/:id", read, imageHandler.GetImage)
+		images.GET("/:id/download", stream, middleware.Allow(auth.OpDownload), imageHandler.DownloadImage)
+		images.GET("/:id/progress", stream, imageHandler.StreamImageProgress)
+		images.GET("/:id/wait", stream, imageHandler.WaitImage)
+		images.GET("/:id/srcset", read, middleware.Allow(auth.OpDownload), imageHandler.GetImageSrcset)
+		images.GET("/:id/compare", stream, middleware.Allow(auth.OpDownload), imageHandler.CompareImage)
+		images.POST("/:id/reprocess", write, middleware.Allow(auth.OpReprocess), imageHandler.ReprocessImage)
+		images.POST("/:id/retry", write, middleware.Allow(auth.OpReprocess), imageHandler.RetryImage)
+		images.GET("/:id/history", read, imageHandler.GetImageHistory)
+		images.GET("/:id/versions", read, imageHandler.ListImageVersions)
+		images.POST("/:id/versions/:version/promote", write, middleware.Allow(auth.OpReprocess), imageHandler.PromoteImageVersion)
+		images.DELETE("/:id", write, middleware.Allow(auth.OpDelete), imageHandler.DeleteImage)
+	}
+
+	// Statistics routes
+	stats := api.Group("/stats")
+	{
+		stats.GET("", read, statsHandler.GetStats)
+		stats.GET("/storage", read, statsHandler.GetStorageUsage)
+	}
+
+	// Single-use upload tokens, issued to API key owners
+	if uploadTokenHandler != nil {
+		api.POST("/upload-tokens", write, middleware.Allow(auth.OpUpload), uploadTokenHandler.Issue)
+	}
+
+	// Usage of the calling API key owner
+	api.GET("/usage", read, usageHandler.GetUsage)
+	// Adicione outras rotas da API aqui dentro do grupo 'api'
+}
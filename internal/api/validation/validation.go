@@ -0,0 +1,162 @@
+// Package validation binds request parameters and reports invalid input as
+// VALIDATION_FAILED errors with field-level details.
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/not-nullexception/image-optimizer/internal/apierror"
+)
+
+// FieldError describes why a single request parameter is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func init() {
+	// Report fields by their query, form or URI parameter name rather than the Go field name
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(f reflect.StructField) string {
+			for _, tag := range []string{"form", "uri", "json"} {
+				if name, _, _ := strings.Cut(f.Tag.Get(tag), ","); name != "" && name != "-" {
+					return name
+				}
+			}
+			return f.Name
+		})
+	}
+}
+
+// Query binds the query string into req and validates it. On failure it writes an
+// error response and returns false.
+func Query(c *gin.Context, req any) bool {
+	return check(c, c.ShouldBindQuery(req))
+}
+
+// URI binds the path parameters into req and validates them. On failure it writes an
+// error response and returns false.
+func URI(c *gin.Context, req any) bool {
+	return check(c, c.ShouldBindUri(req))
+}
+
+// JSON binds the JSON request body into req and validates it. On failure it writes an
+// error response and returns false.
+func JSON(c *gin.Context, req any) bool {
+	return check(c, c.ShouldBindJSON(req))
+}
+
+// Values binds values, sent in the request field named field, into req as if they were a
+// query string and validates them. Invalid values are reported as field.name. On failure it
+// writes an error response and returns false.
+func Values(c *gin.Context, field string, values url.Values, req any) bool {
+	err := binding.Query.Bind(&http.Request{URL: &url.URL{RawQuery: values.Encode()}}, req)
+	if err == nil {
+		return true
+	}
+
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		fields := make([]FieldError, 0, len(verrs))
+		for _, fe := range verrs {
+			fields = append(fields, FieldError{Field: field + "." + fe.Field(), Message: message(fe)})
+		}
+		abort(c, fields...)
+		return false
+	}
+	Fail(c, field, field+" could not be parsed: "+err.Error())
+	return false
+}
+
+// JSONList parses value, the JSON array sent in the form field named field, into list, a
+// pointer to a slice of structs, and validates every element. Invalid elements are
+// reported as field[i].name. On failure it writes an error response and returns false.
+func JSONList(c *gin.Context, field, value string, list any) bool {
+	if err := json.Unmarshal([]byte(value), list); err != nil {
+		Fail(c, field, field+" must be a JSON array of objects")
+		return false
+	}
+
+	var fields []FieldError
+	elements := reflect.ValueOf(list).Elem()
+	for i := range elements.Len() {
+		var verrs validator.ValidationErrors
+		if errors.As(binding.Validator.ValidateStruct(elements.Index(i).Addr().Interface()), &verrs) {
+			for _, fe := range verrs {
+				fields = append(fields, FieldError{Field: fmt.Sprintf("%s[%d].%s", field, i, fe.Field()), Message: message(fe)})
+			}
+		}
+	}
+	if len(fields) > 0 {
+		abort(c, fields...)
+		return false
+	}
+	return true
+}
+
+// Fail writes a validation error for a single invalid field
+func Fail(c *gin.Context, field, message string) {
+	abort(c, FieldError{Field: field, Message: message})
+}
+
+// FailWithCode writes an error with a specific code for a single invalid field
+func FailWithCode(c *gin.Context, code apierror.Code, field, message string) {
+	apierror.Abort(c, apierror.New(http.StatusBadRequest, code, "The request contains invalid parameters").
+		WithDetails([]FieldError{{Field: field, Message: message}}))
+}
+
+// abort writes a validation error listing every invalid field
+func abort(c *gin.Context, errs ...FieldError) {
+	apierror.Abort(c, apierror.New(http.StatusBadRequest, apierror.CodeValidationFailed, "The request contains invalid parameters").
+		WithDetails(errs))
+}
+
+// check writes an error response for a binding error
+func check(c *gin.Context, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		fields := make([]FieldError, 0, len(verrs))
+		for _, fe := range verrs {
+			fields = append(fields, FieldError{Field: fe.Field(), Message: message(fe)})
+		}
+		abort(c, fields...)
+		return false
+	}
+
+	// Type conversion errors, such as letters in a numeric parameter
+	apierror.Abort(c, apierror.New(http.StatusBadRequest, apierror.CodeValidationFailed,
+		"The request parameters could not be parsed: "+err.Error()))
+	return false
+}
+
+// message translates a validation failure into a human readable message
+func message(fe validator.FieldError) string {
+	field := fe.Field()
+	switch fe.Tag() {
+	case "required":
+		return fmt.Sprintf("%s is required", field)
+	case "min", "gte":
+		return fmt.Sprintf("%s must be at least %s", field, fe.Param())
+	case "max", "lte":
+		return fmt.Sprintf("%s must be at most %s", field, fe.Param())
+	case "oneof":
+		return fmt.Sprintf("%s must be one of: %s", field, strings.ReplaceAll(fe.Param(), " ", ", "))
+	case "uuid":
+		return fmt.Sprintf("%s must be a valid UUID", field)
+	default:
+		return fmt.Sprintf("%s is invalid", field)
+	}
+}
@@ -0,0 +1,223 @@
+// Package apierror defines the typed error codes returned by the API and maps
+// repository, storage and queue errors to them.
+package apierror
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+)
+
+// ContentType is the media type of error responses
+const ContentType = "application/problem+json"
+
+// codeKey is the context key of the code of the error response written by Abort
+const codeKey = "apierror.code"
+
+// Code is a stable, machine readable error code
+type Code string
+
+const (
+	CodeValidationFailed      Code = "VALIDATION_FAILED"
+	CodeUnsupportedFormat     Code = "UNSUPPORTED_FORMAT"
+	CodeChecksumMismatch      Code = "CHECKSUM_MISMATCH"
+	CodeUnsupportedAPIVersion Code = "UNSUPPORTED_API_VERSION"
+	CodeImageNotFound         Code = "IMAGE_NOT_FOUND"
+	CodeVersionNotFound       Code = "VERSION_NOT_FOUND"
+	CodeVariantNotAvailable   Code = "VARIANT_NOT_AVAILABLE"
+	CodeTemplateNotFound      Code = "TEMPLATE_NOT_FOUND"
+	CodeImageWithheld         Code = "IMAGE_WITHHELD"
+	CodeImageProcessing       Code = "IMAGE_PROCESSING"
+	CodeImageNotFailed        Code = "IMAGE_NOT_FAILED"
+	CodeUnauthorized          Code = "UNAUTHORIZED"
+	CodeOperationNotAllowed   Code = "OPERATION_NOT_ALLOWED"
+	CodeRequestTimeout        Code = "REQUEST_TIMEOUT"
+	CodePayloadTooLarge       Code = "PAYLOAD_TOO_LARGE"
+	CodeUploadThrottled       Code = "UPLOAD_THROTTLED"
+	CodeInvalidSignature      Code = "INVALID_SIGNATURE"
+	CodeURLExpired            Code = "URL_EXPIRED"
+	CodeInvalidUploadToken    Code = "INVALID_UPLOAD_TOKEN"
+	CodeUploadTokenUsed       Code = "UPLOAD_TOKEN_USED"
+	CodeInvalidDeletionToken  Code = "INVALID_DELETION_TOKEN"
+	CodeMalwareDetected       Code = "MALWARE_DETECTED"
+	CodeScannerUnavailable    Code = "SCANNER_UNAVAILABLE"
+	CodeDeadlineExceeded      Code = "DEADLINE_EXCEEDED"
+	CodeGCRunning             Code = "GC_RUNNING"
+	CodeSelfTestRunning       Code = "SELFTEST_RUNNING"
+	CodeRequeueRunning        Code = "REQUEUE_RUNNING"
+	CodeStorageUnavailable    Code = "STORAGE_UNAVAILABLE"
+	CodeQueueUnavailable      Code = "QUEUE_UNAVAILABLE"
+	CodeDatabaseUnavailable   Code = "DATABASE_UNAVAILABLE"
+	CodeInternal              Code = "INTERNAL_ERROR"
+)
+
+var (
+	// ErrUnauthorized is returned when a request lacks valid credentials
+	ErrUnauthorized = New(http.StatusUnauthorized, CodeUnauthorized, "Authentication required")
+	// ErrOperationNotAllowed is returned when the API key of the caller is limited to other operations
+	ErrOperationNotAllowed = New(http.StatusForbidden, CodeOperationNotAllowed, "Operation not allowed for this API key")
+	// ErrRequestTimeout is returned when the request body arrives too slowly
+	ErrRequestTimeout = New(http.StatusRequestTimeout, CodeRequestTimeout, "Request body not received in time")
+	// ErrPayloadTooLarge is returned when the request body exceeds the configured limit
+	ErrPayloadTooLarge = New(http.StatusRequestEntityTooLarge, CodePayloadTooLarge, "Request body too large")
+	// ErrUploadThrottled is returned for low priority uploads while the processing pipeline
+	// is saturated
+	ErrUploadThrottled = New(http.StatusTooManyRequests, CodeUploadThrottled, "Processing pipeline saturated, retry later")
+	// ErrImageWithheld is returned when moderation prevents an image from being served
+	ErrImageWithheld = New(http.StatusForbidden, CodeImageWithheld, "Image withheld by moderation")
+	// ErrImageProcessing is returned when an operation conflicts with processing in progress
+	ErrImageProcessing = New(http.StatusConflict, CodeImageProcessing, "Image is being processed")
+	// ErrImageNotFailed is returned when retrying an image whose processing did not fail
+	ErrImageNotFailed = New(http.StatusConflict, CodeImageNotFailed, "Image processing did not fail")
+	// ErrVariantNotAvailable is returned when the requested variant has not been produced yet
+	ErrVariantNotAvailable = New(http.StatusNotFound, CodeVariantNotAvailable, "Optimized image not available")
+	// ErrTemplateNotFound is returned for unknown transformation templates
+	ErrTemplateNotFound = New(http.StatusNotFound, CodeTemplateNotFound, "Unknown template")
+	// ErrInvalidSignature is returned for transformation URLs with a bad signature
+	ErrInvalidSignature = New(http.StatusForbidden, CodeInvalidSignature, "Invalid signature")
+	// ErrURLExpired is returned for transformation URLs past their expiry
+	ErrURLExpired = New(http.StatusGone, CodeURLExpired, "Transformation URL expired")
+	// ErrInvalidUploadToken is returned for upload tokens with a bad signature or past their expiry
+	ErrInvalidUploadToken = New(http.StatusUnauthorized, CodeInvalidUploadToken, "Invalid or expired upload token")
+	// ErrUploadTokenUsed is returned for upload tokens that were already used
+	ErrUploadTokenUsed = New(http.StatusConflict, CodeUploadTokenUsed, "Upload token already used")
+	// ErrMalwareDetected is returned for uploads rejected by the malware scanner
+	ErrMalwareDetected = New(http.StatusUnprocessableEntity, CodeMalwareDetected, "Upload rejected: malware detected")
+	// ErrScannerUnavailable is returned when uploads cannot be scanned for malware
+	ErrScannerUnavailable = New(http.StatusServiceUnavailable, CodeScannerUnavailable, "Malware scanner unavailable")
+	// ErrDeadlineExceeded is returned when a request runs past its route timeout
+	ErrDeadlineExceeded = New(http.StatusGatewayTimeout, CodeDeadlineExceeded, "Request timed out")
+	// ErrGCRunning is returned when a garbage collection pass is already running
+	ErrGCRunning = New(http.StatusConflict, CodeGCRunning, "Garbage collection already running")
+	// ErrSelfTestRunning is returned when a self-test is already running
+	ErrSelfTestRunning = New(http.StatusConflict, CodeSelfTestRunning, "Self-test already running")
+	// ErrRequeueRunning is returned when a bulk requeue of failed images is already running
+	ErrRequeueRunning = New(http.StatusConflict, CodeRequeueRunning, "Requeue of failed images already running")
+)
+
+// Error is an API error with a status code, a typed code and optional details
+type Error struct {
+	Status  int
+	Code    Code
+	Message string
+	Details any
+	Err     error
+}
+
+// Response is the JSON body of an error: an RFC 7807 problem document extended with
+// code, message and details
+type Response struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Code     Code   `json:"code"`
+	Message  string `json:"message"`
+	Details  any    `json:"details,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// New creates an Error
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return string(e.Code) + ": " + e.Message + ": " + e.Err.Error()
+	}
+	return string(e.Code) + ": " + e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// WithDetails returns a copy of e carrying details
+func (e *Error) WithDetails(details any) *Error {
+	copied := *e
+	copied.Details = details
+	return &copied
+}
+
+// FromRepository maps a repository error
+func FromRepository(err error) *Error {
+	if errors.Is(err, db.ErrNotFound) {
+		return &Error{Status: http.StatusNotFound, Code: CodeImageNotFound, Message: "Image not found", Err: err}
+	}
+	if errors.Is(err, db.ErrVersionNotFound) {
+		return &Error{Status: http.StatusNotFound, Code: CodeVersionNotFound, Message: "Image version not found", Err: err}
+	}
+	return &Error{Status: http.StatusServiceUnavailable, Code: CodeDatabaseUnavailable, Message: "Database unavailable", Err: err}
+}
+
+// FromRequestBody maps an error reading the request body caused by the body limit or the
+// slow client timeout, and returns nil for any other error
+func FromRequestBody(err error) *Error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &Error{Status: http.StatusRequestEntityTooLarge, Code: CodePayloadTooLarge, Message: ErrPayloadTooLarge.Message, Err: err}
+	}
+	var netErr net.Error
+	if errors.Is(err, os.ErrDeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return &Error{Status: http.StatusRequestTimeout, Code: CodeRequestTimeout, Message: ErrRequestTimeout.Message, Err: err}
+	}
+	return nil
+}
+
+// FromStorage maps an object storage error
+func FromStorage(err error) *Error {
+	if errors.Is(err, minio.ErrObjectNotFound) {
+		return &Error{Status: http.StatusNotFound, Code: CodeImageNotFound, Message: "Image not found in storage", Err: err}
+	}
+	return &Error{Status: http.StatusServiceUnavailable, Code: CodeStorageUnavailable, Message: "Storage unavailable", Err: err}
+}
+
+// FromQueue maps a message queue error
+func FromQueue(err error) *Error {
+	return &Error{Status: http.StatusServiceUnavailable, Code: CodeQueueUnavailable, Message: "Processing queue unavailable", Err: err}
+}
+
+// Internal wraps an unexpected error
+func Internal(message string, err error) *Error {
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: message, Err: err}
+}
+
+// ResponseCode returns the code of the error response written by Abort, empty if the
+// request was not aborted with an error
+func ResponseCode(c *gin.Context) Code {
+	value, _ := c.Get(codeKey)
+	code, _ := value.(Code)
+	return code
+}
+
+// Abort writes err as the response and aborts the request
+func Abort(c *gin.Context, err *Error) {
+	// Dependencies failing because the route timeout cancelled the request are reported
+	// as the timeout rather than as an outage
+	if err.Status >= http.StatusInternalServerError && errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+		err = &Error{Status: ErrDeadlineExceeded.Status, Code: ErrDeadlineExceeded.Code, Message: ErrDeadlineExceeded.Message, Err: err.Err}
+	}
+
+	// Server errors are attached to the context for middleware.ReportErrors
+	if err.Status >= http.StatusInternalServerError {
+		_ = c.Error(err)
+	}
+	c.Set(codeKey, err.Code)
+
+	c.Header("Content-Type", ContentType)
+	c.AbortWithStatusJSON(err.Status, Response{
+		Type:     "about:blank",
+		Title:    http.StatusText(err.Status),
+		Status:   err.Status,
+		Code:     err.Code,
+		Message:  err.Message,
+		Details:  err.Details,
+		Instance: c.Request.URL.Path,
+	})
+}
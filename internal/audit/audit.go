@@ -0,0 +1,36 @@
+// Package audit records security relevant actions as structured log entries, separate
+// from the diagnostic logs of each component.
+package audit
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// Actions recorded in the audit log
+const (
+	ActionDeleteRequested = "image.delete_requested"
+	ActionDeleteConfirmed = "image.delete_confirmed"
+	ActionDeleteExpired   = "image.delete_expired"
+	ActionDeleted         = "image.deleted"
+	ActionMalwareDetected = "image.malware_detected"
+)
+
+// Record writes an audit entry for action on an image. Entries carry the request and
+// trace IDs of ctx and are marked with audit=true so they can be routed separately;
+// fields adds action specific details.
+func Record(ctx context.Context, action string, imageID uuid.UUID, fields map[string]any) {
+	auditLogger := logger.GetLoggerWithContext(ctx, "audit")
+
+	event := auditLogger.Info().
+		Bool("audit", true).
+		Str("action", action).
+		Str("image_id", imageID.String())
+	if len(fields) > 0 {
+		event = event.Fields(fields)
+	}
+
+	event.Msg("Audit")
+}
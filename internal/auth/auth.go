@@ -0,0 +1,108 @@
+// Package auth identifies API callers by their API key. Requests without a key are
+// anonymous; the owner of a key owns the images it uploads. The keys of some owners may be
+// limited to some input formats, dimensions and operations.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"slices"
+
+	"github.com/not-nullexception/image-optimizer/config"
+)
+
+// Operations an API key may be limited to, besides reading image metadata
+const (
+	OpUpload    = "upload"
+	OpDownload  = "download"
+	OpReprocess = "reprocess"
+	OpDelete    = "delete"
+	OpExport    = "export"
+)
+
+type ownerKey struct{}
+
+type limitsKey struct{}
+
+// Keys maps API keys to the owner they authenticate. Only hashes of the keys are kept.
+type Keys struct {
+	owners map[string]string
+	limits map[string]*config.KeyLimits
+}
+
+// NewKeys creates Keys from a map of owner to API key and the limits of some owners
+func NewKeys(keys map[string]string, limits map[string]config.KeyLimits) *Keys {
+	owners := make(map[string]string, len(keys))
+	for owner, key := range keys {
+		owners[hashKey(key)] = owner
+	}
+
+	ownerLimits := make(map[string]*config.KeyLimits, len(limits))
+	for owner, l := range limits {
+		ownerLimits[owner] = &l
+	}
+	return &Keys{owners: owners, limits: ownerLimits}
+}
+
+// Owner returns the owner authenticated by key
+func (k *Keys) Owner(key string) (string, bool) {
+	owner, ok := k.owners[hashKey(key)]
+	return owner, ok
+}
+
+// Limits returns the limits of the keys of owner, nil if it is unrestricted
+func (k *Keys) Limits(owner string) *config.KeyLimits {
+	return k.limits[owner]
+}
+
+// WithLimits attaches the limits of the caller's key to ctx
+func WithLimits(ctx context.Context, limits *config.KeyLimits) context.Context {
+	return context.WithValue(ctx, limitsKey{}, limits)
+}
+
+// limits returns the limits of the caller of the request, nil if it is unrestricted
+func limits(ctx context.Context) *config.KeyLimits {
+	l, _ := ctx.Value(limitsKey{}).(*config.KeyLimits)
+	return l
+}
+
+// AllowsOperation reports whether the caller of the request may perform operation
+func AllowsOperation(ctx context.Context, operation string) bool {
+	l := limits(ctx)
+	return l == nil || len(l.Operations) == 0 || slices.Contains(l.Operations, operation)
+}
+
+// AllowsFormat reports whether the caller of the request may upload images in format,
+// under the limits of its key and the policy of its upload token
+func AllowsFormat(ctx context.Context, format string) bool {
+	if p := Policy(ctx); p != nil && len(p.Formats) > 0 && !slices.Contains(p.Formats, format) {
+		return false
+	}
+	l := limits(ctx)
+	return l == nil || len(l.Formats) == 0 || slices.Contains(l.Formats, format)
+}
+
+// AllowsDimensions reports whether the caller of the request may upload an image of
+// width x height
+func AllowsDimensions(ctx context.Context, width, height int) bool {
+	l := limits(ctx)
+	return l == nil || l.MaxWidth == 0 || (width <= l.MaxWidth && height <= l.MaxHeight)
+}
+
+// ToContext attaches the authenticated owner to ctx
+func ToContext(ctx context.Context, owner string) context.Context {
+	return context.WithValue(ctx, ownerKey{}, owner)
+}
+
+// Owner returns the authenticated owner of the request, or "" for anonymous callers
+func Owner(ctx context.Context) string {
+	owner, _ := ctx.Value(ownerKey{}).(string)
+	return owner
+}
+
+// hashKey hashes an API key so lookups do not compare the keys themselves
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidUploadToken is returned when an upload token is malformed or its signature
+	// does not match
+	ErrInvalidUploadToken = errors.New("invalid upload token")
+	// ErrUploadTokenExpired is returned when an upload token is past its expiry
+	ErrUploadTokenExpired = errors.New("upload token expired")
+)
+
+type policyKey struct{}
+
+// UploadPolicy is what an upload token allows: a single upload on behalf of Owner, within
+// the constraints of the policy, until ExpiresAt
+type UploadPolicy struct {
+	ID    uuid.UUID `json:"id"`
+	Owner string    `json:"owner"`
+	// MaxBytes bounds the size of the upload, 0 for the global limit
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+	// Formats are the input formats the upload may have, any if empty
+	Formats []string `json:"formats,omitempty"`
+	// Preset holds the processing options of the upload as a query string, replacing
+	// those of the upload request
+	Preset    string    `json:"preset,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// UploadTokens issues and verifies upload tokens. A token is the base64url encoded JSON of
+// its policy and the HMAC-SHA256 signature of that encoding, separated by a dot.
+type UploadTokens struct {
+	key []byte
+}
+
+// NewUploadTokens creates UploadTokens signing with the given secret key
+func NewUploadTokens(key string) *UploadTokens {
+	return &UploadTokens{key: []byte(key)}
+}
+
+// Issue returns a signed token for policy
+func (t *UploadTokens) Issue(policy *UploadPolicy) (string, error) {
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(data)
+	return encoded + "." + t.sign(encoded), nil
+}
+
+// Verify checks the signature and expiry of token and returns its policy. It doesn't
+// check whether the token was used before.
+func (t *UploadTokens) Verify(token string) (*UploadPolicy, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(t.sign(encoded))) {
+		return nil, ErrInvalidUploadToken
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidUploadToken
+	}
+	var policy UploadPolicy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, ErrInvalidUploadToken
+	}
+
+	if time.Now().After(policy.ExpiresAt) {
+		return nil, ErrUploadTokenExpired
+	}
+	return &policy, nil
+}
+
+// sign returns the URL-safe signature of an encoded policy
+func (t *UploadTokens) sign(encoded string) string {
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// WithPolicy attaches the policy of the upload token authenticating the request to ctx
+func WithPolicy(ctx context.Context, policy *UploadPolicy) context.Context {
+	return context.WithValue(ctx, policyKey{}, policy)
+}
+
+// Policy returns the policy of the upload token authenticating the request, nil if the
+// request has none
+func Policy(ctx context.Context) *UploadPolicy {
+	policy, _ := ctx.Value(policyKey{}).(*UploadPolicy)
+	return policy
+}
@@ -0,0 +1,70 @@
+package background
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// httpRemover sends the image to an external segmentation service (for example an
+// ONNX model runner), which must answer with the cut-out image in the requested format
+type httpRemover struct {
+	url    string
+	token  string
+	format string
+	client *http.Client
+}
+
+func newHTTPRemover(cfg *config.BackgroundRemovalConfig) *httpRemover {
+	return &httpRemover{
+		url:    cfg.URL,
+		token:  cfg.Token,
+		format: cfg.Format,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+// RemoveBackground sends the image to the service and returns the cut-out
+func (h *httpRemover) RemoveBackground(ctx context.Context, data []byte, contentType string) ([]byte, string, error) {
+	reqLogger := logger.FromContext(ctx).With().Str("component", "background-http").Logger()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("error creating background removal request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "image/"+h.format)
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error calling background removal service")
+		return nil, "", fmt.Errorf("error calling background removal service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		reqLogger.Error().Int("status", resp.StatusCode).Msg("Background removal service returned an error")
+		return nil, "", fmt.Errorf("background removal service returned status %d", resp.StatusCode)
+	}
+
+	cutout, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading background removal response: %w", err)
+	}
+
+	resultType := resp.Header.Get("Content-Type")
+	if resultType == "" {
+		resultType = "image/" + h.format
+	}
+
+	reqLogger.Debug().Int("size", len(cutout)).Str("content_type", resultType).Msg("Background removed")
+	return cutout, resultType, nil
+}
@@ -0,0 +1,33 @@
+package background
+
+import (
+	"context"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// Remover produces a cut-out of the foreground of an image on a transparent background
+type Remover interface {
+	// RemoveBackground returns the cut-out image data and its content type
+	RemoveBackground(ctx context.Context, data []byte, contentType string) ([]byte, string, error)
+}
+
+// NewRemover returns the Remover for the configured provider,
+// or nil if background removal is disabled.
+func NewRemover(cfg *config.BackgroundRemovalConfig) Remover {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	initLogger := logger.GetLogger("background")
+
+	switch cfg.Provider {
+	case "http":
+		initLogger.Info().Str("provider", cfg.Provider).Str("format", cfg.Format).Msg("Background removal enabled")
+		return newHTTPRemover(cfg)
+	default:
+		initLogger.Warn().Str("provider", cfg.Provider).Msg("Unknown background removal provider, background removal disabled")
+		return nil
+	}
+}
@@ -0,0 +1,154 @@
+// Package backpressure throttles low priority uploads while the processing pipeline is
+// saturated, so a backlog the workers can't catch up with stops growing. The saturation
+// is polled from the queue depth and the utilization of the workers.
+package backpressure
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
+	"github.com/rs/zerolog"
+)
+
+// Upload priorities
+const (
+	PriorityLow    = "low"
+	PriorityNormal = "normal"
+)
+
+// Reasons for throttling, the threshold exceeded
+const (
+	ReasonQueueDepth        = "queue_depth"
+	ReasonWorkerUtilization = "worker_utilization"
+)
+
+// Monitor polls the saturation of the processing pipeline and decides which uploads are
+// throttled
+type Monitor struct {
+	// queue reports the depth of the queues, nil if the queue client can't
+	queue  rabbitmq.Inspector
+	repo   db.Repository
+	config *config.BackpressureConfig
+	logger zerolog.Logger
+
+	// reason is the threshold exceeded at the last poll, empty while none is
+	reason atomic.Value
+}
+
+// NewMonitor creates a Monitor of the queues of queueClient, which must be the client
+// itself rather than a wrapper for its queues to be inspected
+func NewMonitor(queueClient rabbitmq.Client, repo db.Repository, cfg *config.BackpressureConfig) *Monitor {
+	inspector, _ := queueClient.(rabbitmq.Inspector)
+	m := &Monitor{
+		queue:  inspector,
+		repo:   repo,
+		config: cfg,
+		logger: logger.GetLogger("backpressure"),
+	}
+	m.reason.Store("")
+	return m
+}
+
+// Run polls the pipeline at start and every PollInterval until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	if m.queue == nil {
+		m.logger.Warn().Msg("Queue client can't report its depth, uploads are not throttled")
+		return
+	}
+	m.logger.Info().
+		Dur("interval", m.config.PollInterval).
+		Int("max_queue_depth", m.config.MaxQueueDepth).
+		Float64("max_worker_utilization", m.config.MaxWorkerUtilization).
+		Msg("Starting backpressure monitoring")
+
+	ticker := time.NewTicker(m.config.PollInterval)
+	defer ticker.Stop()
+
+	m.poll(logger.ToContext(ctx, m.logger))
+	for {
+		select {
+		case <-ctx.Done():
+			m.logger.Info().Msg("Backpressure monitoring stopped")
+			return
+		case <-ticker.C:
+			m.poll(logger.ToContext(ctx, m.logger))
+		}
+	}
+}
+
+// poll reads the queue depth and worker utilization, updates their gauges and the
+// threshold exceeded. Uploads are not throttled while they can't be read.
+func (m *Monitor) poll(ctx context.Context) {
+	stats, err := m.queue.Stats(ctx)
+	if err != nil {
+		m.logger.Warn().Err(err).Msg("Failed to read queue depth")
+		m.setReason("")
+		return
+	}
+	metrics.UpdateQueueDepth(stats.Depth)
+
+	// Images are processing from the start of their resize task to its end, so they
+	// count the resize tasks in flight
+	counts, err := m.repo.CountImagesByStatus(ctx, models.ImageFilter{})
+	if err != nil {
+		m.logger.Warn().Err(err).Msg("Failed to count images being processed")
+	} else {
+		metrics.UpdateWorkerUtilization(counts.Processing, stats.Capacity)
+	}
+
+	reason := ""
+	switch {
+	case m.config.MaxQueueDepth > 0 && stats.Depth > m.config.MaxQueueDepth:
+		reason = ReasonQueueDepth
+	case m.config.MaxWorkerUtilization > 0 && err == nil && stats.Capacity > 0 &&
+		float64(counts.Processing)/float64(stats.Capacity)*100 > m.config.MaxWorkerUtilization:
+		reason = ReasonWorkerUtilization
+	}
+	m.setReason(reason)
+
+	m.logger.Debug().
+		Int("queue_depth", stats.Depth).
+		Int("consumers", stats.Consumers).
+		Int("processing", counts.Processing).
+		Int("capacity", stats.Capacity).
+		Str("throttling", reason).
+		Msg("Pipeline saturation polled")
+}
+
+// setReason records the threshold exceeded, logging when throttling starts or stops
+func (m *Monitor) setReason(reason string) {
+	previous := m.reason.Swap(reason).(string)
+	switch {
+	case previous == "" && reason != "":
+		m.logger.Warn().Str("reason", reason).Msg("Processing pipeline saturated, throttling low priority uploads")
+	case previous != "" && reason == "":
+		m.logger.Info().Msg("Processing pipeline recovered, no longer throttling uploads")
+	}
+}
+
+// Throttle returns the threshold exceeded if uploads of priority must be refused, empty
+// otherwise. An empty priority is the configured default.
+func (m *Monitor) Throttle(priority string) string {
+	if !m.config.Enabled {
+		return ""
+	}
+	if priority == "" {
+		priority = m.config.DefaultPriority
+	}
+	if priority != PriorityLow {
+		return ""
+	}
+	return m.reason.Load().(string)
+}
+
+// RetryAfter is how long throttled clients are asked to wait
+func (m *Monitor) RetryAfter() time.Duration {
+	return m.config.RetryAfter
+}
@@ -0,0 +1,63 @@
+package cdn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// fastlyInvalidator purges individual URLs using the Fastly purge API
+type fastlyInvalidator struct {
+	token   string
+	baseURL string
+	client  *http.Client
+}
+
+func newFastlyInvalidator(cfg *config.CDNConfig) *fastlyInvalidator {
+	return &fastlyInvalidator{
+		token:   cfg.InvalidationToken,
+		baseURL: cfg.PublicBaseURL,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Invalidate purges the public URL of every object
+func (f *fastlyInvalidator) Invalidate(ctx context.Context, objectNames ...string) error {
+	reqLogger := logger.FromContext(ctx).With().Str("component", "cdn-fastly").Logger()
+
+	var errs error
+	for _, name := range objectNames {
+		url := PublicURL(f.baseURL, name)
+
+		req, err := http.NewRequestWithContext(ctx, "PURGE", url, nil)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("error creating purge request for %s: %w", url, err))
+			continue
+		}
+		req.Header.Set("Fastly-Key", f.token)
+
+		resp, err := f.client.Do(req)
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("error purging %s: %w", url, err))
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= http.StatusMultipleChoices {
+			errs = errors.Join(errs, fmt.Errorf("purge of %s failed with status %d", url, resp.StatusCode))
+			continue
+		}
+
+		reqLogger.Debug().Str("url", url).Msg("CDN object purged")
+	}
+
+	if errs != nil {
+		reqLogger.Error().Err(errs).Msg("Error purging CDN objects")
+	}
+	return errs
+}
@@ -0,0 +1,45 @@
+package cdn
+
+import (
+	"context"
+	"strings"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// Invalidator purges cached copies of stored objects from a CDN
+type Invalidator interface {
+	Invalidate(ctx context.Context, objectNames ...string) error
+}
+
+// NewInvalidator returns the Invalidator for the configured provider.
+// A no-op invalidator is returned when no provider is configured.
+func NewInvalidator(cfg *config.CDNConfig) Invalidator {
+	initLogger := logger.GetLogger("cdn")
+
+	switch cfg.InvalidationProvider {
+	case "webhook":
+		initLogger.Info().Str("provider", cfg.InvalidationProvider).Msg("CDN invalidation enabled")
+		return newWebhookInvalidator(cfg)
+	case "fastly":
+		initLogger.Info().Str("provider", cfg.InvalidationProvider).Msg("CDN invalidation enabled")
+		return newFastlyInvalidator(cfg)
+	case "", "none":
+		return noopInvalidator{}
+	default:
+		initLogger.Warn().Str("provider", cfg.InvalidationProvider).Msg("Unknown CDN invalidation provider, invalidation disabled")
+		return noopInvalidator{}
+	}
+}
+
+// PublicURL returns the stable public URL of an object served through the CDN
+func PublicURL(baseURL, objectName string) string {
+	return strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimPrefix(objectName, "/")
+}
+
+type noopInvalidator struct{}
+
+func (noopInvalidator) Invalidate(ctx context.Context, objectNames ...string) error {
+	return nil
+}
@@ -0,0 +1,76 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// webhookInvalidator posts the objects to purge to an HTTP endpoint, e.g. a function
+// that creates a CloudFront invalidation.
+type webhookInvalidator struct {
+	url     string
+	token   string
+	baseURL string
+	client  *http.Client
+}
+
+type webhookPayload struct {
+	Paths []string `json:"paths"`
+	URLs  []string `json:"urls"`
+}
+
+func newWebhookInvalidator(cfg *config.CDNConfig) *webhookInvalidator {
+	return &webhookInvalidator{
+		url:     cfg.InvalidationURL,
+		token:   cfg.InvalidationToken,
+		baseURL: cfg.PublicBaseURL,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Invalidate sends the object paths and their public URLs to the webhook
+func (w *webhookInvalidator) Invalidate(ctx context.Context, objectNames ...string) error {
+	reqLogger := logger.FromContext(ctx).With().Str("component", "cdn-webhook").Logger()
+
+	payload := webhookPayload{Paths: make([]string, 0, len(objectNames)), URLs: make([]string, 0, len(objectNames))}
+	for _, name := range objectNames {
+		payload.Paths = append(payload.Paths, "/"+name)
+		payload.URLs = append(payload.URLs, PublicURL(w.baseURL, name))
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshaling invalidation payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating invalidation request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.token)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error sending CDN invalidation")
+		return fmt.Errorf("error sending invalidation request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		reqLogger.Error().Int("status", resp.StatusCode).Msg("CDN invalidation rejected")
+		return fmt.Errorf("invalidation request failed with status %d", resp.StatusCode)
+	}
+
+	reqLogger.Debug().Strs("paths", payload.Paths).Msg("CDN invalidation sent")
+	return nil
+}
@@ -0,0 +1,336 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// Repository is an in-memory read-through cache in front of another db.Repository.
+// Single image reads and list pages are cached for a short TTL; writes through this
+// repository invalidate the affected entries immediately. The worker updates the status of
+// images without going through this cache, so only completed images are cached.
+type Repository struct {
+	db.Repository
+
+	ttl        time.Duration
+	maxEntries int
+
+	mu     sync.RWMutex
+	images map[uuid.UUID]imageEntry
+	lists  map[string]listEntry
+}
+
+type imageEntry struct {
+	image     *models.Image
+	expiresAt time.Time
+}
+
+type listEntry struct {
+	images    []*models.Image
+	total     int
+	expiresAt time.Time
+}
+
+// NewRepository wraps next with an in-memory cache
+func NewRepository(next db.Repository, cfg *config.CacheConfig) db.Repository {
+	initLogger := logger.GetLogger("cache-repository")
+	initLogger.Info().
+		Dur("ttl", cfg.TTL).
+		Int("max_entries", cfg.MaxEntries).
+		Msg("Repository cache enabled")
+
+	return &Repository{
+		Repository: next,
+		ttl:        cfg.TTL,
+		maxEntries: cfg.MaxEntries,
+		images:     make(map[uuid.UUID]imageEntry),
+		lists:      make(map[string]listEntry),
+	}
+}
+
+// GetImageByID returns the cached image or loads it from the underlying repository
+func (r *Repository) GetImageByID(ctx context.Context, id uuid.UUID) (*models.Image, error) {
+	r.mu.RLock()
+	entry, ok := r.images[id]
+	r.mu.RUnlock()
+
+	if ok && time.Now().Before(entry.expiresAt) {
+		reqLogger := logger.FromContext(ctx)
+		reqLogger.Debug().Str("image_id", id.String()).Msg("Image served from cache")
+		return cloneImage(entry.image), nil
+	}
+
+	img, err := r.Repository.GetImageByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	if len(r.images) >= r.maxEntries {
+		r.evictExpiredLocked()
+	}
+	if len(r.images) < r.maxEntries && settled(img) {
+		r.images[id] = imageEntry{image: cloneImage(img), expiresAt: time.Now().Add(r.ttl)}
+	}
+	r.mu.Unlock()
+
+	return img, nil
+}
+
+// ListImages returns the cached page or loads it from the underlying repository
+func (r *Repository) ListImages(ctx context.Context, filter models.ImageFilter, limit, offset int) ([]*models.Image, int, error) {
+	key := fmt.Sprintf("%d:%d:%+v", limit, offset, filter)
+
+	r.mu.RLock()
+	entry, ok := r.lists[key]
+	r.mu.RUnlock()
+
+	if ok && time.Now().Before(entry.expiresAt) {
+		reqLogger := logger.FromContext(ctx)
+		reqLogger.Debug().Int("limit", limit).Int("offset", offset).Msg("Image list served from cache")
+		return cloneImages(entry.images), entry.total, nil
+	}
+
+	images, total, err := r.Repository.ListImages(ctx, filter, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	r.mu.Lock()
+	if len(r.lists) >= r.maxEntries {
+		r.evictExpiredLocked()
+	}
+	if len(r.lists) < r.maxEntries && allSettled(images) {
+		r.lists[key] = listEntry{images: cloneImages(images), total: total, expiresAt: time.Now().Add(r.ttl)}
+	}
+	r.mu.Unlock()
+
+	return images, total, nil
+}
+
+// CreateImage creates the image and invalidates cached lists
+func (r *Repository) CreateImage(ctx context.Context, image *models.Image) error {
+	err := r.Repository.CreateImage(ctx, image)
+	r.invalidate(image.ID)
+	return err
+}
+
+// UpdateImage updates the image and invalidates its cache entries
+func (r *Repository) UpdateImage(ctx context.Context, image *models.Image) error {
+	err := r.Repository.UpdateImage(ctx, image)
+	r.invalidate(image.ID)
+	return err
+}
+
+// DeleteImage deletes the image and invalidates its cache entries
+func (r *Repository) DeleteImage(ctx context.Context, id uuid.UUID) error {
+	err := r.Repository.DeleteImage(ctx, id)
+	r.invalidate(id)
+	return err
+}
+
+// UpdateImageStatus updates the status and invalidates the image cache entries
+func (r *Repository) UpdateImageStatus(ctx context.Context, id uuid.UUID, status models.ProcessingStatus, errorMsg string) error {
+	err := r.Repository.UpdateImageStatus(ctx, id, status, errorMsg)
+	r.invalidate(id)
+	return err
+}
+
+// UpdateLeasedImageStatus updates the status and invalidates the image cache entries
+func (r *Repository) UpdateLeasedImageStatus(ctx context.Context, id uuid.UUID, workerID string, status models.ProcessingStatus, errorMsg string) error {
+	err := r.Repository.UpdateLeasedImageStatus(ctx, id, workerID, status, errorMsg)
+	r.invalidate(id)
+	return err
+}
+
+// StartImageProcessing moves the image to processing and invalidates the image cache entries
+func (r *Repository) StartImageProcessing(ctx context.Context, id uuid.UUID, taskID, workerID string, lease time.Duration) (string, error) {
+	previousOwner, err := r.Repository.StartImageProcessing(ctx, id, taskID, workerID, lease)
+	r.invalidate(id)
+	return previousOwner, err
+}
+
+// RetryImage moves the failed image back to pending and invalidates the image cache entries
+func (r *Repository) RetryImage(ctx context.Context, id uuid.UUID, automatic bool) error {
+	err := r.Repository.RetryImage(ctx, id, automatic)
+	r.invalidate(id)
+	return err
+}
+
+// UpdateImageProgress updates the progress and invalidates the image cache entries
+func (r *Repository) UpdateImageProgress(ctx context.Context, id uuid.UUID, percent int, stage string) error {
+	err := r.Repository.UpdateImageProgress(ctx, id, percent, stage)
+	r.invalidate(id)
+	return err
+}
+
+// UpdateImageOptimized updates the optimized data and invalidates the image cache entries
+func (r *Repository) UpdateImageOptimized(ctx context.Context, id uuid.UUID, workerID string, path string, size int64, width, height int) error {
+	err := r.Repository.UpdateImageOptimized(ctx, id, workerID, path, size, width, height)
+	r.invalidate(id)
+	return err
+}
+
+// UpdateModerationStatus updates the moderation status and invalidates the image cache entries
+func (r *Repository) UpdateModerationStatus(ctx context.Context, id uuid.UUID, status models.ModerationStatus) error {
+	err := r.Repository.UpdateModerationStatus(ctx, id, status)
+	r.invalidate(id)
+	return err
+}
+
+// UpdateImagePlaceholder updates the placeholder data and invalidates the image cache entries
+func (r *Repository) UpdateImagePlaceholder(ctx context.Context, id uuid.UUID, blurHash string, dominantColors []string) error {
+	err := r.Repository.UpdateImagePlaceholder(ctx, id, blurHash, dominantColors)
+	r.invalidate(id)
+	return err
+}
+
+// UpdateImageText updates the extracted text and invalidates the image cache entries
+func (r *Repository) UpdateImageText(ctx context.Context, id uuid.UUID, text string) error {
+	err := r.Repository.UpdateImageText(ctx, id, text)
+	r.invalidate(id)
+	return err
+}
+
+// UpdateImageCutout updates the cut-out path and invalidates the image cache entries
+func (r *Repository) UpdateImageCutout(ctx context.Context, id uuid.UUID, path string, size int64) error {
+	err := r.Repository.UpdateImageCutout(ctx, id, path, size)
+	r.invalidate(id)
+	return err
+}
+
+// UpdateImageRenditions updates the renditions and invalidates the image cache entries
+func (r *Repository) UpdateImageRenditions(ctx context.Context, id uuid.UUID, renditions []models.Rendition) error {
+	err := r.Repository.UpdateImageRenditions(ctx, id, renditions)
+	r.invalidate(id)
+	return err
+}
+
+// UpdateImageQualityScore updates the quality score and invalidates the image cache entries
+func (r *Repository) UpdateImageQualityScore(ctx context.Context, id uuid.UUID, score float64) error {
+	err := r.Repository.UpdateImageQualityScore(ctx, id, score)
+	r.invalidate(id)
+	return err
+}
+
+// UpdateImageIntegrity updates the integrity status and invalidates the image cache entries
+func (r *Repository) UpdateImageIntegrity(ctx context.Context, id uuid.UUID, status models.IntegrityStatus, checkedAt time.Time) error {
+	err := r.Repository.UpdateImageIntegrity(ctx, id, status, checkedAt)
+	r.invalidate(id)
+	return err
+}
+
+// UpdateImageReplication updates the replication status and invalidates the image cache entries
+func (r *Repository) UpdateImageReplication(ctx context.Context, id uuid.UUID, status models.ReplicationStatus, replicatedAt, updatedAt time.Time) error {
+	err := r.Repository.UpdateImageReplication(ctx, id, status, replicatedAt, updatedAt)
+	r.invalidate(id)
+	return err
+}
+
+// PromoteImageVersion promotes the version and invalidates the image cache entries
+func (r *Repository) PromoteImageVersion(ctx context.Context, id uuid.UUID, version int) error {
+	err := r.Repository.PromoteImageVersion(ctx, id, version)
+	r.invalidate(id)
+	return err
+}
+
+// MoveImageOriginal moves the original and invalidates the image cache entries
+func (r *Repository) MoveImageOriginal(ctx context.Context, id uuid.UUID, from, to string) error {
+	err := r.Repository.MoveImageOriginal(ctx, id, from, to)
+	r.invalidate(id)
+	return err
+}
+
+// PruneImageVersions prunes the versions and invalidates the image cache entries, as the
+// stored bytes change
+func (r *Repository) PruneImageVersions(ctx context.Context, id uuid.UUID, keep int, maxAge time.Duration) ([]*models.ImageVersion, error) {
+	removed, err := r.Repository.PruneImageVersions(ctx, id, keep, maxAge)
+	r.invalidate(id)
+	return removed, err
+}
+
+// ListenImageStatus listens on the underlying repository, dropping the cached image of
+// every event first, so the changes made by other processes are read right away
+func (r *Repository) ListenImageStatus(ctx context.Context, handle func(*models.ImageStatusEvent)) error {
+	return r.Repository.ListenImageStatus(ctx, func(event *models.ImageStatusEvent) {
+		r.invalidate(event.ImageID)
+		handle(event)
+	})
+}
+
+// invalidate drops the cached image and every cached list page, since any of them may contain it
+func (r *Repository) invalidate(id uuid.UUID) {
+	r.mu.Lock()
+	delete(r.images, id)
+	r.lists = make(map[string]listEntry)
+	r.mu.Unlock()
+}
+
+// evictExpiredLocked removes expired entries. The caller must hold the write lock.
+func (r *Repository) evictExpiredLocked() {
+	now := time.Now()
+	for id, entry := range r.images {
+		if now.After(entry.expiresAt) {
+			delete(r.images, id)
+		}
+	}
+	for key, entry := range r.lists {
+		if now.After(entry.expiresAt) {
+			delete(r.lists, key)
+		}
+	}
+}
+
+// settled reports whether the worker is done with an image, so that it only changes
+// through writes that invalidate the cache
+func settled(img *models.Image) bool {
+	return img.Status == models.StatusCompleted
+}
+
+// allSettled reports whether the worker is done with every image of a list page
+func allSettled(images []*models.Image) bool {
+	for _, img := range images {
+		if !settled(img) {
+			return false
+		}
+	}
+	return true
+}
+
+// cloneImage copies an image with its slices and pointers, so that callers changing the
+// images they get can't change the cached ones
+func cloneImage(img *models.Image) *models.Image {
+	c := *img
+	c.DominantColors = slices.Clone(img.DominantColors)
+	c.Renditions = slices.Clone(img.Renditions)
+	c.LeaseExpiresAt = clonePtr(img.LeaseExpiresAt)
+	c.IntegrityCheckedAt = clonePtr(img.IntegrityCheckedAt)
+	c.ReplicatedAt = clonePtr(img.ReplicatedAt)
+	return &c
+}
+
+// cloneImages copies every image of a list page
+func cloneImages(images []*models.Image) []*models.Image {
+	clones := make([]*models.Image, len(images))
+	for i, img := range images {
+		clones[i] = cloneImage(img)
+	}
+	return clones
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
@@ -0,0 +1,974 @@
+// Package memory is an in-memory db.Repository for tests and ephemeral demo runs. It
+// keeps the behaviour callers rely on from the Postgres repository: conditional status
+// transitions and leases, the processing ledger, the history of status changes, stored
+// bytes and the deletes cascading from images. Full-text search matches every word of
+// the query in the original name or extracted text.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+)
+
+// record is a stored image with the columns the model doesn't expose
+type record struct {
+	models.Image
+	processingTaskID string
+	processedAt      *time.Time
+}
+
+type ledgerKey struct {
+	taskID   string
+	taskType string
+}
+
+type usageKey struct {
+	owner string
+	// day is the UTC day of daily usage, or the first day of the month of monthly usage
+	day time.Time
+}
+
+type Repository struct {
+	mu sync.RWMutex
+
+	images      map[uuid.UUID]*record
+	versions    map[uuid.UUID][]*models.ImageVersion
+	events      map[uuid.UUID][]*models.ImageEvent
+	lastEventID int64
+	deletions   map[uuid.UUID]*models.ImageDeletion
+	// uploadTokens holds the expiry of the upload tokens used
+	uploadTokens map[uuid.UUID]time.Time
+
+	outbox       map[int64]*models.OutboxTask
+	lastOutboxID int64
+	ledger       map[ledgerKey]*models.TaskRecord
+
+	dailyUsage   map[usageKey]*models.UsageCounts
+	monthlyUsage map[usageKey]*models.MonthlyUsage
+
+	// listeners are called with the status events of images, like the notifications of
+	// the images_notify_status trigger
+	listeners      map[int64]func(*models.ImageStatusEvent)
+	lastListenerID int64
+}
+
+// NewRepository creates an empty in-memory repository
+func NewRepository() db.Repository {
+	return &Repository{
+		images:       make(map[uuid.UUID]*record),
+		versions:     make(map[uuid.UUID][]*models.ImageVersion),
+		events:       make(map[uuid.UUID][]*models.ImageEvent),
+		deletions:    make(map[uuid.UUID]*models.ImageDeletion),
+		uploadTokens: make(map[uuid.UUID]time.Time),
+		outbox:       make(map[int64]*models.OutboxTask),
+		ledger:       make(map[ledgerKey]*models.TaskRecord),
+		dailyUsage:   make(map[usageKey]*models.UsageCounts),
+		monthlyUsage: make(map[usageKey]*models.MonthlyUsage),
+		listeners:    make(map[int64]func(*models.ImageStatusEvent)),
+	}
+}
+
+// GetImageByID retrieves an image by its ID
+func (r *Repository) GetImageByID(_ context.Context, id uuid.UUID) (*models.Image, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	img, ok := r.images[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", db.ErrNotFound, id)
+	}
+	return img.clone(), nil
+}
+
+// FindImageByChecksum retrieves the newest image of owner whose original has the hex
+// SHA-256 checksum, skipping failed and rejected images
+func (r *Repository) FindImageByChecksum(_ context.Context, checksum, owner string) (*models.Image, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, img := range r.sorted(models.ImageFilter{AllOwners: true}) {
+		if img.OriginalChecksum == checksum && img.Owner == owner &&
+			img.Status != models.StatusFailed && img.ModerationStatus != models.ModerationRejected {
+			return img.clone(), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: checksum %s", db.ErrNotFound, checksum)
+}
+
+// ListImages retrieves a list of images matching filter with pagination
+func (r *Repository) ListImages(_ context.Context, filter models.ImageFilter, limit, offset int) ([]*models.Image, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	matched := r.sorted(filter)
+	images := make([]*models.Image, 0)
+	for i := offset; i < len(matched) && len(images) < limit; i++ {
+		images = append(images, matched[i].clone())
+	}
+	return images, len(matched), nil
+}
+
+// CountImagesByStatus counts the images matching filter in each processing status. The
+// status of filter is ignored.
+func (r *Repository) CountImagesByStatus(_ context.Context, filter models.ImageFilter) (models.ImageStatusCounts, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	filter.Status = ""
+	var counts models.ImageStatusCounts
+	for _, img := range r.sorted(filter) {
+		counts.Add(img.Status, 1)
+	}
+	return counts, nil
+}
+
+// IterateImages calls fn for every image matching filter, newest first. The images are
+// read up front, so fn may use the repository.
+func (r *Repository) IterateImages(_ context.Context, filter models.ImageFilter, _ int, fn func(*models.Image) error) error {
+	r.mu.RLock()
+	matched := r.sorted(filter)
+	images := make([]*models.Image, len(matched))
+	for i, img := range matched {
+		images[i] = img.clone()
+	}
+	r.mu.RUnlock()
+
+	for _, img := range images {
+		if err := fn(img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateImage creates a new image record. Like the Postgres insert, only the upload
+// fields are taken from image; the others start at their defaults.
+func (r *Repository) CreateImage(_ context.Context, image *models.Image) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.images[image.ID]; ok {
+		return fmt.Errorf("error creating image: image %s already exists", image.ID)
+	}
+
+	img := newRecord(image)
+	r.images[img.ID] = img
+	img.StoredBytes = r.storedBytes(img)
+	r.recordEvent(img, "")
+	return nil
+}
+
+// UpdateImage updates an existing image record
+func (r *Repository) UpdateImage(_ context.Context, image *models.Image) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	image.UpdatedAt = time.Now()
+	r.update(image.ID, func(img *record) {
+		img.OriginalName, img.OriginalSize = image.OriginalName, image.OriginalSize
+		img.OriginalWidth, img.OriginalHeight = image.OriginalWidth, image.OriginalHeight
+		img.OriginalFormat, img.OriginalPath = image.OriginalFormat, image.OriginalPath
+		img.OptimizedPath, img.OptimizedSize = image.OptimizedPath, image.OptimizedSize
+		img.OptimizedWidth, img.OptimizedHeight = image.OptimizedWidth, image.OptimizedHeight
+		r.setStatus(img, image.Status, image.Error, image.UpdatedAt)
+	})
+	return nil
+}
+
+// DeleteImage deletes an image record along with its versions, history, pending
+// deletion, outbox tasks and ledger entries
+func (r *Repository) DeleteImage(_ context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.images[id]; !ok {
+		return fmt.Errorf("%w: %s", db.ErrNotFound, id)
+	}
+
+	delete(r.images, id)
+	delete(r.versions, id)
+	delete(r.events, id)
+	delete(r.deletions, id)
+	for outboxID, task := range r.outbox {
+		if task.ImageID == id {
+			delete(r.outbox, outboxID)
+		}
+	}
+	for key, task := range r.ledger {
+		if task.ImageID == id {
+			delete(r.ledger, key)
+		}
+	}
+	r.publish(&models.ImageStatusEvent{ImageID: id, Deleted: true})
+	return nil
+}
+
+// UpdateImageStatus updates the status of an image
+func (r *Repository) UpdateImageStatus(_ context.Context, id uuid.UUID, status models.ProcessingStatus, errorMsg string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.update(id, func(img *record) {
+		r.setStatus(img, status, errorMsg, time.Now())
+	})
+	return nil
+}
+
+// UpdateLeasedImageStatus updates the status of an image leased to workerID
+func (r *Repository) UpdateLeasedImageStatus(_ context.Context, id uuid.UUID, workerID string, status models.ProcessingStatus, errorMsg string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	img, ok := r.images[id]
+	if !ok || img.LeaseOwner != workerID {
+		return fmt.Errorf("%w: %s", db.ErrLeaseLost, id)
+	}
+	r.setStatus(img, status, errorMsg, time.Now())
+	return nil
+}
+
+// RetryImage moves a failed image back to pending to retry it, counting automatic
+// retries. It fails with ErrStatusConflict if the image is not failed.
+func (r *Repository) RetryImage(_ context.Context, id uuid.UUID, automatic bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	img, ok := r.images[id]
+	if !ok || img.Status != models.StatusFailed {
+		return fmt.Errorf("%w: image %s is not failed", db.ErrStatusConflict, id)
+	}
+
+	r.update(id, func(img *record) {
+		if automatic {
+			img.AutoRetries++
+		}
+		r.setStatus(img, models.StatusPending, "", time.Now())
+	})
+	return nil
+}
+
+// ListRetryableImages retrieves up to limit failed images to retry automatically: those
+// whose last resize task failed, that were retried fewer than maxRetries times, have no
+// task retry waiting in the outbox and failed longer ago than their backoff, baseDelay
+// doubled per retry up to maxDelay
+func (r *Repository) ListRetryableImages(_ context.Context, maxRetries int, baseDelay, maxDelay time.Duration, limit int) ([]*models.Image, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	var retryable []*record
+	for _, img := range r.images {
+		if img.Status != models.StatusFailed || img.AutoRetries >= maxRetries {
+			continue
+		}
+		backoff := time.Duration(math.Min(float64(baseDelay)*math.Pow(2, float64(img.AutoRetries)), float64(maxDelay)))
+		if img.UpdatedAt.After(now.Add(-backoff)) || r.hasOutboxTask(img.ID) {
+			continue
+		}
+		if last := r.lastTask(img.ID, "resize_image", true); last == nil || last.Status != models.TaskFailed {
+			continue
+		}
+		retryable = append(retryable, img)
+	}
+
+	slices.SortFunc(retryable, func(a, b *record) int { return a.UpdatedAt.Compare(b.UpdatedAt) })
+	images := make([]*models.Image, 0)
+	for _, img := range retryable[:min(limit, len(retryable))] {
+		images = append(images, img.clone())
+	}
+	return images, nil
+}
+
+// StartImageProcessing moves a pending image to processing and records the task doing it,
+// its attempts and the lease of workerID. A redelivered task may take over an image it left
+// processing or failed, from another worker only once that worker's lease expired; the
+// worker taken over from is returned.
+func (r *Repository) StartImageProcessing(_ context.Context, id uuid.UUID, taskID, workerID string, lease time.Duration) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	img, ok := r.images[id]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", db.ErrStatusConflict, id)
+	}
+
+	now := time.Now()
+	ownTask := img.processingTaskID == taskID
+	startable := img.Status == models.StatusPending || img.Status == models.StatusQueueFailed ||
+		((img.Status == models.StatusProcessing || img.Status == models.StatusFailed) && ownTask)
+	leaseExpired := img.LeaseExpiresAt != nil && img.LeaseExpiresAt.Before(now)
+	leasable := img.Status != models.StatusProcessing || img.LeaseOwner == "" || img.LeaseOwner == workerID || leaseExpired
+
+	if !startable {
+		return "", fmt.Errorf("%w: %s", db.ErrStatusConflict, id)
+	}
+	if !leasable {
+		return "", fmt.Errorf("%w: %s holds %s until %s", db.ErrLeaseHeld, img.LeaseOwner, id, img.LeaseExpiresAt.Format(time.RFC3339))
+	}
+
+	previousOwner := img.LeaseOwner
+	r.update(id, func(img *record) {
+		if ownTask {
+			img.ProcessingAttempts++
+		} else {
+			img.ProcessingAttempts = 1
+		}
+		expiresAt := now.Add(lease)
+		img.processingTaskID = taskID
+		img.ProcessingProgress, img.ProcessingStage = 0, ""
+		img.LeaseOwner, img.LeaseExpiresAt = workerID, &expiresAt
+		r.setStatus(img, models.StatusProcessing, "", now)
+	})
+
+	if previousOwner == workerID {
+		previousOwner = ""
+	}
+	return previousOwner, nil
+}
+
+// RenewImageLease extends the lease of workerID on an image. It fails with ErrLeaseLost
+// if the worker no longer holds it.
+func (r *Repository) RenewImageLease(_ context.Context, id uuid.UUID, workerID string, lease time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	img, ok := r.images[id]
+	if !ok || img.LeaseOwner != workerID {
+		return fmt.Errorf("%w: %s", db.ErrLeaseLost, id)
+	}
+
+	expiresAt := time.Now().Add(lease)
+	img.LeaseExpiresAt = &expiresAt
+	return nil
+}
+
+// ReleaseImageLease drops the lease of workerID on an image, if it still holds it
+func (r *Repository) ReleaseImageLease(_ context.Context, id uuid.UUID, workerID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if img, ok := r.images[id]; ok && img.LeaseOwner == workerID {
+		img.LeaseOwner, img.LeaseExpiresAt = "", nil
+	}
+	return nil
+}
+
+// UpdateImageProgress updates the progress of a processing image. Images that finished
+// processing in the meantime are left alone.
+func (r *Repository) UpdateImageProgress(_ context.Context, id uuid.UUID, percent int, stage string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if img, ok := r.images[id]; ok && img.Status == models.StatusProcessing {
+		changed := img.ProcessingProgress != percent || img.ProcessingStage != stage
+		img.ProcessingProgress, img.ProcessingStage = percent, stage
+		if changed {
+			r.publishStatus(img)
+		}
+	}
+	return nil
+}
+
+// UpdateImageOptimized updates the optimized image information of an image leased to workerID
+func (r *Repository) UpdateImageOptimized(_ context.Context, id uuid.UUID, workerID string, path string, size int64, width, height int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if img, ok := r.images[id]; !ok || img.LeaseOwner != workerID {
+		return fmt.Errorf("%w: %s", db.ErrLeaseLost, id)
+	}
+	now := time.Now()
+	r.update(id, func(img *record) {
+		img.OptimizedPath, img.OptimizedSize = path, size
+		img.OptimizedWidth, img.OptimizedHeight = width, height
+		img.processedAt = &now
+		img.ReplicationStatus = models.ReplicationPending
+		img.ProcessingProgress, img.ProcessingStage = 100, ""
+		r.setStatus(img, models.StatusCompleted, img.Error, now)
+	})
+	return nil
+}
+
+// UpdateModerationStatus updates the moderation status of an image
+func (r *Repository) UpdateModerationStatus(_ context.Context, id uuid.UUID, status models.ModerationStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.update(id, func(img *record) {
+		img.ModerationStatus, img.UpdatedAt = status, time.Now()
+	})
+	return nil
+}
+
+// UpdateImagePlaceholder updates the BlurHash and dominant colors of an image
+func (r *Repository) UpdateImagePlaceholder(_ context.Context, id uuid.UUID, blurHash string, dominantColors []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.update(id, func(img *record) {
+		img.BlurHash, img.DominantColors, img.UpdatedAt = blurHash, slices.Clone(dominantColors), time.Now()
+	})
+	return nil
+}
+
+// UpdateImageText stores the text extracted from an image
+func (r *Repository) UpdateImageText(_ context.Context, id uuid.UUID, text string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.update(id, func(img *record) {
+		img.ExtractedText, img.UpdatedAt = text, time.Now()
+	})
+	return nil
+}
+
+// UpdateImageCutout stores the path and size of the background-removed cut-out of an image
+func (r *Repository) UpdateImageCutout(_ context.Context, id uuid.UUID, path string, size int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.update(id, func(img *record) {
+		img.CutoutPath, img.CutoutSize, img.UpdatedAt = path, size, time.Now()
+		img.ReplicationStatus = models.ReplicationPending
+	})
+	return nil
+}
+
+// UpdateImageRenditions stores the additional renditions of an image
+func (r *Repository) UpdateImageRenditions(_ context.Context, id uuid.UUID, renditions []models.Rendition) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.update(id, func(img *record) {
+		img.Renditions, img.UpdatedAt = slices.Clone(renditions), time.Now()
+		img.ReplicationStatus = models.ReplicationPending
+	})
+	return nil
+}
+
+// UpdateImageQualityScore stores the perceptual quality score of the optimized image
+func (r *Repository) UpdateImageQualityScore(_ context.Context, id uuid.UUID, score float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.update(id, func(img *record) {
+		img.QualityScore, img.UpdatedAt = score, time.Now()
+	})
+	return nil
+}
+
+// UpdateImageIntegrity records the result of verifying the stored objects of an image
+func (r *Repository) UpdateImageIntegrity(_ context.Context, id uuid.UUID, status models.IntegrityStatus, checkedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.update(id, func(img *record) {
+		img.IntegrityStatus, img.IntegrityCheckedAt = status, &checkedAt
+	})
+	return nil
+}
+
+// UpdateImageReplication records the result of copying the optimized objects of an image
+// to the replication target. It leaves updated_at alone, like UpdateImageIntegrity. The
+// result is dropped if the image changed since updatedAt, when the objects were read.
+func (r *Repository) UpdateImageReplication(_ context.Context, id uuid.UUID, status models.ReplicationStatus, replicatedAt, updatedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.update(id, func(img *record) {
+		if !img.UpdatedAt.Equal(updatedAt) {
+			return
+		}
+		img.ReplicationStatus = status
+		if status == models.ReplicationReplicated {
+			img.ReplicatedAt = &replicatedAt
+		}
+	})
+	return nil
+}
+
+// NextImageVersion returns the number of the next version of an image
+func (r *Repository) NextImageVersion(_ context.Context, id uuid.UUID) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	next := 1
+	for _, v := range r.versions[id] {
+		next = max(next, v.Version+1)
+	}
+	return next, nil
+}
+
+// CreateImageVersion records an optimized output of an image
+func (r *Repository) CreateImageVersion(_ context.Context, version *models.ImageVersion) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.images[version.ImageID]; !ok {
+		return fmt.Errorf("error creating image version: image %s does not exist", version.ImageID)
+	}
+	for _, v := range r.versions[version.ImageID] {
+		if v.Version == version.Version {
+			return fmt.Errorf("error creating image version: version %d of %s already exists", version.Version, version.ImageID)
+		}
+	}
+
+	if version.CreatedAt.IsZero() {
+		version.CreatedAt = time.Now()
+	}
+	stored := *version
+	r.versions[version.ImageID] = append(r.versions[version.ImageID], &stored)
+	return nil
+}
+
+// ListImageVersions returns the versions of an image, newest first
+func (r *Repository) ListImageVersions(_ context.Context, id uuid.UUID) ([]*models.ImageVersion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var versions []*models.ImageVersion
+	for _, v := range r.versions[id] {
+		stored := *v
+		versions = append(versions, &stored)
+	}
+	slices.SortFunc(versions, func(a, b *models.ImageVersion) int { return b.Version - a.Version })
+	return versions, nil
+}
+
+// ListImageEvents returns the status transitions of an image, oldest first
+func (r *Repository) ListImageEvents(_ context.Context, id uuid.UUID) ([]*models.ImageEvent, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	events := []*models.ImageEvent{}
+	for _, e := range r.events[id] {
+		stored := *e
+		events = append(events, &stored)
+	}
+	return events, nil
+}
+
+// PromoteImageVersion copies a version into the optimized fields of its image
+func (r *Repository) PromoteImageVersion(_ context.Context, id uuid.UUID, version int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := slices.IndexFunc(r.versions[id], func(v *models.ImageVersion) bool { return v.Version == version })
+	if _, ok := r.images[id]; !ok || i < 0 {
+		return db.ErrVersionNotFound
+	}
+
+	v := r.versions[id][i]
+	r.update(id, func(img *record) {
+		img.OptimizedPath, img.OptimizedSize = v.Path, v.Size
+		img.OptimizedWidth, img.OptimizedHeight = v.Width, v.Height
+		img.QualityScore, img.UpdatedAt = v.QualityScore, time.Now()
+		img.ReplicationStatus = models.ReplicationPending
+	})
+	return nil
+}
+
+// PruneImageVersions deletes the versions outside the retention policy. The current version
+// is always kept.
+func (r *Repository) PruneImageVersions(_ context.Context, id uuid.UUID, keep int, maxAge time.Duration) ([]*models.ImageVersion, error) {
+	if keep <= 0 && maxAge <= 0 {
+		return nil, nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	img, ok := r.images[id]
+	if !ok {
+		return nil, nil
+	}
+
+	var cutoff time.Time
+	if maxAge > 0 {
+		cutoff = time.Now().Add(-maxAge)
+	}
+
+	// Rank the versions newest first to find those beyond keep
+	newest := slices.Clone(r.versions[id])
+	slices.SortFunc(newest, func(a, b *models.ImageVersion) int { return b.Version - a.Version })
+
+	var kept, removed []*models.ImageVersion
+	for rank, v := range newest {
+		if v.Path != img.OptimizedPath && ((keep > 0 && rank >= keep) || v.CreatedAt.Before(cutoff)) {
+			removed = append(removed, v)
+			continue
+		}
+		kept = append(kept, v)
+	}
+	r.versions[id] = kept
+
+	if len(removed) > 0 {
+		img.StoredBytes = r.storedBytes(img)
+	}
+	return removed, nil
+}
+
+// MoveImageOriginal renames the original of an image from one object to another, along
+// with the optimized path and versions of runs that kept the original
+func (r *Repository) MoveImageOriginal(_ context.Context, id uuid.UUID, from, to string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	img, ok := r.images[id]
+	if !ok || img.OriginalPath != from {
+		return fmt.Errorf("%w: %s", db.ErrNotFound, id)
+	}
+
+	for _, v := range r.versions[id] {
+		if v.Path == from {
+			v.Path = to
+		}
+	}
+	r.update(id, func(img *record) {
+		img.OriginalPath = to
+		if img.OptimizedPath == from {
+			img.OptimizedPath = to
+		}
+	})
+	return nil
+}
+
+// ObjectReferenced reports whether an object is used by an image other than exclude
+func (r *Repository) ObjectReferenced(_ context.Context, objectName string, exclude uuid.UUID) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.referenced(objectName, exclude), nil
+}
+
+// UnreferencedObjects returns the objects of names that no image or version uses
+func (r *Repository) UnreferencedObjects(_ context.Context, names []string) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var unreferenced []string
+	for _, name := range names {
+		if !r.referenced(name, uuid.Nil) {
+			unreferenced = append(unreferenced, name)
+		}
+	}
+	return unreferenced, nil
+}
+
+// SaveImageDeletion stores a pending deletion, replacing an earlier one of the same image
+func (r *Repository) SaveImageDeletion(_ context.Context, deletion *models.ImageDeletion) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.images[deletion.ImageID]; !ok {
+		return fmt.Errorf("error saving image deletion: image %s does not exist", deletion.ImageID)
+	}
+
+	stored := *deletion
+	r.deletions[deletion.ImageID] = &stored
+	return nil
+}
+
+// GetImageDeletion returns the pending deletion of an image
+func (r *Repository) GetImageDeletion(_ context.Context, id uuid.UUID) (*models.ImageDeletion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	deletion, ok := r.deletions[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", db.ErrDeletionNotFound, id)
+	}
+	stored := *deletion
+	return &stored, nil
+}
+
+// ListExpiredImageDeletions returns up to limit pending deletions that expired before now
+func (r *Repository) ListExpiredImageDeletions(_ context.Context, now time.Time, limit int) ([]*models.ImageDeletion, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var deletions []*models.ImageDeletion
+	for _, deletion := range r.deletions {
+		if !deletion.ExpiresAt.After(now) {
+			stored := *deletion
+			deletions = append(deletions, &stored)
+		}
+	}
+	slices.SortFunc(deletions, func(a, b *models.ImageDeletion) int { return a.ExpiresAt.Compare(b.ExpiresAt) })
+	return deletions[:min(limit, len(deletions))], nil
+}
+
+// DeleteImageDeletion drops the pending deletion of an image
+func (r *Repository) DeleteImageDeletion(_ context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.deletions, id)
+	return nil
+}
+
+// UseUploadToken records the use of an upload token, failing with db.ErrUploadTokenUsed
+// if it was used before
+func (r *Repository) UseUploadToken(_ context.Context, id uuid.UUID, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for tokenID, expires := range r.uploadTokens {
+		if expires.Before(now) {
+			delete(r.uploadTokens, tokenID)
+		}
+	}
+
+	if _, ok := r.uploadTokens[id]; ok {
+		return fmt.Errorf("%w: %s", db.ErrUploadTokenUsed, id)
+	}
+	r.uploadTokens[id] = expiresAt
+	return nil
+}
+
+// ListenImageStatus calls handle with the status events of images until ctx is done
+func (r *Repository) ListenImageStatus(ctx context.Context, handle func(*models.ImageStatusEvent)) error {
+	r.mu.Lock()
+	r.lastListenerID++
+	id := r.lastListenerID
+	r.listeners[id] = handle
+	r.mu.Unlock()
+
+	<-ctx.Done()
+
+	r.mu.Lock()
+	delete(r.listeners, id)
+	r.mu.Unlock()
+	return ctx.Err()
+}
+
+func (r *Repository) Ping(_ context.Context) error {
+	return nil
+}
+
+func (r *Repository) Close() error {
+	return nil
+}
+
+// newRecord builds the stored image of an upload, with the defaults of the images table
+func newRecord(upload *models.Image) *record {
+	status := upload.Status
+	if status == "" {
+		status = models.StatusPending
+	}
+	visibility := upload.Visibility
+	if visibility == "" {
+		visibility = models.VisibilityPublic
+	}
+
+	return &record{Image: models.Image{
+		ID:                upload.ID,
+		OriginalName:      upload.OriginalName,
+		OriginalSize:      upload.OriginalSize,
+		OriginalWidth:     upload.OriginalWidth,
+		OriginalHeight:    upload.OriginalHeight,
+		OriginalFormat:    upload.OriginalFormat,
+		OriginalPath:      upload.OriginalPath,
+		OriginalChecksum:  upload.OriginalChecksum,
+		OriginalMD5:       upload.OriginalMD5,
+		Status:            status,
+		Visibility:        visibility,
+		Owner:             upload.Owner,
+		ModerationStatus:  models.ModerationUnchecked,
+		IntegrityStatus:   models.IntegrityUnverified,
+		ReplicationStatus: models.ReplicationPending,
+		DominantColors:    []string{},
+		Renditions:        []models.Rendition{},
+		CreatedAt:         upload.CreatedAt,
+		UpdatedAt:         upload.UpdatedAt,
+	}}
+}
+
+// clone copies the image, so that callers can't change the stored one
+func (img *record) clone() *models.Image {
+	c := img.Image
+	c.DominantColors = slices.Clone(img.DominantColors)
+	c.Renditions = slices.Clone(img.Renditions)
+	c.LeaseExpiresAt = clonePtr(img.LeaseExpiresAt)
+	c.IntegrityCheckedAt = clonePtr(img.IntegrityCheckedAt)
+	c.ReplicatedAt = clonePtr(img.ReplicatedAt)
+	return &c
+}
+
+func clonePtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+// update applies fn to an image, if it exists, and recomputes its stored bytes like the
+// images_stored_bytes trigger. The caller holds the write lock.
+func (r *Repository) update(id uuid.UUID, fn func(img *record)) {
+	img, ok := r.images[id]
+	if !ok {
+		return
+	}
+	fn(img)
+	img.StoredBytes = r.storedBytes(img)
+}
+
+// setStatus changes the status of an image, recording the transition in its history like
+// the images_record_event trigger
+func (r *Repository) setStatus(img *record, status models.ProcessingStatus, errorMsg string, at time.Time) {
+	prevStatus := img.Status
+	img.Status, img.Error, img.UpdatedAt = status, errorMsg, at
+	if prevStatus != status {
+		r.recordEvent(img, prevStatus)
+		r.publishStatus(img)
+	}
+}
+
+// publishStatus publishes the status and progress of an image. The caller holds the write lock.
+func (r *Repository) publishStatus(img *record) {
+	r.publish(&models.ImageStatusEvent{
+		ImageID:  img.ID,
+		Status:   img.Status,
+		Progress: img.ProcessingProgress,
+		Stage:    img.ProcessingStage,
+	})
+}
+
+// publish calls the listeners with event. The caller holds the write lock.
+func (r *Repository) publish(event *models.ImageStatusEvent) {
+	for _, handle := range r.listeners {
+		handle(event)
+	}
+}
+
+// recordEvent appends the transition of an image from prevStatus, empty for a new image,
+// to its history. Transitions into and out of processing are attributed to the worker and
+// attempt of the resize task processing the image.
+func (r *Repository) recordEvent(img *record, prevStatus models.ProcessingStatus) {
+	since := img.CreatedAt
+	if events := r.events[img.ID]; len(events) > 0 {
+		since = events[len(events)-1].CreatedAt
+	}
+
+	now := time.Now()
+	r.lastEventID++
+	event := &models.ImageEvent{
+		ID:         r.lastEventID,
+		ImageID:    img.ID,
+		FromStatus: prevStatus,
+		Status:     img.Status,
+		Error:      img.Error,
+		DurationMs: max(now.Sub(since).Milliseconds(), 0),
+		CreatedAt:  now,
+	}
+	if (prevStatus == models.StatusProcessing || img.Status == models.StatusProcessing) && img.processingTaskID != "" {
+		if task, ok := r.ledger[ledgerKey{img.processingTaskID, "resize_image"}]; ok {
+			event.WorkerID, event.Attempt = task.WorkerID, task.Attempt
+		}
+	}
+	r.events[img.ID] = append(r.events[img.ID], event)
+}
+
+// storedBytes totals the objects kept for an image: original, optimized, cut-out,
+// renditions and the older versions not shared with the original or optimized image
+func (r *Repository) storedBytes(img *record) int64 {
+	total := img.OriginalSize + img.OptimizedSize + img.CutoutSize
+	for _, rendition := range img.Renditions {
+		total += rendition.Size
+	}
+	for _, v := range r.versions[img.ID] {
+		if v.Path != img.OptimizedPath && v.Path != img.OriginalPath {
+			total += v.Size
+		}
+	}
+	return total
+}
+
+// referenced reports whether an image other than exclude, or a version of one, uses an object
+func (r *Repository) referenced(objectName string, exclude uuid.UUID) bool {
+	for id, img := range r.images {
+		if id == exclude {
+			continue
+		}
+		if img.OriginalPath == objectName || img.OptimizedPath == objectName || img.CutoutPath == objectName {
+			return true
+		}
+		if slices.ContainsFunc(img.Renditions, func(rendition models.Rendition) bool { return rendition.Path == objectName }) {
+			return true
+		}
+		if slices.ContainsFunc(r.versions[id], func(v *models.ImageVersion) bool { return v.Path == objectName }) {
+			return true
+		}
+	}
+	return false
+}
+
+// sorted returns the images matching filter, newest first. The caller holds the lock.
+func (r *Repository) sorted(filter models.ImageFilter) []*record {
+	var matched []*record
+	for _, img := range r.images {
+		if matches(img, filter) {
+			matched = append(matched, img)
+		}
+	}
+	slices.SortFunc(matched, func(a, b *record) int {
+		if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(b.ID.String(), a.ID.String())
+	})
+	return matched
+}
+
+// matches reports whether an image matches filter, as filterClause does in SQL
+func matches(img *record, filter models.ImageFilter) bool {
+	if filter.Query != "" {
+		text := strings.ToLower(img.OriginalName + " " + img.ExtractedText)
+		for _, word := range strings.Fields(strings.ToLower(filter.Query)) {
+			if !strings.Contains(text, word) {
+				return false
+			}
+		}
+	}
+
+	// Private images are only listed for their owner
+	switch {
+	case filter.AllOwners:
+	case filter.Viewer != "":
+		if img.Visibility != models.VisibilityPublic && img.Owner != filter.Viewer {
+			return false
+		}
+	default:
+		if img.Visibility != models.VisibilityPublic {
+			return false
+		}
+	}
+
+	if !filter.CheckedBefore.IsZero() && img.IntegrityCheckedAt != nil && !img.IntegrityCheckedAt.Before(filter.CheckedBefore) {
+		return false
+	}
+	if filter.Unreplicated && (img.Status != models.StatusCompleted || img.ReplicationStatus == models.ReplicationReplicated) {
+		return false
+	}
+	if filter.Status != "" && img.Status != filter.Status {
+		return false
+	}
+	if !filter.UpdatedSince.IsZero() && img.UpdatedAt.Before(filter.UpdatedSince) {
+		return false
+	}
+	if filter.ErrorLike != "" && !strings.Contains(strings.ToLower(img.Error), strings.ToLower(filter.ErrorLike)) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,226 @@
+package memory
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+)
+
+// GetStorageUsage sums the bytes stored for all images, in total and per original format
+func (r *Repository) GetStorageUsage(_ context.Context) (*models.StorageUsage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	formats := make(map[string]*models.FormatStorageUsage)
+	usage := &models.StorageUsage{ByFormat: []models.FormatStorageUsage{}}
+	for _, img := range r.images {
+		format, ok := formats[img.OriginalFormat]
+		if !ok {
+			format = &models.FormatStorageUsage{Format: img.OriginalFormat}
+			formats[img.OriginalFormat] = format
+		}
+
+		var reclaimed int64
+		if img.OptimizedSize > 0 {
+			reclaimed = img.OriginalSize - img.OptimizedSize
+		}
+		format.Images++
+		format.TotalBytes += img.StoredBytes
+		format.OriginalBytes += img.OriginalSize
+		format.OptimizedBytes += img.OptimizedSize
+		format.ReclaimedBytes += reclaimed
+
+		usage.Images++
+		usage.TotalBytes += img.StoredBytes
+		usage.OriginalBytes += img.OriginalSize
+		usage.OptimizedBytes += img.OptimizedSize
+		usage.CutoutBytes += img.CutoutSize
+		usage.RenditionBytes += img.StoredBytes - img.OriginalSize - img.OptimizedSize - img.CutoutSize
+		usage.ReclaimedBytes += reclaimed
+	}
+
+	for _, format := range formats {
+		usage.ByFormat = append(usage.ByFormat, *format)
+	}
+	slices.SortFunc(usage.ByFormat, func(a, b models.FormatStorageUsage) int { return strings.Compare(a.Format, b.Format) })
+	return usage, nil
+}
+
+// GetImageStats aggregates counts by status, size reduction, processing latency and the
+// uploads per day since the given day. Nothing is materialized in memory, so fromView
+// is ignored.
+func (r *Repository) GetImageStats(_ context.Context, since time.Time, _ bool) (*models.ImageStats, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stats := &models.ImageStats{
+		ByStatus:      make(map[models.ProcessingStatus]int64),
+		UploadsPerDay: []models.DailyUploads{},
+	}
+
+	var reductions, latencies []float64
+	uploads := make(map[string]int64)
+	for _, img := range r.images {
+		stats.ByStatus[img.Status]++
+		stats.Total++
+
+		if img.Status == models.StatusCompleted && img.OriginalSize > 0 && img.OptimizedSize > 0 {
+			reductions = append(reductions, 100*float64(img.OriginalSize-img.OptimizedSize)/float64(img.OriginalSize))
+			if img.processedAt != nil {
+				latencies = append(latencies, float64(img.processedAt.Sub(img.CreatedAt).Milliseconds()))
+			}
+		}
+
+		if !img.CreatedAt.Before(since) {
+			uploads[img.CreatedAt.In(since.Location()).Format(time.DateOnly)]++
+		}
+	}
+
+	for _, reduction := range reductions {
+		stats.AverageReduction += reduction / float64(len(reductions))
+	}
+	slices.Sort(latencies)
+	stats.ProcessingLatencyP50MS = percentile(latencies, 0.5)
+	stats.ProcessingLatencyP95MS = percentile(latencies, 0.95)
+
+	// Include days without uploads so dashboards get a continuous series
+	for day := since; !day.After(time.Now()); day = day.AddDate(0, 0, 1) {
+		key := day.Format(time.DateOnly)
+		stats.UploadsPerDay = append(stats.UploadsPerDay, models.DailyUploads{Day: key, Uploads: uploads[key]})
+	}
+
+	return stats, nil
+}
+
+// RefreshImageStats does nothing: GetImageStats always reads the images
+func (r *Repository) RefreshImageStats(_ context.Context) error {
+	return nil
+}
+
+// AddUsage adds usage counted from requests to the usage of owner on the UTC day of at.
+// StorageByteDays is ignored; it comes from RecordStorageUsage.
+func (r *Repository) AddUsage(_ context.Context, owner string, at time.Time, usage models.UsageCounts) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	daily := r.daily(owner, at)
+	daily.Uploads += usage.Uploads
+	daily.ProcessedBytes += usage.ProcessedBytes
+	daily.Transformations += usage.Transformations
+	return nil
+}
+
+// RecordStorageUsage snapshots the bytes stored by every owner as their storage of the UTC
+// day of at, replacing earlier snapshots of the day
+func (r *Repository) RecordStorageUsage(_ context.Context, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := make(map[string]int64)
+	for _, img := range r.images {
+		if img.Owner != "" {
+			stored[img.Owner] += img.StoredBytes
+		}
+	}
+	for owner, bytes := range stored {
+		r.daily(owner, at).StorageByteDays = bytes
+	}
+	return nil
+}
+
+// RollupUsage recomputes the monthly usage of every month from the one of since on, from
+// the daily usage
+func (r *Repository) RollupUsage(_ context.Context, since time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	from := monthOf(since)
+	now := time.Now()
+	rolled := make(map[usageKey]*models.MonthlyUsage)
+	for key, daily := range r.dailyUsage {
+		if key.day.Before(from) {
+			continue
+		}
+		month := usageKey{owner: key.owner, day: monthOf(key.day)}
+		usage, ok := rolled[month]
+		if !ok {
+			usage = &models.MonthlyUsage{Owner: key.owner, Month: month.day, UpdatedAt: now}
+			rolled[month] = usage
+		}
+		usage.Uploads += daily.Uploads
+		usage.ProcessedBytes += daily.ProcessedBytes
+		usage.Transformations += daily.Transformations
+		usage.StorageByteDays += daily.StorageByteDays
+	}
+
+	for key, usage := range rolled {
+		r.monthlyUsage[key] = usage
+	}
+	return nil
+}
+
+// ListMonthlyUsage retrieves the monthly usage selected by filter, by month then owner
+func (r *Repository) ListMonthlyUsage(_ context.Context, filter models.UsageFilter) ([]*models.MonthlyUsage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	usage := make([]*models.MonthlyUsage, 0)
+	for _, u := range r.monthlyUsage {
+		if filter.Owner != "" && u.Owner != filter.Owner {
+			continue
+		}
+		if !filter.From.IsZero() && u.Month.Before(monthOf(filter.From)) {
+			continue
+		}
+		if !filter.To.IsZero() && u.Month.After(monthOf(filter.To)) {
+			continue
+		}
+		stored := *u
+		usage = append(usage, &stored)
+	}
+
+	slices.SortFunc(usage, func(a, b *models.MonthlyUsage) int {
+		if c := a.Month.Compare(b.Month); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Owner, b.Owner)
+	})
+	return usage, nil
+}
+
+// daily returns the daily usage of owner on the UTC day of at, adding it if needed. The
+// caller holds the write lock.
+func (r *Repository) daily(owner string, at time.Time) *models.UsageCounts {
+	at = at.UTC()
+	key := usageKey{owner: owner, day: time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)}
+	usage, ok := r.dailyUsage[key]
+	if !ok {
+		usage = &models.UsageCounts{}
+		r.dailyUsage[key] = usage
+	}
+	return usage
+}
+
+// monthOf returns the first day of the UTC month of t
+func monthOf(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// percentile interpolates the p percentile of sorted values like percentile_cont, 0 if
+// there are none
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	if lower+1 >= len(sorted) {
+		return sorted[lower]
+	}
+	return sorted[lower] + (pos-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
@@ -0,0 +1,190 @@
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+)
+
+// SaveOutboxTask persists a task that could not be published
+func (r *Repository) SaveOutboxTask(_ context.Context, task *models.OutboxTask) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.images[task.ImageID]; !ok {
+		return fmt.Errorf("error saving outbox task: image %s does not exist", task.ImageID)
+	}
+
+	r.lastOutboxID++
+	task.ID = r.lastOutboxID
+	stored := *task
+	stored.Payload = slices.Clone(task.Payload)
+	r.outbox[task.ID] = &stored
+	return nil
+}
+
+// ClaimOutboxTasks returns up to limit tasks that are due for publishing and pushes their
+// next attempt back by lease, so concurrent relays do not publish the same task
+func (r *Repository) ClaimOutboxTasks(_ context.Context, limit int, lease time.Duration) ([]*models.OutboxTask, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	var due []*models.OutboxTask
+	for _, task := range r.outbox {
+		if !task.NextAttemptAt.After(now) {
+			due = append(due, task)
+		}
+	}
+	slices.SortFunc(due, func(a, b *models.OutboxTask) int { return cmp.Compare(a.ID, b.ID) })
+
+	var tasks []*models.OutboxTask
+	for _, task := range due[:min(limit, len(due))] {
+		task.NextAttemptAt = now.Add(lease)
+		claimed := *task
+		claimed.Payload = slices.Clone(task.Payload)
+		tasks = append(tasks, &claimed)
+	}
+	return tasks, nil
+}
+
+// DeleteOutboxTask removes a task once it has been published
+func (r *Repository) DeleteOutboxTask(_ context.Context, id int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.outbox, id)
+	return nil
+}
+
+// RescheduleOutboxTask records a failed publish attempt and when to try again
+func (r *Repository) RescheduleOutboxTask(_ context.Context, id int64, lastError string, nextAttemptAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if task, ok := r.outbox[id]; ok {
+		task.Attempts++
+		task.LastError, task.NextAttemptAt = lastError, nextAttemptAt
+	}
+	return nil
+}
+
+// BeginTask inserts the ledger entry of a task, or counts another attempt of one that did
+// not complete
+func (r *Repository) BeginTask(_ context.Context, task *models.TaskRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := ledgerKey{task.TaskID, task.TaskType}
+	entry, ok := r.ledger[key]
+	switch {
+	case ok && entry.Status == models.TaskCompleted:
+		task.Status = entry.Status
+		return db.ErrTaskCompleted
+	case ok:
+		entry.Attempt++
+		entry.WorkerID = task.WorkerID
+		if len(task.Payload) > 0 {
+			entry.Payload = slices.Clone(task.Payload)
+		}
+	default:
+		// Ledger entries of deleted images are deleted with them
+		if _, exists := r.images[task.ImageID]; !exists {
+			return fmt.Errorf("%w: %s", db.ErrNotFound, task.ImageID)
+		}
+		entry = &models.TaskRecord{
+			TaskID:   task.TaskID,
+			ImageID:  task.ImageID,
+			TaskType: task.TaskType,
+			Attempt:  1,
+			WorkerID: task.WorkerID,
+		}
+		if len(task.Payload) > 0 {
+			entry.Payload = slices.Clone(task.Payload)
+		}
+		r.ledger[key] = entry
+	}
+
+	entry.Status, entry.StartedAt, entry.FinishedAt = models.TaskRunning, time.Now(), nil
+	task.Attempt, task.Status, task.LastError, task.StartedAt = entry.Attempt, entry.Status, entry.LastError, entry.StartedAt
+	return nil
+}
+
+// LastTask retrieves the ledger entry of the last task of taskType started for an image
+func (r *Repository) LastTask(_ context.Context, imageID uuid.UUID, taskType string) (*models.TaskRecord, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry := r.lastTask(imageID, taskType, false)
+	if entry == nil {
+		return nil, fmt.Errorf("%w: no %s task for %s", db.ErrNotFound, taskType, imageID)
+	}
+	task := *entry
+	task.FinishedAt = clonePtr(entry.FinishedAt)
+	task.Payload = slices.Clone(entry.Payload)
+	return &task, nil
+}
+
+// FinishTask records the outcome of an attempt of a task
+func (r *Repository) FinishTask(_ context.Context, task *models.TaskRecord, status models.TaskStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.ledger[ledgerKey{task.TaskID, task.TaskType}]
+	if !ok || entry.Attempt != task.Attempt {
+		return nil
+	}
+
+	now := time.Now()
+	entry.Status, entry.LastError, entry.FinishedAt = status, task.LastError, &now
+	return nil
+}
+
+// AbandonTask uncounts the attempt begun with task and gives the entry back to the worker
+// holding the lease of its image
+func (r *Repository) AbandonTask(_ context.Context, task *models.TaskRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.ledger[ledgerKey{task.TaskID, task.TaskType}]
+	if !ok || entry.Attempt != task.Attempt {
+		return nil
+	}
+	entry.Attempt--
+	if img, ok := r.images[entry.ImageID]; ok {
+		entry.WorkerID = img.LeaseOwner
+	}
+	return nil
+}
+
+// lastTask returns the ledger entry of the last task of taskType started for an image,
+// only among those that kept their payload if withPayload. The caller holds the lock.
+func (r *Repository) lastTask(imageID uuid.UUID, taskType string, withPayload bool) *models.TaskRecord {
+	var last *models.TaskRecord
+	for _, entry := range r.ledger {
+		if entry.ImageID != imageID || entry.TaskType != taskType || (withPayload && entry.Payload == nil) {
+			continue
+		}
+		if last == nil || entry.StartedAt.After(last.StartedAt) {
+			last = entry
+		}
+	}
+	return last
+}
+
+// hasOutboxTask reports whether a task of an image waits in the outbox. The caller holds
+// the lock.
+func (r *Repository) hasOutboxTask(imageID uuid.UUID) bool {
+	for _, task := range r.outbox {
+		if task.ImageID == imageID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ImageDeletion is a pending two-step deletion of an image. Only the SHA-256 hash of the
+// confirmation token is stored.
+type ImageDeletion struct {
+	ImageID     uuid.UUID `json:"image_id" db:"image_id"`
+	TokenHash   string    `json:"-" db:"token_hash"`
+	RequestedAt time.Time `json:"requested_at" db:"requested_at"`
+	ExpiresAt   time.Time `json:"expires_at" db:"expires_at"`
+}
+
+// ImageDeletionResponse is returned when a deletion is pending confirmation. In deferred
+// mode the image is purged at ExpiresAt unless it is confirmed earlier; in confirm mode
+// the deletion is dropped at ExpiresAt.
+type ImageDeletionResponse struct {
+	Status        string    `json:"status"`
+	Mode          string    `json:"mode"`
+	DeletionToken string    `json:"deletion_token"`
+	ExpiresAt     time.Time `json:"expires_at"`
+}
@@ -0,0 +1,28 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ImageEvent is a status transition in the processing history of an image. Transitions
+// into and out of processing carry the worker and attempt of the resize task.
+type ImageEvent struct {
+	ID         int64            `json:"id" db:"id"`
+	ImageID    uuid.UUID        `json:"image_id" db:"image_id"`
+	FromStatus ProcessingStatus `json:"from_status,omitempty" db:"from_status"`
+	Status     ProcessingStatus `json:"status" db:"status"`
+	Error      string           `json:"error,omitempty" db:"error"`
+	WorkerID   string           `json:"worker_id,omitempty" db:"worker_id"`
+	Attempt    int              `json:"attempt,omitempty" db:"attempt"`
+	// DurationMs is how long the image was in FromStatus
+	DurationMs int64     `json:"duration_ms" db:"duration_ms"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// ImageHistoryResponse represents the processing history of an image, oldest first
+type ImageHistoryResponse struct {
+	ImageID uuid.UUID     `json:"image_id"`
+	Events  []*ImageEvent `json:"events"`
+}
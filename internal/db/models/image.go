@@ -1,9 +1,13 @@
 package models
 
 import (
+	"path"
+	"strings"
 	"time"
+	"unicode"
 
 	"github.com/google/uuid"
+	"golang.org/x/text/unicode/norm"
 )
 
 type ProcessingStatus string
@@ -13,41 +17,149 @@ const (
 	StatusProcessing ProcessingStatus = "processing"
 	StatusCompleted  ProcessingStatus = "completed"
 	StatusFailed     ProcessingStatus = "failed"
+	// StatusQueueFailed means the processing task could not be published and is waiting in the outbox
+	StatusQueueFailed ProcessingStatus = "queued_failed"
+	// StatusRejected means the upload was refused, e.g. because malware was found in it,
+	// and is never processed
+	StatusRejected ProcessingStatus = "rejected"
+)
+
+type ModerationStatus string
+
+const (
+	ModerationUnchecked   ModerationStatus = "unchecked"
+	ModerationApproved    ModerationStatus = "approved"
+	ModerationQuarantined ModerationStatus = "quarantined"
+	ModerationRejected    ModerationStatus = "rejected"
+)
+
+// IntegrityStatus is the result of the last verification of the stored objects of an
+// image against their checksums
+type IntegrityStatus string
+
+const (
+	IntegrityUnverified IntegrityStatus = "unverified"
+	IntegrityOK         IntegrityStatus = "ok"
+	// IntegrityCorrupted means an object doesn't match its checksum
+	IntegrityCorrupted IntegrityStatus = "corrupted"
+	// IntegrityMissing means an object is gone from storage
+	IntegrityMissing IntegrityStatus = "missing"
+)
+
+// ReplicationStatus tracks the copy of the optimized objects of an image to the
+// replication target
+type ReplicationStatus string
+
+const (
+	// ReplicationPending images have optimized objects that changed since their last copy
+	ReplicationPending    ReplicationStatus = "pending"
+	ReplicationReplicated ReplicationStatus = "replicated"
+	// ReplicationFailed images are retried on the next pass
+	ReplicationFailed ReplicationStatus = "failed"
+)
+
+type Visibility string
+
+const (
+	VisibilityPublic Visibility = "public"
+	// VisibilityPrivate images are only visible to their owner
+	VisibilityPrivate Visibility = "private"
 )
 
 // Image represents an image in the system
 type Image struct {
-	ID              uuid.UUID        `json:"id" db:"id"`
-	OriginalName    string           `json:"original_name" db:"original_name"`
-	OriginalSize    int64            `json:"original_size" db:"original_size"`
-	OriginalWidth   int              `json:"original_width" db:"original_width"`
-	OriginalHeight  int              `json:"original_height" db:"original_height"`
-	OriginalFormat  string           `json:"original_format" db:"original_format"`
-	OriginalPath    string           `json:"original_path" db:"original_path"`
-	OptimizedPath   string           `json:"optimized_path,omitempty" db:"optimized_path"`
-	OptimizedSize   int64            `json:"optimized_size,omitempty" db:"optimized_size"`
-	OptimizedWidth  int              `json:"optimized_width,omitempty" db:"optimized_width"`
-	OptimizedHeight int              `json:"optimized_height,omitempty" db:"optimized_height"`
-	Status          ProcessingStatus `json:"status" db:"status"`
-	Error           string           `json:"error,omitempty" db:"error"`
-	CreatedAt       time.Time        `json:"created_at" db:"created_at"`
-	UpdatedAt       time.Time        `json:"updated_at" db:"updated_at"`
+	ID               uuid.UUID        `json:"id" db:"id"`
+	OriginalName     string           `json:"original_name" db:"original_name"`
+	OriginalSize     int64            `json:"original_size" db:"original_size"`
+	OriginalWidth    int              `json:"original_width" db:"original_width"`
+	OriginalHeight   int              `json:"original_height" db:"original_height"`
+	OriginalFormat   string           `json:"original_format" db:"original_format"`
+	OriginalPath     string           `json:"original_path" db:"original_path"`
+	OptimizedPath    string           `json:"optimized_path,omitempty" db:"optimized_path"`
+	OptimizedSize    int64            `json:"optimized_size,omitempty" db:"optimized_size"`
+	OptimizedWidth   int              `json:"optimized_width,omitempty" db:"optimized_width"`
+	OptimizedHeight  int              `json:"optimized_height,omitempty" db:"optimized_height"`
+	Status           ProcessingStatus `json:"status" db:"status"`
+	Error            string           `json:"error,omitempty" db:"error"`
+	ModerationStatus ModerationStatus `json:"moderation_status" db:"moderation_status"`
+	BlurHash         string           `json:"blurhash,omitempty" db:"blurhash"`
+	DominantColors   []string         `json:"dominant_colors,omitempty" db:"dominant_colors"`
+	ExtractedText    string           `json:"extracted_text,omitempty" db:"extracted_text"`
+	CutoutPath       string           `json:"cutout_path,omitempty" db:"cutout_path"`
+	CutoutSize       int64            `json:"cutout_size,omitempty" db:"cutout_size"`
+	Renditions       []Rendition      `json:"renditions,omitempty" db:"renditions"`
+	QualityScore     float64          `json:"quality_score,omitempty" db:"quality_score"`
+	Visibility       Visibility       `json:"visibility" db:"visibility"`
+	// Owner is the API key owner that uploaded the image, empty for anonymous uploads
+	Owner string `json:"owner,omitempty" db:"owner"`
+	// ProcessingAttempts counts the runs of the current resize task, including retries
+	ProcessingAttempts int `json:"processing_attempts" db:"processing_attempts"`
+	// ProcessingProgress is the percentage done of the running resize task, in ProcessingStage
+	ProcessingProgress int    `json:"processing_progress" db:"processing_progress"`
+	ProcessingStage    string `json:"processing_stage,omitempty" db:"processing_stage"`
+	// AutoRetries counts the automatic retries of the image after it failed
+	AutoRetries int `json:"auto_retries" db:"auto_retries"`
+	// LeaseOwner is the worker processing the image, which holds it until LeaseExpiresAt
+	LeaseOwner     string     `json:"lease_owner,omitempty" db:"lease_owner"`
+	LeaseExpiresAt *time.Time `json:"lease_expires_at,omitempty" db:"lease_expires_at"`
+	// OriginalChecksum and OriginalMD5 are the hex SHA-256 and MD5 of the original, computed at upload
+	OriginalChecksum   string            `json:"original_checksum,omitempty" db:"original_checksum"`
+	OriginalMD5        string            `json:"original_md5,omitempty" db:"original_md5"`
+	IntegrityStatus    IntegrityStatus   `json:"integrity_status" db:"integrity_status"`
+	IntegrityCheckedAt *time.Time        `json:"integrity_checked_at,omitempty" db:"integrity_checked_at"`
+	ReplicationStatus  ReplicationStatus `json:"replication_status" db:"replication_status"`
+	ReplicatedAt       *time.Time        `json:"replicated_at,omitempty" db:"replicated_at"`
+	// StoredBytes is the total size of the original, optimized, older version, rendition and cut-out objects
+	StoredBytes int64     `json:"stored_bytes" db:"stored_bytes"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// maxDisplayNameLength is the longest original name kept, in characters
+const maxDisplayNameLength = 255
+
+// DisplayName cleans a client supplied file name for display. Directories sent by some
+// clients are dropped, the name is normalized to NFC, control characters are removed and
+// it is cut to maxDisplayNameLength characters, keeping its extension. Object keys are
+// named separately, so the name keeps any script.
+func DisplayName(fileName string) string {
+	fileName = fileName[strings.LastIndexAny(fileName, `/\`)+1:]
+	fileName = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, norm.NFC.String(fileName)))
+
+	runes := []rune(fileName)
+	if len(runes) <= maxDisplayNameLength {
+		return fileName
+	}
+	ext := []rune(path.Ext(fileName))
+	if len(ext) > 10 {
+		ext = nil
+	}
+	return string(runes[:maxDisplayNameLength-len(ext)]) + string(ext)
 }
 
 // NewImage creates a new Image with default values
 func NewImage(originalName string, originalSize int64, originalWidth, originalHeight int, originalFormat, originalPath string) *Image {
 	now := time.Now()
 	return &Image{
-		ID:             uuid.New(),
-		OriginalName:   originalName,
-		OriginalSize:   originalSize,
-		OriginalWidth:  originalWidth,
-		OriginalHeight: originalHeight,
-		OriginalFormat: originalFormat,
-		OriginalPath:   originalPath,
-		Status:         StatusPending,
-		CreatedAt:      now,
-		UpdatedAt:      now,
+		ID:                uuid.New(),
+		OriginalName:      DisplayName(originalName),
+		OriginalSize:      originalSize,
+		OriginalWidth:     originalWidth,
+		OriginalHeight:    originalHeight,
+		OriginalFormat:    originalFormat,
+		OriginalPath:      originalPath,
+		Status:            StatusPending,
+		ModerationStatus:  ModerationUnchecked,
+		IntegrityStatus:   IntegrityUnverified,
+		ReplicationStatus: ReplicationPending,
+		Visibility:        VisibilityPublic,
+		CreatedAt:         now,
+		UpdatedAt:         now,
 	}
 }
 
@@ -55,42 +167,204 @@ func NewImage(originalName string, originalSize int64, originalWidth, originalHe
 func NewImageWithID(id uuid.UUID, originalName string, originalSize int64, originalWidth, originalHeight int, originalFormat, originalPath string) *Image {
 	now := time.Now()
 	return &Image{
-		ID:             id,
-		OriginalName:   originalName,
-		OriginalSize:   originalSize,
-		OriginalWidth:  originalWidth,
-		OriginalHeight: originalHeight,
-		OriginalFormat: originalFormat,
-		OriginalPath:   originalPath,
-		Status:         StatusPending,
-		CreatedAt:      now,
-		UpdatedAt:      now,
+		ID:                id,
+		OriginalName:      DisplayName(originalName),
+		OriginalSize:      originalSize,
+		OriginalWidth:     originalWidth,
+		OriginalHeight:    originalHeight,
+		OriginalFormat:    originalFormat,
+		OriginalPath:      originalPath,
+		Status:            StatusPending,
+		ModerationStatus:  ModerationUnchecked,
+		IntegrityStatus:   IntegrityUnverified,
+		ReplicationStatus: ReplicationPending,
+		Visibility:        VisibilityPublic,
+		CreatedAt:         now,
+		UpdatedAt:         now,
 	}
 }
 
+// Rendition is an additional stored size of an image
+type Rendition struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// Format is the image format of the rendition, empty for renditions stored before
+	// it was recorded
+	Format string `json:"format,omitempty"`
+}
+
+// Withheld reports whether moderation prevents the image from being served
+func (i *Image) Withheld() bool {
+	return i.ModerationStatus == ModerationQuarantined || i.ModerationStatus == ModerationRejected
+}
+
+// Private reports whether the image is only visible to its owner
+func (i *Image) Private() bool {
+	return i.Visibility == VisibilityPrivate
+}
+
+// VisibleTo reports whether owner may see the image. Anonymous callers ("") only see
+// public images.
+func (i *Image) VisibleTo(owner string) bool {
+	return !i.Private() || (owner != "" && i.Owner == owner)
+}
+
+// ImageFilter narrows down image listings
+type ImageFilter struct {
+	// Query is a full-text search over the original name and the extracted text
+	Query string
+	// Viewer limits the results to public images and the private images it owns
+	Viewer string
+	// AllOwners includes the private images of every owner, for maintenance tasks
+	AllOwners bool
+	// CheckedBefore limits the results to images whose integrity wasn't verified since
+	CheckedBefore time.Time
+	// Unreplicated limits the results to completed images not replicated since they changed
+	Unreplicated bool
+	// Status limits the results to images in a processing status, any if empty
+	Status ProcessingStatus
+	// UpdatedSince limits the results to images updated since, such as failed since
+	UpdatedSince time.Time
+	// ErrorLike limits the results to images whose error contains it, ignoring case
+	ErrorLike string
+}
+
 // ImageListResponse represents the response for image listing
 type ImageListResponse struct {
-	Images []*Image `json:"images"`
-	Total  int      `json:"total"`
+	Images []*Image          `json:"images"`
+	Total  int               `json:"total"`
+	Counts ImageStatusCounts `json:"counts"`
+}
+
+// ImageStatusCounts counts the images matching a listing in each processing status,
+// regardless of the status filter
+type ImageStatusCounts struct {
+	Pending     int `json:"pending"`
+	Processing  int `json:"processing"`
+	Completed   int `json:"completed"`
+	Failed      int `json:"failed"`
+	QueueFailed int `json:"queued_failed"`
+	Rejected    int `json:"rejected"`
+}
+
+// Add counts n images in status
+func (c *ImageStatusCounts) Add(status ProcessingStatus, n int) {
+	switch status {
+	case StatusPending:
+		c.Pending += n
+	case StatusProcessing:
+		c.Processing += n
+	case StatusCompleted:
+		c.Completed += n
+	case StatusFailed:
+		c.Failed += n
+	case StatusQueueFailed:
+		c.QueueFailed += n
+	case StatusRejected:
+		c.Rejected += n
+	}
 }
 
 // ImageResponse represents the response for a single image
 type ImageResponse struct {
-	ID            uuid.UUID        `json:"id"`
-	OriginalName  string           `json:"original_name"`
-	Status        ProcessingStatus `json:"status"`
-	OriginalURL   string           `json:"original_url,omitempty"`
-	OptimizedURL  string           `json:"optimized_url,omitempty"`
-	OriginalSize  int64            `json:"original_size"`
-	OptimizedSize int64            `json:"optimized_size,omitempty"`
-	Reduction     float64          `json:"reduction,omitempty"`
-	CreatedAt     time.Time        `json:"created_at"`
-	UpdatedAt     time.Time        `json:"updated_at"`
-	Error         string           `json:"error,omitempty"`
+	ID               uuid.UUID         `json:"id"`
+	OriginalName     string            `json:"original_name"`
+	Status           ProcessingStatus  `json:"status"`
+	OriginalURL      string            `json:"original_url,omitempty"`
+	OptimizedURL     string            `json:"optimized_url,omitempty"`
+	CutoutURL        string            `json:"cutout_url,omitempty"`
+	OriginalSize     int64             `json:"original_size"`
+	OptimizedSize    int64             `json:"optimized_size,omitempty"`
+	Reduction        float64           `json:"reduction,omitempty"`
+	QualityScore     float64           `json:"quality_score,omitempty"`
+	StoredBytes      int64             `json:"stored_bytes"`
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+	Error            string            `json:"error,omitempty"`
+	Attempts         int               `json:"attempts,omitempty"`
+	Progress         int               `json:"progress,omitempty"`
+	Stage            string            `json:"stage,omitempty"`
+	ModerationStatus ModerationStatus  `json:"moderation_status"`
+	Visibility       Visibility        `json:"visibility"`
+	BlurHash         string            `json:"blurhash,omitempty"`
+	DominantColors   []string          `json:"dominant_colors,omitempty"`
+	ExtractedText    string            `json:"extracted_text,omitempty"`
+	TransformURLs    map[string]string `json:"transform_urls,omitempty"`
+	RenditionURLs    map[string]string `json:"rendition_urls,omitempty"`
+}
+
+// UploadTokenResponse is a signed single-use upload token
+type UploadTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// UploadURL is where the token is used, sent in the X-Upload-Token header or the
+	// upload_token query parameter
+	UploadURL string `json:"upload_url"`
+}
+
+// ImageComparisonResponse compares the optimized image to its original
+type ImageComparisonResponse struct {
+	ID            uuid.UUID `json:"id"`
+	OriginalURL   string    `json:"original_url,omitempty"`
+	OptimizedURL  string    `json:"optimized_url,omitempty"`
+	OriginalSize  int64     `json:"original_size"`
+	OptimizedSize int64     `json:"optimized_size"`
+	Reduction     float64   `json:"reduction"`
+	// SSIM is the structural similarity of the optimized image to the original, from 0 to 1
+	SSIM float64 `json:"ssim"`
+	// PixelDiff is the percentage of pixels whose color changed noticeably
+	PixelDiff float64 `json:"pixel_diff"`
+}
+
+// SrcsetResponse is the srcset of an image, listing a URL for every requested width
+type SrcsetResponse struct {
+	ID uuid.UUID `json:"id"`
+	// Srcset is ready to use as the srcset attribute of an img element
+	Srcset   string          `json:"srcset"`
+	Variants []SrcsetVariant `json:"variants"`
+}
+
+// SrcsetVariant is a width of a srcset
+type SrcsetVariant struct {
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Format string `json:"format,omitempty"`
+	URL    string `json:"url"`
+	// Stored is set for widths served from a stored variant; the others are rendered on
+	// request by a transformation URL
+	Stored bool `json:"stored"`
+}
+
+// ImageProgressEvent is sent on the progress stream of an image whenever its processing
+// status or progress changes
+type ImageProgressEvent struct {
+	Status   ProcessingStatus `json:"status"`
+	Progress int              `json:"progress"`
+	Stage    string           `json:"stage,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// ImageStatusEvent is a change of the status or progress of an image, notified by the
+// database whichever process made it
+type ImageStatusEvent struct {
+	ImageID  uuid.UUID        `json:"id"`
+	Status   ProcessingStatus `json:"status"`
+	Progress int              `json:"progress"`
+	Stage    string           `json:"stage"`
+	// Deleted is set when the image was deleted, without any status
+	Deleted bool `json:"deleted,omitempty"`
 }
 
 // ImageUploadResponse represents the response for image upload
 type ImageUploadResponse struct {
 	ID     uuid.UUID `json:"id"`
 	Status string    `json:"status"`
+	// Duplicate is set when the upload matched the checksum of an earlier upload, whose
+	// image is returned instead
+	Duplicate bool `json:"duplicate,omitempty"`
+	// OptimizedURL is set when the upload was processed inline with sync=true
+	OptimizedURL string `json:"optimized_url,omitempty"`
 }
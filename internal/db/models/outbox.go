@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// OutboxTask is a queue task persisted because it could not be published.
+// Payload holds the JSON encoded task.
+type OutboxTask struct {
+	ID            int64     `json:"id" db:"id"`
+	ImageID       uuid.UUID `json:"image_id" db:"image_id"`
+	Payload       []byte    `json:"payload" db:"payload"`
+	Attempts      int       `json:"attempts" db:"attempts"`
+	LastError     string    `json:"last_error,omitempty" db:"last_error"`
+	NextAttemptAt time.Time `json:"next_attempt_at" db:"next_attempt_at"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,42 @@
+package models
+
+// StorageUsage summarises the bytes stored for all images
+type StorageUsage struct {
+	Images         int64 `json:"images"`
+	TotalBytes     int64 `json:"total_bytes"`
+	OriginalBytes  int64 `json:"original_bytes"`
+	OptimizedBytes int64 `json:"optimized_bytes"`
+	RenditionBytes int64 `json:"rendition_bytes"`
+	CutoutBytes    int64 `json:"cutout_bytes"`
+	// ReclaimedBytes is how much smaller the optimized images are than their originals
+	ReclaimedBytes int64                `json:"reclaimed_bytes"`
+	ByFormat       []FormatStorageUsage `json:"by_format"`
+}
+
+// FormatStorageUsage is the storage usage of the images of one original format
+type FormatStorageUsage struct {
+	Format         string `json:"format"`
+	Images         int64  `json:"images"`
+	TotalBytes     int64  `json:"total_bytes"`
+	OriginalBytes  int64  `json:"original_bytes"`
+	OptimizedBytes int64  `json:"optimized_bytes"`
+	ReclaimedBytes int64  `json:"reclaimed_bytes"`
+}
+
+// ImageStats aggregates processing statistics for dashboards
+type ImageStats struct {
+	Total    int64                      `json:"total"`
+	ByStatus map[ProcessingStatus]int64 `json:"by_status"`
+	// AverageReduction is the mean size reduction percentage of completed images
+	AverageReduction float64 `json:"average_reduction"`
+	// ProcessingLatency percentiles measure upload to completion, in milliseconds
+	ProcessingLatencyP50MS float64        `json:"processing_latency_p50_ms"`
+	ProcessingLatencyP95MS float64        `json:"processing_latency_p95_ms"`
+	UploadsPerDay          []DailyUploads `json:"uploads_per_day"`
+}
+
+// DailyUploads is the number of images uploaded on a day
+type DailyUploads struct {
+	Day     string `json:"day"`
+	Uploads int64  `json:"uploads"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TaskStatus is the state of a queue task in the processing ledger
+type TaskStatus string
+
+const (
+	TaskRunning   TaskStatus = "running"
+	TaskCompleted TaskStatus = "completed"
+	// TaskFailed tasks are run again when they are retried
+	TaskFailed TaskStatus = "failed"
+)
+
+// TaskRecord is the processing ledger entry of a queue task. Attempt counts the
+// deliveries of the task that started processing, LastError is the error of the last
+// failed one.
+type TaskRecord struct {
+	TaskID   string    `json:"task_id" db:"task_id"`
+	ImageID  uuid.UUID `json:"image_id" db:"image_id"`
+	TaskType string    `json:"task_type" db:"task_type"`
+	Attempt  int       `json:"attempt" db:"attempt"`
+	// WorkerID identifies the worker that began the last attempt
+	WorkerID   string     `json:"worker_id,omitempty" db:"worker_id"`
+	Status     TaskStatus `json:"status" db:"status"`
+	LastError  string     `json:"last_error,omitempty" db:"last_error"`
+	StartedAt  time.Time  `json:"started_at" db:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty" db:"finished_at"`
+	// Payload is the task as published, kept to retry it
+	Payload json.RawMessage `json:"payload,omitempty" db:"payload"`
+}
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// UsageCounts are the billable usage counters of an API key owner
+type UsageCounts struct {
+	Uploads int64 `json:"uploads"`
+	// ProcessedBytes are the bytes of the originals optimized by the worker
+	ProcessedBytes  int64 `json:"processed_bytes"`
+	Transformations int64 `json:"transformations"`
+	// StorageByteDays sums the bytes stored on each day. It is not counted from requests
+	// but from the daily storage snapshots.
+	StorageByteDays int64 `json:"storage_byte_days"`
+}
+
+// MonthlyUsage is the usage of an API key owner rolled up over a calendar month
+type MonthlyUsage struct {
+	Owner string `json:"owner"`
+	// Month is the first day of the month, in UTC
+	Month time.Time `json:"month"`
+	UsageCounts
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UsageFilter selects monthly usage rows. An empty Owner selects every owner; From and
+// To bound the months, inclusive, if set.
+type UsageFilter struct {
+	Owner string
+	From  time.Time
+	To    time.Time
+}
+
+// UsageResponse is the usage of the caller by month
+type UsageResponse struct {
+	Owner  string          `json:"owner"`
+	Months []*MonthlyUsage `json:"months"`
+}
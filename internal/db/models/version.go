@@ -0,0 +1,37 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ImageVersion is one optimized output of an image. Every processing run adds a version;
+// the image's optimized fields describe the current one.
+type ImageVersion struct {
+	ImageID      uuid.UUID `json:"image_id" db:"image_id"`
+	Version      int       `json:"version" db:"version"`
+	Path         string    `json:"path" db:"path"`
+	Size         int64     `json:"size" db:"size"`
+	Width        int       `json:"width" db:"width"`
+	Height       int       `json:"height" db:"height"`
+	QualityScore float64   `json:"quality_score,omitempty" db:"quality_score"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// ImageVersionResponse represents a version in the version history of an image
+type ImageVersionResponse struct {
+	Version      int       `json:"version"`
+	Current      bool      `json:"current"`
+	URL          string    `json:"url,omitempty"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	QualityScore float64   `json:"quality_score,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ImageVersionListResponse represents the version history of an image, newest first
+type ImageVersionListResponse struct {
+	Versions []*ImageVersionResponse `json:"versions"`
+}
@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/rs/zerolog"
+)
+
+// maxConnectRetryDelay caps the backoff between startup connection attempts
+const maxConnectRetryDelay = 30 * time.Second
+
+// waitForDatabase pings the database until it answers, up to cfg.ConnectAttempts times
+// with exponential backoff, so services started alongside Postgres don't crash-loop
+// while it boots. Rejected credentials are read again before the next attempt.
+func waitForDatabase(ctx context.Context, pool *pgxpool.Pool, cfg *config.DatabaseConfig, log zerolog.Logger) error {
+	attempts := max(cfg.ConnectAttempts, 1)
+	retryDelay := cfg.ConnectRetryDelay
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		log.Info().
+			Str("host", cfg.Host).
+			Int("port", cfg.Port).
+			Int("attempt", attempt).
+			Int("max_attempts", attempts).
+			Msg("Connecting to Postgres")
+
+		if err = pool.Ping(ctx); err == nil {
+			return nil
+		}
+		if attempt == attempts {
+			break
+		}
+
+		log.Warn().
+			Err(err).
+			Int("attempt", attempt).
+			Dur("retry_delay", retryDelay).
+			Msg("Failed to connect to Postgres, retrying...")
+
+		select {
+		case <-time.After(retryDelay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		retryDelay = min(retryDelay*2, maxConnectRetryDelay)
+	}
+
+	return fmt.Errorf("failed to connect to Postgres after %d attempts: %w", attempts, err)
+}
+
+// warmup opens the minimum number of connections before the service starts taking
+// requests, so the first ones don't pay for connection setup
+func warmup(ctx context.Context, pool *pgxpool.Pool, count int, log zerolog.Logger) {
+	if count <= 0 {
+		return
+	}
+
+	start := time.Now()
+	conns := make([]*pgxpool.Conn, count)
+	var wg sync.WaitGroup
+	for i := range conns {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := pool.Acquire(ctx)
+			if err != nil {
+				log.Warn().Err(err).Msg("Failed to open warmup connection")
+				return
+			}
+			conns[i] = conn
+		}()
+	}
+	wg.Wait()
+
+	opened := 0
+	for _, conn := range conns {
+		if conn != nil {
+			conn.Release()
+			opened++
+		}
+	}
+
+	log.Info().Int("connections", opened).Dur("duration", time.Since(start)).Msg("Connection pool warmed up")
+}
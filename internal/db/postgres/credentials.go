@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/rs/zerolog"
+)
+
+// useRotatingCredentials makes every new pool connection use the current credentials of
+// creds, and returns the tracer invalidating them when the server rejects them so the
+// next connection reads the rotated ones
+func useRotatingCredentials(poolConfig *pgxpool.Config, creds *config.Credential, log zerolog.Logger) pgx.QueryTracer {
+	poolConfig.BeforeConnect = func(ctx context.Context, cc *pgx.ConnConfig) error {
+		user, password, err := creds.Get(ctx)
+		if err != nil {
+			log.Warn().Err(err).Msg("Connecting with the previous database credentials")
+		}
+		cc.User, cc.Password = user, password
+		return nil
+	}
+	return &credentialTracer{creds: creds, logger: log}
+}
+
+// credentialTracer watches connection attempts for rejected credentials
+type credentialTracer struct {
+	creds  *config.Credential
+	logger zerolog.Logger
+}
+
+func (t *credentialTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return ctx
+}
+
+func (t *credentialTracer) TraceQueryEnd(context.Context, *pgx.Conn, pgx.TraceQueryEndData) {}
+
+func (t *credentialTracer) TraceConnectStart(ctx context.Context, _ pgx.TraceConnectStartData) context.Context {
+	return ctx
+}
+
+func (t *credentialTracer) TraceConnectEnd(_ context.Context, data pgx.TraceConnectEndData) {
+	if isAuthError(data.Err) {
+		t.logger.Warn().Err(data.Err).Msg("Database rejected the credentials, reading them again for the next connection")
+		t.creds.Invalidate()
+	}
+}
+
+// isAuthError reports whether err is a rejected password or role
+func isAuthError(err error) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	// invalid_password, invalid_authorization_specification
+	return pgErr.Code == "28P01" || pgErr.Code == "28000"
+}
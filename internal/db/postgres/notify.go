@@ -0,0 +1,47 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// imageStatusChannel is the channel the images_notify_status trigger notifies the status
+// events of images on
+const imageStatusChannel = "image_status"
+
+// ListenImageStatus listens for the status events of images on a connection of its own,
+// taken out of the pool, until ctx is done or the connection fails
+func (r *Repository) ListenImageStatus(ctx context.Context, handle func(*models.ImageStatusEvent)) error {
+	reqLogger := logger.FromContext(ctx)
+
+	pooled, err := r.pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring connection to listen on: %w", err)
+	}
+	// The connection is left listening, so it is closed instead of returning to the pool
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+imageStatusChannel); err != nil {
+		return fmt.Errorf("error listening for image status events: %w", err)
+	}
+	reqLogger.Debug().Msg("Listening for image status events")
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("error waiting for image status events: %w", err)
+		}
+
+		var event models.ImageStatusEvent
+		if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+			reqLogger.Warn().Err(err).Str("payload", notification.Payload).Msg("Ignoring malformed image status event")
+			continue
+		}
+		handle(&event)
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/not-nullexception/image-optimizer/config"
 	"github.com/not-nullexception/image-optimizer/internal/db"
@@ -15,6 +17,18 @@ import (
 	"github.com/not-nullexception/image-optimizer/internal/logger"
 )
 
+// imageColumns lists the images table columns in the order expected by scanImage
+const imageColumns = `id, original_name, original_size, original_width, original_height,
+	original_format, original_path, optimized_path, optimized_size,
+	optimized_width, optimized_height, status, error, processing_attempts, processing_progress,
+	processing_stage, auto_retries, lease_owner, lease_expires_at, moderation_status, blurhash, dominant_colors,
+	extracted_text, cutout_path, cutout_size, renditions, quality_score, visibility, owner,
+	original_checksum, original_md5, integrity_status, integrity_checked_at, replication_status, replicated_at,
+	stored_bytes, created_at, updated_at`
+
+// versionColumns lists the image_versions columns in the order expected by scanVersions
+const versionColumns = `image_id, version, path, size, width, height, quality_score, created_at`
+
 type Repository struct {
 	pool *pgxpool.Pool
 }
@@ -31,6 +45,26 @@ func NewRepository(ctx context.Context, cfg *config.DatabaseConfig) (db.Reposito
 	// Set pool configuration
 	poolConfig.MaxConns = int32(cfg.MaxConnections)
 	poolConfig.MinConns = int32(cfg.MinConnections)
+	if cfg.MaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = cfg.MaxConnLifetime
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		poolConfig.MaxConnIdleTime = cfg.MaxConnIdleTime
+	}
+	// Broken and expired idle connections are closed by the health check; replacements
+	// are opened lazily, so the pool recovers from database restarts on its own
+	if cfg.HealthCheckPeriod > 0 {
+		poolConfig.HealthCheckPeriod = cfg.HealthCheckPeriod
+	}
+
+	// Queries are traced as children of the caller's span
+	tracers := []pgx.QueryTracer{&queryTracer{}}
+
+	// Pick up rotated credentials when the database rejects the current ones
+	if cfg.Credentials != nil {
+		tracers = append(tracers, useRotatingCredentials(poolConfig, cfg.Credentials, initLogger))
+	}
+	poolConfig.ConnConfig.Tracer = multitracer.New(tracers...)
 
 	// Create connection pool
 	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
@@ -38,12 +72,14 @@ func NewRepository(ctx context.Context, cfg *config.DatabaseConfig) (db.Reposito
 		return nil, fmt.Errorf("unable to create connection pool: %w", err)
 	}
 
-	// Test connection
-	if err := pool.Ping(ctx); err != nil {
+	// Wait for the database, which may still be starting
+	if err := waitForDatabase(ctx, pool, cfg, initLogger); err != nil {
+		pool.Close()
 		return nil, fmt.Errorf("unable to connect to database: %w", err)
 	}
 
 	initLogger.Info().Msg("Connected to Postgres database")
+	warmup(ctx, pool, cfg.MinConnections, initLogger)
 	return &Repository{pool: pool}, nil
 }
 
@@ -51,27 +87,17 @@ func NewRepository(ctx context.Context, cfg *config.DatabaseConfig) (db.Reposito
 func (r *Repository) GetImageByID(ctx context.Context, id uuid.UUID) (*models.Image, error) {
 	reqLogger := logger.FromContext(ctx)
 
-	query := `
-		SELECT id, original_name, original_size, original_width, original_height,
-			original_format, original_path, optimized_path, optimized_size,
-			optimized_width, optimized_height, status, error, created_at, updated_at
-		FROM images
-		WHERE id = $1
-	`
+	query := `SELECT ` + imageColumns + ` FROM images WHERE id = $1`
 
 	reqLogger.Debug().Str("image_id", id.String()).Msg("Executing GetImageByID query")
 
 	var img models.Image
-	err := r.pool.QueryRow(ctx, query, id).Scan(
-		&img.ID, &img.OriginalName, &img.OriginalSize, &img.OriginalWidth, &img.OriginalHeight,
-		&img.OriginalFormat, &img.OriginalPath, &img.OptimizedPath, &img.OptimizedSize,
-		&img.OptimizedWidth, &img.OptimizedHeight, &img.Status, &img.Error, &img.CreatedAt, &img.UpdatedAt,
-	)
+	err := scanImage(r.pool.QueryRow(ctx, query, id), &img)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			reqLogger.Warn().Err(err).Str("image_id", id.String()).Msg("Image not found")
-			return nil, fmt.Errorf("image not found: %w", err)
+			return nil, fmt.Errorf("%w: %s", db.ErrNotFound, id)
 		}
 
 		reqLogger.Error().Err(err).Str("image_id", id.String()).Msg("Error querying image")
@@ -82,31 +108,88 @@ func (r *Repository) GetImageByID(ctx context.Context, id uuid.UUID) (*models.Im
 	return &img, nil
 }
 
-// ListImages retrieves a list of images with pagination
-func (r *Repository) ListImages(ctx context.Context, limit, offset int) ([]*models.Image, int, error) {
+// CountImagesByStatus counts the images matching filter in each processing status. The
+// status of filter is ignored.
+func (r *Repository) CountImagesByStatus(ctx context.Context, filter models.ImageFilter) (models.ImageStatusCounts, error) {
 	reqLogger := logger.FromContext(ctx)
 
-	query := `
-		SELECT id, original_name, original_size, original_width, original_height, 
-			original_format, original_path, optimized_path, optimized_size, 
-			optimized_width, optimized_height, status, error, created_at, updated_at
+	filter.Status = ""
+	where, args := filterClause(filter)
+	query := `SELECT status, COUNT(*) FROM images ` + where + ` GROUP BY status`
+
+	var counts models.ImageStatusCounts
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error counting images by status")
+		return counts, fmt.Errorf("error counting images by status: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var status models.ProcessingStatus
+		var n int
+		if err := rows.Scan(&status, &n); err != nil {
+			return counts, fmt.Errorf("error scanning status count: %w", err)
+		}
+		counts.Add(status, n)
+	}
+	if err := rows.Err(); err != nil {
+		return counts, fmt.Errorf("error iterating over status counts: %w", err)
+	}
+
+	return counts, nil
+}
+
+// FindImageByChecksum retrieves the newest image of owner whose original has the hex
+// SHA-256 checksum, skipping failed and rejected images
+func (r *Repository) FindImageByChecksum(ctx context.Context, checksum, owner string) (*models.Image, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `SELECT ` + imageColumns + ` FROM images
+		WHERE original_checksum = $1 AND owner = $2 AND status <> $3 AND moderation_status <> $4
+		ORDER BY created_at DESC
+		LIMIT 1`
+
+	var img models.Image
+	err := scanImage(r.pool.QueryRow(ctx, query, checksum, owner, models.StatusFailed, models.ModerationRejected), &img)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: checksum %s", db.ErrNotFound, checksum)
+		}
+
+		reqLogger.Error().Err(err).Str("checksum", checksum).Msg("Error querying image by checksum")
+		return nil, fmt.Errorf("error querying image by checksum: %w", err)
+	}
+
+	return &img, nil
+}
+
+// ListImages retrieves a list of images matching filter with pagination
+func (r *Repository) ListImages(ctx context.Context, filter models.ImageFilter, limit, offset int) ([]*models.Image, int, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	where, args := filterClause(filter)
+
+	query := fmt.Sprintf(`
+		SELECT `+imageColumns+`
 		FROM images
+		%s
 		ORDER BY created_at DESC
-		LIMIT $1 OFFSET $2
-	`
+		LIMIT $%d OFFSET $%d
+	`, where, len(args)+1, len(args)+2)
 
-	countQuery := `SELECT COUNT(*) FROM images`
+	countQuery := `SELECT COUNT(*) FROM images ` + where
 
-	reqLogger.Debug().Int("limit", limit).Int("offset", offset).Msg("Executing ListImages query")
+	reqLogger.Debug().Int("limit", limit).Int("offset", offset).Str("query", filter.Query).Msg("Executing ListImages query")
 
 	var total int
-	err := r.pool.QueryRow(ctx, countQuery).Scan(&total)
+	err := r.pool.QueryRow(ctx, countQuery, args...).Scan(&total)
 	if err != nil {
 		reqLogger.Error().Err(err).Msg("Error counting images")
 		return nil, 0, fmt.Errorf("error counting images: %w", err)
 	}
 
-	rows, err := r.pool.Query(ctx, query, limit, offset)
+	rows, err := r.pool.Query(ctx, query, append(args, limit, offset)...)
 	if err != nil {
 		reqLogger.Error().Err(err).Msg("Error querying images")
 		return nil, 0, fmt.Errorf("error querying images: %w", err)
@@ -116,11 +199,7 @@ func (r *Repository) ListImages(ctx context.Context, limit, offset int) ([]*mode
 	images := make([]*models.Image, 0)
 	for rows.Next() {
 		var img models.Image
-		err := rows.Scan(
-			&img.ID, &img.OriginalName, &img.OriginalSize, &img.OriginalWidth, &img.OriginalHeight,
-			&img.OriginalFormat, &img.OriginalPath, &img.OptimizedPath, &img.OptimizedSize,
-			&img.OptimizedWidth, &img.OptimizedHeight, &img.Status, &img.Error, &img.CreatedAt, &img.UpdatedAt,
-		)
+		err := scanImage(rows, &img)
 		if err != nil {
 			reqLogger.Error().Err(err).Msg("Error scanning image row")
 			return nil, 0, fmt.Errorf("error scanning image row: %w", err)
@@ -137,6 +216,72 @@ func (r *Repository) ListImages(ctx context.Context, limit, offset int) ([]*mode
 	return images, total, nil
 }
 
+// IterateImages calls fn for every image matching filter, newest first. Rows are read in
+// batches using keyset pagination on (created_at, id), so inserts and deletes during the
+// iteration neither skip nor repeat images that already existed.
+func (r *Repository) IterateImages(ctx context.Context, filter models.ImageFilter, batchSize int, fn func(*models.Image) error) error {
+	reqLogger := logger.FromContext(ctx)
+
+	where, args := filterClause(filter)
+	reqLogger.Debug().Int("batch_size", batchSize).Str("query", filter.Query).Msg("Executing IterateImages queries")
+
+	var last *models.Image
+	for {
+		batchWhere, batchArgs := where, args
+		if last != nil {
+			batchArgs = append(append([]any{}, args...), last.CreatedAt, last.ID)
+			cursor := fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(batchArgs)-1, len(batchArgs))
+			if batchWhere == "" {
+				batchWhere = "WHERE " + cursor
+			} else {
+				batchWhere += " AND " + cursor
+			}
+		}
+
+		query := fmt.Sprintf(`
+			SELECT `+imageColumns+`
+			FROM images
+			%s
+			ORDER BY created_at DESC, id DESC
+			LIMIT $%d
+		`, batchWhere, len(batchArgs)+1)
+
+		rows, err := r.pool.Query(ctx, query, append(batchArgs, batchSize)...)
+		if err != nil {
+			reqLogger.Error().Err(err).Msg("Error querying images")
+			return fmt.Errorf("error querying images: %w", err)
+		}
+
+		batch := make([]*models.Image, 0, batchSize)
+		for rows.Next() {
+			var img models.Image
+			if err := scanImage(rows, &img); err != nil {
+				rows.Close()
+				reqLogger.Error().Err(err).Msg("Error scanning image row")
+				return fmt.Errorf("error scanning image row: %w", err)
+			}
+			batch = append(batch, &img)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			reqLogger.Error().Err(err).Msg("Error iterating over image rows")
+			return fmt.Errorf("error iterating over rows: %w", err)
+		}
+
+		// Release the connection before handing rows to fn, which may block on a slow client
+		for _, img := range batch {
+			if err := fn(img); err != nil {
+				return err
+			}
+		}
+
+		if len(batch) < batchSize {
+			return nil
+		}
+		last = batch[len(batch)-1]
+	}
+}
+
 // CreateImage creates a new image record
 func (r *Repository) CreateImage(ctx context.Context, image *models.Image) error {
 	reqLogger := logger.FromContext(ctx)
@@ -144,9 +289,10 @@ func (r *Repository) CreateImage(ctx context.Context, image *models.Image) error
 	query := `
 		INSERT INTO images (
 			id, original_name, original_size, original_width, original_height,
-			original_format, original_path, status, created_at, updated_at
+			original_format, original_path, original_checksum, original_md5, status, visibility, owner,
+			created_at, updated_at
 		) VALUES (
-			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14
 		)
 	`
 
@@ -154,7 +300,8 @@ func (r *Repository) CreateImage(ctx context.Context, image *models.Image) error
 
 	_, err := r.pool.Exec(ctx, query,
 		image.ID, image.OriginalName, image.OriginalSize, image.OriginalWidth, image.OriginalHeight,
-		image.OriginalFormat, image.OriginalPath, image.Status, image.CreatedAt, image.UpdatedAt,
+		image.OriginalFormat, image.OriginalPath, image.OriginalChecksum, image.OriginalMD5, image.Status,
+		image.Visibility, image.Owner, image.CreatedAt, image.UpdatedAt,
 	)
 
 	if err != nil {
@@ -213,7 +360,7 @@ func (r *Repository) DeleteImage(ctx context.Context, id uuid.UUID) error {
 
 	if commandTag.RowsAffected() == 0 {
 		reqLogger.Warn().Str("image_id", id.String()).Msg("Image not found for deletion")
-		return fmt.Errorf("image not found")
+		return fmt.Errorf("%w: %s", db.ErrNotFound, id)
 	}
 
 	reqLogger.Debug().Str("image_id", id.String()).Msg("Image deleted successfully")
@@ -244,48 +391,1470 @@ func (r *Repository) UpdateImageStatus(ctx context.Context, id uuid.UUID, status
 	return nil
 }
 
-// UpdateImageOptimized updates the optimized image information
-func (r *Repository) UpdateImageOptimized(ctx context.Context, id uuid.UUID, path string, size int64, width, height int) error {
+// UpdateLeasedImageStatus updates the status of an image leased to workerID. It fails
+// with ErrLeaseLost if another worker took the image over.
+func (r *Repository) UpdateLeasedImageStatus(ctx context.Context, id uuid.UUID, workerID string, status models.ProcessingStatus, errorMsg string) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE images
+		SET status = $3, error = $4, updated_at = $5
+		WHERE id = $1 AND lease_owner = $2
+	`
+
+	reqLogger.Debug().Str("image_id", id.String()).Msg("Executing UpdateLeasedImageStatus query")
+
+	commandTag, err := r.pool.Exec(ctx, query, id, workerID, status, errorMsg, time.Now())
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error updating image status")
+		return fmt.Errorf("error updating image status: %w", err)
+	}
+	if commandTag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", db.ErrLeaseLost, id)
+	}
+
+	reqLogger.Debug().Str("image_id", id.String()).Msg("Image status updated successfully")
+	return nil
+}
+
+// RetryImage moves a failed image back to pending to retry it, counting automatic
+// retries. It fails with ErrStatusConflict if the image is not failed.
+func (r *Repository) RetryImage(ctx context.Context, id uuid.UUID, automatic bool) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE images
+		SET status = 'pending', error = '', updated_at = $2,
+			auto_retries = auto_retries + CASE WHEN $3 THEN 1 ELSE 0 END
+		WHERE id = $1 AND status = 'failed'
+	`
+
+	reqLogger.Debug().Str("image_id", id.String()).Bool("automatic", automatic).Msg("Executing RetryImage query")
+
+	tag, err := r.pool.Exec(ctx, query, id, time.Now(), automatic)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error retrying image")
+		return fmt.Errorf("error retrying image: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: image %s is not failed", db.ErrStatusConflict, id)
+	}
+
+	return nil
+}
+
+// ListRetryableImages retrieves up to limit failed images to retry automatically: those
+// whose last resize task failed, as opposed to images failed for good whose task completed,
+// that were retried fewer than maxRetries times, have no task retry waiting in the outbox
+// and failed longer ago than their backoff, baseDelay doubled per retry up to maxDelay
+func (r *Repository) ListRetryableImages(ctx context.Context, maxRetries int, baseDelay, maxDelay time.Duration, limit int) ([]*models.Image, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		SELECT ` + prefixColumns("i.", imageColumns) + `
+		FROM images i
+		WHERE i.status = 'failed' AND i.auto_retries < $1
+			AND i.updated_at <= $2 - LEAST($3 * power(2, i.auto_retries), $4) * interval '1 second'
+			AND NOT EXISTS (SELECT 1 FROM task_outbox o WHERE o.image_id = i.id)
+			AND (
+				SELECT l.status FROM task_ledger l
+				WHERE l.image_id = i.id AND l.task_type = 'resize_image' AND l.payload IS NOT NULL
+				ORDER BY l.started_at DESC
+				LIMIT 1
+			) = 'failed'
+		ORDER BY i.updated_at
+		LIMIT $5
+	`
+
+	rows, err := r.pool.Query(ctx, query, maxRetries, time.Now(), baseDelay.Seconds(), maxDelay.Seconds(), limit)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error querying retryable images")
+		return nil, fmt.Errorf("error querying retryable images: %w", err)
+	}
+	defer rows.Close()
+
+	images := make([]*models.Image, 0)
+	for rows.Next() {
+		var img models.Image
+		if err := scanImage(rows, &img); err != nil {
+			return nil, fmt.Errorf("error scanning image row: %w", err)
+		}
+		images = append(images, &img)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return images, nil
+}
+
+// StartImageProcessing moves a pending image to processing and records the task doing it,
+// its attempts and the lease of workerID. A redelivered task may take over an image it left
+// processing or failed, from another worker only once that worker's lease expired; the
+// worker taken over from is returned.
+func (r *Repository) StartImageProcessing(ctx context.Context, id uuid.UUID, taskID, workerID string, lease time.Duration) (string, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	// prev locks the row to read the lease being replaced
+	query := `
+		WITH prev AS (
+			SELECT id, lease_owner FROM images WHERE id = $1 FOR UPDATE
+		)
+		UPDATE images i
+		SET status = 'processing', error = '', processing_task_id = $2, updated_at = $3,
+			processing_progress = 0, processing_stage = '',
+			processing_attempts = CASE WHEN i.processing_task_id = $2 THEN i.processing_attempts + 1 ELSE 1 END,
+			lease_owner = $4, lease_expires_at = $5
+		FROM prev
+		WHERE i.id = prev.id AND (i.status IN ('pending', 'queued_failed')
+			OR (i.status IN ('processing', 'failed') AND i.processing_task_id = $2))
+			AND (i.status <> 'processing' OR i.lease_owner IN ('', $4) OR i.lease_expires_at < $3)
+		RETURNING prev.lease_owner
+	`
+
+	reqLogger.Debug().Str("image_id", id.String()).Str("task_id", taskID).Msg("Executing StartImageProcessing query")
+
+	now := time.Now()
+	var previousOwner string
+	err := r.pool.QueryRow(ctx, query, id, taskID, now, workerID, now.Add(lease)).Scan(&previousOwner)
+	if err == nil {
+		if previousOwner == workerID {
+			previousOwner = ""
+		}
+		return previousOwner, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		reqLogger.Error().Err(err).Msg("Error starting image processing")
+		return "", fmt.Errorf("error starting image processing: %w", err)
+	}
+
+	// Nothing was updated: either another worker holds the image or its status moved on
+	var owner string
+	var expiresAt time.Time
+	err = r.pool.QueryRow(ctx, `
+		SELECT lease_owner, lease_expires_at FROM images
+		WHERE id = $1 AND status = 'processing' AND processing_task_id = $2 AND lease_expires_at >= $3
+	`, id, taskID, now).Scan(&owner, &expiresAt)
+	if err == nil {
+		return "", fmt.Errorf("%w: %s holds %s until %s", db.ErrLeaseHeld, owner, id, expiresAt.Format(time.RFC3339))
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		reqLogger.Error().Err(err).Msg("Error checking image lease")
+		return "", fmt.Errorf("error checking image lease: %w", err)
+	}
+
+	return "", fmt.Errorf("%w: %s", db.ErrStatusConflict, id)
+}
+
+// RenewImageLease extends the lease of workerID on an image. It fails with ErrLeaseLost
+// if the worker no longer holds it.
+func (r *Repository) RenewImageLease(ctx context.Context, id uuid.UUID, workerID string, lease time.Duration) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE images
+		SET lease_expires_at = $3
+		WHERE id = $1 AND lease_owner = $2
+	`
+
+	commandTag, err := r.pool.Exec(ctx, query, id, workerID, time.Now().Add(lease))
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error renewing image lease")
+		return fmt.Errorf("error renewing image lease: %w", err)
+	}
+	if commandTag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", db.ErrLeaseLost, id)
+	}
+
+	return nil
+}
+
+// ReleaseImageLease drops the lease of workerID on an image, if it still holds it
+func (r *Repository) ReleaseImageLease(ctx context.Context, id uuid.UUID, workerID string) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE images
+		SET lease_owner = '', lease_expires_at = NULL
+		WHERE id = $1 AND lease_owner = $2
+	`
+
+	if _, err := r.pool.Exec(ctx, query, id, workerID); err != nil {
+		reqLogger.Error().Err(err).Msg("Error releasing image lease")
+		return fmt.Errorf("error releasing image lease: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateImageProgress updates the progress of a processing image. Images that finished
+// processing in the meantime are left alone.
+func (r *Repository) UpdateImageProgress(ctx context.Context, id uuid.UUID, percent int, stage string) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE images
+		SET processing_progress = $2, processing_stage = $3
+		WHERE id = $1 AND status = 'processing'
+	`
+
+	reqLogger.Debug().Str("image_id", id.String()).Int("percent", percent).Str("stage", stage).Msg("Executing UpdateImageProgress query")
+
+	_, err := r.pool.Exec(ctx, query, id, percent, stage)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error updating image progress")
+		return fmt.Errorf("error updating image progress: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateImageOptimized updates the optimized image information of an image leased to
+// workerID. It fails with ErrLeaseLost if another worker took the image over.
+func (r *Repository) UpdateImageOptimized(ctx context.Context, id uuid.UUID, workerID string, path string, size int64, width, height int) error {
 	reqLogger := logger.FromContext(ctx)
 
 	query := `
 		UPDATE images
 		SET optimized_path = $2, optimized_size = $3, optimized_width = $4, optimized_height = $5,
-			status = $6, updated_at = $7
-		WHERE id = $1
+			status = $6, updated_at = $7, processed_at = $7, replication_status = 'pending',
+			processing_progress = 100, processing_stage = ''
+		WHERE id = $1 AND lease_owner = $8
 	`
 
 	reqLogger.Debug().Str("image_id", id.String()).Msg("Executing UpdateImageOptimized query")
 
 	updatedAt := time.Now()
 
-	_, err := r.pool.Exec(ctx, query,
+	commandTag, err := r.pool.Exec(ctx, query,
 		id, path, size, width, height,
-		models.StatusCompleted, updatedAt,
+		models.StatusCompleted, updatedAt, workerID,
 	)
 	if err != nil {
 		reqLogger.Error().Err(err).Msg("Error updating optimized image")
 		return fmt.Errorf("error updating optimized image: %w", err)
 	}
+	if commandTag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", db.ErrLeaseLost, id)
+	}
 
 	reqLogger.Debug().Str("image_id", id.String()).Msg("Optimized image updated successfully")
 	return nil
 }
 
-func (r *Repository) Ping(ctx context.Context) error {
+// UpdateModerationStatus updates the moderation status of an image
+func (r *Repository) UpdateModerationStatus(ctx context.Context, id uuid.UUID, status models.ModerationStatus) error {
 	reqLogger := logger.FromContext(ctx)
-	reqLogger.Debug().Msg("Pinging database")
 
-	err := r.pool.Ping(ctx)
+	query := `
+		UPDATE images
+		SET moderation_status = $2, updated_at = $3
+		WHERE id = $1
+	`
+
+	reqLogger.Debug().Str("image_id", id.String()).Msg("Executing UpdateModerationStatus query")
+
+	_, err := r.pool.Exec(ctx, query, id, status, time.Now())
 	if err != nil {
-		reqLogger.Error().Err(err).Msg("Error pinging database")
-		return fmt.Errorf("error pinging database: %w", err)
+		reqLogger.Error().Err(err).Msg("Error updating moderation status")
+		return fmt.Errorf("error updating moderation status: %w", err)
 	}
 
+	reqLogger.Debug().Str("image_id", id.String()).Str("moderation_status", string(status)).Msg("Moderation status updated successfully")
 	return nil
 }
 
-func (r *Repository) Close() error {
-	r.pool.Close()
+// UpdateImagePlaceholder updates the BlurHash and dominant colors of an image
+func (r *Repository) UpdateImagePlaceholder(ctx context.Context, id uuid.UUID, blurHash string, dominantColors []string) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE images
+		SET blurhash = $2, dominant_colors = $3, updated_at = $4
+		WHERE id = $1
+	`
+
+	reqLogger.Debug().Str("image_id", id.String()).Msg("Executing UpdateImagePlaceholder query")
+
+	_, err := r.pool.Exec(ctx, query, id, blurHash, dominantColors, time.Now())
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error updating image placeholder")
+		return fmt.Errorf("error updating image placeholder: %w", err)
+	}
+
+	reqLogger.Debug().Str("image_id", id.String()).Msg("Image placeholder updated successfully")
+	return nil
+}
+
+// UpdateImageText stores the text extracted from an image
+func (r *Repository) UpdateImageText(ctx context.Context, id uuid.UUID, text string) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE images
+		SET extracted_text = $2, updated_at = $3
+		WHERE id = $1
+	`
+
+	reqLogger.Debug().Str("image_id", id.String()).Msg("Executing UpdateImageText query")
+
+	_, err := r.pool.Exec(ctx, query, id, text, time.Now())
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error updating extracted text")
+		return fmt.Errorf("error updating extracted text: %w", err)
+	}
+
+	reqLogger.Debug().Str("image_id", id.String()).Msg("Extracted text updated successfully")
+	return nil
+}
+
+// UpdateImageCutout stores the path and size of the background-removed cut-out of an image
+func (r *Repository) UpdateImageCutout(ctx context.Context, id uuid.UUID, path string, size int64) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE images
+		SET cutout_path = $2, cutout_size = $3, updated_at = $4, replication_status = 'pending'
+		WHERE id = $1
+	`
+
+	reqLogger.Debug().Str("image_id", id.String()).Msg("Executing UpdateImageCutout query")
+
+	_, err := r.pool.Exec(ctx, query, id, path, size, time.Now())
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error updating image cutout")
+		return fmt.Errorf("error updating image cutout: %w", err)
+	}
+
+	reqLogger.Debug().Str("image_id", id.String()).Msg("Image cutout updated successfully")
+	return nil
+}
+
+// UpdateImageRenditions stores the additional renditions of an image
+func (r *Repository) UpdateImageRenditions(ctx context.Context, id uuid.UUID, renditions []models.Rendition) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE images
+		SET renditions = $2, updated_at = $3, replication_status = 'pending'
+		WHERE id = $1
+	`
+
+	reqLogger.Debug().Str("image_id", id.String()).Int("renditions", len(renditions)).Msg("Executing UpdateImageRenditions query")
+
+	_, err := r.pool.Exec(ctx, query, id, renditions, time.Now())
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error updating image renditions")
+		return fmt.Errorf("error updating image renditions: %w", err)
+	}
+
+	reqLogger.Debug().Str("image_id", id.String()).Msg("Image renditions updated successfully")
+	return nil
+}
+
+// UpdateImageQualityScore stores the perceptual quality score of the optimized image
+func (r *Repository) UpdateImageQualityScore(ctx context.Context, id uuid.UUID, score float64) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE images
+		SET quality_score = $2, updated_at = $3
+		WHERE id = $1
+	`
+
+	reqLogger.Debug().Str("image_id", id.String()).Float64("quality_score", score).Msg("Executing UpdateImageQualityScore query")
+
+	_, err := r.pool.Exec(ctx, query, id, score, time.Now())
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error updating image quality score")
+		return fmt.Errorf("error updating image quality score: %w", err)
+	}
+
+	reqLogger.Debug().Str("image_id", id.String()).Msg("Image quality score updated successfully")
 	return nil
 }
+
+// UpdateImageIntegrity records the result of verifying the stored objects of an image
+func (r *Repository) UpdateImageIntegrity(ctx context.Context, id uuid.UUID, status models.IntegrityStatus, checkedAt time.Time) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE images
+		SET integrity_status = $2, integrity_checked_at = $3
+		WHERE id = $1
+	`
+
+	reqLogger.Debug().Str("image_id", id.String()).Str("integrity_status", string(status)).Msg("Executing UpdateImageIntegrity query")
+
+	_, err := r.pool.Exec(ctx, query, id, status, checkedAt)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error updating image integrity")
+		return fmt.Errorf("error updating image integrity: %w", err)
+	}
+
+	reqLogger.Debug().Str("image_id", id.String()).Msg("Image integrity updated successfully")
+	return nil
+}
+
+// UpdateImageReplication records the result of copying the optimized objects of an image
+// to the replication target. It leaves updated_at alone, like UpdateImageIntegrity. The
+// result is dropped if the image changed since updatedAt, when the objects were read, so
+// the pending status of newer objects is kept.
+func (r *Repository) UpdateImageReplication(ctx context.Context, id uuid.UUID, status models.ReplicationStatus, replicatedAt, updatedAt time.Time) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE images
+		SET replication_status = $2, replicated_at = CASE WHEN $2 = 'replicated' THEN $3 ELSE replicated_at END
+		WHERE id = $1 AND updated_at = $4
+	`
+
+	reqLogger.Debug().Str("image_id", id.String()).Str("replication_status", string(status)).Msg("Executing UpdateImageReplication query")
+
+	_, err := r.pool.Exec(ctx, query, id, status, replicatedAt, updatedAt)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error updating image replication")
+		return fmt.Errorf("error updating image replication: %w", err)
+	}
+
+	reqLogger.Debug().Str("image_id", id.String()).Msg("Image replication updated successfully")
+	return nil
+}
+
+// NextImageVersion returns the number of the next version of an image
+func (r *Repository) NextImageVersion(ctx context.Context, id uuid.UUID) (int, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `SELECT COALESCE(MAX(version), 0) + 1 FROM image_versions WHERE image_id = $1`
+
+	var version int
+	if err := r.pool.QueryRow(ctx, query, id).Scan(&version); err != nil {
+		reqLogger.Error().Err(err).Msg("Error getting next image version")
+		return 0, fmt.Errorf("error getting next image version: %w", err)
+	}
+
+	return version, nil
+}
+
+// CreateImageVersion records an optimized output of an image
+func (r *Repository) CreateImageVersion(ctx context.Context, version *models.ImageVersion) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		INSERT INTO image_versions (image_id, version, path, size, width, height, quality_score, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	reqLogger.Debug().Str("image_id", version.ImageID.String()).Int("version", version.Version).Msg("Executing CreateImageVersion query")
+
+	if version.CreatedAt.IsZero() {
+		version.CreatedAt = time.Now()
+	}
+
+	_, err := r.pool.Exec(ctx, query,
+		version.ImageID, version.Version, version.Path, version.Size,
+		version.Width, version.Height, version.QualityScore, version.CreatedAt,
+	)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error creating image version")
+		return fmt.Errorf("error creating image version: %w", err)
+	}
+
+	return nil
+}
+
+// ListImageVersions returns the versions of an image, newest first
+func (r *Repository) ListImageVersions(ctx context.Context, id uuid.UUID) ([]*models.ImageVersion, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		SELECT ` + versionColumns + `
+		FROM image_versions
+		WHERE image_id = $1
+		ORDER BY version DESC
+	`
+
+	rows, err := r.pool.Query(ctx, query, id)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error listing image versions")
+		return nil, fmt.Errorf("error listing image versions: %w", err)
+	}
+
+	versions, err := scanVersions(rows)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error scanning image versions")
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+// ListImageEvents returns the status transitions of an image, oldest first
+func (r *Repository) ListImageEvents(ctx context.Context, id uuid.UUID) ([]*models.ImageEvent, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		SELECT id, image_id, from_status, status, error, worker_id, attempt, duration_ms, created_at
+		FROM image_events
+		WHERE image_id = $1
+		ORDER BY id
+	`
+
+	rows, err := r.pool.Query(ctx, query, id)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error listing image events")
+		return nil, fmt.Errorf("error listing image events: %w", err)
+	}
+	defer rows.Close()
+
+	events := []*models.ImageEvent{}
+	for rows.Next() {
+		var e models.ImageEvent
+		err := rows.Scan(&e.ID, &e.ImageID, &e.FromStatus, &e.Status, &e.Error, &e.WorkerID, &e.Attempt, &e.DurationMs, &e.CreatedAt)
+		if err != nil {
+			reqLogger.Error().Err(err).Msg("Error scanning image event")
+			return nil, fmt.Errorf("error scanning image event: %w", err)
+		}
+		events = append(events, &e)
+	}
+	if err := rows.Err(); err != nil {
+		reqLogger.Error().Err(err).Msg("Error iterating image events")
+		return nil, fmt.Errorf("error iterating image events: %w", err)
+	}
+
+	return events, nil
+}
+
+// PromoteImageVersion copies a version into the optimized fields of its image
+func (r *Repository) PromoteImageVersion(ctx context.Context, id uuid.UUID, version int) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE images i
+		SET optimized_path = v.path, optimized_size = v.size, optimized_width = v.width,
+			optimized_height = v.height, quality_score = v.quality_score, updated_at = $3,
+			replication_status = 'pending'
+		FROM image_versions v
+		WHERE i.id = $1 AND v.image_id = i.id AND v.version = $2
+	`
+
+	reqLogger.Debug().Str("image_id", id.String()).Int("version", version).Msg("Executing PromoteImageVersion query")
+
+	commandTag, err := r.pool.Exec(ctx, query, id, version, time.Now())
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error promoting image version")
+		return fmt.Errorf("error promoting image version: %w", err)
+	}
+	if commandTag.RowsAffected() == 0 {
+		return db.ErrVersionNotFound
+	}
+
+	return nil
+}
+
+// PruneImageVersions deletes the versions outside the retention policy. The current version
+// is always kept.
+func (r *Repository) PruneImageVersions(ctx context.Context, id uuid.UUID, keep int, maxAge time.Duration) ([]*models.ImageVersion, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	if keep <= 0 && maxAge <= 0 {
+		return nil, nil
+	}
+
+	// Versions created before the zero time are never pruned by age
+	var cutoff time.Time
+	if maxAge > 0 {
+		cutoff = time.Now().Add(-maxAge)
+	}
+
+	query := `
+		DELETE FROM image_versions v
+		USING images i
+		WHERE v.image_id = $1 AND i.id = v.image_id AND v.path <> COALESCE(i.optimized_path, '')
+			AND (
+				($2 > 0 AND v.version NOT IN (
+					SELECT version FROM image_versions WHERE image_id = $1 ORDER BY version DESC LIMIT $2
+				))
+				OR v.created_at < $3
+			)
+		RETURNING ` + prefixColumns("v.", versionColumns)
+
+	reqLogger.Debug().Str("image_id", id.String()).Int("keep", keep).Dur("max_age", maxAge).Msg("Executing PruneImageVersions query")
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, query, id, keep, cutoff)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error pruning image versions")
+		return nil, fmt.Errorf("error pruning image versions: %w", err)
+	}
+	removed, err := scanVersions(rows)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error scanning pruned image versions")
+		return nil, err
+	}
+
+	// Recompute stored_bytes through the trigger
+	if len(removed) > 0 {
+		if _, err := tx.Exec(ctx, `UPDATE images SET stored_bytes = 0 WHERE id = $1`, id); err != nil {
+			return nil, fmt.Errorf("error updating stored bytes: %w", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error committing pruned image versions: %w", err)
+	}
+
+	return removed, nil
+}
+
+// MoveImageOriginal renames the original of an image from one object to another, along
+// with the optimized path and versions of runs that kept the original
+func (r *Repository) MoveImageOriginal(ctx context.Context, id uuid.UUID, from, to string) error {
+	reqLogger := logger.FromContext(ctx)
+
+	reqLogger.Debug().Str("image_id", id.String()).Str("from", from).Str("to", to).Msg("Executing MoveImageOriginal query")
+
+	tx, err := r.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := `
+		UPDATE images
+		SET original_path = $3,
+			optimized_path = CASE WHEN optimized_path = $2 THEN $3 ELSE optimized_path END
+		WHERE id = $1 AND original_path = $2
+	`
+	commandTag, err := tx.Exec(ctx, query, id, from, to)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error moving image original")
+		return fmt.Errorf("error moving image original: %w", err)
+	}
+	if commandTag.RowsAffected() == 0 {
+		reqLogger.Warn().Str("image_id", id.String()).Str("from", from).Msg("Image original not found for move")
+		return fmt.Errorf("%w: %s", db.ErrNotFound, id)
+	}
+
+	if _, err := tx.Exec(ctx, `UPDATE image_versions SET path = $3 WHERE image_id = $1 AND path = $2`, id, from, to); err != nil {
+		reqLogger.Error().Err(err).Msg("Error moving image versions")
+		return fmt.Errorf("error moving image versions: %w", err)
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing moved image original: %w", err)
+	}
+
+	reqLogger.Debug().Str("image_id", id.String()).Msg("Image original moved successfully")
+	return nil
+}
+
+// ObjectReferenced reports whether an object is used by an image other than exclude. Objects
+// are only shared between images with content-addressed naming.
+func (r *Repository) ObjectReferenced(ctx context.Context, objectName string, exclude uuid.UUID) (bool, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM images
+			WHERE id <> $2 AND (
+				original_path = $1 OR optimized_path = $1 OR cutout_path = $1
+				OR renditions @> jsonb_build_array(jsonb_build_object('path', $1::text))
+			)
+		) OR EXISTS (
+			SELECT 1 FROM image_versions WHERE image_id <> $2 AND path = $1
+		)
+	`
+
+	var referenced bool
+	if err := r.pool.QueryRow(ctx, query, objectName, exclude).Scan(&referenced); err != nil {
+		reqLogger.Error().Err(err).Str("object", objectName).Msg("Error checking object references")
+		return false, fmt.Errorf("error checking object references: %w", err)
+	}
+
+	return referenced, nil
+}
+
+// UnreferencedObjects returns the objects of names that no image or version uses
+func (r *Repository) UnreferencedObjects(ctx context.Context, names []string) ([]string, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		SELECT name FROM unnest($1::text[]) AS name
+		WHERE NOT EXISTS (
+			SELECT 1 FROM images
+			WHERE original_path = name OR optimized_path = name OR cutout_path = name
+				OR renditions @> jsonb_build_array(jsonb_build_object('path', name))
+		) AND NOT EXISTS (
+			SELECT 1 FROM image_versions WHERE path = name
+		)
+	`
+
+	reqLogger.Debug().Int("objects", len(names)).Msg("Executing UnreferencedObjects query")
+
+	rows, err := r.pool.Query(ctx, query, names)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error checking object references")
+		return nil, fmt.Errorf("error checking object references: %w", err)
+	}
+	defer rows.Close()
+
+	var unreferenced []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			reqLogger.Error().Err(err).Msg("Error scanning unreferenced object")
+			return nil, fmt.Errorf("error scanning unreferenced object: %w", err)
+		}
+		unreferenced = append(unreferenced, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		reqLogger.Error().Err(err).Msg("Error iterating unreferenced objects")
+		return nil, fmt.Errorf("error iterating unreferenced objects: %w", err)
+	}
+
+	return unreferenced, nil
+}
+
+// SaveImageDeletion stores a pending deletion, replacing an earlier one of the same image
+func (r *Repository) SaveImageDeletion(ctx context.Context, deletion *models.ImageDeletion) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		INSERT INTO image_deletions (image_id, token_hash, requested_at, expires_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (image_id) DO UPDATE
+		SET token_hash = EXCLUDED.token_hash, requested_at = EXCLUDED.requested_at, expires_at = EXCLUDED.expires_at
+	`
+
+	reqLogger.Debug().Str("image_id", deletion.ImageID.String()).Msg("Executing SaveImageDeletion query")
+
+	_, err := r.pool.Exec(ctx, query, deletion.ImageID, deletion.TokenHash, deletion.RequestedAt, deletion.ExpiresAt)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error saving image deletion")
+		return fmt.Errorf("error saving image deletion: %w", err)
+	}
+
+	return nil
+}
+
+// GetImageDeletion returns the pending deletion of an image
+func (r *Repository) GetImageDeletion(ctx context.Context, id uuid.UUID) (*models.ImageDeletion, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		SELECT image_id, token_hash, requested_at, expires_at
+		FROM image_deletions
+		WHERE image_id = $1
+	`
+
+	var deletion models.ImageDeletion
+	err := r.pool.QueryRow(ctx, query, id).Scan(&deletion.ImageID, &deletion.TokenHash, &deletion.RequestedAt, &deletion.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: %s", db.ErrDeletionNotFound, id)
+		}
+		reqLogger.Error().Err(err).Str("image_id", id.String()).Msg("Error getting image deletion")
+		return nil, fmt.Errorf("error getting image deletion: %w", err)
+	}
+
+	return &deletion, nil
+}
+
+// ListExpiredImageDeletions returns up to limit pending deletions that expired before now
+func (r *Repository) ListExpiredImageDeletions(ctx context.Context, now time.Time, limit int) ([]*models.ImageDeletion, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		SELECT image_id, token_hash, requested_at, expires_at
+		FROM image_deletions
+		WHERE expires_at <= $1
+		ORDER BY expires_at
+		LIMIT $2
+	`
+
+	rows, err := r.pool.Query(ctx, query, now, limit)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error listing expired image deletions")
+		return nil, fmt.Errorf("error listing expired image deletions: %w", err)
+	}
+	defer rows.Close()
+
+	var deletions []*models.ImageDeletion
+	for rows.Next() {
+		var deletion models.ImageDeletion
+		if err := rows.Scan(&deletion.ImageID, &deletion.TokenHash, &deletion.RequestedAt, &deletion.ExpiresAt); err != nil {
+			reqLogger.Error().Err(err).Msg("Error scanning image deletion")
+			return nil, fmt.Errorf("error scanning image deletion: %w", err)
+		}
+		deletions = append(deletions, &deletion)
+	}
+
+	if err := rows.Err(); err != nil {
+		reqLogger.Error().Err(err).Msg("Error iterating image deletions")
+		return nil, fmt.Errorf("error iterating image deletions: %w", err)
+	}
+
+	return deletions, nil
+}
+
+// DeleteImageDeletion drops the pending deletion of an image
+func (r *Repository) DeleteImageDeletion(ctx context.Context, id uuid.UUID) error {
+	reqLogger := logger.FromContext(ctx)
+
+	reqLogger.Debug().Str("image_id", id.String()).Msg("Executing DeleteImageDeletion query")
+
+	_, err := r.pool.Exec(ctx, `DELETE FROM image_deletions WHERE image_id = $1`, id)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error deleting image deletion")
+		return fmt.Errorf("error deleting image deletion: %w", err)
+	}
+
+	return nil
+}
+
+// UseUploadToken records the use of an upload token, failing with db.ErrUploadTokenUsed
+// if it was used before. Expired tokens can't be replayed, so they are deleted on the way.
+func (r *Repository) UseUploadToken(ctx context.Context, id uuid.UUID, expiresAt time.Time) error {
+	reqLogger := logger.FromContext(ctx)
+
+	reqLogger.Debug().Str("token_id", id.String()).Msg("Executing UseUploadToken query")
+
+	if _, err := r.pool.Exec(ctx, `DELETE FROM upload_tokens WHERE expires_at < NOW()`); err != nil {
+		reqLogger.Error().Err(err).Msg("Error deleting expired upload tokens")
+		return fmt.Errorf("error deleting expired upload tokens: %w", err)
+	}
+
+	tag, err := r.pool.Exec(ctx, `
+		INSERT INTO upload_tokens (id, expires_at) VALUES ($1, $2)
+		ON CONFLICT (id) DO NOTHING
+	`, id, expiresAt)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error recording upload token")
+		return fmt.Errorf("error recording upload token: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%w: %s", db.ErrUploadTokenUsed, id)
+	}
+
+	return nil
+}
+
+// GetStorageUsage sums the bytes stored for all images, in total and per original format
+func (r *Repository) GetStorageUsage(ctx context.Context) (*models.StorageUsage, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		SELECT original_format, COUNT(*), COALESCE(SUM(stored_bytes), 0), COALESCE(SUM(original_size), 0),
+			COALESCE(SUM(optimized_size), 0), COALESCE(SUM(cutout_size), 0),
+			COALESCE(SUM(stored_bytes - original_size - COALESCE(optimized_size, 0) - cutout_size), 0),
+			COALESCE(SUM(original_size - optimized_size) FILTER (WHERE optimized_size > 0), 0)
+		FROM images
+		GROUP BY original_format
+		ORDER BY original_format
+	`
+
+	reqLogger.Debug().Msg("Executing GetStorageUsage query")
+
+	rows, err := r.pool.Query(ctx, query)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error querying storage usage")
+		return nil, fmt.Errorf("error querying storage usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := &models.StorageUsage{ByFormat: []models.FormatStorageUsage{}}
+	for rows.Next() {
+		var format models.FormatStorageUsage
+		var cutoutBytes, renditionBytes int64
+		if err := rows.Scan(&format.Format, &format.Images, &format.TotalBytes, &format.OriginalBytes,
+			&format.OptimizedBytes, &cutoutBytes, &renditionBytes, &format.ReclaimedBytes); err != nil {
+			reqLogger.Error().Err(err).Msg("Error scanning storage usage row")
+			return nil, fmt.Errorf("error scanning storage usage row: %w", err)
+		}
+
+		usage.Images += format.Images
+		usage.TotalBytes += format.TotalBytes
+		usage.OriginalBytes += format.OriginalBytes
+		usage.OptimizedBytes += format.OptimizedBytes
+		usage.RenditionBytes += renditionBytes
+		usage.CutoutBytes += cutoutBytes
+		usage.ReclaimedBytes += format.ReclaimedBytes
+		usage.ByFormat = append(usage.ByFormat, format)
+	}
+
+	if err := rows.Err(); err != nil {
+		reqLogger.Error().Err(err).Msg("Error iterating over storage usage rows")
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return usage, nil
+}
+
+// GetImageStats aggregates counts by status, size reduction, processing latency and the
+// uploads per day since the given day. With fromView the daily uploads are read from the
+// image_daily_stats materialized view instead of the images table.
+func (r *Repository) GetImageStats(ctx context.Context, since time.Time, fromView bool) (*models.ImageStats, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	reqLogger.Debug().Time("since", since).Bool("from_view", fromView).Msg("Executing GetImageStats queries")
+
+	stats := &models.ImageStats{
+		ByStatus:      make(map[models.ProcessingStatus]int64),
+		UploadsPerDay: []models.DailyUploads{},
+	}
+
+	rows, err := r.pool.Query(ctx, `SELECT status, COUNT(*) FROM images GROUP BY status`)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error querying status counts")
+		return nil, fmt.Errorf("error querying status counts: %w", err)
+	}
+	for rows.Next() {
+		var status models.ProcessingStatus
+		var count int64
+		if err := rows.Scan(&status, &count); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning status count: %w", err)
+		}
+		stats.ByStatus[status] = count
+		stats.Total += count
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over status counts: %w", err)
+	}
+
+	query := `
+		SELECT
+			COALESCE(AVG(100.0 * (original_size - optimized_size) / original_size), 0),
+			COALESCE(percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM processed_at - created_at))
+				FILTER (WHERE processed_at IS NOT NULL), 0) * 1000,
+			COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM processed_at - created_at))
+				FILTER (WHERE processed_at IS NOT NULL), 0) * 1000
+		FROM images
+		WHERE status = $1 AND original_size > 0 AND optimized_size > 0
+	`
+	err = r.pool.QueryRow(ctx, query, models.StatusCompleted).Scan(
+		&stats.AverageReduction, &stats.ProcessingLatencyP50MS, &stats.ProcessingLatencyP95MS,
+	)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error querying processing stats")
+		return nil, fmt.Errorf("error querying processing stats: %w", err)
+	}
+
+	query = `SELECT created_at::date, COUNT(*) FROM images WHERE created_at >= $1 GROUP BY 1 ORDER BY 1`
+	if fromView {
+		query = `SELECT day, uploads FROM image_daily_stats WHERE day >= $1::date ORDER BY day`
+	}
+	rows, err = r.pool.Query(ctx, query, since)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error querying daily uploads")
+		return nil, fmt.Errorf("error querying daily uploads: %w", err)
+	}
+	defer rows.Close()
+
+	uploads := make(map[string]int64)
+	for rows.Next() {
+		var day time.Time
+		var count int64
+		if err := rows.Scan(&day, &count); err != nil {
+			return nil, fmt.Errorf("error scanning daily uploads: %w", err)
+		}
+		uploads[day.Format(time.DateOnly)] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over daily uploads: %w", err)
+	}
+
+	// Include days without uploads so dashboards get a continuous series
+	for day := since; !day.After(time.Now()); day = day.AddDate(0, 0, 1) {
+		key := day.Format(time.DateOnly)
+		stats.UploadsPerDay = append(stats.UploadsPerDay, models.DailyUploads{Day: key, Uploads: uploads[key]})
+	}
+
+	return stats, nil
+}
+
+// RefreshImageStats recomputes the image_daily_stats materialized view without blocking readers
+func (r *Repository) RefreshImageStats(ctx context.Context) error {
+	reqLogger := logger.FromContext(ctx)
+
+	reqLogger.Debug().Msg("Refreshing image_daily_stats")
+
+	if _, err := r.pool.Exec(ctx, `REFRESH MATERIALIZED VIEW CONCURRENTLY image_daily_stats`); err != nil {
+		reqLogger.Error().Err(err).Msg("Error refreshing image stats view")
+		return fmt.Errorf("error refreshing image stats view: %w", err)
+	}
+	return nil
+}
+
+// AddUsage adds usage counted from requests to the usage of owner on the UTC day of at.
+// StorageByteDays is ignored; it comes from RecordStorageUsage.
+func (r *Repository) AddUsage(ctx context.Context, owner string, at time.Time, usage models.UsageCounts) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		INSERT INTO usage_daily (owner, day, uploads, processed_bytes, transformations)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (owner, day) DO UPDATE
+		SET uploads = usage_daily.uploads + EXCLUDED.uploads,
+			processed_bytes = usage_daily.processed_bytes + EXCLUDED.processed_bytes,
+			transformations = usage_daily.transformations + EXCLUDED.transformations
+	`
+
+	_, err := r.pool.Exec(ctx, query, owner, at.UTC(), usage.Uploads, usage.ProcessedBytes, usage.Transformations)
+	if err != nil {
+		reqLogger.Error().Err(err).Str("owner", owner).Msg("Error adding usage")
+		return fmt.Errorf("error adding usage: %w", err)
+	}
+	return nil
+}
+
+// RecordStorageUsage snapshots the bytes stored by every owner as their storage of the UTC
+// day of at, replacing earlier snapshots of the day
+func (r *Repository) RecordStorageUsage(ctx context.Context, at time.Time) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		INSERT INTO usage_daily (owner, day, stored_bytes)
+		SELECT owner, $1, COALESCE(SUM(stored_bytes), 0)
+		FROM images
+		WHERE owner <> ''
+		GROUP BY owner
+		ON CONFLICT (owner, day) DO UPDATE
+		SET stored_bytes = EXCLUDED.stored_bytes
+	`
+
+	reqLogger.Debug().Time("day", at).Msg("Executing RecordStorageUsage query")
+
+	if _, err := r.pool.Exec(ctx, query, at.UTC()); err != nil {
+		reqLogger.Error().Err(err).Msg("Error recording storage usage")
+		return fmt.Errorf("error recording storage usage: %w", err)
+	}
+	return nil
+}
+
+// RollupUsage recomputes the monthly usage of every month from the one of since on, from
+// the daily usage
+func (r *Repository) RollupUsage(ctx context.Context, since time.Time) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		INSERT INTO usage_monthly (owner, month, uploads, processed_bytes, transformations, storage_byte_days, updated_at)
+		SELECT owner, date_trunc('month', day)::date, SUM(uploads), SUM(processed_bytes),
+			SUM(transformations), SUM(stored_bytes), $2
+		FROM usage_daily
+		WHERE day >= date_trunc('month', $1::date)
+		GROUP BY owner, date_trunc('month', day)
+		ON CONFLICT (owner, month) DO UPDATE
+		SET uploads = EXCLUDED.uploads, processed_bytes = EXCLUDED.processed_bytes,
+			transformations = EXCLUDED.transformations, storage_byte_days = EXCLUDED.storage_byte_days,
+			updated_at = EXCLUDED.updated_at
+	`
+
+	reqLogger.Debug().Time("since", since).Msg("Executing RollupUsage query")
+
+	if _, err := r.pool.Exec(ctx, query, since.UTC(), time.Now()); err != nil {
+		reqLogger.Error().Err(err).Msg("Error rolling up usage")
+		return fmt.Errorf("error rolling up usage: %w", err)
+	}
+	return nil
+}
+
+// ListMonthlyUsage retrieves the monthly usage selected by filter, by month then owner
+func (r *Repository) ListMonthlyUsage(ctx context.Context, filter models.UsageFilter) ([]*models.MonthlyUsage, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	var conditions []string
+	var args []any
+	if filter.Owner != "" {
+		args = append(args, filter.Owner)
+		conditions = append(conditions, fmt.Sprintf("owner = $%d", len(args)))
+	}
+	if !filter.From.IsZero() {
+		args = append(args, filter.From.UTC())
+		conditions = append(conditions, fmt.Sprintf("month >= date_trunc('month', $%d::date)", len(args)))
+	}
+	if !filter.To.IsZero() {
+		args = append(args, filter.To.UTC())
+		conditions = append(conditions, fmt.Sprintf("month <= date_trunc('month', $%d::date)", len(args)))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	query := `
+		SELECT owner, month, uploads, processed_bytes, transformations, storage_byte_days, updated_at
+		FROM usage_monthly
+		` + where + `
+		ORDER BY month, owner
+	`
+
+	rows, err := r.pool.Query(ctx, query, args...)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error querying monthly usage")
+		return nil, fmt.Errorf("error querying monthly usage: %w", err)
+	}
+	defer rows.Close()
+
+	usage := make([]*models.MonthlyUsage, 0)
+	for rows.Next() {
+		var u models.MonthlyUsage
+		if err := rows.Scan(&u.Owner, &u.Month, &u.Uploads, &u.ProcessedBytes, &u.Transformations,
+			&u.StorageByteDays, &u.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning monthly usage row: %w", err)
+		}
+		usage = append(usage, &u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating over rows: %w", err)
+	}
+
+	return usage, nil
+}
+
+// SaveOutboxTask persists a task that could not be published
+func (r *Repository) SaveOutboxTask(ctx context.Context, task *models.OutboxTask) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		INSERT INTO task_outbox (image_id, payload, last_error, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+
+	reqLogger.Debug().Str("image_id", task.ImageID.String()).Msg("Executing SaveOutboxTask query")
+
+	err := r.pool.QueryRow(ctx, query, task.ImageID, task.Payload, task.LastError, task.NextAttemptAt, task.CreatedAt).Scan(&task.ID)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error saving outbox task")
+		return fmt.Errorf("error saving outbox task: %w", err)
+	}
+
+	reqLogger.Debug().Int64("outbox_id", task.ID).Msg("Outbox task saved successfully")
+	return nil
+}
+
+// ClaimOutboxTasks returns up to limit tasks that are due for publishing and pushes their
+// next attempt back by lease, so concurrent relays do not publish the same task
+func (r *Repository) ClaimOutboxTasks(ctx context.Context, limit int, lease time.Duration) ([]*models.OutboxTask, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE task_outbox
+		SET next_attempt_at = $2
+		WHERE id IN (
+			SELECT id FROM task_outbox
+			WHERE next_attempt_at <= NOW()
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, image_id, payload, attempts, last_error, next_attempt_at, created_at
+	`
+
+	reqLogger.Debug().Int("limit", limit).Msg("Executing ClaimOutboxTasks query")
+
+	rows, err := r.pool.Query(ctx, query, limit, time.Now().Add(lease))
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error claiming outbox tasks")
+		return nil, fmt.Errorf("error claiming outbox tasks: %w", err)
+	}
+	defer rows.Close()
+
+	var tasks []*models.OutboxTask
+	for rows.Next() {
+		var task models.OutboxTask
+		if err := rows.Scan(&task.ID, &task.ImageID, &task.Payload, &task.Attempts, &task.LastError, &task.NextAttemptAt, &task.CreatedAt); err != nil {
+			reqLogger.Error().Err(err).Msg("Error scanning outbox task")
+			return nil, fmt.Errorf("error scanning outbox task: %w", err)
+		}
+		tasks = append(tasks, &task)
+	}
+
+	if err := rows.Err(); err != nil {
+		reqLogger.Error().Err(err).Msg("Error iterating outbox tasks")
+		return nil, fmt.Errorf("error iterating outbox tasks: %w", err)
+	}
+
+	return tasks, nil
+}
+
+// DeleteOutboxTask removes a task once it has been published
+func (r *Repository) DeleteOutboxTask(ctx context.Context, id int64) error {
+	reqLogger := logger.FromContext(ctx)
+
+	reqLogger.Debug().Int64("outbox_id", id).Msg("Executing DeleteOutboxTask query")
+
+	_, err := r.pool.Exec(ctx, `DELETE FROM task_outbox WHERE id = $1`, id)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error deleting outbox task")
+		return fmt.Errorf("error deleting outbox task: %w", err)
+	}
+
+	return nil
+}
+
+// RescheduleOutboxTask records a failed publish attempt and when to try again
+func (r *Repository) RescheduleOutboxTask(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE task_outbox
+		SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+		WHERE id = $1
+	`
+
+	reqLogger.Debug().Int64("outbox_id", id).Msg("Executing RescheduleOutboxTask query")
+
+	_, err := r.pool.Exec(ctx, query, id, lastError, nextAttemptAt)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error rescheduling outbox task")
+		return fmt.Errorf("error rescheduling outbox task: %w", err)
+	}
+
+	return nil
+}
+
+// BeginTask inserts the ledger entry of a task, or counts another attempt of one that did
+// not complete
+func (r *Repository) BeginTask(ctx context.Context, task *models.TaskRecord) error {
+	reqLogger := logger.FromContext(ctx)
+
+	// Selecting from images skips the insert, rather than failing it, for deleted images
+	query := `
+		INSERT INTO task_ledger (task_id, image_id, task_type, attempt, status, started_at, payload, worker_id)
+		SELECT $1, id, $3, 1, 'running', $4, $5, $6 FROM images WHERE id = $2
+		ON CONFLICT (task_id, task_type) DO UPDATE
+		SET attempt = task_ledger.attempt + 1, status = 'running',
+			started_at = EXCLUDED.started_at, finished_at = NULL,
+			payload = COALESCE(EXCLUDED.payload, task_ledger.payload), worker_id = EXCLUDED.worker_id
+		WHERE task_ledger.status <> 'completed'
+		RETURNING attempt, status, last_error, started_at
+	`
+
+	reqLogger.Debug().Str("task_id", task.TaskID).Str("image_id", task.ImageID.String()).Msg("Executing BeginTask query")
+
+	var payload []byte
+	if len(task.Payload) > 0 {
+		payload = task.Payload
+	}
+	err := r.pool.QueryRow(ctx, query, task.TaskID, task.ImageID, task.TaskType, time.Now(), payload, task.WorkerID).
+		Scan(&task.Attempt, &task.Status, &task.LastError, &task.StartedAt)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		reqLogger.Error().Err(err).Msg("Error beginning task")
+		return fmt.Errorf("error beginning task: %w", err)
+	}
+
+	// Nothing was written: either the task completed or its image is gone
+	var status models.TaskStatus
+	err = r.pool.QueryRow(ctx, `SELECT status FROM task_ledger WHERE task_id = $1 AND task_type = $2`, task.TaskID, task.TaskType).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("%w: %s", db.ErrNotFound, task.ImageID)
+	}
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error querying task")
+		return fmt.Errorf("error querying task: %w", err)
+	}
+
+	task.Status = status
+	return db.ErrTaskCompleted
+}
+
+// LastTask retrieves the ledger entry of the last task of taskType started for an image
+func (r *Repository) LastTask(ctx context.Context, imageID uuid.UUID, taskType string) (*models.TaskRecord, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		SELECT task_id, image_id, task_type, attempt, status, last_error, started_at, finished_at, payload
+		FROM task_ledger
+		WHERE image_id = $1 AND task_type = $2
+		ORDER BY started_at DESC
+		LIMIT 1
+	`
+
+	var task models.TaskRecord
+	err := r.pool.QueryRow(ctx, query, imageID, taskType).Scan(
+		&task.TaskID, &task.ImageID, &task.TaskType, &task.Attempt, &task.Status,
+		&task.LastError, &task.StartedAt, &task.FinishedAt, &task.Payload,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("%w: no %s task for %s", db.ErrNotFound, taskType, imageID)
+		}
+		reqLogger.Error().Err(err).Msg("Error querying last task")
+		return nil, fmt.Errorf("error querying last task: %w", err)
+	}
+
+	return &task, nil
+}
+
+// FinishTask records the outcome of an attempt of a task
+func (r *Repository) FinishTask(ctx context.Context, task *models.TaskRecord, status models.TaskStatus) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE task_ledger
+		SET status = $4, last_error = $5, finished_at = $6
+		WHERE task_id = $1 AND task_type = $2 AND attempt = $3
+	`
+
+	reqLogger.Debug().Str("task_id", task.TaskID).Int("attempt", task.Attempt).Msg("Executing FinishTask query")
+
+	_, err := r.pool.Exec(ctx, query, task.TaskID, task.TaskType, task.Attempt, status, task.LastError, time.Now())
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error finishing task")
+		return fmt.Errorf("error finishing task: %w", err)
+	}
+
+	return nil
+}
+
+// AbandonTask uncounts the attempt begun with task, which did not run because another
+// worker holds its image, and gives the entry back to the worker holding the lease
+func (r *Repository) AbandonTask(ctx context.Context, task *models.TaskRecord) error {
+	reqLogger := logger.FromContext(ctx)
+
+	query := `
+		UPDATE task_ledger l
+		SET attempt = l.attempt - 1, worker_id = i.lease_owner
+		FROM images i
+		WHERE l.task_id = $1 AND l.task_type = $2 AND l.attempt = $3 AND i.id = l.image_id
+	`
+
+	reqLogger.Debug().Str("task_id", task.TaskID).Int("attempt", task.Attempt).Msg("Executing AbandonTask query")
+
+	if _, err := r.pool.Exec(ctx, query, task.TaskID, task.TaskType, task.Attempt); err != nil {
+		reqLogger.Error().Err(err).Msg("Error abandoning task")
+		return fmt.Errorf("error abandoning task: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) Ping(ctx context.Context) error {
+	reqLogger := logger.FromContext(ctx)
+	reqLogger.Debug().Msg("Pinging database")
+
+	err := r.pool.Ping(ctx)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error pinging database")
+		return fmt.Errorf("error pinging database: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) Close() error {
+	r.pool.Close()
+	return nil
+}
+
+// scanImage scans a row selected with imageColumns into img
+// scanVersions reads all image versions from rows and closes them
+func scanVersions(rows pgx.Rows) ([]*models.ImageVersion, error) {
+	defer rows.Close()
+
+	var versions []*models.ImageVersion
+	for rows.Next() {
+		var v models.ImageVersion
+		if err := rows.Scan(&v.ImageID, &v.Version, &v.Path, &v.Size, &v.Width, &v.Height, &v.QualityScore, &v.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning image version: %w", err)
+		}
+		versions = append(versions, &v)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating image versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+// prefixColumns qualifies every column of a comma separated list with prefix
+func prefixColumns(prefix, columns string) string {
+	parts := strings.Split(columns, ", ")
+	for i, part := range parts {
+		parts[i] = prefix + part
+	}
+	return strings.Join(parts, ", ")
+}
+
+func scanImage(row pgx.Row, img *models.Image) error {
+	return row.Scan(
+		&img.ID, &img.OriginalName, &img.OriginalSize, &img.OriginalWidth, &img.OriginalHeight,
+		&img.OriginalFormat, &img.OriginalPath, &img.OptimizedPath, &img.OptimizedSize,
+		&img.OptimizedWidth, &img.OptimizedHeight, &img.Status, &img.Error, &img.ProcessingAttempts, &img.ProcessingProgress,
+		&img.ProcessingStage, &img.AutoRetries, &img.LeaseOwner, &img.LeaseExpiresAt, &img.ModerationStatus,
+		&img.BlurHash, &img.DominantColors, &img.ExtractedText, &img.CutoutPath, &img.CutoutSize, &img.Renditions, &img.QualityScore,
+		&img.Visibility, &img.Owner, &img.OriginalChecksum, &img.OriginalMD5, &img.IntegrityStatus, &img.IntegrityCheckedAt,
+		&img.ReplicationStatus, &img.ReplicatedAt, &img.StoredBytes, &img.CreatedAt, &img.UpdatedAt,
+	)
+}
+
+// likeEscaper escapes the wildcards of LIKE patterns, so they match literally
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike escapes value for use in a LIKE pattern
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
+
+// filterClause builds the WHERE clause and its arguments for an image filter
+func filterClause(filter models.ImageFilter) (string, []any) {
+	var conditions []string
+	var args []any
+
+	if filter.Query != "" {
+		args = append(args, filter.Query)
+		conditions = append(conditions, fmt.Sprintf("search_vector @@ plainto_tsquery('simple', $%d)", len(args)))
+	}
+
+	// Private images are only listed for their owner
+	switch {
+	case filter.AllOwners:
+	case filter.Viewer != "":
+		args = append(args, filter.Viewer)
+		conditions = append(conditions, fmt.Sprintf("(visibility = 'public' OR owner = $%d)", len(args)))
+	default:
+		conditions = append(conditions, "visibility = 'public'")
+	}
+
+	if !filter.CheckedBefore.IsZero() {
+		args = append(args, filter.CheckedBefore)
+		conditions = append(conditions, fmt.Sprintf("(integrity_checked_at IS NULL OR integrity_checked_at < $%d)", len(args)))
+	}
+
+	if filter.Unreplicated {
+		conditions = append(conditions, "status = 'completed' AND replication_status <> 'replicated'")
+	}
+
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		conditions = append(conditions, fmt.Sprintf("status = $%d", len(args)))
+	}
+
+	if !filter.UpdatedSince.IsZero() {
+		args = append(args, filter.UpdatedSince)
+		conditions = append(conditions, fmt.Sprintf("updated_at >= $%d", len(args)))
+	}
+
+	if filter.ErrorLike != "" {
+		args = append(args, "%"+escapeLike(filter.ErrorLike)+"%")
+		conditions = append(conditions, fmt.Sprintf("error ILIKE $%d", len(args)))
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
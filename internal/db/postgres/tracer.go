@@ -0,0 +1,104 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the query spans among those of the service
+const tracerName = "github.com/not-nullexception/image-optimizer/internal/db/postgres"
+
+// queryTracer records every query as a span named after its statement summary, such as
+// "SELECT images", with the statement and the rows it returned or affected. Spans are
+// only started as children of the span of the caller, so queries of untraced work such
+// as the health check don't start traces of their own.
+type queryTracer struct{}
+
+func (t *queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	parent := trace.SpanFromContext(ctx)
+	if !parent.SpanContext().IsValid() {
+		return ctx
+	}
+
+	operation, table := summarize(data.SQL)
+	name := operation
+	if table != "" {
+		name += " " + table
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.operation", operation),
+		// queries only hold placeholders, so the statement carries no values
+		attribute.String("db.statement", strings.Join(strings.Fields(data.SQL), " ")),
+	}
+	if table != "" {
+		attrs = append(attrs, attribute.String("db.sql.table", table))
+	}
+
+	ctx, _ = parent.TracerProvider().Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	return ctx
+}
+
+func (t *queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := trace.SpanFromContext(ctx)
+	if !span.IsRecording() {
+		return
+	}
+	defer span.End()
+
+	// pgx.ErrNoRows is an expected answer, not a failed query
+	if data.Err != nil && !errors.Is(data.Err, pgx.ErrNoRows) {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+		return
+	}
+	span.SetAttributes(attribute.Int64("db.rows", data.CommandTag.RowsAffected()))
+}
+
+// summarize returns the operation of the statement sql and the table it reads or writes,
+// empty when it can't be told, such as for statements starting with a WITH clause
+func summarize(sql string) (operation, table string) {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "QUERY", ""
+	}
+	operation = strings.ToUpper(fields[0])
+
+	var marker string
+	switch operation {
+	case "SELECT", "DELETE":
+		marker = "FROM"
+	case "INSERT":
+		marker = "INTO"
+	case "UPDATE":
+		if len(fields) > 1 {
+			return operation, tableName(fields[1])
+		}
+		return operation, ""
+	default:
+		return operation, ""
+	}
+
+	for i := 1; i < len(fields)-1; i++ {
+		if strings.EqualFold(fields[i], marker) {
+			return operation, tableName(fields[i+1])
+		}
+	}
+	return operation, ""
+}
+
+// tableName strips what may follow a table name without a space, such as a column list
+func tableName(field string) string {
+	name, _, _ := strings.Cut(field, "(")
+	return strings.TrimRight(name, ",;")
+}
@@ -2,20 +2,151 @@ package db
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/not-nullexception/image-optimizer/internal/db/models"
 )
 
+// ErrNotFound is returned when the requested image does not exist
+var ErrNotFound = errors.New("image not found")
+
+// ErrVersionNotFound is returned when the requested image version does not exist
+var ErrVersionNotFound = errors.New("image version not found")
+
+// ErrDeletionNotFound is returned when an image has no pending deletion
+var ErrDeletionNotFound = errors.New("image deletion not found")
+
+// ErrTaskCompleted is returned when a redelivered task already completed
+var ErrTaskCompleted = errors.New("task already completed")
+
+// ErrStatusConflict is returned when an image is not in the status a transition expects
+var ErrStatusConflict = errors.New("image status conflict")
+
+// ErrLeaseHeld is returned when another worker holds the lease of an image
+var ErrLeaseHeld = errors.New("image leased by another worker")
+
+// ErrLeaseLost is returned when a worker no longer holds the lease of an image
+var ErrLeaseLost = errors.New("image lease lost")
+
+// ErrUploadTokenUsed is returned when a single-use upload token was already used
+var ErrUploadTokenUsed = errors.New("upload token already used")
+
 // Repository defines the interface for database operations
 type Repository interface {
 	GetImageByID(ctx context.Context, id uuid.UUID) (*models.Image, error)
-	ListImages(ctx context.Context, limit, offset int) ([]*models.Image, int, error)
+	// FindImageByChecksum returns the newest image of owner whose original has the hex SHA-256
+	// checksum, skipping failed and rejected images. It fails with ErrNotFound if there is none.
+	FindImageByChecksum(ctx context.Context, checksum, owner string) (*models.Image, error)
+	ListImages(ctx context.Context, filter models.ImageFilter, limit, offset int) ([]*models.Image, int, error)
+	// CountImagesByStatus counts the images matching filter in each processing status, ignoring its status
+	CountImagesByStatus(ctx context.Context, filter models.ImageFilter) (models.ImageStatusCounts, error)
+	// IterateImages calls fn for every image matching filter, newest first, reading batchSize rows at a time
+	IterateImages(ctx context.Context, filter models.ImageFilter, batchSize int, fn func(*models.Image) error) error
 	CreateImage(ctx context.Context, image *models.Image) error
 	UpdateImage(ctx context.Context, image *models.Image) error
 	DeleteImage(ctx context.Context, id uuid.UUID) error
 	UpdateImageStatus(ctx context.Context, id uuid.UUID, status models.ProcessingStatus, errorMsg string) error
-	UpdateImageOptimized(ctx context.Context, id uuid.UUID, path string, size int64, width, height int) error
+	// UpdateLeasedImageStatus updates the status of an image leased to workerID. It fails
+	// with ErrLeaseLost if another worker took the image over.
+	UpdateLeasedImageStatus(ctx context.Context, id uuid.UUID, workerID string, status models.ProcessingStatus, errorMsg string) error
+	// StartImageProcessing moves an image to processing for the resize task taskID, leased to
+	// workerID for lease, and returns the worker whose expired lease it took over, if any. It
+	// fails with ErrStatusConflict unless the image is pending, or taskID started it and it did
+	// not complete since, and with ErrLeaseHeld while another worker holds the image.
+	StartImageProcessing(ctx context.Context, id uuid.UUID, taskID, workerID string, lease time.Duration) (string, error)
+	// RenewImageLease extends the lease of workerID on an image. It fails with ErrLeaseLost
+	// if another worker took the image over.
+	RenewImageLease(ctx context.Context, id uuid.UUID, workerID string, lease time.Duration) error
+	// ReleaseImageLease drops the lease of workerID on an image, if it still holds it
+	ReleaseImageLease(ctx context.Context, id uuid.UUID, workerID string) error
+	// RetryImage moves a failed image back to pending to retry it, counting automatic retries.
+	// It fails with ErrStatusConflict if the image is not failed.
+	RetryImage(ctx context.Context, id uuid.UUID, automatic bool) error
+	// ListRetryableImages returns up to limit failed images due for an automatic retry
+	ListRetryableImages(ctx context.Context, maxRetries int, baseDelay, maxDelay time.Duration, limit int) ([]*models.Image, error)
+	// UpdateImageProgress records the progress in percent and the stage of a running resize task
+	UpdateImageProgress(ctx context.Context, id uuid.UUID, percent int, stage string) error
+	// UpdateImageOptimized records the result of processing an image leased to workerID and
+	// completes it. It fails with ErrLeaseLost if another worker took the image over.
+	UpdateImageOptimized(ctx context.Context, id uuid.UUID, workerID string, path string, size int64, width, height int) error
+	UpdateModerationStatus(ctx context.Context, id uuid.UUID, status models.ModerationStatus) error
+	UpdateImagePlaceholder(ctx context.Context, id uuid.UUID, blurHash string, dominantColors []string) error
+	UpdateImageText(ctx context.Context, id uuid.UUID, text string) error
+	UpdateImageCutout(ctx context.Context, id uuid.UUID, path string, size int64) error
+	UpdateImageRenditions(ctx context.Context, id uuid.UUID, renditions []models.Rendition) error
+	UpdateImageQualityScore(ctx context.Context, id uuid.UUID, score float64) error
+	UpdateImageIntegrity(ctx context.Context, id uuid.UUID, status models.IntegrityStatus, checkedAt time.Time) error
+	UpdateImageReplication(ctx context.Context, id uuid.UUID, status models.ReplicationStatus, replicatedAt, updatedAt time.Time) error
+
+	// Versions
+	NextImageVersion(ctx context.Context, id uuid.UUID) (int, error)
+	CreateImageVersion(ctx context.Context, version *models.ImageVersion) error
+	ListImageVersions(ctx context.Context, id uuid.UUID) ([]*models.ImageVersion, error)
+	// PromoteImageVersion makes a stored version the current optimized image
+	PromoteImageVersion(ctx context.Context, id uuid.UUID, version int) error
+	// MoveImageOriginal renames the original of an image from one object to another,
+	// including the optimized image and versions that reuse the original. It fails with
+	// ErrNotFound unless the image still has the original from.
+	MoveImageOriginal(ctx context.Context, id uuid.UUID, from, to string) error
+	// PruneImageVersions deletes the versions beyond the newest keep (0 keeps all) and those
+	// older than maxAge (0 disables it), never the current one, and returns them
+	PruneImageVersions(ctx context.Context, id uuid.UUID, keep int, maxAge time.Duration) ([]*models.ImageVersion, error)
+
+	// ListImageEvents returns the status transitions of an image, oldest first
+	ListImageEvents(ctx context.Context, id uuid.UUID) ([]*models.ImageEvent, error)
+	// ListenImageStatus calls handle with the changes of the status or progress of every
+	// image, and their deletes, until ctx is done or it stops listening with an error.
+	// handle must not block.
+	ListenImageStatus(ctx context.Context, handle func(*models.ImageStatusEvent)) error
+
+	// ObjectReferenced reports whether any image other than exclude, or any version, uses an object
+	ObjectReferenced(ctx context.Context, objectName string, exclude uuid.UUID) (bool, error)
+	// UnreferencedObjects returns the objects of names that no image or version uses
+	UnreferencedObjects(ctx context.Context, names []string) ([]string, error)
+
+	// Two-step deletions
+	SaveImageDeletion(ctx context.Context, deletion *models.ImageDeletion) error
+	GetImageDeletion(ctx context.Context, id uuid.UUID) (*models.ImageDeletion, error)
+	ListExpiredImageDeletions(ctx context.Context, now time.Time, limit int) ([]*models.ImageDeletion, error)
+	DeleteImageDeletion(ctx context.Context, id uuid.UUID) error
+
+	// UseUploadToken records the use of an upload token valid until expiresAt, failing with
+	// ErrUploadTokenUsed if it was used before. Expired tokens are forgotten.
+	UseUploadToken(ctx context.Context, id uuid.UUID, expiresAt time.Time) error
+
+	// Statistics
+	GetStorageUsage(ctx context.Context) (*models.StorageUsage, error)
+	GetImageStats(ctx context.Context, since time.Time, fromView bool) (*models.ImageStats, error)
+	RefreshImageStats(ctx context.Context) error
+
+	// Usage metering
+	// AddUsage adds usage counted from requests to the usage of owner on the UTC day of at
+	AddUsage(ctx context.Context, owner string, at time.Time, usage models.UsageCounts) error
+	// RecordStorageUsage snapshots the bytes stored by every owner on the UTC day of at
+	RecordStorageUsage(ctx context.Context, at time.Time) error
+	// RollupUsage recomputes the monthly usage from the month of since on
+	RollupUsage(ctx context.Context, since time.Time) error
+	ListMonthlyUsage(ctx context.Context, filter models.UsageFilter) ([]*models.MonthlyUsage, error)
+
+	// Task outbox
+	SaveOutboxTask(ctx context.Context, task *models.OutboxTask) error
+	ClaimOutboxTasks(ctx context.Context, limit int, lease time.Duration) ([]*models.OutboxTask, error)
+	DeleteOutboxTask(ctx context.Context, id int64) error
+	RescheduleOutboxTask(ctx context.Context, id int64, lastError string, nextAttemptAt time.Time) error
+
+	// Processing ledger
+	// BeginTask records a delivery of a task starting to process and sets its attempt. It
+	// fails with ErrTaskCompleted if the task already completed, and ErrNotFound if its image is gone.
+	BeginTask(ctx context.Context, task *models.TaskRecord) error
+	// LastTask returns the ledger entry of the last task of taskType started for an image
+	LastTask(ctx context.Context, imageID uuid.UUID, taskType string) (*models.TaskRecord, error)
+	// FinishTask records the outcome of the attempt begun with task, unless a later attempt started since
+	FinishTask(ctx context.Context, task *models.TaskRecord, status models.TaskStatus) error
+	// AbandonTask uncounts the attempt begun with task, which did not run because another
+	// worker holds its image, and gives the entry back to that worker
+	AbandonTask(ctx context.Context, task *models.TaskRecord) error
 
 	// Health check
 	Ping(ctx context.Context) error
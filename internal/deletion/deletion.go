@@ -0,0 +1,255 @@
+// Package deletion removes images with their stored objects, either immediately or in two
+// steps: a request that returns a confirmation token, and a confirmation (or, in deferred
+// mode, the end of the grace period) that purges the image.
+package deletion
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/audit"
+	"github.com/not-nullexception/image-optimizer/internal/cdn"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+	"github.com/rs/zerolog"
+)
+
+// Deletion modes
+const (
+	ModeImmediate = "immediate"
+	ModeConfirm   = "confirm"
+	ModeDeferred  = "deferred"
+)
+
+// sweepBatchSize is how many expired deletions are processed per sweep
+const sweepBatchSize = 100
+
+// ErrInvalidToken is returned when a confirmation token does not match a pending deletion
+var ErrInvalidToken = errors.New("invalid or expired deletion token")
+
+// Purger deletes images and manages their pending deletions
+type Purger struct {
+	repo        db.Repository
+	minioClient minio.Client
+	invalidator cdn.Invalidator
+	config      *config.DeleteConfig
+	logger      zerolog.Logger
+}
+
+// NewPurger creates a new Purger
+func NewPurger(repo db.Repository, minioClient minio.Client, invalidator cdn.Invalidator, cfg *config.DeleteConfig) *Purger {
+	return &Purger{
+		repo:        repo,
+		minioClient: minioClient,
+		invalidator: invalidator,
+		config:      cfg,
+		logger:      logger.GetLogger("deletion"),
+	}
+}
+
+// TwoStep reports whether deletions must be requested before they are purged
+func (p *Purger) TwoStep() bool {
+	return p.config.Mode == ModeConfirm || p.config.Mode == ModeDeferred
+}
+
+// Mode returns the configured deletion mode
+func (p *Purger) Mode() string {
+	return p.config.Mode
+}
+
+// Request stores a pending deletion of an image and returns it with its confirmation
+// token. A new request replaces the token of an earlier one.
+func (p *Purger) Request(ctx context.Context, id uuid.UUID) (*models.ImageDeletion, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", fmt.Errorf("error generating deletion token: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now()
+	deletion := &models.ImageDeletion{
+		ImageID:     id,
+		TokenHash:   hashToken(token),
+		RequestedAt: now,
+		ExpiresAt:   now.Add(p.config.GracePeriod),
+	}
+	if err := p.repo.SaveImageDeletion(ctx, deletion); err != nil {
+		return nil, "", err
+	}
+
+	metrics.DeletionsTotal.WithLabelValues("requested").Inc()
+	audit.Record(ctx, audit.ActionDeleteRequested, id, map[string]any{
+		"mode":       p.config.Mode,
+		"expires_at": deletion.ExpiresAt,
+	})
+
+	return deletion, token, nil
+}
+
+// Confirm purges an image with a pending deletion if token matches it
+func (p *Purger) Confirm(ctx context.Context, img *models.Image, token string) error {
+	deletion, err := p.repo.GetImageDeletion(ctx, img.ID)
+	if err != nil {
+		if errors.Is(err, db.ErrDeletionNotFound) {
+			return ErrInvalidToken
+		}
+		return err
+	}
+
+	expired := time.Now().After(deletion.ExpiresAt)
+	if subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(deletion.TokenHash)) != 1 ||
+		(expired && p.config.Mode == ModeConfirm) {
+		return ErrInvalidToken
+	}
+
+	metrics.DeletionsTotal.WithLabelValues("confirmed").Inc()
+	audit.Record(ctx, audit.ActionDeleteConfirmed, img.ID, nil)
+
+	return p.Purge(ctx, img)
+}
+
+// Purge deletes the objects of an image and then the image itself. Objects that cannot be
+// deleted are logged and left behind so the image never outlives its database record.
+func (p *Purger) Purge(ctx context.Context, img *models.Image) error {
+	reqLogger := logger.FromContext(ctx)
+	id := img.ID
+	idStr := id.String()
+
+	// Delete original image from MinIO
+	err := minio.Release(ctx, p.minioClient.In(minio.ClassOriginal), p.repo, img.OriginalPath, id)
+	if err != nil {
+		reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to delete original image from storage")
+		// Continue anyway, as we want to clean up the database; the garbage collector
+		// removes the object later
+	}
+
+	// Delete optimized image from MinIO if it exists
+	if img.OptimizedPath != "" && img.OptimizedPath != img.OriginalPath {
+		err = minio.Release(ctx, p.minioClient.In(minio.ClassOptimized), p.repo, img.OptimizedPath, id)
+		if err != nil {
+			reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to delete optimized image from storage")
+			// Continue anyway
+		}
+	}
+
+	// Delete the older versions from MinIO
+	versions, err := p.repo.ListImageVersions(ctx, id)
+	if err != nil {
+		reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to list image versions")
+	}
+	for _, version := range versions {
+		if version.Path == img.OptimizedPath || version.Path == img.OriginalPath {
+			continue
+		}
+		err = minio.Release(ctx, p.minioClient.In(minio.ClassOptimized), p.repo, version.Path, id)
+		if err != nil {
+			reqLogger.Error().Err(err).Str("id", idStr).Int("version", version.Version).Msg("Failed to delete image version from storage")
+		}
+	}
+
+	// Delete the renditions from MinIO
+	for _, rendition := range img.Renditions {
+		err = minio.Release(ctx, p.minioClient.In(minio.ClassOptimized), p.repo, rendition.Path, id)
+		if err != nil {
+			reqLogger.Error().Err(err).Str("id", idStr).Str("rendition", rendition.Name).Msg("Failed to delete rendition from storage")
+		}
+	}
+
+	// Delete the cut-out from MinIO if it exists
+	if img.CutoutPath != "" {
+		err = minio.Release(ctx, p.minioClient.In(minio.ClassOptimized), p.repo, img.CutoutPath, id)
+		if err != nil {
+			reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to delete cutout image from storage")
+		}
+	}
+
+	// Delete the image from the database, which also drops a pending deletion
+	if err := p.repo.DeleteImage(ctx, id); err != nil {
+		reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to delete image from database")
+		return err
+	}
+
+	// Purge the optimized image from the CDN
+	if img.OptimizedPath != "" {
+		if err := p.invalidator.Invalidate(ctx, img.OptimizedPath); err != nil {
+			reqLogger.Error().Err(err).Str("id", idStr).Msg("Failed to invalidate CDN cache for deleted image")
+		}
+	}
+
+	metrics.DeletionsTotal.WithLabelValues("purged").Inc()
+	audit.Record(ctx, audit.ActionDeleted, id, map[string]any{"original_path": img.OriginalPath})
+
+	return nil
+}
+
+// Run processes expired pending deletions every PurgeInterval until ctx is cancelled: in
+// deferred mode the images are purged, in confirm mode the deletions are dropped.
+func (p *Purger) Run(ctx context.Context) {
+	p.logger.Info().Str("mode", p.config.Mode).Dur("interval", p.config.PurgeInterval).Msg("Starting deletion purger")
+
+	ticker := time.NewTicker(p.config.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			p.logger.Info().Msg("Deletion purger stopped")
+			return
+		case <-ticker.C:
+			p.sweep(logger.ToContext(ctx, p.logger))
+		}
+	}
+}
+
+// sweep processes one batch of expired deletions
+func (p *Purger) sweep(ctx context.Context) {
+	deletions, err := p.repo.ListExpiredImageDeletions(ctx, time.Now(), sweepBatchSize)
+	if err != nil {
+		p.logger.Error().Err(err).Msg("Failed to list expired deletions")
+		return
+	}
+
+	for _, deletion := range deletions {
+		deletionLogger := p.logger.With().Str("image_id", deletion.ImageID.String()).Logger()
+
+		if p.config.Mode != ModeDeferred {
+			// Unconfirmed deletions expire and the image is kept
+			if err := p.repo.DeleteImageDeletion(ctx, deletion.ImageID); err != nil {
+				deletionLogger.Error().Err(err).Msg("Failed to drop expired deletion")
+				continue
+			}
+			metrics.DeletionsTotal.WithLabelValues("expired").Inc()
+			audit.Record(ctx, audit.ActionDeleteExpired, deletion.ImageID, nil)
+			continue
+		}
+
+		img, err := p.repo.GetImageByID(ctx, deletion.ImageID)
+		if err != nil {
+			deletionLogger.Error().Err(err).Msg("Failed to get image of expired deletion")
+			continue
+		}
+		if err := p.Purge(ctx, img); err != nil {
+			deletionLogger.Error().Err(err).Msg("Failed to purge image after grace period")
+			continue
+		}
+		deletionLogger.Info().Msg("Image purged after grace period")
+	}
+}
+
+// hashToken returns the hex encoded SHA-256 hash of a confirmation token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,46 @@
+// Package errreport aggregates failures in an external error tracker, next to the logs.
+package errreport
+
+import (
+	"context"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// Reporter sends errors and panics to an error tracker. Events carry the request and
+// trace IDs of ctx besides tags; they are sent in the background, so callers never
+// block on the tracker.
+type Reporter interface {
+	// CaptureError reports a failure with the stack trace of the caller
+	CaptureError(ctx context.Context, err error, tags map[string]string)
+	// CapturePanic reports a recovered panic. It must be called directly from the
+	// deferred function that recovered, so the stack trace starts at the panic.
+	CapturePanic(ctx context.Context, recovered any, tags map[string]string)
+	// Flush waits for pending events, up to ctx's deadline
+	Flush(ctx context.Context)
+}
+
+// NewReporter returns the Reporter for the configured provider,
+// or nil if error reporting is disabled.
+func NewReporter(cfg *config.ErrorReportConfig) Reporter {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	initLogger := logger.GetLogger("errreport")
+
+	switch cfg.Provider {
+	case "sentry":
+		reporter, err := newSentryReporter(&cfg.Sentry)
+		if err != nil {
+			initLogger.Warn().Err(err).Msg("Error creating Sentry reporter, error reporting disabled")
+			return nil
+		}
+		initLogger.Info().Str("provider", cfg.Provider).Str("environment", cfg.Sentry.Environment).Msg("Error reporting enabled")
+		return reporter
+	default:
+		initLogger.Warn().Str("provider", cfg.Provider).Msg("Unknown error reporting provider, error reporting disabled")
+		return nil
+	}
+}
@@ -0,0 +1,257 @@
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// modulePath marks the stack frames of this application as in-app in Sentry
+const modulePath = "github.com/not-nullexception/image-optimizer"
+
+// sentryReporter reports events to the store endpoint of a Sentry project
+type sentryReporter struct {
+	endpoint    string
+	auth        string
+	environment string
+	release     string
+	serverName  string
+	client      *http.Client
+	pending     sync.WaitGroup
+	logger      zerolog.Logger
+}
+
+func newSentryReporter(cfg *config.SentryConfig) (*sentryReporter, error) {
+	endpoint, key, err := parseDSN(cfg.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sentry DSN: %w", err)
+	}
+
+	serverName, _ := os.Hostname()
+
+	return &sentryReporter{
+		endpoint:    endpoint,
+		auth:        fmt.Sprintf("Sentry sentry_version=7, sentry_client=image-optimizer/%s, sentry_key=%s", cfg.Release, key),
+		environment: cfg.Environment,
+		release:     cfg.Release,
+		serverName:  serverName,
+		client:      &http.Client{Timeout: cfg.Timeout},
+		logger:      logger.GetLogger("errreport-sentry"),
+	}, nil
+}
+
+// parseDSN turns a DSN of the form https://key@host/path/project into the store
+// endpoint of the project and its public key
+func parseDSN(dsn string) (string, string, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "", "", err
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return "", "", fmt.Errorf("missing public key")
+	}
+
+	prefix, project := path.Split(strings.TrimSuffix(u.Path, "/"))
+	if project == "" {
+		return "", "", fmt.Errorf("missing project id")
+	}
+
+	endpoint := fmt.Sprintf("%s://%s%sapi/%s/store/", u.Scheme, u.Host, prefix, project)
+	return endpoint, u.User.Username(), nil
+}
+
+// CaptureError reports err with the stack trace of the caller
+func (s *sentryReporter) CaptureError(ctx context.Context, err error, tags map[string]string) {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(2, pcs)
+
+	// Group by the type of the innermost error rather than by the wrapping fmt errors
+	root := err
+	for unwrapped := errors.Unwrap(root); unwrapped != nil; unwrapped = errors.Unwrap(root) {
+		root = unwrapped
+	}
+
+	event := s.newEvent(ctx, "error", tags)
+	event.Exception = &exceptions{Values: []exception{{
+		Type:       fmt.Sprintf("%T", root),
+		Value:      err.Error(),
+		Stacktrace: stacktraceFrom(pcs[:n]),
+	}}}
+
+	s.send(event)
+}
+
+// CapturePanic reports recovered with the stack trace of the panic, skipping the
+// deferred function that recovered it
+func (s *sentryReporter) CapturePanic(ctx context.Context, recovered any, tags map[string]string) {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(3, pcs)
+
+	event := s.newEvent(ctx, "fatal", tags)
+	event.Exception = &exceptions{Values: []exception{{
+		Type:       "panic",
+		Value:      fmt.Sprint(recovered),
+		Stacktrace: stacktraceFrom(pcs[:n]),
+	}}}
+
+	s.send(event)
+}
+
+// Flush waits for events still being sent, up to ctx's deadline
+func (s *sentryReporter) Flush(ctx context.Context) {
+	done := make(chan struct{})
+	go func() {
+		s.pending.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		s.logger.Warn().Msg("Timed out flushing Sentry events")
+	}
+}
+
+func (s *sentryReporter) newEvent(ctx context.Context, level string, tags map[string]string) *event {
+	e := &event{
+		EventID:     strings.ReplaceAll(uuid.NewString(), "-", ""),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339Nano),
+		Level:       level,
+		Platform:    "go",
+		ServerName:  s.serverName,
+		Environment: s.environment,
+		Release:     s.release,
+		Tags:        map[string]string{},
+	}
+
+	for k, v := range tags {
+		e.Tags[k] = v
+	}
+	if requestID := logger.RequestIDFromContext(ctx); requestID != "" {
+		e.Tags["request_id"] = requestID
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		e.Contexts = map[string]any{
+			"trace": map[string]string{
+				"trace_id": sc.TraceID().String(),
+				"span_id":  sc.SpanID().String(),
+			},
+		}
+	}
+
+	return e
+}
+
+// send posts the event without blocking the caller
+func (s *sentryReporter) send(e *event) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		s.logger.Error().Err(err).Msg("Error encoding Sentry event")
+		return
+	}
+
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+
+		req, err := http.NewRequest(http.MethodPost, s.endpoint, bytes.NewReader(body))
+		if err != nil {
+			s.logger.Error().Err(err).Msg("Error creating Sentry request")
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Sentry-Auth", s.auth)
+
+		resp, err := s.client.Do(req)
+		if err != nil {
+			s.logger.Error().Err(err).Str("event_id", e.EventID).Msg("Error sending event to Sentry")
+			return
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 300 {
+			s.logger.Error().Int("status", resp.StatusCode).Str("event_id", e.EventID).Msg("Sentry rejected event")
+		}
+	}()
+}
+
+// stacktraceFrom converts program counters, innermost first, into Sentry frames,
+// which are ordered oldest first
+func stacktraceFrom(pcs []uintptr) *stacktrace {
+	var result []frame
+
+	frames := runtime.CallersFrames(pcs)
+	for {
+		f, more := frames.Next()
+		if f.Function != "" {
+			result = append(result, frame{
+				Function: f.Function,
+				AbsPath:  f.File,
+				Filename: path.Base(f.File),
+				Lineno:   f.Line,
+				InApp:    strings.HasPrefix(f.Function, modulePath),
+			})
+		}
+		if !more {
+			break
+		}
+	}
+
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+
+	return &stacktrace{Frames: result}
+}
+
+// event is the subset of the Sentry event payload used by this package
+type event struct {
+	EventID     string            `json:"event_id"`
+	Timestamp   string            `json:"timestamp"`
+	Level       string            `json:"level"`
+	Platform    string            `json:"platform"`
+	ServerName  string            `json:"server_name,omitempty"`
+	Environment string            `json:"environment,omitempty"`
+	Release     string            `json:"release,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	Contexts    map[string]any    `json:"contexts,omitempty"`
+	Exception   *exceptions       `json:"exception,omitempty"`
+}
+
+type exceptions struct {
+	Values []exception `json:"values"`
+}
+
+type exception struct {
+	Type       string      `json:"type"`
+	Value      string      `json:"value"`
+	Stacktrace *stacktrace `json:"stacktrace,omitempty"`
+}
+
+type stacktrace struct {
+	Frames []frame `json:"frames"`
+}
+
+type frame struct {
+	Function string `json:"function"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
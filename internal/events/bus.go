@@ -0,0 +1,138 @@
+// Package events relays the status events of images notified by the database to the
+// subscribers of the API, such as progress streams and long-polling requests.
+package events
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	"github.com/rs/zerolog"
+)
+
+// listenRetryDelay is how long the bus waits before listening again after it stopped
+// listening with an error
+const listenRetryDelay = time.Second
+
+// Event is a status event of an image, or a resync after events may have been missed
+type Event struct {
+	models.ImageStatusEvent
+	// Resync is set when events may have been missed while the bus was not listening;
+	// subscribers read the image again
+	Resync bool
+}
+
+// Bus listens for the status events of images on the repository and fans them out to the
+// subscribers of each image
+type Bus struct {
+	repo   db.Repository
+	logger zerolog.Logger
+
+	mu          sync.Mutex
+	subscribers map[uuid.UUID]map[chan Event]struct{}
+}
+
+// NewBus creates a new Bus, which delivers events once it runs
+func NewBus(repo db.Repository) *Bus {
+	return &Bus{
+		repo:        repo,
+		logger:      logger.GetLogger("event-bus"),
+		subscribers: make(map[uuid.UUID]map[chan Event]struct{}),
+	}
+}
+
+// Run listens for status events until ctx is cancelled, listening again whenever the
+// repository stops listening
+func (b *Bus) Run(ctx context.Context) {
+	b.logger.Info().Msg("Starting image status event bus")
+
+	ctx = logger.ToContext(ctx, b.logger)
+	for {
+		err := b.repo.ListenImageStatus(ctx, b.publish)
+		if ctx.Err() != nil {
+			b.logger.Info().Msg("Image status event bus stopped")
+			return
+		}
+		b.logger.Warn().Err(err).Msg("Stopped listening for image status events, listening again")
+		metrics.StatusEventsTotal.WithLabelValues("resync").Inc()
+		b.resync()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(listenRetryDelay):
+		}
+	}
+}
+
+// Subscribe returns a channel receiving the events of an image until ctx is done. It holds
+// the newest event only: an event not read before the next one is replaced by it.
+func (b *Bus) Subscribe(ctx context.Context, id uuid.UUID) <-chan Event {
+	events := make(chan Event, 1)
+
+	b.mu.Lock()
+	if b.subscribers[id] == nil {
+		b.subscribers[id] = make(map[chan Event]struct{})
+	}
+	b.subscribers[id][events] = struct{}{}
+	b.mu.Unlock()
+	metrics.StatusEventSubscribers.Inc()
+
+	context.AfterFunc(ctx, func() {
+		b.mu.Lock()
+		delete(b.subscribers[id], events)
+		if len(b.subscribers[id]) == 0 {
+			delete(b.subscribers, id)
+		}
+		b.mu.Unlock()
+		metrics.StatusEventSubscribers.Dec()
+	})
+	return events
+}
+
+// publish delivers a status event to the subscribers of its image
+func (b *Bus) publish(event *models.ImageStatusEvent) {
+	metrics.StatusEventsTotal.WithLabelValues("received").Inc()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for events := range b.subscribers[event.ImageID] {
+		deliver(events, Event{ImageStatusEvent: *event})
+	}
+}
+
+// resync tells every subscriber to read its image again
+func (b *Bus) resync() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for id, subscribers := range b.subscribers {
+		for events := range subscribers {
+			deliver(events, Event{ImageStatusEvent: models.ImageStatusEvent{ImageID: id}, Resync: true})
+		}
+	}
+}
+
+// deliver sends event to a subscriber, replacing the event it did not read yet. The
+// caller holds the lock, so it is the only sender.
+func deliver(events chan Event, event Event) {
+	select {
+	case events <- event:
+		return
+	default:
+	}
+
+	// A replaced resync is kept, since the event replacing it may not tell everything missed
+	select {
+	case unread := <-events:
+		event.Resync = event.Resync || unread.Resync
+	default:
+	}
+	events <- event
+}
@@ -0,0 +1,41 @@
+package faces
+
+import (
+	"context"
+	"image"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// Detector finds faces in an image and returns their bounding boxes in image coordinates
+type Detector interface {
+	Detect(ctx context.Context, img image.Image) ([]image.Rectangle, error)
+}
+
+// NewDetector returns the Detector for the configured provider,
+// or nil if face detection is disabled.
+func NewDetector(cfg *config.FaceDetectionConfig) Detector {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	initLogger := logger.GetLogger("faces")
+
+	switch cfg.Provider {
+	case "pigo":
+		detector, err := newPigoDetector()
+		if err != nil {
+			initLogger.Error().Err(err).Msg("Error loading face detector, face detection disabled")
+			return nil
+		}
+		initLogger.Info().Str("provider", cfg.Provider).Msg("Face detection enabled")
+		return detector
+	case "http":
+		initLogger.Info().Str("provider", cfg.Provider).Msg("Face detection enabled")
+		return newHTTPDetector(cfg)
+	default:
+		initLogger.Warn().Str("provider", cfg.Provider).Msg("Unknown face detection provider, face detection disabled")
+		return nil
+	}
+}
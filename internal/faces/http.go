@@ -0,0 +1,88 @@
+package faces
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// httpDetector sends the image as JPEG to an external detection service (for example a
+// pigo or OpenCV sidecar), which must answer with a JSON body of the form
+// {"faces": [{"x": 10, "y": 20, "width": 64, "height": 64}]} in pixel coordinates.
+type httpDetector struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+type httpFace struct {
+	X      int `json:"x"`
+	Y      int `json:"y"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
+type httpResponse struct {
+	Faces []httpFace `json:"faces"`
+}
+
+func newHTTPDetector(cfg *config.FaceDetectionConfig) *httpDetector {
+	return &httpDetector{
+		url:    cfg.URL,
+		token:  cfg.Token,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+// Detect sends the image to the detection service and returns the faces it found
+func (h *httpDetector) Detect(ctx context.Context, img image.Image) ([]image.Rectangle, error) {
+	reqLogger := logger.FromContext(ctx).With().Str("component", "faces-http").Logger()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("error encoding image for face detection: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, &buf)
+	if err != nil {
+		return nil, fmt.Errorf("error creating face detection request: %w", err)
+	}
+	req.Header.Set("Content-Type", "image/jpeg")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error calling face detection service")
+		return nil, fmt.Errorf("error calling face detection service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		reqLogger.Error().Int("status", resp.StatusCode).Msg("Face detection service returned an error")
+		return nil, fmt.Errorf("face detection service returned status %d", resp.StatusCode)
+	}
+
+	var result httpResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("error decoding face detection response: %w", err)
+	}
+
+	// Translate to the coordinate space of img, whose bounds may not start at the origin
+	origin := img.Bounds().Min
+	rects := make([]image.Rectangle, 0, len(result.Faces))
+	for _, f := range result.Faces {
+		rects = append(rects, image.Rect(f.X, f.Y, f.X+f.Width, f.Y+f.Height).Add(origin))
+	}
+
+	reqLogger.Debug().Int("faces", len(rects)).Msg("Faces detected")
+	return rects, nil
+}
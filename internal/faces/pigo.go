@@ -0,0 +1,98 @@
+package faces
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"image"
+	"image/draw"
+
+	"github.com/disintegration/imaging"
+	pigo "github.com/esimov/pigo/core"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+const (
+	// pigoMaxSide is the longest side images are scaled down to before detection. Faces
+	// smaller than pigoMinFaceSize at that scale are not found.
+	pigoMaxSide     = 1024
+	pigoMinFaceSize = 20
+	// pigoMinScore is the detection score below which a detection is not a face
+	pigoMinScore = 5.0
+	// pigoIoUThreshold merges overlapping detections of the same face
+	pigoIoUThreshold = 0.2
+)
+
+// facefinderCascade is the frontal face cascade shipped with pigo
+// (github.com/esimov/pigo/cascade/facefinder, MIT licensed)
+//
+//go:embed cascade/facefinder
+var facefinderCascade []byte
+
+// pigoDetector finds faces in-process with the pigo pixel intensity comparison detector
+type pigoDetector struct {
+	classifier *pigo.Pigo
+}
+
+func newPigoDetector() (*pigoDetector, error) {
+	classifier, err := pigo.NewPigo().Unpack(facefinderCascade)
+	if err != nil {
+		return nil, fmt.Errorf("error unpacking face detection cascade: %w", err)
+	}
+	return &pigoDetector{classifier: classifier}, nil
+}
+
+// Detect runs the cascade over a grayscale copy of the image, scaled down to pigoMaxSide,
+// and returns the faces it found
+func (p *pigoDetector) Detect(ctx context.Context, img image.Image) ([]image.Rectangle, error) {
+	reqLogger := logger.FromContext(ctx).With().Str("component", "faces-pigo").Logger()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	scale := 1.0
+	src := img
+	if longest := max(bounds.Dx(), bounds.Dy()); longest > pigoMaxSide {
+		scale = float64(longest) / pigoMaxSide
+		src = imaging.Resize(img, int(float64(bounds.Dx())/scale), int(float64(bounds.Dy())/scale), imaging.Linear)
+	}
+
+	srcBounds := src.Bounds()
+	gray := image.NewGray(image.Rect(0, 0, srcBounds.Dx(), srcBounds.Dy()))
+	draw.Draw(gray, gray.Rect, src, srcBounds.Min, draw.Src)
+
+	params := pigo.CascadeParams{
+		MinSize:     pigoMinFaceSize,
+		MaxSize:     min(gray.Rect.Dx(), gray.Rect.Dy()),
+		ShiftFactor: 0.1,
+		ScaleFactor: 1.1,
+		ImageParams: pigo.ImageParams{
+			Pixels: gray.Pix,
+			Rows:   gray.Rect.Dy(),
+			Cols:   gray.Rect.Dx(),
+			Dim:    gray.Stride,
+		},
+	}
+	detections := p.classifier.ClusterDetections(p.classifier.RunCascade(params, 0), pigoIoUThreshold)
+
+	// Scale back to the coordinate space of img, whose bounds may not start at the origin
+	rects := make([]image.Rectangle, 0, len(detections))
+	for _, d := range detections {
+		if d.Q < pigoMinScore {
+			continue
+		}
+		half := float64(d.Scale) / 2
+		rect := image.Rect(
+			int((float64(d.Col)-half)*scale),
+			int((float64(d.Row)-half)*scale),
+			int((float64(d.Col)+half)*scale),
+			int((float64(d.Row)+half)*scale),
+		)
+		rects = append(rects, rect.Add(bounds.Min).Intersect(bounds))
+	}
+
+	reqLogger.Debug().Int("faces", len(rects)).Msg("Faces detected")
+	return rects, nil
+}
@@ -0,0 +1,48 @@
+//go:build !faults
+
+package faults
+
+import (
+	"net/http"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
+)
+
+// Available reports whether the binary was built with fault injection
+const Available = false
+
+// Injector does nothing in binaries built without the faults build tag
+type Injector struct{}
+
+// New returns an injector that injects nothing, warning about faults configured anyway
+func New(cfg *config.FaultsConfig) *Injector {
+	if cfg.Storage != (config.FaultRule{}) || cfg.Database != (config.FaultRule{}) || cfg.Queue != (config.FaultRule{}) {
+		initLogger := logger.GetLogger("faults")
+		initLogger.Warn().Msg("FAULTS_* settings are ignored: the binary was built without the faults build tag")
+	}
+	return &Injector{}
+}
+
+// Handler is not found without fault injection
+func (i *Injector) Handler() http.Handler {
+	return http.NotFoundHandler()
+}
+
+// WrapStorage returns next unchanged
+func WrapStorage(next minio.Client, _ *Injector) minio.Client {
+	return next
+}
+
+// WrapQueue returns next unchanged
+func WrapQueue(next rabbitmq.Client, _ *Injector) rabbitmq.Client {
+	return next
+}
+
+// WrapRepository returns next unchanged
+func WrapRepository(next db.Repository, _ *Injector) db.Repository {
+	return next
+}
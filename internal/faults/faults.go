@@ -0,0 +1,19 @@
+// Package faults injects errors and latency into the calls to storage, the database and
+// the queue, for resilience testing of the retry, circuit breaker and outbox paths in
+// staging. The injector is only compiled into binaries built with the faults build tag;
+// in other builds New returns an injector whose wrappers return the clients unchanged.
+package faults
+
+import "errors"
+
+// Target is a dependency faults are injected into
+type Target string
+
+const (
+	TargetStorage  Target = "storage"
+	TargetDatabase Target = "database"
+	TargetQueue    Target = "queue"
+)
+
+// ErrInjected is the error of the calls failed by an injected fault
+var ErrInjected = errors.New("injected fault")
@@ -0,0 +1,84 @@
+//go:build faults
+
+package faults
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/config"
+)
+
+// ruleState is the JSON form of the faults injected into a target
+type ruleState struct {
+	ErrorRate float64 `json:"error_rate"`
+	Latency   string  `json:"latency"`
+}
+
+// Handler serves the faults injected at runtime: GET returns them, PUT
+// ?target=&error_rate=&latency= replaces those of a target until the process restarts
+// and DELETE stops injecting faults
+func (i *Injector) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			target, rule, err := parseRule(r)
+			if err != nil {
+				writeJSON(rw, http.StatusBadRequest, map[string]any{"error": err.Error()})
+				return
+			}
+			i.Set(target, rule)
+		case http.MethodDelete:
+			i.Reset()
+		default:
+			rw.Header().Set("Allow", "GET, PUT, DELETE")
+			writeJSON(rw, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+			return
+		}
+
+		state := make(map[Target]ruleState)
+		for target, rule := range i.Rules() {
+			state[target] = ruleState{ErrorRate: rule.ErrorRate, Latency: rule.Latency.String()}
+		}
+		writeJSON(rw, http.StatusOK, state)
+	})
+}
+
+// parseRule reads the target and faults of a PUT; omitted faults are zero
+func parseRule(r *http.Request) (Target, config.FaultRule, error) {
+	query := r.URL.Query()
+
+	target := Target(query.Get("target"))
+	switch target {
+	case TargetStorage, TargetDatabase, TargetQueue:
+	default:
+		return "", config.FaultRule{}, errors.New("target must be one of storage, database or queue")
+	}
+
+	var rule config.FaultRule
+	if value := query.Get("error_rate"); value != "" {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return "", config.FaultRule{}, errors.New("error_rate must be between 0 and 1")
+		}
+		rule.ErrorRate = rate
+	}
+	if value := query.Get("latency"); value != "" {
+		latency, err := time.ParseDuration(value)
+		if err != nil || latency < 0 {
+			return "", config.FaultRule{}, errors.New("latency must be a non-negative duration such as 250ms")
+		}
+		rule.Latency = latency
+	}
+	return target, rule, nil
+}
+
+func writeJSON(rw http.ResponseWriter, status int, body any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	json.NewEncoder(rw).Encode(body)
+}
@@ -0,0 +1,111 @@
+//go:build faults
+
+package faults
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog"
+)
+
+// Available reports whether the binary was built with fault injection
+const Available = true
+
+// injectedTotal counts the calls failed by an injected fault, by target
+var injectedTotal = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "image_optimizer_injected_faults_total",
+		Help: "The total number of calls failed by fault injection",
+	},
+	[]string{"target"},
+)
+
+// Injector holds the faults injected into each target, changeable at runtime
+type Injector struct {
+	mu     sync.RWMutex
+	rules  map[Target]config.FaultRule
+	logger zerolog.Logger
+}
+
+// New creates an injector with the faults of cfg
+func New(cfg *config.FaultsConfig) *Injector {
+	i := &Injector{
+		rules: map[Target]config.FaultRule{
+			TargetStorage:  cfg.Storage,
+			TargetDatabase: cfg.Database,
+			TargetQueue:    cfg.Queue,
+		},
+		logger: logger.GetLogger("faults"),
+	}
+	i.logger.Warn().Interface("rules", i.Rules()).Msg("Fault injection available")
+	return i
+}
+
+// Set replaces the faults injected into target
+func (i *Injector) Set(target Target, rule config.FaultRule) {
+	i.mu.Lock()
+	i.rules[target] = rule
+	i.mu.Unlock()
+
+	i.logger.Warn().
+		Str("target", string(target)).
+		Float64("error_rate", rule.ErrorRate).
+		Dur("latency", rule.Latency).
+		Msg("Fault injection changed")
+}
+
+// Reset stops injecting faults into every target
+func (i *Injector) Reset() {
+	i.mu.Lock()
+	for target := range i.rules {
+		i.rules[target] = config.FaultRule{}
+	}
+	i.mu.Unlock()
+
+	i.logger.Warn().Msg("Fault injection reset")
+}
+
+// Rules returns the faults injected into each target
+func (i *Injector) Rules() map[Target]config.FaultRule {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	rules := make(map[Target]config.FaultRule, len(i.rules))
+	for target, rule := range i.rules {
+		rules[target] = rule
+	}
+	return rules
+}
+
+// inject delays a call to target by the configured latency, then fails it at the
+// configured error rate
+func (i *Injector) inject(ctx context.Context, target Target) error {
+	i.mu.RLock()
+	rule := i.rules[target]
+	i.mu.RUnlock()
+
+	if rule.Latency > 0 {
+		timer := time.NewTimer(rule.Latency)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	if rule.ErrorRate > 0 && rand.Float64() < rule.ErrorRate {
+		injectedTotal.WithLabelValues(string(target)).Inc()
+		return fmt.Errorf("%w into %s", ErrInjected, target)
+	}
+	return nil
+}
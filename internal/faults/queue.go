@@ -0,0 +1,40 @@
+//go:build faults
+
+package faults
+
+import (
+	"context"
+
+	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
+)
+
+// queueClient injects faults into the tasks published to and consumed from another
+// rabbitmq.Client
+type queueClient struct {
+	rabbitmq.Client
+
+	injector *Injector
+}
+
+// WrapQueue injects the queue faults of injector into the calls to next
+func WrapQueue(next rabbitmq.Client, injector *Injector) rabbitmq.Client {
+	return &queueClient{Client: next, injector: injector}
+}
+
+func (c *queueClient) Publish(ctx context.Context, task rabbitmq.Task) error {
+	if err := c.injector.inject(ctx, TargetQueue); err != nil {
+		return err
+	}
+	return c.Client.Publish(ctx, task)
+}
+
+// Consume fails deliveries before processFunc sees them, so they take the same
+// retry and dead-letter path as a failed task
+func (c *queueClient) Consume(ctx context.Context, processFunc rabbitmq.ProcessFunc) error {
+	return c.Client.Consume(ctx, func(ctx context.Context, task rabbitmq.Task) error {
+		if err := c.injector.inject(ctx, TargetQueue); err != nil {
+			return err
+		}
+		return processFunc(ctx, task)
+	})
+}
@@ -0,0 +1,123 @@
+//go:build faults
+
+package faults
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+)
+
+// repository injects faults into the calls to another db.Repository on the upload,
+// processing and outbox paths. The other calls pass through.
+type repository struct {
+	db.Repository
+
+	injector *Injector
+}
+
+// WrapRepository injects the database faults of injector into the calls to next
+func WrapRepository(next db.Repository, injector *Injector) db.Repository {
+	return &repository{Repository: next, injector: injector}
+}
+
+func (r *repository) GetImageByID(ctx context.Context, id uuid.UUID) (*models.Image, error) {
+	if err := r.injector.inject(ctx, TargetDatabase); err != nil {
+		return nil, err
+	}
+	return r.Repository.GetImageByID(ctx, id)
+}
+
+func (r *repository) CreateImage(ctx context.Context, image *models.Image) error {
+	if err := r.injector.inject(ctx, TargetDatabase); err != nil {
+		return err
+	}
+	return r.Repository.CreateImage(ctx, image)
+}
+
+func (r *repository) UpdateImageStatus(ctx context.Context, id uuid.UUID, status models.ProcessingStatus, errorMsg string) error {
+	if err := r.injector.inject(ctx, TargetDatabase); err != nil {
+		return err
+	}
+	return r.Repository.UpdateImageStatus(ctx, id, status, errorMsg)
+}
+
+func (r *repository) UpdateLeasedImageStatus(ctx context.Context, id uuid.UUID, workerID string, status models.ProcessingStatus, errorMsg string) error {
+	if err := r.injector.inject(ctx, TargetDatabase); err != nil {
+		return err
+	}
+	return r.Repository.UpdateLeasedImageStatus(ctx, id, workerID, status, errorMsg)
+}
+
+func (r *repository) StartImageProcessing(ctx context.Context, id uuid.UUID, taskID, workerID string, lease time.Duration) (string, error) {
+	if err := r.injector.inject(ctx, TargetDatabase); err != nil {
+		return "", err
+	}
+	return r.Repository.StartImageProcessing(ctx, id, taskID, workerID, lease)
+}
+
+func (r *repository) UpdateImageProgress(ctx context.Context, id uuid.UUID, percent int, stage string) error {
+	if err := r.injector.inject(ctx, TargetDatabase); err != nil {
+		return err
+	}
+	return r.Repository.UpdateImageProgress(ctx, id, percent, stage)
+}
+
+func (r *repository) UpdateImageOptimized(ctx context.Context, id uuid.UUID, workerID string, path string, size int64, width, height int) error {
+	if err := r.injector.inject(ctx, TargetDatabase); err != nil {
+		return err
+	}
+	return r.Repository.UpdateImageOptimized(ctx, id, workerID, path, size, width, height)
+}
+
+func (r *repository) NextImageVersion(ctx context.Context, id uuid.UUID) (int, error) {
+	if err := r.injector.inject(ctx, TargetDatabase); err != nil {
+		return 0, err
+	}
+	return r.Repository.NextImageVersion(ctx, id)
+}
+
+func (r *repository) CreateImageVersion(ctx context.Context, version *models.ImageVersion) error {
+	if err := r.injector.inject(ctx, TargetDatabase); err != nil {
+		return err
+	}
+	return r.Repository.CreateImageVersion(ctx, version)
+}
+
+func (r *repository) SaveOutboxTask(ctx context.Context, task *models.OutboxTask) error {
+	if err := r.injector.inject(ctx, TargetDatabase); err != nil {
+		return err
+	}
+	return r.Repository.SaveOutboxTask(ctx, task)
+}
+
+func (r *repository) ClaimOutboxTasks(ctx context.Context, limit int, lease time.Duration) ([]*models.OutboxTask, error) {
+	if err := r.injector.inject(ctx, TargetDatabase); err != nil {
+		return nil, err
+	}
+	return r.Repository.ClaimOutboxTasks(ctx, limit, lease)
+}
+
+func (r *repository) BeginTask(ctx context.Context, task *models.TaskRecord) error {
+	if err := r.injector.inject(ctx, TargetDatabase); err != nil {
+		return err
+	}
+	return r.Repository.BeginTask(ctx, task)
+}
+
+func (r *repository) FinishTask(ctx context.Context, task *models.TaskRecord, status models.TaskStatus) error {
+	if err := r.injector.inject(ctx, TargetDatabase); err != nil {
+		return err
+	}
+	return r.Repository.FinishTask(ctx, task, status)
+}
+
+func (r *repository) AbandonTask(ctx context.Context, task *models.TaskRecord) error {
+	if err := r.injector.inject(ctx, TargetDatabase); err != nil {
+		return err
+	}
+	return r.Repository.AbandonTask(ctx, task)
+}
@@ -0,0 +1,84 @@
+//go:build faults
+
+package faults
+
+import (
+	"context"
+	"io"
+
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+)
+
+// storageClient injects faults into the calls to another minio.Client. Presigned URLs,
+// bucket notifications and pings pass through.
+type storageClient struct {
+	minio.Client
+
+	injector *Injector
+}
+
+// WrapStorage injects the storage faults of injector into the calls to next
+func WrapStorage(next minio.Client, injector *Injector) minio.Client {
+	return &storageClient{Client: next, injector: injector}
+}
+
+func (c *storageClient) UploadImage(ctx context.Context, reader io.Reader, objectName string, contentType string) error {
+	if err := c.injector.inject(ctx, TargetStorage); err != nil {
+		return err
+	}
+	return c.Client.UploadImage(ctx, reader, objectName, contentType)
+}
+
+func (c *storageClient) UploadOriginal(ctx context.Context, reader io.Reader, objectName string, contentType string) error {
+	if err := c.injector.inject(ctx, TargetStorage); err != nil {
+		return err
+	}
+	return c.Client.UploadOriginal(ctx, reader, objectName, contentType)
+}
+
+func (c *storageClient) GetImage(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	if err := c.injector.inject(ctx, TargetStorage); err != nil {
+		return nil, err
+	}
+	return c.Client.GetImage(ctx, objectName)
+}
+
+func (c *storageClient) StatImage(ctx context.Context, objectName string) (*minio.ObjectInfo, error) {
+	if err := c.injector.inject(ctx, TargetStorage); err != nil {
+		return nil, err
+	}
+	return c.Client.StatImage(ctx, objectName)
+}
+
+func (c *storageClient) DeleteImage(ctx context.Context, objectName string) error {
+	if err := c.injector.inject(ctx, TargetStorage); err != nil {
+		return err
+	}
+	return c.Client.DeleteImage(ctx, objectName)
+}
+
+func (c *storageClient) CopyObject(ctx context.Context, src, dst string) error {
+	if err := c.injector.inject(ctx, TargetStorage); err != nil {
+		return err
+	}
+	return c.Client.CopyObject(ctx, src, dst)
+}
+
+func (c *storageClient) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	if err := c.injector.inject(ctx, TargetStorage); err != nil {
+		return nil, err
+	}
+	return c.Client.ListObjects(ctx, prefix)
+}
+
+func (c *storageClient) Lifecycle(ctx context.Context) ([]minio.LifecycleRule, error) {
+	if err := c.injector.inject(ctx, TargetStorage); err != nil {
+		return nil, err
+	}
+	return c.Client.Lifecycle(ctx)
+}
+
+// In returns the client of class with the same injector
+func (c *storageClient) In(class minio.Class) minio.Client {
+	return &storageClient{Client: c.Client.In(class), injector: c.injector}
+}
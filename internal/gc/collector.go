@@ -0,0 +1,261 @@
+// Package gc reconciles the bucket with the database: objects no image or version uses are
+// orphaned, and images whose original is missing are dangling. Both are reported and, in
+// delete mode, removed.
+package gc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/deletion"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+	"github.com/rs/zerolog"
+)
+
+// Modes
+const (
+	ModeReport = "report"
+	ModeDelete = "delete"
+)
+
+// referenceBatchSize is how many object names are checked against the database per query
+const referenceBatchSize = 500
+
+// ErrRunning is returned when a pass is started while another one is running
+var ErrRunning = errors.New("garbage collection already running")
+
+// Report lists what a pass found and removed
+type Report struct {
+	Mode            string      `json:"mode"`
+	ScannedObjects  int         `json:"scanned_objects"`
+	OrphanedObjects []string    `json:"orphaned_objects"`
+	DanglingImages  []uuid.UUID `json:"dangling_images"`
+	DeletedObjects  int         `json:"deleted_objects"`
+	DeletedImages   int         `json:"deleted_images"`
+}
+
+// Collector finds and removes orphaned objects and dangling image records
+type Collector struct {
+	repo        db.Repository
+	minioClient minio.Client
+	purger      *deletion.Purger
+	config      *config.GCConfig
+	// skipPrefixes hold scratch objects, such as the ingestion drop prefix, that are
+	// never referenced by images
+	skipPrefixes []string
+	// expirePrefixes are expired by lifecycle rules, so originals missing under them were
+	// removed on purpose
+	expirePrefixes []string
+	running        sync.Mutex
+	logger         zerolog.Logger
+}
+
+// NewCollector creates a new Collector. Dangling images are removed through purger, so
+// their remaining objects go with them.
+func NewCollector(repo db.Repository, minioClient minio.Client, purger *deletion.Purger, cfg *config.GCConfig, ingest *config.IngestConfig, lifecycle *config.LifecycleConfig) *Collector {
+	var skip []string
+	for _, prefix := range []string{ingest.BucketPrefix, ingest.BucketFailedPrefix} {
+		if prefix != "" {
+			skip = append(skip, prefix)
+		}
+	}
+
+	expire := make([]string, 0, len(lifecycle.ExpirePrefixes))
+	for prefix := range lifecycle.ExpirePrefixes {
+		expire = append(expire, prefix)
+	}
+
+	return &Collector{
+		repo:           repo,
+		minioClient:    minioClient,
+		purger:         purger,
+		config:         cfg,
+		skipPrefixes:   skip,
+		expirePrefixes: expire,
+		logger:         logger.GetLogger("gc"),
+	}
+}
+
+// Run runs a pass every interval until ctx is cancelled
+func (c *Collector) Run(ctx context.Context) {
+	c.logger.Info().Str("mode", c.config.Mode).Dur("interval", c.config.Interval).Msg("Starting garbage collector")
+
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			c.logger.Info().Msg("Garbage collector stopped")
+			return
+		case <-ticker.C:
+			if _, err := c.Collect(logger.ToContext(ctx, c.logger), c.config.Mode); err != nil && !errors.Is(err, ErrRunning) {
+				c.logger.Error().Err(err).Msg("Garbage collection failed")
+			}
+		}
+	}
+}
+
+// Collect runs one pass in mode. Nothing is deleted unless both the bucket and the
+// images could be listed completely.
+func (c *Collector) Collect(ctx context.Context, mode string) (*Report, error) {
+	if !c.running.TryLock() {
+		return nil, ErrRunning
+	}
+	defer c.running.Unlock()
+
+	reqLogger := logger.FromContext(ctx)
+	report := &Report{Mode: mode, OrphanedObjects: []string{}, DanglingImages: []uuid.UUID{}}
+	cutoff := time.Now().Add(-c.config.MinAge)
+
+	// Objects of both classes, by name; classes sharing a bucket list the same objects
+	objects := make(map[string]minio.Class)
+	originals := make(map[string]bool)
+	for _, class := range []minio.Class{minio.ClassOriginal, minio.ClassOptimized} {
+		names, err := c.minioClient.In(class).ListObjects(ctx, "")
+		if err != nil {
+			return nil, fmt.Errorf("error listing %s objects: %w", class, err)
+		}
+		for _, name := range names {
+			if c.skipped(name) {
+				continue
+			}
+			if _, ok := objects[name]; !ok {
+				objects[name] = class
+			}
+			if class == minio.ClassOriginal {
+				originals[name] = true
+			}
+		}
+	}
+	report.ScannedObjects = len(objects)
+
+	orphaned, err := c.orphanedObjects(ctx, objects, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	report.OrphanedObjects = orphaned
+
+	err = c.repo.IterateImages(ctx, models.ImageFilter{AllOwners: true}, referenceBatchSize, func(img *models.Image) error {
+		if img.OriginalPath != "" && !originals[img.OriginalPath] && img.CreatedAt.Before(cutoff) && !c.removedOnPurpose(img) {
+			report.DanglingImages = append(report.DanglingImages, img.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing images: %w", err)
+	}
+
+	metrics.GCOrphanedObjects.Set(float64(len(report.OrphanedObjects)))
+	metrics.GCDanglingImages.Set(float64(len(report.DanglingImages)))
+
+	if mode == ModeDelete {
+		c.remove(ctx, report, objects)
+	}
+
+	reqLogger.Info().
+		Str("mode", mode).
+		Int("scanned_objects", report.ScannedObjects).
+		Int("orphaned_objects", len(report.OrphanedObjects)).
+		Int("dangling_images", len(report.DanglingImages)).
+		Int("deleted_objects", report.DeletedObjects).
+		Int("deleted_images", report.DeletedImages).
+		Msg("Garbage collection finished")
+
+	return report, nil
+}
+
+// orphanedObjects returns the objects older than cutoff that no image or version uses
+func (c *Collector) orphanedObjects(ctx context.Context, objects map[string]minio.Class, cutoff time.Time) ([]string, error) {
+	names := make([]string, 0, len(objects))
+	for name := range objects {
+		names = append(names, name)
+	}
+
+	orphaned := []string{}
+	for start := 0; start < len(names); start += referenceBatchSize {
+		end := min(start+referenceBatchSize, len(names))
+		unreferenced, err := c.repo.UnreferencedObjects(ctx, names[start:end])
+		if err != nil {
+			return nil, err
+		}
+
+		// Recent objects may belong to an upload whose record isn't written yet
+		for _, name := range unreferenced {
+			info, err := c.minioClient.In(objects[name]).StatImage(ctx, name)
+			if err != nil {
+				if errors.Is(err, minio.ErrObjectNotFound) {
+					continue
+				}
+				return nil, err
+			}
+			if info.LastModified.Before(cutoff) {
+				orphaned = append(orphaned, name)
+			}
+		}
+	}
+	return orphaned, nil
+}
+
+// remove deletes the orphaned objects and purges the dangling images of report
+func (c *Collector) remove(ctx context.Context, report *Report, objects map[string]minio.Class) {
+	reqLogger := logger.FromContext(ctx)
+
+	for _, name := range report.OrphanedObjects {
+		if err := c.minioClient.In(objects[name]).DeleteImage(ctx, name); err != nil {
+			reqLogger.Error().Err(err).Str("object", name).Msg("Failed to delete orphaned object")
+			continue
+		}
+		report.DeletedObjects++
+		metrics.GCDeletedTotal.WithLabelValues("object").Inc()
+	}
+
+	for _, id := range report.DanglingImages {
+		img, err := c.repo.GetImageByID(ctx, id)
+		if err != nil {
+			reqLogger.Error().Err(err).Str("image_id", id.String()).Msg("Failed to get dangling image")
+			continue
+		}
+		if err := c.purger.Purge(ctx, img); err != nil {
+			reqLogger.Error().Err(err).Str("image_id", id.String()).Msg("Failed to purge dangling image")
+			continue
+		}
+		report.DeletedImages++
+		metrics.GCDeletedTotal.WithLabelValues("image").Inc()
+	}
+}
+
+// removedOnPurpose reports whether the original of img is missing because it was meant to
+// go: rejected images have their original deleted, and lifecycle rules expire originals
+// under some prefixes, such as those never promoted out of the upload quarantine
+func (c *Collector) removedOnPurpose(img *models.Image) bool {
+	if img.Status == models.StatusRejected || img.ModerationStatus == models.ModerationRejected {
+		return true
+	}
+	for _, prefix := range c.expirePrefixes {
+		if strings.HasPrefix(img.OriginalPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipped reports whether name is under a scratch prefix
+func (c *Collector) skipped(name string) bool {
+	for _, prefix := range c.skipPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
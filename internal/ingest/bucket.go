@@ -0,0 +1,172 @@
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+	"github.com/rs/zerolog"
+)
+
+// listenRetryDelay is how long BucketListener waits before listening again after an error
+const listenRetryDelay = 5 * time.Second
+
+// BucketListener ingests objects written directly to a drop prefix of the bucket. It
+// listens for s3:ObjectCreated:* notifications; objects that arrived while it was not
+// listening are picked up by listing the prefix on start and after every reconnect.
+//
+// Ingested objects are copied to the image layout and removed from the drop prefix.
+// Invalid images are moved to the failed prefix.
+type BucketListener struct {
+	ingester    *Ingester
+	minioClient minio.Client
+	config      *config.IngestConfig
+	logger      zerolog.Logger
+}
+
+// NewBucketListener creates a new BucketListener
+func NewBucketListener(ingester *Ingester, minioClient minio.Client, cfg *config.IngestConfig) *BucketListener {
+	// Objects are dropped into the bucket of temporary objects
+	return &BucketListener{
+		ingester:    ingester,
+		minioClient: minioClient.In(minio.ClassTemp),
+		config:      cfg,
+		logger:      logger.GetLogger("ingest-bucket"),
+	}
+}
+
+// Run listens for new objects until ctx is cancelled
+func (l *BucketListener) Run(ctx context.Context) {
+	l.logger.Info().Str("prefix", l.config.BucketPrefix).Msg("Listening for objects in drop prefix")
+
+	for ctx.Err() == nil {
+		// Start listening before listing, so no object falls between the two
+		listenCtx, cancel := context.WithCancel(ctx)
+		events := l.minioClient.ListenObjectCreated(listenCtx, l.config.BucketPrefix)
+		l.ingestExisting(ctx)
+
+		for event := range events {
+			if event.Err != nil {
+				l.logger.Error().Err(event.Err).Msg("Bucket notifications interrupted")
+				break
+			}
+			l.ingestObject(ctx, event.Key)
+		}
+		cancel()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(listenRetryDelay):
+		}
+	}
+}
+
+// ingestExisting ingests the objects already in the drop prefix
+func (l *BucketListener) ingestExisting(ctx context.Context) {
+	keys, err := l.minioClient.ListObjects(ctx, l.config.BucketPrefix)
+	if err != nil {
+		l.logger.Error().Err(err).Msg("Failed to list drop prefix")
+		return
+	}
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			return
+		}
+		l.ingestObject(ctx, key)
+	}
+}
+
+// ingestObject ingests a single object of the drop prefix
+func (l *BucketListener) ingestObject(ctx context.Context, key string) {
+	// Skip folder markers
+	if strings.HasSuffix(key, "/") {
+		return
+	}
+
+	reqLogger := l.logger.With().Str("object", key).Logger()
+	ctx = logger.ToContext(ctx, reqLogger)
+
+	data, err := l.read(ctx, key)
+	if errors.Is(err, minio.ErrObjectNotFound) {
+		// Already ingested, e.g. found by listing and then notified
+		return
+	}
+	if errors.Is(err, errTooLarge) {
+		// Too large to read, so it is moved on the server
+		reqLogger.Warn().Err(err).Msg("Rejected invalid image")
+		if err := l.minioClient.CopyObject(ctx, key, l.failedKey(key)); err != nil {
+			reqLogger.Error().Err(err).Msg("Failed to move invalid object to failed prefix")
+			return
+		}
+		if err := l.minioClient.DeleteImage(ctx, key); err != nil {
+			reqLogger.Error().Err(err).Msg("Failed to remove object from drop prefix")
+		}
+		return
+	}
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to read object")
+		return
+	}
+
+	img, err := l.ingester.Ingest(ctx, bytes.NewReader(data), path.Base(key))
+	switch {
+	case errors.Is(err, ErrInvalidImage):
+		reqLogger.Warn().Err(err).Msg("Rejected invalid image")
+		if err := l.minioClient.UploadImage(ctx, bytes.NewReader(data), l.failedKey(key), "application/octet-stream"); err != nil {
+			reqLogger.Error().Err(err).Msg("Failed to move invalid object to failed prefix")
+			return
+		}
+	case err != nil:
+		reqLogger.Error().Err(err).Msg("Failed to ingest object, will retry on next listing")
+		return
+	default:
+		reqLogger.Info().Str("image_id", img.ID.String()).Msg("Object ingested")
+	}
+
+	if err := l.minioClient.DeleteImage(ctx, key); err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to remove object from drop prefix")
+	}
+}
+
+// failedKey returns the key an invalid object of the drop prefix is moved to
+func (l *BucketListener) failedKey(key string) string {
+	return l.config.BucketFailedPrefix + strings.TrimPrefix(key, l.config.BucketPrefix)
+}
+
+// read downloads an object into memory. Objects larger than the ingester accepts fail
+// with errTooLarge without being read.
+func (l *BucketListener) read(ctx context.Context, key string) ([]byte, error) {
+	// GetObject is lazy, so check that the object still exists first
+	info, err := l.minioClient.StatImage(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	maxBytes := l.ingester.maxBytes
+	if info.Size > maxBytes {
+		return nil, errTooLarge
+	}
+
+	reader, err := l.minioClient.GetImage(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// The object may have been overwritten since it was stat'ed
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(io.LimitReader(reader, maxBytes+1)); err != nil {
+		return nil, fmt.Errorf("error reading object: %w", err)
+	}
+	if int64(buf.Len()) > maxBytes {
+		return nil, errTooLarge
+	}
+	return buf.Bytes(), nil
+}
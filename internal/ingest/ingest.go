@@ -0,0 +1,177 @@
+// Package ingest registers images that arrive outside the REST API and queues them for
+// processing, the same way an upload does.
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+	"github.com/not-nullexception/image-optimizer/internal/outbox"
+	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
+	"github.com/not-nullexception/image-optimizer/internal/scan"
+	"github.com/not-nullexception/image-optimizer/pkg/optimizer"
+)
+
+// ErrInvalidImage is returned by Ingest when the file is not a supported image, or when
+// the malware scanner finds it infected
+var ErrInvalidImage = errors.New("invalid image")
+
+// errTooLarge is returned for images larger than the request bodies the API accepts
+var errTooLarge = errors.New("image exceeds SERVER_MAX_BODY_BYTES")
+
+// Ingester stores images, creates their records and queues them for optimization with
+// the configured processing defaults
+type Ingester struct {
+	repo        db.Repository
+	minioClient minio.Client
+	outbox      *outbox.Relay
+	scanner     scan.Scanner
+	processing  *config.ProcessingConfig
+	scanConfig  *config.ScanConfig
+	// maxBytes is the size of the largest image ingested, the same as for uploads
+	maxBytes int64
+}
+
+// NewIngester creates a new Ingester, which rejects images larger than maxBytes
+func NewIngester(repo db.Repository, minioClient minio.Client, relay *outbox.Relay, processing *config.ProcessingConfig, scanConfig *config.ScanConfig, maxBytes int64) *Ingester {
+	return &Ingester{
+		repo:        repo,
+		minioClient: minioClient,
+		outbox:      relay,
+		scanner:     scan.NewScanner(scanConfig),
+		processing:  processing,
+		scanConfig:  scanConfig,
+		maxBytes:    maxBytes,
+	}
+}
+
+// Ingest validates the image read from file, uploads it as the original of a new image
+// and queues it for processing
+func (i *Ingester) Ingest(ctx context.Context, file io.ReadSeeker, filename string) (*models.Image, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	fileSize, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, fmt.Errorf("error seeking image: %w", err)
+	}
+	if fileSize > i.maxBytes {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImage, errTooLarge)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error rewinding image: %w", err)
+	}
+
+	width, height, size, format, err := optimizer.Validate(file)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidImage, err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("error rewinding image: %w", err)
+	}
+
+	if err := i.scan(ctx, file); err != nil {
+		return nil, err
+	}
+
+	imageID := uuid.New()
+	objectName, err := i.minioClient.GenerateObjectName(imageID, filename, file)
+	if err != nil {
+		return nil, err
+	}
+	checksum, err := minio.Checksum(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := minio.StoreOriginal(ctx, i.minioClient.In(minio.ClassOriginal), file, objectName, "image/"+format); err != nil {
+		return nil, fmt.Errorf("error uploading image: %w", err)
+	}
+
+	img := models.NewImageWithID(imageID, filename, size, width, height, format, objectName)
+	img.OriginalChecksum = checksum
+	if err := i.repo.CreateImage(ctx, img); err != nil {
+		if cleanupErr := minio.Release(context.Background(), i.minioClient.In(minio.ClassOriginal), i.repo, objectName, imageID); cleanupErr != nil {
+			reqLogger.Error().Err(cleanupErr).Str("object_name", objectName).Msg("Failed to cleanup MinIO object after DB error")
+		}
+		return nil, fmt.Errorf("error creating image record: %w", err)
+	}
+
+	if err := i.enqueue(ctx, img); err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// scan checks file for malware and rewinds it. Infected files are invalid images, so they
+// end up with the other rejected files; a scanner failure is transient unless the scanner
+// fails open.
+func (i *Ingester) scan(ctx context.Context, file io.ReadSeeker) error {
+	if i.scanner == nil {
+		return nil
+	}
+
+	reqLogger := logger.FromContext(ctx)
+
+	result, err := i.scanner.Scan(ctx, file)
+	if _, seekErr := file.Seek(0, io.SeekStart); seekErr != nil {
+		return fmt.Errorf("error rewinding image: %w", seekErr)
+	}
+	switch {
+	case err != nil:
+		metrics.MalwareScansTotal.WithLabelValues("error").Inc()
+		if !i.scanConfig.FailOpen {
+			return fmt.Errorf("error scanning image for malware: %w", err)
+		}
+		reqLogger.Warn().Err(err).Msg("Failed to scan image for malware, ingesting it unscanned")
+	case result.Infected:
+		metrics.MalwareScansTotal.WithLabelValues("infected").Inc()
+		return fmt.Errorf("%w: malware detected: %s", ErrInvalidImage, result.Signature)
+	default:
+		metrics.MalwareScansTotal.WithLabelValues("clean").Inc()
+	}
+
+	return nil
+}
+
+// enqueue publishes the resize task for img through the outbox and updates its status
+// if the queue is unavailable
+func (i *Ingester) enqueue(ctx context.Context, img *models.Image) error {
+	task := rabbitmq.NewImageTask(rabbitmq.TaskTypeResizeImage, rabbitmq.ImagePayload{
+		ImageID:      img.ID.String(),
+		OriginalPath: img.OriginalPath,
+		Filename:     img.OriginalName,
+		Config: &rabbitmq.ResizeConfig{
+			MaxWidth:        i.processing.DefaultMaxWidth,
+			MaxHeight:       i.processing.DefaultMaxHeight,
+			Quality:         i.processing.QualityFor(img.OriginalFormat),
+			OptimizeStorage: i.processing.DefaultOptimizeStorage,
+		},
+	})
+
+	reqLogger := logger.FromContext(ctx)
+
+	published, err := i.outbox.Publish(ctx, img.ID, task)
+	if err != nil {
+		if updateErr := i.repo.UpdateImageStatus(ctx, img.ID, models.StatusFailed, "processing queue unavailable"); updateErr != nil {
+			reqLogger.Error().Err(updateErr).Str("image_id", img.ID.String()).Msg("Failed to mark unqueued image as failed")
+		}
+		return fmt.Errorf("error queueing image: %w", err)
+	}
+	if !published {
+		img.Status = models.StatusQueueFailed
+		if err := i.repo.UpdateImageStatus(ctx, img.ID, img.Status, "processing queue unavailable, task will be retried"); err != nil {
+			reqLogger.Error().Err(err).Str("image_id", img.ID.String()).Msg("Failed to mark image as queued_failed")
+		}
+	}
+
+	return nil
+}
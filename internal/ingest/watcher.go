@@ -0,0 +1,270 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/rs/zerolog"
+)
+
+// Watcher ingests image files dropped into a directory. Ingested files are moved to the
+// processed directory and invalid images to the failed directory. Files that could not be
+// ingested because storage, database or queue were unavailable stay where they are and are
+// retried on the next rescan.
+//
+// fsnotify does not see changes made by other NFS clients, so the directory is also
+// rescanned every ScanInterval.
+type Watcher struct {
+	ingester *Ingester
+	config   *config.IngestConfig
+	logger   zerolog.Logger
+
+	// pending maps files to the time they were last written
+	pending map[string]time.Time
+}
+
+// NewWatcher creates a new Watcher
+func NewWatcher(ingester *Ingester, cfg *config.IngestConfig) *Watcher {
+	return &Watcher{
+		ingester: ingester,
+		config:   cfg,
+		logger:   logger.GetLogger("ingest-watcher"),
+		pending:  make(map[string]time.Time),
+	}
+}
+
+// Run watches the directory until ctx is cancelled. Files already in the directory are
+// ingested first.
+func (w *Watcher) Run(ctx context.Context) error {
+	for _, dir := range []string{w.config.ProcessedDir, w.config.FailedDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("error creating %s: %w", dir, err)
+		}
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("error creating file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	if err := w.watch(watcher, w.config.Dir); err != nil {
+		return err
+	}
+	w.scan(w.config.Dir)
+
+	w.logger.Info().
+		Str("dir", w.config.Dir).
+		Bool("recursive", w.config.Recursive).
+		Dur("scan_interval", w.config.ScanInterval).
+		Msg("Watching directory for images")
+
+	settle := time.NewTicker(w.config.SettleDelay)
+	defer settle.Stop()
+	rescan := time.NewTicker(w.config.ScanInterval)
+	defer rescan.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			w.handleEvent(watcher, event)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			// Overflows drop events; the next rescan picks up the missed files
+			w.logger.Warn().Err(err).Msg("File watcher error")
+		case <-rescan.C:
+			w.scan(w.config.Dir)
+		case <-settle.C:
+			w.ingestSettled(ctx)
+		}
+	}
+}
+
+// handleEvent records created and written files, and watches new subdirectories
+func (w *Watcher) handleEvent(watcher *fsnotify.Watcher, event fsnotify.Event) {
+	if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+		return
+	}
+
+	info, err := os.Stat(event.Name)
+	if err != nil {
+		return
+	}
+	if info.IsDir() {
+		if w.config.Recursive && !w.excluded(event.Name) {
+			if err := w.watch(watcher, event.Name); err != nil {
+				w.logger.Error().Err(err).Str("dir", event.Name).Msg("Failed to watch directory")
+			}
+			w.scan(event.Name)
+		}
+		return
+	}
+
+	if acceptedFile(event.Name) {
+		w.pending[event.Name] = time.Now()
+	}
+}
+
+// watch adds dir, and its subdirectories if recursive, to the watcher
+func (w *Watcher) watch(watcher *fsnotify.Watcher, dir string) error {
+	if !w.config.Recursive {
+		if err := watcher.Add(dir); err != nil {
+			return fmt.Errorf("error watching %s: %w", dir, err)
+		}
+		return nil
+	}
+
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		if w.excluded(path) {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(path); err != nil {
+			return fmt.Errorf("error watching %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// scan marks every image file in dir as pending. Files are only ingested once they have
+// not changed for SettleDelay, so files still being copied are left alone.
+func (w *Watcher) scan(dir string) {
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && (!w.config.Recursive || w.excluded(path)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !acceptedFile(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if _, ok := w.pending[path]; !ok {
+			w.pending[path] = info.ModTime()
+		}
+		return nil
+	})
+	if err != nil {
+		w.logger.Error().Err(err).Str("dir", dir).Msg("Failed to scan directory")
+	}
+}
+
+// ingestSettled ingests the pending files that have not been written to for SettleDelay,
+// Concurrency at a time
+func (w *Watcher) ingestSettled(ctx context.Context) {
+	var settled []string
+	for path, modified := range w.pending {
+		if time.Since(modified) >= w.config.SettleDelay {
+			settled = append(settled, path)
+			delete(w.pending, path)
+		}
+	}
+
+	sem := make(chan struct{}, w.config.Concurrency)
+	var wg sync.WaitGroup
+	for _, path := range settled {
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(path string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			w.ingestFile(ctx, path)
+		}(path)
+	}
+	wg.Wait()
+}
+
+// ingestFile ingests a single file and moves it out of the watched directory
+func (w *Watcher) ingestFile(ctx context.Context, path string) {
+	reqLogger := w.logger.With().Str("file", path).Logger()
+	ctx = logger.ToContext(ctx, reqLogger)
+
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to open file")
+		return
+	}
+
+	img, err := w.ingester.Ingest(ctx, file, filepath.Base(path))
+	file.Close()
+
+	target := w.config.ProcessedDir
+	switch {
+	case errors.Is(err, ErrInvalidImage):
+		reqLogger.Warn().Err(err).Msg("Rejected invalid image")
+		target = w.config.FailedDir
+	case err != nil:
+		reqLogger.Error().Err(err).Msg("Failed to ingest file, will retry on next scan")
+		return
+	default:
+		reqLogger.Info().Str("image_id", img.ID.String()).Msg("File ingested")
+	}
+
+	if err := w.move(path, target); err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to move file out of the watched directory")
+	}
+}
+
+// move moves path into dir, keeping its path relative to the watched directory
+func (w *Watcher) move(path, dir string) error {
+	rel, err := filepath.Rel(w.config.Dir, path)
+	if err != nil {
+		return err
+	}
+	dest := filepath.Join(dir, rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	return os.Rename(path, dest)
+}
+
+// excluded reports whether dir is the processed or failed directory
+func (w *Watcher) excluded(dir string) bool {
+	return dir == w.config.ProcessedDir || dir == w.config.FailedDir
+}
+
+// acceptedFile reports whether path is a JPEG or PNG file. Hidden files, which are
+// usually partial copies, are skipped.
+func acceptedFile(path string) bool {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") {
+		return false
+	}
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jpg", ".jpeg", ".png":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,177 @@
+// Package integrity verifies stored objects against the checksums recorded at upload,
+// to detect objects that were corrupted or tampered with.
+package integrity
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+	"github.com/rs/zerolog"
+)
+
+// Verifier verifies the stored objects of images and flags the corrupted ones
+type Verifier struct {
+	repo        db.Repository
+	minioClient minio.Client
+	config      *config.IntegrityConfig
+	logger      zerolog.Logger
+}
+
+// NewVerifier creates a new Verifier
+func NewVerifier(repo db.Repository, minioClient minio.Client, cfg *config.IntegrityConfig) *Verifier {
+	return &Verifier{
+		repo:        repo,
+		minioClient: minioClient,
+		config:      cfg,
+		logger:      logger.GetLogger("integrity"),
+	}
+}
+
+// object is a stored object of an image and its expected checksum, if known
+type object struct {
+	class    minio.Class
+	path     string
+	checksum string
+}
+
+// VerifyImage verifies the original and derived objects of img and records the result.
+// An object is corrupted if it doesn't match the checksum of the image record or, failing
+// that, of its metadata; objects without checksum are skipped. Missing objects are
+// reported apart from corrupted ones, which take precedence. Storage errors are returned
+// without flagging the image.
+func (v *Verifier) VerifyImage(ctx context.Context, img *models.Image) (models.IntegrityStatus, error) {
+	reqLogger := logger.FromContext(ctx).With().Str("image_id", img.ID.String()).Logger()
+
+	objects := []object{{class: minio.ClassOriginal, path: img.OriginalPath, checksum: img.OriginalChecksum}}
+	for _, path := range []string{img.OptimizedPath, img.CutoutPath} {
+		objects = append(objects, object{class: minio.ClassOptimized, path: path})
+	}
+	for _, rendition := range img.Renditions {
+		objects = append(objects, object{class: minio.ClassOptimized, path: rendition.Path})
+	}
+
+	status := models.IntegrityUnverified
+	for _, obj := range objects {
+		if obj.path == "" {
+			continue
+		}
+
+		verified, err := v.verifyObject(ctx, obj)
+		if errors.Is(err, minio.ErrChecksumMismatch) {
+			reqLogger.Warn().Err(err).Str("object", obj.path).Msg("Stored object is corrupted")
+			status = models.IntegrityCorrupted
+			break
+		}
+		if errors.Is(err, minio.ErrObjectNotFound) {
+			reqLogger.Warn().Err(err).Str("object", obj.path).Msg("Stored object is missing")
+			status = models.IntegrityMissing
+			continue
+		}
+		if err != nil {
+			metrics.IntegrityChecksTotal.WithLabelValues("error").Inc()
+			return "", err
+		}
+		if verified && status != models.IntegrityMissing {
+			status = models.IntegrityOK
+		}
+	}
+
+	metrics.IntegrityChecksTotal.WithLabelValues(string(status)).Inc()
+	if err := v.repo.UpdateImageIntegrity(ctx, img.ID, status, time.Now()); err != nil {
+		return "", err
+	}
+	return status, nil
+}
+
+// verifyObject reads obj and compares its checksum. It reports whether the object had a
+// checksum to compare with.
+func (v *Verifier) verifyObject(ctx context.Context, obj object) (bool, error) {
+	storage := v.minioClient.In(obj.class)
+
+	expected := obj.checksum
+	if expected == "" {
+		info, err := storage.StatImage(ctx, obj.path)
+		if err != nil {
+			return false, err
+		}
+		if info.Checksum == "" {
+			return false, nil
+		}
+		expected = info.Checksum
+	}
+
+	reader, err := storage.GetImage(ctx, obj.path)
+	if err != nil {
+		return false, err
+	}
+	defer reader.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, reader); err != nil {
+		return false, fmt.Errorf("error reading object %s: %w", obj.path, err)
+	}
+	if hex.EncodeToString(h.Sum(nil)) != expected {
+		return false, fmt.Errorf("%w: %s", minio.ErrChecksumMismatch, obj.path)
+	}
+	return true, nil
+}
+
+// Run verifies the images that weren't verified within the configured interval, every
+// interval, until ctx is cancelled
+func (v *Verifier) Run(ctx context.Context) {
+	v.logger.Info().Dur("interval", v.config.Interval).Msg("Starting integrity verification")
+
+	ticker := time.NewTicker(v.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			v.logger.Info().Msg("Integrity verification stopped")
+			return
+		case <-ticker.C:
+			v.sweep(logger.ToContext(ctx, v.logger))
+		}
+	}
+}
+
+// sweep verifies every image that is due
+func (v *Verifier) sweep(ctx context.Context) {
+	filter := models.ImageFilter{AllOwners: true, CheckedBefore: time.Now().Add(-v.config.Interval)}
+
+	var checked, corrupted, missing int
+	err := v.repo.IterateImages(ctx, filter, v.config.BatchSize, func(img *models.Image) error {
+		status, err := v.VerifyImage(ctx, img)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			v.logger.Error().Err(err).Str("image_id", img.ID.String()).Msg("Failed to verify image")
+			return nil
+		}
+		checked++
+		switch status {
+		case models.IntegrityCorrupted:
+			corrupted++
+		case models.IntegrityMissing:
+			missing++
+		}
+		return nil
+	})
+	if err != nil && ctx.Err() == nil {
+		v.logger.Error().Err(err).Msg("Failed to list images to verify")
+	}
+
+	v.logger.Info().Int("checked", checked).Int("corrupted", corrupted).Int("missing", missing).Msg("Integrity verification finished")
+}
@@ -3,7 +3,10 @@ package logger
 
 import (
 	"context"
+	"io"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	// Verifique se o path do config está correto para seu projeto
@@ -11,6 +14,7 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log" // Logger global zerolog
 	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 // contextKey define um tipo privado para chaves de contexto para evitar colisões.
@@ -19,23 +23,187 @@ type contextKey string
 // loggerKey é a chave usada para armazenar/recuperar o logger do context.Context.
 const loggerKey = contextKey("logger")
 
+// requestIDKey é a chave usada para armazenar/recuperar o request ID do context.Context.
+const requestIDKey = contextKey("request_id")
+
 // baseLogger fornece uma instância base do logger.
 // Usar log.With().Logger() cria uma instância separada, mais segura para futuras
 // modificações (como hooks) do que usar diretamente log.Logger global.
 var baseLogger = log.With().Logger()
 
+// levelMu protege configuredLevel e levelOverridden.
+var levelMu sync.Mutex
+
+// configuredLevel é o nível definido pela configuração (LOG_LEVEL).
+var configuredLevel = zerolog.InfoLevel
+
+// levelOverridden indica que o nível foi alterado em tempo de execução por OverrideLevel;
+// a alteração prevalece sobre a configuração até o reinício do processo.
+var levelOverridden bool
+
+// LevelState descreve o nível de log em vigor e a sua origem.
+type LevelState struct {
+	Level      string `json:"level"`
+	Configured string `json:"configured"`
+	Overridden bool   `json:"overridden"`
+}
+
 // Setup inicializa as configurações globais do zerolog e reconfigura nosso baseLogger.
-func Setup(cfg *config.LogConfig) {
+// Além de stderr, os logs são escritos em cfg.File e enviados a cfg.LokiURL quando
+// configurados. A função retornada fecha essas saídas e deve ser chamada no encerramento.
+func Setup(cfg *config.LogConfig) func() {
 	zerolog.TimeFieldFormat = time.RFC3339
+	output, closeOutput := setupOutput(cfg)
+	log.Logger = zerolog.New(output).With().Timestamp().Logger()
+
 	level := getLogLevel(cfg.Level)
+	levelMu.Lock()
+	configuredLevel = level
+	levelMu.Unlock()
 	zerolog.SetGlobalLevel(level) // Define o nível globalmente
 
 	// Atualiza nosso baseLogger para refletir as configurações globais atuais
 	// (caso mude o output writer global, por exemplo).
 	baseLogger = log.With().Logger()
 
+	// Amostra os logs de debug e trace, que são os de maior volume no worker.
+	// Todos os loggers derivados de baseLogger herdam o sampler.
+	if sampler := debugSampler(cfg); sampler != nil {
+		baseLogger = baseLogger.Sample(zerolog.LevelSampler{TraceSampler: sampler, DebugSampler: sampler})
+	}
+
 	// Log inicial usa a instância global zerolog.log
-	log.Info().Str("level", level.String()).Msg("Global logger initialized")
+	log.Info().
+		Str("level", level.String()).
+		Int("debug_sample_rate", cfg.DebugSampleRate).
+		Str("file", cfg.File).
+		Bool("loki", cfg.LokiURL != "").
+		Msg("Global logger initialized")
+
+	return closeOutput
+}
+
+// setupOutput monta as saídas de log configuradas e a função que as fecha.
+func setupOutput(cfg *config.LogConfig) (io.Writer, func()) {
+	writers := []io.Writer{os.Stderr}
+	var closers []io.Closer
+
+	if cfg.File != "" {
+		file := &lumberjack.Logger{
+			Filename:   cfg.File,
+			MaxSize:    cfg.FileMaxSizeMB,
+			MaxBackups: cfg.FileMaxBackups,
+			MaxAge:     cfg.FileMaxAgeDays,
+			Compress:   cfg.FileCompress,
+		}
+		writers = append(writers, file)
+		closers = append(closers, file)
+	}
+
+	if cfg.LokiURL != "" {
+		labels := map[string]string{"service": cfg.ServiceName}
+		if hostname, err := os.Hostname(); err == nil {
+			labels["host"] = hostname
+		}
+		loki := newLokiWriter(cfg.LokiURL, labels, cfg.LokiBatchSize, cfg.LokiFlushInterval)
+		writers = append(writers, loki)
+		closers = append(closers, loki)
+	}
+
+	closeOutput := func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}
+	if len(writers) == 1 {
+		return os.Stderr, closeOutput
+	}
+	return zerolog.MultiLevelWriter(writers...), closeOutput
+}
+
+// debugSampler retorna o sampler de debug configurado, ou nil se todos os logs de debug
+// devem ser escritos. Até DebugSampleBurst mensagens por segundo são escritas antes de
+// amostrar 1 a cada DebugSampleRate.
+func debugSampler(cfg *config.LogConfig) zerolog.Sampler {
+	if cfg.DebugSampleRate <= 1 {
+		return nil
+	}
+
+	var sampler zerolog.Sampler = &zerolog.BasicSampler{N: uint32(cfg.DebugSampleRate)}
+	if cfg.DebugSampleBurst > 0 {
+		sampler = &zerolog.BurstSampler{
+			Burst:       uint32(cfg.DebugSampleBurst),
+			Period:      time.Second,
+			NextSampler: sampler,
+		}
+	}
+	return sampler
+}
+
+// SetLevel altera o nível global de log em tempo de execução, sem reconfigurar a saída.
+// É o nível da configuração: enquanto houver um nível definido por OverrideLevel, ele
+// só é registrado e passa a valer após ClearLevelOverride.
+func SetLevel(level string) {
+	parsed := getLogLevel(level)
+
+	levelMu.Lock()
+	defer levelMu.Unlock()
+
+	configuredLevel = parsed
+	if levelOverridden {
+		return
+	}
+	applyLevel(parsed)
+}
+
+// OverrideLevel altera o nível global de log até o reinício do processo, mesmo que a
+// configuração seja recarregada.
+func OverrideLevel(level string) LevelState {
+	parsed := getLogLevel(level)
+
+	levelMu.Lock()
+	defer levelMu.Unlock()
+
+	levelOverridden = true
+	applyLevel(parsed)
+	return levelState()
+}
+
+// ClearLevelOverride desfaz OverrideLevel e volta ao nível da configuração.
+func ClearLevelOverride() LevelState {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+
+	levelOverridden = false
+	applyLevel(configuredLevel)
+	return levelState()
+}
+
+// Level retorna o nível de log em vigor.
+func Level() LevelState {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+
+	return levelState()
+}
+
+// applyLevel define o nível global. Deve ser chamada com levelMu travado.
+func applyLevel(level zerolog.Level) {
+	if level == zerolog.GlobalLevel() {
+		return
+	}
+
+	zerolog.SetGlobalLevel(level)
+	log.Info().Str("level", level.String()).Bool("overridden", levelOverridden).Msg("Log level changed")
+}
+
+// levelState descreve o nível em vigor. Deve ser chamada com levelMu travado.
+func levelState() LevelState {
+	return LevelState{
+		Level:      zerolog.GlobalLevel().String(),
+		Configured: configuredLevel.String(),
+		Overridden: levelOverridden,
+	}
 }
 
 // getLogLevel (Permanece igual)
@@ -70,6 +238,10 @@ func GetLoggerWithContext(ctx context.Context, component string) zerolog.Logger
 	// Começa com o logger base
 	loggerWithComponent := baseLogger.With().Str("component", component).Logger()
 
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		loggerWithComponent = loggerWithComponent.With().Str("request_id", requestID).Logger()
+	}
+
 	span := trace.SpanFromContext(ctx)
 	if spanCtx := span.SpanContext(); spanCtx.IsValid() {
 		// Retorna uma NOVA instância de logger com os IDs adicionados
@@ -82,6 +254,17 @@ func GetLoggerWithContext(ctx context.Context, component string) zerolog.Logger
 	return loggerWithComponent
 }
 
+// WithRequestID anexa o request ID ao context.Context para correlacionar logs entre serviços.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestIDFromContext recupera o request ID do context.Context, ou "" se não houver.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
 // ToContext anexa o logger fornecido ao context.Context.
 func ToContext(ctx context.Context, logger zerolog.Logger) context.Context {
 	return context.WithValue(ctx, loggerKey, logger)
@@ -0,0 +1,160 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lokiPushPath é o endpoint de ingestão da API HTTP do Loki.
+const lokiPushPath = "/loki/api/v1/push"
+
+// lokiMaxBatches limita as linhas pendentes a lokiMaxBatches lotes; com o Loki fora do
+// ar, as linhas além disso são descartadas em vez de acumular memória.
+const lokiMaxBatches = 10
+
+// lokiWriter envia as linhas de log ao Loki em lotes. Write só enfileira a linha, então
+// o log nunca espera pela rede.
+type lokiWriter struct {
+	url       string
+	labels    map[string]string
+	batchSize int
+	client    *http.Client
+
+	mu      sync.Mutex
+	pending [][2]string
+	dropped int
+
+	flush chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// lokiPush é o corpo de uma requisição de push do Loki.
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// newLokiWriter cria um lokiWriter e inicia o envio dos lotes a cada interval.
+func newLokiWriter(baseURL string, labels map[string]string, batchSize int, interval time.Duration) *lokiWriter {
+	w := &lokiWriter{
+		url:       strings.TrimSuffix(baseURL, "/") + lokiPushPath,
+		labels:    labels,
+		batchSize: batchSize,
+		client:    &http.Client{Timeout: 10 * time.Second},
+		flush:     make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go w.run(interval)
+	return w
+}
+
+// Write enfileira uma linha de log. O zerolog reutiliza p, então a linha é copiada.
+func (w *lokiWriter) Write(p []byte) (int, error) {
+	line := string(bytes.TrimRight(p, "\n"))
+	timestamp := strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	w.mu.Lock()
+	if len(w.pending) >= w.batchSize*lokiMaxBatches {
+		w.dropped++
+	} else {
+		w.pending = append(w.pending, [2]string{timestamp, line})
+	}
+	full := len(w.pending) >= w.batchSize
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.flush <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+// Close envia as linhas pendentes e para o envio.
+func (w *lokiWriter) Close() error {
+	close(w.stop)
+	<-w.done
+	return nil
+}
+
+// run envia os lotes a cada interval, ou antes quando um lote enche, até Close.
+func (w *lokiWriter) run(interval time.Duration) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			w.send()
+			return
+		case <-ticker.C:
+			w.send()
+		case <-w.flush:
+			w.send()
+		}
+	}
+}
+
+// send envia as linhas pendentes em lotes de até batchSize. Falhas não podem ser
+// registradas no próprio log, então vão para stderr; o lote que falhou é descartado
+// para não repetir indefinidamente.
+func (w *lokiWriter) send() {
+	w.mu.Lock()
+	pending, dropped := w.pending, w.dropped
+	w.pending, w.dropped = nil, 0
+	w.mu.Unlock()
+
+	if dropped > 0 {
+		fmt.Fprintf(os.Stderr, "loki: dropped %d log lines while the push backlog was full\n", dropped)
+	}
+
+	for len(pending) > 0 {
+		n := min(len(pending), w.batchSize)
+		if err := w.push(pending[:n]); err != nil {
+			fmt.Fprintf(os.Stderr, "loki: failed to push %d log lines: %v\n", n, err)
+		}
+		pending = pending[n:]
+	}
+}
+
+// push envia um lote ao Loki.
+func (w *lokiWriter) push(values [][2]string) error {
+	body, err := json.Marshal(lokiPush{Streams: []lokiStream{{Stream: w.labels, Values: values}}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -2,11 +2,14 @@ package metrics
 
 import (
 	"context"
+	"net/http"
 	"time"
 
 	"github.com/not-nullexception/image-optimizer/internal/logger"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -48,6 +51,17 @@ var (
 		[]string{"status"},
 	)
 
+	// ProcessingStageDuration measures the stages of image processing, to tell storage I/O
+	// from CPU time
+	ProcessingStageDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "image_optimizer_processing_stage_duration_seconds",
+			Help:    "The duration of image processing stages in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 14), // From 5ms to ~40s
+		},
+		[]string{"stage"},
+	)
+
 	// ImageSizeReduction measures the image size reduction percentage
 	ImageSizeReduction = promauto.NewHistogram(
 		prometheus.HistogramOpts{
@@ -57,6 +71,327 @@ var (
 		},
 	)
 
+	// UploadSize measures the size of accepted uploads; its count by format is the
+	// distribution of original formats
+	UploadSize = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "image_optimizer_upload_size_bytes",
+			Help:    "The size of accepted uploads in bytes by original format",
+			Buckets: prometheus.ExponentialBuckets(16*1024, 2, 10), // From 16KB to 8MB
+		},
+		[]string{"format"},
+	)
+
+	// OutputFormatsTotal counts optimized images by original and output format
+	OutputFormatsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_output_formats_total",
+			Help: "The total number of optimized images by original and output format",
+		},
+		[]string{"original_format", "output_format"},
+	)
+
+	// BackgroundRemovalTotal counts background removal tasks
+	BackgroundRemovalTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_background_removal_total",
+			Help: "The total number of background removal tasks",
+		},
+		[]string{"status"},
+	)
+
+	// BackgroundRemovalDuration measures the duration of background removal tasks
+	BackgroundRemovalDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "image_optimizer_background_removal_duration_seconds",
+			Help:    "The duration of background removal tasks in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.1, 2, 10), // From 100ms to ~100s
+		},
+		[]string{"status"},
+	)
+
+	// ImageQualityScore measures the SSIM score of optimized images against their source
+	ImageQualityScore = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "image_optimizer_quality_score",
+			Help:    "The SSIM score of optimized images against the unencoded image",
+			Buckets: []float64{0.8, 0.85, 0.9, 0.925, 0.95, 0.97, 0.98, 0.99, 0.995, 1},
+		},
+	)
+
+	// RenditionsTotal counts encoded renditions
+	RenditionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_renditions_total",
+			Help: "The total number of encoded image renditions",
+		},
+		[]string{"status"},
+	)
+
+	// RenditionDuration measures the duration of encoding and storing a single rendition
+	RenditionDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "image_optimizer_rendition_duration_seconds",
+			Help:    "The duration of encoding and storing a single rendition in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 12), // From 10ms to ~20s
+		},
+		[]string{"status"},
+	)
+
+	// DeletionsTotal counts the steps of image deletions: requested, confirmed, expired and purged
+	DeletionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_deletions_total",
+			Help: "The total number of image deletion steps",
+		},
+		[]string{"step"},
+	)
+
+	// MalwareScansTotal counts malware scans of uploads by result: clean, infected or error
+	MalwareScansTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_malware_scans_total",
+			Help: "The total number of malware scans of uploads",
+		},
+		[]string{"result"},
+	)
+
+	// IntegrityChecksTotal counts verifications of stored images by result: ok, corrupted,
+	// missing, unverified (no checksum to compare with) or error
+	IntegrityChecksTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_integrity_checks_total",
+			Help: "The total number of integrity verifications of stored images",
+		},
+		[]string{"result"},
+	)
+
+	// ReplicatedImagesTotal counts passes over images to replicate by result: success or failure
+	ReplicatedImagesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_replicated_images_total",
+			Help: "The total number of images copied to the replication target",
+		},
+		[]string{"result"},
+	)
+
+	// ReplicationPendingImages gauges the images left unreplicated by the last pass
+	ReplicationPendingImages = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "image_optimizer_replication_pending_images",
+			Help: "The number of images not replicated after the last replication pass",
+		},
+	)
+
+	// ReplicationLagSeconds gauges the age of the oldest change not replicated after the
+	// last pass, 0 when the target caught up
+	ReplicationLagSeconds = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "image_optimizer_replication_lag_seconds",
+			Help: "The age of the oldest change not yet replicated",
+		},
+	)
+
+	// GCOrphanedObjects gauges the orphaned objects found by the last garbage collection
+	GCOrphanedObjects = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "image_optimizer_gc_orphaned_objects",
+			Help: "The number of objects no image uses, as of the last garbage collection",
+		},
+	)
+
+	// GCDanglingImages gauges the images missing their original as of the last garbage collection
+	GCDanglingImages = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "image_optimizer_gc_dangling_images",
+			Help: "The number of images whose original is missing, as of the last garbage collection",
+		},
+	)
+
+	// GCDeletedTotal counts what garbage collection removed by kind: object or image
+	GCDeletedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_gc_deleted_total",
+			Help: "The total number of orphaned objects and dangling images removed",
+		},
+		[]string{"kind"},
+	)
+
+	// SkippedTasksTotal counts redelivered tasks acknowledged without processing by reason:
+	// completed, image_deleted or status_conflict
+	SkippedTasksTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_skipped_tasks_total",
+			Help: "The total number of redelivered tasks acknowledged without processing",
+		},
+		[]string{"reason"},
+	)
+
+	// ImageLeasesTotal counts image lease events: held when a redelivered task found another
+	// worker processing its image, takeover when it took over an expired lease, and lost when a
+	// worker found its image taken over
+	ImageLeasesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_image_leases_total",
+			Help: "The total number of image lease conflicts, takeovers and losses",
+		},
+		[]string{"event"},
+	)
+
+	// TaskRetriesTotal counts failed tasks by outcome: scheduled for a delayed retry,
+	// requeued at once because scheduling failed, exhausted, or permanent (not retried)
+	TaskRetriesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_task_retries_total",
+			Help: "The total number of failed tasks by retry outcome",
+		},
+		[]string{"result"},
+	)
+
+	// ImageRetriesTotal counts failed images queued again, by trigger: manual requests or
+	// the automatic retry policy
+	ImageRetriesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_image_retries_total",
+			Help: "The total number of failed images retried by trigger",
+		},
+		[]string{"trigger"},
+	)
+
+	// PanicsTotal counts panics recovered by component
+	PanicsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_panics_total",
+			Help: "The total number of recovered panics",
+		},
+		[]string{"component"},
+	)
+
+	// CircuitBreakerState gauges the circuit breaker of each dependency: 0 closed, 1 half-open, 2 open
+	CircuitBreakerState = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "image_optimizer_circuit_breaker_state",
+			Help: "The circuit breaker state by dependency (0 closed, 1 half-open, 2 open)",
+		},
+		[]string{"dependency"},
+	)
+
+	// CircuitBreakerTransitionsTotal counts circuit breaker state changes by dependency and new state
+	CircuitBreakerTransitionsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_circuit_breaker_transitions_total",
+			Help: "The total number of circuit breaker state changes",
+		},
+		[]string{"dependency", "state"},
+	)
+
+	// CircuitBreakerRejectedTotal counts calls rejected by an open circuit breaker
+	CircuitBreakerRejectedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_circuit_breaker_rejected_total",
+			Help: "The total number of calls rejected by an open circuit breaker",
+		},
+		[]string{"dependency"},
+	)
+
+	// StorageAttemptsTotal counts attempts of MinIO calls by operation and result: success,
+	// retry (failed and retried) or failure (failed for good)
+	StorageAttemptsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_storage_attempts_total",
+			Help: "The total number of attempted MinIO calls",
+		},
+		[]string{"operation", "result"},
+	)
+
+	// StorageDuration measures MinIO calls by operation and result, including their retries
+	StorageDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "image_optimizer_storage_duration_seconds",
+			Help:    "The duration of MinIO calls in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 16), // From 1ms to ~30s
+		},
+		[]string{"operation", "result"},
+	)
+
+	// StorageBytesTotal counts the bytes uploaded and downloaded by MinIO calls; its rate is
+	// the storage throughput
+	StorageBytesTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_storage_bytes_total",
+			Help: "The total number of bytes transferred by MinIO calls",
+		},
+		[]string{"operation"},
+	)
+
+	// ThrottledUploadsTotal counts low priority uploads refused while the processing
+	// pipeline was saturated, by the threshold exceeded
+	ThrottledUploadsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_throttled_uploads_total",
+			Help: "The total number of uploads refused by backpressure throttling",
+		},
+		[]string{"reason"},
+	)
+
+	// SyncUploadsTotal counts uploads with sync=true by outcome: processed inline, or
+	// queued because they were too large or inline processing failed
+	SyncUploadsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_sync_uploads_total",
+			Help: "The total number of uploads requesting synchronous processing",
+		},
+		[]string{"result"},
+	)
+
+	// StatusEventsTotal counts the image status events received by the event bus, and its
+	// resyncs after it stopped listening
+	StatusEventsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_status_events_total",
+			Help: "The total number of image status events received and resyncs of the event bus",
+		},
+		[]string{"result"},
+	)
+
+	// StatusEventSubscribers gauges the subscribers of the event bus, such as progress
+	// streams and long-polling requests
+	StatusEventSubscribers = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "image_optimizer_status_event_subscribers",
+			Help: "The number of current subscribers to image status events",
+		},
+	)
+
+	// NegotiatedFormatsTotal counts the formats served by the transformation and download
+	// endpoints after negotiating them with the Accept header
+	NegotiatedFormatsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_negotiated_formats_total",
+			Help: "The total number of images served by endpoint and negotiated format",
+		},
+		[]string{"endpoint", "format"},
+	)
+
+	// SelfTestsTotal counts end-to-end self-tests by result: passed or failed
+	SelfTestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "image_optimizer_selftests_total",
+			Help: "The total number of end-to-end self-tests",
+		},
+		[]string{"result"},
+	)
+
+	// SelfTestStageDuration measures the latency of each stage of the self-tests
+	SelfTestStageDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "image_optimizer_selftest_stage_duration_seconds",
+			Help:    "The duration of the stages of end-to-end self-tests in seconds",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 14), // From 5ms to ~40s
+		},
+		[]string{"stage"},
+	)
+
 	// QueueDepth gauges the current depth of the processing queue
 	QueueDepth = promauto.NewGauge(
 		prometheus.GaugeOpts{
@@ -73,6 +408,32 @@ var (
 		},
 	)
 
+	// WorkerConcurrency gauges the number of tasks the worker processes at once, as set by
+	// auto-tuning
+	WorkerConcurrency = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "image_optimizer_worker_concurrency",
+			Help: "The number of tasks the worker processes at once",
+		},
+	)
+
+	// WorkerTaskCPURatio gauges the CPU time of tasks per second they run, smoothed: near 1
+	// or above for encode-bound tasks, near 0 for IO-bound ones
+	WorkerTaskCPURatio = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "image_optimizer_worker_task_cpu_ratio",
+			Help: "The CPU seconds used per second of task run time",
+		},
+	)
+
+	// WorkerHeapBytes gauges the heap of the worker as read by auto-tuning
+	WorkerHeapBytes = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "image_optimizer_worker_heap_bytes",
+			Help: "The heap allocated by the worker in bytes",
+		},
+	)
+
 	// StorageUsage gauges the current storage usage in bytes
 	StorageUsage = promauto.NewGauge(
 		prometheus.GaugeOpts{
@@ -90,10 +451,34 @@ var (
 	)
 )
 
+// Handler serves the metrics of the default registry. Exemplars are only part of the
+// OpenMetrics format, so it is offered to scrapers that accept it.
+func Handler() http.Handler {
+	return promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	)
+}
+
+// observe records value on observer with the trace ID of the sampled span of ctx, if any,
+// as an exemplar, so a dashboard can jump from a bucket to a trace that landed in it
+func observe(ctx context.Context, observer prometheus.Observer, value float64) {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok && spanContext.IsSampled() {
+		exemplarObserver.ObserveWithExemplar(value, prometheus.Labels{"trace_id": spanContext.TraceID().String()})
+		return
+	}
+	observer.Observe(value)
+}
+
+// RecordRequestDuration records the duration of an HTTP request
+func RecordRequestDuration(ctx context.Context, method, endpoint string, duration float64) {
+	observe(ctx, RequestDuration.WithLabelValues(method, endpoint), duration)
+}
+
 // RecordProcessingTime records the time taken to process an image
 func RecordProcessingTime(ctx context.Context, status string, startTime time.Time) {
 	duration := time.Since(startTime).Seconds()
-	ProcessingDuration.WithLabelValues(status).Observe(duration)
+	observe(ctx, ProcessingDuration.WithLabelValues(status), duration)
 	ProcessingTotal.WithLabelValues(status).Inc()
 
 	reqLogger := logger.FromContext(ctx)
@@ -104,6 +489,65 @@ func RecordProcessingTime(ctx context.Context, status string, startTime time.Tim
 		Msg("Recorded image processing time")
 }
 
+// RecordProcessingStage records the time taken by one stage of image processing
+func RecordProcessingStage(ctx context.Context, stage string, duration time.Duration) {
+	ProcessingStageDuration.WithLabelValues(stage).Observe(duration.Seconds())
+
+	reqLogger := logger.FromContext(ctx)
+
+	reqLogger.Debug().
+		Str("stage", stage).
+		Float64("duration_seconds", duration.Seconds()).
+		Msg("Recorded processing stage time")
+}
+
+// RecordStorageCall records the duration of a MinIO call and the bytes it transferred
+func RecordStorageCall(ctx context.Context, operation, result string, duration time.Duration, bytes int64) {
+	observe(ctx, StorageDuration.WithLabelValues(operation, result), duration.Seconds())
+	if bytes > 0 {
+		StorageBytesTotal.WithLabelValues(operation).Add(float64(bytes))
+	}
+}
+
+// RecordBackgroundRemoval records the outcome and duration of a background removal task
+func RecordBackgroundRemoval(ctx context.Context, status string, startTime time.Time) {
+	duration := time.Since(startTime).Seconds()
+	BackgroundRemovalDuration.WithLabelValues(status).Observe(duration)
+	BackgroundRemovalTotal.WithLabelValues(status).Inc()
+
+	reqLogger := logger.FromContext(ctx)
+
+	reqLogger.Debug().
+		Str("status", status).
+		Float64("duration_seconds", duration).
+		Msg("Recorded background removal time")
+}
+
+// RecordQualityScore records the perceptual quality score of an optimized image
+func RecordQualityScore(ctx context.Context, score float64) {
+	ImageQualityScore.Observe(score)
+
+	reqLogger := logger.FromContext(ctx)
+
+	reqLogger.Debug().
+		Float64("quality_score", score).
+		Msg("Recorded image quality score")
+}
+
+// RecordRendition records the outcome and duration of a single rendition
+func RecordRendition(ctx context.Context, status string, startTime time.Time) {
+	duration := time.Since(startTime).Seconds()
+	RenditionDuration.WithLabelValues(status).Observe(duration)
+	RenditionsTotal.WithLabelValues(status).Inc()
+
+	reqLogger := logger.FromContext(ctx)
+
+	reqLogger.Debug().
+		Str("status", status).
+		Float64("duration_seconds", duration).
+		Msg("Recorded rendition time")
+}
+
 // RecordSizeReduction records the percentage of size reduction
 func RecordSizeReduction(ctx context.Context, originalSize, optimizedSize int64) {
 	if originalSize <= 0 {
@@ -122,6 +566,30 @@ func RecordSizeReduction(ctx context.Context, originalSize, optimizedSize int64)
 		Msg("Recorded image size reduction")
 }
 
+// RecordUpload records the size and original format of an accepted upload
+func RecordUpload(ctx context.Context, format string, size int64) {
+	UploadSize.WithLabelValues(format).Observe(float64(size))
+
+	reqLogger := logger.FromContext(ctx)
+
+	reqLogger.Debug().
+		Str("format", format).
+		Int64("size", size).
+		Msg("Recorded upload size")
+}
+
+// RecordOutputFormat records the output format of an optimized image
+func RecordOutputFormat(ctx context.Context, originalFormat, outputFormat string) {
+	OutputFormatsTotal.WithLabelValues(originalFormat, outputFormat).Inc()
+
+	reqLogger := logger.FromContext(ctx)
+
+	reqLogger.Debug().
+		Str("original_format", originalFormat).
+		Str("output_format", outputFormat).
+		Msg("Recorded output format")
+}
+
 // UpdateQueueDepth updates the queue depth metric
 func UpdateQueueDepth(depth int) {
 	QueueDepth.Set(float64(depth))
@@ -2,19 +2,244 @@ package minio
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// ErrObjectNotFound is returned when the requested object does not exist
+var ErrObjectNotFound = errors.New("object not found")
+
+// ErrPresignUnavailable is returned for presigned URLs of objects that can't be read
+// without sending a key, such as objects encrypted with SSE-C
+var ErrPresignUnavailable = errors.New("presigned URLs are not available for encrypted objects")
+
+// ErrChecksumMismatch is returned when the content of an object doesn't match the
+// checksum recorded at upload, because it was corrupted or tampered with
+var ErrChecksumMismatch = errors.New("object checksum mismatch")
+
+// ObjectInfo holds metadata about a stored object
+type ObjectInfo struct {
+	Size         int64
+	ContentType  string
+	ETag         string
+	LastModified time.Time
+	// Checksum is the hex SHA-256 recorded in the object metadata at upload, empty for
+	// objects uploaded without one
+	Checksum string
+}
+
+// ChecksumMetadata is the object metadata key holding the checksum of the content
+const ChecksumMetadata = "Sha256"
+
+type contentChecksumKey struct{}
+
+// WithContentChecksum returns a context carrying the hex SHA-256 of the content uploaded
+// with it, known before the content is read. Uploads that cannot be hashed up front
+// record it in the object metadata.
+func WithContentChecksum(ctx context.Context, checksum string) context.Context {
+	return context.WithValue(ctx, contentChecksumKey{}, checksum)
+}
+
+// ContentChecksum returns the checksum carried by ctx, empty if none
+func ContentChecksum(ctx context.Context) string {
+	checksum, _ := ctx.Value(contentChecksumKey{}).(string)
+	return checksum
+}
+
+type contentMD5Key struct{}
+
+// WithContentMD5 returns a context asking the store to verify the MD5 of the content
+// uploaded with it, for uploads whose MD5 was sent by the client
+func WithContentMD5(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contentMD5Key{}, true)
+}
+
+// ContentMD5 reports whether ctx asks the store to verify the MD5 of the content
+func ContentMD5(ctx context.Context) bool {
+	verify, _ := ctx.Value(contentMD5Key{}).(bool)
+	return verify
+}
+
+// Checksum returns the hex SHA-256 of the rest of content and seeks back to where it was
+func Checksum(content io.ReadSeeker) (string, error) {
+	start, err := content.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return "", fmt.Errorf("error seeking content: %w", err)
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", fmt.Errorf("error hashing content: %w", err)
+	}
+	if _, err := content.Seek(start, io.SeekStart); err != nil {
+		return "", fmt.Errorf("error rewinding content: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ObjectEvent reports an object created in the bucket. Err is set if listening failed.
+type ObjectEvent struct {
+	Key  string
+	Size int64
+	Err  error
+}
+
+// Variant describes an object derived from an original. Its name tells the kind of
+// object apart ("optimized", "optimized-thumbnail", "cutout") so that the public-read
+// policy can tell derived objects apart from originals.
+type Variant struct {
+	Name   string
+	Width  int
+	Height int
+	// Format is the image format of the object, such as "jpeg" or "png"
+	Format string
+}
+
+// FileName names the object of a variant <name>-<width>x<height>.<ext>, so outputs of
+// different sizes or formats never overwrite each other. Variants of unknown size are
+// named <name>.<ext>.
+func (v Variant) FileName() string {
+	ext := v.Format
+	if ext == "jpeg" {
+		ext = "jpg"
+	}
+	if v.Width <= 0 || v.Height <= 0 {
+		return v.Name + "." + ext
+	}
+	return fmt.Sprintf("%s-%dx%d.%s", v.Name, v.Width, v.Height, ext)
+}
+
+// Namer names the objects stored for an image
+type Namer interface {
+	// GenerateObjectName names the original of an image. content is only read by
+	// content-addressed naming and is rewound afterwards.
+	GenerateObjectName(id uuid.UUID, fileName string, content io.ReadSeeker) (string, error)
+
+	// DerivedObjectName names a variant derived from the original stored at originalPath
+	DerivedObjectName(originalPath string, id uuid.UUID, variant Variant, content []byte) string
+
+	// ContentAddressed reports whether names are derived from content, in which case
+	// objects may be shared between images and are never overwritten with other content
+	ContentAddressed() bool
+}
+
+// Class is a class of stored objects. Each class may be kept in its own bucket, so that
+// lifecycle and replication policies can differ between them.
+type Class string
+
+const (
+	// ClassOriginal holds the uploaded originals, including quarantined ones
+	ClassOriginal Class = "original"
+	// ClassOptimized holds the objects derived from originals: optimized images,
+	// renditions, versions and cutouts
+	ClassOptimized Class = "optimized"
+	// ClassTemp holds the objects dropped into the bucket for ingestion
+	ClassTemp Class = "temp"
+)
+
+// OriginalTag is the object tag set on originals, which lifecycle rules filter on
+const OriginalTag = "kind=original"
+
+// LifecycleRule is a bucket lifecycle rule as applied to the bucket
+type LifecycleRule struct {
+	ID             string `json:"id"`
+	Prefix         string `json:"prefix,omitempty"`
+	Tag            string `json:"tag,omitempty"`
+	TransitionDays int    `json:"transition_days,omitempty"`
+	StorageClass   string `json:"storage_class,omitempty"`
+	ExpirationDays int    `json:"expiration_days,omitempty"`
+}
+
+// Store uploads an object through c. With content-addressed naming an existing object
+// already holds the same content, so the upload is skipped.
+func Store(ctx context.Context, c Client, reader io.Reader, objectName, contentType string) error {
+	return store(ctx, c, objectName, func() error {
+		return c.UploadImage(ctx, reader, objectName, contentType)
+	})
+}
+
+// StoreOriginal uploads the original of an image like Store, tagged with OriginalTag
+func StoreOriginal(ctx context.Context, c Client, reader io.Reader, objectName, contentType string) error {
+	return store(ctx, c, objectName, func() error {
+		return c.UploadOriginal(ctx, reader, objectName, contentType)
+	})
+}
+
+func store(ctx context.Context, c Client, objectName string, upload func() error) error {
+	if c.ContentAddressed() {
+		_, err := c.StatImage(ctx, objectName)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrObjectNotFound) {
+			return err
+		}
+	}
+	return upload()
+}
+
+// StoreCopy stores a server-side copy of src as dst. With content-addressed naming the
+// copy is skipped if dst already exists.
+func StoreCopy(ctx context.Context, c Client, src, dst string) error {
+	return store(ctx, c, dst, func() error {
+		return c.CopyObject(ctx, src, dst)
+	})
+}
+
+// Referencer reports whether an object is used by any image other than exclude
+type Referencer interface {
+	ObjectReferenced(ctx context.Context, objectName string, exclude uuid.UUID) (bool, error)
+}
+
+// Release deletes an object of an image. With content-addressed naming the object is kept
+// while other images still use it.
+func Release(ctx context.Context, c Client, refs Referencer, objectName string, imageID uuid.UUID) error {
+	if c.ContentAddressed() {
+		referenced, err := refs.ObjectReferenced(ctx, objectName, imageID)
+		if err != nil {
+			return err
+		}
+		if referenced {
+			return nil
+		}
+	}
+	return c.DeleteImage(ctx, objectName)
+}
+
 // Client defines the interface for MinIO operations
 type Client interface {
 	UploadImage(ctx context.Context, reader io.Reader, objectName string, contentType string) error
+	// UploadOriginal uploads an image original, tagged with OriginalTag
+	UploadOriginal(ctx context.Context, reader io.Reader, objectName string, contentType string) error
 	GetImage(ctx context.Context, objectName string) (io.ReadCloser, error)
+	StatImage(ctx context.Context, objectName string) (*ObjectInfo, error)
 	DeleteImage(ctx context.Context, objectName string) error
+	// CopyObject copies an object within the bucket on the server, with its metadata and tags
+	CopyObject(ctx context.Context, src, dst string) error
 	GetImageURL(ctx context.Context, objectName string, expires time.Duration) (string, error)
-	GenerateObjectName(id uuid.UUID, fileName string) string
+	Namer
+
+	// ListObjects returns the names of all objects under prefix
+	ListObjects(ctx context.Context, prefix string) ([]string, error)
+
+	// ListenObjectCreated streams bucket notifications for objects created under prefix
+	// until ctx is cancelled
+	ListenObjectCreated(ctx context.Context, prefix string) <-chan ObjectEvent
+
+	// Lifecycle returns the lifecycle rules applied to the bucket
+	Lifecycle(ctx context.Context) ([]LifecycleRule, error)
+
+	// In returns a client for the bucket holding objects of class. Object names are the
+	// same in every bucket; the root client uses the main bucket.
+	In(class Class) Client
+
+	// Ping checks that the buckets are reachable
+	Ping(ctx context.Context) error
 
 	// Close closes the MinIO client connection
 	Close() error
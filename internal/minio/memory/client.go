@@ -0,0 +1,266 @@
+// Package memory is an in-memory minio.Client for tests and ephemeral demo runs. Objects
+// are named like the MinIO client names them and kept per bucket, so object classes
+// configured to share a bucket share their objects.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+	miniostore "github.com/not-nullexception/image-optimizer/internal/minio/minio"
+)
+
+// listenerBuffer is how many notifications a slow ListenObjectCreated consumer may fall
+// behind before further ones are dropped
+const listenerBuffer = 256
+
+type object struct {
+	data        []byte
+	contentType string
+	etag        string
+	checksum    string
+	original    bool
+	modified    time.Time
+}
+
+// bucket holds the objects of a bucket and the listeners to their creation
+type bucket struct {
+	name string
+
+	mu        sync.RWMutex
+	objects   map[string]*object
+	listeners map[*listener]struct{}
+}
+
+type listener struct {
+	prefix string
+	events chan minio.ObjectEvent
+}
+
+type Client struct {
+	minio.Namer
+
+	config  *config.MinIOConfig
+	buckets map[string]*bucket
+	bucket  *bucket
+}
+
+// NewClient creates an in-memory client with empty buckets
+func NewClient(cfg *config.MinIOConfig) (minio.Client, error) {
+	namer, err := miniostore.ConfiguredNamer(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := make(map[string]*bucket)
+	for _, name := range []string{cfg.Bucket, cfg.OriginalsBucket, cfg.OptimizedBucket, cfg.TempBucket} {
+		if _, ok := buckets[name]; name != "" && !ok {
+			buckets[name] = &bucket{
+				name:      name,
+				objects:   make(map[string]*object),
+				listeners: make(map[*listener]struct{}),
+			}
+		}
+	}
+
+	return &Client{Namer: namer, config: cfg, buckets: buckets, bucket: buckets[cfg.Bucket]}, nil
+}
+
+// In returns a client for the bucket holding objects of class
+func (c *Client) In(class minio.Class) minio.Client {
+	name := c.config.Bucket
+	switch class {
+	case minio.ClassOriginal:
+		name = c.config.OriginalsBucket
+	case minio.ClassOptimized:
+		name = c.config.OptimizedBucket
+	case minio.ClassTemp:
+		name = c.config.TempBucket
+	}
+
+	in := *c
+	if b, ok := c.buckets[name]; ok {
+		in.bucket = b
+	}
+	return &in
+}
+
+func (c *Client) UploadImage(_ context.Context, reader io.Reader, objectName string, contentType string) error {
+	return c.put(reader, objectName, contentType, false)
+}
+
+// UploadOriginal uploads an image original, tagged with OriginalTag
+func (c *Client) UploadOriginal(_ context.Context, reader io.Reader, objectName string, contentType string) error {
+	return c.put(reader, objectName, contentType, true)
+}
+
+// put stores the content of reader with its SHA-256 as checksum, and notifies the
+// listeners of the bucket
+func (c *Client) put(reader io.Reader, objectName, contentType string, original bool) error {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("error uploading image: %w", err)
+	}
+	checksum := sha256.Sum256(data)
+	etag := md5.Sum(data)
+
+	c.bucket.mu.Lock()
+	defer c.bucket.mu.Unlock()
+
+	c.bucket.objects[objectName] = &object{
+		data:        data,
+		contentType: contentType,
+		etag:        hex.EncodeToString(etag[:]),
+		checksum:    hex.EncodeToString(checksum[:]),
+		original:    original,
+		modified:    time.Now(),
+	}
+	c.bucket.notify(objectName, int64(len(data)))
+	return nil
+}
+
+func (c *Client) GetImage(_ context.Context, objectName string) (io.ReadCloser, error) {
+	obj, err := c.get(objectName)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+func (c *Client) StatImage(_ context.Context, objectName string) (*minio.ObjectInfo, error) {
+	obj, err := c.get(objectName)
+	if err != nil {
+		return nil, err
+	}
+	return &minio.ObjectInfo{
+		Size:         int64(len(obj.data)),
+		ContentType:  obj.contentType,
+		ETag:         obj.etag,
+		LastModified: obj.modified,
+		Checksum:     obj.checksum,
+	}, nil
+}
+
+// get returns a stored object, whose data is never modified in place
+func (c *Client) get(objectName string) (*object, error) {
+	c.bucket.mu.RLock()
+	defer c.bucket.mu.RUnlock()
+
+	obj, ok := c.bucket.objects[objectName]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", minio.ErrObjectNotFound, objectName)
+	}
+	return obj, nil
+}
+
+// DeleteImage deletes an object. Deleting an object that is already gone succeeds.
+func (c *Client) DeleteImage(_ context.Context, objectName string) error {
+	c.bucket.mu.Lock()
+	defer c.bucket.mu.Unlock()
+
+	delete(c.bucket.objects, objectName)
+	return nil
+}
+
+// CopyObject copies an object within the bucket, with its metadata and tags
+func (c *Client) CopyObject(_ context.Context, src, dst string) error {
+	c.bucket.mu.Lock()
+	defer c.bucket.mu.Unlock()
+
+	obj, ok := c.bucket.objects[src]
+	if !ok {
+		return fmt.Errorf("error copying object: %w: %s", minio.ErrObjectNotFound, src)
+	}
+	copied := *obj
+	copied.modified = time.Now()
+	c.bucket.objects[dst] = &copied
+	c.bucket.notify(dst, int64(len(copied.data)))
+	return nil
+}
+
+// GetImageURL returns a memory:// URL naming the object. Nothing serves it; it only
+// identifies the object in responses.
+func (c *Client) GetImageURL(_ context.Context, objectName string, expires time.Duration) (string, error) {
+	u := url.URL{
+		Scheme:   "memory",
+		Host:     c.bucket.name,
+		Path:     "/" + objectName,
+		RawQuery: url.Values{"expires": {expires.String()}}.Encode(),
+	}
+	return u.String(), nil
+}
+
+// ListObjects returns the names of all objects under prefix, in lexical order like S3
+func (c *Client) ListObjects(_ context.Context, prefix string) ([]string, error) {
+	c.bucket.mu.RLock()
+	defer c.bucket.mu.RUnlock()
+
+	var names []string
+	for name := range c.bucket.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// ListenObjectCreated streams the objects uploaded or copied under prefix from now on.
+// The channel is closed when ctx is cancelled.
+func (c *Client) ListenObjectCreated(ctx context.Context, prefix string) <-chan minio.ObjectEvent {
+	l := &listener{prefix: prefix, events: make(chan minio.ObjectEvent, listenerBuffer)}
+
+	b := c.bucket
+	b.mu.Lock()
+	b.listeners[l] = struct{}{}
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.listeners, l)
+		close(l.events)
+		b.mu.Unlock()
+	}()
+
+	return l.events
+}
+
+// notify sends a created object to the listeners of its prefix. The caller holds the
+// write lock.
+func (b *bucket) notify(objectName string, size int64) {
+	for l := range b.listeners {
+		if !strings.HasPrefix(objectName, l.prefix) {
+			continue
+		}
+		select {
+		case l.events <- minio.ObjectEvent{Key: objectName, Size: size}:
+		default:
+		}
+	}
+}
+
+// Lifecycle returns no rules: objects in memory never expire or change storage class
+func (c *Client) Lifecycle(_ context.Context) ([]minio.LifecycleRule, error) {
+	return []minio.LifecycleRule{}, nil
+}
+
+func (c *Client) Ping(_ context.Context) error {
+	return nil
+}
+
+func (c *Client) Close() error {
+	return nil
+}
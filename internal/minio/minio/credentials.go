@@ -0,0 +1,55 @@
+package minio
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/rs/zerolog"
+)
+
+// rotatingProvider serves the current MinIO keys of a config.Credential. The client
+// retrieves them again once the credential is invalidated by rejectionTransport.
+type rotatingProvider struct {
+	creds  *config.Credential
+	logger zerolog.Logger
+}
+
+func (p *rotatingProvider) Retrieve() (credentials.Value, error) {
+	return p.RetrieveWithCredContext(nil)
+}
+
+func (p *rotatingProvider) RetrieveWithCredContext(*credentials.CredContext) (credentials.Value, error) {
+	accessKey, secretKey, err := p.creds.Get(context.Background())
+	if err != nil {
+		p.logger.Warn().Err(err).Msg("Signing with the previous MinIO credentials")
+	}
+
+	return credentials.Value{
+		AccessKeyID:     accessKey,
+		SecretAccessKey: secretKey,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
+
+func (p *rotatingProvider) IsExpired() bool {
+	return p.creds.Stale()
+}
+
+// rejectionTransport invalidates the credential when MinIO answers 403, so the
+// requests after a key rotation are signed with the new keys
+type rejectionTransport struct {
+	next   http.RoundTripper
+	creds  *config.Credential
+	logger zerolog.Logger
+}
+
+func (t *rejectionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err == nil && resp.StatusCode == http.StatusForbidden && !t.creds.Stale() {
+		t.logger.Warn().Str("path", req.URL.Path).Msg("MinIO rejected the request, reading the credentials again")
+		t.creds.Invalidate()
+	}
+	return resp, err
+}
@@ -0,0 +1,47 @@
+package minio
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/not-nullexception/image-optimizer/config"
+)
+
+// Server-side encryption modes selected with MINIO_SSE
+const (
+	// EncryptionS3 encrypts objects with keys managed by the server (SSE-S3)
+	EncryptionS3 = "s3"
+	// EncryptionKMS encrypts objects with a key of the server's KMS (SSE-KMS)
+	EncryptionKMS = "kms"
+	// EncryptionCustomer encrypts objects with a key provided on every request (SSE-C)
+	EncryptionCustomer = "c"
+)
+
+// serverSideEncryption returns the encryption of new objects, or nil if disabled
+func serverSideEncryption(cfg *config.MinIOConfig) (encrypt.ServerSide, error) {
+	switch cfg.SSE {
+	case "":
+		return nil, nil
+	case EncryptionS3:
+		return encrypt.NewSSE(), nil
+	case EncryptionKMS:
+		sse, err := encrypt.NewSSEKMS(cfg.SSEKMSKeyID, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring SSE-KMS: %w", err)
+		}
+		return sse, nil
+	case EncryptionCustomer:
+		key, err := base64.StdEncoding.DecodeString(cfg.SSECustomerKey)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding MINIO_SSE_C_KEY: %w", err)
+		}
+		sse, err := encrypt.NewSSEC(key)
+		if err != nil {
+			return nil, fmt.Errorf("error configuring SSE-C: %w", err)
+		}
+		return sse, nil
+	default:
+		return nil, fmt.Errorf("unknown server-side encryption %q, expected s3, kms or c", cfg.SSE)
+	}
+}
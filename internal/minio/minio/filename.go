@@ -0,0 +1,112 @@
+package minio
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// maxBaseLength is the longest sanitized base name kept in object keys, in bytes
+const maxBaseLength = 100
+
+// maxExtLength is the longest extension kept in object keys, dot included
+const maxExtLength = 10
+
+// fallbackBase names originals whose file name has nothing left after sanitizing
+const fallbackBase = "image"
+
+// collisionPrefix is prepended to originals named like the objects derived from them.
+// Those share the original's directory, and optimized ones are public-read by name.
+const collisionPrefix = "original_"
+
+// transliterations maps Cyrillic and Greek letters to ASCII. Latin letters with
+// diacritics lose them instead.
+var transliterations = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "u",
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i", 'θ': "th",
+	'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x", 'ο': "o", 'π': "p",
+	'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y", 'φ': "f", 'χ': "ch", 'ψ': "ps",
+	'ω': "o",
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ł': "l", 'þ': "th",
+}
+
+// sanitizeFileName sanitizes a file name for storage. Letters are transliterated to ASCII
+// where possible, whitespace becomes underscores and anything else outside
+// [A-Za-z0-9_.-] is dropped.
+func sanitizeFileName(fileName string) string {
+	var b strings.Builder
+	for _, r := range norm.NFC.String(fileName) {
+		if allowedRune(r) {
+			b.WriteRune(r)
+			continue
+		}
+		if unicode.IsSpace(r) {
+			b.WriteByte('_')
+			continue
+		}
+		lower := unicode.ToLower(r)
+		if ascii, ok := transliterations[lower]; ok {
+			if lower != r && ascii != "" {
+				// keep capitals capitalized
+				ascii = strings.ToUpper(ascii[:1]) + ascii[1:]
+			}
+			b.WriteString(ascii)
+			continue
+		}
+		// letters with diacritics decompose into their base letter and combining marks
+		for _, d := range norm.NFD.String(string(r)) {
+			if allowedRune(d) {
+				b.WriteRune(d)
+			}
+		}
+	}
+	return b.String()
+}
+
+// allowedRune reports whether r is kept as is in object keys
+func allowedRune(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-' || r == '.'
+}
+
+// sanitizedName returns fileName sanitized for storage: its base is sanitized, stripped
+// of leading and trailing dots, cut to maxBaseLength and prefixed if it would collide with
+// a derived object; its extension is sanitized and cut to maxExtLength
+func sanitizedName(fileName string) string {
+	fileName = fileName[strings.LastIndexAny(fileName, `/\`)+1:]
+	ext := sanitizeFileName(extension(fileName))
+	if len(ext) < 2 {
+		ext = ""
+	}
+	ext = truncate(ext, maxExtLength)
+
+	base := strings.Trim(sanitizeFileName(strings.TrimSuffix(fileName, extension(fileName))), ".")
+	base = truncate(base, maxBaseLength)
+	if base == "" {
+		base = fallbackBase
+	}
+	if strings.HasPrefix(base, "optimized") || strings.HasPrefix(base, "cutout") {
+		base = collisionPrefix + base
+	}
+	return base + ext
+}
+
+// extension returns the extension of fileName, dot included, or "" if it has none
+func extension(fileName string) string {
+	if i := strings.LastIndexByte(fileName, '.'); i > 0 {
+		return fileName[i:]
+	}
+	return ""
+}
+
+// truncate cuts the ASCII string s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
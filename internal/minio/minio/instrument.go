@@ -0,0 +1,71 @@
+package minio
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the storage spans among those of the service
+const tracerName = "github.com/not-nullexception/image-optimizer/internal/minio"
+
+// instrument times a storage operation on objectName, with all its attempts, in
+// image_optimizer_storage_duration_seconds and records it as a span named after the
+// operation, as a child of the span of ctx. The returned function ends the operation with
+// the bytes it transferred and its error. The context it returns carries the span, so
+// retries are recorded on it.
+func (m *MinioClient) instrument(ctx context.Context, op, objectName string) (context.Context, func(bytes int64, err error)) {
+	start := time.Now()
+
+	span := trace.SpanFromContext(ctx)
+	if span.SpanContext().IsValid() {
+		ctx, span = span.TracerProvider().Tracer(tracerName).Start(ctx, "storage "+op,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(
+				attribute.String("storage.operation", op),
+				attribute.String("storage.bucket", m.bucketName),
+				attribute.String("storage.key_prefix", keyPrefix(objectName)),
+			),
+		)
+	}
+
+	return ctx, func(bytes int64, err error) {
+		result := "success"
+		switch {
+		case errors.Is(err, minio.ErrObjectNotFound):
+			// a missing object is an answer, not a failed call
+			result = "not_found"
+		case err != nil:
+			result = "failure"
+		}
+		metrics.RecordStorageCall(ctx, op, result, time.Since(start), bytes)
+
+		if !span.IsRecording() {
+			return
+		}
+		span.SetAttributes(attribute.String("storage.result", result))
+		if bytes > 0 {
+			span.SetAttributes(attribute.Int64("storage.bytes", bytes))
+		}
+		if result == "failure" {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// keyPrefix returns the first segment of objectName, such as a configured prefix, the year
+// of date naming or the ID directory of uuid naming, so spans tell kinds of objects apart
+// without their full names
+func keyPrefix(objectName string) string {
+	prefix, _, _ := strings.Cut(objectName, "/")
+	return prefix
+}
@@ -2,73 +2,193 @@ package minio
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
-	"path"
+	"net/url"
+	"slices"
+	"sort"
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
 	minioLib "github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/minio/minio-go/v7/pkg/encrypt"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"github.com/minio/minio-go/v7/pkg/notification"
 	"github.com/not-nullexception/image-optimizer/config"
 	"github.com/not-nullexception/image-optimizer/internal/logger"
 	"github.com/not-nullexception/image-optimizer/internal/minio"
+	"github.com/not-nullexception/image-optimizer/internal/resilience"
 )
 
 type MinioClient struct {
+	minio.Namer
+
 	client     *minioLib.Client
 	bucketName string
 	config     *config.MinIOConfig
+	backoff    resilience.Backoff
+	// sse encrypts new objects, and decrypts them with SSE-C; nil disables it
+	sse encrypt.ServerSide
 }
 
 func NewClient(cfg *config.MinIOConfig) (minio.Client, error) {
 	reqLogger := logger.GetLogger("minio-client")
 
-	// Initialize MinIO client
-	client, err := minioLib.New(cfg.Endpoint, &minioLib.Options{
+	options := &minioLib.Options{
 		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
 		Secure: cfg.SSL,
-	})
+		// Calls are retried by withRetry, which knows which ones are safe to repeat
+		MaxRetries: 1,
+	}
+
+	// Pick up rotated keys when MinIO rejects the current ones
+	if cfg.Credentials != nil {
+		transport, err := minioLib.DefaultTransport(cfg.SSL)
+		if err != nil {
+			return nil, fmt.Errorf("error creating MinIO transport: %w", err)
+		}
+		options.Creds = credentials.New(&rotatingProvider{creds: cfg.Credentials, logger: reqLogger})
+		options.Transport = &rejectionTransport{next: transport, creds: cfg.Credentials, logger: reqLogger}
+	}
+
+	// Initialize MinIO client
+	client, err := minioLib.New(cfg.Endpoint, options)
 	if err != nil {
 		return nil, fmt.Errorf("error initializing MinIO client: %w", err)
 	}
 
+	namer, err := ConfiguredNamer(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	sse, err := serverSideEncryption(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	mc := &MinioClient{
+		Namer:      namer,
 		client:     client,
 		bucketName: cfg.Bucket,
 		config:     cfg,
+		backoff:    resilience.Backoff{Base: cfg.RetryBaseDelay, Max: cfg.RetryMaxDelay},
+		sse:        sse,
 	}
 
-	exists, err := client.BucketExists(context.Background(), cfg.Bucket)
-	if err != nil {
-		reqLogger.Error().Err(err).Msg("Error checking if bucket exists")
-		return nil, fmt.Errorf("error checking if bucket exists: %w", err)
+	// Each class of objects may be kept in its own bucket
+	for _, bucket := range mc.buckets() {
+		exists, err := client.BucketExists(context.Background(), bucket)
+		if err != nil {
+			reqLogger.Error().Err(err).Str("bucket", bucket).Msg("Error checking if bucket exists")
+			return nil, fmt.Errorf("error checking if bucket exists: %w", err)
+		}
+
+		if !exists {
+			err = client.MakeBucket(context.Background(), bucket, minioLib.MakeBucketOptions{Region: cfg.Location})
+			if err != nil {
+				reqLogger.Error().Err(err).Str("bucket", bucket).Msg("Error creating bucket")
+				return nil, fmt.Errorf("error creating bucket: %w", err)
+			}
+			reqLogger.Info().Str("bucket", bucket).Msg("Bucket created")
+		} else {
+			reqLogger.Info().Str("bucket", bucket).Msg("Bucket already exists")
+		}
+
+		if cfg.Lifecycle.Managed {
+			rules := lifecycleRules(&cfg.Lifecycle)
+			err = client.SetBucketLifecycle(context.Background(), bucket, rules)
+			if err != nil {
+				reqLogger.Error().Err(err).Str("bucket", bucket).Msg("Error applying bucket lifecycle")
+				return nil, fmt.Errorf("error setting bucket lifecycle: %w", err)
+			}
+			reqLogger.Info().Str("bucket", bucket).Int("rules", len(rules.Rules)).Msg("Bucket lifecycle applied")
+		}
 	}
 
-	if !exists {
-		err = client.MakeBucket(context.Background(), cfg.Bucket, minioLib.MakeBucketOptions{Region: cfg.Location})
+	if cfg.PublicRead {
+		err = client.SetBucketPolicy(context.Background(), cfg.OptimizedBucket, publicReadPolicy(cfg.OptimizedBucket))
 		if err != nil {
-			reqLogger.Error().Err(err).Str("bucket", cfg.Bucket).Msg("Error creating bucket")
-			return nil, fmt.Errorf("error creating bucket: %w", err)
+			reqLogger.Error().Err(err).Str("bucket", cfg.OptimizedBucket).Msg("Error setting public-read bucket policy")
+			return nil, fmt.Errorf("error setting bucket policy: %w", err)
 		}
-		reqLogger.Info().Str("bucket", cfg.Bucket).Msg("Bucket created")
-	} else {
-		reqLogger.Info().Str("bucket", cfg.Bucket).Msg("Bucket already exists")
+		reqLogger.Info().Str("bucket", cfg.OptimizedBucket).Msg("Optimized objects are publicly readable")
 	}
 
 	return mc, nil
 }
 
-// UploadImage TODO - Check if we need retry logic with backoff
-// UploadImage uploads an image to MinIO
+// In returns a client for the bucket holding objects of class
+func (m *MinioClient) In(class minio.Class) minio.Client {
+	c := *m
+	switch class {
+	case minio.ClassOriginal:
+		c.bucketName = m.config.OriginalsBucket
+	case minio.ClassOptimized:
+		c.bucketName = m.config.OptimizedBucket
+	case minio.ClassTemp:
+		c.bucketName = m.config.TempBucket
+	}
+	return &c
+}
+
+// buckets returns the distinct buckets of the main bucket and the object classes
+func (m *MinioClient) buckets() []string {
+	var buckets []string
+	for _, bucket := range []string{m.config.Bucket, m.config.OriginalsBucket, m.config.OptimizedBucket, m.config.TempBucket} {
+		if bucket != "" && !slices.Contains(buckets, bucket) {
+			buckets = append(buckets, bucket)
+		}
+	}
+	return buckets
+}
+
+// lifecycleRules builds the bucket lifecycle configuration. An empty configuration
+// removes the lifecycle of the bucket.
+func lifecycleRules(cfg *config.LifecycleConfig) *lifecycle.Configuration {
+	rules := lifecycle.NewConfiguration()
+
+	if cfg.OriginalsTransitionDays > 0 && cfg.OriginalsStorageClass != "" {
+		key, value, _ := strings.Cut(minio.OriginalTag, "=")
+		rules.Rules = append(rules.Rules, lifecycle.Rule{
+			ID:         "transition-originals",
+			Status:     "Enabled",
+			RuleFilter: lifecycle.Filter{Tag: lifecycle.Tag{Key: key, Value: value}},
+			Transition: lifecycle.Transition{
+				Days:         lifecycle.ExpirationDays(cfg.OriginalsTransitionDays),
+				StorageClass: cfg.OriginalsStorageClass,
+			},
+		})
+	}
+
+	prefixes := make([]string, 0, len(cfg.ExpirePrefixes))
+	for prefix := range cfg.ExpirePrefixes {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		rules.Rules = append(rules.Rules, lifecycle.Rule{
+			ID:         "expire-" + strings.Trim(strings.ReplaceAll(prefix, "/", "-"), "-"),
+			Status:     "Enabled",
+			RuleFilter: lifecycle.Filter{Prefix: prefix},
+			Expiration: lifecycle.Expiration{Days: lifecycle.ExpirationDays(cfg.ExpirePrefixes[prefix])},
+		})
+	}
+
+	return rules
+}
+
+// UploadImage uploads an image to MinIO. Uploads from readers that can be rewound,
+// such as files and in-memory buffers, are retried.
 func (m *MinioClient) UploadImage(ctx context.Context, reader io.Reader, objectName string, contentType string) error {
 	reqLogger := logger.FromContext(ctx).With().Str("component", "minio-client").Logger()
 
 	reqLogger.Debug().Str("object", objectName).Str("content_type", contentType).Msg("Starting image upload")
 
-	_, err := m.client.PutObject(ctx, m.bucketName, objectName, reader, -1,
-		minioLib.PutObjectOptions{ContentType: contentType})
+	err := m.putObject(ctx, reader, objectName, minioLib.PutObjectOptions{ContentType: contentType})
 	if err != nil {
 		reqLogger.Error().Err(err).Str("object", objectName).Msg("Error uploading image")
 		return fmt.Errorf("error uploading image: %w", err)
@@ -78,27 +198,184 @@ func (m *MinioClient) UploadImage(ctx context.Context, reader io.Reader, objectN
 	return nil
 }
 
-// GetImage TODO - Check if we need retry logic with backoff
-// GetImage retrieves an image from MinIO
+// UploadOriginal uploads an image original, tagged so that lifecycle rules can tier it
+func (m *MinioClient) UploadOriginal(ctx context.Context, reader io.Reader, objectName string, contentType string) error {
+	reqLogger := logger.FromContext(ctx).With().Str("component", "minio-client").Logger()
+
+	key, value, _ := strings.Cut(minio.OriginalTag, "=")
+	err := m.putObject(ctx, reader, objectName,
+		minioLib.PutObjectOptions{ContentType: contentType, UserTags: map[string]string{key: value}})
+	if err != nil {
+		reqLogger.Error().Err(err).Str("object", objectName).Msg("Error uploading original")
+		return fmt.Errorf("error uploading image: %w", err)
+	}
+
+	reqLogger.Debug().Str("object", objectName).Str("content_type", contentType).Msg("Original uploaded successfully")
+	return nil
+}
+
+// streamPartSize is the size of the parts uploads of unknown size are sent in, the
+// smallest part size S3 accepts
+const streamPartSize = 5 * 1024 * 1024
+
+// putObject uploads reader, retrying if it can be rewound. Uploading the same content
+// again is harmless, so the upload is idempotent as long as the reader starts over.
+// The checksum of readers that can be rewound, or else the one carried by ctx, is stored in
+// the object metadata.
+func (m *MinioClient) putObject(ctx context.Context, reader io.Reader, objectName string, opts minioLib.PutObjectOptions) (err error) {
+	ctx, done := m.instrument(ctx, "upload", objectName)
+	var size int64
+	defer func() { done(size, err) }()
+
+	opts.ServerSideEncryption = m.sse
+	objectSize := int64(-1)
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		checksum, err := minio.Checksum(seeker)
+		if err != nil {
+			return err
+		}
+		opts.UserMetadata = map[string]string{minio.ChecksumMetadata: checksum}
+		if objectSize, err = remaining(seeker); err != nil {
+			return err
+		}
+	} else if checksum := minio.ContentChecksum(ctx); checksum != "" {
+		opts.UserMetadata = map[string]string{minio.ChecksumMetadata: checksum}
+	}
+	// The store verifies the MD5 of every part against the one sent with it, which was
+	// hashed from the same bytes the client's MD5 was checked against
+	if minio.ContentMD5(ctx) {
+		opts.SendContentMd5 = true
+	}
+	// Streams of unknown size are buffered a part at a time; without a part size the
+	// client sizes parts for the largest possible object
+	if objectSize < 0 && opts.PartSize == 0 {
+		opts.PartSize = streamPartSize
+	}
+	rewind, rewindable := rewinder(reader)
+	return m.withRetry(ctx, "upload", rewindable, func() error {
+		if err := rewind(); err != nil {
+			return fmt.Errorf("error rewinding upload: %w", err)
+		}
+		info, err := m.client.PutObject(ctx, m.bucketName, objectName, reader, objectSize, opts)
+		size = info.Size
+		return err
+	})
+}
+
+// remaining returns the number of bytes left to read from seeker, leaving its position unchanged
+func remaining(seeker io.Seeker) (int64, error) {
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, fmt.Errorf("error seeking upload: %w", err)
+	}
+	end, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, fmt.Errorf("error seeking upload: %w", err)
+	}
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("error rewinding upload: %w", err)
+	}
+	return end - start, nil
+}
+
+// GetImage retrieves an image from MinIO. The object is opened before returning, so
+// that failures are retried and a missing object is reported as minio.ErrObjectNotFound.
+// With MINIO_VERIFY_CHECKSUMS, reading the object to the end fails with
+// minio.ErrChecksumMismatch if it doesn't match its checksum.
 func (m *MinioClient) GetImage(ctx context.Context, objectName string) (io.ReadCloser, error) {
 	reqLogger := logger.FromContext(ctx).With().Str("component", "minio-client").Logger()
 
 	reqLogger.Debug().Str("object", objectName).Msg("Starting image retrieval")
 
-	obj, err := m.client.GetObject(ctx, m.bucketName, objectName, minioLib.GetObjectOptions{})
+	ctx, done := m.instrument(ctx, "get", objectName)
+	var obj *minioLib.Object
+	var info minioLib.ObjectInfo
+	err := m.withRetry(ctx, "get", true, func() error {
+		var err error
+		obj, err = m.client.GetObject(ctx, m.bucketName, objectName, minioLib.GetObjectOptions{ServerSideEncryption: m.sse})
+		if err != nil {
+			return err
+		}
+		if info, err = obj.Stat(); err != nil {
+			obj.Close()
+			return notFound(err, objectName)
+		}
+		return nil
+	})
+	// The size of the object is counted once it is opened, as it is read by the caller
+	done(info.Size, err)
+	if errors.Is(err, minio.ErrObjectNotFound) {
+		return nil, err
+	}
 	if err != nil {
 		reqLogger.Error().Err(err).Str("object", objectName).Msg("Error getting image")
 		return nil, fmt.Errorf("error getting image: %w", err)
 	}
 
 	reqLogger.Debug().Str("object", objectName).Msg("Image retrieved successfully")
+	if checksum := info.UserMetadata[minio.ChecksumMetadata]; m.config.VerifyChecksums && checksum != "" {
+		return newVerifyingObject(obj, objectName, checksum, info.Size), nil
+	}
 	return obj, nil
 }
 
+// StatImage retrieves the metadata of an image in MinIO
+func (m *MinioClient) StatImage(ctx context.Context, objectName string) (*minio.ObjectInfo, error) {
+	reqLogger := logger.FromContext(ctx).With().Str("component", "minio-client").Logger()
+
+	ctx, done := m.instrument(ctx, "stat", objectName)
+	var info minioLib.ObjectInfo
+	err := m.withRetry(ctx, "stat", true, func() error {
+		var err error
+		info, err = m.client.StatObject(ctx, m.bucketName, objectName, minioLib.StatObjectOptions{ServerSideEncryption: m.sse})
+		return notFound(err, objectName)
+	})
+	done(0, err)
+	if err != nil {
+		if errors.Is(err, minio.ErrObjectNotFound) {
+			return nil, err
+		}
+		reqLogger.Error().Err(err).Str("object", objectName).Msg("Error getting image metadata")
+		return nil, fmt.Errorf("error getting image metadata: %w", err)
+	}
+
+	return &minio.ObjectInfo{
+		Size:         info.Size,
+		ContentType:  info.ContentType,
+		ETag:         info.ETag,
+		LastModified: info.LastModified,
+		Checksum:     info.UserMetadata[minio.ChecksumMetadata],
+	}, nil
+}
+
+// Ping checks that the buckets of all object classes exist and are reachable
+func (m *MinioClient) Ping(ctx context.Context) error {
+	for _, bucket := range m.buckets() {
+		var exists bool
+		err := m.withRetry(ctx, "ping", true, func() error {
+			var err error
+			exists, err = m.client.BucketExists(ctx, bucket)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("error checking bucket %s: %w", bucket, err)
+		}
+		if !exists {
+			return fmt.Errorf("bucket %s does not exist", bucket)
+		}
+	}
+	return nil
+}
+
 // DeleteImage deletes an image from MinIO
 func (m *MinioClient) DeleteImage(ctx context.Context, objectName string) error {
 	reqLogger := logger.FromContext(ctx).With().Str("component", "minio-client").Logger()
-	err := m.client.RemoveObject(ctx, m.bucketName, objectName, minioLib.RemoveObjectOptions{})
+	ctx, done := m.instrument(ctx, "delete", objectName)
+	// Removing an object that is already gone succeeds, so deletes are idempotent
+	err := m.withRetry(ctx, "delete", true, func() error {
+		return m.client.RemoveObject(ctx, m.bucketName, objectName, minioLib.RemoveObjectOptions{})
+	})
+	done(0, err)
 	if err != nil {
 		reqLogger.Error().Err(err).Str("object", objectName).Msg("Error deleting image")
 		return fmt.Errorf("error deleting image: %w", err)
@@ -108,12 +385,46 @@ func (m *MinioClient) DeleteImage(ctx context.Context, objectName string) error
 	return nil
 }
 
+// CopyObject copies an object within the bucket on the server, with its metadata and tags
+func (m *MinioClient) CopyObject(ctx context.Context, src, dst string) error {
+	reqLogger := logger.FromContext(ctx).With().Str("component", "minio-client").Logger()
+
+	srcOpts := minioLib.CopySrcOptions{Bucket: m.bucketName, Object: src}
+	if m.sse != nil && m.sse.Type() == encrypt.SSEC {
+		// The source is decrypted with the same customer key it was written with
+		srcOpts.Encryption = encrypt.SSECopy(m.sse)
+	}
+	dstOpts := minioLib.CopyDestOptions{Bucket: m.bucketName, Object: dst, Encryption: m.sse}
+
+	ctx, done := m.instrument(ctx, "copy", dst)
+	// Copying the same source again yields the same object
+	err := m.withRetry(ctx, "copy", true, func() error {
+		_, err := m.client.CopyObject(ctx, dstOpts, srcOpts)
+		return notFound(err, src)
+	})
+	// The copy happens on the server, so no bytes are transferred
+	done(0, err)
+	if err != nil {
+		reqLogger.Error().Err(err).Str("src", src).Str("dst", dst).Msg("Error copying object")
+		return fmt.Errorf("error copying object: %w", err)
+	}
+
+	reqLogger.Debug().Str("src", src).Str("dst", dst).Msg("Object copied successfully")
+	return nil
+}
+
 // GetImageURL generates a pre-signed URL for an image in MinIO
 func (m *MinioClient) GetImageURL(ctx context.Context, objectName string, expires time.Duration) (string, error) {
 	reqLogger := logger.FromContext(ctx).With().Str("component", "minio-client").Logger()
 
+	if m.sse != nil && m.sse.Type() == encrypt.SSEC {
+		return "", minio.ErrPresignUnavailable
+	}
+
 	reqLogger.Debug().Str("object", objectName).Msg("Generating pre-signed URL")
+	ctx, done := m.instrument(ctx, "presign", objectName)
 	url, err := m.client.PresignedGetObject(ctx, m.bucketName, objectName, expires, nil)
+	done(0, err)
 	if err != nil {
 		reqLogger.Error().Err(err).Str("object", objectName).Msg("Error generating pre-signed URL")
 		return "", fmt.Errorf("error generating pre-signed URL: %w", err)
@@ -123,31 +434,120 @@ func (m *MinioClient) GetImageURL(ctx context.Context, objectName string, expire
 	return url.String(), nil
 }
 
-// GenerateObjectName generates a unique object name
-func (m *MinioClient) GenerateObjectName(id uuid.UUID, fileName string) string {
-	ext := path.Ext(fileName)
-	base := strings.TrimSuffix(path.Base(fileName), ext)
-	sanitizedBase := sanitizeFileName(base)
-	return fmt.Sprintf("%s/%s%s", id.String(), sanitizedBase, ext)
+// ListObjects returns the names of all objects under prefix
+func (m *MinioClient) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	ctx, done := m.instrument(ctx, "list", prefix)
+	var names []string
+	err := m.withRetry(ctx, "list", true, func() error {
+		names = names[:0]
+		for obj := range m.client.ListObjects(ctx, m.bucketName, minioLib.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+			if obj.Err != nil {
+				return obj.Err
+			}
+			names = append(names, obj.Key)
+		}
+		return nil
+	})
+	done(0, err)
+	if err != nil {
+		return nil, fmt.Errorf("error listing objects: %w", err)
+	}
+	return names, nil
 }
 
-// Close closes the MinIO client connection
-func (m *MinioClient) Close() error {
-	return nil
+// ListenObjectCreated streams s3:ObjectCreated:* notifications for objects under prefix.
+// The channel is closed when ctx is cancelled.
+func (m *MinioClient) ListenObjectCreated(ctx context.Context, prefix string) <-chan minio.ObjectEvent {
+	events := make(chan minio.ObjectEvent)
+
+	go func() {
+		defer close(events)
+		for info := range m.client.ListenBucketNotification(ctx, m.bucketName, prefix, "", []string{string(notification.ObjectCreatedAll)}) {
+			if info.Err != nil {
+				select {
+				case events <- minio.ObjectEvent{Err: fmt.Errorf("error listening for bucket notifications: %w", info.Err)}:
+				case <-ctx.Done():
+				}
+				return
+			}
+			for _, record := range info.Records {
+				// Keys in notifications are URL encoded
+				key, err := url.QueryUnescape(record.S3.Object.Key)
+				if err != nil {
+					key = record.S3.Object.Key
+				}
+				select {
+				case events <- minio.ObjectEvent{Key: key, Size: record.S3.Object.Size}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return events
 }
 
-// sanitizeFileName sanitizes a file name for storage
-func sanitizeFileName(fileName string) string {
-	// Replace special characters with underscores
-	fileName = strings.ReplaceAll(fileName, " ", "_")
+// Lifecycle returns the lifecycle rules applied to the bucket
+func (m *MinioClient) Lifecycle(ctx context.Context) ([]minio.LifecycleRule, error) {
+	var cfg *lifecycle.Configuration
+	err := m.withRetry(ctx, "lifecycle", true, func() error {
+		var err error
+		cfg, err = m.client.GetBucketLifecycle(ctx, m.bucketName)
+		return err
+	})
+	if err != nil {
+		if minioLib.ToErrorResponse(err).Code == "NoSuchLifecycleConfiguration" {
+			return []minio.LifecycleRule{}, nil
+		}
+		return nil, fmt.Errorf("error getting bucket lifecycle: %w", err)
+	}
 
-	// Remove any special characters
-	fileName = strings.Map(func(r rune) rune {
-		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' || r == '-' || r == '.' {
-			return r
+	rules := make([]minio.LifecycleRule, 0, len(cfg.Rules))
+	for _, r := range cfg.Rules {
+		if r.Status != "Enabled" {
+			continue
+		}
+		rule := minio.LifecycleRule{
+			ID:             r.ID,
+			Prefix:         r.RuleFilter.Prefix,
+			TransitionDays: int(r.Transition.Days),
+			StorageClass:   r.Transition.StorageClass,
+			ExpirationDays: int(r.Expiration.Days),
 		}
-		return -1
-	}, fileName)
+		if rule.Prefix == "" {
+			rule.Prefix = r.Prefix
+		}
+		if !r.RuleFilter.Tag.IsEmpty() {
+			rule.Tag = r.RuleFilter.Tag.Key + "=" + r.RuleFilter.Tag.Value
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// notFound replaces a NoSuchKey error by minio.ErrObjectNotFound
+func notFound(err error, objectName string) error {
+	if err != nil && minioLib.ToErrorResponse(err).Code == "NoSuchKey" {
+		return fmt.Errorf("%w: %s", minio.ErrObjectNotFound, objectName)
+	}
+	return err
+}
+
+// Close closes the MinIO client connection
+func (m *MinioClient) Close() error {
+	return nil
+}
 
-	return fileName
+// publicReadPolicy returns a bucket policy that allows anonymous reads of optimized objects only
+func publicReadPolicy(bucket string) string {
+	return fmt.Sprintf(`{
+	"Version": "2012-10-17",
+	"Statement": [{
+		"Effect": "Allow",
+		"Principal": {"AWS": ["*"]},
+		"Action": ["s3:GetObject"],
+		"Resource": ["arn:aws:s3:::%s/*/optimized*"]
+	}]
+}`, bucket)
 }
@@ -0,0 +1,189 @@
+package minio
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+)
+
+// Naming strategies selected with MINIO_NAMING_STRATEGY
+const (
+	// NamingUUID stores every object of an image under <id>/
+	NamingUUID = "uuid"
+	// NamingDate partitions images by upload date under yyyy/mm/dd/<id>/, so that large
+	// buckets can be listed and expired by day
+	NamingDate = "date"
+	// NamingHash stores objects under the SHA-256 of their content, so identical originals
+	// and identical variants are stored once
+	NamingHash = "hash"
+)
+
+// Variant naming schemes selected with MINIO_VARIANT_NAMING
+const (
+	// VariantNamingSized names derived objects <variant>-<w>x<h>.<format>
+	VariantNamingSized = "sized"
+	// VariantNamingPlain names derived objects <variant>.<format>, as before sized names
+	// were introduced; outputs of one variant in several sizes overwrite each other
+	VariantNamingPlain = "plain"
+)
+
+// NewNamer returns the namer for a naming strategy
+func NewNamer(strategy string) (minio.Namer, error) {
+	switch strategy {
+	case NamingUUID, "":
+		return uuidNamer{}, nil
+	case NamingDate:
+		return dateNamer{now: time.Now}, nil
+	case NamingHash:
+		return hashNamer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown naming strategy %q, expected uuid, date or hash", strategy)
+	}
+}
+
+// ConfiguredNamer returns the namer of the naming strategy of cfg, placing objects under
+// the configured prefixes and new originals in the upload quarantine
+func ConfiguredNamer(cfg *config.MinIOConfig) (minio.Namer, error) {
+	namer, err := NewNamer(cfg.NamingStrategy)
+	if err != nil {
+		return nil, err
+	}
+
+	// Originals and derived objects may be kept under their own prefixes
+	if cfg.OriginalsPrefix != "" || cfg.OptimizedPrefix != "" {
+		namer = prefixNamer{Namer: namer, originals: cfg.OriginalsPrefix, optimized: cfg.OptimizedPrefix}
+	}
+
+	// Derived objects may keep the names without size
+	if cfg.VariantNaming == VariantNamingPlain {
+		namer = plainNamer{Namer: namer}
+	}
+
+	// New originals wait in the upload quarantine until their image is processed
+	if cfg.UploadQuarantinePrefix != "" {
+		namer = quarantineNamer{Namer: namer, prefix: cfg.UploadQuarantinePrefix}
+	}
+	return namer, nil
+}
+
+// uuidNamer names objects <id>/<file name> and <id>/<variant file name>
+type uuidNamer struct{}
+
+func (uuidNamer) GenerateObjectName(id uuid.UUID, fileName string, _ io.ReadSeeker) (string, error) {
+	return path.Join(id.String(), sanitizedName(fileName)), nil
+}
+
+func (uuidNamer) DerivedObjectName(originalPath string, id uuid.UUID, variant minio.Variant, _ []byte) string {
+	return siblingName(originalPath, id, variant)
+}
+
+func (uuidNamer) ContentAddressed() bool { return false }
+
+// dateNamer names objects yyyy/mm/dd/<id>/<file name>; derived objects share the
+// partition of their original
+type dateNamer struct {
+	now func() time.Time
+}
+
+func (n dateNamer) GenerateObjectName(id uuid.UUID, fileName string, _ io.ReadSeeker) (string, error) {
+	return path.Join(n.now().UTC().Format("2006/01/02"), id.String(), sanitizedName(fileName)), nil
+}
+
+func (dateNamer) DerivedObjectName(originalPath string, id uuid.UUID, variant minio.Variant, _ []byte) string {
+	return siblingName(originalPath, id, variant)
+}
+
+func (dateNamer) ContentAddressed() bool { return false }
+
+// hashNamer names objects aa/<sha256>/original<ext> and aa/<sha256>/<variant file name>,
+// where aa is the first byte of the hash, to spread keys over prefixes
+type hashNamer struct{}
+
+func (hashNamer) GenerateObjectName(_ uuid.UUID, fileName string, content io.ReadSeeker) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return "", fmt.Errorf("error hashing image: %w", err)
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("error rewinding image: %w", err)
+	}
+	return hashName(hex.EncodeToString(h.Sum(nil)), "original"+strings.ToLower(path.Ext(fileName))), nil
+}
+
+func (hashNamer) DerivedObjectName(_ string, _ uuid.UUID, variant minio.Variant, content []byte) string {
+	sum := sha256.Sum256(content)
+	return hashName(hex.EncodeToString(sum[:]), variant.FileName())
+}
+
+func (hashNamer) ContentAddressed() bool { return true }
+
+func hashName(sum, name string) string {
+	return path.Join(sum[:2], sum, name)
+}
+
+// prefixNamer places the objects named by another namer under a prefix for originals
+// and one for derived objects
+type prefixNamer struct {
+	minio.Namer
+	originals string
+	optimized string
+}
+
+func (n prefixNamer) GenerateObjectName(id uuid.UUID, fileName string, content io.ReadSeeker) (string, error) {
+	name, err := n.Namer.GenerateObjectName(id, fileName, content)
+	if err != nil {
+		return "", err
+	}
+	return n.originals + name, nil
+}
+
+func (n prefixNamer) DerivedObjectName(originalPath string, id uuid.UUID, variant minio.Variant, content []byte) string {
+	return n.optimized + n.Namer.DerivedObjectName(strings.TrimPrefix(originalPath, n.originals), id, variant, content)
+}
+
+// quarantineNamer places new originals in the upload quarantine, and names the objects
+// derived from them as if they had been promoted, so those never expire with it
+type quarantineNamer struct {
+	minio.Namer
+	prefix string
+}
+
+func (n quarantineNamer) GenerateObjectName(id uuid.UUID, fileName string, content io.ReadSeeker) (string, error) {
+	name, err := n.Namer.GenerateObjectName(id, fileName, content)
+	if err != nil {
+		return "", err
+	}
+	return n.prefix + name, nil
+}
+
+func (n quarantineNamer) DerivedObjectName(originalPath string, id uuid.UUID, variant minio.Variant, content []byte) string {
+	return n.Namer.DerivedObjectName(strings.TrimPrefix(originalPath, n.prefix), id, variant, content)
+}
+
+// plainNamer names derived objects without their size
+type plainNamer struct {
+	minio.Namer
+}
+
+func (n plainNamer) DerivedObjectName(originalPath string, id uuid.UUID, variant minio.Variant, content []byte) string {
+	variant.Width, variant.Height = 0, 0
+	return n.Namer.DerivedObjectName(originalPath, id, variant, content)
+}
+
+// siblingName names a derived object in the directory of its original. Originals
+// without a directory fall back to <id>/.
+func siblingName(originalPath string, id uuid.UUID, variant minio.Variant) string {
+	dir := path.Dir(originalPath)
+	if dir == "." {
+		dir = id.String()
+	}
+	return path.Join(dir, variant.FileName())
+}
@@ -0,0 +1,115 @@
+package minio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	minioLib "github.com/minio/minio-go/v7"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// retryableCodes are the S3 error codes of transient server-side failures
+var retryableCodes = map[string]bool{
+	"RequestTimeout":     true,
+	"RequestError":       true,
+	"InternalError":      true,
+	"ServiceUnavailable": true,
+	"SlowDown":           true,
+	"Throttling":         true,
+}
+
+// withRetry calls fn until it succeeds, fails permanently or MINIO_RETRY_MAX_ATTEMPTS
+// attempts were made. Calls that are not idempotent, such as uploads from a reader that
+// can't be rewound, pass idempotent=false and are attempted once. Every attempt is
+// counted in image_optimizer_storage_attempts_total, and every retry is an event of the
+// span of ctx.
+func (m *MinioClient) withRetry(ctx context.Context, op string, idempotent bool, fn func() error) error {
+	attempts := 1
+	if idempotent {
+		attempts = max(m.config.RetryAttempts, 1)
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			metrics.StorageAttemptsTotal.WithLabelValues(op, "success").Inc()
+			return nil
+		}
+		if attempt >= attempts || ctx.Err() != nil || !m.retryable(err) {
+			metrics.StorageAttemptsTotal.WithLabelValues(op, "failure").Inc()
+			return err
+		}
+		metrics.StorageAttemptsTotal.WithLabelValues(op, "retry").Inc()
+
+		delay := m.backoff.Delay(attempt)
+		trace.SpanFromContext(ctx).AddEvent("retry", trace.WithAttributes(
+			attribute.Int("attempt", attempt),
+			attribute.String("error", err.Error()),
+		))
+		reqLogger := logger.FromContext(ctx)
+		reqLogger.Warn().
+			Err(err).
+			Str("component", "minio-client").
+			Str("operation", op).
+			Int("attempt", attempt).
+			Dur("retry_delay", delay).
+			Msg("Storage call failed, retrying")
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// retryable reports whether err is a transient failure: a network error, a throttled
+// request or a 5xx response. Other errors, such as a missing object or denied access,
+// won't go away by retrying, except an access denied with credentials that were
+// invalidated in the meantime.
+func (m *MinioClient) retryable(err error) bool {
+	var resp minioLib.ErrorResponse
+	if errors.As(err, &resp) {
+		switch {
+		case retryableCodes[resp.Code]:
+			return true
+		case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+			return true
+		case resp.StatusCode == http.StatusForbidden:
+			return m.config.Credentials != nil && m.config.Credentials.Stale()
+		default:
+			return false
+		}
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// rewinder returns a function that rewinds reader to its current offset before each
+// upload attempt, and whether reader can be rewound at all
+func rewinder(reader io.Reader) (func() error, bool) {
+	seeker, ok := reader.(io.Seeker)
+	if !ok {
+		return func() error { return nil }, false
+	}
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return func() error { return nil }, false
+	}
+	return func() error {
+		_, err := seeker.Seek(start, io.SeekStart)
+		return err
+	}, true
+}
@@ -0,0 +1,62 @@
+package minio
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	minioLib "github.com/minio/minio-go/v7"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+)
+
+// verifyingObject checks an object read from start to end against the checksum in its
+// metadata: the read reaching the end, or the last byte for readers that stop at the
+// object size like io.CopyN, fails with minio.ErrChecksumMismatch if the content doesn't
+// match. Partial reads after seeking elsewhere, as done for range
+// requests, are not verified.
+type verifyingObject struct {
+	*minioLib.Object
+	name     string
+	expected string
+	size     int64
+	read     int64
+	hash     hash.Hash
+}
+
+func newVerifyingObject(obj *minioLib.Object, name, expected string, size int64) *verifyingObject {
+	return &verifyingObject{Object: obj, name: name, expected: expected, size: size, hash: sha256.New()}
+}
+
+func (o *verifyingObject) Read(p []byte) (int, error) {
+	n, err := o.Object.Read(p)
+	if o.hash == nil {
+		return n, err
+	}
+
+	o.hash.Write(p[:n])
+	o.read += int64(n)
+	if (err == io.EOF || o.read >= o.size) && hex.EncodeToString(o.hash.Sum(nil)) != o.expected {
+		o.hash = nil
+		return n, fmt.Errorf("%w: %s", minio.ErrChecksumMismatch, o.name)
+	}
+	return n, err
+}
+
+func (o *verifyingObject) Seek(offset int64, whence int) (int64, error) {
+	pos, err := o.Object.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+
+	switch {
+	case pos == 0:
+		// Reading from the start again, as http.ServeContent does after sizing the object
+		o.hash = sha256.New()
+		o.read = 0
+	case whence != io.SeekEnd || offset != 0:
+		o.hash = nil
+	}
+	return pos, nil
+}
@@ -0,0 +1,115 @@
+// Package resilient wraps a minio.Client with a circuit breaker
+package resilient
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+	"github.com/not-nullexception/image-optimizer/internal/resilience"
+)
+
+// Client calls another minio.Client through a circuit breaker, so requests fail fast
+// while storage is down instead of piling up. Each call is let through once: the
+// retries of the underlying client count as a single call.
+type Client struct {
+	minio.Client
+
+	policy *resilience.Policy
+}
+
+// NewClient wraps next with a circuit breaker
+func NewClient(next minio.Client, cfg *config.ResilienceConfig) minio.Client {
+	initLogger := logger.GetLogger("resilient-minio-client")
+	initLogger.Info().
+		Int("failure_threshold", cfg.FailureThreshold).
+		Dur("open_timeout", cfg.OpenTimeout).
+		Msg("Storage circuit breaker enabled")
+
+	return &Client{
+		Client: next,
+		policy: resilience.NewPolicy("minio", cfg, func(err error) bool {
+			return errors.Is(err, minio.ErrObjectNotFound)
+		}),
+	}
+}
+
+func (c *Client) UploadImage(ctx context.Context, reader io.Reader, objectName string, contentType string) error {
+	return c.policy.Call(ctx, func() error {
+		return c.Client.UploadImage(ctx, reader, objectName, contentType)
+	})
+}
+
+func (c *Client) UploadOriginal(ctx context.Context, reader io.Reader, objectName string, contentType string) error {
+	return c.policy.Call(ctx, func() error {
+		return c.Client.UploadOriginal(ctx, reader, objectName, contentType)
+	})
+}
+
+func (c *Client) GetImage(ctx context.Context, objectName string) (io.ReadCloser, error) {
+	var obj io.ReadCloser
+	err := c.policy.Call(ctx, func() error {
+		var err error
+		obj, err = c.Client.GetImage(ctx, objectName)
+		return err
+	})
+	return obj, err
+}
+
+func (c *Client) StatImage(ctx context.Context, objectName string) (*minio.ObjectInfo, error) {
+	var info *minio.ObjectInfo
+	err := c.policy.Call(ctx, func() error {
+		var err error
+		info, err = c.Client.StatImage(ctx, objectName)
+		return err
+	})
+	return info, err
+}
+
+func (c *Client) DeleteImage(ctx context.Context, objectName string) error {
+	return c.policy.Call(ctx, func() error {
+		return c.Client.DeleteImage(ctx, objectName)
+	})
+}
+
+func (c *Client) CopyObject(ctx context.Context, src, dst string) error {
+	return c.policy.Call(ctx, func() error {
+		return c.Client.CopyObject(ctx, src, dst)
+	})
+}
+
+func (c *Client) ListObjects(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := c.policy.Call(ctx, func() error {
+		var err error
+		names, err = c.Client.ListObjects(ctx, prefix)
+		return err
+	})
+	return names, err
+}
+
+func (c *Client) Lifecycle(ctx context.Context) ([]minio.LifecycleRule, error) {
+	var rules []minio.LifecycleRule
+	err := c.policy.Call(ctx, func() error {
+		var err error
+		rules, err = c.Client.Lifecycle(ctx)
+		return err
+	})
+	return rules, err
+}
+
+// In returns the client of class behind the same circuit breaker, since all buckets
+// are served by the same MinIO
+func (c *Client) In(class minio.Class) minio.Client {
+	return &Client{Client: c.Client.In(class), policy: c.policy}
+}
+
+// Ping goes through the breaker so that readiness reflects an open circuit
+func (c *Client) Ping(ctx context.Context) error {
+	return c.policy.Call(ctx, func() error {
+		return c.Client.Ping(ctx)
+	})
+}
@@ -0,0 +1,39 @@
+package moderation
+
+import (
+	"context"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// Verdict is the outcome of classifying an image
+type Verdict struct {
+	Flagged bool     `json:"flagged"`
+	Score   float64  `json:"score"`
+	Labels  []string `json:"labels,omitempty"`
+}
+
+// Classifier decides whether an image contains content that must not be published
+type Classifier interface {
+	Classify(ctx context.Context, data []byte, contentType string) (*Verdict, error)
+}
+
+// NewClassifier returns the Classifier for the configured provider,
+// or nil if moderation is disabled.
+func NewClassifier(cfg *config.ModerationConfig) Classifier {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	initLogger := logger.GetLogger("moderation")
+
+	switch cfg.Provider {
+	case "http":
+		initLogger.Info().Str("provider", cfg.Provider).Float64("threshold", cfg.Threshold).Msg("Content moderation enabled")
+		return newHTTPClassifier(cfg)
+	default:
+		initLogger.Warn().Str("provider", cfg.Provider).Msg("Unknown moderation provider, moderation disabled")
+		return nil
+	}
+}
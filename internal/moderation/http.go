@@ -0,0 +1,72 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// httpClassifier sends the image to an external classification service, which can
+// front a local model or a third-party moderation API. The service receives the raw
+// image bytes and must answer with a JSON body of the form {"score": 0.93, "labels": ["nsfw"]}.
+type httpClassifier struct {
+	url       string
+	token     string
+	threshold float64
+	client    *http.Client
+}
+
+func newHTTPClassifier(cfg *config.ModerationConfig) *httpClassifier {
+	return &httpClassifier{
+		url:       cfg.URL,
+		token:     cfg.Token,
+		threshold: cfg.Threshold,
+		client:    &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+// Classify sends the image to the classification service and flags it if the score
+// reaches the configured threshold
+func (h *httpClassifier) Classify(ctx context.Context, data []byte, contentType string) (*Verdict, error) {
+	reqLogger := logger.FromContext(ctx).With().Str("component", "moderation-http").Logger()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error creating moderation request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error calling moderation service")
+		return nil, fmt.Errorf("error calling moderation service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		reqLogger.Error().Int("status", resp.StatusCode).Msg("Moderation service returned an error")
+		return nil, fmt.Errorf("moderation service returned status %d", resp.StatusCode)
+	}
+
+	var verdict Verdict
+	if err := json.NewDecoder(resp.Body).Decode(&verdict); err != nil {
+		return nil, fmt.Errorf("error decoding moderation response: %w", err)
+	}
+	verdict.Flagged = verdict.Flagged || verdict.Score >= h.threshold
+
+	reqLogger.Debug().
+		Bool("flagged", verdict.Flagged).
+		Float64("score", verdict.Score).
+		Strs("labels", verdict.Labels).
+		Msg("Image classified")
+
+	return &verdict, nil
+}
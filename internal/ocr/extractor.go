@@ -0,0 +1,35 @@
+package ocr
+
+import (
+	"context"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// Extractor extracts text from images
+type Extractor interface {
+	ExtractText(ctx context.Context, data []byte, contentType string) (string, error)
+}
+
+// NewExtractor returns the Extractor for the configured provider,
+// or nil if OCR is disabled.
+func NewExtractor(cfg *config.OCRConfig) Extractor {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	initLogger := logger.GetLogger("ocr")
+
+	switch cfg.Provider {
+	case "tesseract":
+		initLogger.Info().Str("provider", cfg.Provider).Str("language", cfg.Language).Msg("OCR enabled")
+		return newTesseractExtractor(cfg)
+	case "http":
+		initLogger.Info().Str("provider", cfg.Provider).Msg("OCR enabled")
+		return newHTTPExtractor(cfg)
+	default:
+		initLogger.Warn().Str("provider", cfg.Provider).Msg("Unknown OCR provider, OCR disabled")
+		return nil
+	}
+}
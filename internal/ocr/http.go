@@ -0,0 +1,66 @@
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// httpExtractor sends the image to an external OCR service, which receives the raw
+// image bytes and must answer with a JSON body of the form {"text": "..."}
+type httpExtractor struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+type httpResponse struct {
+	Text string `json:"text"`
+}
+
+func newHTTPExtractor(cfg *config.OCRConfig) *httpExtractor {
+	return &httpExtractor{
+		url:    cfg.URL,
+		token:  cfg.Token,
+		client: &http.Client{Timeout: cfg.Timeout},
+	}
+}
+
+// ExtractText sends the image to the OCR service and returns the recognised text
+func (h *httpExtractor) ExtractText(ctx context.Context, data []byte, contentType string) (string, error) {
+	reqLogger := logger.FromContext(ctx).With().Str("component", "ocr-http").Logger()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("error creating OCR request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Error calling OCR service")
+		return "", fmt.Errorf("error calling OCR service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		reqLogger.Error().Int("status", resp.StatusCode).Msg("OCR service returned an error")
+		return "", fmt.Errorf("OCR service returned status %d", resp.StatusCode)
+	}
+
+	var result httpResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("error decoding OCR response: %w", err)
+	}
+
+	reqLogger.Debug().Int("text_length", len(result.Text)).Msg("Text extracted")
+	return result.Text, nil
+}
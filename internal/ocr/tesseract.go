@@ -0,0 +1,51 @@
+package ocr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// tesseractExtractor runs the tesseract CLI, which must be installed in the worker image
+type tesseractExtractor struct {
+	binary   string
+	language string
+	timeout  time.Duration
+}
+
+func newTesseractExtractor(cfg *config.OCRConfig) *tesseractExtractor {
+	return &tesseractExtractor{
+		binary:   cfg.TesseractPath,
+		language: cfg.Language,
+		timeout:  cfg.Timeout,
+	}
+}
+
+// ExtractText pipes the image through tesseract and returns the recognised text
+func (t *tesseractExtractor) ExtractText(ctx context.Context, data []byte, contentType string) (string, error) {
+	reqLogger := logger.FromContext(ctx).With().Str("component", "ocr-tesseract").Logger()
+
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.binary, "stdin", "stdout", "-l", t.language)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		reqLogger.Error().Err(err).Str("stderr", stderr.String()).Msg("Tesseract failed")
+		return "", fmt.Errorf("error running tesseract: %w", err)
+	}
+
+	text := strings.TrimSpace(stdout.String())
+	reqLogger.Debug().Int("text_length", len(text)).Msg("Text extracted")
+	return text, nil
+}
@@ -0,0 +1,199 @@
+// Package outbox persists queue tasks that could not be published and re-publishes
+// them once the queue is reachable again.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
+	"github.com/rs/zerolog"
+)
+
+// Relay stores unpublished tasks in the database and periodically re-publishes them
+type Relay struct {
+	repo        db.Repository
+	queueClient rabbitmq.Client
+	config      *config.OutboxConfig
+	logger      zerolog.Logger
+}
+
+// NewRelay creates a new Relay
+func NewRelay(repo db.Repository, queueClient rabbitmq.Client, cfg *config.OutboxConfig) *Relay {
+	return &Relay{
+		repo:        repo,
+		queueClient: queueClient,
+		config:      cfg,
+		logger:      logger.GetLogger("outbox-relay"),
+	}
+}
+
+// Publish sends task to the queue. If that fails the task is stored in the outbox and
+// published reports false; an error is only returned if the task could not be stored either.
+func (r *Relay) Publish(ctx context.Context, imageID uuid.UUID, task rabbitmq.Task) (published bool, err error) {
+	if task.RequestID == "" {
+		task.RequestID = logger.RequestIDFromContext(ctx)
+	}
+
+	publishErr := r.queueClient.Publish(ctx, task)
+	if publishErr == nil {
+		return true, nil
+	}
+
+	payload, err := json.Marshal(task)
+	if err != nil {
+		return false, fmt.Errorf("error encoding task for outbox: %w", err)
+	}
+
+	now := time.Now()
+	err = r.repo.SaveOutboxTask(ctx, &models.OutboxTask{
+		ImageID:       imageID,
+		Payload:       payload,
+		LastError:     publishErr.Error(),
+		NextAttemptAt: now.Add(r.config.RelayInterval),
+		CreatedAt:     now,
+	})
+	if err != nil {
+		return false, fmt.Errorf("error publishing task (%v) and storing it in the outbox: %w", publishErr, err)
+	}
+
+	msg := "Queue unavailable, task stored in outbox"
+	switch {
+	case errors.Is(publishErr, rabbitmq.ErrNotConfirmed):
+		msg = "Task not confirmed by the broker, stored in outbox"
+	case errors.Is(publishErr, rabbitmq.ErrUnroutable):
+		msg = "Task not routed to any queue, stored in outbox"
+	}
+	reqLogger := logger.FromContext(ctx)
+	reqLogger.Warn().Err(publishErr).Str("image_id", imageID.String()).Str("task_type", string(task.Type)).Msg(msg)
+	return false, nil
+}
+
+// Schedule stores task in the outbox to be published once delay elapsed, as the fallback
+// of a task run outside the queue. Done removes it once that run finished.
+func (r *Relay) Schedule(ctx context.Context, imageID uuid.UUID, task rabbitmq.Task, delay time.Duration) (int64, error) {
+	if task.RequestID == "" {
+		task.RequestID = logger.RequestIDFromContext(ctx)
+	}
+
+	payload, err := json.Marshal(task)
+	if err != nil {
+		return 0, fmt.Errorf("error encoding task for outbox: %w", err)
+	}
+
+	now := time.Now()
+	outboxTask := &models.OutboxTask{
+		ImageID:       imageID,
+		Payload:       payload,
+		NextAttemptAt: now.Add(delay),
+		CreatedAt:     now,
+	}
+	if err := r.repo.SaveOutboxTask(ctx, outboxTask); err != nil {
+		return 0, fmt.Errorf("error storing task in the outbox: %w", err)
+	}
+	return outboxTask.ID, nil
+}
+
+// Done removes a task stored by Schedule
+func (r *Relay) Done(ctx context.Context, id int64) error {
+	if err := r.repo.DeleteOutboxTask(ctx, id); err != nil {
+		return fmt.Errorf("error removing task from the outbox: %w", err)
+	}
+	return nil
+}
+
+// Run re-publishes due outbox tasks every RelayInterval until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	r.logger.Info().Dur("interval", r.config.RelayInterval).Msg("Starting outbox relay")
+
+	ticker := time.NewTicker(r.config.RelayInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Info().Msg("Outbox relay stopped")
+			return
+		case <-ticker.C:
+			r.flush(logger.ToContext(ctx, r.logger))
+		}
+	}
+}
+
+// flush publishes one batch of due tasks
+func (r *Relay) flush(ctx context.Context) {
+	tasks, err := r.repo.ClaimOutboxTasks(ctx, r.config.BatchSize, r.config.RelayInterval)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to claim outbox tasks")
+		return
+	}
+
+	for _, outboxTask := range tasks {
+		r.relay(ctx, outboxTask)
+	}
+}
+
+// relay publishes a single outbox task, rescheduling it with exponential backoff on failure
+func (r *Relay) relay(ctx context.Context, outboxTask *models.OutboxTask) {
+	taskLogger := r.logger.With().Int64("outbox_id", outboxTask.ID).Str("image_id", outboxTask.ImageID.String()).Logger()
+
+	var task rabbitmq.Task
+	if err := json.Unmarshal(outboxTask.Payload, &task); err != nil {
+		// A task that cannot be decoded will never succeed
+		taskLogger.Error().Err(err).Msg("Dropping undecodable outbox task")
+		if err := r.repo.DeleteOutboxTask(ctx, outboxTask.ID); err != nil {
+			taskLogger.Error().Err(err).Msg("Failed to delete undecodable outbox task")
+		}
+		return
+	}
+	if task.RequestID != "" {
+		taskLogger = taskLogger.With().Str("request_id", task.RequestID).Logger()
+	}
+
+	// Reset the status before publishing so it cannot overwrite a worker that already picked the task up
+	resize := task.Type == rabbitmq.TaskTypeResizeImage
+	if resize {
+		if err := r.repo.UpdateImageStatus(ctx, outboxTask.ImageID, models.StatusPending, ""); err != nil {
+			taskLogger.Error().Err(err).Msg("Failed to reset image status before publishing outbox task")
+			return
+		}
+	}
+
+	if err := r.queueClient.Publish(ctx, task); err != nil {
+		if resize {
+			if err := r.repo.UpdateImageStatus(ctx, outboxTask.ImageID, models.StatusQueueFailed, "processing queue unavailable, task will be retried"); err != nil {
+				taskLogger.Error().Err(err).Msg("Failed to restore queued_failed status")
+			}
+		}
+		next := time.Now().Add(r.backoff(outboxTask.Attempts + 1))
+		taskLogger.Warn().Err(err).Int("attempts", outboxTask.Attempts+1).Time("next_attempt_at", next).Msg("Outbox task publish failed")
+		if err := r.repo.RescheduleOutboxTask(ctx, outboxTask.ID, err.Error(), next); err != nil {
+			taskLogger.Error().Err(err).Msg("Failed to reschedule outbox task")
+		}
+		return
+	}
+
+	if err := r.repo.DeleteOutboxTask(ctx, outboxTask.ID); err != nil {
+		// The task may be published again; workers tolerate reprocessing an image
+		taskLogger.Error().Err(err).Msg("Failed to delete published outbox task")
+	}
+
+	taskLogger.Info().Str("task_type", string(task.Type)).Msg("Outbox task published")
+}
+
+// backoff returns the delay before the given attempt, doubling from RelayInterval up to MaxBackoff
+func (r *Relay) backoff(attempt int) time.Duration {
+	delay := r.config.RelayInterval
+	for i := 1; i < attempt && delay < r.config.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, r.config.MaxBackoff)
+}
@@ -3,31 +3,127 @@ package image
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"image"
-	"image/jpeg"
-	"image/png"
 	"io"
-	"math"
-	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
-	"github.com/disintegration/imaging"
 	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/internal/faces"
 	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
 	"github.com/not-nullexception/image-optimizer/internal/minio"
-	"github.com/rs/zerolog"
+	"github.com/not-nullexception/image-optimizer/internal/moderation"
+	"github.com/not-nullexception/image-optimizer/pkg/optimizer"
 )
 
+// ErrContentFlagged is returned by ProcessImage when the moderation classifier flags an image
+var ErrContentFlagged = errors.New("content flagged by moderation")
+
+// ErrImageTooLarge is returned when decoding an image would exceed the memory budget
+var ErrImageTooLarge = optimizer.ErrImageTooLarge
+
+// ErrInvalidImage is returned when an image is corrupt or truncated
+var ErrInvalidImage = optimizer.ErrInvalidImage
+
+// ErrUnsupportedFormat is returned for images or outputs in a format that can't be encoded
+var ErrUnsupportedFormat = optimizer.ErrUnsupportedFormat
+
+// errRenditionUpload marks rendition errors caused by the upload rather than the encode
+var errRenditionUpload = errors.New("error uploading rendition")
+
+// Rendition describes an additional size encoded alongside the optimized image
+type Rendition = optimizer.Rendition
+
+// Placeholder holds the low-resolution previews computed from the original image
+type Placeholder = optimizer.Placeholder
+
+// Processor optimizes images stored in MinIO. The image work itself is done by
+// pkg/optimizer; the processor adds storage, moderation and metrics.
 type Processor struct {
 	minioClient minio.Client
-	logger      zerolog.Logger
+	classifier  moderation.Classifier
+	optimizer   *optimizer.Optimizer
+	opts        []optimizer.Option
+}
+
+// Option configures optional Processor behaviour
+type Option func(*Processor)
+
+// WithFaceDetector enables face-aware cropping and face blurring
+func WithFaceDetector(detector faces.Detector) Option {
+	return func(p *Processor) {
+		if detector != nil {
+			p.opts = append(p.opts, optimizer.WithFaceDetector(detector))
+		}
+	}
+}
+
+// WithClassifier enables a moderation check after decoding; flagged images are not published
+func WithClassifier(classifier moderation.Classifier) Option {
+	return func(p *Processor) {
+		p.classifier = classifier
+	}
+}
+
+// WithConcurrency sets how many renditions of one image are encoded in parallel
+func WithConcurrency(n int) Option {
+	return func(p *Processor) {
+		p.opts = append(p.opts, optimizer.WithConcurrency(n))
+	}
+}
+
+// WithMemoryLimitShare divides the GOMEMLIMIT budget between n images processed concurrently,
+// so each decode is only allowed its share of the limit
+func WithMemoryLimitShare(n int) Option {
+	return func(p *Processor) {
+		p.opts = append(p.opts, optimizer.WithMemoryLimitShare(n))
+	}
+}
+
+// WithQualityThreshold re-encodes JPEG images scoring below minScore SSIM, raising the
+// quality by step each time; a minScore of 0 only measures the score
+func WithQualityThreshold(minScore float64, step int) Option {
+	return func(p *Processor) {
+		p.opts = append(p.opts, optimizer.WithQualityThreshold(minScore, step))
+	}
+}
+
+// RenditionResult is the outcome of encoding and storing a single rendition. Err is set if
+// the rendition failed; other renditions are unaffected.
+type RenditionResult struct {
+	Name   string
+	Path   string
+	Size   int64
+	Width  int
+	Height int
+	// Format is the image format of the rendition, "jpeg" or "png"
+	Format string
+	Err    error
 }
 
 type ProcessingResult struct {
+	// Format is the image format of the optimized image, "jpeg" or "png"
+	Format          string
 	OptimizedPath   string
 	OptimizedSize   int64
 	OptimizedWidth  int
 	OptimizedHeight int
+	Moderation      *moderation.Verdict
+	Placeholder     Placeholder
+	Renditions      []RenditionResult
+	// QualityScore is the SSIM of the optimized image against the unencoded image
+	QualityScore float64
+}
+
+// RenderResult holds an image rendered in memory by Render
+type RenderResult struct {
+	Data        []byte
+	ContentType string
+	Width       int
+	Height      int
 }
 
 type Config struct {
@@ -35,204 +131,372 @@ type Config struct {
 	MaxHeight       int
 	Quality         int
 	OptimizeStorage bool
+	// Gravity crops the image to the MaxWidth x MaxHeight aspect ratio around
+	// the center or the detected faces; empty keeps the whole image
+	Gravity   string
+	BlurFaces bool
+	// Filter is the resampling filter used when resizing; empty selects Lanczos
+	Filter string
+	// Sharpen is the sigma of the unsharp mask applied after resizing; 0 disables it
+	Sharpen float64
+	// TargetSizeKB lowers the JPEG quality until the output fits in this many kilobytes,
+	// but not below MinQuality; 0 disables it
+	TargetSizeKB int
+	MinQuality   int
+	// Format encodes the image rendered by Render as "jpeg" or "png"; empty keeps the
+	// format of the original
+	Format string
+	// Renditions are additional sizes encoded in parallel from the same decoded image
+	Renditions []Rendition
+	// Version numbers the optimized object, so earlier versions are not overwritten; 0
+	// uses the unversioned name
+	Version int
+	// Progress is called with the percentage done as ProcessImage moves through its
+	// stages, concurrently while renditions are stored; nil disables it
+	Progress ProgressFunc
 }
 
-func New(minioClient minio.Client) *Processor {
-	return &Processor{
+// ProgressFunc reports the percentage done of ProcessImage and its current stage
+type ProgressFunc func(ctx context.Context, percent int, stage string)
+
+// Processing stages reported to a ProgressFunc
+const (
+	StageDownloading = "downloading"
+	StageOptimizing  = "optimizing"
+	StageRenditions  = "renditions"
+	StageUploading   = "uploading"
+)
+
+// report calls the Progress function of the config, if any
+func (c Config) report(ctx context.Context, percent int, stage string) {
+	if c.Progress != nil {
+		c.Progress(ctx, percent, stage)
+	}
+}
+
+// options converts the config to optimizer options
+func (c Config) options() optimizer.Options {
+	return optimizer.Options{
+		MaxWidth:     c.MaxWidth,
+		MaxHeight:    c.MaxHeight,
+		Quality:      c.Quality,
+		Gravity:      c.Gravity,
+		BlurFaces:    c.BlurFaces,
+		Filter:       c.Filter,
+		Sharpen:      c.Sharpen,
+		TargetSizeKB: c.TargetSizeKB,
+		MinQuality:   c.MinQuality,
+		Format:       c.Format,
+		Renditions:   c.Renditions,
+	}
+}
+
+func New(minioClient minio.Client, opts ...Option) *Processor {
+	p := &Processor{
 		minioClient: minioClient,
-		logger:      logger.GetLogger("image-processor"),
 	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+	p.optimizer = optimizer.New(p.opts...)
+
+	return p
 }
 
 // ProcessImage processes an image from MinIO
 func (p *Processor) ProcessImage(ctx context.Context, imageID uuid.UUID, originalPath string, filename string, config Config) (*ProcessingResult, error) {
 	reqLogger := logger.FromContext(ctx).With().Str("component", "image-processor").Logger()
+	ctx = reqLogger.WithContext(ctx)
 
 	reqLogger.Info().
 		Str("image_id", imageID.String()).
 		Str("path", originalPath).
+		Str("filename", filename).
 		Msg("Processing image")
 
 	// Get the image from MinIO
-	reader, err := p.minioClient.GetImage(ctx, originalPath)
+	config.report(ctx, 0, StageDownloading)
+	downloadStart := time.Now()
+	object, err := p.minioClient.In(minio.ClassOriginal).GetImage(ctx, originalPath)
 	if err != nil {
 		reqLogger.Error().Err(err).Msg("Failed to get image from MinIO")
 		return nil, fmt.Errorf("error getting image from MinIO: %w", err)
 	}
-	defer reader.Close()
-
-	// Read the entire image into memory
-	imgData, err := io.ReadAll(reader)
-	if err != nil {
-		reqLogger.Error().Err(err).Msg("Failed to read image data")
-		return nil, fmt.Errorf("error reading image data: %w", err)
-	}
-
-	// Decode the image
-	img, format, err := image.Decode(bytes.NewReader(imgData))
-	if err != nil {
-		reqLogger.Error().Err(err).Msg("Failed to decode image")
-		return nil, fmt.Errorf("error decoding image: %w", err)
+	defer object.Close()
+	// The optimizer reads the object as it downloads, so the download is timed by its reads
+	reader := &timedReader{r: object, elapsed: time.Since(downloadStart)}
+
+	opts := config.options()
+
+	// Run the moderation check before anything is published
+	var verdict *moderation.Verdict
+	if p.classifier != nil {
+		opts.Check = func(ctx context.Context, data []byte, format string) error {
+			var err error
+			verdict, err = p.classifier.Classify(ctx, data, "image/"+format)
+			if err != nil {
+				return fmt.Errorf("error classifying image: %w", err)
+			}
+			if verdict.Flagged {
+				reqLogger.Warn().
+					Str("image_id", imageID.String()).
+					Float64("score", verdict.Score).
+					Strs("labels", verdict.Labels).
+					Msg("Image flagged by moderation")
+				return ErrContentFlagged
+			}
+			return nil
+		}
 	}
 
-	// Get original dimensions
-	bounds := img.Bounds()
-	originalWidth := bounds.Dx()
-	originalHeight := bounds.Dy()
-
-	reqLogger.Debug().
-		Str("image_id", imageID.String()).
-		Str("format", format).
-		Int("original_width", originalWidth).
-		Int("original_height", originalHeight).
-		Int("original_size", len(imgData)).
-		Msg("Image details")
-
-	// Calculate new dimensions while maintaining aspect ratio
-	var newWidth, newHeight int
-	if config.MaxWidth > 0 && config.MaxHeight > 0 {
-		// Calculate scaling factors
-		widthFactor := float64(config.MaxWidth) / float64(originalWidth)
-		heightFactor := float64(config.MaxHeight) / float64(originalHeight)
-
-		// Use the smaller factor to ensure the image fits within the maximum dimensions
-		scaleFactor := math.Min(widthFactor, heightFactor)
-
-		// Only resize if the image is larger than the target dimensions
-		if scaleFactor < 1.0 {
-			newWidth = int(float64(originalWidth) * scaleFactor)
-			newHeight = int(float64(originalHeight) * scaleFactor)
-		} else {
-			// If the image is already smaller than the target dimensions, keep original size
-			newWidth = originalWidth
-			newHeight = originalHeight
+	// Upload renditions as they are encoded; they take up to 80% of the progress
+	var mu sync.Mutex
+	renditionPaths := make(map[string]string, len(opts.Renditions))
+	renditionCount := len(opts.Renditions)
+	opts.StoreRendition = func(ctx context.Context, r *optimizer.RenditionResult, data io.Reader) error {
+		content, err := io.ReadAll(data)
+		if err != nil {
+			return err
 		}
-	} else {
-		// If no maximum dimensions are specified, keep original size
-		newWidth = originalWidth
-		newHeight = originalHeight
+		variant := minio.Variant{Name: "optimized-" + r.Name, Width: r.Width, Height: r.Height, Format: contentFormat(r.ContentType)}
+		path := p.minioClient.DerivedObjectName(originalPath, imageID, variant, content)
+		if err := minio.Store(ctx, p.minioClient.In(minio.ClassOptimized), bytes.NewReader(content), path, r.ContentType); err != nil {
+			return fmt.Errorf("%w %s: %w", errRenditionUpload, r.Name, err)
+		}
+		mu.Lock()
+		renditionPaths[r.Name] = path
+		stored := len(renditionPaths)
+		mu.Unlock()
+		config.report(ctx, 20+60*stored/renditionCount, StageRenditions)
+		return nil
 	}
 
-	// Resize the image if needed
-	var resizedImg image.Image
-	if newWidth != originalWidth || newHeight != originalHeight {
-		resizedImg = imaging.Resize(img, newWidth, newHeight, imaging.Lanczos)
-		reqLogger.Debug().
-			Str("image_id", imageID.String()).
-			Int("new_width", newWidth).
-			Int("new_height", newHeight).
-			Msg("Image resized")
-	} else {
-		resizedImg = img
-		reqLogger.Debug().
-			Str("image_id", imageID.String()).
-			Msg("No resizing needed")
+	config.report(ctx, 10, StageOptimizing)
+	result, output, err := p.optimizer.Optimize(ctx, reader, opts)
+	if errors.Is(err, ErrContentFlagged) {
+		return &ProcessingResult{Moderation: verdict}, err
 	}
-
-	// Create a buffer to hold the processed image
-	var buf bytes.Buffer
-
-	// Set quality and encode the image based on format
-	var processingErr error
-	var contentType string
-
-	// Generate unique path for the processed image
-	ext := filepath.Ext(filename)
-	optimizedPath := fmt.Sprintf("%s/optimized%s", imageID.String(), ext)
-
-	switch format {
-	case "jpeg":
-		contentType = "image/jpeg"
-		processingErr = jpeg.Encode(&buf, resizedImg, &jpeg.Options{
-			Quality: config.Quality,
-		})
-	case "png":
-		contentType = "image/png"
-		encoder := png.Encoder{
-			CompressionLevel: png.BestCompression,
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to optimize image")
+		return nil, err
+	}
+	recordStage(ctx, stageDownload, reader.elapsed)
+	recordStage(ctx, stageDecode, result.Timings.Decode)
+	recordStage(ctx, stageResize, result.Timings.Resize)
+	recordStage(ctx, stageEncode, result.Timings.Encode)
+
+	renditions := make([]RenditionResult, 0, len(result.Renditions))
+	for _, r := range result.Renditions {
+		status := "success"
+		if errors.Is(r.Err, errRenditionUpload) {
+			status = "upload_error"
+		} else if r.Err != nil {
+			status = "encode_error"
 		}
-		processingErr = encoder.Encode(&buf, resizedImg)
-	default:
-		reqLogger.Info().Msg("Unsupported image format")
-		return nil, fmt.Errorf("unsupported image format: %s", format)
+		metrics.RecordRendition(ctx, status, time.Now().Add(-r.Duration))
+
+		renditions = append(renditions, RenditionResult{
+			Name:   r.Name,
+			Path:   renditionPaths[r.Name],
+			Size:   r.Size,
+			Width:  r.Width,
+			Height: r.Height,
+			Format: contentFormat(r.ContentType),
+			Err:    r.Err,
+		})
 	}
 
-	if processingErr != nil {
-		reqLogger.Error().Err(processingErr).Msg("Failed to encode processed image")
-		return nil, fmt.Errorf("error encoding processed image: %w", processingErr)
-	}
+	// Only upload if the processed image is smaller than the original or if we forced resizing or editing
+	if result.Improved || config.OptimizeStorage {
+		content, err := io.ReadAll(output)
+		if err != nil {
+			return nil, fmt.Errorf("error reading processed image: %w", err)
+		}
 
-	// Get the processed image data
-	processedImgData := buf.Bytes()
+		variant := p.optimizedVariant(config, result.Width, result.Height, result.Format)
+		optimizedPath := p.minioClient.DerivedObjectName(originalPath, imageID, variant, content)
 
-	// Only upload if the processed image is smaller than the original or if we forced resizing
-	if len(processedImgData) < len(imgData) || newWidth != originalWidth || newHeight != originalHeight || config.OptimizeStorage {
 		// Upload the processed image to MinIO
-		err = p.minioClient.UploadImage(ctx, bytes.NewReader(processedImgData), optimizedPath, contentType)
+		config.report(ctx, 90, StageUploading)
+		uploadStart := time.Now()
+		err = minio.Store(ctx, p.minioClient.In(minio.ClassOptimized), bytes.NewReader(content), optimizedPath, result.ContentType)
 		if err != nil {
 			reqLogger.Error().Err(err).Msg("Failed to upload processed image")
 			return nil, fmt.Errorf("error uploading processed image: %w", err)
 		}
+		recordStage(ctx, stageUpload, time.Since(uploadStart))
 
 		reqLogger.Info().
 			Str("image_id", imageID.String()).
-			Int("original_size", len(imgData)).
-			Int("processed_size", len(processedImgData)).
-			Float64("reduction_percentage", (1-float64(len(processedImgData))/float64(len(imgData)))*100).
+			Int64("original_size", result.OriginalSize).
+			Int64("processed_size", result.Size).
+			Float64("reduction_percentage", (1-float64(result.Size)/float64(result.OriginalSize))*100).
 			Msg("Image processed and uploaded")
 
 		return &ProcessingResult{
+			Format:          result.Format,
 			OptimizedPath:   optimizedPath,
-			OptimizedSize:   int64(len(processedImgData)),
-			OptimizedWidth:  newWidth,
-			OptimizedHeight: newHeight,
+			OptimizedSize:   result.Size,
+			OptimizedWidth:  result.Width,
+			OptimizedHeight: result.Height,
+			Moderation:      verdict,
+			Placeholder:     result.Placeholder,
+			Renditions:      renditions,
+			QualityScore:    result.QualityScore,
 		}, nil
 	}
 
-	// If no optimization was achieved, and we're not forcing optimization, use the original
+	// If no optimization was achieved, and we're not forcing optimization, use the original.
+	// It is copied to the optimized bucket, where readers look up the optimized image.
 	reqLogger.Info().
 		Str("image_id", imageID.String()).
 		Msg("No optimization achieved, using original image")
 
+	config.report(ctx, 90, StageUploading)
+	uploadStart := time.Now()
+	variant := p.optimizedVariant(config, result.OriginalWidth, result.OriginalHeight, result.Format)
+	optimizedPath, err := p.copyOriginal(ctx, imageID, originalPath, variant, result.ContentType)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to copy original as optimized image")
+		return nil, err
+	}
+	recordStage(ctx, stageUpload, time.Since(uploadStart))
+
 	return &ProcessingResult{
-		OptimizedPath:   originalPath,
-		OptimizedSize:   int64(len(imgData)),
-		OptimizedWidth:  originalWidth,
-		OptimizedHeight: originalHeight,
+		Format:          result.Format,
+		OptimizedPath:   optimizedPath,
+		OptimizedSize:   result.OriginalSize,
+		OptimizedWidth:  result.OriginalWidth,
+		OptimizedHeight: result.OriginalHeight,
+		Moderation:      verdict,
+		Placeholder:     result.Placeholder,
+		Renditions:      renditions,
+		QualityScore:    1,
 	}, nil
 }
 
-// ValidateImage checks if an image is valid and returns its dimensions and size
-func (p *Processor) ValidateImage(ctx context.Context, reader io.Reader) (int, int, int64, string, error) {
-	reqLogger := logger.FromContext(ctx).With().Str("component", "image-validator").Logger()
+// optimizedVariant names the optimized image of a task. Content-addressed names are
+// unique per content already, so only other names carry the version.
+func (p *Processor) optimizedVariant(config Config, width, height int, format string) minio.Variant {
+	variant := minio.Variant{Name: "optimized", Width: width, Height: height, Format: format}
+	if config.Version > 0 && !p.minioClient.ContentAddressed() {
+		variant.Name = fmt.Sprintf("optimized.v%d", config.Version)
+	}
+	return variant
+}
 
-	reqLogger.Info().Msg("Validating image")
+// copyOriginal stores the original at originalPath as the optimized image variant and
+// returns its path
+func (p *Processor) copyOriginal(ctx context.Context, imageID uuid.UUID, originalPath string, variant minio.Variant, contentType string) (string, error) {
+	reader, err := p.minioClient.In(minio.ClassOriginal).GetImage(ctx, originalPath)
+	if err != nil {
+		return "", fmt.Errorf("error getting image from MinIO: %w", err)
+	}
+	defer reader.Close()
 
-	// Read the entire image into memory
-	imgData, err := io.ReadAll(reader)
+	content, err := io.ReadAll(reader)
 	if err != nil {
-		reqLogger.Error().Err(err).Msg("Failed to read image data")
-		return 0, 0, 0, "", fmt.Errorf("error reading image data: %w", err)
+		return "", fmt.Errorf("error reading original image: %w", err)
 	}
 
-	// Decode the image
-	img, format, err := image.Decode(bytes.NewReader(imgData))
+	optimizedPath := p.minioClient.DerivedObjectName(originalPath, imageID, variant, content)
+	if err := minio.Store(ctx, p.minioClient.In(minio.ClassOptimized), bytes.NewReader(content), optimizedPath, contentType); err != nil {
+		return "", fmt.Errorf("error uploading processed image: %w", err)
+	}
+	return optimizedPath, nil
+}
+
+// Render fetches an original from MinIO and returns it transformed according to config,
+// without storing the result. It is used for on-the-fly derived images.
+func (p *Processor) Render(ctx context.Context, objectPath string, config Config) (*RenderResult, error) {
+	reqLogger := logger.FromContext(ctx).With().Str("component", "image-processor").Str("path", objectPath).Logger()
+
+	reader, err := p.minioClient.In(minio.ClassOriginal).GetImage(ctx, objectPath)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to get image from MinIO")
+		return nil, fmt.Errorf("error getting image from MinIO: %w", err)
+	}
+	defer reader.Close()
+
+	result, output, err := p.optimizer.Render(reqLogger.WithContext(ctx), reader, config.options())
 	if err != nil {
-		reqLogger.Error().Err(err).Msg("Failed to decode image")
-		return 0, 0, 0, "", fmt.Errorf("error decoding image: %w", err)
+		reqLogger.Error().Err(err).Msg("Failed to render image")
+		return nil, err
 	}
 
-	// Check if format is supported
-	if format != "jpeg" && format != "png" {
-		reqLogger.Error().Msg("Unsupported image format")
-		return 0, 0, 0, "", fmt.Errorf("unsupported image format: %s", format)
+	data, err := io.ReadAll(output)
+	if err != nil {
+		return nil, fmt.Errorf("error reading rendered image: %w", err)
 	}
 
-	// Get dimensions
-	bounds := img.Bounds()
-	width := bounds.Dx()
-	height := bounds.Dy()
-	size := int64(len(imgData))
+	return &RenderResult{
+		Data:        data,
+		ContentType: result.ContentType,
+		Width:       result.Width,
+		Height:      result.Height,
+	}, nil
+}
+
+// Compare fetches an original and its optimized image from MinIO and measures how the
+// optimized image differs, rendering both side by side if composite is set
+func (p *Processor) Compare(ctx context.Context, originalPath, optimizedPath string, composite bool) (*optimizer.Comparison, error) {
+	reqLogger := logger.FromContext(ctx).With().Str("component", "image-processor").Str("path", originalPath).Logger()
+
+	original, err := p.minioClient.In(minio.ClassOriginal).GetImage(ctx, originalPath)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to get original image from MinIO")
+		return nil, fmt.Errorf("error getting original image from MinIO: %w", err)
+	}
+	defer original.Close()
+
+	optimized, err := p.minioClient.In(minio.ClassOptimized).GetImage(ctx, optimizedPath)
+	if err != nil {
+		reqLogger.Error().Err(err).Str("optimized_path", optimizedPath).Msg("Failed to get optimized image from MinIO")
+		return nil, fmt.Errorf("error getting optimized image from MinIO: %w", err)
+	}
+	defer optimized.Close()
+
+	comparison, err := optimizer.Compare(reqLogger.WithContext(ctx), original, optimized, composite)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Failed to compare images")
+		return nil, err
+	}
+
+	return &comparison, nil
+}
+
+// ProbeImage reads the dimensions and format of an image from its header, without decoding it
+func (p *Processor) ProbeImage(ctx context.Context, reader io.Reader) (int, int, string, error) {
+	reqLogger := logger.FromContext(ctx).With().Str("component", "image-validator").Logger()
+
+	width, height, format, err := optimizer.Probe(reader)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Invalid image header")
+		return 0, 0, "", err
+	}
+
+	reqLogger.Debug().
+		Int("width", width).
+		Int("height", height).
+		Str("format", format).
+		Msg("Image header probed")
+
+	return width, height, format, nil
+}
+
+// ValidateImage checks if an image is valid and returns its dimensions and size. Only the
+// header is decoded; the pixel data is decoded when the image is processed.
+func (p *Processor) ValidateImage(ctx context.Context, reader io.Reader) (int, int, int64, string, error) {
+	reqLogger := logger.FromContext(ctx).With().Str("component", "image-validator").Logger()
+
+	reqLogger.Info().Msg("Validating image")
+
+	width, height, size, format, err := optimizer.Validate(reader)
+	if err != nil {
+		reqLogger.Error().Err(err).Msg("Invalid image")
+		return 0, 0, 0, "", err
+	}
 
 	reqLogger.Debug().
 		Int("width", width).
@@ -245,3 +509,8 @@ func (p *Processor) ValidateImage(ctx context.Context, reader io.Reader) (int, i
 
 	return width, height, size, format, nil
 }
+
+// contentFormat returns the image format of a content type, "jpeg" for image/jpeg
+func contentFormat(contentType string) string {
+	return strings.TrimPrefix(contentType, "image/")
+}
@@ -0,0 +1,44 @@
+package image
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Timed stages of ProcessImage. Download and upload are storage I/O; decode, resize and
+// encode are CPU time.
+const (
+	stageDownload = "download"
+	stageDecode   = "decode"
+	stageResize   = "resize"
+	stageEncode   = "encode"
+	stageUpload   = "upload"
+)
+
+// timedReader counts the time spent in reads, which for an object read from storage is
+// the time spent downloading it
+type timedReader struct {
+	r       io.Reader
+	elapsed time.Duration
+}
+
+func (t *timedReader) Read(p []byte) (int, error) {
+	start := time.Now()
+	n, err := t.r.Read(p)
+	t.elapsed += time.Since(start)
+	return n, err
+}
+
+// recordStage records the duration of a stage as a metric and as an event on the span of
+// ctx, if any
+func recordStage(ctx context.Context, stage string, duration time.Duration) {
+	metrics.RecordProcessingStage(ctx, stage, duration)
+	trace.SpanFromContext(ctx).AddEvent(stage, trace.WithAttributes(
+		attribute.Float64("duration_seconds", duration.Seconds()),
+	))
+}
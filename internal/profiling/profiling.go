@@ -0,0 +1,101 @@
+// Package profiling exposes net/http/pprof on a dedicated port and optionally pushes
+// continuous profiles to Pyroscope.
+package profiling
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	pyroscope "github.com/grafana/pyroscope-go"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// Start starts the pprof server on addr and, if a Pyroscope server is configured, pushes
+// profiles for serviceName to it. It returns a function that stops both.
+func Start(cfg *config.ObservabilityConfig, serviceName, addr string) (func(), error) {
+	log := logger.GetLogger("profiling")
+
+	if !cfg.ProfilerEnabled {
+		log.Info().Msg("Profiler is disabled")
+		return func() {}, nil
+	}
+
+	var profiler *pyroscope.Profiler
+	if cfg.PyroscopeURL != "" {
+		var err error
+		profiler, err = pyroscope.Start(pyroscope.Config{
+			ApplicationName: serviceName,
+			ServerAddress:   cfg.PyroscopeURL,
+			AuthToken:       cfg.PyroscopeToken,
+			ProfileTypes: []pyroscope.ProfileType{
+				pyroscope.ProfileCPU,
+				pyroscope.ProfileAllocObjects,
+				pyroscope.ProfileAllocSpace,
+				pyroscope.ProfileInuseObjects,
+				pyroscope.ProfileInuseSpace,
+				pyroscope.ProfileGoroutines,
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to start pyroscope profiler: %w", err)
+		}
+		log.Info().Str("server", cfg.PyroscopeURL).Str("application", serviceName).Msg("Pushing profiles to Pyroscope")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	server := &http.Server{
+		Addr:        addr,
+		Handler:     requireToken(cfg.ProfilerToken, mux),
+		ReadTimeout: 5 * time.Second,
+		// No write timeout: CPU profiles and traces stream for the requested duration
+		IdleTimeout: 30 * time.Second,
+	}
+
+	go func() {
+		log.Info().Str("address", addr).Msg("Starting pprof server")
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Str("address", addr).Msg("pprof server failed")
+		}
+	}()
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Error().Err(err).Msg("pprof server shutdown failed")
+		}
+		if profiler != nil {
+			if err := profiler.Stop(); err != nil {
+				log.Error().Err(err).Msg("Error stopping pyroscope profiler")
+			}
+		}
+	}, nil
+}
+
+// requireToken rejects requests without the bearer token; an empty token disables the check
+func requireToken(token string, next http.Handler) http.Handler {
+	if token == "" {
+		return next
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
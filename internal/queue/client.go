@@ -2,18 +2,39 @@ package rabbitmq
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	// ErrNotConfirmed is returned by Publish when the broker rejected a task or did not
+	// confirm it in time. The task may have been queued anyway, so publishing it again may
+	// deliver it twice; workers skip tasks that already completed.
+	ErrNotConfirmed = errors.New("task not confirmed by the broker")
+	// ErrUnroutable is returned by Publish when the broker returned a task that no queue is
+	// bound to receive
+	ErrUnroutable = errors.New("task not routed to any queue")
 )
 
 type TaskType string
 
 const (
-	TaskTypeResizeImage TaskType = "resize_image"
+	TaskTypeResizeImage      TaskType = "resize_image"
+	TaskTypeExtractText      TaskType = "extract_text"
+	TaskTypeRemoveBackground TaskType = "remove_background"
 )
 
 type Task struct {
-	ID   string         `json:"id"`
-	Type TaskType       `json:"type"`
-	Data map[string]any `json:"data"`
+	// ID is unique per published task and kept across redeliveries
+	ID   string   `json:"id"`
+	Type TaskType `json:"type"`
+	// Version is the version of the layout of Data, so workers can decode tasks published
+	// before a format change
+	Version int `json:"version,omitempty"`
+	// Data is the payload of the task, such as an ImagePayload
+	Data json.RawMessage `json:"data"`
+	// RequestID is the X-Request-ID of the API request that created the task
+	RequestID string `json:"request_id,omitempty"`
 }
 
 // ProcessFunc is a function that processes a task
@@ -22,8 +43,31 @@ type ProcessFunc func(ctx context.Context, task Task) error
 // Client defines the interface for RabbitMQ operations
 type Client interface {
 	Publish(ctx context.Context, task Task) error
+	// Consume processes tasks until ctx is cancelled
 	Consume(ctx context.Context, processFunc ProcessFunc) error
+	// Drain waits for the tasks in flight after Consume stopped, cancelling and requeuing
+	// those still running when ctx is done
+	Drain(ctx context.Context) error
+
+	// Ping checks that the connection and channel are open
+	Ping() error
 
 	// Close closes the RabbitMQ connection
 	Close() error
 }
+
+// Stats is the state of the queues of a Client
+type Stats struct {
+	// Depth is the number of tasks waiting in the queues
+	Depth int
+	// Consumers is the number of consumers of the queues
+	Consumers int
+	// Capacity is the number of tasks the consumers process at once, the prefetch of each
+	// queue times its consumers
+	Capacity int
+}
+
+// Inspector is implemented by clients that can report the state of their queues
+type Inspector interface {
+	Stats(ctx context.Context) (Stats, error)
+}
@@ -0,0 +1,51 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Serializations of published tasks
+const (
+	SerializationJSON     = "json"
+	SerializationProtobuf = "protobuf"
+)
+
+// Content types of encoded tasks
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// ErrUnsupportedContentType is returned when decoding a message of a content type that is
+// not a task encoding
+var ErrUnsupportedContentType = errors.New("unsupported task content type")
+
+// Marshal encodes task with serialization and returns the encoding with its content type
+func Marshal(task Task, serialization string) ([]byte, string, error) {
+	switch serialization {
+	case SerializationProtobuf:
+		return marshalProtobuf(task), ContentTypeProtobuf, nil
+	case SerializationJSON, "":
+		body, err := json.Marshal(task)
+		return body, ContentTypeJSON, err
+	default:
+		return nil, "", fmt.Errorf("unknown serialization %q, expected json or protobuf", serialization)
+	}
+}
+
+// Unmarshal decodes a task encoded with contentType. Messages without a content type are
+// JSON, so consumers read any encoding whatever the serialization they publish with.
+func Unmarshal(body []byte, contentType string) (Task, error) {
+	var task Task
+	switch contentType {
+	case ContentTypeProtobuf:
+		return unmarshalProtobuf(body)
+	case ContentTypeJSON, "":
+		err := json.Unmarshal(body, &task)
+		return task, err
+	default:
+		return task, fmt.Errorf("%w: %s", ErrUnsupportedContentType, contentType)
+	}
+}
@@ -0,0 +1,253 @@
+// Package memory is an in-memory rabbitmq.Client for tests and ephemeral demo runs. Tasks
+// are JSON encoded like on the broker, routed to the queues of their type, and requeued
+// when processing fails until they were delivered maxDeliveries times.
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
+	"github.com/rs/zerolog"
+)
+
+// queueCapacity is how many tasks a queue holds before Publish fails
+const queueCapacity = 1024
+
+// cancelGrace is how long Drain waits for cancelled tasks to return
+const cancelGrace = 5 * time.Second
+
+// maxDeliveries is how many times a task is delivered before it is dropped, so a task
+// failing every time doesn't keep a consumer busy forever
+const maxDeliveries = 5
+
+// ErrQueueFull is returned by Publish when the queue of the task holds queueCapacity tasks
+var ErrQueueFull = errors.New("queue is full")
+
+type Client struct {
+	queue *queue
+	// taskQueues are the queues of their own of some task types
+	taskQueues map[rabbitmq.TaskType]*queue
+	logger     zerolog.Logger
+	closed     atomic.Bool
+
+	inflight    sync.WaitGroup
+	consuming   sync.WaitGroup
+	cancelTasks context.CancelFunc
+}
+
+// queue holds encoded tasks, processed up to prefetch at once
+type queue struct {
+	name     string
+	prefetch int
+	tasks    chan message
+}
+
+// message is an encoded task and the number of times it was delivered
+type message struct {
+	body       []byte
+	deliveries int
+}
+
+func newQueue(name string, prefetch int) *queue {
+	return &queue{name: name, prefetch: max(prefetch, 1), tasks: make(chan message, queueCapacity)}
+}
+
+// NewClient creates an in-memory client with the queues of cfg
+func NewClient(cfg *config.RabbitMQConfig) (rabbitmq.Client, error) {
+	c := &Client{
+		queue:      newQueue(cfg.Queue, cfg.Prefetch),
+		taskQueues: make(map[rabbitmq.TaskType]*queue, len(cfg.TaskQueues)),
+		logger:     logger.GetLogger("memory-queue"),
+	}
+	for taskType, tq := range cfg.TaskQueues {
+		c.taskQueues[rabbitmq.TaskType(taskType)] = newQueue(tq.Queue, tq.Prefetch)
+	}
+	return c, nil
+}
+
+// Publish adds a task to the queue of its type
+func (c *Client) Publish(ctx context.Context, task rabbitmq.Task) error {
+	if c.closed.Load() {
+		return errors.New("error publishing message: client is closed")
+	}
+
+	body, err := json.Marshal(task)
+	if err != nil {
+		return fmt.Errorf("error marshaling task: %w", err)
+	}
+
+	q := c.queue
+	if tq, ok := c.taskQueues[task.Type]; ok {
+		q = tq
+	}
+
+	select {
+	case q.tasks <- message{body: body}:
+	case <-ctx.Done():
+		return fmt.Errorf("error publishing message: %w", ctx.Err())
+	default:
+		return fmt.Errorf("error publishing message to %s: %w", q.name, ErrQueueFull)
+	}
+
+	c.logger.Debug().
+		Str("task_id", task.ID).
+		Str("task_type", string(task.Type)).
+		Str("queue", q.name).
+		Msg("Task published")
+	return nil
+}
+
+// Consume processes the tasks of every queue, each in its own goroutine, up to the
+// prefetch count of its queue. Cancelling ctx stops taking tasks from the queues; tasks
+// in flight keep running until Drain.
+func (c *Client) Consume(ctx context.Context, processFunc rabbitmq.ProcessFunc) error {
+	// Tasks outlive the consumption so they can finish while draining
+	taskCtx, cancelTasks := context.WithCancel(context.WithoutCancel(ctx))
+	c.cancelTasks = cancelTasks
+
+	c.consume(ctx, taskCtx, c.queue, processFunc)
+	for _, q := range c.taskQueues {
+		c.consume(ctx, taskCtx, q, processFunc)
+	}
+	return nil
+}
+
+// consume dispatches the tasks of q until ctx is cancelled
+func (c *Client) consume(ctx, taskCtx context.Context, q *queue, processFunc rabbitmq.ProcessFunc) {
+	c.logger.Info().Str("queue", q.name).Int("prefetch", q.prefetch).Msg("Started consuming messages")
+
+	slots := make(chan struct{}, q.prefetch)
+	c.consuming.Add(1)
+	go func() {
+		defer c.consuming.Done()
+		for {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				c.logger.Info().Str("queue", q.name).Msg("Consumer stopped")
+				return
+			}
+
+			select {
+			case msg := <-q.tasks:
+				c.inflight.Add(1)
+				go func() {
+					defer func() {
+						<-slots
+						c.inflight.Done()
+					}()
+					c.handleMessage(taskCtx, q, msg, processFunc)
+				}()
+			case <-ctx.Done():
+				c.logger.Info().Str("queue", q.name).Msg("Consumer stopped")
+				return
+			}
+		}
+	}()
+}
+
+// handleMessage processes a task, requeuing it if processing failed and it was delivered
+// fewer than maxDeliveries times
+func (c *Client) handleMessage(ctx context.Context, q *queue, msg message, processFunc rabbitmq.ProcessFunc) {
+	var task rabbitmq.Task
+	if err := json.Unmarshal(msg.body, &task); err != nil {
+		c.logger.Error().Err(err).Str("queue", q.name).Msg("Error unmarshaling message")
+		return
+	}
+
+	msg.deliveries++
+	if err := processFunc(ctx, task); err != nil {
+		c.logger.Error().
+			Err(err).
+			Str("task_id", task.ID).
+			Str("queue", q.name).
+			Int("deliveries", msg.deliveries).
+			Msg("Error processing message")
+
+		// Tasks cancelled by the shutdown are requeued whatever their count
+		if msg.deliveries >= maxDeliveries && ctx.Err() == nil {
+			c.logger.Error().
+				Str("task_id", task.ID).
+				Str("queue", q.name).
+				Int("deliveries", msg.deliveries).
+				Msg("Task failed too many times, dropping it")
+			return
+		}
+
+		select {
+		case q.tasks <- msg:
+		default:
+			c.logger.Error().Str("task_id", task.ID).Str("queue", q.name).Msg("Error requeuing message: queue is full")
+		}
+		return
+	}
+
+	c.logger.Debug().
+		Str("task_id", task.ID).
+		Str("task_type", string(task.Type)).
+		Msg("Task processed successfully")
+}
+
+// Drain waits until the consumers stopped by cancelling the context of Consume are done
+// and their tasks in flight too. Tasks still running when ctx is done are cancelled, and
+// requeued if they return an error.
+func (c *Client) Drain(ctx context.Context) error {
+	if c.cancelTasks == nil {
+		return nil
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		c.consuming.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return fmt.Errorf("error stopping consumers: %w", ctx.Err())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	c.logger.Warn().Msg("Shutdown deadline reached, cancelling tasks in flight")
+	c.cancelTasks()
+	select {
+	case <-done:
+	case <-time.After(cancelGrace):
+		c.logger.Warn().Msg("Tasks did not return after cancellation")
+	}
+	return fmt.Errorf("tasks in flight cancelled: %w", ctx.Err())
+}
+
+// Ping fails once the client is closed
+func (c *Client) Ping() error {
+	if c.closed.Load() {
+		return errors.New("client is closed")
+	}
+	return nil
+}
+
+// Close stops accepting tasks. Queued tasks are discarded with the client.
+func (c *Client) Close() error {
+	c.closed.Store(true)
+	return nil
+}
@@ -0,0 +1,71 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+// CurrentVersion is the version of the payloads of published tasks. Tasks published before
+// payloads were versioned have version 0 and the layout of version 1.
+const CurrentVersion = 1
+
+// ErrUnsupportedVersion is returned when decoding a task published with a payload version
+// newer than the decoder knows
+var ErrUnsupportedVersion = errors.New("unsupported task version")
+
+// ImagePayloadV1 is the payload of tasks on an image in version 1: resize, text extraction
+// and background removal. Only resize tasks have a Filename and Config.
+type ImagePayloadV1 struct {
+	ImageID      string          `json:"image_id"`
+	OriginalPath string          `json:"original_path"`
+	Filename     string          `json:"filename,omitempty"`
+	Config       *ResizeConfigV1 `json:"config,omitempty"`
+}
+
+// ResizeConfigV1 holds the processing options of a resize task in version 1
+type ResizeConfigV1 struct {
+	MaxWidth        int     `json:"max_width"`
+	MaxHeight       int     `json:"max_height"`
+	Quality         int     `json:"quality"`
+	OptimizeStorage bool    `json:"optimize_storage"`
+	Gravity         string  `json:"gravity,omitempty"`
+	BlurFaces       bool    `json:"blur_faces,omitempty"`
+	Filter          string  `json:"filter,omitempty"`
+	Sharpen         float64 `json:"sharpen,omitempty"`
+	TargetSizeKB    int     `json:"target_size_kb,omitempty"`
+	MinQuality      int     `json:"min_quality,omitempty"`
+	// Renditions are transformation template names
+	Renditions []string   `json:"renditions,omitempty"`
+	Targets    []TargetV1 `json:"targets,omitempty"`
+}
+
+// TargetV1 is an additional output of a resize task in version 1, encoded alongside the
+// optimized image
+type TargetV1 struct {
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Quality int    `json:"quality"`
+	Format  string `json:"format"`
+}
+
+// The payload types of CurrentVersion
+type (
+	ImagePayload = ImagePayloadV1
+	ResizeConfig = ResizeConfigV1
+	Target       = TargetV1
+)
+
+// NewImageTask returns a task of taskType with a new ID and payload encoded in
+// CurrentVersion
+func NewImageTask(taskType TaskType, payload ImagePayload) Task {
+	// the payload only has plain fields, so it always encodes
+	data, _ := json.Marshal(payload)
+	return Task{
+		ID:      uuid.NewString(),
+		Type:    taskType,
+		Version: CurrentVersion,
+		Data:    data,
+	}
+}
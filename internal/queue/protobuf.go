@@ -0,0 +1,302 @@
+package rabbitmq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// Field numbers of the messages of task.proto
+const (
+	taskID        protowire.Number = 1
+	taskType      protowire.Number = 2
+	taskVersion   protowire.Number = 3
+	taskRequestID protowire.Number = 4
+	taskImageV1   protowire.Number = 5
+	taskJSON      protowire.Number = 6
+
+	imageID           protowire.Number = 1
+	imageOriginalPath protowire.Number = 2
+	imageFilename     protowire.Number = 3
+	imageConfig       protowire.Number = 4
+
+	configMaxWidth        protowire.Number = 1
+	configMaxHeight       protowire.Number = 2
+	configQuality         protowire.Number = 3
+	configOptimizeStorage protowire.Number = 4
+	configGravity         protowire.Number = 5
+	configBlurFaces       protowire.Number = 6
+	configFilter          protowire.Number = 7
+	configSharpen         protowire.Number = 8
+	configTargetSizeKB    protowire.Number = 9
+	configMinQuality      protowire.Number = 10
+	configRenditions      protowire.Number = 11
+	configTargets         protowire.Number = 12
+
+	targetWidth   protowire.Number = 1
+	targetHeight  protowire.Number = 2
+	targetQuality protowire.Number = 3
+	targetFormat  protowire.Number = 4
+)
+
+// marshalProtobuf encodes task as the Task message of task.proto. Payloads of image tasks
+// in version 1 are encoded as messages; other payloads, or image payloads with fields the
+// message doesn't have, are kept as JSON.
+func marshalProtobuf(task Task) []byte {
+	var b []byte
+	b = appendString(b, taskID, task.ID)
+	b = appendString(b, taskType, string(task.Type))
+	b = appendInt(b, taskVersion, task.Version)
+	b = appendString(b, taskRequestID, task.RequestID)
+
+	if payload, ok := imagePayloadV1(task); ok {
+		b = protowire.AppendTag(b, taskImageV1, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalImagePayloadV1(payload))
+	} else if len(task.Data) > 0 {
+		b = protowire.AppendTag(b, taskJSON, protowire.BytesType)
+		b = protowire.AppendBytes(b, task.Data)
+	}
+	return b
+}
+
+// imagePayloadV1 decodes the payload of task if it is an image task in version 1 whose
+// payload has no fields ImagePayloadV1 doesn't have
+func imagePayloadV1(task Task) (ImagePayloadV1, bool) {
+	var payload ImagePayloadV1
+	switch task.Type {
+	case TaskTypeResizeImage, TaskTypeExtractText, TaskTypeRemoveBackground:
+	default:
+		return payload, false
+	}
+	if task.Version > 1 {
+		return payload, false
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(task.Data))
+	decoder.DisallowUnknownFields()
+	return payload, decoder.Decode(&payload) == nil
+}
+
+func marshalImagePayloadV1(payload ImagePayloadV1) []byte {
+	var b []byte
+	b = appendString(b, imageID, payload.ImageID)
+	b = appendString(b, imageOriginalPath, payload.OriginalPath)
+	b = appendString(b, imageFilename, payload.Filename)
+	if config := payload.Config; config != nil {
+		var c []byte
+		c = appendInt(c, configMaxWidth, config.MaxWidth)
+		c = appendInt(c, configMaxHeight, config.MaxHeight)
+		c = appendInt(c, configQuality, config.Quality)
+		c = appendBool(c, configOptimizeStorage, config.OptimizeStorage)
+		c = appendString(c, configGravity, config.Gravity)
+		c = appendBool(c, configBlurFaces, config.BlurFaces)
+		c = appendString(c, configFilter, config.Filter)
+		if config.Sharpen != 0 {
+			c = protowire.AppendTag(c, configSharpen, protowire.Fixed64Type)
+			c = protowire.AppendFixed64(c, math.Float64bits(config.Sharpen))
+		}
+		c = appendInt(c, configTargetSizeKB, config.TargetSizeKB)
+		c = appendInt(c, configMinQuality, config.MinQuality)
+		for _, name := range config.Renditions {
+			c = protowire.AppendTag(c, configRenditions, protowire.BytesType)
+			c = protowire.AppendString(c, name)
+		}
+		for _, target := range config.Targets {
+			var t []byte
+			t = appendInt(t, targetWidth, target.Width)
+			t = appendInt(t, targetHeight, target.Height)
+			t = appendInt(t, targetQuality, target.Quality)
+			t = appendString(t, targetFormat, target.Format)
+			c = protowire.AppendTag(c, configTargets, protowire.BytesType)
+			c = protowire.AppendBytes(c, t)
+		}
+		b = protowire.AppendTag(b, imageConfig, protowire.BytesType)
+		b = protowire.AppendBytes(b, c)
+	}
+	return b
+}
+
+// unmarshalProtobuf decodes the Task message of task.proto. Image payloads are converted
+// back to their JSON layout, so decoded tasks are the same whatever their encoding.
+func unmarshalProtobuf(body []byte) (Task, error) {
+	var task Task
+	err := walkFields(body, func(num protowire.Number, v fieldValue) error {
+		switch num {
+		case taskID:
+			task.ID = string(v.bytes)
+		case taskType:
+			task.Type = TaskType(v.bytes)
+		case taskVersion:
+			task.Version = v.int()
+		case taskRequestID:
+			task.RequestID = string(v.bytes)
+		case taskImageV1:
+			payload, err := unmarshalImagePayloadV1(v.bytes)
+			if err != nil {
+				return err
+			}
+			task.Data, err = json.Marshal(payload)
+			return err
+		case taskJSON:
+			task.Data = json.RawMessage(bytes.Clone(v.bytes))
+		}
+		return nil
+	})
+	if err != nil {
+		return Task{}, fmt.Errorf("invalid protobuf task: %w", err)
+	}
+	return task, nil
+}
+
+func unmarshalImagePayloadV1(b []byte) (ImagePayloadV1, error) {
+	var payload ImagePayloadV1
+	err := walkFields(b, func(num protowire.Number, v fieldValue) error {
+		switch num {
+		case imageID:
+			payload.ImageID = string(v.bytes)
+		case imageOriginalPath:
+			payload.OriginalPath = string(v.bytes)
+		case imageFilename:
+			payload.Filename = string(v.bytes)
+		case imageConfig:
+			config, err := unmarshalResizeConfigV1(v.bytes)
+			if err != nil {
+				return err
+			}
+			payload.Config = &config
+		}
+		return nil
+	})
+	return payload, err
+}
+
+func unmarshalResizeConfigV1(b []byte) (ResizeConfigV1, error) {
+	var config ResizeConfigV1
+	err := walkFields(b, func(num protowire.Number, v fieldValue) error {
+		switch num {
+		case configMaxWidth:
+			config.MaxWidth = v.int()
+		case configMaxHeight:
+			config.MaxHeight = v.int()
+		case configQuality:
+			config.Quality = v.int()
+		case configOptimizeStorage:
+			config.OptimizeStorage = v.number != 0
+		case configGravity:
+			config.Gravity = string(v.bytes)
+		case configBlurFaces:
+			config.BlurFaces = v.number != 0
+		case configFilter:
+			config.Filter = string(v.bytes)
+		case configSharpen:
+			config.Sharpen = math.Float64frombits(v.number)
+		case configTargetSizeKB:
+			config.TargetSizeKB = v.int()
+		case configMinQuality:
+			config.MinQuality = v.int()
+		case configRenditions:
+			config.Renditions = append(config.Renditions, string(v.bytes))
+		case configTargets:
+			var target TargetV1
+			err := walkFields(v.bytes, func(num protowire.Number, v fieldValue) error {
+				switch num {
+				case targetWidth:
+					target.Width = v.int()
+				case targetHeight:
+					target.Height = v.int()
+				case targetQuality:
+					target.Quality = v.int()
+				case targetFormat:
+					target.Format = string(v.bytes)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			config.Targets = append(config.Targets, target)
+		}
+		return nil
+	})
+	return config, err
+}
+
+// fieldValue is the value of an encoded field: number holds varint and fixed size values,
+// bytes the content of length-delimited ones
+type fieldValue struct {
+	number uint64
+	bytes  []byte
+}
+
+// int returns the value of an int32 field
+func (v fieldValue) int() int {
+	return int(int32(v.number))
+}
+
+// walkFields calls field for each field of the encoded message b. Fields of unknown wire
+// types are skipped, so messages of later schemas still decode.
+func walkFields(b []byte, field func(num protowire.Number, v fieldValue) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		var v fieldValue
+		known := true
+		switch typ {
+		case protowire.VarintType:
+			v.number, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed64Type:
+			v.number, n = protowire.ConsumeFixed64(b)
+		case protowire.BytesType:
+			v.bytes, n = protowire.ConsumeBytes(b)
+		default:
+			known = false
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if !known {
+			continue
+		}
+
+		if err := field(num, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendString appends a string field, omitted when empty as in proto3
+func appendString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// appendInt appends an int32 field, omitted when zero as in proto3
+func appendInt(b []byte, num protowire.Number, v int) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(int64(int32(v))))
+}
+
+// appendBool appends a bool field, omitted when false as in proto3
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, protowire.EncodeBool(v))
+}
@@ -2,11 +2,12 @@ package rabbitmq
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/not-nullexception/image-optimizer/config"
 	"github.com/not-nullexception/image-optimizer/internal/logger"
 	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
@@ -18,16 +19,64 @@ type RabbitMQClient struct {
 	conn         *amqp.Connection
 	channel      *amqp.Channel
 	queueName    string
+	prefetch     int
 	exchangeName string
 	routingKey   string
 	consumerTag  string
-	logger       zerolog.Logger
+	// taskQueues are the queues of their own of some task types
+	taskQueues map[rabbitmq.TaskType]*taskQueue
+	logger     zerolog.Logger
+	// serialization encodes published tasks; consumed tasks are decoded by their content type
+	serialization string
+
+	// confirmTimeout bounds the wait for publisher confirms, which are disabled if zero.
+	// Messages returned as unroutable are collected from returns into returned, by message
+	// ID, until their publisher takes them or gives up waiting.
+	confirmTimeout time.Duration
+	returnsMu      sync.Mutex
+	returns        chan amqp.Return
+	returned       map[string]returnedMessage
+
+	// inflight tracks the tasks being processed, consuming the consumers until they stop and
+	// cancelTasks cancels the tasks still running when draining times out
+	inflight    sync.WaitGroup
+	consuming   sync.WaitGroup
+	cancelTasks context.CancelFunc
+}
+
+// returnedMessage is the reason the broker returned a message for, and when it was collected
+type returnedMessage struct {
+	reason      string
+	collectedAt time.Time
+}
+
+// taskQueue is the queue of a task type. It is consumed on a channel of its own, so its
+// prefetch bounds its tasks independently of the other queues, and bound to the exchange
+// with its name as routing key.
+type taskQueue struct {
+	name     string
+	prefetch int
+	channel  *amqp.Channel
 }
 
 const (
 	TaskTypeResizeImage = "resize_image"
 )
 
+// deliverySource is the part of an AMQP channel a consumer uses: it starts the deliveries
+// of a queue and cancels them
+type deliverySource interface {
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+}
+
+// cancelGrace is how long Drain waits for cancelled tasks to return
+const cancelGrace = 5 * time.Second
+
+// returnsBuffer is how many returned messages are held until a publisher collects them;
+// the broker connection waits while it is full
+const returnsBuffer = 64
+
 func NewClient(cfg *config.RabbitMQConfig) (rabbitmq.Client, error) {
 	log := logger.GetLogger("rabbitmq-client")
 
@@ -60,62 +109,109 @@ func NewClient(cfg *config.RabbitMQConfig) (rabbitmq.Client, error) {
 		return nil, fmt.Errorf("error declaring exchange: %w", err)
 	}
 
+	// Declare and bind the default queue
+	if err := declareQueue(channel, cfg.Queue, cfg.RoutingKey, cfg.Exchange, cfg.Prefetch); err != nil {
+		channel.Close()
+		conn.Close()
+		return nil, err
+	}
+
+	client := &RabbitMQClient{
+		conn:          conn,
+		channel:       channel,
+		queueName:     cfg.Queue,
+		exchangeName:  cfg.Exchange,
+		routingKey:    cfg.RoutingKey,
+		consumerTag:   cfg.ConsumerTag,
+		prefetch:      cfg.Prefetch,
+		taskQueues:    make(map[rabbitmq.TaskType]*taskQueue, len(cfg.TaskQueues)),
+		logger:        log,
+		serialization: cfg.Serialization,
+	}
+
+	// Wait for the broker to take responsibility for published tasks
+	if cfg.ConfirmTimeout > 0 {
+		if err := channel.Confirm(false); err != nil {
+			channel.Close()
+			conn.Close()
+			return nil, fmt.Errorf("error enabling publisher confirms: %w", err)
+		}
+		client.confirmTimeout = cfg.ConfirmTimeout
+		client.returns = channel.NotifyReturn(make(chan amqp.Return, returnsBuffer))
+		client.returned = make(map[string]returnedMessage)
+	}
+
+	// Declare the queues of their own of task types, each with a channel of its own
+	for taskType, queueCfg := range cfg.TaskQueues {
+		queueChannel, err := conn.Channel()
+		if err == nil {
+			err = declareQueue(queueChannel, queueCfg.Queue, queueCfg.Queue, cfg.Exchange, queueCfg.Prefetch)
+			if err != nil {
+				queueChannel.Close()
+			}
+		}
+		if err != nil {
+			client.Close()
+			return nil, fmt.Errorf("error setting up queue of task type %s: %w", taskType, err)
+		}
+		client.taskQueues[rabbitmq.TaskType(taskType)] = &taskQueue{name: queueCfg.Queue, prefetch: queueCfg.Prefetch, channel: queueChannel}
+
+		log.Info().
+			Str("task_type", taskType).
+			Str("queue", queueCfg.Queue).
+			Int("prefetch", queueCfg.Prefetch).
+			Msg("Task type routed to a queue of its own")
+	}
+
+	log.Info().
+		Str("exchange", cfg.Exchange).
+		Str("queue", cfg.Queue).
+		Str("routing_key", cfg.RoutingKey).
+		Dur("confirm_timeout", cfg.ConfirmTimeout).
+		Msg("RabbitMQ client initialized")
+
+	return client, nil
+}
+
+// declareQueue declares a durable queue, binds it to exchange with routingKey and limits
+// the deliveries the channel holds unacknowledged to prefetch
+func declareQueue(channel *amqp.Channel, name, routingKey, exchange string, prefetch int) error {
 	// Declare queue
-	_, err = channel.QueueDeclare(
-		cfg.Queue, // name
-		true,      // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
+	_, err := channel.QueueDeclare(
+		name,  // name
+		true,  // durable
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		nil,   // arguments
 	)
 	if err != nil {
-		channel.Close()
-		conn.Close()
-		return nil, fmt.Errorf("error declaring queue: %w", err)
+		return fmt.Errorf("error declaring queue: %w", err)
 	}
 
 	// Bind queue to exchange
 	err = channel.QueueBind(
-		cfg.Queue,      // queue name
-		cfg.RoutingKey, // routing key
-		cfg.Exchange,   // exchange name
-		false,          // no-wait
-		nil,            // arguments
+		name,       // queue name
+		routingKey, // routing key
+		exchange,   // exchange name
+		false,      // no-wait
+		nil,        // arguments
 	)
 	if err != nil {
-		channel.Close()
-		conn.Close()
-		return nil, fmt.Errorf("error binding queue: %w", err)
+		return fmt.Errorf("error binding queue: %w", err)
 	}
 
 	// Set QoS
 	err = channel.Qos(
-		1,     // prefetch count
-		0,     // prefetch size
-		false, // global
+		prefetch, // prefetch count
+		0,        // prefetch size
+		false,    // global
 	)
 	if err != nil {
-		channel.Close()
-		conn.Close()
-		return nil, fmt.Errorf("error setting QoS: %w", err)
+		return fmt.Errorf("error setting QoS: %w", err)
 	}
 
-	log.Info().
-		Str("exchange", cfg.Exchange).
-		Str("queue", cfg.Queue).
-		Str("routing_key", cfg.RoutingKey).
-		Msg("RabbitMQ client initialized")
-
-	return &RabbitMQClient{
-		conn:         conn,
-		channel:      channel,
-		queueName:    cfg.Queue,
-		exchangeName: cfg.Exchange,
-		routingKey:   cfg.RoutingKey,
-		consumerTag:  cfg.ConsumerTag,
-		logger:       log,
-	}, nil
+	return nil
 }
 
 func connect(cfg *config.RabbitMQConfig, log zerolog.Logger) (*amqp.Connection, error) {
@@ -133,12 +229,18 @@ func connect(cfg *config.RabbitMQConfig, log zerolog.Logger) (*amqp.Connection,
 			Int("max_attempts", maxRetries).
 			Msg("Connecting to RabbitMQ")
 
-		conn, err = amqp.Dial(cfg.RabbitMQURL())
+		conn, err = amqp.Dial(dialURL(cfg, log))
 		if err == nil {
 			log.Info().Msg("Connected to RabbitMQ")
 			return conn, nil
 		}
 
+		// Retry with the rotated credentials if the current ones were rejected
+		if errors.Is(err, amqp.ErrCredentials) && cfg.Credentials != nil {
+			log.Warn().Msg("RabbitMQ rejected the credentials, reading them again")
+			cfg.Credentials.Invalidate()
+		}
+
 		log.Warn().
 			Err(err).
 			Int("attempt", i+1).
@@ -152,31 +254,59 @@ func connect(cfg *config.RabbitMQConfig, log zerolog.Logger) (*amqp.Connection,
 	return nil, fmt.Errorf("failed to connect to RabbitMQ after %d attempts: %w", maxRetries, err)
 }
 
-// Publish publishes a task to the queue
+// dialURL returns the connection string with the current credentials
+func dialURL(cfg *config.RabbitMQConfig, log zerolog.Logger) string {
+	if cfg.Credentials == nil {
+		return cfg.RabbitMQURL()
+	}
+
+	user, password, err := cfg.Credentials.Get(context.Background())
+	if err != nil {
+		log.Warn().Err(err).Msg("Connecting with the previous RabbitMQ credentials")
+	}
+	return cfg.URLWith(user, password)
+}
+
+// Publish publishes a task to the queue. With publisher confirms it returns once the broker
+// confirmed the task, or fails with rabbitmq.ErrNotConfirmed when it didn't within the
+// confirm timeout and with rabbitmq.ErrUnroutable when no queue received it.
 func (c *RabbitMQClient) Publish(ctx context.Context, task rabbitmq.Task) error {
 	reqLogger :=
 		logger.FromContext(ctx).With().Str("component", "rabbitmq-client").Logger()
 
-	body, err := json.Marshal(task)
+	body, contentType, err := rabbitmq.Marshal(task, c.serialization)
 	if err != nil {
 		reqLogger.Error().Err(err).Msg("Error marshaling task")
 		return fmt.Errorf("error marshaling task: %w", err)
 	}
 
-	reqLogger.Debug().Msg("Publishing task")
+	// Task types with a queue of their own are routed to it
+	routingKey := c.routingKey
+	if queue, ok := c.taskQueues[task.Type]; ok {
+		routingKey = queue.name
+	}
 
-	err = c.channel.PublishWithContext(
-		ctx,
-		c.exchangeName, // exchange
-		c.routingKey,   // routing key
-		false,          // mandatory
-		false,          // immediate
-		amqp.Publishing{
-			ContentType:  "application/json",
-			DeliveryMode: amqp.Persistent,
-			Body:         body,
-		},
-	)
+	reqLogger.Debug().Str("routing_key", routingKey).Msg("Publishing task")
+
+	msg := amqp.Publishing{
+		ContentType:   contentType,
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: task.RequestID,
+		MessageId:     task.ID,
+		Body:          body,
+	}
+	if c.confirmTimeout > 0 {
+		err = c.publishConfirmed(ctx, routingKey, msg)
+	} else {
+		err = c.channel.PublishWithContext(
+			ctx,
+			c.exchangeName, // exchange
+			routingKey,     // routing key
+			false,          // mandatory
+			false,          // immediate
+			msg,
+		)
+	}
 	if err != nil {
 		reqLogger.Error().Err(err).Msg("Error publishing message")
 		return fmt.Errorf("error publishing message: %w", err)
@@ -191,72 +321,149 @@ func (c *RabbitMQClient) Publish(ctx context.Context, task rabbitmq.Task) error
 	return nil
 }
 
+// publishConfirmed publishes msg as mandatory and waits up to the confirm timeout for the
+// broker to confirm it. The broker returns a message it could not route to any queue
+// before confirming it, so a returned message is known by the time it is confirmed.
+func (c *RabbitMQClient) publishConfirmed(ctx context.Context, routingKey string, msg amqp.Publishing) error {
+	if msg.MessageId == "" {
+		msg.MessageId = uuid.NewString()
+	}
+
+	confirmation, err := c.channel.PublishWithDeferredConfirmWithContext(
+		ctx,
+		c.exchangeName, // exchange
+		routingKey,     // routing key
+		true,           // mandatory
+		false,          // immediate
+		msg,
+	)
+	if err != nil {
+		return err
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, c.confirmTimeout)
+	defer cancel()
+	acked, err := confirmation.WaitContext(waitCtx)
+
+	if reason, ok := c.takeReturned(msg.MessageId); ok {
+		return fmt.Errorf("%w: %s", rabbitmq.ErrUnroutable, reason)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", rabbitmq.ErrNotConfirmed, err)
+	}
+	if !acked {
+		return fmt.Errorf("%w: rejected by the broker", rabbitmq.ErrNotConfirmed)
+	}
+	return nil
+}
+
+// takeReturned collects the messages returned so far and reports whether the one with
+// messageID is among them, with the reason the broker gave. Messages returned after their
+// publisher stopped waiting are dropped once the confirm timeout passed.
+func (c *RabbitMQClient) takeReturned(messageID string) (string, bool) {
+	c.returnsMu.Lock()
+	defer c.returnsMu.Unlock()
+
+	now := time.Now()
+	for drained := false; !drained; {
+		select {
+		case ret, ok := <-c.returns:
+			if !ok {
+				// the channel is closed; receiving from nil never succeeds
+				c.returns = nil
+				continue
+			}
+			c.logger.Warn().
+				Str("message_id", ret.MessageId).
+				Str("routing_key", ret.RoutingKey).
+				Str("reason", ret.ReplyText).
+				Msg("Broker returned an unroutable task")
+			c.returned[ret.MessageId] = returnedMessage{reason: ret.ReplyText, collectedAt: now}
+		default:
+			drained = true
+		}
+	}
+
+	returned, ok := c.returned[messageID]
+	delete(c.returned, messageID)
+	for id, msg := range c.returned {
+		if now.Sub(msg.collectedAt) > c.confirmTimeout {
+			delete(c.returned, id)
+		}
+	}
+	return returned.reason, ok
+}
+
 // Consume TODO - Implement dead letter queue on error
-// Consume starts consuming tasks from the queue
+// Consume starts consuming tasks from the default queue and the queues of task types. Each
+// delivery is processed in its own goroutine, up to the prefetch count of its queue.
+// Cancelling ctx stops the consumption and requeues the deliveries not started yet; tasks
+// in flight keep running until Drain.
 func (c *RabbitMQClient) Consume(ctx context.Context, processFunc rabbitmq.ProcessFunc) error {
+	// Tasks outlive the consumption so they can finish while draining
+	taskCtx, cancelTasks := context.WithCancel(context.WithoutCancel(ctx))
+	c.cancelTasks = cancelTasks
+
+	if err := c.consume(ctx, taskCtx, c.channel, c.queueName, c.consumerTag, processFunc); err != nil {
+		return err
+	}
+	for _, queue := range c.taskQueues {
+		if err := c.consume(ctx, taskCtx, queue.channel, queue.name, c.consumerTag+"-"+queue.name, processFunc); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// consume starts consuming queue on channel until ctx is cancelled
+func (c *RabbitMQClient) consume(ctx, taskCtx context.Context, channel deliverySource, queue, consumerTag string, processFunc rabbitmq.ProcessFunc) error {
 	reqLogger := logger.FromContext(ctx).With().Str("component", "rabbitmq-client").Logger()
-	messages, err := c.channel.Consume(
-		c.queueName,   // queue
-		c.consumerTag, // consumer
-		false,         // auto-ack
-		false,         // exclusive
-		false,         // no-local
-		false,         // no-wait
-		nil,           // args
+	messages, err := channel.Consume(
+		queue,       // queue
+		consumerTag, // consumer
+		false,       // auto-ack
+		false,       // exclusive
+		false,       // no-local
+		false,       // no-wait
+		nil,         // args
 	)
 	if err != nil {
-		reqLogger.Error().Err(err).Msg("Error consuming from queue")
-		return fmt.Errorf("error consuming from queue: %w", err)
+		reqLogger.Error().Err(err).Str("queue", queue).Msg("Error consuming from queue")
+		return fmt.Errorf("error consuming from queue %s: %w", queue, err)
 	}
 
 	c.logger.Info().
-		Str("queue", c.queueName).
-		Str("consumer_tag", c.consumerTag).
+		Str("queue", queue).
+		Str("consumer_tag", consumerTag).
 		Msg("Started consuming messages")
 
-	// Process messages in a separate goroutine
+	// Dispatch messages in a separate goroutine
+	c.consuming.Add(1)
 	go func() {
+		defer c.consuming.Done()
 		for {
 			select {
 			case msg, ok := <-messages:
 				if !ok {
-					c.logger.Warn().Msg("RabbitMQ channel closed")
+					c.logger.Warn().Str("queue", queue).Msg("RabbitMQ channel closed")
 					return
 				}
 
 				c.logger.Debug().
+					Str("queue", queue).
 					Str("delivery_tag", fmt.Sprintf("%d", msg.DeliveryTag)).
 					Msg("Received message")
 
-				// Process the message
-				err := c.processMessage(ctx, msg, processFunc)
-				if err != nil {
-					c.logger.Error().
-						Err(err).
-						Str("delivery_tag", fmt.Sprintf("%d", msg.DeliveryTag)).
-						Msg("Error processing message")
-
-					// Reject the message and requeue
-					err = msg.Nack(false, true)
-					if err != nil {
-						c.logger.Error().
-							Err(err).
-							Str("delivery_tag", fmt.Sprintf("%d", msg.DeliveryTag)).
-							Msg("Error negatively acknowledging message")
-					}
-				} else {
-					// Acknowledge the message
-					err = msg.Ack(false)
-					if err != nil {
-						c.logger.Error().
-							Err(err).
-							Str("delivery_tag", fmt.Sprintf("%d", msg.DeliveryTag)).
-							Msg("Error acknowledging message")
-					}
-				}
+				c.inflight.Add(1)
+				go func() {
+					defer c.inflight.Done()
+					c.handleMessage(taskCtx, msg, processFunc)
+				}()
 
 			case <-ctx.Done():
-				c.logger.Info().Msg("Stopping consumer due to context cancellation")
+				c.logger.Info().Str("queue", queue).Msg("Stopping consumer due to context cancellation")
+				c.stopConsuming(channel, consumerTag, messages)
 				return
 			}
 		}
@@ -265,12 +472,109 @@ func (c *RabbitMQClient) Consume(ctx context.Context, processFunc rabbitmq.Proce
 	return nil
 }
 
+// stopConsuming cancels a consumer so the broker stops delivering, and requeues the
+// deliveries it already sent
+func (c *RabbitMQClient) stopConsuming(channel deliverySource, consumerTag string, messages <-chan amqp.Delivery) {
+	if err := channel.Cancel(consumerTag, false); err != nil {
+		c.logger.Error().Err(err).Str("consumer_tag", consumerTag).Msg("Error cancelling consumer")
+		return
+	}
+
+	// The deliveries channel is closed once the buffered deliveries are read
+	requeued := 0
+	for msg := range messages {
+		if err := msg.Nack(false, true); err != nil {
+			c.logger.Error().
+				Err(err).
+				Str("delivery_tag", fmt.Sprintf("%d", msg.DeliveryTag)).
+				Msg("Error requeuing message")
+			continue
+		}
+		requeued++
+	}
+	c.logger.Info().Str("consumer_tag", consumerTag).Int("requeued", requeued).Msg("Consumer stopped")
+}
+
+// Drain waits until the consumers stopped by cancelling the context of Consume are done
+// and their tasks in flight too. Tasks still running when ctx is done are cancelled;
+// their deliveries are requeued when they return, or by the broker once the channel closes.
+func (c *RabbitMQClient) Drain(ctx context.Context) error {
+	if c.cancelTasks == nil {
+		return nil
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		c.consuming.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return fmt.Errorf("error stopping consumers: %w", ctx.Err())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	c.logger.Warn().Msg("Shutdown deadline reached, cancelling tasks in flight")
+	c.cancelTasks()
+	select {
+	case <-done:
+	case <-time.After(cancelGrace):
+		c.logger.Warn().Msg("Tasks did not return after cancellation; the broker requeues them")
+	}
+	return fmt.Errorf("tasks in flight cancelled: %w", ctx.Err())
+}
+
+// handleMessage processes a delivery and acknowledges it, or requeues it if processing failed
+func (c *RabbitMQClient) handleMessage(ctx context.Context, msg amqp.Delivery, processFunc rabbitmq.ProcessFunc) {
+	err := c.processMessage(ctx, msg, processFunc)
+	if err != nil {
+		c.logger.Error().
+			Err(err).
+			Str("delivery_tag", fmt.Sprintf("%d", msg.DeliveryTag)).
+			Msg("Error processing message")
+
+		// Reject the message and requeue
+		err = msg.Nack(false, true)
+		if err != nil {
+			c.logger.Error().
+				Err(err).
+				Str("delivery_tag", fmt.Sprintf("%d", msg.DeliveryTag)).
+				Msg("Error negatively acknowledging message")
+		}
+		return
+	}
+
+	// Acknowledge the message
+	err = msg.Ack(false)
+	if err != nil {
+		c.logger.Error().
+			Err(err).
+			Str("delivery_tag", fmt.Sprintf("%d", msg.DeliveryTag)).
+			Msg("Error acknowledging message")
+	}
+}
+
 func (c *RabbitMQClient) processMessage(ctx context.Context, msg amqp.Delivery, processFunc rabbitmq.ProcessFunc) error {
-	var task rabbitmq.Task
-	err := json.Unmarshal(msg.Body, &task)
+	task, err := rabbitmq.Unmarshal(msg.Body, msg.ContentType)
 	if err != nil {
 		return fmt.Errorf("error unmarshaling message: %w", err)
 	}
+	if task.RequestID == "" {
+		task.RequestID = msg.CorrelationId
+	}
 
 	c.logger.Debug().
 		Str("task_id", task.ID).
@@ -290,13 +594,72 @@ func (c *RabbitMQClient) processMessage(ctx context.Context, msg amqp.Delivery,
 	return nil
 }
 
+// Stats returns the depth and consumers of the default queue and the queues of their own
+// of task types. The queues are inspected on a channel of their own, as the broker closes
+// the channel of a failed inspection.
+func (c *RabbitMQClient) Stats(ctx context.Context) (rabbitmq.Stats, error) {
+	var stats rabbitmq.Stats
+
+	channel, err := c.conn.Channel()
+	if err != nil {
+		return stats, fmt.Errorf("error creating channel: %w", err)
+	}
+	defer channel.Close()
+
+	queues := map[string]int{c.queueName: c.prefetch}
+	for _, queue := range c.taskQueues {
+		queues[queue.name] = queue.prefetch
+	}
+	for name, prefetch := range queues {
+		if err := ctx.Err(); err != nil {
+			return stats, err
+		}
+		queue, err := channel.QueueDeclarePassive(
+			name,  // name
+			true,  // durable
+			false, // delete when unused
+			false, // exclusive
+			false, // no-wait
+			nil,   // arguments
+		)
+		if err != nil {
+			return stats, fmt.Errorf("error inspecting queue %s: %w", name, err)
+		}
+		stats.Depth += queue.Messages
+		stats.Consumers += queue.Consumers
+		stats.Capacity += queue.Consumers * prefetch
+	}
+	return stats, nil
+}
+
+// Ping checks that the connection and channel are open
+func (c *RabbitMQClient) Ping() error {
+	if c.conn == nil || c.conn.IsClosed() {
+		return errors.New("connection is closed")
+	}
+	if c.channel == nil || c.channel.IsClosed() {
+		return errors.New("channel is closed")
+	}
+	for _, queue := range c.taskQueues {
+		if queue.channel.IsClosed() {
+			return fmt.Errorf("channel of queue %s is closed", queue.name)
+		}
+	}
+	return nil
+}
+
 // Close closes the RabbitMQ connection
 func (c *RabbitMQClient) Close() error {
 	var err error
 	var channelErr, connErr error
 
+	for _, queue := range c.taskQueues {
+		if err := queue.channel.Close(); err != nil {
+			channelErr = errors.Join(channelErr, fmt.Errorf("queue %s: %w", queue.name, err))
+		}
+	}
 	if c.channel != nil {
-		channelErr = c.channel.Close()
+		channelErr = errors.Join(channelErr, c.channel.Close())
 	}
 
 	if c.conn != nil {
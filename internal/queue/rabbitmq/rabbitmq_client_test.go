@@ -0,0 +1,206 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/rs/zerolog"
+)
+
+// fakeSource is a deliverySource whose deliveries are sent by the test. Cancelling the
+// consumer signals cancelled; the test closes the deliveries once it sent the ones the
+// broker had in flight.
+type fakeSource struct {
+	deliveries chan amqp.Delivery
+	cancelled  chan struct{}
+	closeOnce  sync.Once
+	// closeOnCancel closes the deliveries when the consumer is cancelled
+	closeOnCancel bool
+}
+
+func newFakeSource(closeOnCancel bool) *fakeSource {
+	return &fakeSource{
+		deliveries:    make(chan amqp.Delivery, 16),
+		cancelled:     make(chan struct{}),
+		closeOnCancel: closeOnCancel,
+	}
+}
+
+func (s *fakeSource) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error) {
+	return s.deliveries, nil
+}
+
+func (s *fakeSource) Cancel(consumer string, noWait bool) error {
+	close(s.cancelled)
+	if s.closeOnCancel {
+		s.close()
+	}
+	return nil
+}
+
+func (s *fakeSource) close() {
+	s.closeOnce.Do(func() { close(s.deliveries) })
+}
+
+// fakeAcknowledger records the acknowledgements of deliveries by tag
+type fakeAcknowledger struct {
+	mu       sync.Mutex
+	acked    []uint64
+	requeued []uint64
+}
+
+func (a *fakeAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acked = append(a.acked, tag)
+	return nil
+}
+
+func (a *fakeAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if requeue {
+		a.requeued = append(a.requeued, tag)
+	}
+	return nil
+}
+
+func (a *fakeAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func (a *fakeAcknowledger) results() (acked, requeued []uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]uint64(nil), a.acked...), append([]uint64(nil), a.requeued...)
+}
+
+func delivery(t *testing.T, ack *fakeAcknowledger, tag uint64) amqp.Delivery {
+	t.Helper()
+	body, contentType, err := rabbitmq.Marshal(rabbitmq.Task{ID: "task", Type: rabbitmq.TaskTypeResizeImage}, rabbitmq.SerializationJSON)
+	if err != nil {
+		t.Fatalf("marshal task: %v", err)
+	}
+	return amqp.Delivery{Acknowledger: ack, DeliveryTag: tag, Body: body, ContentType: contentType}
+}
+
+// startConsuming consumes source the way Consume does, returning the function stopping
+// the consumption
+func startConsuming(t *testing.T, source *fakeSource, processFunc rabbitmq.ProcessFunc) (*RabbitMQClient, context.CancelFunc) {
+	t.Helper()
+	c := &RabbitMQClient{logger: zerolog.Nop()}
+	ctx, stop := context.WithCancel(logger.ToContext(context.Background(), zerolog.Nop()))
+	taskCtx, cancelTasks := context.WithCancel(context.WithoutCancel(ctx))
+	c.cancelTasks = cancelTasks
+
+	if err := c.consume(ctx, taskCtx, source, "images", "test", processFunc); err != nil {
+		t.Fatalf("consume: %v", err)
+	}
+	t.Cleanup(func() {
+		stop()
+		cancelTasks()
+	})
+	return c, stop
+}
+
+func TestDrainAcksTasksFinishedBeforeDeadline(t *testing.T) {
+	ack := &fakeAcknowledger{}
+	source := newFakeSource(true)
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	c, stop := startConsuming(t, source, func(ctx context.Context, task rabbitmq.Task) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	})
+
+	source.deliveries <- delivery(t, ack, 1)
+	source.deliveries <- delivery(t, ack, 2)
+	<-started
+	<-started
+
+	stop()
+	<-source.cancelled
+	close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Drain(ctx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+
+	acked, requeued := ack.results()
+	if len(acked) != 2 || len(requeued) != 0 {
+		t.Errorf("acked %v and requeued %v, want both tasks acked", acked, requeued)
+	}
+}
+
+func TestDrainRequeuesTasksAfterDeadline(t *testing.T) {
+	ack := &fakeAcknowledger{}
+	source := newFakeSource(false)
+	started := make(chan struct{}, 1)
+	c, stop := startConsuming(t, source, func(ctx context.Context, task rabbitmq.Task) error {
+		started <- struct{}{}
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	source.deliveries <- delivery(t, ack, 1)
+	<-started
+
+	stop()
+	<-source.cancelled
+	// A delivery the broker sent before the cancellation is requeued without processing
+	source.deliveries <- delivery(t, ack, 2)
+	source.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Drain returned %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	acked, requeued := ack.results()
+	if len(acked) != 0 || len(requeued) != 2 {
+		t.Errorf("acked %v and requeued %v, want both tasks requeued", acked, requeued)
+	}
+}
+
+func TestStopConsumingAcceptsNoNewDeliveries(t *testing.T) {
+	ack := &fakeAcknowledger{}
+	source := newFakeSource(false)
+	var processed int
+	var mu sync.Mutex
+	c, stop := startConsuming(t, source, func(ctx context.Context, task rabbitmq.Task) error {
+		mu.Lock()
+		processed++
+		mu.Unlock()
+		return nil
+	})
+
+	stop()
+	<-source.cancelled
+	for tag := uint64(1); tag <= 3; tag++ {
+		source.deliveries <- delivery(t, ack, tag)
+	}
+	source.close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Drain(ctx); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	acked, requeued := ack.results()
+	if processed != 0 || len(acked) != 0 || len(requeued) != 3 {
+		t.Errorf("processed %d, acked %v and requeued %v, want the 3 deliveries requeued unprocessed", processed, acked, requeued)
+	}
+}
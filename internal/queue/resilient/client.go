@@ -0,0 +1,40 @@
+// Package resilient wraps a queue client with a circuit breaker
+package resilient
+
+import (
+	"context"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
+	"github.com/not-nullexception/image-optimizer/internal/resilience"
+)
+
+// Client publishes through another queue client behind a circuit breaker, so that
+// uploads fall back to the outbox at once while the broker is down. Publishes are not
+// retried here: the outbox relay re-publishes them.
+type Client struct {
+	rabbitmq.Client
+
+	policy *resilience.Policy
+}
+
+// NewClient wraps next with a circuit breaker
+func NewClient(next rabbitmq.Client, cfg *config.ResilienceConfig) rabbitmq.Client {
+	initLogger := logger.GetLogger("resilient-queue-client")
+	initLogger.Info().
+		Int("failure_threshold", cfg.FailureThreshold).
+		Dur("open_timeout", cfg.OpenTimeout).
+		Msg("Queue circuit breaker enabled")
+
+	return &Client{
+		Client: next,
+		policy: resilience.NewPolicy("rabbitmq", cfg, nil),
+	}
+}
+
+func (c *Client) Publish(ctx context.Context, task rabbitmq.Task) error {
+	return c.policy.Call(ctx, func() error {
+		return c.Client.Publish(ctx, task)
+	})
+}
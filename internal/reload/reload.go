@@ -0,0 +1,86 @@
+// Package reload re-reads the configuration at runtime and hands the reloadable
+// settings to the live components of a service.
+package reload
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/rs/zerolog"
+)
+
+// Component is a live component that adopts the reloadable settings of cfg. Settings
+// that need a restart must be ignored.
+type Component interface {
+	Reconfigure(cfg *config.Config)
+}
+
+// Reloader applies reloaded configurations to the registered components. The log
+// level is always applied.
+type Reloader struct {
+	mu         sync.Mutex
+	components []Component
+	logger     zerolog.Logger
+}
+
+func New() *Reloader {
+	return &Reloader{
+		logger: logger.GetLogger("reload"),
+	}
+}
+
+// Register adds c to the components reconfigured on every reload
+func (r *Reloader) Register(c Component) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.components = append(r.components, c)
+}
+
+// Reload re-reads the configuration and applies it. Reloads are serialized so the
+// components always end up with the same, latest configuration.
+func (r *Reloader) Reload() (*config.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := config.Reload()
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to reload configuration")
+		return nil, fmt.Errorf("error reloading configuration: %w", err)
+	}
+
+	logger.SetLevel(cfg.Log.Level)
+	for _, c := range r.components {
+		c.Reconfigure(cfg)
+	}
+
+	r.logger.Info().
+		Str("log_level", cfg.Log.Level).
+		Int("components", len(r.components)).
+		Msg("Configuration reloaded")
+
+	return cfg, nil
+}
+
+// WatchSignal reloads the configuration on every SIGHUP until ctx is done
+func (r *Reloader) WatchSignal(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.logger.Info().Msg("Received SIGHUP, reloading configuration")
+			_, _ = r.Reload()
+		}
+	}
+}
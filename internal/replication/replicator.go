@@ -0,0 +1,137 @@
+// Package replication copies the optimized objects of completed images to a secondary
+// MinIO/S3 target, for disaster recovery.
+package replication
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+	"github.com/rs/zerolog"
+)
+
+// Replicator copies the optimized objects of images that changed since their last copy
+type Replicator struct {
+	repo   db.Repository
+	source minio.Client
+	target minio.Client
+	config *config.ReplicationConfig
+	logger zerolog.Logger
+}
+
+// NewReplicator creates a new Replicator copying from source to target
+func NewReplicator(repo db.Repository, source, target minio.Client, cfg *config.ReplicationConfig) *Replicator {
+	return &Replicator{
+		repo:   repo,
+		source: source.In(minio.ClassOptimized),
+		target: target.In(minio.ClassOptimized),
+		config: cfg,
+		logger: logger.GetLogger("replication"),
+	}
+}
+
+// Run replicates the pending images every interval until ctx is cancelled
+func (r *Replicator) Run(ctx context.Context) {
+	r.logger.Info().Dur("interval", r.config.Interval).Msg("Starting replication")
+
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Info().Msg("Replication stopped")
+			return
+		case <-ticker.C:
+			r.sweep(logger.ToContext(ctx, r.logger))
+		}
+	}
+}
+
+// sweep replicates every pending image and updates the lag metrics with the ones left
+func (r *Replicator) sweep(ctx context.Context) {
+	var replicated, failed int
+	var oldest time.Time
+
+	filter := models.ImageFilter{AllOwners: true, Unreplicated: true}
+	err := r.repo.IterateImages(ctx, filter, r.config.BatchSize, func(img *models.Image) error {
+		if err := r.ReplicateImage(ctx, img); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			r.logger.Error().Err(err).Str("image_id", img.ID.String()).Msg("Failed to replicate image")
+			failed++
+			if oldest.IsZero() || img.UpdatedAt.Before(oldest) {
+				oldest = img.UpdatedAt
+			}
+			return nil
+		}
+		replicated++
+		return nil
+	})
+	if err != nil && ctx.Err() == nil {
+		r.logger.Error().Err(err).Msg("Failed to list images to replicate")
+	}
+
+	metrics.ReplicationPendingImages.Set(float64(failed))
+	lag := 0.0
+	if !oldest.IsZero() {
+		lag = time.Since(oldest).Seconds()
+	}
+	metrics.ReplicationLagSeconds.Set(lag)
+
+	if replicated > 0 || failed > 0 {
+		r.logger.Info().Int("replicated", replicated).Int("failed", failed).Msg("Replication pass finished")
+	}
+}
+
+// ReplicateImage copies the optimized, cut-out and rendition objects of img to the target
+// and records the result
+func (r *Replicator) ReplicateImage(ctx context.Context, img *models.Image) error {
+	paths := []string{img.OptimizedPath, img.CutoutPath}
+	for _, rendition := range img.Renditions {
+		paths = append(paths, rendition.Path)
+	}
+
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if err := r.copyObject(ctx, path); err != nil {
+			metrics.ReplicatedImagesTotal.WithLabelValues("failure").Inc()
+			if updateErr := r.repo.UpdateImageReplication(ctx, img.ID, models.ReplicationFailed, time.Now(), img.UpdatedAt); updateErr != nil {
+				r.logger.Error().Err(updateErr).Str("image_id", img.ID.String()).Msg("Failed to record replication failure")
+			}
+			return err
+		}
+	}
+
+	metrics.ReplicatedImagesTotal.WithLabelValues("success").Inc()
+	// Objects changed while they were copied stay pending for the next pass
+	return r.repo.UpdateImageReplication(ctx, img.ID, models.ReplicationReplicated, time.Now(), img.UpdatedAt)
+}
+
+// copyObject streams an object from the source to the target under the same name
+func (r *Replicator) copyObject(ctx context.Context, path string) error {
+	info, err := r.source.StatImage(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	reader, err := r.source.GetImage(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if err := r.target.UploadImage(ctx, reader, path, info.ContentType); err != nil {
+		return fmt.Errorf("error copying %s to the replication target: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package resilience
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff computes the delays between retries: exponential from Base, capped at Max,
+// with jitter so that callers don't retry in lockstep
+type Backoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// Delay returns the delay before retry n, counting from 1. A random part of up to
+// half the exponential delay is dropped.
+func (b Backoff) Delay(n int) time.Duration {
+	delay := b.Base << (max(n, 1) - 1)
+	if delay <= 0 || delay > b.Max {
+		delay = b.Max
+	}
+	half := delay / 2
+	return half + rand.N(half+1)
+}
@@ -0,0 +1,173 @@
+// Package resilience protects callers from failing dependencies with circuit breakers
+// and bounded, jittered retries.
+package resilience
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	"github.com/rs/zerolog"
+)
+
+// ErrOpen is returned without calling the dependency while its circuit breaker is open
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a circuit breaker
+type State int
+
+const (
+	// StateClosed lets all calls through
+	StateClosed State = iota
+	// StateHalfOpen lets a few trial calls through to probe the dependency
+	StateHalfOpen
+	// StateOpen rejects all calls until the open timeout has passed
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half_open"
+	default:
+		return "open"
+	}
+}
+
+// Breaker is a circuit breaker. It opens after threshold consecutive failures, rejects
+// calls for openTimeout, then lets halfOpenCalls trial calls through: the first success
+// closes it again and a failure opens it for another openTimeout.
+type Breaker struct {
+	name          string
+	threshold     int
+	openTimeout   time.Duration
+	halfOpenCalls int
+	logger        zerolog.Logger
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	trials   int
+}
+
+// NewBreaker returns a closed circuit breaker for the dependency name
+func NewBreaker(name string, threshold int, openTimeout time.Duration, halfOpenCalls int) *Breaker {
+	b := &Breaker{
+		name:          name,
+		threshold:     max(threshold, 1),
+		openTimeout:   openTimeout,
+		halfOpenCalls: max(halfOpenCalls, 1),
+		logger:        logger.GetLogger("circuit-breaker").With().Str("dependency", name).Logger(),
+	}
+	metrics.CircuitBreakerState.WithLabelValues(name).Set(float64(StateClosed))
+	return b
+}
+
+// State returns the current state of the breaker
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen && time.Since(b.openedAt) >= b.openTimeout {
+		return StateHalfOpen
+	}
+	return b.state
+}
+
+// allow admits a call, or returns ErrOpen. trial reports whether the call probes a
+// half-open breaker, and must be passed to done.
+func (b *Breaker) allow() (trial bool, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen {
+		if time.Since(b.openedAt) < b.openTimeout {
+			metrics.CircuitBreakerRejectedTotal.WithLabelValues(b.name).Inc()
+			return false, ErrOpen
+		}
+		b.setStateLocked(StateHalfOpen)
+	}
+
+	if b.state == StateHalfOpen {
+		if b.trials >= b.halfOpenCalls {
+			metrics.CircuitBreakerRejectedTotal.WithLabelValues(b.name).Inc()
+			return false, ErrOpen
+		}
+		b.trials++
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// outcome is what a call tells about the health of the dependency
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	// outcomeNeutral is a call that tells nothing about the dependency, such as one
+	// cancelled by its caller or asking for a missing object
+	outcomeNeutral
+)
+
+// done records the outcome of a call admitted by allow
+func (b *Breaker) done(trial bool, result outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case trial && b.state == StateHalfOpen:
+		b.trials--
+		switch result {
+		case outcomeFailure:
+			b.setStateLocked(StateOpen)
+		case outcomeSuccess:
+			b.setStateLocked(StateClosed)
+		}
+		// A neutral trial frees its slot for another one
+	case b.state == StateClosed:
+		switch result {
+		case outcomeSuccess:
+			b.failures = 0
+			return
+		case outcomeNeutral:
+			return
+		}
+		b.failures++
+		if b.failures >= b.threshold {
+			b.setStateLocked(StateOpen)
+		}
+	}
+	// Calls admitted before the breaker changed state don't affect it
+}
+
+func (b *Breaker) setStateLocked(state State) {
+	if b.state == state {
+		return
+	}
+
+	switch state {
+	case StateOpen:
+		b.openedAt = time.Now()
+		b.logger.Warn().
+			Int("failures", b.failures).
+			Dur("open_timeout", b.openTimeout).
+			Msg("Circuit breaker opened")
+	case StateClosed:
+		b.logger.Info().Msg("Circuit breaker closed")
+	case StateHalfOpen:
+		b.logger.Info().Msg("Circuit breaker half-open, probing dependency")
+	}
+
+	b.state = state
+	b.failures = 0
+	b.trials = 0
+	metrics.CircuitBreakerState.WithLabelValues(b.name).Set(float64(state))
+	metrics.CircuitBreakerTransitionsTotal.WithLabelValues(b.name, state.String()).Inc()
+}
@@ -0,0 +1,61 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/not-nullexception/image-optimizer/config"
+)
+
+// Policy calls a dependency through its circuit breaker
+type Policy struct {
+	name      string
+	breaker   *Breaker
+	permanent func(error) bool
+}
+
+// NewPolicy returns the policy of the dependency name. permanent reports errors that
+// are the caller's fault, such as a missing object: they count neither as failures nor
+// as successes of the dependency. It may be nil.
+func NewPolicy(name string, cfg *config.ResilienceConfig, permanent func(error) bool) *Policy {
+	if permanent == nil {
+		permanent = func(error) bool { return false }
+	}
+
+	return &Policy{
+		name:      name,
+		breaker:   NewBreaker(name, cfg.FailureThreshold, cfg.OpenTimeout, cfg.HalfOpenCalls),
+		permanent: permanent,
+	}
+}
+
+// Breaker returns the circuit breaker of the policy
+func (p *Policy) Breaker() *Breaker {
+	return p.breaker
+}
+
+// Call calls fn through the circuit breaker
+func (p *Policy) Call(ctx context.Context, fn func() error) error {
+	trial, err := p.breaker.allow()
+	if err != nil {
+		return fmt.Errorf("%s unavailable: %w", p.name, err)
+	}
+
+	err = fn()
+	p.breaker.done(trial, p.outcome(ctx, err))
+	return err
+}
+
+// outcome classifies the result of a call. Cancellations by the caller and permanent
+// errors neither count against the dependency nor prove it healthy.
+func (p *Policy) outcome(ctx context.Context, err error) outcome {
+	switch {
+	case err == nil:
+		return outcomeSuccess
+	case errors.Is(err, context.Canceled) && ctx.Err() != nil, p.permanent(err):
+		return outcomeNeutral
+	default:
+		return outcomeFailure
+	}
+}
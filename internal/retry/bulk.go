@@ -0,0 +1,130 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// ErrBulkRunning is returned when a bulk requeue is started while another one is running
+var ErrBulkRunning = errors.New("bulk requeue already running")
+
+// errLimitReached stops the iteration once the limit of a bulk requeue is reached
+var errLimitReached = errors.New("bulk requeue limit reached")
+
+// BulkFilter selects the failed images queued again by RequeueFailed
+type BulkFilter struct {
+	// Since limits the requeue to images that failed since, any if zero
+	Since time.Time
+	// ErrorLike limits the requeue to images whose error contains it, ignoring case
+	ErrorLike string
+	// Limit bounds the images requeued
+	Limit int
+	// DryRun only counts the matching images
+	DryRun bool
+}
+
+// BulkReport describes a bulk requeue
+type BulkReport struct {
+	DryRun bool `json:"dry_run"`
+	// Matched counts the failed images matching the filter, up to the limit
+	Matched  int `json:"matched"`
+	Requeued int `json:"requeued"`
+	// QueueFailed counts the images left queued_failed, whose tasks the outbox publishes later
+	QueueFailed int `json:"queue_failed"`
+	// Skipped counts the images withheld by moderation, without a recorded task, or no
+	// longer failed when their turn came
+	Skipped int `json:"skipped"`
+	Failed  int `json:"failed"`
+	// Limited reports whether more images matched than the limit allowed
+	Limited bool `json:"limited"`
+}
+
+// RequeueFailed queues the failed images matching filter again with the task they failed
+// with, newest first. Images are read BatchSize at a time and queued at no more than
+// BulkRate per second, so a large backlog doesn't flood the workers. Only one bulk requeue
+// runs at a time; ErrBulkRunning is returned meanwhile.
+func (r *Retrier) RequeueFailed(ctx context.Context, filter BulkFilter) (*BulkReport, error) {
+	if !r.bulk.TryLock() {
+		return nil, ErrBulkRunning
+	}
+	defer r.bulk.Unlock()
+
+	reqLogger := logger.FromContext(ctx)
+	report := &BulkReport{DryRun: filter.DryRun}
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / r.config.BulkRate))
+	defer ticker.Stop()
+
+	imageFilter := models.ImageFilter{
+		AllOwners:    true,
+		Status:       models.StatusFailed,
+		UpdatedSince: filter.Since,
+		ErrorLike:    filter.ErrorLike,
+	}
+	err := r.repo.IterateImages(ctx, imageFilter, r.config.BatchSize, func(img *models.Image) error {
+		if report.Matched == filter.Limit {
+			report.Limited = true
+			return errLimitReached
+		}
+		report.Matched++
+		if filter.DryRun {
+			return nil
+		}
+		if img.Withheld() {
+			report.Skipped++
+			return nil
+		}
+
+		task, err := r.LastTask(ctx, img.ID)
+		if errors.Is(err, ErrNoTask) {
+			report.Skipped++
+			return nil
+		}
+		if err != nil {
+			reqLogger.Error().Err(err).Str("image_id", img.ID.String()).Msg("Failed to load task to requeue")
+			report.Failed++
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		status, err := r.Requeue(ctx, img.ID, task, false)
+		switch {
+		case errors.Is(err, db.ErrStatusConflict):
+			// the image was retried or reprocessed since it was listed
+			report.Skipped++
+		case err != nil:
+			reqLogger.Error().Err(err).Str("image_id", img.ID.String()).Msg("Failed to requeue image")
+			report.Failed++
+		case status == models.StatusQueueFailed:
+			report.QueueFailed++
+		default:
+			report.Requeued++
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errLimitReached) {
+		reqLogger.Error().Err(err).Int("requeued", report.Requeued).Msg("Bulk requeue interrupted")
+		return nil, err
+	}
+
+	reqLogger.Info().
+		Bool("dry_run", report.DryRun).
+		Int("matched", report.Matched).
+		Int("requeued", report.Requeued).
+		Int("queue_failed", report.QueueFailed).
+		Int("skipped", report.Skipped).
+		Int("failed", report.Failed).
+		Msg("Bulk requeue finished")
+
+	return report, nil
+}
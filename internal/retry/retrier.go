@@ -0,0 +1,154 @@
+// Package retry queues failed images for processing again, on request or automatically
+// with a backoff for failures that may not repeat, such as storage timeouts.
+package retry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	"github.com/not-nullexception/image-optimizer/internal/outbox"
+	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
+	"github.com/rs/zerolog"
+)
+
+// ErrNoTask is returned for images without a recorded resize task to retry
+var ErrNoTask = errors.New("no task recorded for image")
+
+// Retrier queues failed images again with the resize task they failed with
+type Retrier struct {
+	repo   db.Repository
+	outbox *outbox.Relay
+	config *config.RetryConfig
+	logger zerolog.Logger
+	// bulk is held while a bulk requeue runs
+	bulk sync.Mutex
+}
+
+// NewRetrier creates a new Retrier
+func NewRetrier(repo db.Repository, relay *outbox.Relay, cfg *config.RetryConfig) *Retrier {
+	return &Retrier{
+		repo:   repo,
+		outbox: relay,
+		config: cfg,
+		logger: logger.GetLogger("image-retrier"),
+	}
+}
+
+// LastTask returns the last resize task recorded for an image, with a new ID so the
+// retry runs as a task of its own. It fails with ErrNoTask if none was recorded.
+func (r *Retrier) LastTask(ctx context.Context, id uuid.UUID) (rabbitmq.Task, error) {
+	record, err := r.repo.LastTask(ctx, id, string(rabbitmq.TaskTypeResizeImage))
+	if errors.Is(err, db.ErrNotFound) || (err == nil && len(record.Payload) == 0) {
+		return rabbitmq.Task{}, ErrNoTask
+	}
+	if err != nil {
+		return rabbitmq.Task{}, err
+	}
+
+	var task rabbitmq.Task
+	if err := json.Unmarshal(record.Payload, &task); err != nil {
+		return rabbitmq.Task{}, fmt.Errorf("error decoding task of image %s: %w", id, err)
+	}
+	task.ID = uuid.NewString()
+	task.RequestID = ""
+	return task, nil
+}
+
+// Requeue moves a failed image back to pending and publishes task for it, counting the
+// retry if it is automatic. It returns the status the image was left in, and fails with
+// db.ErrStatusConflict if the image is not failed.
+func (r *Retrier) Requeue(ctx context.Context, id uuid.UUID, task rabbitmq.Task, automatic bool) (models.ProcessingStatus, error) {
+	reqLogger := logger.FromContext(ctx)
+
+	if err := r.repo.RetryImage(ctx, id, automatic); err != nil {
+		return "", err
+	}
+
+	trigger := "manual"
+	if automatic {
+		trigger = "automatic"
+	}
+	metrics.ImageRetriesTotal.WithLabelValues(trigger).Inc()
+
+	published, err := r.outbox.Publish(ctx, id, task)
+	if err != nil {
+		if updateErr := r.repo.UpdateImageStatus(ctx, id, models.StatusFailed, "processing queue unavailable"); updateErr != nil {
+			reqLogger.Error().Err(updateErr).Str("image_id", id.String()).Msg("Failed to mark unqueued image as failed")
+		}
+		return models.StatusFailed, err
+	}
+	if !published {
+		if err := r.repo.UpdateImageStatus(ctx, id, models.StatusQueueFailed, "processing queue unavailable, task will be retried"); err != nil {
+			reqLogger.Error().Err(err).Str("image_id", id.String()).Msg("Failed to mark image as queued_failed")
+		}
+		return models.StatusQueueFailed, nil
+	}
+	return models.StatusPending, nil
+}
+
+// Run retries the failed images that are due every Interval until ctx is cancelled
+func (r *Retrier) Run(ctx context.Context) {
+	r.logger.Info().Dur("interval", r.config.Interval).Int("max_retries", r.config.MaxRetries).Msg("Starting automatic image retries")
+
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			r.logger.Info().Msg("Automatic image retries stopped")
+			return
+		case <-ticker.C:
+			r.sweep(logger.ToContext(ctx, r.logger))
+		}
+	}
+}
+
+// sweep retries one batch of due images
+func (r *Retrier) sweep(ctx context.Context) {
+	images, err := r.repo.ListRetryableImages(ctx, r.config.MaxRetries, r.config.BaseDelay, r.config.MaxDelay, r.config.BatchSize)
+	if err != nil {
+		r.logger.Error().Err(err).Msg("Failed to list images to retry")
+		return
+	}
+
+	var retried int
+	for _, img := range images {
+		task, err := r.LastTask(ctx, img.ID)
+		if err != nil {
+			r.logger.Error().Err(err).Str("image_id", img.ID.String()).Msg("Failed to load task to retry")
+			continue
+		}
+
+		status, err := r.Requeue(ctx, img.ID, task, true)
+		if errors.Is(err, db.ErrStatusConflict) {
+			// the image was retried or reprocessed since it was listed
+			continue
+		}
+		if err != nil {
+			r.logger.Error().Err(err).Str("image_id", img.ID.String()).Msg("Failed to retry image")
+			continue
+		}
+
+		retried++
+		r.logger.Info().
+			Str("image_id", img.ID.String()).
+			Int("retry", img.AutoRetries+1).
+			Str("status", string(status)).
+			Msg("Failed image queued for automatic retry")
+	}
+
+	if len(images) > 0 {
+		r.logger.Info().Int("retried", retried).Msg("Automatic image retries finished")
+	}
+}
@@ -0,0 +1,104 @@
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// clamdChunkSize is the size of the chunks streamed to clamd; it must stay below the
+// StreamMaxLength of the daemon
+const clamdChunkSize = 64 * 1024
+
+// clamdScanner streams uploads to a ClamAV daemon over TCP with the INSTREAM command
+type clamdScanner struct {
+	address string
+	timeout time.Duration
+}
+
+func newClamdScanner(cfg *config.ScanConfig) *clamdScanner {
+	return &clamdScanner{
+		address: cfg.Address,
+		timeout: cfg.Timeout,
+	}
+}
+
+// Scan sends r to clamd and parses its verdict
+func (s *clamdScanner) Scan(ctx context.Context, r io.Reader) (*Result, error) {
+	reqLogger := logger.FromContext(ctx).With().Str("component", "scan-clamd").Logger()
+
+	dialer := net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", s.address)
+	if err != nil {
+		reqLogger.Error().Err(err).Str("address", s.address).Msg("Error connecting to clamd")
+		return nil, fmt.Errorf("error connecting to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(s.timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("error setting clamd deadline: %w", err)
+	}
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, fmt.Errorf("error sending clamd command: %w", err)
+	}
+
+	// The stream is sent as length-prefixed chunks and terminated by a zero-length chunk
+	chunk := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := r.Read(chunk)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return nil, fmt.Errorf("error streaming to clamd: %w", err)
+			}
+			if _, err := conn.Write(chunk[:n]); err != nil {
+				return nil, fmt.Errorf("error streaming to clamd: %w", err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("error reading upload for scanning: %w", readErr)
+		}
+	}
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return nil, fmt.Errorf("error streaming to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return nil, fmt.Errorf("error reading clamd reply: %w", err)
+	}
+	reply = strings.TrimRight(reply, "\x00\n")
+
+	// Replies look like "stream: OK", "stream: Eicar-Signature FOUND" or "... ERROR"
+	verdict := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case verdict == "OK":
+		reqLogger.Debug().Msg("Upload scanned clean")
+		return &Result{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		signature := strings.TrimSuffix(verdict, " FOUND")
+		reqLogger.Warn().Str("signature", signature).Msg("Malware found in upload")
+		return &Result{Infected: true, Signature: signature}, nil
+	default:
+		reqLogger.Error().Str("reply", reply).Msg("clamd returned an error")
+		return nil, fmt.Errorf("clamd returned an error: %s", reply)
+	}
+}
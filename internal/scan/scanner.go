@@ -0,0 +1,40 @@
+package scan
+
+import (
+	"context"
+	"io"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// Result is the outcome of scanning an upload
+type Result struct {
+	Infected bool
+	// Signature names the malware found in an infected upload
+	Signature string
+}
+
+// Scanner checks uploads for viruses and other malware
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (*Result, error)
+}
+
+// NewScanner returns the Scanner for the configured provider,
+// or nil if malware scanning is disabled.
+func NewScanner(cfg *config.ScanConfig) Scanner {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	initLogger := logger.GetLogger("scan")
+
+	switch cfg.Provider {
+	case "clamd":
+		initLogger.Info().Str("provider", cfg.Provider).Str("address", cfg.Address).Bool("fail_open", cfg.FailOpen).Msg("Malware scanning enabled")
+		return newClamdScanner(cfg)
+	default:
+		initLogger.Warn().Str("provider", cfg.Provider).Msg("Unknown malware scanning provider, scanning disabled")
+		return nil
+	}
+}
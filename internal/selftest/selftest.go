@@ -0,0 +1,298 @@
+// Package selftest runs a built-in test image through the whole pipeline, from storage
+// and database to the queue, a worker and back, as a synthetic canary of the system.
+package selftest
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/cdn"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/deletion"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+	"github.com/not-nullexception/image-optimizer/internal/outbox"
+	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
+)
+
+// Stages of a self-test, in order
+const (
+	StageUpload     = "upload"
+	StageRecord     = "record"
+	StageEnqueue    = "enqueue"
+	StageQueueWait  = "queue_wait"
+	StageProcessing = "processing"
+	StageVerify     = "verify"
+	StageCleanup    = "cleanup"
+)
+
+// fileName is the name of the test image, which tells it apart in logs and audit records
+const fileName = "selftest.png"
+
+// testImageSize is the width and height of the test image
+const testImageSize = 64
+
+// pollInterval is how often the processing history of the test image is checked
+const pollInterval = 200 * time.Millisecond
+
+// cleanupTimeout bounds the removal of the test image, which runs even after the test
+// timed out
+const cleanupTimeout = 10 * time.Second
+
+// ErrRunning is returned when a self-test is started while another one is running
+var ErrRunning = errors.New("self-test already running")
+
+// Report is the outcome of a self-test and the latency of each stage it reached
+type Report struct {
+	Passed    bool      `json:"passed"`
+	ImageID   uuid.UUID `json:"image_id"`
+	Stages    []Stage   `json:"stages"`
+	LatencyMS float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Stage is a step of a self-test
+type Stage struct {
+	Name      string  `json:"name"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// add records a stage that took d, failed with err if not nil, and returns err
+func (r *Report) add(name string, d time.Duration, err error) error {
+	stage := Stage{Name: name, LatencyMS: milliseconds(d)}
+	if err != nil {
+		stage.Error = err.Error()
+	}
+	r.Stages = append(r.Stages, stage)
+	metrics.SelfTestStageDuration.WithLabelValues(name).Observe(d.Seconds())
+	return err
+}
+
+// Runner runs self-tests, one at a time
+type Runner struct {
+	repo        db.Repository
+	minioClient minio.Client
+	relay       *outbox.Relay
+	purger      *deletion.Purger
+	processing  *config.ProcessingConfig
+	config      *config.SelfTestConfig
+	running     sync.Mutex
+}
+
+// NewRunner creates a new Runner. The test image is queued through the outbox like
+// uploads and removed like deleted images.
+func NewRunner(repo db.Repository, minioClient minio.Client, queueClient rabbitmq.Client, cfg *config.Config) *Runner {
+	return &Runner{
+		repo:        repo,
+		minioClient: minioClient,
+		relay:       outbox.NewRelay(repo, queueClient, &cfg.Outbox),
+		purger:      deletion.NewPurger(repo, minioClient, cdn.NewInvalidator(&cfg.CDN), &cfg.Delete),
+		processing:  &cfg.Processing,
+		config:      &cfg.SelfTest,
+	}
+}
+
+// Run uploads the test image, waits up to the configured timeout for a worker to process
+// it, checks the optimized image was stored and removes the test image again. Failures
+// of the pipeline are reported rather than returned; the test image is removed whatever
+// stage it reached.
+func (r *Runner) Run(ctx context.Context) (*Report, error) {
+	if !r.running.TryLock() {
+		return nil, ErrRunning
+	}
+	defer r.running.Unlock()
+
+	reqLogger := logger.FromContext(ctx)
+	start := time.Now()
+	report := &Report{ImageID: uuid.New(), Stages: []Stage{}}
+
+	testCtx, cancel := context.WithTimeout(ctx, r.config.Timeout)
+	defer cancel()
+
+	img, err := r.submit(testCtx, report)
+	if err == nil {
+		err = r.await(testCtx, report)
+	}
+	if err == nil {
+		err = r.verify(testCtx, report)
+	}
+	if img != nil {
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cleanupTimeout)
+		err = errors.Join(err, r.cleanup(cleanupCtx, report, img))
+		cancel()
+	}
+
+	report.LatencyMS = milliseconds(time.Since(start))
+	report.Passed = err == nil
+	result := "passed"
+	if err != nil {
+		result = "failed"
+		report.Error = err.Error()
+		reqLogger.Warn().Err(err).Str("image_id", report.ImageID.String()).Msg("Self-test failed")
+	} else {
+		reqLogger.Info().Str("image_id", report.ImageID.String()).Float64("latency_ms", report.LatencyMS).Msg("Self-test passed")
+	}
+	metrics.SelfTestsTotal.WithLabelValues(result).Inc()
+
+	return report, nil
+}
+
+// submit stores the test image, creates its record and queues it for processing. It
+// returns the image once its record exists, so that it is cleaned up.
+func (r *Runner) submit(ctx context.Context, report *Report) (*models.Image, error) {
+	data := testImage()
+	objectName, err := r.minioClient.GenerateObjectName(report.ImageID, fileName, bytes.NewReader(data))
+	if err != nil {
+		return nil, report.add(StageUpload, 0, fmt.Errorf("error generating object name: %w", err))
+	}
+
+	start := time.Now()
+	err = minio.StoreOriginal(ctx, r.minioClient.In(minio.ClassOriginal), bytes.NewReader(data), objectName, "image/png")
+	if report.add(StageUpload, time.Since(start), err) != nil {
+		return nil, err
+	}
+
+	img := models.NewImageWithID(report.ImageID, fileName, int64(len(data)), testImageSize, testImageSize, "png", objectName)
+	sha, sum := sha256.Sum256(data), md5.Sum(data)
+	img.OriginalChecksum = hex.EncodeToString(sha[:])
+	img.OriginalMD5 = hex.EncodeToString(sum[:])
+
+	start = time.Now()
+	err = r.repo.CreateImage(ctx, img)
+	if report.add(StageRecord, time.Since(start), err) != nil {
+		cleanupErr := minio.Release(context.WithoutCancel(ctx), r.minioClient.In(minio.ClassOriginal), r.repo, objectName, img.ID)
+		return nil, errors.Join(err, cleanupErr)
+	}
+
+	start = time.Now()
+	published, err := r.relay.Publish(ctx, img.ID, r.resizeTask(img))
+	if err == nil && !published {
+		err = errors.New("queue unavailable, task left in the outbox")
+	}
+	return img, report.add(StageEnqueue, time.Since(start), err)
+}
+
+// resizeTask builds the resize task of the test image with the processing defaults
+func (r *Runner) resizeTask(img *models.Image) rabbitmq.Task {
+	return rabbitmq.NewImageTask(rabbitmq.TaskTypeResizeImage, rabbitmq.ImagePayload{
+		ImageID:      img.ID.String(),
+		OriginalPath: img.OriginalPath,
+		Filename:     img.OriginalName,
+		Config: &rabbitmq.ResizeConfig{
+			MaxWidth:        r.processing.DefaultMaxWidth,
+			MaxHeight:       r.processing.DefaultMaxHeight,
+			Quality:         r.processing.QualityFor(img.OriginalFormat),
+			OptimizeStorage: r.processing.DefaultOptimizeStorage,
+		},
+	})
+}
+
+// await waits for a worker to finish processing the test image. The time spent queued
+// and processing is taken from the status transitions recorded by the database, which
+// the repository cache does not hold.
+func (r *Runner) await(ctx context.Context, report *Report) error {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	for {
+		events, err := r.repo.ListImageEvents(ctx, report.ImageID)
+		if err != nil && ctx.Err() == nil {
+			return report.add(StageQueueWait, time.Since(start), fmt.Errorf("error reading processing history: %w", err))
+		}
+
+		status := models.StatusPending
+		var queued, processed time.Duration
+		var failure string
+		for _, event := range events {
+			status = event.Status
+			switch event.Status {
+			case models.StatusProcessing:
+				queued = time.Duration(event.DurationMs) * time.Millisecond
+			case models.StatusCompleted, models.StatusFailed:
+				processed = time.Duration(event.DurationMs) * time.Millisecond
+				failure = event.Error
+			}
+		}
+
+		switch status {
+		case models.StatusCompleted:
+			report.add(StageQueueWait, queued, nil)
+			return report.add(StageProcessing, processed, nil)
+		case models.StatusFailed:
+			report.add(StageQueueWait, queued, nil)
+			return report.add(StageProcessing, processed, fmt.Errorf("processing failed: %s", failure))
+		}
+
+		select {
+		case <-ctx.Done():
+			waited := time.Since(start)
+			if status != models.StatusProcessing {
+				return report.add(StageQueueWait, waited, fmt.Errorf("not picked up by a worker in time: %w", ctx.Err()))
+			}
+			report.add(StageQueueWait, queued, nil)
+			return report.add(StageProcessing, waited-queued, fmt.Errorf("not processed in time: %w", ctx.Err()))
+		case <-ticker.C:
+		}
+	}
+}
+
+// verify checks that the optimized test image is stored
+func (r *Runner) verify(ctx context.Context, report *Report) error {
+	start := time.Now()
+	img, err := r.repo.GetImageByID(ctx, report.ImageID)
+	if err == nil && img.OptimizedPath == "" {
+		err = errors.New("processed image has no optimized object")
+	}
+	if err == nil {
+		_, err = r.minioClient.In(minio.ClassOptimized).StatImage(ctx, img.OptimizedPath)
+	}
+	return report.add(StageVerify, time.Since(start), err)
+}
+
+// cleanup deletes the test image and its objects. The record is read again for the
+// objects created by the worker, falling back to the image as submitted.
+func (r *Runner) cleanup(ctx context.Context, report *Report, submitted *models.Image) error {
+	start := time.Now()
+	img, err := r.repo.GetImageByID(ctx, submitted.ID)
+	if err != nil {
+		img = submitted
+	}
+	return report.add(StageCleanup, time.Since(start), r.purger.Purge(ctx, img))
+}
+
+// testImage returns the test image, a small PNG gradient
+var testImage = sync.OnceValue(func() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, testImageSize, testImageSize))
+	for y := range testImageSize {
+		for x := range testImageSize {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: 128, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		panic(fmt.Sprintf("encoding self-test image: %v", err))
+	}
+	return buf.Bytes()
+})
+
+// milliseconds returns d in fractional milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,82 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	prometheusbridge "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	metricsdk "go.opentelemetry.io/otel/sdk/metric"
+
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// MetricsConfig holds the configuration for OTLP metrics export
+type MetricsConfig struct {
+	ServiceName    string
+	ServiceVersion string
+	Environment    string
+	OTLPEndpoint   string
+	Interval       time.Duration
+	Enabled        bool
+	// Insecure exports over plaintext gRPC instead of TLS, for collectors without TLS
+	Insecure bool
+}
+
+// InitMetrics starts an OpenTelemetry MeterProvider that periodically exports the metrics
+// registered with Prometheus over OTLP, so the existing counters and histograms reach
+// backends that cannot scrape /metrics.
+func InitMetrics(ctx context.Context, cfg MetricsConfig) (func(), error) {
+	log := logger.GetLogger("otel-metrics")
+
+	if !cfg.Enabled {
+		log.Info().Msg("OTLP metrics export is disabled")
+		return func() {}, nil
+	}
+
+	if cfg.OTLPEndpoint == "" {
+		return nil, fmt.Errorf("OTLP endpoint is required")
+	}
+
+	opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithEndpoint(cfg.OTLPEndpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlpmetricgrpc.WithInsecure())
+	}
+	exporter, err := otlpmetricgrpc.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP metric exporter: %w", err)
+	}
+
+	res, err := newResource(ctx, cfg.ServiceName, cfg.ServiceVersion, cfg.Environment)
+	if err != nil {
+		return nil, err
+	}
+
+	// The bridge reads the Prometheus default registry on every export
+	reader := metricsdk.NewPeriodicReader(exporter,
+		metricsdk.WithInterval(cfg.Interval),
+		metricsdk.WithProducer(prometheusbridge.NewMetricProducer()),
+	)
+
+	mp := metricsdk.NewMeterProvider(
+		metricsdk.WithReader(reader),
+		metricsdk.WithResource(res),
+	)
+	otel.SetMeterProvider(mp)
+
+	log.Info().
+		Str("service", cfg.ServiceName).
+		Str("otlp_endpoint", cfg.OTLPEndpoint).
+		Dur("interval", cfg.Interval).
+		Msg("OTLP metrics export initialized")
+
+	return func() {
+		if err := mp.Shutdown(context.Background()); err != nil {
+			log.Error().Err(err).Msg("Error shutting down meter provider")
+		} else {
+			log.Info().Msg("Meter provider shut down successfully")
+		}
+	}, nil
+}
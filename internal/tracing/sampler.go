@@ -0,0 +1,103 @@
+package tracing
+
+import (
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	tracesdk "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.23.1"
+)
+
+// Sampler types
+const (
+	// SamplerAlwaysOn keeps every trace
+	SamplerAlwaysOn = "always_on"
+	// SamplerAlwaysOff keeps no trace, except on routes with a ratio of their own
+	SamplerAlwaysOff = "always_off"
+	// SamplerRatio keeps the configured ratio of new traces
+	SamplerRatio = "ratio"
+	// SamplerTail exports every trace for the collector to sample once traces are complete,
+	// with the ratio the trace would have been kept at as the sampling.ratio attribute of
+	// its root span
+	SamplerTail = "tail"
+)
+
+// samplingRatioKey is the attribute hinting a tail sampling collector at the ratio a trace
+// should be kept at, unless its policies keep it anyway, such as for errors
+const samplingRatioKey = attribute.Key("sampling.ratio")
+
+// SamplingConfig configures which traces are kept
+type SamplingConfig struct {
+	Sampler string
+	Ratio   float64
+	// RouteRatios overrides Ratio for the server spans of the API routes listed, such as
+	// 0 to never sample health checks
+	RouteRatios map[string]float64
+}
+
+// newSampler returns the sampler of cfg. Spans with a parent follow its decision, so a
+// trace is kept or dropped as a whole.
+func newSampler(cfg SamplingConfig) (tracesdk.Sampler, error) {
+	root := &routeSampler{
+		ratio:  cfg.Ratio,
+		routes: make(map[string]routeRatio, len(cfg.RouteRatios)),
+		tail:   cfg.Sampler == SamplerTail,
+	}
+	for route, ratio := range cfg.RouteRatios {
+		root.routes[route] = routeRatio{ratio: ratio, sampler: tracesdk.TraceIDRatioBased(ratio)}
+	}
+
+	switch cfg.Sampler {
+	case SamplerAlwaysOn, SamplerTail:
+		root.fallback = tracesdk.AlwaysSample()
+	case SamplerAlwaysOff:
+		root.fallback = tracesdk.NeverSample()
+	case SamplerRatio, "":
+		root.fallback = tracesdk.TraceIDRatioBased(cfg.Ratio)
+	default:
+		return nil, fmt.Errorf("unknown sampler %q, expected always_on, always_off, ratio or tail", cfg.Sampler)
+	}
+
+	return tracesdk.ParentBased(root), nil
+}
+
+type routeRatio struct {
+	ratio   float64
+	sampler tracesdk.Sampler
+}
+
+// routeSampler samples new traces by the ratio of their route, if it has one, or else
+// by the fallback sampler. With tail sampling every trace is kept but those at a ratio of
+// 0, and the ratio is left as a hint to the collector.
+type routeSampler struct {
+	fallback tracesdk.Sampler
+	ratio    float64
+	routes   map[string]routeRatio
+	tail     bool
+}
+
+func (s *routeSampler) ShouldSample(p tracesdk.SamplingParameters) tracesdk.SamplingResult {
+	sampler, ratio := s.fallback, s.ratio
+	for _, attr := range p.Attributes {
+		if attr.Key == semconv.HTTPRouteKey {
+			if route, ok := s.routes[attr.Value.AsString()]; ok {
+				sampler, ratio = route.sampler, route.ratio
+			}
+			break
+		}
+	}
+
+	if !s.tail {
+		return sampler.ShouldSample(p)
+	}
+	if ratio == 0 {
+		return tracesdk.NeverSample().ShouldSample(p)
+	}
+	result := tracesdk.AlwaysSample().ShouldSample(p)
+	result.Attributes = append(result.Attributes, samplingRatioKey.Float64(ratio))
+	return result
+}
+
+func (s *routeSampler) Description() string {
+	return fmt.Sprintf("RouteSampler{%s,routes:%d,tail:%t}", s.fallback.Description(), len(s.routes), s.tail)
+}
@@ -28,6 +28,9 @@ type TracingConfig struct {
 	Environment    string
 	OTLPEndpoint   string
 	Enabled        bool
+	Sampling       SamplingConfig
+	// Insecure exports over plaintext gRPC instead of TLS, for collectors without TLS
+	Insecure bool
 }
 
 // Init initializes the OpenTelemetry tracer
@@ -45,34 +48,31 @@ func Init(ctx context.Context, cfg TracingConfig) (func(), error) {
 	}
 
 	// Create OTLP exporter
-	traceExporter, err := otlptracegrpc.New(ctx,
-		otlptracegrpc.WithEndpoint(cfg.OTLPEndpoint),
-		otlptracegrpc.WithInsecure(), // TODO - Check if is development or production and use secure connection
-	)
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.OTLPEndpoint)}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	traceExporter, err := otlptracegrpc.New(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create OTLP trace exporter: %w", err)
 	}
 
 	// Create resource with service information
-	res, err := resource.New(ctx,
-		resource.WithAttributes(
-			semconv.ServiceName(cfg.ServiceName),
-			semconv.ServiceVersion(cfg.ServiceVersion),
-			attribute.String("environment", cfg.Environment),
-		),
-		resource.WithOS(),
-		resource.WithProcessRuntimeDescription(),
-		resource.WithTelemetrySDK(),
-	)
+	res, err := newResource(ctx, cfg.ServiceName, cfg.ServiceVersion, cfg.Environment)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create resource: %w", err)
+		return nil, err
+	}
+
+	sampler, err := newSampler(cfg.Sampling)
+	if err != nil {
+		return nil, err
 	}
 
 	// Configure trace provider with appropriate sampling
 	tp := tracesdk.NewTracerProvider(
 		tracesdk.WithBatcher(traceExporter),
 		tracesdk.WithResource(res),
-		tracesdk.WithSampler(tracesdk.ParentBased(tracesdk.TraceIDRatioBased(0.5))),
+		tracesdk.WithSampler(sampler),
 	)
 
 	// Set global trace provider
@@ -92,6 +92,7 @@ func Init(ctx context.Context, cfg TracingConfig) (func(), error) {
 		Str("version", cfg.ServiceVersion).
 		Str("environment", cfg.Environment).
 		Str("otlp_endpoint", cfg.OTLPEndpoint).
+		Str("sampler", sampler.Description()).
 		Msg("Tracing initialized with OpenTelemetry")
 
 	// Return a cleanup function
@@ -104,6 +105,24 @@ func Init(ctx context.Context, cfg TracingConfig) (func(), error) {
 	}, nil
 }
 
+// newResource describes the service for exported telemetry
+func newResource(ctx context.Context, serviceName, serviceVersion, environment string) (*resource.Resource, error) {
+	res, err := resource.New(ctx,
+		resource.WithAttributes(
+			semconv.ServiceName(serviceName),
+			semconv.ServiceVersion(serviceVersion),
+			attribute.String("environment", environment),
+		),
+		resource.WithOS(),
+		resource.WithProcessRuntimeDescription(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+	return res, nil
+}
+
 // Tracer returns the global tracer
 func Tracer() trace.Tracer {
 	return tracer
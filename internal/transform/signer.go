@@ -0,0 +1,63 @@
+package transform
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrInvalidSignature is returned when a transformation URL signature does not match
+	ErrInvalidSignature = errors.New("invalid signature")
+	// ErrExpired is returned when a transformation URL is past its expiry
+	ErrExpired = errors.New("url expired")
+)
+
+// Signer creates and verifies HMAC signatures for transformation URLs
+type Signer struct {
+	key []byte
+}
+
+// NewSigner creates a new Signer using the given secret key
+func NewSigner(key string) *Signer {
+	return &Signer{key: []byte(key)}
+}
+
+// Sign returns the URL-safe signature for a template applied to an image until expires
+func (s *Signer) Sign(template string, id uuid.UUID, expires time.Time) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(payload(template, id, expires)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks that signature is valid for the template, image and expiry. The signature
+// is checked first, so a tampered URL is reported as such even past its expiry.
+func (s *Signer) Verify(signature, template string, id uuid.UUID, expires time.Time) error {
+	expected := s.Sign(template, id, expires)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+
+	if time.Now().After(expires) {
+		return ErrExpired
+	}
+
+	return nil
+}
+
+// URL returns the signed path for a template applied to an image
+func (s *Signer) URL(template string, id uuid.UUID, expires time.Time) string {
+	return fmt.Sprintf("/t/%s/%s/%s?expires=%d",
+		s.Sign(template, id, expires), template, id.String(), expires.Unix())
+}
+
+// payload builds the message that is signed for a transformation URL
+func payload(template string, id uuid.UUID, expires time.Time) string {
+	return template + "/" + id.String() + "/" + strconv.FormatInt(expires.Unix(), 10)
+}
@@ -0,0 +1,38 @@
+package transform
+
+import (
+	"strconv"
+	"strings"
+)
+
+// MaxWidth bounds the width of width templates
+const MaxWidth = 10000
+
+// WidthTemplate names the template resizing an image to width, keeping its aspect ratio,
+// in format if set. Srcset URLs are signed for width templates, which need no
+// configuration; configured templates of the same name take precedence.
+func WidthTemplate(width int, format string) string {
+	name := "w" + strconv.Itoa(width)
+	if format != "" {
+		name += "." + format
+	}
+	return name
+}
+
+// ParseWidthTemplate returns the width and format of a template named by WidthTemplate
+func ParseWidthTemplate(name string) (width int, format string, ok bool) {
+	rest, found := strings.CutPrefix(name, "w")
+	if !found {
+		return 0, "", false
+	}
+	rest, format, _ = strings.Cut(rest, ".")
+	if format != "" && format != "jpeg" && format != "png" {
+		return 0, "", false
+	}
+
+	width, err := strconv.Atoi(rest)
+	if err != nil || width < 1 || width > MaxWidth || strconv.Itoa(width) != rest {
+		return 0, "", false
+	}
+	return width, format, true
+}
@@ -0,0 +1,136 @@
+// Package usage meters the billable usage of API key owners: uploads, processed bytes and
+// transformations as they are served, and stored bytes from daily snapshots, rolled up
+// into monthly totals for invoicing.
+package usage
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/rs/zerolog"
+)
+
+// Meter records usage and keeps the monthly rollups current
+type Meter struct {
+	repo   db.Repository
+	config *config.UsageConfig
+	logger zerolog.Logger
+
+	mu sync.Mutex
+	// pending is the usage recorded since the last flush, summed per owner and day
+	pending map[usageKey]models.UsageCounts
+}
+
+// usageKey is an owner and the UTC day usage is recorded on
+type usageKey struct {
+	owner string
+	day   time.Time
+}
+
+// NewMeter creates a new Meter
+func NewMeter(repo db.Repository, cfg *config.UsageConfig) *Meter {
+	return &Meter{
+		repo:    repo,
+		config:  cfg,
+		logger:  logger.GetLogger("usage-meter"),
+		pending: make(map[usageKey]models.UsageCounts),
+	}
+}
+
+// Record adds usage to the usage of owner today. Anonymous usage is not metered. The
+// usage is written with the next flush, so requests served don't wait for the database.
+func (m *Meter) Record(ctx context.Context, owner string, usage models.UsageCounts) {
+	if !m.config.Enabled || owner == "" {
+		return
+	}
+
+	now := time.Now().UTC()
+	key := usageKey{owner: owner, day: time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)}
+	m.mu.Lock()
+	m.pending[key] = addCounts(m.pending[key], usage)
+	m.mu.Unlock()
+}
+
+// RunFlusher writes the recorded usage every FlushInterval until ctx is cancelled. The
+// usage recorded afterwards is written by Flush.
+func (m *Meter) RunFlusher(ctx context.Context) {
+	ticker := time.NewTicker(m.config.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Flush(logger.ToContext(ctx, m.logger))
+		}
+	}
+}
+
+// Flush writes the usage recorded since the last flush, one row per owner and day.
+// Usage that fails to be written is kept for the next flush.
+func (m *Meter) Flush(ctx context.Context) {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = make(map[usageKey]models.UsageCounts, len(pending))
+	m.mu.Unlock()
+
+	for key, usage := range pending {
+		if err := m.repo.AddUsage(ctx, key.owner, key.day, usage); err != nil {
+			m.logger.Warn().Err(err).Str("owner", key.owner).Msg("Failed to record usage, keeping it for the next flush")
+			m.mu.Lock()
+			m.pending[key] = addCounts(m.pending[key], usage)
+			m.mu.Unlock()
+		}
+	}
+}
+
+// addCounts returns the sum of the usage counted from requests in a and b
+func addCounts(a, b models.UsageCounts) models.UsageCounts {
+	return models.UsageCounts{
+		Uploads:         a.Uploads + b.Uploads,
+		ProcessedBytes:  a.ProcessedBytes + b.ProcessedBytes,
+		Transformations: a.Transformations + b.Transformations,
+	}
+}
+
+// Run snapshots the stored bytes and rolls up the usage at start and every
+// RollupInterval until ctx is cancelled
+func (m *Meter) Run(ctx context.Context) {
+	m.logger.Info().Dur("interval", m.config.RollupInterval).Msg("Starting usage rollups")
+
+	ticker := time.NewTicker(m.config.RollupInterval)
+	defer ticker.Stop()
+
+	m.rollup(logger.ToContext(ctx, m.logger))
+	for {
+		select {
+		case <-ctx.Done():
+			m.logger.Info().Msg("Usage rollups stopped")
+			return
+		case <-ticker.C:
+			m.rollup(logger.ToContext(ctx, m.logger))
+		}
+	}
+}
+
+// rollup snapshots today's stored bytes and recomputes the current and previous month,
+// which still gets usage recorded around the turn of the month
+func (m *Meter) rollup(ctx context.Context) {
+	now := time.Now().UTC()
+	if err := m.repo.RecordStorageUsage(ctx, now); err != nil {
+		m.logger.Error().Err(err).Msg("Failed to snapshot storage usage")
+	}
+
+	previousMonth := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+	if err := m.repo.RollupUsage(ctx, previousMonth); err != nil {
+		m.logger.Error().Err(err).Msg("Failed to roll up usage")
+		return
+	}
+	m.logger.Debug().Time("since", previousMonth).Msg("Usage rolled up")
+}
@@ -0,0 +1,51 @@
+package worker
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// logLevels are the levels accepted by LogLevelHandler
+var logLevels = map[string]bool{
+	"debug": true, "info": true, "warn": true, "error": true, "fatal": true, "panic": true,
+}
+
+// LogLevelHandler serves /admin/loglevel behind the admin token, as on the API: GET
+// returns the level in effect, PUT ?level= overrides it until the worker restarts and
+// DELETE returns to the configured level
+func LogLevelHandler(token string) http.Handler {
+	return AdminOnly(token, http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(rw, http.StatusOK, logger.Level())
+		case http.MethodPut:
+			level := r.URL.Query().Get("level")
+			if !logLevels[level] {
+				writeJSON(rw, http.StatusBadRequest, map[string]any{
+					"error": "level must be one of debug, info, warn, error, fatal or panic",
+				})
+				return
+			}
+			writeJSON(rw, http.StatusOK, logger.OverrideLevel(level))
+		case http.MethodDelete:
+			writeJSON(rw, http.StatusOK, logger.ClearLevelOverride())
+		default:
+			rw.Header().Set("Allow", "GET, PUT, DELETE")
+			writeJSON(rw, http.StatusMethodNotAllowed, map[string]any{"error": "method not allowed"})
+		}
+	}))
+}
+
+// AdminOnly serves next only to requests bearing the admin token
+func AdminOnly(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			writeJSON(rw, http.StatusUnauthorized, map[string]any{"error": "unauthorized"})
+			return
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
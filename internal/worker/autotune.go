@@ -0,0 +1,170 @@
+package worker
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// cpuRatioSmoothing is the weight of the latest sample in the smoothed CPU ratio
+	cpuRatioSmoothing = 0.3
+	// minCPURatio bounds the CPU ratio of IO-bound tasks, so their concurrency stays within
+	// a multiple of GOMAXPROCS even below MaxWorkers
+	minCPURatio = 0.05
+	// memoryLimitShare is the share of GOMEMLIMIT used as memory budget unless configured
+	memoryLimitShare = 0.9
+)
+
+// autoTuner sets the limit of the shared task limiter so tasks keep GOMAXPROCS busy: about
+// GOMAXPROCS encode-bound tasks at once, more of those waiting on IO, up to MaxWorkers.
+// While the heap exceeds the memory budget the limit backs off quickly, and it recovers
+// one task per interval once the heap is back under budget.
+type autoTuner struct {
+	sem      *limiter
+	tracker  *taskTracker
+	interval time.Duration
+	// budget is the heap size in bytes above which the limit backs off, 0 for none
+	budget uint64
+	// ceiling is MaxWorkers, replaced on configuration reloads
+	ceiling atomic.Int64
+	running atomic.Bool
+	logger  zerolog.Logger
+
+	// cpuRatio is the smoothed CPU time of tasks per second they run, from the CPU time of
+	// the process and the run time of tasks since lastCPU and lastBusy were read
+	cpuRatio float64
+	lastCPU  time.Duration
+	lastBusy int64
+}
+
+func newAutoTuner(sem *limiter, tracker *taskTracker, cfg *config.WorkerConfig, log zerolog.Logger) *autoTuner {
+	t := &autoTuner{
+		sem:      sem,
+		tracker:  tracker,
+		interval: cfg.AutoTuneInterval,
+		budget:   memoryBudget(cfg.MemoryBudgetMB),
+		logger:   log,
+		// tasks are taken as encode-bound until their CPU time is observed
+		cpuRatio: 1,
+	}
+	t.ceiling.Store(int64(cfg.MaxWorkers))
+	return t
+}
+
+// memoryBudget returns the budget of budgetMB, or else the share of GOMEMLIMIT if set
+func memoryBudget(budgetMB int) uint64 {
+	if budgetMB > 0 {
+		return uint64(budgetMB) << 20
+	}
+	if limit := debug.SetMemoryLimit(-1); limit < math.MaxInt64 {
+		return uint64(float64(limit) * memoryLimitShare)
+	}
+	return 0
+}
+
+// run tunes the limit every interval until ctx is cancelled
+func (t *autoTuner) run(ctx context.Context) {
+	t.running.Store(true)
+	defer t.running.Store(false)
+
+	t.lastCPU, _ = processCPUTime()
+	t.lastBusy = t.tracker.runTime(time.Now())
+	t.apply(t.target())
+	t.logger.Info().
+		Int("concurrency", t.sem.size()).
+		Int("gomaxprocs", runtime.GOMAXPROCS(0)).
+		Uint64("memory_budget", t.budget).
+		Dur("interval", t.interval).
+		Msg("Auto-tuning task concurrency")
+
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.tune()
+		}
+	}
+}
+
+// tune updates the CPU ratio and sets the limit from it and the heap size
+func (t *autoTuner) tune() {
+	t.observeCPU()
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	metrics.WorkerHeapBytes.Set(float64(mem.HeapAlloc))
+
+	current, target := t.sem.size(), t.target()
+	limit := target
+	switch {
+	case t.budget > 0 && mem.HeapAlloc > t.budget:
+		// the heap grows with the images in flight, so shed a quarter of them at least
+		limit = max(min(current*3/4, current-1, target), 1)
+		t.logger.Warn().
+			Uint64("heap_alloc", mem.HeapAlloc).
+			Uint64("memory_budget", t.budget).
+			Int("concurrency", limit).
+			Msg("Heap over memory budget, lowering task concurrency")
+	case current < target:
+		limit = current + 1
+	}
+	t.apply(limit)
+}
+
+// observeCPU adds the CPU ratio of the tasks that ran since the last call to the smoothed
+// ratio, counting the time tasks still in flight ran for. It is left as is while no task
+// ran, or if the CPU time of the process can't be read.
+func (t *autoTuner) observeCPU() {
+	cpu, ok := processCPUTime()
+	busy := t.tracker.runTime(time.Now())
+	cpuDelta, busyDelta := cpu-t.lastCPU, time.Duration(busy-t.lastBusy)
+	t.lastCPU, t.lastBusy = cpu, busy
+	if !ok || busyDelta <= 0 {
+		return
+	}
+
+	sample := cpuDelta.Seconds() / busyDelta.Seconds()
+	t.cpuRatio = cpuRatioSmoothing*sample + (1-cpuRatioSmoothing)*t.cpuRatio
+	metrics.WorkerTaskCPURatio.Set(t.cpuRatio)
+}
+
+// target returns the concurrency keeping GOMAXPROCS busy with tasks of the CPU ratio
+// observed, within 1 and MaxWorkers
+func (t *autoTuner) target() int {
+	target := int(math.Ceil(float64(runtime.GOMAXPROCS(0)) / max(t.cpuRatio, minCPURatio)))
+	return min(max(target, 1), int(t.ceiling.Load()))
+}
+
+// apply sets the limit, logging changes
+func (t *autoTuner) apply(limit int) {
+	metrics.WorkerConcurrency.Set(float64(limit))
+	if current := t.sem.size(); limit != current {
+		t.logger.Info().
+			Int("from", current).
+			Int("to", limit).
+			Float64("cpu_ratio", t.cpuRatio).
+			Msg("Changing task concurrency")
+		t.sem.setLimit(limit)
+	}
+}
+
+// setCeiling adopts the MaxWorkers of a reloaded configuration. A lower ceiling applies at
+// once; while the tuner doesn't run, the limit is the ceiling.
+func (t *autoTuner) setCeiling(ceiling int) {
+	t.ceiling.Store(int64(ceiling))
+	if !t.running.Load() || t.sem.size() > ceiling {
+		t.sem.setLimit(ceiling)
+	}
+}
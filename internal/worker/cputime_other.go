@@ -0,0 +1,11 @@
+//go:build !unix
+
+package worker
+
+import "time"
+
+// processCPUTime can't read the CPU time of the process on this platform, so auto-tuning
+// treats tasks as CPU-bound
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
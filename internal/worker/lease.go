@@ -0,0 +1,73 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+)
+
+// defaultWorkerID identifies a worker without WORKER_ID by its hostname, with a random
+// suffix so a restarted worker never mistakes the leases of its predecessor for its own
+func defaultWorkerID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "worker"
+	}
+	return hostname + "-" + uuid.NewString()[:8]
+}
+
+// holdLease renews the lease of the worker on image id every third of the lease duration
+// until the returned release function drops it. The returned context is cancelled when
+// another worker took the image over, so the image stops being processed twice.
+func (w *Worker) holdLease(ctx context.Context, id uuid.UUID) (context.Context, func()) {
+	taskLogger := logger.FromContext(ctx)
+	lease := w.config.Worker.LeaseDuration
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(lease / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				err := w.repo.RenewImageLease(ctx, id, w.id, lease)
+				if errors.Is(err, db.ErrLeaseLost) {
+					taskLogger.Warn().Msg("Image lease taken over by another worker, stopping processing")
+					metrics.ImageLeasesTotal.WithLabelValues("lost").Inc()
+					cancel(err)
+					return
+				}
+				if err != nil {
+					// the lease holds until it expires, so the next renewal may still succeed
+					taskLogger.Warn().Err(err).Msg("Failed to renew image lease")
+				}
+			}
+		}
+	}()
+
+	return ctx, func() {
+		close(stop)
+		<-stopped
+		cancel(nil)
+
+		if err := w.repo.ReleaseImageLease(context.WithoutCancel(ctx), id, w.id); err != nil {
+			taskLogger.Warn().Err(err).Msg("Failed to release image lease")
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"context"
+	"sync"
+)
+
+// limiter bounds the number of tasks processed at once. Unlike a buffered channel its
+// limit can change while tasks run: lowering it lets running tasks finish and holds
+// new ones until enough slots are released.
+type limiter struct {
+	mu      sync.Mutex
+	limit   int
+	active  int
+	changed chan struct{} // closed and replaced whenever a slot may have become free
+}
+
+func newLimiter(limit int) *limiter {
+	return &limiter{
+		limit:   limit,
+		changed: make(chan struct{}),
+	}
+}
+
+// acquire waits for a free slot or until ctx is done
+func (l *limiter) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.active < l.limit {
+			l.active++
+			l.mu.Unlock()
+			return nil
+		}
+		changed := l.changed
+		l.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release frees a slot taken by acquire
+func (l *limiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active--
+	l.notify()
+}
+
+// setLimit changes the number of slots
+func (l *limiter) setLimit(limit int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.limit = limit
+	l.notify()
+}
+
+// size returns the current number of slots
+func (l *limiter) size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.limit
+}
+
+// notify wakes up the waiting acquire calls; l.mu must be held
+func (l *limiter) notify() {
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
@@ -0,0 +1,39 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+
+	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
+)
+
+// decodeImagePayload decodes the payload of an image task into the payload of the current
+// version. Tasks published before payloads were versioned have the layout of version 1.
+// Tasks of a newer version than the worker knows fail, so they are retried until a worker
+// of that version takes them during a rolling deploy.
+func decodeImagePayload(task rabbitmq.Task) (rabbitmq.ImagePayload, error) {
+	var payload rabbitmq.ImagePayload
+
+	switch task.Version {
+	case 0, 1:
+		var v1 rabbitmq.ImagePayloadV1
+		if err := json.Unmarshal(task.Data, &v1); err != nil {
+			return payload, fmt.Errorf("%w: %w", errInvalidTask, err)
+		}
+		payload = v1
+	default:
+		return payload, fmt.Errorf("%w: %d, expected at most %d", rabbitmq.ErrUnsupportedVersion, task.Version, rabbitmq.CurrentVersion)
+	}
+
+	return payload, nil
+}
+
+// taskImageID returns the image ID of task, empty if it has none, such as tasks of types
+// registered by plugins with payloads of their own
+func taskImageID(task rabbitmq.Task) string {
+	payload, err := decodeImagePayload(task)
+	if err != nil {
+		return ""
+	}
+	return payload.ImageID
+}
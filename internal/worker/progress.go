@@ -0,0 +1,43 @@
+package worker
+
+import (
+	"context"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+)
+
+// progressReporter records the progress of a resize task on its image. Reports may arrive
+// concurrently and out of order; progress never goes back and unchanged reports are not
+// written.
+type progressReporter struct {
+	repo db.Repository
+	id   uuid.UUID
+
+	mu      sync.Mutex
+	percent int
+	stage   string
+}
+
+func newProgressReporter(repo db.Repository, id uuid.UUID) *progressReporter {
+	return &progressReporter{repo: repo, id: id, percent: -1}
+}
+
+// report is an imageprocessor.ProgressFunc
+func (p *progressReporter) report(ctx context.Context, percent int, stage string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if percent < p.percent || (percent == p.percent && stage == p.stage) {
+		return
+	}
+	p.percent, p.stage = percent, stage
+
+	// The write is held under the lock so that reports are written in order
+	if err := p.repo.UpdateImageProgress(ctx, p.id, percent, stage); err != nil {
+		taskLogger := logger.FromContext(ctx)
+		taskLogger.Warn().Err(err).Int("percent", percent).Str("stage", stage).Msg("Failed to record processing progress")
+	}
+}
@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/minio"
+	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
+)
+
+// HandlerFunc processes the tasks of one type. It gets the worker running the task for its
+// dependencies; returning an error fails the task, which is then retried.
+type HandlerFunc func(ctx context.Context, w *Worker, task rabbitmq.Task) error
+
+var (
+	handlersMu sync.RWMutex
+	handlers   = make(map[rabbitmq.TaskType]HandlerFunc)
+)
+
+// RegisterHandler makes the worker process tasks of taskType with fn. Packages adding task
+// types call it from an init function and are imported by the worker command. It panics if
+// taskType already has a handler.
+func RegisterHandler(taskType rabbitmq.TaskType, fn HandlerFunc) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+
+	if _, ok := handlers[taskType]; ok {
+		panic(fmt.Sprintf("worker: handler already registered for task type %s", taskType))
+	}
+	handlers[taskType] = fn
+}
+
+// handlerFor returns the handler registered for taskType
+func handlerFor(taskType rabbitmq.TaskType) (HandlerFunc, bool) {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+
+	fn, ok := handlers[taskType]
+	return fn, ok
+}
+
+func init() {
+	RegisterHandler(rabbitmq.TaskTypeResizeImage, method((*Worker).processImageResize))
+	RegisterHandler(rabbitmq.TaskTypeExtractText, method((*Worker).processTextExtraction))
+	RegisterHandler(rabbitmq.TaskTypeRemoveBackground, method((*Worker).processBackgroundRemoval))
+}
+
+// method adapts a task processing method of Worker to a HandlerFunc
+func method(fn func(*Worker, context.Context, rabbitmq.Task) error) HandlerFunc {
+	return func(ctx context.Context, w *Worker, task rabbitmq.Task) error {
+		return fn(w, ctx, task)
+	}
+}
+
+// Repository returns the image repository, for registered handlers
+func (w *Worker) Repository() db.Repository {
+	return w.repo
+}
+
+// Storage returns the storage client, for registered handlers
+func (w *Worker) Storage() minio.Client {
+	return w.minioClient
+}
+
+// Config returns the worker configuration, for registered handlers
+func (w *Worker) Config() *config.Config {
+	return w.config
+}
@@ -0,0 +1,89 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/not-nullexception/image-optimizer/internal/db"
+	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/logger"
+	"github.com/not-nullexception/image-optimizer/internal/metrics"
+	imageprocessor "github.com/not-nullexception/image-optimizer/internal/processor/image"
+	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
+)
+
+// errInvalidTask is returned for tasks whose data is malformed or incomplete
+var errInvalidTask = errors.New("invalid task data")
+
+// permanent reports whether err fails a task the same way on every attempt, or comes from
+// losing its image to another worker that finishes the task, so the task is not retried
+func permanent(err error) bool {
+	return errors.Is(err, errInvalidTask) ||
+		errors.Is(err, db.ErrLeaseLost) ||
+		errors.Is(err, imageprocessor.ErrInvalidImage) ||
+		errors.Is(err, imageprocessor.ErrImageTooLarge) ||
+		errors.Is(err, imageprocessor.ErrUnsupportedFormat)
+}
+
+// retryLater stores a failed task in the outbox to be published again once its backoff
+// elapsed, so its delivery is acknowledged instead of requeued at once. Tasks failing
+// permanently are dropped. It returns the error to requeue the delivery with, or nil to
+// acknowledge it.
+func (w *Worker) retryLater(ctx context.Context, task rabbitmq.Task, record *models.TaskRecord, taskErr error) error {
+	taskLogger := logger.FromContext(ctx)
+
+	// tasks cancelled by the shutdown are requeued for another worker right away
+	if ctx.Err() != nil {
+		return taskErr
+	}
+
+	if permanent(taskErr) {
+		taskLogger.Error().Err(taskErr).Int("attempt", record.Attempt).Msg("Task failed permanently, giving up")
+		metrics.TaskRetriesTotal.WithLabelValues("permanent").Inc()
+		return nil
+	}
+
+	if record.Attempt >= w.config.Worker.MaxAttempts {
+		taskLogger.Error().Err(taskErr).Int("attempt", record.Attempt).Msg("Task failed too many times, giving up")
+		metrics.TaskRetriesTotal.WithLabelValues("exhausted").Inc()
+		return nil
+	}
+
+	payload, err := json.Marshal(task)
+	if err != nil {
+		taskLogger.Error().Err(err).Msg("Failed to encode task for retry, requeuing it")
+		return taskErr
+	}
+
+	now := time.Now()
+	next := now.Add(w.retryBackoff(record.Attempt))
+	err = w.repo.SaveOutboxTask(ctx, &models.OutboxTask{
+		ImageID:       record.ImageID,
+		Payload:       payload,
+		LastError:     taskErr.Error(),
+		NextAttemptAt: next,
+		CreatedAt:     now,
+	})
+	if err != nil {
+		taskLogger.Error().Err(err).Msg("Failed to schedule task retry, requeuing it")
+		metrics.TaskRetriesTotal.WithLabelValues("requeued").Inc()
+		return taskErr
+	}
+
+	taskLogger.Warn().Int("attempt", record.Attempt).Time("next_attempt_at", next).Msg("Task retry scheduled")
+	metrics.TaskRetriesTotal.WithLabelValues("scheduled").Inc()
+	return nil
+}
+
+// retryBackoff returns the delay after the given failed attempt, doubling from
+// RetryBaseDelay up to RetryMaxDelay
+func (w *Worker) retryBackoff(attempt int) time.Duration {
+	cfg := w.config.Worker
+	delay := cfg.RetryBaseDelay
+	for i := 1; i < attempt && delay < cfg.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, cfg.RetryMaxDelay)
+}
@@ -0,0 +1,195 @@
+package worker
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Status is a snapshot of the worker state served on /status
+type Status struct {
+	ID                 string       `json:"id"`
+	Healthy            bool         `json:"healthy"`
+	Reason             string       `json:"reason,omitempty"`
+	ConsumerConnected  bool         `json:"consumer_connected"`
+	ConsumerError      string       `json:"consumer_error,omitempty"`
+	InFlight           int64        `json:"in_flight"`
+	Processed          uint64       `json:"processed"`
+	Failed             uint64       `json:"failed"`
+	StartedAt          time.Time    `json:"started_at"`
+	LastTaskStartedAt  *time.Time   `json:"last_task_started_at,omitempty"`
+	LastTaskFinishedAt *time.Time   `json:"last_task_finished_at,omitempty"`
+	LastSuccessAt      *time.Time   `json:"last_success_at,omitempty"`
+	LastFailureAt      *time.Time   `json:"last_failure_at,omitempty"`
+	Config             StatusConfig `json:"config"`
+}
+
+// StatusConfig summarises the worker configuration
+type StatusConfig struct {
+	Queue                string        `json:"queue"`
+	MaxWorkers           int           `json:"max_workers"`
+	RenditionConcurrency int           `json:"rendition_concurrency"`
+	StallTimeout         time.Duration `json:"stall_timeout_ns"`
+	Moderation           bool          `json:"moderation"`
+	OCR                  bool          `json:"ocr"`
+	FaceDetection        bool          `json:"face_detection"`
+	BackgroundRemoval    bool          `json:"background_removal"`
+	// TaskQueues maps the task types consumed from queues of their own to their queue
+	TaskQueues map[string]StatusTaskQueue `json:"task_queues,omitempty"`
+}
+
+// StatusTaskQueue is the queue of its own of a task type and its concurrency
+type StatusTaskQueue struct {
+	Queue    string `json:"queue"`
+	Prefetch int    `json:"prefetch"`
+}
+
+// taskTracker records task activity for health and status reporting
+type taskTracker struct {
+	inFlight  atomic.Int64
+	processed atomic.Uint64
+	failed    atomic.Uint64
+	startedAt time.Time
+
+	mu sync.Mutex
+	// busy sums the run time of finished tasks and running sums the start times of the
+	// tasks in flight, both in nanoseconds
+	busy         int64
+	running      int64
+	lastStarted  time.Time
+	lastFinished time.Time
+	lastSuccess  time.Time
+	lastFailure  time.Time
+}
+
+// taskStarted records the start of a task and returns it
+func (t *taskTracker) taskStarted() time.Time {
+	now := time.Now()
+	t.mu.Lock()
+	t.inFlight.Add(1)
+	t.lastStarted = now
+	t.running += now.UnixNano()
+	t.mu.Unlock()
+	return now
+}
+
+// taskFinished records the end of a task started at started and its outcome
+func (t *taskTracker) taskFinished(started time.Time, err error) {
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight.Add(-1)
+	t.busy += int64(now.Sub(started))
+	t.running -= started.UnixNano()
+	t.lastFinished = now
+	if err != nil {
+		t.failed.Add(1)
+		t.lastFailure = now
+	} else {
+		t.processed.Add(1)
+		t.lastSuccess = now
+	}
+}
+
+// runTime returns the run time of all tasks up to now, counting the tasks in flight for
+// as long as they have been running
+func (t *taskTracker) runTime(now time.Time) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.busy + t.inFlight.Load()*now.UnixNano() - t.running
+}
+
+// Status returns a snapshot of the worker state. The worker is unhealthy if the consumer is
+// disconnected, or if tasks are in flight but none has started or finished within the
+// stall timeout.
+func (w *Worker) Status() Status {
+	t := &w.tracker
+
+	status := Status{
+		ID:                w.id,
+		Healthy:           true,
+		ConsumerConnected: true,
+		InFlight:          t.inFlight.Load(),
+		Processed:         t.processed.Load(),
+		Failed:            t.failed.Load(),
+		StartedAt:         t.startedAt,
+		Config: StatusConfig{
+			Queue:                w.config.RabbitMQ.Queue,
+			MaxWorkers:           w.sem.size(),
+			RenditionConcurrency: w.config.Worker.RenditionConcurrency,
+			StallTimeout:         w.config.Worker.StallTimeout,
+			Moderation:           w.config.Moderation.Enabled,
+			OCR:                  w.config.OCR.Enabled,
+			FaceDetection:        w.config.FaceDetection.Enabled,
+			BackgroundRemoval:    w.config.Background.Enabled,
+		},
+	}
+	for taskType, queue := range w.config.RabbitMQ.TaskQueues {
+		if status.Config.TaskQueues == nil {
+			status.Config.TaskQueues = make(map[string]StatusTaskQueue, len(w.config.RabbitMQ.TaskQueues))
+		}
+		status.Config.TaskQueues[taskType] = StatusTaskQueue{Queue: queue.Queue, Prefetch: queue.Prefetch}
+	}
+
+	t.mu.Lock()
+	status.LastTaskStartedAt = timePtr(t.lastStarted)
+	status.LastTaskFinishedAt = timePtr(t.lastFinished)
+	status.LastSuccessAt = timePtr(t.lastSuccess)
+	status.LastFailureAt = timePtr(t.lastFailure)
+	lastProgress := t.lastStarted
+	if t.lastFinished.After(lastProgress) {
+		lastProgress = t.lastFinished
+	}
+	t.mu.Unlock()
+
+	if err := w.queueClient.Ping(); err != nil {
+		status.Healthy = false
+		status.ConsumerConnected = false
+		status.ConsumerError = err.Error()
+		status.Reason = "queue consumer disconnected"
+	} else if status.InFlight > 0 && w.config.Worker.StallTimeout > 0 && time.Since(lastProgress) > w.config.Worker.StallTimeout {
+		status.Healthy = false
+		status.Reason = "no task progress within stall timeout"
+	}
+
+	return status
+}
+
+// HealthHandler serves /healthz: 200 when healthy, 503 otherwise
+func (w *Worker) HealthHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		status := w.Status()
+		code := http.StatusOK
+		body := map[string]any{"status": "UP"}
+		if !status.Healthy {
+			code = http.StatusServiceUnavailable
+			body = map[string]any{"status": "DOWN", "reason": status.Reason}
+		}
+		writeJSON(rw, code, body)
+	})
+}
+
+// StatusHandler serves /status with the full worker status
+func (w *Worker) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, http.StatusOK, w.Status())
+	})
+}
+
+// writeJSON writes body as a JSON response
+func writeJSON(rw http.ResponseWriter, code int, body any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(code)
+	json.NewEncoder(rw).Encode(body)
+}
+
+// timePtr returns nil for the zero time so unset timestamps are omitted
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
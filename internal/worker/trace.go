@@ -0,0 +1,38 @@
+package worker
+
+import (
+	"context"
+
+	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the task spans among those of the service
+const tracerName = "github.com/not-nullexception/image-optimizer/internal/worker"
+
+// startTaskSpan starts the span of task, with its ID, type and image as attributes. Tasks
+// processed by the API are children of the request span; queued tasks start a trace.
+func startTaskSpan(ctx context.Context, task rabbitmq.Task) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("task_id", task.ID),
+		attribute.String("task_type", string(task.Type)),
+	}
+	if imageID := taskImageID(task); imageID != "" {
+		attrs = append(attrs, attribute.String("image_id", imageID))
+	}
+
+	return otel.Tracer(tracerName).Start(ctx, "task "+string(task.Type),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+// failSpan marks the span of a task as failed with err, so traces of failed tasks can be
+// filtered in the tracing backend
+func failSpan(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
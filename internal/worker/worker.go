@@ -1,21 +1,39 @@
 package worker
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"sync"
+	"image"
+	"io"
+	"net/http"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/not-nullexception/image-optimizer/config"
+	"github.com/not-nullexception/image-optimizer/internal/background"
+	"github.com/not-nullexception/image-optimizer/internal/cdn"
 	"github.com/not-nullexception/image-optimizer/internal/db"
 	"github.com/not-nullexception/image-optimizer/internal/db/models"
+	"github.com/not-nullexception/image-optimizer/internal/errreport"
+	"github.com/not-nullexception/image-optimizer/internal/faces"
 	"github.com/not-nullexception/image-optimizer/internal/logger"
 	"github.com/not-nullexception/image-optimizer/internal/metrics"
 	"github.com/not-nullexception/image-optimizer/internal/minio"
+	"github.com/not-nullexception/image-optimizer/internal/moderation"
+	"github.com/not-nullexception/image-optimizer/internal/ocr"
 	imageprocessor "github.com/not-nullexception/image-optimizer/internal/processor/image"
 	rabbitmq "github.com/not-nullexception/image-optimizer/internal/queue"
+	"github.com/not-nullexception/image-optimizer/internal/usage"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Worker struct {
@@ -23,10 +41,25 @@ type Worker struct {
 	minioClient minio.Client
 	queueClient rabbitmq.Client
 	processor   *imageprocessor.Processor
+	invalidator cdn.Invalidator
+	extractor   ocr.Extractor
+	remover     background.Remover
+	reporter    errreport.Reporter
+	meter       *usage.Meter
 	baseLogger  zerolog.Logger
 	config      *config.Config
-	sem         chan struct{} // Semafor to limit concurrent tasks
-	wg          sync.WaitGroup
+	sem         *limiter // Semafor to limit concurrent tasks, resized on configuration reloads
+	tracker     taskTracker
+	// queueLimits bound the tasks of the task types consumed from queues of their own,
+	// independently of sem
+	queueLimits map[rabbitmq.TaskType]*limiter
+	// tuner sets the limit of sem when auto-tuning is enabled, nil otherwise
+	tuner *autoTuner
+	// processing holds the processing defaults, replaced on configuration reloads
+	processing atomic.Pointer[config.ProcessingConfig]
+	// id identifies the worker in image leases, the task ledger and the processing history
+	// of images
+	id string
 }
 
 // New create a new worker instance.
@@ -34,119 +67,326 @@ func New(
 	repo db.Repository,
 	minioClient minio.Client,
 	queueClient rabbitmq.Client,
+	meter *usage.Meter,
 	config *config.Config,
 ) *Worker {
-	return &Worker{
+	w := &Worker{
 		repo:        repo,
 		minioClient: minioClient,
 		queueClient: queueClient,
-		processor:   imageprocessor.New(minioClient),
+		processor: imageprocessor.New(minioClient,
+			imageprocessor.WithClassifier(moderation.NewClassifier(&config.Moderation)),
+			imageprocessor.WithFaceDetector(faces.NewDetector(&config.FaceDetection)),
+			imageprocessor.WithConcurrency(config.Worker.RenditionConcurrency),
+			imageprocessor.WithMemoryLimitShare(concurrentTasks(config)),
+			imageprocessor.WithQualityThreshold(config.Quality.MinScore, config.Quality.FallbackStep),
+		),
+		invalidator: cdn.NewInvalidator(&config.CDN),
+		extractor:   ocr.NewExtractor(&config.OCR),
+		remover:     background.NewRemover(&config.Background),
+		reporter:    errreport.NewReporter(&config.ErrorReport),
+		meter:       meter,
 		baseLogger:  logger.GetLogger("worker"), // Base logger for the worker
 		config:      config,
-		sem:         make(chan struct{}, config.Worker.MaxWorkers),
+		sem:         newLimiter(config.Worker.MaxWorkers),
 	}
+	w.queueLimits = make(map[rabbitmq.TaskType]*limiter, len(config.RabbitMQ.TaskQueues))
+	for taskType, queue := range config.RabbitMQ.TaskQueues {
+		w.queueLimits[rabbitmq.TaskType(taskType)] = newLimiter(queue.Prefetch)
+	}
+	if config.Worker.AutoTune {
+		w.tuner = newAutoTuner(w.sem, &w.tracker, &config.Worker, w.baseLogger)
+	}
+	w.processing.Store(&config.Processing)
+	w.tracker.startedAt = time.Now()
+	w.id = config.Worker.ID
+	if w.id == "" {
+		w.id = defaultWorkerID()
+	}
+	return w
+}
+
+// concurrentTasks is the most tasks the worker processes at once: the deliveries held from
+// the default queue, bounded by MaxWorkers, and the prefetch of every queue of its own
+func concurrentTasks(cfg *config.Config) int {
+	n := min(cfg.RabbitMQ.Prefetch, cfg.Worker.MaxWorkers)
+	for _, queue := range cfg.RabbitMQ.TaskQueues {
+		n += queue.Prefetch
+	}
+	return n
+}
+
+// limiterFor returns the limiter bounding the tasks of taskType: its queue's own for task
+// types consumed from queues of their own, the shared one otherwise
+func (w *Worker) limiterFor(taskType rabbitmq.TaskType) *limiter {
+	if l, ok := w.queueLimits[taskType]; ok {
+		return l
+	}
+	return w.sem
+}
+
+// Reconfigure adopts the task concurrency and processing defaults of a reloaded
+// configuration
+func (w *Worker) Reconfigure(cfg *config.Config) {
+	if w.tuner != nil && cfg.Worker.MaxWorkers > 0 {
+		// auto-tuning keeps choosing the concurrency, up to the new maximum
+		w.tuner.setCeiling(cfg.Worker.MaxWorkers)
+	} else if cfg.Worker.MaxWorkers > 0 && cfg.Worker.MaxWorkers != w.sem.size() {
+		w.baseLogger.Info().
+			Int("from", w.sem.size()).
+			Int("to", cfg.Worker.MaxWorkers).
+			Msg("Changing max concurrent tasks")
+		w.sem.setLimit(cfg.Worker.MaxWorkers)
+	}
+	w.processing.Store(&cfg.Processing)
 }
 
 // Start starts the worker process.
 func (w *Worker) Start(ctx context.Context) error {
-	w.baseLogger.Info().Int("max_concurrent_tasks", w.config.Worker.MaxWorkers).Msg("Starting worker process")
+	w.baseLogger.Info().Int("max_concurrent_tasks", w.sem.size()).Msg("Starting worker process")
 
 	err := w.queueClient.Consume(ctx, w.processTask)
 	if err != nil {
 		w.baseLogger.Error().Err(err).Msg("Worker failed to start consuming messages")
 		return fmt.Errorf("error consuming messages: %w", err)
 	}
+	if w.tuner != nil {
+		go w.tuner.run(ctx)
+	}
 	w.baseLogger.Info().Msg("Worker started and consuming tasks")
 	return nil
 }
 
-// Stop wait for all tasks to complete and then stops the worker.
-func (w *Worker) Stop() {
+// Stop waits for the tasks in flight once consumption was stopped by cancelling the context
+// of Start. Tasks still running when ctx is done are cancelled and requeued.
+func (w *Worker) Stop(ctx context.Context) {
 	w.baseLogger.Info().Msg("Waiting for active worker tasks to complete...")
-	close(w.sem) // close the semaphore channel to signal shutdown
-	w.wg.Wait()  // wait for all tasks to finish
+	if err := w.queueClient.Drain(ctx); err != nil {
+		w.baseLogger.Warn().Err(err).Msg("Worker tasks did not complete before the shutdown deadline")
+	}
+
+	// deliver the failures reported by the last tasks
+	if w.reporter != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), w.config.ErrorReport.Sentry.Timeout)
+		w.reporter.Flush(ctx)
+		cancel()
+	}
 	w.baseLogger.Info().Msg("All active tasks completed. Worker stopped.")
 }
 
+// Process runs task within the caller instead of the queue, as for uploads processed
+// synchronously by the API. Failures are retried like those of queued tasks; an error is
+// returned when the task must be queued again.
+func (w *Worker) Process(ctx context.Context, task rabbitmq.Task) error {
+	return w.processTask(ctx, task)
+}
+
 // processTask called by the queue client for each task.
-func (w *Worker) processTask(ctx context.Context, task rabbitmq.Task) error {
-	w.wg.Add(1)
-	defer w.wg.Done()
+func (w *Worker) processTask(ctx context.Context, task rabbitmq.Task) (err error) {
+	ctx, span := startTaskSpan(ctx, task)
+	defer span.End()
 
-	taskLogger := logger.FromContext(ctx).With().
+	taskLoggerCtx := logger.FromContext(ctx).With().
 		Str("task_id", task.ID).
-		Str("task_type", string(task.Type)).
-		Logger()
+		Str("task_type", string(task.Type))
+	if task.RequestID != "" {
+		// continue the request's log correlation from the API
+		ctx = logger.WithRequestID(ctx, task.RequestID)
+		taskLoggerCtx = taskLoggerCtx.Str("request_id", task.RequestID)
+	}
+	taskLogger := taskLoggerCtx.Logger()
 	ctx = logger.ToContext(ctx, taskLogger) // update context with task logger
 
 	taskLogger.Debug().Msg("Acquiring semaphore slot...")
 	// check if we can acquire a semaphore slot
-	select {
-	case w.sem <- struct{}{}:
-		// Acquired a slot
-		taskLogger.Debug().Msg("Semaphore slot acquired.")
-		defer func() {
-			<-w.sem // release the slot
-			taskLogger.Debug().Msg("Semaphore slot released.")
-		}()
-	case <-ctx.Done():
+	sem := w.limiterFor(task.Type)
+	if err := sem.acquire(ctx); err != nil {
 		taskLogger.Warn().Msg("Context cancelled while waiting for semaphore slot; task not processed.")
-		return ctx.Err()
+		return err
 	}
+	taskLogger.Debug().Msg("Semaphore slot acquired.")
+	defer func() {
+		sem.release() // release the slot
+		taskLogger.Debug().Msg("Semaphore slot released.")
+	}()
 
 	// if we reach here, we have acquired a semaphore slot
 	taskLogger.Info().Msg("Starting task processing")
 
-	var err error
-	switch task.Type {
-	case rabbitmq.TaskTypeResizeImage:
-		err = w.processImageResize(ctx, task) // pass the context
-	default:
+	// a redelivered task that already completed is acknowledged without running again
+	record, skip, err := w.beginTask(ctx, task)
+	if err != nil || skip {
+		return err
+	}
+	if record != nil {
+		defer func() { err = w.finishTask(ctx, task, record, err) }()
+	}
+
+	started := w.tracker.taskStarted()
+	defer func() { w.tracker.taskFinished(started, err) }()
+
+	// a panicking task fails like any other instead of taking the worker down
+	defer func() {
+		recovered := recover()
+		if recovered == nil {
+			return
+		}
+
+		taskLogger.Error().
+			Str("panic", fmt.Sprint(recovered)).
+			Str("stack", string(debug.Stack())).
+			Msg("Recovered from panic in task")
+		metrics.PanicsTotal.WithLabelValues("worker").Inc()
+		if w.reporter != nil {
+			w.reporter.CapturePanic(ctx, recovered, taskTags(task))
+		}
+
+		err = fmt.Errorf("panic processing task: %v", recovered)
+		failSpan(span, err)
+	}()
+
+	if handler, ok := handlerFor(task.Type); ok {
+		err = handler(ctx, w, task)
+	} else {
 		err = fmt.Errorf("unknown task type: %s", string(task.Type))
 		taskLogger.Error().Err(err).Msg("Cannot process unknown task type")
 	}
 
 	if err != nil {
 		taskLogger.Error().Err(err).Msg("Task processing failed")
-		return err // return the error to Nack in RabbitMQ
+		if errors.Is(err, minio.ErrChecksumMismatch) {
+			w.flagCorrupted(ctx, task)
+		}
+		// a lease conflict is expected after a redelivery and not worth reporting
+		if !errors.Is(err, db.ErrLeaseHeld) {
+			failSpan(span, err)
+			if w.reporter != nil {
+				w.reporter.CaptureError(ctx, err, taskTags(task))
+			}
+		}
+		return err // return the error to retry the task
 	}
 
 	taskLogger.Info().Msg("Task processing completed successfully")
 	return nil // return nil to Ack in RabbitMQ
 }
 
+// beginTask records the start of task in the processing ledger. It returns skip for tasks
+// that must not run again, and no record for tasks without a valid image, which fail later.
+func (w *Worker) beginTask(ctx context.Context, task rabbitmq.Task) (record *models.TaskRecord, skip bool, err error) {
+	taskLogger := logger.FromContext(ctx)
+
+	imageID := taskImageID(task)
+	id, parseErr := uuid.Parse(imageID)
+	if task.ID == "" || parseErr != nil {
+		return nil, false, nil
+	}
+
+	record = &models.TaskRecord{TaskID: task.ID, ImageID: id, TaskType: string(task.Type), WorkerID: w.id}
+	// the task is kept with its record so a failed image can be retried with it
+	if payload, marshalErr := json.Marshal(task); marshalErr == nil {
+		record.Payload = payload
+	}
+	err = w.repo.BeginTask(ctx, record)
+	switch {
+	case errors.Is(err, db.ErrTaskCompleted):
+		taskLogger.Info().Msg("Task already completed, acknowledging redelivery")
+		metrics.SkippedTasksTotal.WithLabelValues("completed").Inc()
+		return nil, true, nil
+	case errors.Is(err, db.ErrNotFound):
+		taskLogger.Warn().Str("image_id", imageID).Msg("Image of task no longer exists, acknowledging task")
+		metrics.SkippedTasksTotal.WithLabelValues("image_deleted").Inc()
+		return nil, true, nil
+	case err != nil:
+		taskLogger.Error().Err(err).Msg("Failed to record task start")
+		return nil, false, fmt.Errorf("error recording task start: %w", err)
+	}
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Int("attempt", record.Attempt))
+	taskLogger.Debug().Int("attempt", record.Attempt).Msg("Task recorded in processing ledger")
+	return record, false, nil
+}
+
+// finishTask records the outcome of a task in the processing ledger, even when the task
+// was cancelled by the shutdown, and schedules the retry of a failed task. It returns the
+// error to Nack the delivery with, nil to Ack it.
+func (w *Worker) finishTask(ctx context.Context, task rabbitmq.Task, record *models.TaskRecord, taskErr error) error {
+	// the attempt did not run while another worker processes the image, whose entry it is
+	if errors.Is(taskErr, db.ErrLeaseHeld) {
+		if err := w.repo.AbandonTask(context.WithoutCancel(ctx), record); err != nil {
+			taskLogger := logger.FromContext(ctx)
+			taskLogger.Warn().Err(err).Msg("Failed to abandon task attempt")
+		}
+		return w.retryLater(ctx, task, record, taskErr)
+	}
+
+	status := models.TaskCompleted
+	if taskErr != nil {
+		status = models.TaskFailed
+		record.LastError = taskErr.Error()
+	}
+	if err := w.repo.FinishTask(context.WithoutCancel(ctx), record, status); err != nil {
+		taskLogger := logger.FromContext(ctx)
+		taskLogger.Warn().Err(err).Str("status", string(status)).Msg("Failed to record task outcome")
+	}
+
+	if taskErr == nil {
+		return nil
+	}
+	return w.retryLater(ctx, task, record, taskErr)
+}
+
+// flagCorrupted flags the image of task as corrupted after one of its objects failed
+// checksum verification
+func (w *Worker) flagCorrupted(ctx context.Context, task rabbitmq.Task) {
+	taskLogger := logger.FromContext(ctx)
+
+	imageID := taskImageID(task)
+	id, err := uuid.Parse(imageID)
+	if err != nil {
+		return
+	}
+
+	metrics.IntegrityChecksTotal.WithLabelValues(string(models.IntegrityCorrupted)).Inc()
+	if err := w.repo.UpdateImageIntegrity(ctx, id, models.IntegrityCorrupted, time.Now()); err != nil {
+		taskLogger.Error().Err(err).Str("image_id", imageID).Msg("Failed to flag corrupted image")
+	}
+}
+
 // processImageResize processes the image resize task.
 func (w *Worker) processImageResize(ctx context.Context, task rabbitmq.Task) error {
 	startTime := time.Now()
 
 	taskLogger := logger.FromContext(ctx).With().Str("component", "worker-image-processor").Logger()
 
-	var imageID string
-	var originalPath, filename string
-	var configData map[string]interface{}
-	var ok bool
+	payload, err := decodeImagePayload(task)
+	if err != nil {
+		taskLogger.Error().Err(err).Int("version", task.Version).Msg("Failed to decode task data")
+		return err
+	}
+	imageID, originalPath, filename, configData := payload.ImageID, payload.OriginalPath, payload.Filename, payload.Config
 
-	if imageID, ok = task.Data["image_id"].(string); !ok {
+	if imageID == "" {
 		taskLogger.Error().Msg("Missing or invalid image_id in task data")
-		return fmt.Errorf("missing or invalid image_id in task data")
+		return fmt.Errorf("%w: missing or invalid image_id", errInvalidTask)
 	}
-	if originalPath, ok = task.Data["original_path"].(string); !ok {
+	if originalPath == "" {
 		taskLogger.Error().Str("image_id", imageID).Msg("Missing or invalid original_path in task data")
-		return fmt.Errorf("missing or invalid original_path in task data")
+		return fmt.Errorf("%w: missing or invalid original_path", errInvalidTask)
 	}
-	if filename, ok = task.Data["filename"].(string); !ok {
+	if filename == "" {
 		taskLogger.Error().Str("image_id", imageID).Msg("Missing or invalid filename in task data")
-		return fmt.Errorf("missing or invalid filename in task data")
+		return fmt.Errorf("%w: missing or invalid filename", errInvalidTask)
 	}
-	if configData, ok = task.Data["config"].(map[string]interface{}); !ok {
+	if configData == nil {
 		taskLogger.Error().Str("image_id", imageID).Msg("Missing or invalid config in task data")
-		return fmt.Errorf("missing or invalid config in task data")
+		return fmt.Errorf("%w: missing or invalid config", errInvalidTask)
 	}
 
 	id, err := uuid.Parse(imageID)
 	if err != nil {
 		taskLogger.Error().Err(err).Str("provided_id", imageID).Msg("Invalid image ID format")
-		return fmt.Errorf("invalid image ID format '%s': %w", imageID, err)
+		return fmt.Errorf("%w: invalid image ID format '%s': %w", errInvalidTask, imageID, err)
 	}
 	// Add image_id to the logger context
 	taskLogger = taskLogger.With().Str("image_id", imageID).Logger()
@@ -154,47 +394,82 @@ func (w *Worker) processImageResize(ctx context.Context, task rabbitmq.Task) err
 
 	taskLogger.Info().Msg("Processing image resize task")
 
-	// update image status to processing in DB
+	// update image status to processing in DB and lease the image, unless another run took
+	// the image over
 	taskLogger.Debug().Msg("Updating image status to processing in DB")
-	err = w.repo.UpdateImageStatus(ctx, id, models.StatusProcessing, "") // Passa o ctx
+	previousOwner, err := w.repo.StartImageProcessing(ctx, id, task.ID, w.id, w.config.Worker.LeaseDuration)
+	if errors.Is(err, db.ErrStatusConflict) {
+		taskLogger.Info().Msg("Image is no longer pending for this task, acknowledging it")
+		metrics.SkippedTasksTotal.WithLabelValues("status_conflict").Inc()
+		return nil
+	}
+	if errors.Is(err, db.ErrLeaseHeld) {
+		// the task is retried later, and takes the image over if its worker died meanwhile
+		taskLogger.Info().Err(err).Msg("Image is being processed by another worker")
+		metrics.ImageLeasesTotal.WithLabelValues("held").Inc()
+		return err
+	}
 	if err != nil {
 		taskLogger.Error().Err(err).Msg("Failed to update image status to processing")
 		metrics.RecordProcessingTime(ctx, "db_status_update_error", startTime) // Registra métrica de falha
 		return fmt.Errorf("error updating image status before processing: %w", err)
 	}
+	if previousOwner != "" {
+		taskLogger.Warn().Str("previous_worker", previousOwner).Msg("Took over image whose worker lease expired")
+		metrics.ImageLeasesTotal.WithLabelValues("takeover").Inc()
+	}
+	ctx, releaseLease := w.holdLease(ctx, id)
+	defer releaseLease()
 
 	// parse configs and set defaults
-	// TODO: Move default values to config file
-	const defaultMaxWidth = 1200
-	const defaultMaxHeight = 1200
-	const defaultQuality = 85
-	const defaultOptimizeStorage = true
-
-	var processorConfig imageprocessor.Config
-
-	// Parse config data from task
-	if mwF, ok := configData["max_width"].(float64); ok { // JSON unmarshal can return float64
-		processorConfig.MaxWidth = int(mwF)
-	} else {
-		processorConfig.MaxWidth = defaultMaxWidth
+	defaults := w.processing.Load()
+	defaultMaxWidth := defaults.DefaultMaxWidth
+	defaultMaxHeight := defaults.DefaultMaxHeight
+	defaultQuality := defaults.QualityFor(strings.TrimPrefix(strings.ToLower(filepath.Ext(filename)), "."))
+
+	// Zero values are unset and fall back to the defaults below
+	processorConfig := imageprocessor.Config{
+		MaxWidth:        configData.MaxWidth,
+		MaxHeight:       configData.MaxHeight,
+		Quality:         configData.Quality,
+		OptimizeStorage: configData.OptimizeStorage,
+		Gravity:         configData.Gravity,
+		BlurFaces:       configData.BlurFaces,
+		Filter:          configData.Filter,
+		Sharpen:         max(configData.Sharpen, 0),
+		TargetSizeKB:    max(configData.TargetSizeKB, 0),
 	}
 
-	if mhF, ok := configData["max_height"].(float64); ok {
-		processorConfig.MaxHeight = int(mhF)
-	} else {
-		processorConfig.MaxHeight = defaultMaxHeight
+	if configData.MinQuality > 0 && configData.MinQuality <= 100 {
+		processorConfig.MinQuality = configData.MinQuality
 	}
 
-	if qF, ok := configData["quality"].(float64); ok {
-		processorConfig.Quality = int(qF)
-	} else {
-		processorConfig.Quality = defaultQuality
+	// Renditions are requested by transformation template name
+	for _, name := range configData.Renditions {
+		tmpl, ok := w.config.Transform.Templates[name]
+		if !ok {
+			taskLogger.Warn().Str("rendition", name).Msg("Unknown rendition template, skipping")
+			continue
+		}
+		processorConfig.Renditions = append(processorConfig.Renditions, imageprocessor.Rendition{
+			Name:      name,
+			MaxWidth:  tmpl.MaxWidth,
+			MaxHeight: tmpl.MaxHeight,
+			Quality:   tmpl.Quality,
+			Filter:    tmpl.Filter,
+			Sharpen:   tmpl.Sharpen,
+		})
 	}
 
-	if opt, ok := configData["optimize_storage"].(bool); ok {
-		processorConfig.OptimizeStorage = opt
-	} else {
-		processorConfig.OptimizeStorage = defaultOptimizeStorage
+	// Targets requested with the upload are encoded as renditions in their own format
+	for i, target := range configData.Targets {
+		processorConfig.Renditions = append(processorConfig.Renditions, imageprocessor.Rendition{
+			Name:      fmt.Sprintf("target%d", i+1),
+			MaxWidth:  target.Width,
+			MaxHeight: target.Height,
+			Quality:   target.Quality,
+			Format:    target.Format,
+		})
 	}
 
 	// Apply default values if not set
@@ -213,6 +488,12 @@ func (w *Worker) processImageResize(ctx context.Context, task rabbitmq.Task) err
 		Int("max_height", processorConfig.MaxHeight).
 		Int("quality", processorConfig.Quality).
 		Bool("optimize_storage", processorConfig.OptimizeStorage).
+		Str("gravity", processorConfig.Gravity).
+		Bool("blur_faces", processorConfig.BlurFaces).
+		Str("filter", processorConfig.Filter).
+		Float64("sharpen", processorConfig.Sharpen).
+		Int("target_size_kb", processorConfig.TargetSizeKB).
+		Int("renditions", len(processorConfig.Renditions)).
 		Msg("Effective image processing configuration")
 
 	// Get original image size from DB for metrics
@@ -223,14 +504,46 @@ func (w *Worker) processImageResize(ctx context.Context, task rabbitmq.Task) err
 		imgData = nil // Set to nil to avoid using it later
 	}
 
+	// Every run writes a new version instead of overwriting the current one
+	processorConfig.Version, err = w.repo.NextImageVersion(ctx, id)
+	if err != nil {
+		taskLogger.Error().Err(err).Msg("Failed to get next image version")
+		metrics.RecordProcessingTime(ctx, "db_version_error", startTime)
+		return fmt.Errorf("error getting next image version: %w", err)
+	}
+
 	// Process the image
+	processorConfig.Progress = newProgressReporter(w.repo, id).report
 	taskLogger.Debug().Msg("Calling image processor")
 	result, err := w.processor.ProcessImage(ctx, id, originalPath, filename, processorConfig)
+	if errors.Is(err, imageprocessor.ErrContentFlagged) {
+		metrics.RecordProcessingTime(ctx, "moderation_flagged", startTime)
+		return w.applyModerationPolicy(ctx, id, originalPath)
+	}
+	if errors.Is(err, imageprocessor.ErrImageTooLarge) {
+		// Retrying would hit the same limit, so fail the image and acknowledge the task
+		taskLogger.Error().Err(err).Msg("Image too large to process")
+		metrics.RecordProcessingTime(ctx, "too_large", startTime)
+		if updateErr := w.repo.UpdateLeasedImageStatus(ctx, id, w.id, models.StatusFailed, err.Error()); updateErr != nil {
+			return fmt.Errorf("error updating status of oversized image: %w", updateErr)
+		}
+		return nil
+	}
+	if errors.Is(err, imageprocessor.ErrInvalidImage) || errors.Is(err, imageprocessor.ErrUnsupportedFormat) {
+		// Uploads are only checked up to their header, so corrupt images are caught here;
+		// retrying would decode the same bytes, or ask for the same unsupported output
+		taskLogger.Error().Err(err).Msg("Image could not be decoded")
+		metrics.RecordProcessingTime(ctx, "invalid_image", startTime)
+		if updateErr := w.repo.UpdateLeasedImageStatus(ctx, id, w.id, models.StatusFailed, err.Error()); updateErr != nil {
+			return fmt.Errorf("error updating status of invalid image: %w", updateErr)
+		}
+		return nil
+	}
 	if err != nil {
 		errMsg := fmt.Sprintf("error processing image: %s", err.Error())
 		taskLogger.Error().Err(err).Msg("Image processing failed")
 
-		updateErr := w.repo.UpdateImageStatus(ctx, id, models.StatusFailed, errMsg)
+		updateErr := w.repo.UpdateLeasedImageStatus(ctx, id, w.id, models.StatusFailed, errMsg)
 		if updateErr != nil {
 			taskLogger.Error().Err(updateErr).Msg("Also failed to update image status to failed after processing error")
 		}
@@ -238,20 +551,41 @@ func (w *Worker) processImageResize(ctx context.Context, task rabbitmq.Task) err
 		return err
 	}
 
+	// Record the version before it becomes current
+	err = w.repo.CreateImageVersion(ctx, &models.ImageVersion{
+		ImageID:      id,
+		Version:      processorConfig.Version,
+		Path:         result.OptimizedPath,
+		Size:         result.OptimizedSize,
+		Width:        result.OptimizedWidth,
+		Height:       result.OptimizedHeight,
+		QualityScore: result.QualityScore,
+	})
+	if err != nil {
+		taskLogger.Warn().Err(err).Int("version", processorConfig.Version).Msg("Failed to record image version")
+	}
+
 	// Update image status to processed in DB
 	taskLogger.Debug().Msg("Updating image record with optimized data in DB")
 	err = w.repo.UpdateImageOptimized(
 		ctx,
 		id,
+		w.id,
 		result.OptimizedPath,
 		result.OptimizedSize,
 		result.OptimizedWidth,
 		result.OptimizedHeight,
 	)
+	if errors.Is(err, db.ErrLeaseLost) {
+		// the worker that took the image over records its own result
+		taskLogger.Warn().Err(err).Msg("Image lease lost before recording the result, discarding it")
+		metrics.ImageLeasesTotal.WithLabelValues("lost").Inc()
+		return nil
+	}
 	if err != nil {
 		errMsg := fmt.Sprintf("error updating image record after successful processing: %s", err.Error())
 		taskLogger.Error().Err(err).Msg("Failed to update image record in DB")
-		updateErr := w.repo.UpdateImageStatus(ctx, id, models.StatusFailed, errMsg)
+		updateErr := w.repo.UpdateLeasedImageStatus(ctx, id, w.id, models.StatusFailed, errMsg)
 		if updateErr != nil {
 			taskLogger.Error().Err(updateErr).Msg("Also failed to update image status to failed after DB update error")
 		}
@@ -262,11 +596,35 @@ func (w *Worker) processImageResize(ctx context.Context, task rabbitmq.Task) err
 	// Metric for processing time success
 	metrics.RecordProcessingTime(ctx, "success", startTime)
 
-	// Only record size reduction if we have original image data
+	if err := w.repo.UpdateImagePlaceholder(ctx, id, result.Placeholder.BlurHash, result.Placeholder.DominantColors); err != nil {
+		taskLogger.Warn().Err(err).Msg("Failed to store image placeholder")
+	}
+
+	if err := w.repo.UpdateImageQualityScore(ctx, id, result.QualityScore); err != nil {
+		taskLogger.Warn().Err(err).Msg("Failed to store image quality score")
+	}
+	metrics.RecordQualityScore(ctx, result.QualityScore)
+
+	if len(result.Renditions) > 0 {
+		w.storeRenditions(ctx, id, result.Renditions)
+	}
+
+	w.pruneVersions(ctx, id, originalPath)
+	w.promoteOriginal(ctx, id, originalPath)
+
+	if result.Moderation != nil {
+		if err := w.repo.UpdateModerationStatus(ctx, id, models.ModerationApproved); err != nil {
+			taskLogger.Warn().Err(err).Msg("Failed to record approved moderation status")
+		}
+	}
+
+	// Only record size reduction, formats and usage if we have original image data
 	if imgData != nil {
 		metrics.RecordSizeReduction(ctx, imgData.OriginalSize, result.OptimizedSize)
+		metrics.RecordOutputFormat(ctx, imgData.OriginalFormat, result.Format)
+		w.meter.Record(ctx, imgData.Owner, models.UsageCounts{ProcessedBytes: imgData.OriginalSize})
 	} else {
-		taskLogger.Warn().Msg("Skipping size reduction metric: original image data could not be fetched earlier.")
+		taskLogger.Warn().Msg("Skipping size reduction and format metrics and usage: original image data could not be fetched earlier.")
 	}
 
 	taskLogger.Info().
@@ -278,3 +636,286 @@ func (w *Worker) processImageResize(ctx context.Context, task rabbitmq.Task) err
 
 	return nil
 }
+
+// pruneVersions applies the version retention policy and deletes the objects of the
+// pruned versions. Failures are logged; the versions are pruned again after the next run.
+func (w *Worker) pruneVersions(ctx context.Context, id uuid.UUID, originalPath string) {
+	taskLogger := logger.FromContext(ctx)
+
+	removed, err := w.repo.PruneImageVersions(ctx, id, w.config.Versions.Retain, w.config.Versions.MaxAge)
+	if err != nil {
+		taskLogger.Warn().Err(err).Msg("Failed to prune image versions")
+		return
+	}
+
+	for _, version := range removed {
+		// Runs without any improvement point at the original, which must stay
+		if version.Path == originalPath {
+			continue
+		}
+		if err := minio.Release(ctx, w.minioClient.In(minio.ClassOptimized), w.repo, version.Path, uuid.Nil); err != nil {
+			taskLogger.Warn().Err(err).Int("version", version.Version).Msg("Failed to delete pruned image version")
+			continue
+		}
+		if err := w.invalidator.Invalidate(ctx, version.Path); err != nil {
+			taskLogger.Warn().Err(err).Int("version", version.Version).Msg("Failed to invalidate CDN cache for pruned image version")
+		}
+	}
+}
+
+// promoteOriginal moves the original of a processed image out of the upload quarantine
+// with a server-side copy. On failure the image keeps the quarantined original, which is
+// promoted when the image is reprocessed, or expires with the quarantine.
+func (w *Worker) promoteOriginal(ctx context.Context, id uuid.UUID, originalPath string) {
+	taskLogger := logger.FromContext(ctx)
+
+	prefix := w.config.MinIO.UploadQuarantinePrefix
+	if prefix == "" || !strings.HasPrefix(originalPath, prefix) {
+		return
+	}
+	permanentPath := strings.TrimPrefix(originalPath, prefix)
+	originals := w.minioClient.In(minio.ClassOriginal)
+
+	if err := minio.StoreCopy(ctx, originals, originalPath, permanentPath); err != nil {
+		taskLogger.Error().Err(err).Str("original_path", originalPath).Msg("Failed to copy original out of the upload quarantine")
+		return
+	}
+	if err := w.repo.MoveImageOriginal(ctx, id, originalPath, permanentPath); err != nil {
+		// The copy is left for the garbage collector
+		taskLogger.Error().Err(err).Str("original_path", originalPath).Msg("Failed to record promoted original")
+		return
+	}
+	if err := minio.Release(ctx, originals, w.repo, originalPath, id); err != nil {
+		taskLogger.Warn().Err(err).Str("original_path", originalPath).Msg("Failed to delete quarantined original after promotion")
+	}
+
+	taskLogger.Debug().Str("original_path", permanentPath).Msg("Original promoted out of the upload quarantine")
+}
+
+// currentOriginal returns the original of an image for tasks queued before it was promoted
+// out of the upload quarantine
+func (w *Worker) currentOriginal(ctx context.Context, id uuid.UUID, originalPath string) string {
+	prefix := w.config.MinIO.UploadQuarantinePrefix
+	if prefix == "" || !strings.HasPrefix(originalPath, prefix) {
+		return originalPath
+	}
+
+	img, err := w.repo.GetImageByID(ctx, id)
+	if err != nil {
+		taskLogger := logger.FromContext(ctx)
+		taskLogger.Warn().Err(err).Msg("Failed to get current original path, using the one of the task")
+		return originalPath
+	}
+	return img.OriginalPath
+}
+
+// storeRenditions records the renditions that were encoded successfully. Failed renditions
+// are logged and left out rather than failing the whole task.
+func (w *Worker) storeRenditions(ctx context.Context, id uuid.UUID, results []imageprocessor.RenditionResult) {
+	taskLogger := logger.FromContext(ctx)
+
+	renditions := make([]models.Rendition, 0, len(results))
+	for _, r := range results {
+		if r.Err != nil {
+			taskLogger.Warn().Err(r.Err).Str("rendition", r.Name).Msg("Rendition failed")
+			continue
+		}
+		renditions = append(renditions, models.Rendition{
+			Name:   r.Name,
+			Path:   r.Path,
+			Size:   r.Size,
+			Width:  r.Width,
+			Height: r.Height,
+			Format: r.Format,
+		})
+	}
+
+	if err := w.repo.UpdateImageRenditions(ctx, id, renditions); err != nil {
+		taskLogger.Warn().Err(err).Msg("Failed to store image renditions")
+	}
+}
+
+// applyModerationPolicy handles an image flagged by moderation according to the configured policy.
+// Quarantined images keep their original for review; rejected images have it deleted.
+// The task is acknowledged in both cases since retrying would produce the same verdict.
+func (w *Worker) applyModerationPolicy(ctx context.Context, id uuid.UUID, originalPath string) error {
+	taskLogger := logger.FromContext(ctx)
+
+	status := models.ModerationQuarantined
+	errMsg := "content quarantined by moderation pending review"
+	if w.config.Moderation.Policy == "reject" {
+		status = models.ModerationRejected
+		errMsg = "content rejected by moderation"
+
+		if err := w.minioClient.In(minio.ClassOriginal).DeleteImage(ctx, originalPath); err != nil {
+			taskLogger.Error().Err(err).Msg("Failed to delete original of rejected image")
+		}
+	}
+
+	taskLogger.Warn().Str("moderation_status", string(status)).Msg("Applying moderation policy to flagged image")
+
+	if err := w.repo.UpdateModerationStatus(ctx, id, status); err != nil {
+		taskLogger.Error().Err(err).Msg("Failed to update moderation status")
+		return fmt.Errorf("error updating moderation status: %w", err)
+	}
+
+	if err := w.repo.UpdateLeasedImageStatus(ctx, id, w.id, models.StatusFailed, errMsg); err != nil {
+		taskLogger.Error().Err(err).Msg("Failed to update image status after moderation")
+		return fmt.Errorf("error updating image status after moderation: %w", err)
+	}
+
+	return nil
+}
+
+// processTextExtraction runs OCR on the original image and stores the extracted text.
+func (w *Worker) processTextExtraction(ctx context.Context, task rabbitmq.Task) error {
+	startTime := time.Now()
+
+	taskLogger := logger.FromContext(ctx).With().Str("component", "worker-text-extractor").Logger()
+
+	if w.extractor == nil {
+		taskLogger.Warn().Msg("Received text extraction task but OCR is disabled; skipping")
+		return nil
+	}
+
+	id, originalPath, err := parseImageTask(task)
+	if err != nil {
+		taskLogger.Error().Err(err).Msg("Invalid text extraction task data")
+		return err
+	}
+	taskLogger = taskLogger.With().Str("image_id", id.String()).Logger()
+	ctx = logger.ToContext(ctx, taskLogger)
+
+	taskLogger.Info().Msg("Processing text extraction task")
+	originalPath = w.currentOriginal(ctx, id, originalPath)
+
+	data, err := w.readObject(ctx, originalPath)
+	if err != nil {
+		metrics.RecordProcessingTime(ctx, "ocr_error", startTime)
+		return err
+	}
+
+	text, err := w.extractor.ExtractText(ctx, data, http.DetectContentType(data))
+	if err != nil {
+		taskLogger.Error().Err(err).Msg("Text extraction failed")
+		metrics.RecordProcessingTime(ctx, "ocr_error", startTime)
+		return fmt.Errorf("error extracting text: %w", err)
+	}
+
+	if err := w.repo.UpdateImageText(ctx, id, text); err != nil {
+		taskLogger.Error().Err(err).Msg("Failed to store extracted text")
+		metrics.RecordProcessingTime(ctx, "ocr_db_update_error", startTime)
+		return fmt.Errorf("error storing extracted text: %w", err)
+	}
+
+	metrics.RecordProcessingTime(ctx, "ocr_success", startTime)
+	taskLogger.Info().Int("text_length", len(text)).Msg("Text extracted and stored successfully")
+	return nil
+}
+
+// processBackgroundRemoval produces a transparent cut-out of the original image and stores it.
+func (w *Worker) processBackgroundRemoval(ctx context.Context, task rabbitmq.Task) error {
+	startTime := time.Now()
+
+	taskLogger := logger.FromContext(ctx).With().Str("component", "worker-background-remover").Logger()
+
+	if w.remover == nil {
+		taskLogger.Warn().Msg("Received background removal task but background removal is disabled; skipping")
+		return nil
+	}
+
+	id, originalPath, err := parseImageTask(task)
+	if err != nil {
+		taskLogger.Error().Err(err).Msg("Invalid background removal task data")
+		return err
+	}
+	taskLogger = taskLogger.With().Str("image_id", id.String()).Logger()
+	ctx = logger.ToContext(ctx, taskLogger)
+
+	taskLogger.Info().Msg("Processing background removal task")
+	originalPath = w.currentOriginal(ctx, id, originalPath)
+
+	data, err := w.readObject(ctx, originalPath)
+	if err != nil {
+		metrics.RecordBackgroundRemoval(ctx, "storage_error", startTime)
+		return err
+	}
+
+	cutout, contentType, err := w.remover.RemoveBackground(ctx, data, http.DetectContentType(data))
+	if err != nil {
+		taskLogger.Error().Err(err).Msg("Background removal failed")
+		metrics.RecordBackgroundRemoval(ctx, "error", startTime)
+		return fmt.Errorf("error removing background: %w", err)
+	}
+
+	// The cutout is named after its size when its header can be read
+	variant := minio.Variant{Name: "cutout", Format: w.config.Background.Format}
+	if header, _, err := image.DecodeConfig(bytes.NewReader(cutout)); err == nil {
+		variant.Width, variant.Height = header.Width, header.Height
+	}
+	cutoutPath := w.minioClient.DerivedObjectName(originalPath, id, variant, cutout)
+	if err := minio.Store(ctx, w.minioClient.In(minio.ClassOptimized), bytes.NewReader(cutout), cutoutPath, contentType); err != nil {
+		metrics.RecordBackgroundRemoval(ctx, "storage_error", startTime)
+		return fmt.Errorf("error uploading cutout: %w", err)
+	}
+
+	if err := w.repo.UpdateImageCutout(ctx, id, cutoutPath, int64(len(cutout))); err != nil {
+		taskLogger.Error().Err(err).Msg("Failed to store cutout path")
+		metrics.RecordBackgroundRemoval(ctx, "db_update_error", startTime)
+		return fmt.Errorf("error storing cutout path: %w", err)
+	}
+
+	metrics.RecordBackgroundRemoval(ctx, "success", startTime)
+	taskLogger.Info().Str("cutout_path", cutoutPath).Int("cutout_size", len(cutout)).Msg("Background removed and cutout stored successfully")
+	return nil
+}
+
+// taskTags describes a task in error reports
+func taskTags(task rabbitmq.Task) map[string]string {
+	tags := map[string]string{
+		"component": "worker",
+		"task_id":   task.ID,
+		"task_type": string(task.Type),
+	}
+	if imageID := taskImageID(task); imageID != "" {
+		tags["image_id"] = imageID
+	}
+	return tags
+}
+
+// parseImageTask extracts the image ID and original path shared by image tasks.
+func parseImageTask(task rabbitmq.Task) (uuid.UUID, string, error) {
+	payload, err := decodeImagePayload(task)
+	if err != nil {
+		return uuid.Nil, "", err
+	}
+	if payload.ImageID == "" {
+		return uuid.Nil, "", fmt.Errorf("%w: missing or invalid image_id", errInvalidTask)
+	}
+	if payload.OriginalPath == "" {
+		return uuid.Nil, "", fmt.Errorf("%w: missing or invalid original_path", errInvalidTask)
+	}
+
+	id, err := uuid.Parse(payload.ImageID)
+	if err != nil {
+		return uuid.Nil, "", fmt.Errorf("%w: invalid image ID format '%s': %w", errInvalidTask, payload.ImageID, err)
+	}
+
+	return id, payload.OriginalPath, nil
+}
+
+// readObject reads a whole object from storage into memory.
+func (w *Worker) readObject(ctx context.Context, objectName string) ([]byte, error) {
+	reader, err := w.minioClient.In(minio.ClassOriginal).GetImage(ctx, objectName)
+	if err != nil {
+		return nil, fmt.Errorf("error getting object %s: %w", objectName, err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("error reading object %s: %w", objectName, err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,3 @@
+ALTER TABLE images ADD COLUMN moderation_status VARCHAR(20) NOT NULL DEFAULT 'unchecked';
+
+CREATE INDEX idx_images_moderation_status ON images (moderation_status);
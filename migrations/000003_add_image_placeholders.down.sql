@@ -0,0 +1,2 @@
+ALTER TABLE images DROP COLUMN IF EXISTS dominant_colors;
+ALTER TABLE images DROP COLUMN IF EXISTS blurhash;
@@ -0,0 +1,2 @@
+ALTER TABLE images ADD COLUMN blurhash TEXT NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN dominant_colors TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_images_search_vector;
+ALTER TABLE images DROP COLUMN IF EXISTS search_vector;
+ALTER TABLE images DROP COLUMN IF EXISTS extracted_text;
@@ -0,0 +1,5 @@
+ALTER TABLE images ADD COLUMN extracted_text TEXT NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN search_vector tsvector
+  GENERATED ALWAYS AS (to_tsvector('simple', original_name || ' ' || extracted_text)) STORED;
+
+CREATE INDEX idx_images_search_vector ON images USING GIN (search_vector);
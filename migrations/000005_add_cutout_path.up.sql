@@ -0,0 +1 @@
+ALTER TABLE images ADD COLUMN cutout_path TEXT NOT NULL DEFAULT '';
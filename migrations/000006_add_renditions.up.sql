@@ -0,0 +1 @@
+ALTER TABLE images ADD COLUMN renditions JSONB NOT NULL DEFAULT '[]';
@@ -0,0 +1 @@
+ALTER TABLE images ADD COLUMN quality_score DOUBLE PRECISION NOT NULL DEFAULT 0;
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS task_outbox;
+
+-- PostgreSQL cannot drop enum values; move affected rows back to pending instead
+UPDATE images SET status = 'pending' WHERE status = 'queued_failed';
@@ -0,0 +1,13 @@
+ALTER TYPE processing_status ADD VALUE IF NOT EXISTS 'queued_failed';
+
+CREATE TABLE IF NOT EXISTS task_outbox (
+  id BIGSERIAL PRIMARY KEY,
+  image_id UUID NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+  payload JSONB NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  last_error TEXT NOT NULL DEFAULT '',
+  next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_task_outbox_next_attempt_at ON task_outbox (next_attempt_at);
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_images_original_format;
+DROP TRIGGER IF EXISTS images_stored_bytes ON images;
+DROP FUNCTION IF EXISTS images_stored_bytes();
+ALTER TABLE images DROP COLUMN IF EXISTS stored_bytes;
+ALTER TABLE images DROP COLUMN IF EXISTS cutout_size;
@@ -0,0 +1,20 @@
+ALTER TABLE images ADD COLUMN cutout_size BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE images ADD COLUMN stored_bytes BIGINT NOT NULL DEFAULT 0;
+
+-- stored_bytes is the total size of every object kept for an image: original, optimized, renditions and cut-out
+CREATE OR REPLACE FUNCTION images_stored_bytes() RETURNS trigger AS $$
+BEGIN
+  NEW.stored_bytes := NEW.original_size + COALESCE(NEW.optimized_size, 0) + NEW.cutout_size
+    + COALESCE((SELECT SUM((r->>'size')::BIGINT) FROM jsonb_array_elements(NEW.renditions) r), 0);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER images_stored_bytes
+  BEFORE INSERT OR UPDATE ON images
+  FOR EACH ROW EXECUTE FUNCTION images_stored_bytes();
+
+-- Backfill existing rows through the trigger
+UPDATE images SET stored_bytes = 0;
+
+CREATE INDEX idx_images_original_format ON images (original_format);
@@ -0,0 +1,2 @@
+DROP MATERIALIZED VIEW IF EXISTS image_daily_stats;
+ALTER TABLE images DROP COLUMN IF EXISTS processed_at;
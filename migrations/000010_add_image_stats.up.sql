@@ -0,0 +1,10 @@
+ALTER TABLE images ADD COLUMN processed_at TIMESTAMP WITH TIME ZONE;
+
+-- Daily upload counts for the stats endpoint, refreshed by the API when STATS_MATERIALIZED_VIEW is enabled
+CREATE MATERIALIZED VIEW IF NOT EXISTS image_daily_stats AS
+  SELECT created_at::date AS day, COUNT(*) AS uploads
+  FROM images
+  GROUP BY created_at::date;
+
+-- Required by REFRESH MATERIALIZED VIEW CONCURRENTLY
+CREATE UNIQUE INDEX idx_image_daily_stats_day ON image_daily_stats (day);
@@ -0,0 +1,11 @@
+CREATE OR REPLACE FUNCTION images_stored_bytes() RETURNS trigger AS $$
+BEGIN
+  NEW.stored_bytes := NEW.original_size + COALESCE(NEW.optimized_size, 0) + NEW.cutout_size
+    + COALESCE((SELECT SUM((r->>'size')::BIGINT) FROM jsonb_array_elements(NEW.renditions) r), 0);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TABLE IF EXISTS image_versions;
+
+UPDATE images SET stored_bytes = 0;
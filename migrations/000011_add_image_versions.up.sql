@@ -0,0 +1,28 @@
+CREATE TABLE IF NOT EXISTS image_versions (
+  image_id UUID NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+  version INTEGER NOT NULL,
+  path TEXT NOT NULL,
+  size BIGINT NOT NULL,
+  width INTEGER NOT NULL,
+  height INTEGER NOT NULL,
+  quality_score DOUBLE PRECISION NOT NULL DEFAULT 0,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (image_id, version)
+);
+
+-- Existing optimized outputs become the first version
+INSERT INTO image_versions (image_id, version, path, size, width, height, quality_score, created_at)
+SELECT id, 1, optimized_path, optimized_size, optimized_width, optimized_height, quality_score, COALESCE(processed_at, updated_at)
+FROM images
+WHERE optimized_path IS NOT NULL AND optimized_path <> '';
+
+-- Older versions count towards stored_bytes; the current one is already counted as optimized_size
+CREATE OR REPLACE FUNCTION images_stored_bytes() RETURNS trigger AS $$
+BEGIN
+  NEW.stored_bytes := NEW.original_size + COALESCE(NEW.optimized_size, 0) + NEW.cutout_size
+    + COALESCE((SELECT SUM((r->>'size')::BIGINT) FROM jsonb_array_elements(NEW.renditions) r), 0)
+    + COALESCE((SELECT SUM(v.size) FROM image_versions v
+        WHERE v.image_id = NEW.id AND v.path <> COALESCE(NEW.optimized_path, '') AND v.path <> NEW.original_path), 0);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
@@ -0,0 +1,9 @@
+-- Pending two-step deletions; the token itself is never stored
+CREATE TABLE IF NOT EXISTS image_deletions (
+  image_id UUID PRIMARY KEY REFERENCES images(id) ON DELETE CASCADE,
+  token_hash TEXT NOT NULL,
+  requested_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_image_deletions_expires_at ON image_deletions (expires_at);
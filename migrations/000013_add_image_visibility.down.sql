@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_images_owner;
+ALTER TABLE images DROP COLUMN IF EXISTS owner;
+ALTER TABLE images DROP COLUMN IF EXISTS visibility;
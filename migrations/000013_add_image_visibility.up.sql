@@ -0,0 +1,4 @@
+ALTER TABLE images ADD COLUMN visibility VARCHAR(20) NOT NULL DEFAULT 'public';
+ALTER TABLE images ADD COLUMN owner TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX idx_images_owner ON images (owner) WHERE visibility = 'private';
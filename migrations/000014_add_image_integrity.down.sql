@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_images_integrity_checked_at;
+ALTER TABLE images DROP COLUMN IF EXISTS integrity_checked_at;
+ALTER TABLE images DROP COLUMN IF EXISTS integrity_status;
+ALTER TABLE images DROP COLUMN IF EXISTS original_checksum;
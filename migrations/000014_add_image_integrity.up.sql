@@ -0,0 +1,5 @@
+ALTER TABLE images ADD COLUMN original_checksum VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN integrity_status VARCHAR(20) NOT NULL DEFAULT 'unverified';
+ALTER TABLE images ADD COLUMN integrity_checked_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_images_integrity_checked_at ON images (integrity_checked_at NULLS FIRST);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_images_replication_pending;
+ALTER TABLE images DROP COLUMN IF EXISTS replicated_at;
+ALTER TABLE images DROP COLUMN IF EXISTS replication_status;
@@ -0,0 +1,4 @@
+ALTER TABLE images ADD COLUMN replication_status VARCHAR(20) NOT NULL DEFAULT 'pending';
+ALTER TABLE images ADD COLUMN replicated_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX idx_images_replication_pending ON images (processed_at) WHERE status = 'completed' AND replication_status <> 'replicated';
@@ -0,0 +1,2 @@
+ALTER TABLE images DROP COLUMN IF EXISTS processing_task_id;
+DROP TABLE IF EXISTS task_ledger;
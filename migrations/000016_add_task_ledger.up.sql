@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS task_ledger (
+  task_id VARCHAR(64) NOT NULL,
+  image_id UUID NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+  task_type VARCHAR(32) NOT NULL,
+  attempt INTEGER NOT NULL DEFAULT 1,
+  status VARCHAR(20) NOT NULL DEFAULT 'running',
+  started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  finished_at TIMESTAMP WITH TIME ZONE,
+  -- Tasks queued before task IDs were unique share the image ID across types
+  PRIMARY KEY (task_id, task_type)
+);
+
+CREATE INDEX idx_task_ledger_image_id ON task_ledger (image_id);
+
+-- The resize task that last moved the image to processing
+ALTER TABLE images ADD COLUMN processing_task_id VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+ALTER TABLE images DROP COLUMN IF EXISTS processing_attempts;
+ALTER TABLE task_ledger DROP COLUMN IF EXISTS last_error;
@@ -0,0 +1,4 @@
+ALTER TABLE task_ledger ADD COLUMN last_error TEXT NOT NULL DEFAULT '';
+
+-- Attempts of the resize task that last moved the image to processing
+ALTER TABLE images ADD COLUMN processing_attempts INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+ALTER TABLE images DROP COLUMN IF EXISTS processing_stage;
+ALTER TABLE images DROP COLUMN IF EXISTS processing_progress;
@@ -0,0 +1,3 @@
+-- Progress of the running resize task, in percent, and the stage it is in
+ALTER TABLE images ADD COLUMN processing_progress SMALLINT NOT NULL DEFAULT 0;
+ALTER TABLE images ADD COLUMN processing_stage VARCHAR(32) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_images_original_checksum;
+ALTER TABLE images DROP COLUMN IF EXISTS original_md5;
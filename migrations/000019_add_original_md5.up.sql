@@ -0,0 +1,4 @@
+ALTER TABLE images ADD COLUMN original_md5 VARCHAR(32) NOT NULL DEFAULT '';
+
+-- Uploads with a client checksum look up earlier uploads of the same content
+CREATE INDEX idx_images_original_checksum ON images (original_checksum) WHERE original_checksum <> '';
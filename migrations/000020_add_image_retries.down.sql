@@ -0,0 +1,2 @@
+ALTER TABLE images DROP COLUMN IF EXISTS auto_retries;
+ALTER TABLE task_ledger DROP COLUMN IF EXISTS payload;
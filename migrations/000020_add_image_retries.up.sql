@@ -0,0 +1,4 @@
+-- The task as published, so a failed image can be retried with the same options
+ALTER TABLE task_ledger ADD COLUMN payload JSONB;
+
+ALTER TABLE images ADD COLUMN auto_retries INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,26 @@
+-- Billable usage per API key owner and UTC day, counted as requests are served
+CREATE TABLE IF NOT EXISTS usage_daily (
+  owner TEXT NOT NULL,
+  day DATE NOT NULL,
+  uploads BIGINT NOT NULL DEFAULT 0,
+  processed_bytes BIGINT NOT NULL DEFAULT 0,
+  transformations BIGINT NOT NULL DEFAULT 0,
+  -- Bytes stored by the owner at the last snapshot of the day
+  stored_bytes BIGINT NOT NULL DEFAULT 0,
+  PRIMARY KEY (owner, day)
+);
+
+-- Monthly rollups of usage_daily, the basis of invoices
+CREATE TABLE IF NOT EXISTS usage_monthly (
+  owner TEXT NOT NULL,
+  month DATE NOT NULL,
+  uploads BIGINT NOT NULL DEFAULT 0,
+  processed_bytes BIGINT NOT NULL DEFAULT 0,
+  transformations BIGINT NOT NULL DEFAULT 0,
+  -- Sum of the daily stored bytes of the month
+  storage_byte_days BIGINT NOT NULL DEFAULT 0,
+  updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (owner, month)
+);
+
+CREATE INDEX idx_usage_monthly_month ON usage_monthly (month);
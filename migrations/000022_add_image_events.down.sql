@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS images_record_event_update ON images;
+DROP TRIGGER IF EXISTS images_record_event_insert ON images;
+DROP FUNCTION IF EXISTS images_record_event();
+DROP TABLE IF EXISTS image_events;
+ALTER TABLE task_ledger DROP COLUMN IF EXISTS worker_id;
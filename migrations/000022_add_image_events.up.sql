@@ -0,0 +1,60 @@
+-- The worker that began each task, recorded in the history of its image
+ALTER TABLE task_ledger ADD COLUMN worker_id VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS image_events (
+  id BIGSERIAL PRIMARY KEY,
+  image_id UUID NOT NULL REFERENCES images(id) ON DELETE CASCADE,
+  from_status VARCHAR(20) NOT NULL DEFAULT '',
+  status VARCHAR(20) NOT NULL,
+  error TEXT NOT NULL DEFAULT '',
+  worker_id VARCHAR(255) NOT NULL DEFAULT '',
+  attempt INTEGER NOT NULL DEFAULT 0,
+  -- How long the image was in from_status
+  duration_ms BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_image_events_image_id ON image_events (image_id, id);
+
+-- Existing images start their history in their current status
+INSERT INTO image_events (image_id, status, error, created_at)
+SELECT id, status, COALESCE(error, ''), updated_at FROM images;
+
+-- Every status change is recorded, whether the API, the worker or a background job made it.
+-- Transitions into and out of processing are attributed to the worker and attempt of the
+-- resize task processing the image.
+CREATE OR REPLACE FUNCTION images_record_event() RETURNS trigger AS $$
+DECLARE
+  prev_status VARCHAR(20) := '';
+  since TIMESTAMP WITH TIME ZONE := NEW.created_at;
+  task_worker VARCHAR(255) := '';
+  task_attempt INTEGER := 0;
+BEGIN
+  IF TG_OP = 'UPDATE' THEN
+    prev_status := OLD.status;
+    since := COALESCE((SELECT MAX(created_at) FROM image_events WHERE image_id = NEW.id), OLD.created_at);
+  END IF;
+
+  IF (prev_status = 'processing' OR NEW.status = 'processing') AND NEW.processing_task_id <> '' THEN
+    SELECT worker_id, attempt INTO task_worker, task_attempt
+    FROM task_ledger
+    WHERE task_id = NEW.processing_task_id AND task_type = 'resize_image';
+  END IF;
+
+  INSERT INTO image_events (image_id, from_status, status, error, worker_id, attempt, duration_ms)
+  VALUES (
+    NEW.id, prev_status, NEW.status, COALESCE(NEW.error, ''), COALESCE(task_worker, ''), COALESCE(task_attempt, 0),
+    GREATEST((EXTRACT(EPOCH FROM (NOW() - since)) * 1000)::BIGINT, 0)
+  );
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER images_record_event_insert
+  AFTER INSERT ON images
+  FOR EACH ROW EXECUTE FUNCTION images_record_event();
+
+CREATE TRIGGER images_record_event_update
+  AFTER UPDATE OF status ON images
+  FOR EACH ROW WHEN (OLD.status IS DISTINCT FROM NEW.status)
+  EXECUTE FUNCTION images_record_event();
@@ -0,0 +1,2 @@
+ALTER TABLE images DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE images DROP COLUMN IF EXISTS lease_owner;
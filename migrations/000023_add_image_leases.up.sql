@@ -0,0 +1,4 @@
+-- The worker processing an image holds a lease on it, renewed while it works. Another
+-- worker may only take the image over once the lease expired.
+ALTER TABLE images ADD COLUMN lease_owner VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN lease_expires_at TIMESTAMP WITH TIME ZONE;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS images_notify_status_delete ON images;
+DROP TRIGGER IF EXISTS images_notify_status_update ON images;
+DROP FUNCTION IF EXISTS images_notify_status();
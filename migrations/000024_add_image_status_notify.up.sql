@@ -0,0 +1,22 @@
+-- Status changes and deletes of images are notified on the image_status channel with the
+-- image ID, so the API can answer clients waiting for an image without polling it
+CREATE OR REPLACE FUNCTION images_notify_status() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    PERFORM pg_notify('image_status', OLD.id::text);
+    RETURN OLD;
+  END IF;
+
+  PERFORM pg_notify('image_status', NEW.id::text);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER images_notify_status_update
+  AFTER UPDATE OF status ON images
+  FOR EACH ROW WHEN (OLD.status IS DISTINCT FROM NEW.status)
+  EXECUTE FUNCTION images_notify_status();
+
+CREATE TRIGGER images_notify_status_delete
+  AFTER DELETE ON images
+  FOR EACH ROW EXECUTE FUNCTION images_notify_status();
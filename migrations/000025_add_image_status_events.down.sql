@@ -0,0 +1,17 @@
+CREATE OR REPLACE FUNCTION images_notify_status() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    PERFORM pg_notify('image_status', OLD.id::text);
+    RETURN OLD;
+  END IF;
+
+  PERFORM pg_notify('image_status', NEW.id::text);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS images_notify_status_update ON images;
+CREATE TRIGGER images_notify_status_update
+  AFTER UPDATE OF status ON images
+  FOR EACH ROW WHEN (OLD.status IS DISTINCT FROM NEW.status)
+  EXECUTE FUNCTION images_notify_status();
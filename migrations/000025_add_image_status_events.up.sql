@@ -0,0 +1,29 @@
+-- Notifications on the image_status channel carry the new status and progress of the
+-- image as JSON, and are also sent when only the progress changes, so the API can relay
+-- them to its subscribers without reading the image
+CREATE OR REPLACE FUNCTION images_notify_status() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    PERFORM pg_notify('image_status', json_build_object('id', OLD.id, 'deleted', true)::text);
+    RETURN OLD;
+  END IF;
+
+  PERFORM pg_notify('image_status', json_build_object(
+    'id', NEW.id,
+    'status', NEW.status,
+    'progress', NEW.processing_progress,
+    'stage', NEW.processing_stage
+  )::text);
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS images_notify_status_update ON images;
+CREATE TRIGGER images_notify_status_update
+  AFTER UPDATE OF status, processing_progress, processing_stage ON images
+  FOR EACH ROW WHEN (
+    OLD.status IS DISTINCT FROM NEW.status
+    OR OLD.processing_progress IS DISTINCT FROM NEW.processing_progress
+    OR OLD.processing_stage IS DISTINCT FROM NEW.processing_stage
+  )
+  EXECUTE FUNCTION images_notify_status();
@@ -0,0 +1,8 @@
+-- Upload tokens are single use: the ID of every token used is kept until it expires, so a
+-- replayed token is rejected
+CREATE TABLE IF NOT EXISTS upload_tokens (
+  id UUID PRIMARY KEY,
+  expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_upload_tokens_expires_at ON upload_tokens (expires_at);
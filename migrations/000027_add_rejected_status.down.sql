@@ -0,0 +1,2 @@
+-- PostgreSQL cannot drop enum values; move affected rows back to failed instead
+UPDATE images SET status = 'failed' WHERE status = 'rejected';
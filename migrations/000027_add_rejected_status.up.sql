@@ -0,0 +1 @@
+ALTER TYPE processing_status ADD VALUE IF NOT EXISTS 'rejected';
@@ -0,0 +1,121 @@
+package optimizer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+
+	"github.com/disintegration/imaging"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// pixelDiffThreshold is the channel difference, out of 255, above which a pixel
+	// counts as changed; smaller differences are invisible encoding noise
+	pixelDiffThreshold = 16
+	// compositeHeight is the height both sides of a comparison composite are fitted to
+	compositeHeight = 512
+	// compositeGap is the width of the separator between both sides of a composite
+	compositeGap     = 8
+	compositeQuality = 85
+)
+
+// compositeBackground fills the separator of a composite
+var compositeBackground = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+
+// Comparison describes how an optimized image differs from its original
+type Comparison struct {
+	// SSIM is the structural similarity of the optimized image to the original, from 0
+	// (unrelated) to 1 (identical)
+	SSIM float64
+	// PixelDiff is the percentage of pixels whose color changed noticeably
+	PixelDiff float64
+	// Composite is a JPEG of the original and the optimized image side by side, if requested
+	Composite []byte
+}
+
+// Compare decodes the original and optimized images read from original and optimized and
+// measures their difference, over samples reduced to the same size. With composite set, it
+// also renders both side by side.
+func Compare(ctx context.Context, original, optimized io.Reader, composite bool) (Comparison, error) {
+	a, _, err := image.Decode(original)
+	if err != nil {
+		return Comparison{}, fmt.Errorf("%w: error decoding original image: %w", ErrInvalidImage, err)
+	}
+	b, _, err := image.Decode(optimized)
+	if err != nil {
+		return Comparison{}, fmt.Errorf("%w: error decoding optimized image: %w", ErrInvalidImage, err)
+	}
+
+	comparison := Comparison{
+		SSIM:      ssim(a, b),
+		PixelDiff: pixelDiff(a, b),
+	}
+
+	if composite {
+		buf := new(bytes.Buffer)
+		if _, err := encodeTo(buf, sideBySide(a, b), "jpeg", compositeQuality); err != nil {
+			return Comparison{}, err
+		}
+		comparison.Composite = buf.Bytes()
+	}
+
+	zerolog.Ctx(ctx).Debug().
+		Float64("ssim", comparison.SSIM).
+		Float64("pixel_diff", comparison.PixelDiff).
+		Msg("Images compared")
+
+	return comparison, nil
+}
+
+// pixelDiff returns the percentage of pixels of b differing from a by more than
+// pixelDiffThreshold in any channel. Both images are reduced to the same sample size first.
+func pixelDiff(a, b image.Image) float64 {
+	sampleA := imaging.Fit(a, qualitySampleSize, qualitySampleSize, imaging.Box)
+	bounds := sampleA.Bounds()
+	sampleB := imaging.Resize(b, bounds.Dx(), bounds.Dy(), imaging.Box)
+
+	pixels := bounds.Dx() * bounds.Dy()
+	if pixels == 0 {
+		return 0
+	}
+
+	var changed int
+	for i := 0; i < len(sampleA.Pix); i += 4 {
+		for channel := range 4 {
+			if absDiff(sampleA.Pix[i+channel], sampleB.Pix[i+channel]) > pixelDiffThreshold {
+				changed++
+				break
+			}
+		}
+	}
+
+	return float64(changed) / float64(pixels) * 100
+}
+
+func absDiff(a, b uint8) uint8 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// sideBySide fits a and b to the composite height and places them left to right
+func sideBySide(a, b image.Image) *image.NRGBA {
+	left := imaging.Resize(a, 0, min(a.Bounds().Dy(), compositeHeight), imaging.Lanczos)
+	right := imaging.Resize(b, 0, left.Bounds().Dy(), imaging.Lanczos)
+
+	width := left.Bounds().Dx() + compositeGap + right.Bounds().Dx()
+	height := max(left.Bounds().Dy(), right.Bounds().Dy())
+	composite := imaging.New(width, height, compositeBackground)
+
+	draw.Draw(composite, left.Bounds(), left, image.Point{}, draw.Src)
+	offset := image.Pt(left.Bounds().Dx()+compositeGap, 0)
+	draw.Draw(composite, right.Bounds().Add(offset), right, image.Point{}, draw.Src)
+
+	return composite
+}
@@ -0,0 +1,65 @@
+package optimizer
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"math"
+)
+
+// FitDimensions returns the dimensions a width x height image is resized to when fitted
+// within maxWidth x maxHeight, which are never larger than the image
+func FitDimensions(width, height, maxWidth, maxHeight int) (int, int) {
+	return fitDimensions(width, height, maxWidth, maxHeight)
+}
+
+// fitDimensions calculates the dimensions that fit within maxWidth x maxHeight while
+// maintaining the aspect ratio. Images are never upscaled.
+func fitDimensions(width, height, maxWidth, maxHeight int) (int, int) {
+	// If no maximum dimensions are specified, keep original size
+	if maxWidth <= 0 || maxHeight <= 0 {
+		return width, height
+	}
+
+	// Use the smaller factor to ensure the image fits within the maximum dimensions
+	widthFactor := float64(maxWidth) / float64(width)
+	heightFactor := float64(maxHeight) / float64(height)
+	scaleFactor := math.Min(widthFactor, heightFactor)
+
+	// Only resize if the image is larger than the target dimensions
+	if scaleFactor >= 1.0 {
+		return width, height
+	}
+
+	return int(float64(width) * scaleFactor), int(float64(height) * scaleFactor)
+}
+
+// encodeTo encodes img in the given format into buf and returns its content type.
+func encodeTo(buf *bytes.Buffer, img image.Image, format string, quality int) (string, error) {
+	var contentType string
+	var err error
+
+	switch format {
+	case "jpeg":
+		contentType = "image/jpeg"
+		err = jpeg.Encode(buf, img, &jpeg.Options{
+			Quality: quality,
+		})
+	case "png":
+		contentType = "image/png"
+		encoder := png.Encoder{
+			CompressionLevel: png.BestCompression,
+		}
+		err = encoder.Encode(buf, img)
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("error encoding processed image: %w", err)
+	}
+
+	return contentType, nil
+}
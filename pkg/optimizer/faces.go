@@ -0,0 +1,148 @@
+package optimizer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// GravityCenter crops the image to the target aspect ratio around its center
+	GravityCenter = "center"
+	// GravityFaces crops the image to the target aspect ratio around the detected faces
+	GravityFaces = "faces"
+)
+
+// ErrFaceDetectionUnavailable is returned when face blurring is requested without a detector
+var ErrFaceDetectionUnavailable = errors.New("face detection is not enabled")
+
+// FaceDetector finds faces in an image and returns their bounding boxes in image coordinates
+type FaceDetector interface {
+	Detect(ctx context.Context, img image.Image) ([]image.Rectangle, error)
+}
+
+// WithFaceDetector enables face-aware cropping and face blurring
+func WithFaceDetector(detector FaceDetector) Option {
+	return func(o *Optimizer) {
+		o.faceDetector = detector
+	}
+}
+
+// applyFaceOperations blurs faces and crops the image according to the configured gravity.
+// It reports whether the image was modified.
+func (o *Optimizer) applyFaceOperations(ctx context.Context, img image.Image, opts Options) (image.Image, bool, error) {
+	log := zerolog.Ctx(ctx)
+
+	if opts.Gravity == "" && !opts.BlurFaces {
+		return img, false, nil
+	}
+
+	var faces []image.Rectangle
+	if opts.Gravity == GravityFaces || opts.BlurFaces {
+		if o.faceDetector == nil {
+			// Never publish an image that was meant to be anonymised
+			if opts.BlurFaces {
+				return nil, false, ErrFaceDetectionUnavailable
+			}
+			log.Warn().Msg("Face gravity requested but face detection is disabled, using center gravity")
+		} else {
+			var err error
+			faces, err = o.faceDetector.Detect(ctx, img)
+			if err != nil {
+				return nil, false, fmt.Errorf("error detecting faces: %w", err)
+			}
+			log.Debug().Int("faces", len(faces)).Msg("Faces detected")
+		}
+	}
+
+	result := img
+	modified := false
+
+	if opts.BlurFaces && len(faces) > 0 {
+		result = blurRegions(result, faces)
+		modified = true
+	}
+
+	if opts.Gravity == GravityCenter || opts.Gravity == GravityFaces {
+		bounds := result.Bounds()
+		focus := image.Pt((bounds.Min.X+bounds.Max.X)/2, (bounds.Min.Y+bounds.Max.Y)/2)
+		if len(faces) > 0 {
+			union := faces[0]
+			for _, f := range faces[1:] {
+				union = union.Union(f)
+			}
+			focus = image.Pt((union.Min.X+union.Max.X)/2, (union.Min.Y+union.Max.Y)/2).Sub(img.Bounds().Min).Add(bounds.Min)
+		}
+
+		cropped, ok := cropToAspect(result, opts.MaxWidth, opts.MaxHeight, focus)
+		if ok {
+			result = cropped
+			modified = true
+		}
+	}
+
+	return result, modified, nil
+}
+
+// cropToAspect crops img to the aspect ratio of width x height, keeping focus as close
+// to the center of the crop as the image bounds allow
+func cropToAspect(img image.Image, width, height int, focus image.Point) (image.Image, bool) {
+	if width <= 0 || height <= 0 {
+		return img, false
+	}
+
+	bounds := img.Bounds()
+	targetRatio := float64(width) / float64(height)
+	currentRatio := float64(bounds.Dx()) / float64(bounds.Dy())
+
+	cropWidth, cropHeight := bounds.Dx(), bounds.Dy()
+	if currentRatio > targetRatio {
+		cropWidth = int(math.Round(float64(bounds.Dy()) * targetRatio))
+	} else {
+		cropHeight = int(math.Round(float64(bounds.Dx()) / targetRatio))
+	}
+
+	if cropWidth == bounds.Dx() && cropHeight == bounds.Dy() {
+		return img, false
+	}
+
+	x0 := clamp(focus.X-cropWidth/2, bounds.Min.X, bounds.Max.X-cropWidth)
+	y0 := clamp(focus.Y-cropHeight/2, bounds.Min.Y, bounds.Max.Y-cropHeight)
+
+	return imaging.Crop(img, image.Rect(x0, y0, x0+cropWidth, y0+cropHeight)), true
+}
+
+// blurRegions returns a copy of img with every region strongly blurred
+func blurRegions(img image.Image, regions []image.Rectangle) image.Image {
+	bounds := img.Bounds()
+	dst := imaging.Clone(img)
+
+	for _, region := range regions {
+		region = region.Intersect(bounds).Sub(bounds.Min)
+		if region.Empty() {
+			continue
+		}
+
+		sigma := math.Max(float64(region.Dx()), float64(region.Dy())) / 6
+		blurred := imaging.Blur(imaging.Crop(dst, region), sigma)
+		dst = imaging.Paste(dst, blurred, region.Min)
+	}
+
+	return dst
+}
+
+// clamp limits value to the [low, high] range
+func clamp(value, low, high int) int {
+	if value < low {
+		return low
+	}
+	if value > high {
+		return high
+	}
+	return value
+}
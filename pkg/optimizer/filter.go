@@ -0,0 +1,60 @@
+package optimizer
+
+import (
+	"image"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// FilterLanczos is a high-quality filter suited to photographs; it is the default
+	FilterLanczos = "lanczos"
+	// FilterCatmullRom is a sharp cubic filter, slightly faster than Lanczos
+	FilterCatmullRom = "catmullrom"
+	// FilterBox averages source pixels; fast and suited to heavy downscaling
+	FilterBox = "box"
+	// FilterNearestNeighbor keeps hard edges; suited to pixel art
+	FilterNearestNeighbor = "nearest"
+)
+
+var resampleFilters = map[string]imaging.ResampleFilter{
+	FilterLanczos:         imaging.Lanczos,
+	FilterCatmullRom:      imaging.CatmullRom,
+	FilterBox:             imaging.Box,
+	FilterNearestNeighbor: imaging.NearestNeighbor,
+}
+
+// resampleFilter returns the filter for name, falling back to Lanczos for unknown names
+func resampleFilter(name string) imaging.ResampleFilter {
+	if filter, ok := resampleFilters[name]; ok {
+		return filter
+	}
+	return imaging.Lanczos
+}
+
+// resize scales img to width x height with the configured filter and sharpens the result
+// if requested. img is returned unchanged if it already has that size. The resized pixels
+// come from the pixel pool: the caller calls release once done with the image.
+func resize(img image.Image, width, height int, opts Options) (resized image.Image, release func()) {
+	bounds := img.Bounds()
+	if bounds.Dx() == width && bounds.Dy() == height {
+		return img, func() {}
+	}
+
+	filter := resampleFilter(opts.Filter)
+	if filter.Support <= 0 {
+		// Nearest neighbour picks pixels instead of weighting them
+		resized = imaging.Resize(img, width, height, filter)
+		release = func() {}
+	} else {
+		pixels := resample(img, width, height, filter)
+		resized, release = pixels.NRGBA, pixels.release
+	}
+
+	if opts.Sharpen > 0 {
+		sharpened := imaging.Sharpen(resized, opts.Sharpen)
+		release()
+		return sharpened, func() {}
+	}
+	return resized, release
+}
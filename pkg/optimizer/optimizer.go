@@ -0,0 +1,328 @@
+// Package optimizer resizes, crops and re-encodes images. It has no storage, queue or
+// database dependencies, so it can be embedded in any Go program:
+//
+//	result, output, err := optimizer.Optimize(ctx, file, optimizer.Options{MaxWidth: 1200, MaxHeight: 1200, Quality: 85})
+//
+// Log messages are written to the zerolog logger of the context, if any (see zerolog.Ctx).
+package optimizer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register the JPEG decoder
+	_ "image/png"  // register the PNG decoder
+	"io"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// Optimizer optimizes images. Its zero configuration, as returned by New without
+// options, is what the package-level functions use.
+type Optimizer struct {
+	faceDetector FaceDetector
+	concurrency  int
+	memoryShare  int
+	minScore     float64
+	qualityStep  int
+}
+
+// Option configures optional Optimizer behaviour
+type Option func(*Optimizer)
+
+// Options controls how a single image is optimized
+type Options struct {
+	MaxWidth  int
+	MaxHeight int
+	Quality   int
+	// Gravity crops the image to the MaxWidth x MaxHeight aspect ratio around
+	// the center or the detected faces; empty keeps the whole image
+	Gravity   string
+	BlurFaces bool
+	// Filter is the resampling filter used when resizing; empty selects Lanczos
+	Filter string
+	// Sharpen is the sigma of the unsharp mask applied after resizing; 0 disables it
+	Sharpen float64
+	// TargetSizeKB lowers the JPEG quality until the output fits in this many kilobytes,
+	// but not below MinQuality; 0 disables it
+	TargetSizeKB int
+	MinQuality   int
+	// Format encodes the image rendered by Render as "jpeg" or "png"; empty keeps the
+	// format of the input
+	Format string
+	// Renditions are additional sizes encoded in parallel from the same decoded image
+	Renditions []Rendition
+	// StoreRendition, if set, receives every encoded rendition instead of it being kept in
+	// RenditionResult.Data. It is called concurrently; an error is reported in the
+	// rendition's result.
+	StoreRendition func(ctx context.Context, rendition *RenditionResult, data io.Reader) error
+	// Check, if set, is called with the raw input and its format before it is decoded;
+	// an error aborts the optimization and is returned unchanged
+	Check func(ctx context.Context, data []byte, format string) error
+}
+
+// Result describes an optimized image
+type Result struct {
+	// Format is the image format of the output, "jpeg" or "png": the input's, unless
+	// Options.Format sets another for Render
+	Format      string
+	ContentType string
+	Width       int
+	Height      int
+	Size        int64
+
+	OriginalWidth  int
+	OriginalHeight int
+	OriginalSize   int64
+
+	// Improved reports whether the output is smaller than the input, or was resized or
+	// edited. Callers may keep the input instead of an output that is not improved.
+	Improved bool
+	// QualityScore is the SSIM of the output against the unencoded image, from 0 to 1
+	QualityScore float64
+	Placeholder  Placeholder
+	Renditions   []RenditionResult
+	// Timings break down where the time of the optimization went
+	Timings Timings
+}
+
+// Timings are the durations of the CPU-bound stages of an optimization. Encode includes
+// the quality check and the re-encodes it triggers; renditions are not included. Render
+// decodes while reading its input, so its Decode includes the read.
+type Timings struct {
+	Decode time.Duration
+	Resize time.Duration
+	Encode time.Duration
+}
+
+// defaultOptimizer serves the package-level functions
+var defaultOptimizer = New()
+
+// New creates an Optimizer
+func New(opts ...Option) *Optimizer {
+	o := &Optimizer{
+		concurrency: defaultRenditionConcurrency,
+		memoryShare: 1,
+		qualityStep: 5,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+// Optimize optimizes the image read from r with the default Optimizer
+func Optimize(ctx context.Context, r io.Reader, opts Options) (Result, io.Reader, error) {
+	return defaultOptimizer.Optimize(ctx, r, opts)
+}
+
+// Render resizes and re-encodes the image read from r with the default Optimizer
+func Render(ctx context.Context, r io.Reader, opts Options) (Result, io.Reader, error) {
+	return defaultOptimizer.Render(ctx, r, opts)
+}
+
+// Optimize decodes the image read from r, applies face operations, resizes it to fit
+// MaxWidth x MaxHeight and re-encodes it in its own format. It returns the result and a
+// reader over the optimized image.
+func (o *Optimizer) Optimize(ctx context.Context, r io.Reader, opts Options) (Result, io.Reader, error) {
+	log := zerolog.Ctx(ctx)
+
+	// Read the entire image into a pooled buffer; imgData is only valid until the function returns
+	srcBuf := getBuffer()
+	defer putBuffer(srcBuf)
+	if _, err := srcBuf.ReadFrom(r); err != nil {
+		return Result{}, nil, fmt.Errorf("error reading image data: %w", err)
+	}
+	imgData := srcBuf.Bytes()
+
+	// Refuse images whose decoded pixels would not fit in memory
+	format, err := o.checkMemoryBudget(imgData)
+	if err != nil {
+		return Result{}, nil, err
+	}
+
+	if opts.Check != nil {
+		if err := opts.Check(ctx, imgData, format); err != nil {
+			return Result{}, nil, err
+		}
+	}
+
+	// Decode the image
+	start := time.Now()
+	img, format, err := image.Decode(bytes.NewReader(imgData))
+	if err != nil {
+		return Result{}, nil, fmt.Errorf("%w: error decoding image: %w", ErrInvalidImage, err)
+	}
+
+	// Get original dimensions
+	bounds := img.Bounds()
+	result := Result{
+		Format:         format,
+		OriginalWidth:  bounds.Dx(),
+		OriginalHeight: bounds.Dy(),
+		OriginalSize:   int64(len(imgData)),
+		Timings:        Timings{Decode: time.Since(start)},
+		// Compute placeholders for frontends from the original image
+		Placeholder: computePlaceholder(img),
+	}
+
+	log.Debug().
+		Str("format", format).
+		Int("original_width", result.OriginalWidth).
+		Int("original_height", result.OriginalHeight).
+		Int("original_size", len(imgData)).
+		Msg("Image details")
+
+	// Apply face-aware cropping and blurring before resizing
+	editedImg, edited, err := o.applyFaceOperations(ctx, img, opts)
+	if err != nil {
+		return Result{}, nil, err
+	}
+	editedBounds := editedImg.Bounds()
+
+	// Calculate new dimensions while maintaining aspect ratio
+	result.Width, result.Height = fitDimensions(editedBounds.Dx(), editedBounds.Dy(), opts.MaxWidth, opts.MaxHeight)
+	resized := result.Width != result.OriginalWidth || result.Height != result.OriginalHeight
+
+	// Resize the image if needed
+	start = time.Now()
+	resizedImg, release := resize(editedImg, result.Width, result.Height, opts)
+	defer release()
+	result.Timings.Resize = time.Since(start)
+	if resized {
+		log.Debug().Int("new_width", result.Width).Int("new_height", result.Height).Msg("Image resized")
+	} else {
+		log.Debug().Msg("No resizing needed")
+	}
+
+	// Encode the image based on format; the buffer is handed to the caller, so it is not pooled
+	start = time.Now()
+	dstBuf := new(bytes.Buffer)
+	if opts.TargetSizeKB > 0 {
+		var quality int
+		var fits bool
+		result.ContentType, quality, fits, err = encodeToTarget(dstBuf, resizedImg, format, opts.Quality, opts.MinQuality, opts.TargetSizeKB*1024)
+		if err == nil {
+			if !fits {
+				log.Warn().
+					Int("target_size_kb", opts.TargetSizeKB).
+					Int("size", dstBuf.Len()).
+					Msg("Target size not reachable above minimum quality")
+			}
+			log.Debug().Int("quality", quality).Int("size", dstBuf.Len()).Msg("Quality selected for target size")
+		}
+	} else {
+		result.ContentType, err = encodeTo(dstBuf, resizedImg, format, opts.Quality)
+	}
+	if err != nil {
+		return Result{}, nil, err
+	}
+
+	// Score the encoded image and raise the quality if compression degraded it too much
+	result.QualityScore, err = o.ensureQuality(ctx, dstBuf, resizedImg, format, opts)
+	if err != nil {
+		return Result{}, nil, err
+	}
+	result.Timings.Encode = time.Since(start)
+	result.Size = int64(dstBuf.Len())
+	result.Improved = dstBuf.Len() < len(imgData) || edited || resized
+
+	// Encode the additional renditions from the edited image
+	if len(opts.Renditions) > 0 {
+		result.Renditions = o.encodeRenditions(ctx, editedImg, format, opts)
+	}
+
+	return result, dstBuf, nil
+}
+
+// Render decodes the image read from r, resizes it to fit MaxWidth x MaxHeight and
+// re-encodes it. Unlike Optimize it skips face operations, quality scoring, placeholders
+// and renditions, so it is cheap enough for on-the-fly derived images.
+func (o *Optimizer) Render(ctx context.Context, r io.Reader, opts Options) (Result, io.Reader, error) {
+	start := time.Now()
+	img, format, err := image.Decode(r)
+	if err != nil {
+		return Result{}, nil, fmt.Errorf("%w: error decoding image: %w", ErrInvalidImage, err)
+	}
+
+	bounds := img.Bounds()
+	result := Result{
+		Format:         format,
+		OriginalWidth:  bounds.Dx(),
+		OriginalHeight: bounds.Dy(),
+		Timings:        Timings{Decode: time.Since(start)},
+	}
+	result.Width, result.Height = fitDimensions(bounds.Dx(), bounds.Dy(), opts.MaxWidth, opts.MaxHeight)
+	result.Improved = result.Width != result.OriginalWidth || result.Height != result.OriginalHeight
+
+	start = time.Now()
+	resized, release := resize(img, result.Width, result.Height, opts)
+	defer release()
+	result.Timings.Resize = time.Since(start)
+
+	if opts.Format != "" {
+		result.Format = opts.Format
+	}
+	start = time.Now()
+	buf := new(bytes.Buffer)
+	result.ContentType, err = encodeTo(buf, resized, result.Format, opts.Quality)
+	if err != nil {
+		return Result{}, nil, err
+	}
+	result.Timings.Encode = time.Since(start)
+	result.Size = int64(buf.Len())
+
+	zerolog.Ctx(ctx).Debug().
+		Int("width", result.Width).
+		Int("height", result.Height).
+		Int64("size", result.Size).
+		Msg("Image rendered")
+
+	return result, buf, nil
+}
+
+// Probe reads the dimensions and format of the image read from r from its header, without
+// decoding it. Only JPEG and PNG images are accepted.
+func Probe(r io.Reader) (width, height int, format string, err error) {
+	cfg, format, err := image.DecodeConfig(r)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("%w: error decoding image header: %w", ErrInvalidImage, err)
+	}
+
+	// Check if format is supported
+	if format != "jpeg" && format != "png" {
+		return 0, 0, "", fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)
+	}
+
+	return cfg.Width, cfg.Height, format, nil
+}
+
+// Validate checks the image read from r and returns its dimensions, size and format. Only
+// the header is decoded, and the end of the image is checked for the end marker of its
+// format to catch truncated files; the pixel data is decoded by Optimize. Only JPEG and PNG
+// images are accepted.
+func Validate(r io.Reader) (width, height int, size int64, format string, err error) {
+	// Keep the end of everything read, header included, for the trailer check
+	tail := &tailWriter{keep: trailerWindow}
+	tee := io.TeeReader(r, tail)
+
+	width, height, format, err = Probe(bufio.NewReader(tee))
+	if err != nil {
+		return 0, 0, 0, "", err
+	}
+
+	// Read the rest of the image, past what the header decoder buffered
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return 0, 0, 0, "", fmt.Errorf("error reading image data: %w", err)
+	}
+	if !hasTrailer(format, tail.buf) {
+		return 0, 0, 0, "", fmt.Errorf("%w: %s image is truncated", ErrInvalidImage, format)
+	}
+
+	return width, height, tail.total, format, nil
+}
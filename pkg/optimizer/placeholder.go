@@ -0,0 +1,189 @@
+package optimizer
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	// placeholderSampleSize is the size images are reduced to before computing placeholders
+	placeholderSampleSize = 64
+	// blurHashComponentsX and blurHashComponentsY set the level of detail of the BlurHash
+	blurHashComponentsX = 4
+	blurHashComponentsY = 3
+	// dominantColorCount is the maximum number of dominant colors returned
+	dominantColorCount = 5
+)
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// Placeholder holds lightweight representations of an image that frontends can render
+// while the real image loads
+type Placeholder struct {
+	BlurHash       string
+	DominantColors []string
+}
+
+// computePlaceholder computes the BlurHash and dominant colors of img
+func computePlaceholder(img image.Image) Placeholder {
+	sample := imaging.Fit(img, placeholderSampleSize, placeholderSampleSize, imaging.Box)
+
+	return Placeholder{
+		BlurHash:       blurHash(sample, blurHashComponentsX, blurHashComponentsY),
+		DominantColors: dominantColors(sample, dominantColorCount),
+	}
+}
+
+// dominantColors returns up to n hex colors that cover the largest areas of img,
+// ordered from most to least frequent. Colors are grouped into buckets of 16 levels
+// per channel and each bucket is represented by its average color.
+func dominantColors(img *image.NRGBA, n int) []string {
+	type bucket struct {
+		r, g, b, count int
+	}
+
+	buckets := make(map[int]*bucket)
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.NRGBAAt(x, y)
+			if c.A < 128 {
+				continue // ignore mostly transparent pixels
+			}
+
+			key := int(c.R>>4)<<8 | int(c.G>>4)<<4 | int(c.B>>4)
+			bk, ok := buckets[key]
+			if !ok {
+				bk = &bucket{}
+				buckets[key] = bk
+			}
+			bk.r += int(c.R)
+			bk.g += int(c.G)
+			bk.b += int(c.B)
+			bk.count++
+		}
+	}
+
+	sorted := make([]*bucket, 0, len(buckets))
+	for _, bk := range buckets {
+		sorted = append(sorted, bk)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].count > sorted[j].count
+	})
+
+	colors := make([]string, 0, n)
+	for _, bk := range sorted {
+		if len(colors) == n {
+			break
+		}
+		colors = append(colors, fmt.Sprintf("#%02x%02x%02x", bk.r/bk.count, bk.g/bk.count, bk.b/bk.count))
+	}
+
+	return colors
+}
+
+// blurHash encodes img as a BlurHash string (https://blurha.sh) with the given number of components
+func blurHash(img *image.NRGBA, componentsX, componentsY int) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return ""
+	}
+
+	factors := make([][3]float64, 0, componentsX*componentsY)
+	for j := 0; j < componentsY; j++ {
+		for i := 0; i < componentsX; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1.0
+			}
+
+			var r, g, b float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := normalisation *
+						math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					c := img.NRGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+					r += basis * srgbToLinear(c.R)
+					g += basis * srgbToLinear(c.G)
+					b += basis * srgbToLinear(c.B)
+				}
+			}
+
+			scale := 1.0 / float64(width*height)
+			factors = append(factors, [3]float64{r * scale, g * scale, b * scale})
+		}
+	}
+
+	var sb strings.Builder
+
+	sizeFlag := (componentsX - 1) + (componentsY-1)*9
+	sb.WriteString(encodeBase83(sizeFlag, 1))
+
+	dc, ac := factors[0], factors[1:]
+
+	maximumValue := 1.0
+	if len(ac) > 0 {
+		actualMaximum := 0.0
+		for _, f := range ac {
+			actualMaximum = math.Max(actualMaximum, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+		maximumValue = float64(quantisedMaximum+1) / 166
+		sb.WriteString(encodeBase83(quantisedMaximum, 1))
+	} else {
+		sb.WriteString(encodeBase83(0, 1))
+	}
+
+	sb.WriteString(encodeBase83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+
+	for _, f := range ac {
+		quantR := quantiseAC(f[0] / maximumValue)
+		quantG := quantiseAC(f[1] / maximumValue)
+		quantB := quantiseAC(f[2] / maximumValue)
+		sb.WriteString(encodeBase83(quantR*19*19+quantG*19+quantB, 2))
+	}
+
+	return sb.String()
+}
+
+// quantiseAC maps a normalised AC component to the 0-18 range used by BlurHash
+func quantiseAC(value float64) int {
+	signPow := math.Copysign(math.Pow(math.Abs(value), 0.5), value)
+	return int(math.Max(0, math.Min(18, math.Floor(signPow*9+9.5))))
+}
+
+// srgbToLinear converts an 8-bit sRGB channel value to linear light
+func srgbToLinear(value uint8) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// linearToSRGB converts a linear light value to an 8-bit sRGB channel value
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+// encodeBase83 encodes value using the BlurHash base83 alphabet with a fixed length
+func encodeBase83(value, length int) string {
+	result := make([]byte, length)
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		result[i-1] = base83Chars[digit]
+	}
+	return string(result)
+}
@@ -0,0 +1,84 @@
+package optimizer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"runtime/debug"
+	"sync"
+)
+
+const (
+	// maxPooledBufferSize keeps unusually large buffers out of the pool so one huge
+	// image does not pin its memory for the lifetime of the worker
+	maxPooledBufferSize = 32 << 20
+	// decodedBytesPerPixel estimates the memory of a decoded image plus one resized copy
+	decodedBytesPerPixel = 8
+)
+
+// ErrImageTooLarge is returned when decoding an image would exceed the memory budget
+var ErrImageTooLarge = errors.New("image too large to process within memory limit")
+
+// ErrInvalidImage is returned when an image is corrupt or truncated, so processing it
+// again cannot succeed
+var ErrInvalidImage = errors.New("invalid image")
+
+// ErrUnsupportedFormat is returned for images in, or outputs requested in, a format other
+// than JPEG or PNG
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+// getBuffer returns an empty buffer from the pool
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putBuffer returns buf to the pool. The caller must not use buf or any slice of it afterwards.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() > maxPooledBufferSize {
+		return
+	}
+	bufferPool.Put(buf)
+}
+
+// WithMemoryLimitShare divides the GOMEMLIMIT budget between n images processed concurrently,
+// so each decode is only allowed its share of the limit
+func WithMemoryLimitShare(n int) Option {
+	return func(o *Optimizer) {
+		if n > 0 {
+			o.memoryShare = n
+		}
+	}
+}
+
+// checkMemoryBudget rejects images whose decoded size would not fit in this optimizer's
+// share of GOMEMLIMIT and returns the image format. It only reads the image header. No
+// limit applies if GOMEMLIMIT is unset.
+func (o *Optimizer) checkMemoryBudget(data []byte) (string, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("%w: error reading image header: %w", ErrInvalidImage, err)
+	}
+
+	limit := debug.SetMemoryLimit(-1)
+	if limit == math.MaxInt64 {
+		return format, nil
+	}
+
+	budget := limit / int64(o.memoryShare)
+	required := int64(cfg.Width) * int64(cfg.Height) * decodedBytesPerPixel
+	if required > budget {
+		return "", fmt.Errorf("%w: %dx%d needs ~%d bytes, budget is %d bytes", ErrImageTooLarge, cfg.Width, cfg.Height, required, budget)
+	}
+
+	return format, nil
+}
@@ -0,0 +1,127 @@
+package optimizer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+
+	"github.com/disintegration/imaging"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// qualitySampleSize bounds the size images are reduced to before scoring
+	qualitySampleSize = 512
+	// ssimWindow is the side of the square windows SSIM is averaged over
+	ssimWindow = 8
+	ssimC1     = (0.01 * 255) * (0.01 * 255)
+	ssimC2     = (0.03 * 255) * (0.03 * 255)
+)
+
+// WithQualityThreshold re-encodes images at higher quality, in steps of step, while their
+// SSIM score against the unencoded image is below minScore. A minScore of 0 disables it.
+func WithQualityThreshold(minScore float64, step int) Option {
+	return func(o *Optimizer) {
+		o.minScore = minScore
+		if step > 0 {
+			o.qualityStep = step
+		}
+	}
+}
+
+// qualityScore decodes the encoded output and returns its SSIM score against reference,
+// from 0 (unrelated) to 1 (identical)
+func qualityScore(reference image.Image, encoded []byte) (float64, error) {
+	decoded, _, err := image.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		return 0, err
+	}
+	return ssim(reference, decoded), nil
+}
+
+// ssim computes the mean structural similarity of the luma of a and b over non-overlapping
+// windows. Both images are reduced to the same sample size first.
+func ssim(a, b image.Image) float64 {
+	sampleA := imaging.Grayscale(imaging.Fit(a, qualitySampleSize, qualitySampleSize, imaging.Box))
+	bounds := sampleA.Bounds()
+	sampleB := imaging.Grayscale(imaging.Resize(b, bounds.Dx(), bounds.Dy(), imaging.Box))
+
+	var total float64
+	var windows int
+	for y := 0; y+ssimWindow <= bounds.Dy(); y += ssimWindow {
+		for x := 0; x+ssimWindow <= bounds.Dx(); x += ssimWindow {
+			total += ssimWindowScore(sampleA, sampleB, x, y)
+			windows++
+		}
+	}
+
+	if windows == 0 {
+		return 1
+	}
+	return total / float64(windows)
+}
+
+// ssimWindowScore computes SSIM for the window with top-left corner x, y
+func ssimWindowScore(a, b *image.NRGBA, x, y int) float64 {
+	const n = ssimWindow * ssimWindow
+
+	var sumA, sumB, sumAA, sumBB, sumAB float64
+	for j := y; j < y+ssimWindow; j++ {
+		for i := x; i < x+ssimWindow; i++ {
+			// Grayscale images have equal channels, so red is the luma
+			va := float64(a.Pix[a.PixOffset(i, j)])
+			vb := float64(b.Pix[b.PixOffset(i, j)])
+			sumA += va
+			sumB += vb
+			sumAA += va * va
+			sumBB += vb * vb
+			sumAB += va * vb
+		}
+	}
+
+	meanA, meanB := sumA/n, sumB/n
+	varA := sumAA/n - meanA*meanA
+	varB := sumBB/n - meanB*meanB
+	covAB := sumAB/n - meanA*meanB
+
+	return ((2*meanA*meanB + ssimC1) * (2*covAB + ssimC2)) /
+		((meanA*meanA + meanB*meanB + ssimC1) * (varA + varB + ssimC2))
+}
+
+// ensureQuality scores the image encoded in buf against reference. If the score is below the
+// optimizer threshold, the image is re-encoded at increasing quality until it passes or the
+// quality reaches 100. Target-size encodes are scored but never raised, as that would break
+// the size budget. It returns the final score.
+func (o *Optimizer) ensureQuality(ctx context.Context, buf *bytes.Buffer, reference image.Image, format string, opts Options) (float64, error) {
+	log := zerolog.Ctx(ctx)
+
+	score, err := qualityScore(reference, buf.Bytes())
+	if err != nil {
+		return 0, fmt.Errorf("error scoring encoded image: %w", err)
+	}
+
+	if o.minScore <= 0 || opts.TargetSizeKB > 0 || format != "jpeg" {
+		return score, nil
+	}
+
+	quality := opts.Quality
+	for score < o.minScore && quality < 100 {
+		quality = min(quality+o.qualityStep, 100)
+
+		buf.Reset()
+		if _, err := encodeTo(buf, reference, format, quality); err != nil {
+			return 0, err
+		}
+		if score, err = qualityScore(reference, buf.Bytes()); err != nil {
+			return 0, fmt.Errorf("error scoring encoded image: %w", err)
+		}
+
+		log.Debug().
+			Int("quality", quality).
+			Float64("score", score).
+			Msg("Re-encoded image at higher quality to meet quality threshold")
+	}
+
+	return score, nil
+}
@@ -0,0 +1,117 @@
+package optimizer
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// defaultRenditionConcurrency bounds parallel rendition encoding when WithConcurrency is not used
+const defaultRenditionConcurrency = 4
+
+// Rendition describes an additional size encoded alongside the optimized image
+type Rendition struct {
+	Name      string
+	MaxWidth  int
+	MaxHeight int
+	Quality   int
+	Filter    string
+	Sharpen   float64
+	// Format encodes the rendition as "jpeg" or "png"; empty keeps the format of the image
+	Format string
+}
+
+// RenditionResult is the outcome of encoding a single rendition. Err is set if the
+// rendition failed; other renditions are unaffected.
+type RenditionResult struct {
+	Name        string
+	ContentType string
+	Size        int64
+	Width       int
+	Height      int
+	// Data holds the encoded rendition unless Options.StoreRendition is set
+	Data []byte
+	// Duration is the time spent encoding and storing the rendition
+	Duration time.Duration
+	Err      error
+}
+
+// WithConcurrency sets how many renditions of one image are encoded in parallel
+func WithConcurrency(n int) Option {
+	return func(o *Optimizer) {
+		if n > 0 {
+			o.concurrency = n
+		}
+	}
+}
+
+// encodeRenditions resizes and encodes every rendition of img concurrently, bounded by the
+// optimizer concurrency. The decoded image is shared read-only between goroutines.
+func (o *Optimizer) encodeRenditions(ctx context.Context, img image.Image, format string, opts Options) []RenditionResult {
+	results := make([]RenditionResult, len(opts.Renditions))
+	sem := make(chan struct{}, o.concurrency)
+	var wg sync.WaitGroup
+
+	for i, rendition := range opts.Renditions {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, rendition Rendition) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = encodeRendition(ctx, img, format, rendition, opts.StoreRendition)
+		}(i, rendition)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// encodeRendition produces a single rendition and hands it to store, if set
+func encodeRendition(ctx context.Context, img image.Image, format string, rendition Rendition, store func(context.Context, *RenditionResult, io.Reader) error) RenditionResult {
+	startTime := time.Now()
+	log := zerolog.Ctx(ctx).With().Str("rendition", rendition.Name).Logger()
+
+	result := RenditionResult{Name: rendition.Name}
+	defer func() { result.Duration = time.Since(startTime) }()
+
+	bounds := img.Bounds()
+	result.Width, result.Height = fitDimensions(bounds.Dx(), bounds.Dy(), rendition.MaxWidth, rendition.MaxHeight)
+
+	opts := Options{Filter: rendition.Filter, Sharpen: rendition.Sharpen}
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if rendition.Format != "" {
+		format = rendition.Format
+	}
+	resized, release := resize(img, result.Width, result.Height, opts)
+	contentType, err := encodeTo(buf, resized, format, rendition.Quality)
+	release()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to encode rendition")
+		result.Err = err
+		return result
+	}
+	result.ContentType = contentType
+	result.Size = int64(buf.Len())
+
+	if store == nil {
+		result.Data = bytes.Clone(buf.Bytes())
+	} else if err := store(ctx, &result, bytes.NewReader(buf.Bytes())); err != nil {
+		log.Error().Err(err).Msg("Failed to store rendition")
+		result.Err = err
+		return result
+	}
+
+	log.Debug().
+		Int("width", result.Width).
+		Int("height", result.Height).
+		Int64("size", result.Size).
+		Msg("Rendition encoded")
+
+	return result
+}
@@ -0,0 +1,245 @@
+package optimizer
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"runtime"
+	"sync"
+
+	"github.com/disintegration/imaging"
+)
+
+// maxPooledPixelsSize keeps the pixels of unusually large images out of the pool, like
+// maxPooledBufferSize for encoded data
+const maxPooledPixelsSize = 64 << 20
+
+// pixelPool holds the pixel buffers of resized images and of the intermediate images of
+// resizing, so each resize doesn't allocate them again
+var pixelPool = sync.Pool{
+	New: func() any {
+		return new([]uint8)
+	},
+}
+
+// pixelBuffer is an NRGBA image whose pixels come from pixelPool
+type pixelBuffer struct {
+	*image.NRGBA
+	pix *[]uint8
+}
+
+// getPixels returns a width x height NRGBA image from the pool. Its pixels are not cleared.
+func getPixels(width, height int) pixelBuffer {
+	pix := pixelPool.Get().(*[]uint8)
+	n := width * height * 4
+	if cap(*pix) < n {
+		*pix = make([]uint8, n)
+	}
+	*pix = (*pix)[:n]
+	return pixelBuffer{
+		NRGBA: &image.NRGBA{Pix: *pix, Stride: width * 4, Rect: image.Rect(0, 0, width, height)},
+		pix:   pix,
+	}
+}
+
+// release returns the pixels to the pool. The image must not be used afterwards.
+func (b pixelBuffer) release() {
+	if b.pix == nil || cap(*b.pix) > maxPooledPixelsSize {
+		return
+	}
+	pixelPool.Put(b.pix)
+}
+
+// indexWeight is the weight of a source pixel in a destination pixel
+type indexWeight struct {
+	index  int
+	weight float64
+}
+
+// resample scales img to width x height with filter into pooled pixels, the same way
+// imaging.Resize does: horizontally into an intermediate image, then vertically. Both
+// images come from the pool; the intermediate one is released before returning.
+func resample(img image.Image, width, height int, filter imaging.ResampleFilter) pixelBuffer {
+	srcBounds := img.Bounds()
+	tmp := getPixels(width, srcBounds.Dy())
+	defer tmp.release()
+	resampleRows(tmp.NRGBA, img, weights(width, srcBounds.Dx(), filter))
+
+	dst := getPixels(width, height)
+	resampleColumns(dst.NRGBA, tmp.NRGBA, weights(height, srcBounds.Dy(), filter))
+	return dst
+}
+
+// rowScanner reads the rows of an image as NRGBA pixels
+type rowScanner struct {
+	img    image.Image
+	bounds image.Rectangle
+	// direct is set for images whose pixels are laid out as NRGBA already
+	direct *image.NRGBA
+}
+
+func newRowScanner(img image.Image) rowScanner {
+	scanner := rowScanner{img: img, bounds: img.Bounds()}
+	switch src := img.(type) {
+	case *image.NRGBA:
+		scanner.direct = src
+	case *image.RGBA:
+		// Opaque premultiplied pixels are the same as non-premultiplied ones
+		if src.Opaque() {
+			scanner.direct = &image.NRGBA{Pix: src.Pix, Stride: src.Stride, Rect: src.Rect}
+		}
+	}
+	return scanner
+}
+
+// scan returns row y, counted from the top of the image, converting it into line if the
+// pixels are not NRGBA already. line holds a row.
+func (s rowScanner) scan(y int, line []uint8) []uint8 {
+	if s.direct != nil {
+		offset := s.direct.PixOffset(s.bounds.Min.X, s.bounds.Min.Y+y)
+		return s.direct.Pix[offset : offset+s.bounds.Dx()*4]
+	}
+
+	rect := image.Rect(0, 0, s.bounds.Dx(), 1)
+	at := image.Pt(s.bounds.Min.X, s.bounds.Min.Y+y)
+	if _, ok := s.img.(*image.YCbCr); ok {
+		// Decoded JPEGs are opaque, and drawing them into RGBA has a fast path
+		draw.Draw(&image.RGBA{Pix: line, Stride: len(line), Rect: rect}, rect, s.img, at, draw.Src)
+	} else {
+		draw.Draw(&image.NRGBA{Pix: line, Stride: len(line), Rect: rect}, rect, s.img, at, draw.Src)
+	}
+	return line
+}
+
+// weights computes for every destination pixel the weights of the source pixels it is
+// made of, scaling the filter support when downsizing
+func weights(dstSize, srcSize int, filter imaging.ResampleFilter) [][]indexWeight {
+	du := float64(srcSize) / float64(dstSize)
+	scale := max(du, 1)
+	radius := math.Ceil(scale * filter.Support)
+
+	out := make([][]indexWeight, dstSize)
+	all := make([]indexWeight, 0, dstSize*int(radius+2)*2)
+	for v := range dstSize {
+		center := (float64(v)+0.5)*du - 0.5
+		begin := max(int(math.Ceil(center-radius)), 0)
+		end := min(int(math.Floor(center+radius)), srcSize-1)
+
+		var sum float64
+		start := len(all)
+		for u := begin; u <= end; u++ {
+			if w := filter.Kernel((float64(u) - center) / scale); w != 0 {
+				sum += w
+				all = append(all, indexWeight{index: u, weight: w})
+			}
+		}
+		if sum != 0 {
+			for i := start; i < len(all); i++ {
+				all[i].weight /= sum
+			}
+		}
+		out[v] = all[start:len(all):len(all)]
+	}
+	return out
+}
+
+// resampleRows fills every row of dst from the same row of img with the weights of its columns
+func resampleRows(dst *image.NRGBA, img image.Image, weights [][]indexWeight) {
+	scanner := newRowScanner(img)
+	parallelRows(dst.Rect.Dy(), func() func(y int) {
+		line := make([]uint8, scanner.bounds.Dx()*4)
+		return func(y int) {
+			srcRow := scanner.scan(y, line)
+			for x, pixelWeights := range weights {
+				var r, g, b, a float64
+				for _, w := range pixelWeights {
+					i := w.index * 4
+					s := srcRow[i : i+4 : i+4]
+					aw := float64(s[3]) * w.weight
+					r += float64(s[0]) * aw
+					g += float64(s[1]) * aw
+					b += float64(s[2]) * aw
+					a += aw
+				}
+				j := y*dst.Stride + x*4
+				setPixel(dst.Pix[j:j+4:j+4], r, g, b, a)
+			}
+		}
+	})
+}
+
+// resampleColumns fills every column of dst from the same column of src with the weights of its rows
+func resampleColumns(dst, src *image.NRGBA, weights [][]indexWeight) {
+	parallelRows(dst.Rect.Dy(), func() func(y int) {
+		return func(y int) {
+			for x := range dst.Rect.Dx() {
+				var r, g, b, a float64
+				for _, w := range weights[y] {
+					i := w.index*src.Stride + x*4
+					s := src.Pix[i : i+4 : i+4]
+					aw := float64(s[3]) * w.weight
+					r += float64(s[0]) * aw
+					g += float64(s[1]) * aw
+					b += float64(s[2]) * aw
+					a += aw
+				}
+				j := y*dst.Stride + x*4
+				setPixel(dst.Pix[j:j+4:j+4], r, g, b, a)
+			}
+		}
+	})
+}
+
+// setPixel writes the alpha-weighted sums r, g, b and a into d. Pooled pixels are not
+// cleared, so fully transparent pixels are written too.
+func setPixel(d []uint8, r, g, b, a float64) {
+	if a == 0 {
+		d[0], d[1], d[2], d[3] = 0, 0, 0, 0
+		return
+	}
+	aInv := 1 / a
+	d[0] = clampUint8(r * aInv)
+	d[1] = clampUint8(g * aInv)
+	d[2] = clampUint8(b * aInv)
+	d[3] = clampUint8(a)
+}
+
+// clampUint8 rounds v to the nearest value a channel can hold
+func clampUint8(v float64) uint8 {
+	switch rounded := int64(v + 0.5); {
+	case rounded > 255:
+		return 255
+	case rounded > 0:
+		return uint8(rounded)
+	default:
+		return 0
+	}
+}
+
+// parallelRows calls the function made by newFn for every row of n, spread over GOMAXPROCS
+// goroutines which each make their own with newFn
+func parallelRows(n int, newFn func() func(y int)) {
+	workers := min(runtime.GOMAXPROCS(0), n)
+	if workers <= 1 {
+		fn := newFn()
+		for y := range n {
+			fn(y)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	chunk := (n + workers - 1) / workers
+	for start := 0; start < n; start += chunk {
+		end := min(start+chunk, n)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn := newFn()
+			for y := start; y < end; y++ {
+				fn(y)
+			}
+		}()
+	}
+	wg.Wait()
+}
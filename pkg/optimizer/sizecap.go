@@ -0,0 +1,60 @@
+package optimizer
+
+import (
+	"bytes"
+	"image"
+)
+
+// defaultMinQuality is the lowest quality tried when fitting a target size
+const defaultMinQuality = 30
+
+// encodeToTarget encodes img into buf with the highest quality between minQuality and
+// maxQuality whose output fits in targetBytes, using a binary search. If even minQuality
+// does not fit, the minQuality encoding is kept and fits is false. Formats without a
+// quality setting are encoded once.
+func encodeToTarget(buf *bytes.Buffer, img image.Image, format string, maxQuality, minQuality, targetBytes int) (contentType string, quality int, fits bool, err error) {
+	contentType, err = encodeTo(buf, img, format, maxQuality)
+	if err != nil || buf.Len() <= targetBytes || format != "jpeg" {
+		return contentType, maxQuality, err == nil && buf.Len() <= targetBytes, err
+	}
+
+	if minQuality <= 0 {
+		minQuality = defaultMinQuality
+	}
+	if minQuality > maxQuality {
+		minQuality = maxQuality
+	}
+
+	scratch := getBuffer()
+	defer putBuffer(scratch)
+
+	// Search for the highest quality in [lo, hi] that fits; buf always holds the best candidate
+	lo, hi := minQuality, maxQuality-1
+	quality = -1
+	for lo <= hi {
+		mid := (lo + hi) / 2
+		scratch.Reset()
+		if _, err = encodeTo(scratch, img, format, mid); err != nil {
+			return "", 0, false, err
+		}
+		if scratch.Len() <= targetBytes {
+			quality = mid
+			buf.Reset()
+			buf.Write(scratch.Bytes())
+			lo = mid + 1
+		} else {
+			hi = mid - 1
+		}
+	}
+
+	if quality >= 0 {
+		return contentType, quality, true, nil
+	}
+
+	// Nothing fits: keep the floor quality rather than degrading further
+	buf.Reset()
+	if _, err = encodeTo(buf, img, format, minQuality); err != nil {
+		return "", 0, false, err
+	}
+	return contentType, minQuality, false, nil
+}
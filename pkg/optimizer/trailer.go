@@ -0,0 +1,42 @@
+package optimizer
+
+import "bytes"
+
+// trailerWindow is how many bytes at the end of an image are searched for its end marker.
+// Encoders may pad JPEG files after the marker.
+const trailerWindow = 64
+
+var (
+	// jpegEOI is the JPEG End Of Image marker
+	jpegEOI = []byte{0xFF, 0xD9}
+	// pngIEND is the IEND chunk closing every PNG file: its empty length, type and CRC
+	pngIEND = []byte{0x00, 0x00, 0x00, 0x00, 'I', 'E', 'N', 'D', 0xAE, 0x42, 0x60, 0x82}
+)
+
+// hasTrailer reports whether tail, the end of an image, holds the end marker of format
+func hasTrailer(format string, tail []byte) bool {
+	switch format {
+	case "jpeg":
+		return bytes.Contains(tail, jpegEOI)
+	case "png":
+		return bytes.Contains(tail, pngIEND)
+	default:
+		return true
+	}
+}
+
+// tailWriter keeps the last bytes written to it and counts them all
+type tailWriter struct {
+	keep  int
+	buf   []byte
+	total int64
+}
+
+func (t *tailWriter) Write(p []byte) (int, error) {
+	t.total += int64(len(p))
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > t.keep {
+		t.buf = append(t.buf[:0], t.buf[len(t.buf)-t.keep:]...)
+	}
+	return len(p), nil
+}